@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
 	"github.com/hootrhino/rhilex/component/orderedmap"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
@@ -34,19 +35,39 @@ import (
 var __DefaultAlarmCenter *AlarmCenter
 
 type QueueData struct {
+	AlarmId   string
+	Action    string // RAISE | CLEAR | ACK
 	RuleId    string
 	Source    string
 	Expr      string
 	EventType string
+	Severity  string
 	HandleId  string
 	Data      string
 	In        map[string]any
 }
+
+// 推送给 HandleId 的告警事件
+type AlarmEvent struct {
+	AlarmId   string `json:"alarmId"`
+	Action    string `json:"action"`
+	RuleId    string `json:"ruleId"`
+	Source    string `json:"source"`
+	EventType string `json:"eventType"`
+	Severity  string `json:"severity"`
+	Ts        uint64 `json:"ts"`
+	Data      string `json:"data"`
+}
+
 type AlarmCenter struct {
 	e         typex.Rhilex
 	registry  *orderedmap.OrderedMap[string, *AlarmRule]
 	caches    []MAlarmLog
 	QueueData chan QueueData
+	locker    sync.Mutex // 保护 states
+	states    map[string]*alarmState
+	// 状态变化的数据库写入和事件发送按顺序执行, 不占用 locker
+	commitLocker sync.Mutex
 }
 
 func InitAlarmCenter(e typex.Rhilex) {
@@ -55,8 +76,11 @@ func InitAlarmCenter(e typex.Rhilex) {
 		registry:  orderedmap.NewOrderedMap[string, *AlarmRule](),
 		caches:    []MAlarmLog{},
 		QueueData: make(chan QueueData, 1024),
+		states:    map[string]*alarmState{},
 	}
 	InitAlarmDb(e)
+	// 恢复重启之前未恢复的告警
+	__DefaultAlarmCenter.restoreActiveAlarms()
 	go func() {
 		for {
 			select {
@@ -81,20 +105,40 @@ func InitAlarmCenter(e typex.Rhilex) {
 			case <-context.Background().Done():
 				return
 			case qData := <-__DefaultAlarmCenter.QueueData:
+				Ts := uint64(time.Now().UnixMilli())
 				__DefaultAlarmCenter.caches = append(__DefaultAlarmCenter.caches, MAlarmLog{
 					UUID:      utils.AlarmLogUuid(),
-					Ts:        uint64(time.Now().UnixMilli()),
+					Ts:        Ts,
 					RuleId:    qData.RuleId,
 					Source:    qData.Source,
 					EventType: qData.EventType,
-					Summary:   fmt.Sprintf("EventType:[ %s ] | Source:[ %s ]", qData.EventType, qData.Source),
-					Info:      qData.Data,
+					Summary: fmt.Sprintf("Action:[ %s ] | Severity:[ %s ] | EventType:[ %s ] | Source:[ %s ]",
+						qData.Action, qData.Severity, qData.EventType, qData.Source),
+					Info: qData.Data,
 				})
+				// 只有触发和恢复才通知事件处理器
+				if qData.Action != ALARM_ACTION_RAISE && qData.Action != ALARM_ACTION_CLEAR {
+					continue
+				}
+				if qData.HandleId == "" {
+					continue
+				}
 				Target := __DefaultAlarmCenter.e.GetOutEnd(qData.HandleId)
 				if Target != nil {
 					if Target.Target != nil {
-						// 直接把这个EventType输出到对面
-						Target.Target.To(qData.EventType)
+						bytes, _ := json.Marshal(AlarmEvent{
+							AlarmId:   qData.AlarmId,
+							Action:    qData.Action,
+							RuleId:    qData.RuleId,
+							Source:    qData.Source,
+							EventType: qData.EventType,
+							Severity:  qData.Severity,
+							Ts:        Ts,
+							Data:      qData.Data,
+						})
+						if _, err := Target.Target.To(string(bytes)); err != nil {
+							glogger.GLogger.Error("Alarm event output failed:", err)
+						}
 					}
 				}
 			}
@@ -119,10 +163,25 @@ func LoadAlarmRule(uuid string, alarmRule AlarmRule) error {
 		if err != nil {
 			return err
 		}
+		var clearProgram *vm.Program
+		if exprDefine.ClearExpr != "" {
+			clearProgram, err = expr.Compile(exprDefine.ClearExpr, expr.AsBool())
+			if err != nil {
+				return err
+			}
+		}
+		Severity, ok := NormalizeSeverity(exprDefine.Severity)
+		if !ok {
+			return fmt.Errorf("invalid severity: %s", exprDefine.Severity)
+		}
 		ExprDefines = append(ExprDefines, ExprDefine{
-			Expr:      exprDefine.Expr,
-			EventType: exprDefine.EventType,
-			program:   program,
+			Expr:         exprDefine.Expr,
+			EventType:    exprDefine.EventType,
+			Severity:     Severity,
+			ClearExpr:    exprDefine.ClearExpr,
+			ClearDelay:   exprDefine.ClearDelay,
+			program:      program,
+			clearProgram: clearProgram,
 		})
 	}
 	NewAlarm := NewAlarmRule(alarmRule.Threshold, alarmRule.Interval, alarmRule.HandleId, ExprDefines)
@@ -145,26 +204,8 @@ func RunExpr(ruleId, Source string, in map[string]any) (bool, error) {
 	for _, exprDefine := range AlarmRule.ExprDefines {
 		go func(exprDefine ExprDefine) {
 			defer wg.Done()
-			output, err := expr.Run(exprDefine.program, in)
-			if err != nil {
+			if err := __DefaultAlarmCenter.evaluate(ruleId, Source, AlarmRule, exprDefine, in); err != nil {
 				errCh <- err
-				return
-			}
-			switch T := output.(type) {
-			case bool:
-				if T {
-					if AlarmRule.AddLog() {
-						__DefaultAlarmCenter.QueueData <- QueueData{
-							In:        in,
-							RuleId:    ruleId,
-							Source:    Source,
-							Expr:      exprDefine.Expr,
-							EventType: exprDefine.EventType,
-							HandleId:  AlarmRule.HandleId,
-							Data:      BeautifulMapPrint(in),
-						}
-					}
-				}
 			}
 		}(exprDefine)
 	}
@@ -182,13 +223,20 @@ func RunExpr(ruleId, Source string, in map[string]any) (bool, error) {
 	return true, nil
 }
 func ReLoadAlarmRule(uuid string, alarmRule AlarmRule) error {
-	RemoveExpr(uuid)
+	__DefaultAlarmCenter.registry.Delete(uuid)
+	// 保留仍然存在的事件的告警状态, 其余的恢复掉
+	keep := map[string]bool{}
+	for _, exprDefine := range alarmRule.ExprDefines {
+		keep[exprDefine.EventType] = true
+	}
+	__DefaultAlarmCenter.dropRuleStates(uuid, keep)
 	return LoadAlarmRule(uuid, alarmRule)
 }
 
 // Remove Expr
 func RemoveExpr(uuid string) {
 	__DefaultAlarmCenter.registry.Delete(uuid)
+	__DefaultAlarmCenter.dropRuleStates(uuid, nil)
 }
 
 // 输入数据检查规则
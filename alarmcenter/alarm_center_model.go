@@ -64,8 +64,70 @@ func (m *MAlarmRule) SetExprDefine(outputs []ExprDefine) {
 }
 
 // 输出规则
+// Expr 为真时触发告警; 如果配置了 ClearExpr, 则 ClearExpr 为真时恢复,
+// 否则 Expr 持续为假 ClearDelay 秒以后恢复。
+// 带死区的恢复用 ClearExpr 表达, 比如 Expr: "temp > 30", ClearExpr: "temp < 28"。
 type ExprDefine struct {
-	Expr      string `json:"expr"`
-	EventType string `json:"eventType"`
-	program   *vm.Program
+	Expr         string `json:"expr"`
+	EventType    string `json:"eventType"`
+	Severity     string `json:"severity"`   // 告警等级
+	ClearExpr    string `json:"clearExpr"`  // 恢复表达式, 可选
+	ClearDelay   uint64 `json:"clearDelay"` // 恢复延时(秒)
+	program      *vm.Program
+	clearProgram *vm.Program
+}
+
+// 告警状态
+const (
+	ALARM_STATE_ACTIVE  string = "ACTIVE"
+	ALARM_STATE_CLEARED string = "CLEARED"
+)
+
+// 告警等级
+const (
+	ALARM_SEVERITY_INFO     string = "INFO"
+	ALARM_SEVERITY_WARNING  string = "WARNING"
+	ALARM_SEVERITY_MAJOR    string = "MAJOR"
+	ALARM_SEVERITY_CRITICAL string = "CRITICAL"
+)
+
+// 告警动作, 也是推送给 HandleId 的事件类型
+const (
+	ALARM_ACTION_RAISE string = "RAISE"
+	ALARM_ACTION_CLEAR string = "CLEAR"
+	ALARM_ACTION_ACK   string = "ACK"
+)
+
+/*
+*
+* 告警等级校验, 空值默认为 WARNING
+*
+ */
+func NormalizeSeverity(severity string) (string, bool) {
+	switch severity {
+	case "":
+		return ALARM_SEVERITY_WARNING, true
+	case ALARM_SEVERITY_INFO, ALARM_SEVERITY_WARNING,
+		ALARM_SEVERITY_MAJOR, ALARM_SEVERITY_CRITICAL:
+		return severity, true
+	}
+	return severity, false
+}
+
+// 有状态告警, 触发时创建, 恢复时更新
+type MAlarm struct {
+	RhilexModel
+	UUID      string `gorm:"uniqueIndex"`                      // UUID
+	RuleId    string `gorm:"not null;index:idx_alarm_rule_id"` // 告警规则
+	Source    string `gorm:"not null"`                         // 告警源，某个设备
+	EventType string `gorm:"not null"`                         // 告警标识符
+	Severity  string `gorm:"not null"`                         // 告警等级
+	State     string `gorm:"not null;index"`                   // ACTIVE | CLEARED
+	Acked     bool   `gorm:"not null"`                         // 是否已确认
+	ActiveTs  uint64 `gorm:"not null"`                         // 触发时间
+	AckedTs   uint64 // 确认时间
+	ClearedTs uint64 // 恢复时间
+	AckedBy   string // 确认人
+	AckNote   string // 确认备注
+	Info      string // 触发时的数据
 }
@@ -88,7 +88,7 @@ func AlarmDbRegisterModel(dist ...any) {
 	__AlarmSqlite.db.AutoMigrate(dist...)
 }
 func InitAlarmDbModel(db *gorm.DB) {
-	db.AutoMigrate(&MAlarmLog{})
+	db.AutoMigrate(&MAlarmLog{}, &MAlarm{})
 	sql := `
 CREATE TRIGGER IF NOT EXISTS limit_m_internal_notifies
 AFTER INSERT ON m_alarm_logs
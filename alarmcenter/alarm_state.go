@@ -0,0 +1,312 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package alarmcenter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+// 某个规则某个告警源某个事件的告警状态
+type alarmState struct {
+	AlarmId    string    // 对应 MAlarm 的 UUID
+	RuleId     string    // 告警规则
+	EventType  string    // 事件
+	falseSince time.Time // Expr 开始变成假的时间, 用来做延时恢复
+}
+
+func alarmStateKey(ruleId, source, eventType string) string {
+	return ruleId + "|" + source + "|" + eventType
+}
+
+/*
+*
+* 执行布尔表达式
+*
+ */
+func runBoolProgram(program *vm.Program, in map[string]any) (bool, error) {
+	output, err := expr.Run(program, in)
+	if err != nil {
+		return false, err
+	}
+	T, ok := output.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s", "invalid expr result")
+	}
+	return T, nil
+}
+
+/*
+*
+* 状态变化以后要写的数据库和要发送的事件, 在状态锁外面执行
+*
+ */
+type alarmChange struct {
+	key   string
+	alarm *MAlarm // 触发的时候新建的告警, 恢复的时候为空
+	event QueueData
+}
+
+/*
+*
+* 告警状态机: 未触发 -> 触发 -> 恢复
+*
+ */
+func (ac *AlarmCenter) evaluate(ruleId, source string, rule *AlarmRule,
+	exprDefine ExprDefine, in map[string]any) error {
+	raised, err := runBoolProgram(exprDefine.program, in)
+	if err != nil {
+		return err
+	}
+	ac.locker.Lock()
+	change, err := ac.transition(ruleId, source, rule, exprDefine, in, raised)
+	if err != nil || change == nil {
+		ac.locker.Unlock()
+		return err
+	}
+	// 先拿到提交锁再释放状态锁, 状态变化按照发生的顺序落库和通知,
+	// 慢的数据库和满的事件队列只阻塞有状态变化的调用
+	ac.commitLocker.Lock()
+	ac.locker.Unlock()
+	ok := ac.commit(change)
+	ac.commitLocker.Unlock()
+	if !ok {
+		ac.rollback(change)
+	}
+	return nil
+}
+
+// 在状态锁里面计算状态变化, 没有变化的时候返回 nil
+func (ac *AlarmCenter) transition(ruleId, source string, rule *AlarmRule,
+	exprDefine ExprDefine, in map[string]any, raised bool) (*alarmChange, error) {
+	key := alarmStateKey(ruleId, source, exprDefine.EventType)
+	state, active := ac.states[key]
+	if !active {
+		// 触发前仍然按照 Threshold 和 Interval 做抑制
+		if raised && rule.AddLog() {
+			return ac.raise(key, ruleId, source, rule, exprDefine, in), nil
+		}
+		return nil, nil
+	}
+	if exprDefine.clearProgram != nil {
+		cleared, err := runBoolProgram(exprDefine.clearProgram, in)
+		if err != nil {
+			return nil, err
+		}
+		if cleared {
+			return ac.clear(key, state, source, rule, exprDefine, in), nil
+		}
+		return nil, nil
+	}
+	if raised {
+		state.falseSince = time.Time{}
+		return nil, nil
+	}
+	now := time.Now()
+	if state.falseSince.IsZero() {
+		state.falseSince = now
+	}
+	if now.Sub(state.falseSince) >= time.Duration(exprDefine.ClearDelay)*time.Second {
+		return ac.clear(key, state, source, rule, exprDefine, in), nil
+	}
+	return nil, nil
+}
+
+/*
+*
+* 触发告警
+*
+ */
+func (ac *AlarmCenter) raise(key, ruleId, source string, rule *AlarmRule,
+	exprDefine ExprDefine, in map[string]any) *alarmChange {
+	Data := BeautifulMapPrint(in)
+	Alarm := MAlarm{
+		UUID:      utils.AlarmUuid(),
+		RuleId:    ruleId,
+		Source:    source,
+		EventType: exprDefine.EventType,
+		Severity:  exprDefine.Severity,
+		State:     ALARM_STATE_ACTIVE,
+		ActiveTs:  uint64(time.Now().UnixMilli()),
+		Info:      Data,
+	}
+	ac.states[key] = &alarmState{
+		AlarmId:   Alarm.UUID,
+		RuleId:    ruleId,
+		EventType: exprDefine.EventType,
+	}
+	return &alarmChange{
+		key:   key,
+		alarm: &Alarm,
+		event: QueueData{
+			AlarmId:   Alarm.UUID,
+			Action:    ALARM_ACTION_RAISE,
+			In:        in,
+			RuleId:    ruleId,
+			Source:    source,
+			Expr:      exprDefine.Expr,
+			EventType: exprDefine.EventType,
+			Severity:  exprDefine.Severity,
+			HandleId:  rule.HandleId,
+			Data:      Data,
+		},
+	}
+}
+
+/*
+*
+* 恢复告警
+*
+ */
+func (ac *AlarmCenter) clear(key string, state *alarmState, source string,
+	rule *AlarmRule, exprDefine ExprDefine, in map[string]any) *alarmChange {
+	delete(ac.states, key)
+	return &alarmChange{
+		key: key,
+		event: QueueData{
+			AlarmId:   state.AlarmId,
+			Action:    ALARM_ACTION_CLEAR,
+			In:        in,
+			RuleId:    state.RuleId,
+			Source:    source,
+			Expr:      exprDefine.ClearExpr,
+			EventType: exprDefine.EventType,
+			Severity:  exprDefine.Severity,
+			HandleId:  rule.HandleId,
+			Data:      BeautifulMapPrint(in),
+		},
+	}
+}
+
+// 写数据库并发送事件, 新的告警没有保存成功的时候返回 false
+func (ac *AlarmCenter) commit(change *alarmChange) bool {
+	if change.alarm != nil {
+		if err := AlarmDb().Create(change.alarm).Error; err != nil {
+			glogger.GLogger.Error("Alarm raise failed:", err)
+			return false
+		}
+	} else if err := clearAlarm(change.event.AlarmId); err != nil {
+		glogger.GLogger.Error("Alarm clear failed:", err)
+	}
+	ac.QueueData <- change.event
+	return true
+}
+
+// 告警没有保存成功, 去掉内存里面的状态, 下次满足条件的时候重新触发
+func (ac *AlarmCenter) rollback(change *alarmChange) {
+	ac.locker.Lock()
+	defer ac.locker.Unlock()
+	if state, ok := ac.states[change.key]; ok && state.AlarmId == change.alarm.UUID {
+		delete(ac.states, change.key)
+	}
+}
+
+func clearAlarm(uuid string) error {
+	return AlarmDb().Model(&MAlarm{}).Where("uuid=?", uuid).
+		Updates(map[string]any{
+			"state":      ALARM_STATE_CLEARED,
+			"cleared_ts": uint64(time.Now().UnixMilli()),
+		}).Error
+}
+
+/*
+*
+* 删除规则的时候恢复它的告警, keep 里面的事件保留
+*
+ */
+func (ac *AlarmCenter) dropRuleStates(ruleId string, keep map[string]bool) {
+	ac.locker.Lock()
+	dropped := []string{}
+	for key, state := range ac.states {
+		if state.RuleId != ruleId || keep[state.EventType] {
+			continue
+		}
+		delete(ac.states, key)
+		dropped = append(dropped, state.AlarmId)
+	}
+	ac.commitLocker.Lock()
+	ac.locker.Unlock()
+	defer ac.commitLocker.Unlock()
+	for _, uuid := range dropped {
+		if err := clearAlarm(uuid); err != nil {
+			glogger.GLogger.Error("Alarm clear failed:", err)
+		}
+	}
+}
+
+/*
+*
+* 重启以后把数据库里面未恢复的告警加载回来
+*
+ */
+func (ac *AlarmCenter) restoreActiveAlarms() {
+	Alarms := []MAlarm{}
+	if err := AlarmDb().Where("state=?", ALARM_STATE_ACTIVE).Find(&Alarms).Error; err != nil {
+		glogger.GLogger.Error("Restore active alarms failed:", err)
+		return
+	}
+	ac.locker.Lock()
+	defer ac.locker.Unlock()
+	for _, Alarm := range Alarms {
+		ac.states[alarmStateKey(Alarm.RuleId, Alarm.Source, Alarm.EventType)] = &alarmState{
+			AlarmId:   Alarm.UUID,
+			RuleId:    Alarm.RuleId,
+			EventType: Alarm.EventType,
+		}
+	}
+}
+
+/*
+*
+* 确认告警, 确认不影响告警状态, 已恢复的告警也可以确认
+*
+ */
+func AckAlarm(uuid, username, note string) error {
+	Alarm := MAlarm{}
+	if err := AlarmDb().Where("uuid=?", uuid).First(&Alarm).Error; err != nil {
+		return err
+	}
+	// 带上未确认的条件更新, 同时确认的时候只有一个能成功
+	result := AlarmDb().Model(&MAlarm{}).Where("uuid=? AND acked=?", uuid, false).
+		Updates(map[string]any{
+			"acked":    true,
+			"acked_ts": uint64(time.Now().UnixMilli()),
+			"acked_by": username,
+			"ack_note": note,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		AlarmDb().Where("uuid=?", uuid).First(&Alarm)
+		return fmt.Errorf("alarm already acknowledged by: %s", Alarm.AckedBy)
+	}
+	__DefaultAlarmCenter.QueueData <- QueueData{
+		AlarmId:   Alarm.UUID,
+		Action:    ALARM_ACTION_ACK,
+		RuleId:    Alarm.RuleId,
+		Source:    Alarm.Source,
+		EventType: Alarm.EventType,
+		Severity:  Alarm.Severity,
+		Data:      fmt.Sprintf("Acknowledged by: %s, Note: %s", username, note),
+	}
+	return nil
+}
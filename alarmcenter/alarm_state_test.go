@@ -0,0 +1,185 @@
+package alarmcenter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/orderedmap"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/sirupsen/logrus"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 每个测试一个内存数据库, 不启动后台的日志协程
+func setupAlarmTest(t *testing.T) *AlarmCenter {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	__AlarmSqlite = &SqliteDAO{name: "Sqlite3", db: db}
+	InitAlarmDbModel(db)
+	__DefaultAlarmCenter = &AlarmCenter{
+		registry:  orderedmap.NewOrderedMap[string, *AlarmRule](),
+		caches:    []MAlarmLog{},
+		QueueData: make(chan QueueData, 16),
+		states:    map[string]*alarmState{},
+	}
+	return __DefaultAlarmCenter
+}
+
+func loadTestRule(t *testing.T, exprDefine ExprDefine) {
+	if err := LoadAlarmRule("RULE1", AlarmRule{
+		Threshold:   1,
+		ExprDefines: []ExprDefine{exprDefine},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func input(t *testing.T, temp float64) {
+	if _, err := Input("RULE1", "DEVICE1", map[string]any{"temp": temp}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 取出一个事件, 没有的时候返回空的 Action
+func nextEvent(ac *AlarmCenter) QueueData {
+	select {
+	case data := <-ac.QueueData:
+		return data
+	default:
+		return QueueData{}
+	}
+}
+
+func findAlarm(t *testing.T, uuid string) MAlarm {
+	alarm := MAlarm{}
+	if err := AlarmDb().Where("uuid=?", uuid).First(&alarm).Error; err != nil {
+		t.Fatal(err)
+	}
+	return alarm
+}
+
+func TestAlarmRaise(t *testing.T) {
+	ac := setupAlarmTest(t)
+	loadTestRule(t, ExprDefine{Expr: "temp > 30", EventType: "HIGH_TEMP"})
+	input(t, 20)
+	if event := nextEvent(ac); event.Action != "" {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	input(t, 35)
+	event := nextEvent(ac)
+	if event.Action != ALARM_ACTION_RAISE || event.Source != "DEVICE1" {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	if alarm := findAlarm(t, event.AlarmId); alarm.State != ALARM_STATE_ACTIVE ||
+		alarm.Severity != ALARM_SEVERITY_WARNING {
+		t.Fatalf("unexpected alarm: %+v", alarm)
+	}
+	// 已经触发的告警不会重复触发
+	input(t, 36)
+	if event := nextEvent(ac); event.Action != "" {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+}
+
+// 有恢复表达式的时候, 中间的值保持触发状态
+func TestAlarmClearExpr(t *testing.T) {
+	ac := setupAlarmTest(t)
+	loadTestRule(t, ExprDefine{Expr: "temp > 30", ClearExpr: "temp < 25", EventType: "HIGH_TEMP"})
+	input(t, 35)
+	raised := nextEvent(ac)
+	input(t, 28)
+	if event := nextEvent(ac); event.Action != "" {
+		t.Fatalf("alarm must stay active between the thresholds: %+v", event)
+	}
+	input(t, 20)
+	event := nextEvent(ac)
+	if event.Action != ALARM_ACTION_CLEAR || event.AlarmId != raised.AlarmId {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	if alarm := findAlarm(t, raised.AlarmId); alarm.State != ALARM_STATE_CLEARED || alarm.ClearedTs == 0 {
+		t.Fatalf("unexpected alarm: %+v", alarm)
+	}
+}
+
+func TestAlarmClearDelay(t *testing.T) {
+	ac := setupAlarmTest(t)
+	loadTestRule(t, ExprDefine{Expr: "temp > 30", EventType: "HIGH_TEMP", ClearDelay: 60})
+	input(t, 35)
+	raised := nextEvent(ac)
+	input(t, 20)
+	if event := nextEvent(ac); event.Action != "" {
+		t.Fatalf("alarm must not clear before the delay: %+v", event)
+	}
+	// 中间又满足条件, 重新计时
+	input(t, 35)
+	key := alarmStateKey("RULE1", "DEVICE1", "HIGH_TEMP")
+	if !ac.states[key].falseSince.IsZero() {
+		t.Fatal("raised again must reset the clear delay")
+	}
+	input(t, 20)
+	ac.states[key].falseSince = time.Now().Add(-time.Minute)
+	input(t, 20)
+	event := nextEvent(ac)
+	if event.Action != ALARM_ACTION_CLEAR || event.AlarmId != raised.AlarmId {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+}
+
+func TestAckAlarm(t *testing.T) {
+	ac := setupAlarmTest(t)
+	loadTestRule(t, ExprDefine{Expr: "temp > 30", EventType: "HIGH_TEMP"})
+	input(t, 35)
+	raised := nextEvent(ac)
+	if err := AckAlarm(raised.AlarmId, "admin", "checked"); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(ac); event.Action != ALARM_ACTION_ACK {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	alarm := findAlarm(t, raised.AlarmId)
+	if !alarm.Acked || alarm.AckedBy != "admin" || alarm.State != ALARM_STATE_ACTIVE {
+		t.Fatalf("unexpected alarm: %+v", alarm)
+	}
+	// 第二次确认不能覆盖第一次的确认人
+	if err := AckAlarm(raised.AlarmId, "operator", ""); err == nil {
+		t.Fatal("alarm can only be acknowledged once")
+	}
+	if alarm := findAlarm(t, raised.AlarmId); alarm.AckedBy != "admin" || alarm.AckNote != "checked" {
+		t.Fatalf("second ack must not overwrite the first: %+v", alarm)
+	}
+	if event := nextEvent(ac); event.Action != "" {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+}
+
+// 重启以后未恢复的告警还能恢复
+func TestRestoreActiveAlarms(t *testing.T) {
+	ac := setupAlarmTest(t)
+	AlarmDb().Create(&MAlarm{UUID: "ALARM1", RuleId: "RULE1", Source: "DEVICE1",
+		EventType: "HIGH_TEMP", Severity: ALARM_SEVERITY_MAJOR, State: ALARM_STATE_ACTIVE})
+	AlarmDb().Create(&MAlarm{UUID: "ALARM2", RuleId: "RULE1", Source: "DEVICE2",
+		EventType: "HIGH_TEMP", Severity: ALARM_SEVERITY_MAJOR, State: ALARM_STATE_CLEARED})
+	ac.restoreActiveAlarms()
+	if len(ac.states) != 1 {
+		t.Fatalf("expect 1 active alarm, got %d", len(ac.states))
+	}
+	loadTestRule(t, ExprDefine{Expr: "temp > 30", ClearExpr: "temp < 25", EventType: "HIGH_TEMP"})
+	input(t, 35)
+	if event := nextEvent(ac); event.Action != "" {
+		t.Fatalf("restored alarm must not raise again: %+v", event)
+	}
+	input(t, 20)
+	if event := nextEvent(ac); event.Action != ALARM_ACTION_CLEAR || event.AlarmId != "ALARM1" {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	if alarm := findAlarm(t, "ALARM1"); alarm.State != ALARM_STATE_CLEARED {
+		t.Fatalf("unexpected alarm: %+v", alarm)
+	}
+}
@@ -43,6 +43,7 @@ type Application struct {
 	AutoStart   bool               `json:"autoStart"`   // 自动启动
 	AppState    AppState           `json:"appState"`    // 状态: 1 运行中, 0 停止
 	KilledBy    string             `json:"-"`           // 被谁杀死的: RHILEX|EXCEPT|NORMAL|""
+	Budget      typex.LuaBudget    `json:"budget"`      // 执行预算, 默认只限制内存
 	luaMainFunc *lua.LFunction     `json:"-"`           // Main
 	vm          *lua.LState        `json:"-"`           // lua 环境
 	ctx         context.Context    `json:"-"`           // context
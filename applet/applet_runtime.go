@@ -21,6 +21,7 @@ import (
 	"time"
 
 	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/luaguard"
 	"github.com/hootrhino/rhilex/component/luaruntime"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
@@ -65,8 +66,9 @@ func LoadApp(app *Application, luaSource string) error {
 	// 加载库
 	// LoadAppLib(app, __DefaultAppletRuntime.RuleEngine)
 	luaruntime.LoadRuleLibGroup(__DefaultAppletRuntime.RuleEngine, "APPLET", app.UUID, app.VM())
-	// 加载到内存里
+	// 加载到内存里, 重新加载的应用不再因为之前超出预算被禁用
 	__DefaultAppletRuntime.Applications[app.UUID] = app
+	luaguard.Enable(app.UUID)
 	return nil
 }
 
@@ -99,17 +101,28 @@ func StartAppWithArgs(uuid string, args ...lua.LValue) error {
 		}()
 		glogger.GLogger.Debugf("Ready to run Application:%s", app.UUID)
 		app.AppState = 1
-		err := app.VM().CallByParam(lua.P{
-			Fn:      app.GetMainFunc(),
-			NRet:    1,
-			Protect: true,
-			Handler: &lua.LFunction{
-				GFunction: func(*lua.LState) int {
-					glogger.GLogger.Debug("Protect Mode Call")
-					return 0
+		err := luaguard.Run(app.VM(), luaguard.AppletBudget(app.Budget), func() error {
+			return app.VM().CallByParam(lua.P{
+				Fn:      app.GetMainFunc(),
+				NRet:    1,
+				Protect: true,
+				Handler: &lua.LFunction{
+					GFunction: func(*lua.LState) int {
+						glogger.GLogger.Debug("Protect Mode Call")
+						return 0
+					},
 				},
-			},
-		}, args...)
+			}, args...)
+		})
+		// 超出预算被中止, 连续超出 lua_max_violations 次以后不再自动重启
+		if v, ok := luaguard.AsViolation(err); ok {
+			if luaguard.ReportAppletViolation(app.UUID, v) {
+				glogger.GLogger.Warnf("App %s disabled by budget violations, No need to rescue", app.UUID)
+				return
+			}
+		} else {
+			luaguard.RecordSuccess(app.UUID)
+		}
 		if err == nil {
 			if app.KilledBy == "RHILEX" {
 				glogger.GLogger.Infof("Application %s Killed By RHILEX", app.UUID)
@@ -0,0 +1,147 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hootrhino/rhilex/alarmcenter"
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/server"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+func LoadAlarmRoute() {
+	api := server.RouteGroup(server.ContextUrl("/alarm"))
+	api.GET(("/list"), server.AddRoute(AlarmList))
+	api.GET(("/detail"), server.AddRoute(AlarmDetail))
+	api.PUT(("/ack"), server.AddRoute(AckAlarm))
+	api.DELETE(("/del"), server.AddRoute(DeleteAlarm))
+}
+
+// 告警
+type AlarmVo struct {
+	UUID      string `json:"uuid"`
+	RuleId    string `json:"ruleId"`
+	Source    string `json:"source"`
+	EventType string `json:"eventType"`
+	Severity  string `json:"severity"`
+	State     string `json:"state"`
+	Acked     bool   `json:"acked"`
+	ActiveTs  uint64 `json:"activeTs"`
+	AckedTs   uint64 `json:"ackedTs"`
+	ClearedTs uint64 `json:"clearedTs"`
+	AckedBy   string `json:"ackedBy"`
+	AckNote   string `json:"ackNote"`
+	Info      string `json:"info"`
+}
+
+func toAlarmVo(Alarm alarmcenter.MAlarm) AlarmVo {
+	return AlarmVo{
+		UUID:      Alarm.UUID,
+		RuleId:    Alarm.RuleId,
+		Source:    Alarm.Source,
+		EventType: Alarm.EventType,
+		Severity:  Alarm.Severity,
+		State:     Alarm.State,
+		Acked:     Alarm.Acked,
+		ActiveTs:  Alarm.ActiveTs,
+		AckedTs:   Alarm.AckedTs,
+		ClearedTs: Alarm.ClearedTs,
+		AckedBy:   Alarm.AckedBy,
+		AckNote:   Alarm.AckNote,
+		Info:      Alarm.Info,
+	}
+}
+
+/*
+*
+* 告警列表, 可以按状态(ACTIVE|CLEARED)和规则过滤
+*
+ */
+func AlarmList(c *gin.Context, ruleEngine typex.Rhilex) {
+	pager, err := service.ReadPageRequest(c)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	state, _ := c.GetQuery("state")
+	ruleId, _ := c.GetQuery("ruleId")
+	count, Alarms := service.PageAlarm(state, ruleId, pager.Current, pager.Size)
+	AlarmVos := []AlarmVo{}
+	for _, Alarm := range Alarms {
+		AlarmVos = append(AlarmVos, toAlarmVo(Alarm))
+	}
+	Result := service.WrapPageResult(*pager, AlarmVos, count)
+	c.JSON(common.HTTP_OK, common.OkWithData(Result))
+}
+
+// 详情
+func AlarmDetail(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	Alarm, err := service.GetMAlarmWithUUID(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400EmptyObj(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(toAlarmVo(*Alarm)))
+}
+
+/*
+*
+* 确认告警, 确认人为当前登录用户
+*
+ */
+func AckAlarm(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		UUID string `json:"uuid" binding:"required"`
+		Note string `json:"note"`
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	username, _ := server.CurrentUser(c)
+	if err := alarmcenter.AckAlarm(form.UUID, username, form.Note); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+/*
+*
+* 删除, 只能删除已经恢复的告警
+*
+ */
+func DeleteAlarm(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	Alarm, err := service.GetMAlarmWithUUID(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if Alarm.State == alarmcenter.ALARM_STATE_ACTIVE {
+		c.JSON(common.HTTP_OK, common.Error("Can not delete active alarm:"+uuid))
+		return
+	}
+	if err := service.DeleteAlarm(uuid); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
@@ -25,8 +25,11 @@ func LoadAlarmRuleRoute() {
 
 // 输出规则
 type ExprDefineVo struct {
-	Expr      string `json:"expr"`
-	EventType string `json:"eventType"`
+	Expr       string `json:"expr"`
+	EventType  string `json:"eventType"`
+	Severity   string `json:"severity"`
+	ClearExpr  string `json:"clearExpr"`
+	ClearDelay uint64 `json:"clearDelay"`
 }
 
 // 告警规则
@@ -58,8 +61,11 @@ func AlarmRuleList(c *gin.Context, ruleEngine typex.Rhilex) {
 		exprDef := []ExprDefineVo{}
 		for _, output := range MexprDefine {
 			exprDef = append(exprDef, ExprDefineVo{
-				Expr:      output.Expr,
-				EventType: output.EventType,
+				Expr:       output.Expr,
+				EventType:  output.EventType,
+				Severity:   output.Severity,
+				ClearExpr:  output.ClearExpr,
+				ClearDelay: output.ClearDelay,
 			})
 		}
 		AlarmRuleVos = append(AlarmRuleVos, AlarmRuleVo{
@@ -88,8 +94,11 @@ func AlarmRuleDetail(c *gin.Context, ruleEngine typex.Rhilex) {
 	exprDef := []ExprDefineVo{}
 	for _, output := range MexprDefine {
 		exprDef = append(exprDef, ExprDefineVo{
-			Expr:      output.Expr,
-			EventType: output.EventType,
+			Expr:       output.Expr,
+			EventType:  output.EventType,
+			Severity:   output.Severity,
+			ClearExpr:  output.ClearExpr,
+			ClearDelay: output.ClearDelay,
 		})
 	}
 	web_data := AlarmRuleVo{
@@ -146,6 +155,16 @@ func CreateAlarmRule(c *gin.Context, ruleEngine typex.Rhilex) {
 			c.JSON(common.HTTP_OK, common.Error("invalid expr result:"+exprDefine.Expr))
 			return
 		}
+		if exprDefine.ClearExpr != "" {
+			if _, errVerifyExpr := alarmcenter.VerifyExpr(exprDefine.ClearExpr); errVerifyExpr != nil {
+				c.JSON(common.HTTP_OK, common.Error400(errVerifyExpr))
+				return
+			}
+		}
+		if _, ok := alarmcenter.NormalizeSeverity(exprDefine.Severity); !ok {
+			c.JSON(common.HTTP_OK, common.Error("invalid severity:"+exprDefine.Severity))
+			return
+		}
 	}
 
 	exprDefinesBytes, _ := json.Marshal(AlarmRule.ExprDefine)
@@ -165,8 +184,11 @@ func CreateAlarmRule(c *gin.Context, ruleEngine typex.Rhilex) {
 	ExprDefines := []alarmcenter.ExprDefine{}
 	for _, ExprDefine := range AlarmRule.ExprDefine {
 		ExprDefines = append(ExprDefines, alarmcenter.ExprDefine{
-			Expr:      ExprDefine.Expr,
-			EventType: ExprDefine.EventType,
+			Expr:       ExprDefine.Expr,
+			EventType:  ExprDefine.EventType,
+			Severity:   ExprDefine.Severity,
+			ClearExpr:  ExprDefine.ClearExpr,
+			ClearDelay: ExprDefine.ClearDelay,
 		})
 	}
 	errLoadExpr := alarmcenter.LoadAlarmRule(Model.UUID, alarmcenter.AlarmRule{
@@ -207,6 +229,16 @@ func UpdateAlarmRule(c *gin.Context, ruleEngine typex.Rhilex) {
 			c.JSON(common.HTTP_OK, common.Error("invalid expr result:"+exprDefine.Expr))
 			return
 		}
+		if exprDefine.ClearExpr != "" {
+			if _, errVerifyExpr := alarmcenter.VerifyExpr(exprDefine.ClearExpr); errVerifyExpr != nil {
+				c.JSON(common.HTTP_OK, common.Error400(errVerifyExpr))
+				return
+			}
+		}
+		if _, ok := alarmcenter.NormalizeSeverity(exprDefine.Severity); !ok {
+			c.JSON(common.HTTP_OK, common.Error("invalid severity:"+exprDefine.Severity))
+			return
+		}
 	}
 
 	exprDefinesBytes, _ := json.Marshal(AlarmRule.ExprDefine)
@@ -227,16 +259,23 @@ func UpdateAlarmRule(c *gin.Context, ruleEngine typex.Rhilex) {
 	ExprDefines := []alarmcenter.ExprDefine{}
 	for _, ExprDefine := range AlarmRule.ExprDefine {
 		ExprDefines = append(ExprDefines, alarmcenter.ExprDefine{
-			Expr:      ExprDefine.Expr,
-			EventType: ExprDefine.EventType,
+			Expr:       ExprDefine.Expr,
+			EventType:  ExprDefine.EventType,
+			Severity:   ExprDefine.Severity,
+			ClearExpr:  ExprDefine.ClearExpr,
+			ClearDelay: ExprDefine.ClearDelay,
 		})
 	}
-	alarmcenter.ReLoadAlarmRule(Model.UUID, alarmcenter.AlarmRule{
+	errLoadExpr := alarmcenter.ReLoadAlarmRule(Model.UUID, alarmcenter.AlarmRule{
 		Interval:    time.Duration(AlarmRule.Interval) * time.Second,
 		Threshold:   AlarmRule.Threshold,
 		HandleId:    AlarmRule.HandleId,
 		ExprDefines: ExprDefines,
 	})
+	if errLoadExpr != nil {
+		c.JSON(common.HTTP_OK, common.Error400(errLoadExpr))
+		return
+	}
 	c.JSON(common.HTTP_OK, common.Ok())
 }
 
@@ -7,6 +7,7 @@ import (
 	"github.com/hootrhino/rhilex/component/apiserver/model"
 	"github.com/hootrhino/rhilex/component/apiserver/server"
 	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/luaguard"
 	"github.com/hootrhino/rhilex/component/luaruntime"
 
 	"github.com/gin-gonic/gin"
@@ -35,14 +36,15 @@ func InitAppletRoute() {
 *
  */
 type AppletDto struct {
-	UUID        string `json:"uuid,omitempty"` // 名称
-	Name        string `json:"name"`           // 名称
-	Version     string `json:"version"`        // 版本号
-	AutoStart   *bool  `json:"autoStart"`      // 自动启动
-	AppState    int    `json:"appState"`       // 状态: 1 运行中, 0 停止
-	Type        string `json:"type"`           // 默认就是lua, 留个扩展以后可能支持别的
-	LuaSource   string `json:"luaSource"`      // Lua源码
-	Description string `json:"description"`
+	UUID        string          `json:"uuid,omitempty"` // 名称
+	Name        string          `json:"name"`           // 名称
+	Version     string          `json:"version"`        // 版本号
+	AutoStart   *bool           `json:"autoStart"`      // 自动启动
+	AppState    int             `json:"appState"`       // 状态: 1 运行中, 0 停止
+	Type        string          `json:"type"`           // 默认就是lua, 留个扩展以后可能支持别的
+	LuaSource   string          `json:"luaSource"`      // Lua源码
+	Description string          `json:"description"`
+	Budget      typex.LuaBudget `json:"budget"` // 执行预算, 默认只限制内存
 }
 
 /*
@@ -72,6 +74,7 @@ func AppDetail(c *gin.Context, ruleEngine typex.Rhilex) {
 		}(),
 		Description: appInfo.Description,
 		LuaSource:   appInfo.LuaSource,
+		Budget:      service.GetAppletBudget(appInfo),
 	}
 	c.JSON(common.HTTP_OK, common.OkWithData(web_data))
 }
@@ -93,6 +96,7 @@ func Apps(c *gin.Context, ruleEngine typex.Rhilex) {
 				return 0
 			}(),
 			Description: mApp.Description,
+			Budget:      service.GetAppletBudget(&mApp),
 		}
 		result = append(result, web_data)
 	}
@@ -138,6 +142,7 @@ func CreateApp(c *gin.Context, ruleEngine typex.Rhilex) {
 			newUUID, form.Name, form.Version, form.Description, defaultLuaMain),
 		AutoStart:   form.AutoStart,
 		Description: form.Description,
+		Budget:      encodeLuaBudget(form.Budget),
 	}
 	if err := service.InsertApp(mAPP); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
@@ -147,6 +152,7 @@ func CreateApp(c *gin.Context, ruleEngine typex.Rhilex) {
 	newAPP := applet.NewApplication(newUUID, form.Name, form.Version)
 	newAPP.AutoStart = *form.AutoStart
 	newAPP.Description = form.Description
+	newAPP.Budget = form.Budget
 	if err := applet.LoadApp(newAPP, mAPP.LuaSource); err != nil {
 		glogger.GLogger.Error("app Load failed:", err)
 		c.JSON(common.HTTP_OK, common.Error400(err))
@@ -189,6 +195,7 @@ func UpdateApp(c *gin.Context, ruleEngine typex.Rhilex) {
 		AutoStart:   form.AutoStart,
 		LuaSource:   form.LuaSource,
 		Description: form.Description,
+		Budget:      encodeLuaBudget(form.Budget),
 	}
 	if err := service.UpdateApp(&mApp); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
@@ -207,6 +214,7 @@ func UpdateApp(c *gin.Context, ruleEngine typex.Rhilex) {
 	newAPP := applet.NewApplication(mApp.UUID, mApp.Name, mApp.Version)
 	newAPP.AutoStart = *mApp.AutoStart
 	newAPP.Description = mApp.Description
+	newAPP.Budget = form.Budget
 	if err := applet.LoadApp(newAPP, mApp.LuaSource); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
@@ -247,6 +255,8 @@ func StartApp(c *gin.Context, ruleEngine typex.Rhilex) {
 			c.JSON(common.HTTP_OK, common.Error400(fmt.Errorf("app is running now:%s", uuid)))
 		}
 		if app.AppState == 0 {
+			// 手动启动的时候解除因为超出预算的禁用
+			luaguard.Enable(uuid)
 			if err := applet.StartApp(uuid); err != nil {
 				c.JSON(common.HTTP_OK, common.Error400(err))
 			} else {
@@ -257,8 +267,9 @@ func StartApp(c *gin.Context, ruleEngine typex.Rhilex) {
 	}
 	// 如果内存里面没有，尝试从配置加载
 	glogger.GLogger.Debug("No loaded, will try to load:", uuid)
-	if err := applet.LoadApp(applet.NewApplication(
-		mApp.UUID, mApp.Name, mApp.Version), mApp.LuaSource); err != nil {
+	newAPP := applet.NewApplication(mApp.UUID, mApp.Name, mApp.Version)
+	newAPP.Budget = service.GetAppletBudget(mApp)
+	if err := applet.LoadApp(newAPP, mApp.LuaSource); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
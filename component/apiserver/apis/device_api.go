@@ -9,6 +9,8 @@ import (
 	"github.com/hootrhino/rhilex/component/apiserver/service"
 	"github.com/hootrhino/rhilex/component/intercache"
 	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/component/intermetric"
+	"github.com/hootrhino/rhilex/component/supervisor"
 	"gorm.io/gorm"
 
 	"github.com/hootrhino/rhilex/typex"
@@ -42,7 +44,10 @@ type DeviceVo struct {
 	State       int            `json:"state"`
 	ErrMsg      string         `json:"errMsg"`
 	Config      map[string]any `json:"config"`
+	Tags        []string       `json:"tags"` // 标签, 规则可以按照标签订阅
 	Description string         `json:"description"`
+
+	Restart *supervisor.RestartStatus `json:"restart,omitempty"` // 只有详情接口返回
 }
 
 /*
@@ -62,6 +67,7 @@ func DeviceDetail(c *gin.Context, ruleEngine typex.Rhilex) {
 	DeviceVo.Name = mdev.Name
 	DeviceVo.Type = mdev.Type
 	DeviceVo.Description = mdev.Description
+	DeviceVo.Tags = deviceTags(mdev.Tags)
 	DeviceVo.Config = mdev.GetConfig()
 	Slot := intercache.GetSlot("__DefaultRuleEngine")
 	if Slot != nil {
@@ -75,8 +81,10 @@ func DeviceDetail(c *gin.Context, ruleEngine typex.Rhilex) {
 	if device == nil {
 		DeviceVo.State = int(typex.SOURCE_STOP)
 	} else {
-		DeviceVo.State = int(device.Device.Status())
+		DeviceVo.State = int(supervisor.RestartState(mdev.UUID, device.Device.Status()))
 	}
+	Restart := supervisor.GetRestartStatus(mdev.UUID)
+	DeviceVo.Restart = &Restart
 	Group := service.GetResourceGroup(mdev.UUID)
 	DeviceVo.Gid = Group.UUID
 	c.JSON(common.HTTP_OK, common.OkWithData(DeviceVo))
@@ -101,13 +109,14 @@ func ListDevice(c *gin.Context, ruleEngine typex.Rhilex) {
 		DeviceVo.Name = mdev.Name
 		DeviceVo.Type = mdev.Type
 		DeviceVo.Description = mdev.Description
+		DeviceVo.Tags = deviceTags(mdev.Tags)
 		DeviceVo.Config = mdev.GetConfig()
 		//
 		device := ruleEngine.GetDevice(mdev.UUID)
 		if device == nil {
 			DeviceVo.State = int(typex.SOURCE_STOP)
 		} else {
-			DeviceVo.State = int(device.Device.Status())
+			DeviceVo.State = int(supervisor.RestartState(mdev.UUID, device.Device.Status()))
 		}
 		Group := service.GetResourceGroup(mdev.UUID)
 		DeviceVo.Gid = Group.UUID
@@ -139,13 +148,14 @@ func ListDeviceByGroup(c *gin.Context, ruleEngine typex.Rhilex) {
 		DeviceVo.Name = mdev.Name
 		DeviceVo.Type = mdev.Type
 		DeviceVo.Description = mdev.Description
+		DeviceVo.Tags = deviceTags(mdev.Tags)
 		DeviceVo.Config = mdev.GetConfig()
 		//
 		device := ruleEngine.GetDevice(mdev.UUID)
 		if device == nil {
 			DeviceVo.State = int(typex.SOURCE_STOP)
 		} else {
-			DeviceVo.State = int(device.Device.Status())
+			DeviceVo.State = int(supervisor.RestartState(mdev.UUID, device.Device.Status()))
 		}
 		Group := service.GetResourceGroup(mdev.UUID)
 		DeviceVo.Gid = Group.UUID
@@ -160,7 +170,7 @@ func ListDeviceByGroup(c *gin.Context, ruleEngine typex.Rhilex) {
 // 重启
 func RestartDevice(c *gin.Context, ruleEngine typex.Rhilex) {
 	uuid, _ := c.GetQuery("uuid")
-	err := ruleEngine.RestartDevice(uuid)
+	err := restartResource(uuid, ruleEngine, ruleEngine.RestartDevice, server.LoadNewestDevice)
 	if err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
@@ -207,6 +217,23 @@ func DeleteDevice(c *gin.Context, ruleEngine typex.Rhilex) {
 		}
 		goto NEXT
 	}
+	// OPC UA需要同步删除点位表记录
+	if Mdev.Type == typex.OPCUA_CLIENT.String() {
+		if err := service.DeleteAllOpcuaDataPointByDevice(uuid); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		goto NEXT
+	}
+	// DNP3需要同步删除测点表记录
+	if Mdev.Type == typex.DNP3_MASTER.String() ||
+		Mdev.Type == typex.DNP3_OUTSTATION.String() {
+		if err := service.DeleteAllDnp3DataPointByDevice(uuid); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		goto NEXT
+	}
 	// SNMP需要同步删除点位表记录
 	if Mdev.Type == typex.GENERIC_SNMP.String() {
 		if err := service.DeleteAllSnmpOidByDevice(uuid); err != nil {
@@ -291,6 +318,8 @@ NEXT:
 		c.JSON(common.HTTP_OK, common.Error400(txErr))
 		return
 	}
+	intermetric.Remove(uuid)
+	supervisor.RemoveRestart(uuid)
 	// 删除云边协同
 	intercache.DeleteValue("__CecollaBinding", uuid)
 	c.JSON(common.HTTP_OK, common.Ok())
@@ -327,6 +356,10 @@ func CreateDevice(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
+	if err := checkRestartPolicy(form.Config); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
 	if service.CheckDeviceNameDuplicate(form.Name) {
 		c.JSON(common.HTTP_OK, common.Error("Device Name Duplicated"))
 		return
@@ -358,6 +391,7 @@ func CreateDevice(c *gin.Context, ruleEngine typex.Rhilex) {
 		Name:        form.Name,
 		Description: form.Description,
 		Config:      string(configJson),
+		Tags:        deviceTags(uniqueStrings(form.Tags)),
 		BindRules:   []string{},
 	}
 	if err := service.InsertDevice(&MDevice); err != nil {
@@ -394,6 +428,10 @@ func UpdateDevice(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error(r))
 		return
 	}
+	if err := checkRestartPolicy(form.Config); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
 	deviceConfig := DeviceConfig{}
 	json.Unmarshal(configJson, &deviceConfig)
 	// if deviceConfig.CecollaConfig.Enable != nil {
@@ -410,6 +448,7 @@ func UpdateDevice(c *gin.Context, ruleEngine typex.Rhilex) {
 			Name:        form.Name,
 			Description: form.Description,
 			Config:      string(configJson),
+			Tags:        deviceTags(uniqueStrings(form.Tags)),
 		}
 		return tx.Model(MDevice).
 			Where("uuid=?", form.UUID).
@@ -419,6 +458,7 @@ func UpdateDevice(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(txErr))
 		return
 	}
+	supervisor.ResetRestart(form.UUID)
 	if err := server.LoadNewestDevice(form.UUID, ruleEngine); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
@@ -443,3 +483,8 @@ func GetDeviceErrorMsg(c *gin.Context, ruleEngine typex.Rhilex) {
 	}
 	c.JSON(common.HTTP_OK, common.OkWithData("--"))
 }
+
+// 标签不为 nil, 更新的时候清空标签也要写进去
+func deviceTags(tags []string) model.StringList {
+	return append(model.StringList{}, tags...)
+}
@@ -16,6 +16,7 @@
 package apis
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"mime/multipart"
@@ -49,6 +50,9 @@ func InitBacnetIpRoute() {
 		route.POST(("/update"), server.AddRoute(BacnetIpSheetCreateOrUpdate))
 		route.DELETE(("/delIds"), server.AddRoute(BacnetIpSheetDeleteByUUIDs))
 		route.DELETE(("/delAll"), server.AddRoute(BacnetIpSheetDeleteAll))
+		route.GET(("/whois"), server.AddRoute(BacnetIpWhoIs))
+		route.GET(("/objects"), server.AddRoute(BacnetIpObjectList))
+		route.POST(("/importFromNetwork"), server.AddRoute(BacnetIpImportFromNetwork))
 	}
 }
 
@@ -390,3 +394,157 @@ func checkBacnetPoint(point dto.BacnetDataPointCreateOrUpdate) error {
 	}
 	return nil
 }
+
+/*
+*
+* 从网络上发现设备和点位, 需要设备已经启动
+*
+ */
+func runningBacnetIpDevice(ruleEngine typex.Rhilex, deviceUuid string) (*typex.Device, error) {
+	Device := ruleEngine.GetDevice(deviceUuid)
+	if Device == nil || Device.Device == nil {
+		return nil, fmt.Errorf("device not exists: %s", deviceUuid)
+	}
+	if Device.Type != typex.GENERIC_BACNET_IP {
+		return nil, errors.New("invalid device type, only support bacnet ip device")
+	}
+	if Device.Device.Status() != typex.SOURCE_UP {
+		return nil, fmt.Errorf("device down: %s", deviceUuid)
+	}
+	return Device, nil
+}
+
+func BacnetIpWhoIs(c *gin.Context, ruleEngine typex.Rhilex) {
+	deviceUuid, _ := c.GetQuery("device_uuid")
+	low, _ := strconv.Atoi(c.DefaultQuery("low", "-1"))
+	high, _ := strconv.Atoi(c.DefaultQuery("high", "-1"))
+	Device, err := runningBacnetIpDevice(ruleEngine, deviceUuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	args, _ := json.Marshal(map[string]int{"low": low, "high": high})
+	result, err := Device.Device.OnCtrl([]byte("WhoIs"), args)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	devices := []dto.BacnetRemoteDeviceVO{}
+	if err := json.Unmarshal(result, &devices); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(devices))
+}
+
+func BacnetIpObjectList(c *gin.Context, ruleEngine typex.Rhilex) {
+	deviceUuid, _ := c.GetQuery("device_uuid")
+	bacnetDeviceId, err := strconv.ParseUint(c.Query("bacnetDeviceId"), 10, 32)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(errors.New("invalid bacnetDeviceId")))
+		return
+	}
+	objects, err := readBacnetObjectList(ruleEngine, deviceUuid, uint32(bacnetDeviceId))
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(objects))
+}
+
+func readBacnetObjectList(ruleEngine typex.Rhilex, deviceUuid string,
+	bacnetDeviceId uint32) ([]dto.BacnetRemoteObjectVO, error) {
+	Device, err := runningBacnetIpDevice(ruleEngine, deviceUuid)
+	if err != nil {
+		return nil, err
+	}
+	args, _ := json.Marshal(map[string]uint32{"bacnetDeviceId": bacnetDeviceId})
+	result, err := Device.Device.OnCtrl([]byte("ReadObjectList"), args)
+	if err != nil {
+		return nil, err
+	}
+	objects := []dto.BacnetRemoteObjectVO{}
+	if err := json.Unmarshal(result, &objects); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+/*
+*
+* 把远程设备的对象导入点位表, objects 为空的时候导入全部对象; 已经存在的点位跳过
+*
+ */
+func BacnetIpImportFromNetwork(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		DeviceUUID     string                     `json:"device_uuid"`
+		BacnetDeviceId uint32                     `json:"bacnetDeviceId"`
+		Objects        []dto.BacnetRemoteObjectVO `json:"objects"`
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	objects := form.Objects
+	if len(objects) == 0 {
+		var err error
+		objects, err = readBacnetObjectList(ruleEngine, form.DeviceUUID, form.BacnetDeviceId)
+		if err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+	}
+	var records []model.MBacnetDataPoint
+	if err := interdb.InterDb().Model(&model.MBacnetDataPoint{}).
+		Where("device_uuid=?", form.DeviceUUID).Find(&records).Error; err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	exists := map[string]bool{}
+	for _, record := range records {
+		exists[fmt.Sprintf("%d:%s:%d", record.BacnetDeviceId, record.ObjectType, record.ObjectId)] = true
+	}
+	list := make([]model.MBacnetDataPoint, 0)
+	for _, object := range objects {
+		key := fmt.Sprintf("%d:%s:%d", form.BacnetDeviceId, object.ObjectType, object.ObjectId)
+		if exists[key] {
+			continue
+		}
+		exists[key] = true
+		createDto := dto.BacnetDataPointCreateOrUpdate{
+			Tag:            object.Name,
+			Alias:          object.Description,
+			BacnetDeviceId: form.BacnetDeviceId,
+			ObjectType:     object.ObjectType,
+			ObjectId:       object.ObjectId,
+		}
+		if createDto.Tag == "" {
+			createDto.Tag = fmt.Sprintf("%s_%d", object.ObjectType, object.ObjectId)
+		}
+		if createDto.Alias == "" {
+			createDto.Alias = createDto.Tag
+		}
+		if err := checkBacnetPoint(createDto); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		list = append(list, model.MBacnetDataPoint{
+			UUID:           utils.BacnetPointUUID(),
+			DeviceUuid:     form.DeviceUUID,
+			Tag:            createDto.Tag,
+			Alias:          createDto.Alias,
+			BacnetDeviceId: createDto.BacnetDeviceId,
+			ObjectType:     createDto.ObjectType,
+			ObjectId:       createDto.ObjectId,
+		})
+	}
+	if len(list) > 0 {
+		if err := service.BatchInsertBacnetDataPoint(list); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		ruleEngine.RestartDevice(form.DeviceUUID)
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(map[string]int{"imported": len(list)}))
+}
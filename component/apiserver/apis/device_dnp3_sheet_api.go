@@ -0,0 +1,474 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hootrhino/rhilex/glogger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/interdb"
+
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/apiserver/server"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/device/dnp3"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+	"github.com/xuri/excelize/v2"
+)
+
+func InitDnp3Route() {
+	// DNP3 测点表, 主站和子站共用
+	Route := server.RouteGroup(server.ContextUrl("/dnp3_data_sheet"))
+	{
+		Route.POST(("/sheetImport"), server.AddRoute(Dnp3SheetImport))
+		Route.GET(("/sheetExport"), server.AddRoute(Dnp3SheetExport))
+		Route.GET(("/list"), server.AddRoute(Dnp3SheetPageList))
+		Route.POST(("/update"), server.AddRoute(Dnp3SheetUpdate))
+		Route.DELETE(("/delAll"), server.AddRoute(Dnp3SheetDeleteAll))
+		Route.DELETE(("/delIds"), server.AddRoute(Dnp3SheetDeleteByUUIDs))
+	}
+}
+
+type Dnp3DataPointVo struct {
+	UUID          string   `json:"uuid,omitempty"`
+	DeviceUUID    string   `json:"device_uuid"`
+	PointType     string   `json:"pointType"`
+	PointIndex    uint16   `json:"pointIndex"`
+	Tag           string   `json:"tag"`
+	Alias         string   `json:"alias"`
+	EventClass    *int     `json:"eventClass"`    // 子站: 事件类别
+	SourceDevice  string   `json:"sourceDevice"`  // 子站: 数据来源设备
+	SourceTag     string   `json:"sourceTag"`     // 子站: 数据来源点位
+	ReportMode    string   `json:"reportMode"`    // 上报模式
+	Deadband      *float64 `json:"deadband"`      // 死区
+	MaxSilence    *uint64  `json:"maxSilence"`    // 最长静默时间(秒)
+	ErrMsg        string   `json:"errMsg"`        // 运行时数据
+	Status        int      `json:"status"`        // 运行时数据
+	LastFetchTime uint64   `json:"lastFetchTime"` // 运行时数据
+	Value         any      `json:"value"`         // 运行时数据
+}
+
+// Dnp3SheetExport 导出测点表
+func Dnp3SheetExport(c *gin.Context, ruleEngine typex.Rhilex) {
+	deviceUuid, _ := c.GetQuery("device_uuid")
+
+	var records []model.MDnp3DataPoint
+	result := interdb.InterDb().Table("m_dnp3_data_points").
+		Where("device_uuid=?", deviceUuid).Find(&records)
+	if result.Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(result.Error))
+		return
+	}
+	// pointType	pointIndex	tag	alias	eventClass	sourceDevice	sourceTag
+	Headers := []string{
+		"pointType", "pointIndex", "tag", "alias", "eventClass", "sourceDevice", "sourceTag",
+	}
+	Headers = append(Headers, reportPolicyHeaders...)
+	xlsx := excelize.NewFile()
+	defer func() {
+		if err := xlsx.Close(); err != nil {
+			glogger.GLogger.Errorf("close excel file, err=%v", err)
+		}
+	}()
+	cell, _ := excelize.CoordinatesToCellName(1, 1)
+	xlsx.SetSheetRow("Sheet1", cell, &Headers)
+	for idx, record := range records {
+		EventClass := 0
+		if record.EventClass != nil {
+			EventClass = *record.EventClass
+		}
+		Row := []string{
+			record.PointType, fmt.Sprintf("%d", record.PointIndex), record.Tag, record.Alias,
+			fmt.Sprintf("%d", EventClass), record.SourceDevice, record.SourceTag,
+		}
+		Row = append(Row, reportPolicyRow(record.ReportMode, record.Deadband, record.MaxSilence)...)
+		cell, _ = excelize.CoordinatesToCellName(1, idx+2)
+		xlsx.SetSheetRow("Sheet1", cell, &Row)
+	}
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment;filename=%v.xlsx",
+		time.Now().UnixMilli()))
+	xlsx.WriteTo(c.Writer)
+}
+
+// Dnp3SheetPageList 分页获取
+func Dnp3SheetPageList(c *gin.Context, ruleEngine typex.Rhilex) {
+	pager, err := service.ReadPageRequest(c)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	deviceUuid, _ := c.GetQuery("device_uuid")
+	db := interdb.InterDb()
+	tx := db.Scopes(service.Paginate(*pager))
+	var count int64
+	err1 := interdb.InterDb().Model(&model.MDnp3DataPoint{}).
+		Where("device_uuid=?", deviceUuid).Count(&count).Error
+	if err1 != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err1))
+		return
+	}
+	var records []model.MDnp3DataPoint
+	result := tx.Order("created_at DESC").Find(&records,
+		&model.MDnp3DataPoint{DeviceUuid: deviceUuid})
+	if result.Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(result.Error))
+		return
+	}
+	Slot := intercache.GetSlot(deviceUuid)
+	recordsVo := []Dnp3DataPointVo{}
+	for _, record := range records {
+		value, ok := Slot[record.UUID]
+		Vo := Dnp3DataPointVo{
+			UUID:         record.UUID,
+			DeviceUUID:   record.DeviceUuid,
+			PointType:    record.PointType,
+			PointIndex:   record.PointIndex,
+			Tag:          record.Tag,
+			Alias:        record.Alias,
+			EventClass:   record.EventClass,
+			SourceDevice: record.SourceDevice,
+			SourceTag:    record.SourceTag,
+			ReportMode:   reportModeOrDefault(record.ReportMode),
+			Deadband:     deadbandToFloat64(record.Deadband),
+			MaxSilence:   record.MaxSilence,
+			ErrMsg:       value.ErrMsg,
+		}
+		if ok {
+			Vo.Status = value.Status               // 运行时
+			Vo.LastFetchTime = value.LastFetchTime // 运行时
+			Vo.Value = value.Value                 // 运行时
+		}
+		recordsVo = append(recordsVo, Vo)
+	}
+	Result := service.WrapPageResult(*pager, recordsVo, count)
+	c.JSON(common.HTTP_OK, common.OkWithData(Result))
+}
+
+/*
+*
+* 删除单行
+*
+ */
+func Dnp3SheetDeleteByUUIDs(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		UUIDs      []string `json:"uuids"`
+		DeviceUUID string   `json:"device_uuid"`
+	}
+	form := Form{}
+	if Error := c.ShouldBindJSON(&form); Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(Error))
+		return
+	}
+	err := service.DeleteDnp3DataPointByDevice(form.UUIDs, form.DeviceUUID)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	ruleEngine.RestartDevice(form.DeviceUUID)
+	c.JSON(common.HTTP_OK, common.Ok())
+
+}
+
+/*
+*
+* 删除所有
+*
+ */
+func Dnp3SheetDeleteAll(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		DeviceUUID string `json:"device_uuid"`
+	}
+	form := Form{}
+	if Error := c.ShouldBindJSON(&form); Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(Error))
+		return
+	}
+	err := service.DeleteAllDnp3DataPointByDevice(form.DeviceUUID)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	ruleEngine.RestartDevice(form.DeviceUUID)
+	c.JSON(common.HTTP_OK, common.Ok())
+
+}
+
+/*
+*
+* 检查点位合法性, 子站的数据来源设备和点位要么都填要么都不填
+*
+ */
+func checkDnp3DataPoint(M Dnp3DataPointVo) error {
+	if err := dnp3.CheckPointType(dnp3.PointType(M.PointType)); err != nil {
+		return err
+	}
+	if M.EventClass != nil && (*M.EventClass < 0 || *M.EventClass > 3) {
+		return fmt.Errorf("'EventClass must range of 0-3")
+	}
+	if (M.SourceDevice == "") != (M.SourceTag == "") {
+		return fmt.Errorf("'SourceDevice and SourceTag must be set together")
+	}
+	if M.Tag == "" {
+		return fmt.Errorf("'Missing required param 'tag'")
+	}
+	if len(M.Tag) > 256 {
+		return fmt.Errorf("'Tag length must range of 1-256")
+	}
+	if M.Alias == "" {
+		return fmt.Errorf("'Missing required param 'alias'")
+	}
+	if len(M.Alias) > 256 {
+		return fmt.Errorf("'Alias length must range of 1-256")
+	}
+	return checkReportPolicy(M.ReportMode, M.Deadband)
+}
+
+// 没有填事件类别的时候按照测点类型取默认值: 遥信 1 类, 遥测 2 类, 电度 3 类
+func dnp3EventClassOrDefault(pointType string, eventClass *int) *int {
+	if eventClass != nil {
+		return eventClass
+	}
+	class := 0
+	switch dnp3.PointType(pointType) {
+	case dnp3.BINARY_INPUT:
+		class = 1
+	case dnp3.ANALOG_INPUT:
+		class = 2
+	case dnp3.COUNTER:
+		class = 3
+	}
+	return &class
+}
+
+/*
+*
+* 更新测点表
+*
+ */
+func Dnp3SheetUpdate(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		DeviceUUID string            `json:"device_uuid"`
+		DataPoints []Dnp3DataPointVo `json:"data_points"`
+	}
+	form := Form{}
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	for _, DataPoint := range form.DataPoints {
+		if err := checkDnp3DataPoint(DataPoint); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		Row := model.MDnp3DataPoint{
+			UUID:         DataPoint.UUID,
+			DeviceUuid:   DataPoint.DeviceUUID,
+			PointType:    DataPoint.PointType,
+			PointIndex:   DataPoint.PointIndex,
+			Tag:          DataPoint.Tag,
+			Alias:        DataPoint.Alias,
+			EventClass:   dnp3EventClassOrDefault(DataPoint.PointType, DataPoint.EventClass),
+			SourceDevice: DataPoint.SourceDevice,
+			SourceTag:    DataPoint.SourceTag,
+			ReportMode:   reportModeOrDefault(DataPoint.ReportMode),
+			Deadband:     model.NewDecimal(*utils.HandleZeroValue(DataPoint.Deadband)),
+			MaxSilence:   DataPoint.MaxSilence,
+		}
+		if DataPoint.UUID == "" ||
+			DataPoint.UUID == "new" ||
+			DataPoint.UUID == "copy" {
+			Row.UUID = utils.Dnp3PointUUID()
+			Row.DeviceUuid = form.DeviceUUID
+			if err0 := service.InsertDnp3DataPoint(Row); err0 != nil {
+				c.JSON(common.HTTP_OK, common.Error400(err0))
+				return
+			}
+		} else {
+			if err0 := service.UpdateDnp3DataPoint(Row); err0 != nil {
+				c.JSON(common.HTTP_OK, common.Error400(err0))
+				return
+			}
+		}
+	}
+	ruleEngine.RestartDevice(form.DeviceUUID)
+	c.JSON(common.HTTP_OK, common.Ok())
+
+}
+
+// Dnp3SheetImport 上传Excel文件
+func Dnp3SheetImport(c *gin.Context, ruleEngine typex.Rhilex) {
+	// 解析 multipart/form-data 类型的请求体
+	err := c.Request.ParseMultipartForm(1024 * 1024 * 10)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+
+	// 获取上传的文件
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	defer file.Close()
+	deviceUuid := c.Request.Form.Get("device_uuid")
+	type DeviceDto struct {
+		UUID string
+		Name string
+		Type string
+	}
+	Device := DeviceDto{}
+	errDb := interdb.InterDb().Table("m_devices").
+		Where("uuid=?", deviceUuid).Find(&Device).Error
+	if errDb != nil {
+		c.JSON(common.HTTP_OK, common.Error400(errDb))
+		return
+	}
+	if Device.Type == "" {
+		c.JSON(common.HTTP_OK,
+			common.Error("Device Not Exists"))
+		return
+	}
+	if Device.Type != typex.DNP3_MASTER.String() &&
+		Device.Type != typex.DNP3_OUTSTATION.String() {
+		c.JSON(common.HTTP_OK,
+			common.Error("Invalid Device Type, Only Support Import DNP3 Master Or Outstation"))
+		return
+	}
+	contentType := header.Header.Get("Content-Type")
+	if contentType != "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet" &&
+		contentType != "application/vnd.ms-excel" {
+		c.JSON(common.HTTP_OK, common.Error("File Must be Excel Sheet"))
+		return
+	}
+	// 判断文件大小是否符合要求（10MB）
+	if header.Size > 1024*1024*10 {
+		c.JSON(common.HTTP_OK, common.Error("Excel file size cannot be greater than 10MB"))
+		return
+	}
+	list, err := parseDnp3DataPointExcel(file, "Sheet1", deviceUuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err = service.InsertDnp3DataPoints(list); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	ruleEngine.RestartDevice(deviceUuid)
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+/*
+*
+* 解析表格
+*
+ */
+func parseDnp3DataPointExcel(r io.Reader, sheetName string,
+	deviceUuid string) (list []model.MDnp3DataPoint, err error) {
+	excelFile, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		excelFile.Close()
+	}()
+	// 读取表格
+	rows, err := excelFile.GetRows(sheetName)
+	if err != nil {
+		return nil, err
+	}
+	// 严格检查表结构 pointType,pointIndex,tag,alias,eventClass,sourceDevice,sourceTag
+	err1 := errors.New("'Invalid Sheet Header, must follow fixed format: " +
+		"【pointType,pointIndex,tag,alias,eventClass,sourceDevice,sourceTag】")
+	if len(rows) < 1 || len(rows[0]) < 7 {
+		return nil, err1
+	}
+	if rows[0][0] != "pointType" ||
+		rows[0][1] != "pointIndex" ||
+		rows[0][2] != "tag" ||
+		rows[0][3] != "alias" ||
+		rows[0][4] != "eventClass" ||
+		rows[0][5] != "sourceDevice" ||
+		rows[0][6] != "sourceTag" {
+		return nil, err1
+	}
+
+	list = make([]model.MDnp3DataPoint, 0)
+	for i := 1; i < len(rows); i++ {
+		row := rows[i]
+		if len(row) < 5 {
+			return nil, fmt.Errorf("invalid row %d, missing columns", i+1)
+		}
+		// 来源设备和点位可以为空, 表格读出来的行会去掉末尾的空列
+		for len(row) < 7 {
+			row = append(row, "")
+		}
+		ReportMode, Deadband, MaxSilence, err := parseReportPolicyRow(rows[0], row, 7)
+		if err != nil {
+			return nil, err
+		}
+		PointIndex, err := strconv.ParseUint(strings.TrimSpace(row[1]), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid row %d: invalid pointIndex '%s'", i+1, row[1])
+		}
+		EventClass, err := strconv.Atoi(strings.TrimSpace(row[4]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid row %d: invalid eventClass '%s'", i+1, row[4])
+		}
+		Vo := Dnp3DataPointVo{
+			PointType:    strings.TrimSpace(row[0]),
+			PointIndex:   uint16(PointIndex),
+			Tag:          row[2],
+			Alias:        row[3],
+			EventClass:   &EventClass,
+			SourceDevice: strings.TrimSpace(row[5]),
+			SourceTag:    strings.TrimSpace(row[6]),
+			ReportMode:   ReportMode,
+			Deadband:     &Deadband,
+		}
+		if err := checkDnp3DataPoint(Vo); err != nil {
+			return nil, fmt.Errorf("invalid row %d: %v", i+1, err)
+		}
+		list = append(list, model.MDnp3DataPoint{
+			UUID:         utils.Dnp3PointUUID(),
+			DeviceUuid:   deviceUuid,
+			PointType:    Vo.PointType,
+			PointIndex:   Vo.PointIndex,
+			Tag:          Vo.Tag,
+			Alias:        Vo.Alias,
+			EventClass:   Vo.EventClass,
+			SourceDevice: Vo.SourceDevice,
+			SourceTag:    Vo.SourceTag,
+			ReportMode:   ReportMode,
+			Deadband:     model.NewDecimal(Deadband),
+			MaxSilence:   &MaxSilence,
+		})
+	}
+	return list, nil
+}
@@ -0,0 +1,441 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hootrhino/rhilex/glogger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/interdb"
+
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/apiserver/server"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/device/iec104"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+	"github.com/xuri/excelize/v2"
+)
+
+func InitIec104Route() {
+	// IEC104 信息体地址表
+	Route := server.RouteGroup(server.ContextUrl("/iec104_master_sheet"))
+	{
+		Route.POST(("/sheetImport"), server.AddRoute(Iec104SheetImport))
+		Route.GET(("/sheetExport"), server.AddRoute(Iec104SheetExport))
+		Route.GET(("/list"), server.AddRoute(Iec104SheetPageList))
+		Route.POST(("/update"), server.AddRoute(Iec104SheetUpdate))
+		Route.DELETE(("/delAll"), server.AddRoute(Iec104SheetDeleteAll))
+		Route.DELETE(("/delIds"), server.AddRoute(Iec104SheetDeleteByUUIDs))
+	}
+}
+
+type Iec104DataPointVo struct {
+	UUID          string   `json:"uuid,omitempty"`
+	DeviceUUID    string   `json:"device_uuid"`
+	Ioa           uint32   `json:"ioa"`
+	TypeId        int      `json:"typeId"`
+	Tag           string   `json:"tag"`
+	Alias         string   `json:"alias"`
+	ReportMode    string   `json:"reportMode"`    // 上报模式
+	Deadband      *float64 `json:"deadband"`      // 死区
+	MaxSilence    *uint64  `json:"maxSilence"`    // 最长静默时间(秒)
+	ErrMsg        string   `json:"errMsg"`        // 运行时数据
+	Status        int      `json:"status"`        // 运行时数据
+	LastFetchTime uint64   `json:"lastFetchTime"` // 运行时数据
+	Value         any      `json:"value"`         // 运行时数据
+}
+
+// Iec104SheetExport 导出信息体地址表
+func Iec104SheetExport(c *gin.Context, ruleEngine typex.Rhilex) {
+	deviceUuid, _ := c.GetQuery("device_uuid")
+
+	var records []model.MIec104DataPoint
+	result := interdb.InterDb().Table("m_iec104_data_points").
+		Where("device_uuid=?", deviceUuid).Find(&records)
+	if result.Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(result.Error))
+		return
+	}
+	// ioa	typeId	tag	alias
+	Headers := []string{
+		"ioa", "typeId", "tag", "alias",
+	}
+	Headers = append(Headers, reportPolicyHeaders...)
+	xlsx := excelize.NewFile()
+	defer func() {
+		if err := xlsx.Close(); err != nil {
+			glogger.GLogger.Errorf("close excel file, err=%v", err)
+		}
+	}()
+	cell, _ := excelize.CoordinatesToCellName(1, 1)
+	xlsx.SetSheetRow("Sheet1", cell, &Headers)
+	for idx, record := range records {
+		Row := []string{
+			fmt.Sprintf("%d", record.Ioa), fmt.Sprintf("%d", record.TypeId), record.Tag, record.Alias,
+		}
+		Row = append(Row, reportPolicyRow(record.ReportMode, record.Deadband, record.MaxSilence)...)
+		cell, _ = excelize.CoordinatesToCellName(1, idx+2)
+		xlsx.SetSheetRow("Sheet1", cell, &Row)
+	}
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment;filename=%v.xlsx",
+		time.Now().UnixMilli()))
+	xlsx.WriteTo(c.Writer)
+}
+
+// Iec104SheetPageList 分页获取
+func Iec104SheetPageList(c *gin.Context, ruleEngine typex.Rhilex) {
+	pager, err := service.ReadPageRequest(c)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	deviceUuid, _ := c.GetQuery("device_uuid")
+	db := interdb.InterDb()
+	tx := db.Scopes(service.Paginate(*pager))
+	var count int64
+	err1 := interdb.InterDb().Model(&model.MIec104DataPoint{}).
+		Where("device_uuid=?", deviceUuid).Count(&count).Error
+	if err1 != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err1))
+		return
+	}
+	var records []model.MIec104DataPoint
+	result := tx.Order("created_at DESC").Find(&records,
+		&model.MIec104DataPoint{DeviceUuid: deviceUuid})
+	if result.Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(result.Error))
+		return
+	}
+	Slot := intercache.GetSlot(deviceUuid)
+	recordsVo := []Iec104DataPointVo{}
+	for _, record := range records {
+		value, ok := Slot[record.UUID]
+		Vo := Iec104DataPointVo{
+			UUID:       record.UUID,
+			DeviceUUID: record.DeviceUuid,
+			Ioa:        record.Ioa,
+			TypeId:     record.TypeId,
+			Tag:        record.Tag,
+			Alias:      record.Alias,
+			ReportMode: reportModeOrDefault(record.ReportMode),
+			Deadband:   deadbandToFloat64(record.Deadband),
+			MaxSilence: record.MaxSilence,
+			ErrMsg:     value.ErrMsg,
+		}
+		if ok {
+			Vo.Status = func() int {
+				if value.Value == "" {
+					return 0
+				}
+				return 1
+			}() // 运行时
+			Vo.LastFetchTime = value.LastFetchTime // 运行时
+			Vo.Value = value.Value                 // 运行时
+		}
+		recordsVo = append(recordsVo, Vo)
+	}
+	Result := service.WrapPageResult(*pager, recordsVo, count)
+	c.JSON(common.HTTP_OK, common.OkWithData(Result))
+}
+
+/*
+*
+* 删除单行
+*
+ */
+func Iec104SheetDeleteByUUIDs(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		UUIDs      []string `json:"uuids"`
+		DeviceUUID string   `json:"device_uuid"`
+	}
+	form := Form{}
+	if Error := c.ShouldBindJSON(&form); Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(Error))
+		return
+	}
+	err := service.DeleteIec104DataPointByDevice(form.UUIDs, form.DeviceUUID)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	ruleEngine.RestartDevice(form.DeviceUUID)
+	c.JSON(common.HTTP_OK, common.Ok())
+
+}
+
+/*
+*
+* 删除所有
+*
+ */
+func Iec104SheetDeleteAll(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		DeviceUUID string `json:"device_uuid"`
+	}
+	form := Form{}
+	if Error := c.ShouldBindJSON(&form); Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(Error))
+		return
+	}
+	err := service.DeleteAllIec104DataPointByDevice(form.DeviceUUID)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	ruleEngine.RestartDevice(form.DeviceUUID)
+	c.JSON(common.HTTP_OK, common.Ok())
+
+}
+
+/*
+*
+* 检查点位合法性, 信息体地址只有3个字节
+*
+ */
+func checkIec104DataPoint(M Iec104DataPointVo) error {
+	if M.Ioa > 0xFFFFFF {
+		return fmt.Errorf("'Ioa must range of 0-16777215")
+	}
+	if err := iec104.CheckPointType(M.TypeId); err != nil {
+		return err
+	}
+	if M.Tag == "" {
+		return fmt.Errorf("'Missing required param 'tag'")
+	}
+	if len(M.Tag) > 256 {
+		return fmt.Errorf("'Tag length must range of 1-256")
+	}
+	if M.Alias == "" {
+		return fmt.Errorf("'Missing required param 'alias'")
+	}
+	if len(M.Alias) > 256 {
+		return fmt.Errorf("'Alias length must range of 1-256")
+	}
+	return checkReportPolicy(M.ReportMode, M.Deadband)
+}
+
+/*
+*
+* 更新信息体地址表
+*
+ */
+func Iec104SheetUpdate(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		DeviceUUID string              `json:"device_uuid"`
+		DataPoints []Iec104DataPointVo `json:"data_points"`
+	}
+	form := Form{}
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	for _, DataPoint := range form.DataPoints {
+		if err := checkIec104DataPoint(DataPoint); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		if DataPoint.UUID == "" ||
+			DataPoint.UUID == "new" ||
+			DataPoint.UUID == "copy" {
+			NewRow := model.MIec104DataPoint{
+				UUID:       utils.Iec104PointUUID(),
+				DeviceUuid: form.DeviceUUID,
+				Ioa:        DataPoint.Ioa,
+				TypeId:     DataPoint.TypeId,
+				Tag:        DataPoint.Tag,
+				Alias:      DataPoint.Alias,
+				ReportMode: reportModeOrDefault(DataPoint.ReportMode),
+				Deadband:   model.NewDecimal(*utils.HandleZeroValue(DataPoint.Deadband)),
+				MaxSilence: DataPoint.MaxSilence,
+			}
+			err0 := service.InsertIec104DataPoint(NewRow)
+			if err0 != nil {
+				c.JSON(common.HTTP_OK, common.Error400(err0))
+				return
+			}
+		} else {
+			OldRow := model.MIec104DataPoint{
+				UUID:       DataPoint.UUID,
+				DeviceUuid: DataPoint.DeviceUUID,
+				Ioa:        DataPoint.Ioa,
+				TypeId:     DataPoint.TypeId,
+				Tag:        DataPoint.Tag,
+				Alias:      DataPoint.Alias,
+				ReportMode: reportModeOrDefault(DataPoint.ReportMode),
+				Deadband:   model.NewDecimal(*utils.HandleZeroValue(DataPoint.Deadband)),
+				MaxSilence: DataPoint.MaxSilence,
+			}
+			err0 := service.UpdateIec104DataPoint(OldRow)
+			if err0 != nil {
+				c.JSON(common.HTTP_OK, common.Error400(err0))
+				return
+			}
+		}
+	}
+	ruleEngine.RestartDevice(form.DeviceUUID)
+	c.JSON(common.HTTP_OK, common.Ok())
+
+}
+
+// Iec104SheetImport 上传Excel文件
+func Iec104SheetImport(c *gin.Context, ruleEngine typex.Rhilex) {
+	// 解析 multipart/form-data 类型的请求体
+	err := c.Request.ParseMultipartForm(1024 * 1024 * 10)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+
+	// 获取上传的文件
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	defer file.Close()
+	deviceUuid := c.Request.Form.Get("device_uuid")
+	type DeviceDto struct {
+		UUID string
+		Name string
+		Type string
+	}
+	Device := DeviceDto{}
+	errDb := interdb.InterDb().Table("m_devices").
+		Where("uuid=?", deviceUuid).Find(&Device).Error
+	if errDb != nil {
+		c.JSON(common.HTTP_OK, common.Error400(errDb))
+		return
+	}
+	if Device.Type == "" {
+		c.JSON(common.HTTP_OK,
+			common.Error("Device Not Exists"))
+		return
+	}
+	if Device.Type != typex.IEC104_MASTER.String() {
+		c.JSON(common.HTTP_OK,
+			common.Error("Invalid Device Type, Only Support Import IEC104 Master"))
+		return
+	}
+	contentType := header.Header.Get("Content-Type")
+	if contentType != "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet" &&
+		contentType != "application/vnd.ms-excel" {
+		c.JSON(common.HTTP_OK, common.Error("File Must be Excel Sheet"))
+		return
+	}
+	// 判断文件大小是否符合要求（10MB）
+	if header.Size > 1024*1024*10 {
+		c.JSON(common.HTTP_OK, common.Error("Excel file size cannot be greater than 10MB"))
+		return
+	}
+	list, err := parseIec104DataPointExcel(file, "Sheet1", deviceUuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err = service.InsertIec104DataPoints(list); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	ruleEngine.RestartDevice(deviceUuid)
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+/*
+*
+* 解析表格
+*
+ */
+func parseIec104DataPointExcel(r io.Reader, sheetName string,
+	deviceUuid string) (list []model.MIec104DataPoint, err error) {
+	excelFile, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		excelFile.Close()
+	}()
+	// 读取表格
+	rows, err := excelFile.GetRows(sheetName)
+	if err != nil {
+		return nil, err
+	}
+	// 严格检查表结构 ioa,typeId,tag,alias
+	err1 := errors.New("'Invalid Sheet Header, must follow fixed format: 【ioa,typeId,tag,alias】")
+	if len(rows) < 1 || len(rows[0]) < 4 {
+		return nil, err1
+	}
+	if rows[0][0] != "ioa" ||
+		rows[0][1] != "typeId" ||
+		rows[0][2] != "tag" ||
+		rows[0][3] != "alias" {
+		return nil, err1
+	}
+
+	list = make([]model.MIec104DataPoint, 0)
+	for i := 1; i < len(rows); i++ {
+		row := rows[i]
+		if len(row) < 4 {
+			return nil, fmt.Errorf("invalid row %d, missing columns", i+1)
+		}
+		ReportMode, Deadband, MaxSilence, err := parseReportPolicyRow(rows[0], row, 4)
+		if err != nil {
+			return nil, err
+		}
+		Ioa, err := strconv.ParseUint(strings.TrimSpace(row[0]), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid row %d: invalid ioa '%s'", i+1, row[0])
+		}
+		TypeId, err := strconv.Atoi(strings.TrimSpace(row[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid row %d: invalid typeId '%s'", i+1, row[1])
+		}
+		Vo := Iec104DataPointVo{
+			Ioa:        uint32(Ioa),
+			TypeId:     TypeId,
+			Tag:        row[2],
+			Alias:      row[3],
+			ReportMode: ReportMode,
+			Deadband:   &Deadband,
+		}
+		if err := checkIec104DataPoint(Vo); err != nil {
+			return nil, fmt.Errorf("invalid row %d: %v", i+1, err)
+		}
+		list = append(list, model.MIec104DataPoint{
+			UUID:       utils.Iec104PointUUID(),
+			DeviceUuid: deviceUuid,
+			Ioa:        Vo.Ioa,
+			TypeId:     Vo.TypeId,
+			Tag:        Vo.Tag,
+			Alias:      Vo.Alias,
+			ReportMode: ReportMode,
+			Deadband:   model.NewDecimal(Deadband),
+			MaxSilence: &MaxSilence,
+		})
+	}
+	return list, nil
+}
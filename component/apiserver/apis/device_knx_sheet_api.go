@@ -0,0 +1,432 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/hootrhino/rhilex/glogger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/interdb"
+
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/apiserver/server"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/device/knx"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+	"github.com/xuri/excelize/v2"
+)
+
+func InitKnxRoute() {
+	// KNX 组地址表
+	Route := server.RouteGroup(server.ContextUrl("/knx_group_sheet"))
+	{
+		Route.POST(("/sheetImport"), server.AddRoute(KnxSheetImport))
+		Route.GET(("/sheetExport"), server.AddRoute(KnxSheetExport))
+		Route.GET(("/list"), server.AddRoute(KnxSheetPageList))
+		Route.POST(("/update"), server.AddRoute(KnxSheetUpdate))
+		Route.DELETE(("/delAll"), server.AddRoute(KnxSheetDeleteAll))
+		Route.DELETE(("/delIds"), server.AddRoute(KnxSheetDeleteByUUIDs))
+	}
+}
+
+type KnxGroupAddressVo struct {
+	UUID          string   `json:"uuid,omitempty"`
+	DeviceUUID    string   `json:"device_uuid"`
+	GroupAddress  string   `json:"groupAddress"`
+	Dpt           string   `json:"dpt"`
+	Tag           string   `json:"tag"`
+	Alias         string   `json:"alias"`
+	ReportMode    string   `json:"reportMode"`    // 上报模式
+	Deadband      *float64 `json:"deadband"`      // 死区
+	MaxSilence    *uint64  `json:"maxSilence"`    // 最长静默时间(秒)
+	ErrMsg        string   `json:"errMsg"`        // 运行时数据
+	Status        int      `json:"status"`        // 运行时数据
+	LastFetchTime uint64   `json:"lastFetchTime"` // 运行时数据
+	Value         any      `json:"value"`         // 运行时数据
+}
+
+// KnxSheetExport 导出组地址表
+func KnxSheetExport(c *gin.Context, ruleEngine typex.Rhilex) {
+	deviceUuid, _ := c.GetQuery("device_uuid")
+
+	var records []model.MKnxGroupAddress
+	result := interdb.InterDb().Table("m_knx_group_addresses").
+		Where("device_uuid=?", deviceUuid).Find(&records)
+	if result.Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(result.Error))
+		return
+	}
+	// groupAddress	dpt	tag	alias
+	Headers := []string{
+		"groupAddress", "dpt", "tag", "alias",
+	}
+	Headers = append(Headers, reportPolicyHeaders...)
+	xlsx := excelize.NewFile()
+	defer func() {
+		if err := xlsx.Close(); err != nil {
+			glogger.GLogger.Errorf("close excel file, err=%v", err)
+		}
+	}()
+	cell, _ := excelize.CoordinatesToCellName(1, 1)
+	xlsx.SetSheetRow("Sheet1", cell, &Headers)
+	for idx, record := range records {
+		Row := []string{
+			record.GroupAddress, record.Dpt, record.Tag, record.Alias,
+		}
+		Row = append(Row, reportPolicyRow(record.ReportMode, record.Deadband, record.MaxSilence)...)
+		cell, _ = excelize.CoordinatesToCellName(1, idx+2)
+		xlsx.SetSheetRow("Sheet1", cell, &Row)
+	}
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment;filename=%v.xlsx",
+		time.Now().UnixMilli()))
+	xlsx.WriteTo(c.Writer)
+}
+
+// KnxSheetPageList 分页获取
+func KnxSheetPageList(c *gin.Context, ruleEngine typex.Rhilex) {
+	pager, err := service.ReadPageRequest(c)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	deviceUuid, _ := c.GetQuery("device_uuid")
+	db := interdb.InterDb()
+	tx := db.Scopes(service.Paginate(*pager))
+	var count int64
+	err1 := interdb.InterDb().Model(&model.MKnxGroupAddress{}).
+		Where("device_uuid=?", deviceUuid).Count(&count).Error
+	if err1 != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err1))
+		return
+	}
+	var records []model.MKnxGroupAddress
+	result := tx.Order("created_at DESC").Find(&records,
+		&model.MKnxGroupAddress{DeviceUuid: deviceUuid})
+	if result.Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(result.Error))
+		return
+	}
+	Slot := intercache.GetSlot(deviceUuid)
+	recordsVo := []KnxGroupAddressVo{}
+	for _, record := range records {
+		value, ok := Slot[record.UUID]
+		Vo := KnxGroupAddressVo{
+			UUID:         record.UUID,
+			DeviceUUID:   record.DeviceUuid,
+			GroupAddress: record.GroupAddress,
+			Dpt:          record.Dpt,
+			Tag:          record.Tag,
+			Alias:        record.Alias,
+			ReportMode:   reportModeOrDefault(record.ReportMode),
+			Deadband:     deadbandToFloat64(record.Deadband),
+			MaxSilence:   record.MaxSilence,
+			ErrMsg:       value.ErrMsg,
+		}
+		if ok {
+			Vo.Status = func() int {
+				if value.Value == "" {
+					return 0
+				}
+				return 1
+			}() // 运行时
+			Vo.LastFetchTime = value.LastFetchTime // 运行时
+			Vo.Value = value.Value                 // 运行时
+		}
+		recordsVo = append(recordsVo, Vo)
+	}
+	Result := service.WrapPageResult(*pager, recordsVo, count)
+	c.JSON(common.HTTP_OK, common.OkWithData(Result))
+}
+
+/*
+*
+* 删除单行
+*
+ */
+func KnxSheetDeleteByUUIDs(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		UUIDs      []string `json:"uuids"`
+		DeviceUUID string   `json:"device_uuid"`
+	}
+	form := Form{}
+	if Error := c.ShouldBindJSON(&form); Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(Error))
+		return
+	}
+	err := service.DeleteKnxGroupAddressByDevice(form.UUIDs, form.DeviceUUID)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	ruleEngine.RestartDevice(form.DeviceUUID)
+	c.JSON(common.HTTP_OK, common.Ok())
+
+}
+
+/*
+*
+* 删除所有
+*
+ */
+func KnxSheetDeleteAll(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		DeviceUUID string `json:"device_uuid"`
+	}
+	form := Form{}
+	if Error := c.ShouldBindJSON(&form); Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(Error))
+		return
+	}
+	err := service.DeleteAllKnxGroupAddressByDevice(form.DeviceUUID)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	ruleEngine.RestartDevice(form.DeviceUUID)
+	c.JSON(common.HTTP_OK, common.Ok())
+
+}
+
+/*
+*
+* 检查组地址合法性
+*
+ */
+func checkKnxGroupAddress(M KnxGroupAddressVo) error {
+	if _, err := knx.ParseGroupAddress(M.GroupAddress); err != nil {
+		return err
+	}
+	if err := knx.CheckDpt(M.Dpt); err != nil {
+		return err
+	}
+	if M.Tag == "" {
+		return fmt.Errorf("'Missing required param 'tag'")
+	}
+	if len(M.Tag) > 256 {
+		return fmt.Errorf("'Tag length must range of 1-256")
+	}
+	if M.Alias == "" {
+		return fmt.Errorf("'Missing required param 'alias'")
+	}
+	if len(M.Alias) > 256 {
+		return fmt.Errorf("'Alias length must range of 1-256")
+	}
+	return checkReportPolicy(M.ReportMode, M.Deadband)
+}
+
+/*
+*
+* 更新组地址表
+*
+ */
+func KnxSheetUpdate(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		DeviceUUID     string              `json:"device_uuid"`
+		GroupAddresses []KnxGroupAddressVo `json:"data_points"`
+	}
+	form := Form{}
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	for _, GroupAddress := range form.GroupAddresses {
+		if err := checkKnxGroupAddress(GroupAddress); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		if GroupAddress.UUID == "" ||
+			GroupAddress.UUID == "new" ||
+			GroupAddress.UUID == "copy" {
+			NewRow := model.MKnxGroupAddress{
+				UUID:         utils.KnxGroupAddressUUID(),
+				DeviceUuid:   form.DeviceUUID,
+				GroupAddress: strings.TrimSpace(GroupAddress.GroupAddress),
+				Dpt:          GroupAddress.Dpt,
+				Tag:          GroupAddress.Tag,
+				Alias:        GroupAddress.Alias,
+				ReportMode:   reportModeOrDefault(GroupAddress.ReportMode),
+				Deadband:     model.NewDecimal(*utils.HandleZeroValue(GroupAddress.Deadband)),
+				MaxSilence:   GroupAddress.MaxSilence,
+			}
+			err0 := service.InsertKnxGroupAddress(NewRow)
+			if err0 != nil {
+				c.JSON(common.HTTP_OK, common.Error400(err0))
+				return
+			}
+		} else {
+			OldRow := model.MKnxGroupAddress{
+				UUID:         GroupAddress.UUID,
+				DeviceUuid:   GroupAddress.DeviceUUID,
+				GroupAddress: strings.TrimSpace(GroupAddress.GroupAddress),
+				Dpt:          GroupAddress.Dpt,
+				Tag:          GroupAddress.Tag,
+				Alias:        GroupAddress.Alias,
+				ReportMode:   reportModeOrDefault(GroupAddress.ReportMode),
+				Deadband:     model.NewDecimal(*utils.HandleZeroValue(GroupAddress.Deadband)),
+				MaxSilence:   GroupAddress.MaxSilence,
+			}
+			err0 := service.UpdateKnxGroupAddress(OldRow)
+			if err0 != nil {
+				c.JSON(common.HTTP_OK, common.Error400(err0))
+				return
+			}
+		}
+	}
+	ruleEngine.RestartDevice(form.DeviceUUID)
+	c.JSON(common.HTTP_OK, common.Ok())
+
+}
+
+// KnxSheetImport 上传Excel文件
+func KnxSheetImport(c *gin.Context, ruleEngine typex.Rhilex) {
+	// 解析 multipart/form-data 类型的请求体
+	err := c.Request.ParseMultipartForm(1024 * 1024 * 10)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+
+	// 获取上传的文件
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	defer file.Close()
+	deviceUuid := c.Request.Form.Get("device_uuid")
+	type DeviceDto struct {
+		UUID string
+		Name string
+		Type string
+	}
+	Device := DeviceDto{}
+	errDb := interdb.InterDb().Table("m_devices").
+		Where("uuid=?", deviceUuid).Find(&Device).Error
+	if errDb != nil {
+		c.JSON(common.HTTP_OK, common.Error400(errDb))
+		return
+	}
+	if Device.Type == "" {
+		c.JSON(common.HTTP_OK,
+			common.Error("Device Not Exists"))
+		return
+	}
+	if Device.Type != typex.KNX_GATEWAY.String() {
+		c.JSON(common.HTTP_OK,
+			common.Error("Invalid Device Type, Only Support Import KNX Gateway"))
+		return
+	}
+	contentType := header.Header.Get("Content-Type")
+	if contentType != "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet" &&
+		contentType != "application/vnd.ms-excel" {
+		c.JSON(common.HTTP_OK, common.Error("File Must be Excel Sheet"))
+		return
+	}
+	// 判断文件大小是否符合要求（10MB）
+	if header.Size > 1024*1024*10 {
+		c.JSON(common.HTTP_OK, common.Error("Excel file size cannot be greater than 10MB"))
+		return
+	}
+	list, err := parseKnxGroupAddressExcel(file, "Sheet1", deviceUuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err = service.InsertKnxGroupAddresses(list); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	ruleEngine.RestartDevice(deviceUuid)
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+/*
+*
+* 解析表格
+*
+ */
+func parseKnxGroupAddressExcel(r io.Reader, sheetName string,
+	deviceUuid string) (list []model.MKnxGroupAddress, err error) {
+	excelFile, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		excelFile.Close()
+	}()
+	// 读取表格
+	rows, err := excelFile.GetRows(sheetName)
+	if err != nil {
+		return nil, err
+	}
+	// 严格检查表结构 groupAddress,dpt,tag,alias
+	err1 := errors.New("'Invalid Sheet Header, must follow fixed format: 【groupAddress,dpt,tag,alias】")
+	if len(rows) < 1 || len(rows[0]) < 4 {
+		return nil, err1
+	}
+	if rows[0][0] != "groupAddress" ||
+		rows[0][1] != "dpt" ||
+		rows[0][2] != "tag" ||
+		rows[0][3] != "alias" {
+		return nil, err1
+	}
+
+	list = make([]model.MKnxGroupAddress, 0)
+	for i := 1; i < len(rows); i++ {
+		row := rows[i]
+		if len(row) < 4 {
+			return nil, fmt.Errorf("invalid row %d, missing columns", i+1)
+		}
+		ReportMode, Deadband, MaxSilence, err := parseReportPolicyRow(rows[0], row, 4)
+		if err != nil {
+			return nil, err
+		}
+		Vo := KnxGroupAddressVo{
+			GroupAddress: strings.TrimSpace(row[0]),
+			Dpt:          strings.TrimSpace(row[1]),
+			Tag:          row[2],
+			Alias:        row[3],
+			ReportMode:   ReportMode,
+			Deadband:     &Deadband,
+		}
+		if err := checkKnxGroupAddress(Vo); err != nil {
+			return nil, fmt.Errorf("invalid row %d: %v", i+1, err)
+		}
+		list = append(list, model.MKnxGroupAddress{
+			UUID:         utils.KnxGroupAddressUUID(),
+			DeviceUuid:   deviceUuid,
+			GroupAddress: Vo.GroupAddress,
+			Dpt:          Vo.Dpt,
+			Tag:          Vo.Tag,
+			Alias:        Vo.Alias,
+			ReportMode:   ReportMode,
+			Deadband:     model.NewDecimal(Deadband),
+			MaxSilence:   &MaxSilence,
+		})
+	}
+	return list, nil
+}
@@ -65,6 +65,9 @@ type ModbusMasterPointVo struct {
 	DataType      string   `json:"dataType"`      // 数据类型
 	DataOrder     string   `json:"dataOrder"`     // 字节序
 	Weight        *float64 `json:"weight"`        // 权重
+	ReportMode    string   `json:"reportMode"`    // 上报模式
+	Deadband      *float64 `json:"deadband"`      // 死区
+	MaxSilence    *uint64  `json:"maxSilence"`    // 最长静默时间(秒)
 	Status        int      `json:"status"`        // 运行时数据
 	LastFetchTime uint64   `json:"lastFetchTime"` // 运行时数据
 	Value         any      `json:"value"`         // 运行时数据
@@ -96,6 +99,7 @@ func ModbusMasterPointsExport(c *gin.Context, ruleEngine typex.Rhilex) {
 		"quality", "type",
 		"order", "weight",
 	}
+	Headers = append(Headers, reportPolicyHeaders...)
 
 	xlsx := excelize.NewFile()
 	defer func() {
@@ -118,6 +122,7 @@ func ModbusMasterPointsExport(c *gin.Context, ruleEngine typex.Rhilex) {
 			record.DataOrder,
 			fmt.Sprintf("%f", *record.Weight),
 		}
+		Row = append(Row, reportPolicyRow(record.ReportMode, record.Deadband, record.MaxSilence)...)
 		cell, _ = excelize.CoordinatesToCellName(1, idx+2)
 		xlsx.SetSheetRow("Sheet1", cell, &Row)
 	}
@@ -173,6 +178,9 @@ func ModbusMasterSheetPageList(c *gin.Context, ruleEngine typex.Rhilex) {
 			DataType:      record.DataType,
 			DataOrder:     record.DataOrder,
 			Weight:        record.Weight.ToFloat64(),
+			ReportMode:    reportModeOrDefault(record.ReportMode),
+			Deadband:      deadbandToFloat64(record.Deadband),
+			MaxSilence:    record.MaxSilence,
 			LastFetchTime: value.LastFetchTime, // 运行时
 			Value:         value.Value,         // 运行时
 			ErrMsg:        value.ErrMsg,        // 运行时
@@ -349,6 +357,11 @@ func CheckModbusMasterDataPoints(M ModbusMasterPointVo) error {
 		return fmt.Errorf("invalid tag name: %s", M.Tag)
 	}
 
+	// Check Report Policy
+	if err := checkReportPolicy(M.ReportMode, M.Deadband); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -390,6 +403,9 @@ func ModbusMasterSheetUpdate(c *gin.Context, ruleEngine typex.Rhilex) {
 				DataType:   ModbusMasterDataPoint.DataType,
 				DataOrder:  ModbusMasterDataPoint.DataOrder,
 				Weight:     model.NewDecimal(*utils.HandleZeroValue(ModbusMasterDataPoint.Weight)),
+				ReportMode: reportModeOrDefault(ModbusMasterDataPoint.ReportMode),
+				Deadband:   model.NewDecimal(*utils.HandleZeroValue(ModbusMasterDataPoint.Deadband)),
+				MaxSilence: ModbusMasterDataPoint.MaxSilence,
 			}
 			err0 := service.InsertModbusPointPosition(NewRow)
 			if err0 != nil {
@@ -410,6 +426,9 @@ func ModbusMasterSheetUpdate(c *gin.Context, ruleEngine typex.Rhilex) {
 				DataType:   ModbusMasterDataPoint.DataType,
 				DataOrder:  ModbusMasterDataPoint.DataOrder,
 				Weight:     model.NewDecimal(*utils.HandleZeroValue(ModbusMasterDataPoint.Weight)),
+				ReportMode: reportModeOrDefault(ModbusMasterDataPoint.ReportMode),
+				Deadband:   model.NewDecimal(*utils.HandleZeroValue(ModbusMasterDataPoint.Deadband)),
+				MaxSilence: ModbusMasterDataPoint.MaxSilence,
 			}
 			err0 := service.UpdateModbusPoint(OldRow)
 			if err0 != nil {
@@ -546,6 +565,10 @@ func parseModbusMasterPointExcel(r io.Reader, sheetName string,
 		Address := uint16(address)
 		Frequency := uint64(frequency)
 		Quantity := uint16(quantity)
+		ReportMode, Deadband, MaxSilence, err := parseReportPolicyRow(rows[0], row, 10)
+		if err != nil {
+			return nil, err
+		}
 
 		if err := CheckModbusMasterDataPoints(ModbusMasterPointVo{
 			Tag:       tag,
@@ -575,6 +598,9 @@ func parseModbusMasterPointExcel(r io.Reader, sheetName string,
 			DataType:   Type,
 			DataOrder:  utils.GetDefaultDataOrder(Type, Order),
 			Weight:     model.NewDecimal(Weight),
+			ReportMode: ReportMode,
+			Deadband:   model.NewDecimal(Deadband),
+			MaxSilence: &MaxSilence,
 		}
 		list = append(list, model)
 	}
@@ -0,0 +1,513 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hootrhino/rhilex/glogger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/interdb"
+
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/apiserver/server"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/device/opcua"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+	"github.com/xuri/excelize/v2"
+)
+
+func InitOpcuaRoute() {
+	// OPC UA 点位表
+	Route := server.RouteGroup(server.ContextUrl("/opcua_data_sheet"))
+	{
+		Route.POST(("/sheetImport"), server.AddRoute(OpcuaSheetImport))
+		Route.GET(("/sheetExport"), server.AddRoute(OpcuaSheetExport))
+		Route.GET(("/list"), server.AddRoute(OpcuaSheetPageList))
+		Route.POST(("/update"), server.AddRoute(OpcuaSheetUpdate))
+		Route.DELETE(("/delAll"), server.AddRoute(OpcuaSheetDeleteAll))
+		Route.DELETE(("/delIds"), server.AddRoute(OpcuaSheetDeleteByUUIDs))
+		Route.GET(("/endpoints"), server.AddRoute(OpcuaGetEndpoints))
+		Route.GET(("/browse"), server.AddRoute(OpcuaBrowse))
+	}
+}
+
+type OpcuaDataPointVo struct {
+	UUID             string   `json:"uuid,omitempty"`
+	DeviceUUID       string   `json:"device_uuid"`
+	NodeId           string   `json:"nodeId"`
+	DataType         string   `json:"dataType"`
+	Tag              string   `json:"tag"`
+	Alias            string   `json:"alias"`
+	SamplingInterval *uint64  `json:"samplingInterval"` // 采样周期(毫秒)
+	ReportMode       string   `json:"reportMode"`       // 上报模式
+	Deadband         *float64 `json:"deadband"`         // 死区
+	MaxSilence       *uint64  `json:"maxSilence"`       // 最长静默时间(秒)
+	ErrMsg           string   `json:"errMsg"`           // 运行时数据
+	Status           int      `json:"status"`           // 运行时数据
+	LastFetchTime    uint64   `json:"lastFetchTime"`    // 运行时数据
+	Value            any      `json:"value"`            // 运行时数据
+}
+
+// OpcuaSheetExport 导出点位表
+func OpcuaSheetExport(c *gin.Context, ruleEngine typex.Rhilex) {
+	deviceUuid, _ := c.GetQuery("device_uuid")
+
+	var records []model.MOpcuaDataPoint
+	result := interdb.InterDb().Table("m_opcua_data_points").
+		Where("device_uuid=?", deviceUuid).Find(&records)
+	if result.Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(result.Error))
+		return
+	}
+	// nodeId	dataType	tag	alias	samplingInterval
+	Headers := []string{
+		"nodeId", "dataType", "tag", "alias", "samplingInterval",
+	}
+	Headers = append(Headers, reportPolicyHeaders...)
+	xlsx := excelize.NewFile()
+	defer func() {
+		if err := xlsx.Close(); err != nil {
+			glogger.GLogger.Errorf("close excel file, err=%v", err)
+		}
+	}()
+	cell, _ := excelize.CoordinatesToCellName(1, 1)
+	xlsx.SetSheetRow("Sheet1", cell, &Headers)
+	for idx, record := range records {
+		SamplingInterval := uint64(0)
+		if record.SamplingInterval != nil {
+			SamplingInterval = *record.SamplingInterval
+		}
+		Row := []string{
+			record.NodeId, record.DataType, record.Tag, record.Alias,
+			fmt.Sprintf("%d", SamplingInterval),
+		}
+		Row = append(Row, reportPolicyRow(record.ReportMode, record.Deadband, record.MaxSilence)...)
+		cell, _ = excelize.CoordinatesToCellName(1, idx+2)
+		xlsx.SetSheetRow("Sheet1", cell, &Row)
+	}
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment;filename=%v.xlsx",
+		time.Now().UnixMilli()))
+	xlsx.WriteTo(c.Writer)
+}
+
+// OpcuaSheetPageList 分页获取
+func OpcuaSheetPageList(c *gin.Context, ruleEngine typex.Rhilex) {
+	pager, err := service.ReadPageRequest(c)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	deviceUuid, _ := c.GetQuery("device_uuid")
+	db := interdb.InterDb()
+	tx := db.Scopes(service.Paginate(*pager))
+	var count int64
+	err1 := interdb.InterDb().Model(&model.MOpcuaDataPoint{}).
+		Where("device_uuid=?", deviceUuid).Count(&count).Error
+	if err1 != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err1))
+		return
+	}
+	var records []model.MOpcuaDataPoint
+	result := tx.Order("created_at DESC").Find(&records,
+		&model.MOpcuaDataPoint{DeviceUuid: deviceUuid})
+	if result.Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(result.Error))
+		return
+	}
+	Slot := intercache.GetSlot(deviceUuid)
+	recordsVo := []OpcuaDataPointVo{}
+	for _, record := range records {
+		value, ok := Slot[record.UUID]
+		Vo := OpcuaDataPointVo{
+			UUID:             record.UUID,
+			DeviceUUID:       record.DeviceUuid,
+			NodeId:           record.NodeId,
+			DataType:         record.DataType,
+			Tag:              record.Tag,
+			Alias:            record.Alias,
+			SamplingInterval: record.SamplingInterval,
+			ReportMode:       reportModeOrDefault(record.ReportMode),
+			Deadband:         deadbandToFloat64(record.Deadband),
+			MaxSilence:       record.MaxSilence,
+			ErrMsg:           value.ErrMsg,
+		}
+		if ok {
+			Vo.Status = func() int {
+				if value.Value == "" {
+					return 0
+				}
+				return 1
+			}() // 运行时
+			Vo.LastFetchTime = value.LastFetchTime // 运行时
+			Vo.Value = value.Value                 // 运行时
+		}
+		recordsVo = append(recordsVo, Vo)
+	}
+	Result := service.WrapPageResult(*pager, recordsVo, count)
+	c.JSON(common.HTTP_OK, common.OkWithData(Result))
+}
+
+/*
+*
+* 删除单行
+*
+ */
+func OpcuaSheetDeleteByUUIDs(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		UUIDs      []string `json:"uuids"`
+		DeviceUUID string   `json:"device_uuid"`
+	}
+	form := Form{}
+	if Error := c.ShouldBindJSON(&form); Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(Error))
+		return
+	}
+	err := service.DeleteOpcuaDataPointByDevice(form.UUIDs, form.DeviceUUID)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	ruleEngine.RestartDevice(form.DeviceUUID)
+	c.JSON(common.HTTP_OK, common.Ok())
+
+}
+
+/*
+*
+* 删除所有
+*
+ */
+func OpcuaSheetDeleteAll(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		DeviceUUID string `json:"device_uuid"`
+	}
+	form := Form{}
+	if Error := c.ShouldBindJSON(&form); Error != nil {
+		c.JSON(common.HTTP_OK, common.Error400(Error))
+		return
+	}
+	err := service.DeleteAllOpcuaDataPointByDevice(form.DeviceUUID)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	ruleEngine.RestartDevice(form.DeviceUUID)
+	c.JSON(common.HTTP_OK, common.Ok())
+
+}
+
+/*
+*
+* 检查点位合法性
+*
+ */
+func checkOpcuaDataPoint(M OpcuaDataPointVo) error {
+	if _, err := opcua.ParseNodeID(M.NodeId); err != nil {
+		return err
+	}
+	if err := opcua.CheckDataType(M.DataType); err != nil {
+		return err
+	}
+	if M.Tag == "" {
+		return fmt.Errorf("'Missing required param 'tag'")
+	}
+	if len(M.Tag) > 256 {
+		return fmt.Errorf("'Tag length must range of 1-256")
+	}
+	if M.Alias == "" {
+		return fmt.Errorf("'Missing required param 'alias'")
+	}
+	if len(M.Alias) > 256 {
+		return fmt.Errorf("'Alias length must range of 1-256")
+	}
+	return checkReportPolicy(M.ReportMode, M.Deadband)
+}
+
+/*
+*
+* 更新点位表, 浏览出来的节点也是通过这里加到点位表
+*
+ */
+func OpcuaSheetUpdate(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		DeviceUUID string             `json:"device_uuid"`
+		DataPoints []OpcuaDataPointVo `json:"data_points"`
+	}
+	form := Form{}
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	for _, DataPoint := range form.DataPoints {
+		if err := checkOpcuaDataPoint(DataPoint); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		if DataPoint.UUID == "" ||
+			DataPoint.UUID == "new" ||
+			DataPoint.UUID == "copy" {
+			NewRow := model.MOpcuaDataPoint{
+				UUID:             utils.OpcuaPointUUID(),
+				DeviceUuid:       form.DeviceUUID,
+				NodeId:           strings.TrimSpace(DataPoint.NodeId),
+				DataType:         DataPoint.DataType,
+				Tag:              DataPoint.Tag,
+				Alias:            DataPoint.Alias,
+				SamplingInterval: DataPoint.SamplingInterval,
+				ReportMode:       reportModeOrDefault(DataPoint.ReportMode),
+				Deadband:         model.NewDecimal(*utils.HandleZeroValue(DataPoint.Deadband)),
+				MaxSilence:       DataPoint.MaxSilence,
+			}
+			err0 := service.InsertOpcuaDataPoint(NewRow)
+			if err0 != nil {
+				c.JSON(common.HTTP_OK, common.Error400(err0))
+				return
+			}
+		} else {
+			OldRow := model.MOpcuaDataPoint{
+				UUID:             DataPoint.UUID,
+				DeviceUuid:       DataPoint.DeviceUUID,
+				NodeId:           strings.TrimSpace(DataPoint.NodeId),
+				DataType:         DataPoint.DataType,
+				Tag:              DataPoint.Tag,
+				Alias:            DataPoint.Alias,
+				SamplingInterval: DataPoint.SamplingInterval,
+				ReportMode:       reportModeOrDefault(DataPoint.ReportMode),
+				Deadband:         model.NewDecimal(*utils.HandleZeroValue(DataPoint.Deadband)),
+				MaxSilence:       DataPoint.MaxSilence,
+			}
+			err0 := service.UpdateOpcuaDataPoint(OldRow)
+			if err0 != nil {
+				c.JSON(common.HTTP_OK, common.Error400(err0))
+				return
+			}
+		}
+	}
+	ruleEngine.RestartDevice(form.DeviceUUID)
+	c.JSON(common.HTTP_OK, common.Ok())
+
+}
+
+// OpcuaSheetImport 上传Excel文件
+func OpcuaSheetImport(c *gin.Context, ruleEngine typex.Rhilex) {
+	// 解析 multipart/form-data 类型的请求体
+	err := c.Request.ParseMultipartForm(1024 * 1024 * 10)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+
+	// 获取上传的文件
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	defer file.Close()
+	deviceUuid := c.Request.Form.Get("device_uuid")
+	type DeviceDto struct {
+		UUID string
+		Name string
+		Type string
+	}
+	Device := DeviceDto{}
+	errDb := interdb.InterDb().Table("m_devices").
+		Where("uuid=?", deviceUuid).Find(&Device).Error
+	if errDb != nil {
+		c.JSON(common.HTTP_OK, common.Error400(errDb))
+		return
+	}
+	if Device.Type == "" {
+		c.JSON(common.HTTP_OK,
+			common.Error("Device Not Exists"))
+		return
+	}
+	if Device.Type != typex.OPCUA_CLIENT.String() {
+		c.JSON(common.HTTP_OK,
+			common.Error("Invalid Device Type, Only Support Import OPC UA Client"))
+		return
+	}
+	contentType := header.Header.Get("Content-Type")
+	if contentType != "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet" &&
+		contentType != "application/vnd.ms-excel" {
+		c.JSON(common.HTTP_OK, common.Error("File Must be Excel Sheet"))
+		return
+	}
+	// 判断文件大小是否符合要求（10MB）
+	if header.Size > 1024*1024*10 {
+		c.JSON(common.HTTP_OK, common.Error("Excel file size cannot be greater than 10MB"))
+		return
+	}
+	list, err := parseOpcuaDataPointExcel(file, "Sheet1", deviceUuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err = service.InsertOpcuaDataPoints(list); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	ruleEngine.RestartDevice(deviceUuid)
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+/*
+*
+* 解析表格
+*
+ */
+func parseOpcuaDataPointExcel(r io.Reader, sheetName string,
+	deviceUuid string) (list []model.MOpcuaDataPoint, err error) {
+	excelFile, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		excelFile.Close()
+	}()
+	// 读取表格
+	rows, err := excelFile.GetRows(sheetName)
+	if err != nil {
+		return nil, err
+	}
+	// 严格检查表结构 nodeId,dataType,tag,alias,samplingInterval
+	err1 := errors.New("'Invalid Sheet Header, must follow fixed format: 【nodeId,dataType,tag,alias,samplingInterval】")
+	if len(rows) < 1 || len(rows[0]) < 5 {
+		return nil, err1
+	}
+	if rows[0][0] != "nodeId" ||
+		rows[0][1] != "dataType" ||
+		rows[0][2] != "tag" ||
+		rows[0][3] != "alias" ||
+		rows[0][4] != "samplingInterval" {
+		return nil, err1
+	}
+
+	list = make([]model.MOpcuaDataPoint, 0)
+	for i := 1; i < len(rows); i++ {
+		row := rows[i]
+		if len(row) < 4 {
+			return nil, fmt.Errorf("invalid row %d, missing columns", i+1)
+		}
+		SamplingInterval := uint64(0)
+		if len(row) > 4 && strings.TrimSpace(row[4]) != "" {
+			SamplingInterval, err = strconv.ParseUint(strings.TrimSpace(row[4]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid row %d, invalid samplingInterval: %s", i+1, row[4])
+			}
+		}
+		ReportMode, Deadband, MaxSilence, err := parseReportPolicyRow(rows[0], row, 5)
+		if err != nil {
+			return nil, err
+		}
+		Vo := OpcuaDataPointVo{
+			NodeId:     strings.TrimSpace(row[0]),
+			DataType:   strings.TrimSpace(row[1]),
+			Tag:        row[2],
+			Alias:      row[3],
+			ReportMode: ReportMode,
+			Deadband:   &Deadband,
+		}
+		if err := checkOpcuaDataPoint(Vo); err != nil {
+			return nil, fmt.Errorf("invalid row %d: %v", i+1, err)
+		}
+		list = append(list, model.MOpcuaDataPoint{
+			UUID:             utils.OpcuaPointUUID(),
+			DeviceUuid:       deviceUuid,
+			NodeId:           Vo.NodeId,
+			DataType:         Vo.DataType,
+			Tag:              Vo.Tag,
+			Alias:            Vo.Alias,
+			SamplingInterval: &SamplingInterval,
+			ReportMode:       ReportMode,
+			Deadband:         model.NewDecimal(Deadband),
+			MaxSilence:       &MaxSilence,
+		})
+	}
+	return list, nil
+}
+
+/*
+*
+* 端点发现, 新建设备之前用来选择安全策略和认证方式
+* GET /opcua_data_sheet/endpoints?endpoint=opc.tcp://127.0.0.1:4840
+*
+ */
+func OpcuaGetEndpoints(c *gin.Context, ruleEngine typex.Rhilex) {
+	endpoint, _ := c.GetQuery("endpoint")
+	if !strings.HasPrefix(endpoint, "opc.tcp://") {
+		c.JSON(common.HTTP_OK, common.Error("Invalid endpoint, must start with opc.tcp://"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	endpoints, err := opcua.GetEndpoints(ctx, endpoint)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(endpoints))
+}
+
+/*
+*
+* 浏览节点, 设备必须已经连上服务端
+* GET /opcua_data_sheet/browse?device_uuid=xxx&nodeId=ns=2;s=Machine
+*
+ */
+func OpcuaBrowse(c *gin.Context, ruleEngine typex.Rhilex) {
+	deviceUuid, _ := c.GetQuery("device_uuid")
+	nodeId, _ := c.GetQuery("nodeId")
+	Device := ruleEngine.GetDevice(deviceUuid)
+	if Device == nil || Device.Device == nil {
+		c.JSON(common.HTTP_OK, common.Error("Device Not Exists"))
+		return
+	}
+	if Device.Type != typex.OPCUA_CLIENT {
+		c.JSON(common.HTTP_OK, common.Error("Invalid Device Type, Only Support OPC UA Client"))
+		return
+	}
+	if Device.Device.Status() != typex.SOURCE_UP {
+		c.JSON(common.HTTP_OK, common.Error("Device Not Connected"))
+		return
+	}
+	args, _ := json.Marshal(map[string]string{"nodeId": nodeId})
+	result, err := Device.Device.OnCtrl([]byte("Browse"), args)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	nodes := []opcua.BrowseNode{}
+	if err := json.Unmarshal(result, &nodes); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(nodes))
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/deadband"
+)
+
+// 点位表的上报策略列, 追加在原有列的后面, 导入的时候可以没有
+var reportPolicyHeaders = []string{"reportMode", "deadband", "maxSilence"}
+
+/*
+*
+* 检查上报策略
+*
+ */
+func checkReportPolicy(reportMode string, deadbandValue *float64) error {
+	policy := deadband.ReportPolicy{ReportMode: reportMode}
+	if deadbandValue != nil {
+		policy.Deadband = *deadbandValue
+	}
+	return policy.Validate()
+}
+
+// 空的时候默认每次都上报
+func reportModeOrDefault(reportMode string) string {
+	if reportMode == "" {
+		return deadband.REPORT_ALWAYS
+	}
+	return reportMode
+}
+
+// 数据库的死区转成前端的值
+func deadbandToFloat64(d *model.Decimal) *float64 {
+	if d == nil {
+		return new(float64)
+	}
+	return d.ToFloat64()
+}
+
+/*
+*
+* 导出的时候上报策略的几列
+*
+ */
+func reportPolicyRow(reportMode string, deadbandValue *model.Decimal, maxSilence *uint64) []string {
+	silence := uint64(0)
+	if maxSilence != nil {
+		silence = *maxSilence
+	}
+	return []string{
+		reportModeOrDefault(reportMode),
+		fmt.Sprintf("%f", *deadbandToFloat64(deadbandValue)),
+		fmt.Sprintf("%d", silence),
+	}
+}
+
+/*
+*
+* 解析上报策略的几列, offset 为 reportMode 所在的列; 表头里没有这几列的话就用默认值
+*
+ */
+func parseReportPolicyRow(header, row []string, offset int) (string, float64, uint64, error) {
+	cell := func(i int) string {
+		if len(header) <= offset+i || header[offset+i] != reportPolicyHeaders[i] {
+			return ""
+		}
+		if len(row) <= offset+i {
+			return ""
+		}
+		return strings.TrimSpace(row[offset+i])
+	}
+	reportMode := reportModeOrDefault(strings.ToUpper(cell(0)))
+	deadbandValue := float64(0)
+	if s := cell(1); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("invalid deadband '%s'", s)
+		}
+		deadbandValue = v
+	}
+	maxSilence := uint64(0)
+	if s := cell(2); s != "" {
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("invalid maxSilence '%s'", s)
+		}
+		maxSilence = v
+	}
+	if err := checkReportPolicy(reportMode, &deadbandValue); err != nil {
+		return "", 0, 0, err
+	}
+	return reportMode, deadbandValue, maxSilence, nil
+}
@@ -16,9 +16,12 @@
 package apis
 
 import (
+	"bytes"
+	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -33,6 +36,7 @@ import (
 
 	"github.com/hootrhino/rhilex/component/apiserver/model"
 	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/device/s7"
 	"github.com/hootrhino/rhilex/typex"
 	"github.com/hootrhino/rhilex/utils"
 	"github.com/jinzhu/copier"
@@ -43,6 +47,7 @@ func InitSiemensS7Route() {
 	SIEMENS_PLC := server.RouteGroup(server.ContextUrl("/s1200_data_sheet"))
 	{
 		SIEMENS_PLC.POST(("/sheetImport"), server.AddRoute(SiemensSheetImport))
+		SIEMENS_PLC.POST(("/tiaImport"), server.AddRoute(SiemensTiaImport))
 		SIEMENS_PLC.GET(("/sheetExport"), server.AddRoute(SiemensPointsExport))
 		SIEMENS_PLC.GET(("/list"), server.AddRoute(SiemensSheetPageList))
 		SIEMENS_PLC.POST(("/update"), server.AddRoute(SiemensSheetUpdate))
@@ -61,6 +66,9 @@ type SiemensPointVo struct {
 	DataType       string   `json:"dataType"`
 	Frequency      *uint64  `json:"frequency"`
 	Weight         *float64 `json:"weight"`        // 权重
+	ReportMode     string   `json:"reportMode"`    // 上报模式
+	Deadband       *float64 `json:"deadband"`      // 死区
+	MaxSilence     *uint64  `json:"maxSilence"`    // 最长静默时间(秒)
 	Status         int      `json:"status"`        // 运行时数据
 	LastFetchTime  uint64   `json:"lastFetchTime"` // 运行时数据
 	Value          any      `json:"value"`         // 运行时数据
@@ -88,6 +96,7 @@ func SiemensPointsExport(c *gin.Context, ruleEngine typex.Rhilex) {
 	Headers := []string{
 		"address", "tag", "alias", "type", "order", "weight", "frequency",
 	}
+	Headers = append(Headers, reportPolicyHeaders...)
 	xlsx := excelize.NewFile()
 	defer func() {
 		if err := xlsx.Close(); err != nil {
@@ -108,6 +117,7 @@ func SiemensPointsExport(c *gin.Context, ruleEngine typex.Rhilex) {
 				fmt.Sprintf("%f", *record.Weight),
 				fmt.Sprintf("%d", *record.Frequency),
 			}
+			Row = append(Row, reportPolicyRow(record.ReportMode, record.Deadband, record.MaxSilence)...)
 			cell, _ = excelize.CoordinatesToCellName(1, idx+2)
 			xlsx.SetSheetRow("Sheet1", cell, &Row)
 		}
@@ -161,6 +171,9 @@ func SiemensSheetPageList(c *gin.Context, ruleEngine typex.Rhilex) {
 			DataType:       record.DataBlockType,
 			DataOrder:      record.DataBlockOrder,
 			Weight:         record.Weight.ToFloat64(),
+			ReportMode:     reportModeOrDefault(record.ReportMode),
+			Deadband:       deadbandToFloat64(record.Deadband),
+			MaxSilence:     record.MaxSilence,
 			LastFetchTime:  value.LastFetchTime, // 运行时
 			Value:          value.Value,         // 运行时
 			ErrMsg:         value.ErrMsg,
@@ -271,7 +284,9 @@ func CheckSiemensDataPoints(M SiemensPointVo) error {
 	dataOrderMap := map[string][]string{
 		"I":        {"A"},
 		"Q":        {"A"},
+		"BOOL":     {"A"},
 		"BYTE":     {"A"},
+		"STRING":   {"A"},
 		"INT16":    {"AB", "BA"},
 		"UINT16":   {"AB", "BA"},
 		"RAW":      {"ABCD", "DCBA", "CDAB"},
@@ -302,6 +317,11 @@ func CheckSiemensDataPoints(M SiemensPointVo) error {
 		return fmt.Errorf("invalid Tag Name: %s", M.Tag)
 	}
 
+	// Check Report Policy
+	if err := checkReportPolicy(M.ReportMode, M.Deadband); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -337,6 +357,8 @@ func SiemensSheetUpdate(c *gin.Context, ruleEngine typex.Rhilex) {
 			NewRow.DataBlockType = SiemensDataPoint.DataType
 			NewRow.DataBlockOrder = SiemensDataPoint.DataOrder
 			NewRow.Weight = model.NewDecimal(*SiemensDataPoint.Weight)
+			NewRow.ReportMode = reportModeOrDefault(SiemensDataPoint.ReportMode)
+			NewRow.Deadband = model.NewDecimal(*utils.HandleZeroValue(SiemensDataPoint.Deadband))
 			err0 := service.InsertSiemensPointPosition(NewRow)
 			if err0 != nil {
 				c.JSON(common.HTTP_OK, common.Error400(err0))
@@ -350,6 +372,8 @@ func SiemensSheetUpdate(c *gin.Context, ruleEngine typex.Rhilex) {
 			OldRow.DataBlockType = SiemensDataPoint.DataType
 			OldRow.DataBlockOrder = SiemensDataPoint.DataOrder
 			OldRow.Weight = model.NewDecimal(*utils.HandleZeroValue(SiemensDataPoint.Weight))
+			OldRow.ReportMode = reportModeOrDefault(SiemensDataPoint.ReportMode)
+			OldRow.Deadband = model.NewDecimal(*utils.HandleZeroValue(SiemensDataPoint.Deadband))
 
 			err0 := service.UpdateSiemensPoint(OldRow)
 			if err0 != nil {
@@ -480,6 +504,10 @@ func parseSiemensPointExcel(
 		if errParse1 != nil {
 			return nil, errParse1
 		}
+		ReportMode, Deadband, MaxSilence, errParse2 := parseReportPolicyRow(rows[0], row, 7)
+		if errParse2 != nil {
+			return nil, errParse2
+		}
 		model := model.MSiemensDataPoint{
 			UUID:           utils.SiemensPointUUID(),
 			DeviceUuid:     deviceUuid,
@@ -490,8 +518,144 @@ func parseSiemensPointExcel(
 			DataBlockOrder: utils.GetDefaultDataOrder(Type, Order),
 			Frequency:      &Frequency,
 			Weight:         model.NewDecimal(limitedWeight),
+			ReportMode:     ReportMode,
+			Deadband:       model.NewDecimal(Deadband),
+			MaxSilence:     &MaxSilence,
 		}
 		list = append(list, model)
 	}
 	return list, nil
 }
+
+/*
+*
+* 导入 TIA Portal 导出的 PLC 变量表(CSV 或者 XLSX), 不支持的类型和已经存在的 Tag 跳过,
+* 跳过的原因返回给前端
+*
+ */
+func SiemensTiaImport(c *gin.Context, ruleEngine typex.Rhilex) {
+	err := c.Request.ParseMultipartForm(1024 * 1024 * 10)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	defer file.Close()
+	deviceUuid := c.Request.Form.Get("device_uuid")
+	type DeviceDto struct {
+		UUID string
+		Name string
+		Type string
+	}
+	Device := DeviceDto{}
+	errDb := interdb.InterDb().Table("m_devices").
+		Where("uuid=?", deviceUuid).Find(&Device).Error
+	if errDb != nil {
+		c.JSON(common.HTTP_OK, common.Error400(errDb))
+		return
+	}
+	if Device.Type == "" {
+		c.JSON(common.HTTP_OK,
+			common.Error("Device Not Exists"))
+		return
+	}
+	if Device.Type != typex.SIEMENS_PLC.String() {
+		c.JSON(common.HTTP_OK,
+			common.Error("Invalid Device Type, Only Support Import Siemens Device"))
+		return
+	}
+	if header.Size > 1024*1024*10 {
+		c.JSON(common.HTTP_OK, common.Error("File size cannot be greater than 10MB"))
+		return
+	}
+	rows, err := readTiaTagTable(file, header.Filename)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	tags, skipped, err := s7.ParseTIATagTable(rows)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	existsTags := []string{}
+	interdb.InterDb().Model(&model.MSiemensDataPoint{}).
+		Where("device_uuid=?", deviceUuid).Pluck("tag", &existsTags)
+	exists := map[string]bool{}
+	for _, tag := range existsTags {
+		exists[tag] = true
+	}
+	list := []model.MSiemensDataPoint{}
+	for _, tag := range tags {
+		if exists[tag.Name] {
+			skipped = append(skipped, fmt.Sprintf("'%s': tag already exists", tag.Name))
+			continue
+		}
+		exists[tag.Name] = true
+		Frequency := uint64(1000)
+		MaxSilence := uint64(0)
+		list = append(list, model.MSiemensDataPoint{
+			UUID:           utils.SiemensPointUUID(),
+			DeviceUuid:     deviceUuid,
+			SiemensAddress: tag.Address,
+			Tag:            tag.Name,
+			Alias:          tag.Comment,
+			DataBlockType:  tag.DataType,
+			DataBlockOrder: tag.DataOrder,
+			Frequency:      &Frequency,
+			Weight:         model.NewDecimal(1),
+			ReportMode:     reportModeOrDefault(""),
+			Deadband:       model.NewDecimal(0),
+			MaxSilence:     &MaxSilence,
+		})
+	}
+	if len(list) > 0 {
+		if err = service.InsertSiemensPointPositions(list); err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		ruleEngine.RestartDevice(deviceUuid)
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(map[string]any{
+		"imported": len(list),
+		"skipped":  skipped,
+	}))
+}
+
+// 按照扩展名读 CSV 或者 XLSX; XLSX 优先读 "PLC Tags" 工作表, 没有的时候读第一个
+func readTiaTagTable(r io.Reader, filename string) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		reader := csv.NewReader(bytes.NewReader(data))
+		// 德语和中文环境下 TIA 导出的 CSV 是分号分隔的
+		firstLine, _, _ := strings.Cut(string(data), "\n")
+		if strings.Count(firstLine, ";") > strings.Count(firstLine, ",") {
+			reader.Comma = ';'
+		}
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+		return reader.ReadAll()
+	case ".xlsx":
+		excelFile, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer excelFile.Close()
+		sheetName := excelFile.GetSheetName(0)
+		for _, name := range excelFile.GetSheetList() {
+			if name == "PLC Tags" {
+				sheetName = name
+			}
+		}
+		return excelFile.GetRows(sheetName)
+	}
+	return nil, fmt.Errorf("file must be csv or xlsx: %s", filename)
+}
@@ -51,16 +51,19 @@ func InitSnmpRoute() {
 }
 
 type SnmpOidVo struct {
-	UUID          string  `json:"uuid,omitempty"`
-	DeviceUUID    string  `json:"device_uuid"`
-	Oid           string  `json:"oid"`
-	Tag           string  `json:"tag"`
-	Alias         string  `json:"alias"`
-	Frequency     *uint64 `json:"frequency"`
-	ErrMsg        string  `json:"errMsg"`        // 运行时数据
-	Status        int     `json:"status"`        // 运行时数据
-	LastFetchTime uint64  `json:"lastFetchTime"` // 运行时数据
-	Value         any     `json:"value"`         // 运行时数据
+	UUID          string   `json:"uuid,omitempty"`
+	DeviceUUID    string   `json:"device_uuid"`
+	Oid           string   `json:"oid"`
+	Tag           string   `json:"tag"`
+	Alias         string   `json:"alias"`
+	Frequency     *uint64  `json:"frequency"`
+	ReportMode    string   `json:"reportMode"`    // 上报模式
+	Deadband      *float64 `json:"deadband"`      // 死区
+	MaxSilence    *uint64  `json:"maxSilence"`    // 最长静默时间(秒)
+	ErrMsg        string   `json:"errMsg"`        // 运行时数据
+	Status        int      `json:"status"`        // 运行时数据
+	LastFetchTime uint64   `json:"lastFetchTime"` // 运行时数据
+	Value         any      `json:"value"`         // 运行时数据
 }
 
 /*
@@ -84,6 +87,7 @@ func SnmpOidsExport(c *gin.Context, ruleEngine typex.Rhilex) {
 	Headers := []string{
 		"oid", "tag", "alias", "frequency",
 	}
+	Headers = append(Headers, reportPolicyHeaders...)
 	xlsx := excelize.NewFile()
 	defer func() {
 		if err := xlsx.Close(); err != nil {
@@ -97,6 +101,7 @@ func SnmpOidsExport(c *gin.Context, ruleEngine typex.Rhilex) {
 			Row := []string{
 				record.Oid, record.Tag, record.Alias, fmt.Sprintf("%d", record.Frequency),
 			}
+			Row = append(Row, reportPolicyRow(record.ReportMode, record.Deadband, record.MaxSilence)...)
 			cell, _ = excelize.CoordinatesToCellName(1, idx+2)
 			xlsx.SetSheetRow("Sheet1", cell, &Row)
 		}
@@ -146,6 +151,9 @@ func SnmpSheetPageList(c *gin.Context, ruleEngine typex.Rhilex) {
 			Tag:        record.Tag,
 			Alias:      record.Alias,
 			Frequency:  record.Frequency,
+			ReportMode: reportModeOrDefault(record.ReportMode),
+			Deadband:   deadbandToFloat64(record.Deadband),
+			MaxSilence: record.MaxSilence,
 			ErrMsg:     value.ErrMsg,
 		}
 		if ok {
@@ -234,7 +242,7 @@ func checkSnmpOids(M SnmpOidVo) error {
 	if len(M.Alias) > 256 {
 		return fmt.Errorf("'Alias length must range of 1-256")
 	}
-	return nil
+	return checkReportPolicy(M.ReportMode, M.Deadband)
 }
 
 /*
@@ -269,6 +277,9 @@ func SnmpSheetUpdate(c *gin.Context, ruleEngine typex.Rhilex) {
 				DeviceUuid: form.DeviceUUID,
 				Oid:        SnmpDataPoint.Oid,
 				Frequency:  SnmpDataPoint.Frequency,
+				ReportMode: reportModeOrDefault(SnmpDataPoint.ReportMode),
+				Deadband:   model.NewDecimal(*utils.HandleZeroValue(SnmpDataPoint.Deadband)),
+				MaxSilence: SnmpDataPoint.MaxSilence,
 			}
 			err0 := service.InsertSnmpOid(NewRow)
 			if err0 != nil {
@@ -283,6 +294,9 @@ func SnmpSheetUpdate(c *gin.Context, ruleEngine typex.Rhilex) {
 				DeviceUuid: SnmpDataPoint.DeviceUUID,
 				Oid:        SnmpDataPoint.Oid,
 				Frequency:  SnmpDataPoint.Frequency,
+				ReportMode: reportModeOrDefault(SnmpDataPoint.ReportMode),
+				Deadband:   model.NewDecimal(*utils.HandleZeroValue(SnmpDataPoint.Deadband)),
+				MaxSilence: SnmpDataPoint.MaxSilence,
 			}
 			err0 := service.UpdateSnmpOid(OldRow)
 			if err0 != nil {
@@ -402,6 +416,10 @@ func parseSnmpOidExcel(r io.Reader, sheetName string,
 		tag := row[1]
 		alias := row[2]
 		frequency, _ := strconv.ParseUint(row[3], 10, 64)
+		ReportMode, Deadband, MaxSilence, err := parseReportPolicyRow(rows[0], row, 4)
+		if err != nil {
+			return nil, err
+		}
 		if err := checkSnmpOids(SnmpOidVo{
 			Oid:       oid,
 			Tag:       tag,
@@ -418,6 +436,9 @@ func parseSnmpOidExcel(r io.Reader, sheetName string,
 			Tag:        tag,
 			Alias:      alias,
 			Frequency:  &frequency,
+			ReportMode: ReportMode,
+			Deadband:   model.NewDecimal(Deadband),
+			MaxSilence: &MaxSilence,
 		}
 		list = append(list, model)
 	}
@@ -0,0 +1,271 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/apiserver/server"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/interflow"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+func InitFlowRoute() {
+	flowApi := server.RouteGroup(server.ContextUrl("/flows"))
+	{
+		flowApi.POST(("/create"), server.AddRoute(CreateFlow))
+		flowApi.PUT(("/update"), server.AddRoute(UpdateFlow))
+		flowApi.DELETE(("/del"), server.AddRoute(DeleteFlow))
+		flowApi.GET(("/list"), server.AddRoute(Flows))
+		flowApi.GET(("/detail"), server.AddRoute(FlowDetail))
+		flowApi.POST(("/validate"), server.AddRoute(ValidateFlow))
+		flowApi.GET(("/exportLua"), server.AddRoute(ExportFlowLua))
+	}
+}
+
+type flowVo struct {
+	UUID        string                `json:"uuid"`
+	Name        string                `json:"name"`
+	Description string                `json:"description"`
+	Nodes       []interflow.FlowNode  `json:"nodes"`
+	Edges       []interflow.FlowEdge  `json:"edges"`
+	Status      int                   `json:"status"` // 1: 运行中, 0: 加载失败
+	Stat        *interflow.FlowStatus `json:"stat"`
+}
+
+func newFlowVo(mFlow *model.MFlow) flowVo {
+	flow := server.GetFlow(mFlow)
+	vo := flowVo{
+		UUID:        flow.UUID,
+		Name:        flow.Name,
+		Description: flow.Description,
+		Nodes:       flow.Nodes,
+		Edges:       flow.Edges,
+	}
+	if stat, ok := interflow.GetFlowStatus(flow.UUID); ok {
+		vo.Status = 1
+		vo.Stat = &stat
+	}
+	return vo
+}
+
+type flowForm struct {
+	Name        string               `json:"name" binding:"required"`
+	Description string               `json:"description"`
+	Nodes       []interflow.FlowNode `json:"nodes" binding:"required"`
+	Edges       []interflow.FlowEdge `json:"edges"`
+}
+
+func (form flowForm) flow(uuid string) interflow.Flow {
+	edges := form.Edges
+	if edges == nil {
+		edges = []interflow.FlowEdge{}
+	}
+	return interflow.Flow{
+		UUID:        uuid,
+		Name:        form.Name,
+		Description: form.Description,
+		Nodes:       form.Nodes,
+		Edges:       edges,
+	}
+}
+
+/*
+*
+* 校验流程的结构, 以及节点引用的资源、输出和告警规则是否存在
+*
+ */
+func checkFlow(flow interflow.Flow) error {
+	if ok, r := utils.IsValidNameLength(flow.Name); !ok {
+		return fmt.Errorf("%s", r)
+	}
+	if err := flow.Validate(); err != nil {
+		return err
+	}
+	for _, node := range flow.NodesOf(interflow.NODE_INPUT) {
+		if node.Kind == "INEND" {
+			if in, _ := service.GetMInEndWithUUID(node.Resource); in == nil {
+				return fmt.Errorf(`node %s: inend not exists: %s`, node.ID, node.Resource)
+			}
+		} else if dev, _ := service.GetMDeviceWithUUID(node.Resource); dev == nil {
+			return fmt.Errorf(`node %s: device not exists: %s`, node.ID, node.Resource)
+		}
+	}
+	for _, node := range flow.NodesOf(interflow.NODE_SINK) {
+		if _, err := service.GetMOutEndWithUUID(node.Resource); err != nil {
+			return fmt.Errorf(`node %s: outend not exists: %s`, node.ID, node.Resource)
+		}
+	}
+	for _, node := range flow.NodesOf(interflow.NODE_ALARM) {
+		if _, err := service.GetMAlarmRuleWithUUID(node.AlarmRuleId); err != nil {
+			return fmt.Errorf(`node %s: alarm rule not exists: %s`, node.ID, node.AlarmRuleId)
+		}
+	}
+	return nil
+}
+
+func encodeFlow(flow interflow.Flow) *model.MFlow {
+	nodes, _ := json.Marshal(flow.Nodes)
+	edges, _ := json.Marshal(flow.Edges)
+	return &model.MFlow{
+		UUID:        flow.UUID,
+		Name:        flow.Name,
+		Description: flow.Description,
+		Nodes:       string(nodes),
+		Edges:       string(edges),
+	}
+}
+
+func CreateFlow(c *gin.Context, ruleEngine typex.Rhilex) {
+	form := flowForm{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	flow := form.flow(utils.FlowUuid())
+	if err := checkFlow(flow); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := service.InsertMFlow(encodeFlow(flow)); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := interflow.LoadFlow(flow); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(flow.UUID))
+}
+
+func UpdateFlow(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		flowForm
+		UUID string `json:"uuid" binding:"required"`
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if _, err := service.GetMFlowWithUUID(form.UUID); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	flow := form.flow(form.UUID)
+	if err := checkFlow(flow); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := service.UpdateMFlow(flow.UUID, encodeFlow(flow)); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	// 直接替换运行中的流程, 窗口的状态保留
+	if err := interflow.LoadFlow(flow); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+func DeleteFlow(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	if _, err := service.GetMFlowWithUUID(uuid); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := service.DeleteMFlow(uuid); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	interflow.RemoveFlow(uuid)
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+func Flows(c *gin.Context, ruleEngine typex.Rhilex) {
+	DataList := []flowVo{}
+	for _, mFlow := range service.AllMFlows() {
+		DataList = append(DataList, newFlowVo(&mFlow))
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(DataList))
+}
+
+func FlowDetail(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	mFlow, err := service.GetMFlowWithUUID(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400EmptyObj(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(newFlowVo(mFlow)))
+}
+
+// 保存之前校验, 编辑器里面用
+func ValidateFlow(c *gin.Context, ruleEngine typex.Rhilex) {
+	form := flowForm{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := checkFlow(form.flow("")); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+/*
+*
+* 导出成等价的 Lua 规则, 返回的内容可以直接用来创建规则
+*
+ */
+func ExportFlowLua(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	mFlow, err := service.GetMFlowWithUUID(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	flow := server.GetFlow(mFlow)
+	actions, err := flow.ToLua()
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	fromSource, fromDevice := []string{}, []string{}
+	for _, node := range flow.NodesOf(interflow.NODE_INPUT) {
+		if node.Kind == "INEND" {
+			fromSource = append(fromSource, node.Resource)
+		} else {
+			fromDevice = append(fromDevice, node.Resource)
+		}
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(map[string]any{
+		"name":        flow.Name,
+		"type":        "lua",
+		"description": flow.Description,
+		"fromSource":  uniqueStrings(fromSource),
+		"fromDevice":  uniqueStrings(fromDevice),
+		"actions":     actions,
+	}))
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/hootrhino/rhilex/component/apiserver/server"
 	"github.com/hootrhino/rhilex/component/apiserver/service"
 	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/intermetric"
+	"github.com/hootrhino/rhilex/component/supervisor"
 	"github.com/hootrhino/rhilex/typex"
 	"github.com/hootrhino/rhilex/utils"
 )
@@ -46,11 +48,19 @@ func InEndDetail(c *gin.Context, ruleEngine typex.Rhilex) {
 			Config:      Model.GetConfig(),
 			State:       typex.SOURCE_STOP,
 		}
-		c.JSON(common.HTTP_OK, common.OkWithData(tmpInEnd))
+		c.JSON(common.HTTP_OK, common.OkWithData(InEndDetailVo{
+			InEnd:   tmpInEnd,
+			Restart: supervisor.GetRestartStatus(Model.UUID),
+		}))
 		return
 	}
 	inEnd.State = inEnd.Source.Status()
-	c.JSON(common.HTTP_OK, common.OkWithData(inEnd))
+	Vo := InEndDetailVo{
+		InEnd:   *inEnd,
+		Restart: supervisor.GetRestartStatus(inEnd.UUID),
+	}
+	Vo.State = supervisor.RestartState(inEnd.UUID, Vo.State)
+	c.JSON(common.HTTP_OK, common.OkWithData(Vo))
 }
 
 // Get all inends
@@ -73,7 +83,9 @@ func InEnds(c *gin.Context, ruleEngine typex.Rhilex) {
 		}
 		if inEnd != nil {
 			inEnd.State = inEnd.Source.Status()
-			inEnds = append(inEnds, *inEnd)
+			tmpInEnd := *inEnd
+			tmpInEnd.State = supervisor.RestartState(inEnd.UUID, tmpInEnd.State)
+			inEnds = append(inEnds, tmpInEnd)
 		}
 	}
 	c.JSON(common.HTTP_OK, common.OkWithData(inEnds))
@@ -107,6 +119,10 @@ func CreateInend(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
+	if err := checkRestartPolicy(form.Config); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
 	isSingle := false
 	// 内部消息总线是单例模式
 	if form.Type == typex.INTERNAL_EVENT.String() {
@@ -143,7 +159,7 @@ func CreateInend(c *gin.Context, ruleEngine typex.Rhilex) {
 }
 func RestartInEnd(c *gin.Context, ruleEngine typex.Rhilex) {
 	uuid, _ := c.GetQuery("uuid")
-	err := ruleEngine.RestartInEnd(uuid)
+	err := restartResource(uuid, ruleEngine, ruleEngine.RestartInEnd, server.LoadNewestInEnd)
 	if err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
@@ -180,6 +196,10 @@ func UpdateInend(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error(r))
 		return
 	}
+	if err := checkRestartPolicy(form.Config); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
 	// 更新的时候从数据库往外面拿
 	InEnd, err := service.GetMInEndWithUUID(form.UUID)
 	if err != nil {
@@ -197,6 +217,7 @@ func UpdateInend(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
+	supervisor.ResetRestart(form.UUID)
 	if err := server.LoadNewestInEnd(form.UUID, ruleEngine); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
@@ -221,6 +242,8 @@ func DeleteInEnd(c *gin.Context, ruleEngine typex.Rhilex) {
 			old.Source.Details().State = typex.SOURCE_STOP
 		}
 		ruleEngine.RemoveInEnd(uuid)
+		intermetric.Remove(uuid)
+		supervisor.RemoveRestart(uuid)
 		c.JSON(common.HTTP_OK, common.Ok())
 	}
 }
@@ -6,7 +6,9 @@ import (
 	"github.com/hootrhino/rhilex/component/apiserver/server"
 	"github.com/hootrhino/rhilex/component/apiserver/service"
 	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/intermetric"
 	"github.com/hootrhino/rhilex/component/lostcache"
+	"github.com/hootrhino/rhilex/component/supervisor"
 	"github.com/hootrhino/rhilex/typex"
 	"github.com/hootrhino/rhilex/utils"
 
@@ -25,6 +27,11 @@ func InitOutEndRoute() {
 		OutEndApi.PUT(("/update"), server.AddRoute(UpdateOutEnd))
 		OutEndApi.PUT("/restart", server.AddRoute(RestartOutEnd))
 		OutEndApi.GET("/outendErrMsg", server.AddRoute(GetOutendErrorMsg))
+		OutEndApi.GET("/outbox", server.AddRoute(OutEndOutbox))
+		OutEndApi.DELETE("/outbox", server.AddRoute(ClearOutEndOutbox))
+		OutEndApi.GET("/outbox/deadLetters", server.AddRoute(OutEndDeadLetters))
+		OutEndApi.PUT("/outbox/deadLetters/requeue", server.AddRoute(RequeueOutEndDeadLetters))
+		OutEndApi.DELETE("/outbox/deadLetters", server.AddRoute(ClearOutEndDeadLetters))
 	}
 
 }
@@ -46,7 +53,9 @@ func OutEnds(c *gin.Context, ruleEngine typex.Rhilex) {
 			}
 			if outEnd != nil {
 				outEnd.State = outEnd.Target.Status()
-				outends = append(outends, *outEnd)
+				tOut := *outEnd
+				tOut.State = supervisor.RestartState(outEnd.UUID, tOut.State)
+				outends = append(outends, tOut)
 			}
 		}
 		c.JSON(common.HTTP_OK, common.OkWithData(outends))
@@ -92,11 +101,21 @@ func OutEndDetail(c *gin.Context, ruleEngine typex.Rhilex) {
 		tOutEnd.Description = mOut.Description
 		tOutEnd.Config = mOut.GetConfig()
 		tOutEnd.State = typex.SOURCE_STOP
-		c.JSON(common.HTTP_OK, common.OkWithData(tOutEnd))
+		c.JSON(common.HTTP_OK, common.OkWithData(OutEndDetailVo{
+			OutEnd:  *tOutEnd,
+			Restart: supervisor.GetRestartStatus(mOut.UUID),
+			Outbox:  lostcache.GetOutboxStats(mOut.UUID, mOut.GetConfig()),
+		}))
 		return
 	}
 	outEnd.State = outEnd.Target.Status()
-	c.JSON(common.HTTP_OK, common.OkWithData(outEnd))
+	Vo := OutEndDetailVo{
+		OutEnd:  *outEnd,
+		Restart: supervisor.GetRestartStatus(outEnd.UUID),
+		Outbox:  lostcache.GetOutboxStats(outEnd.UUID, mOut.GetConfig()),
+	}
+	Vo.State = supervisor.RestartState(outEnd.UUID, Vo.State)
+	c.JSON(common.HTTP_OK, common.OkWithData(Vo))
 }
 
 // Delete outEnd by UUID
@@ -120,6 +139,9 @@ func DeleteOutEnd(c *gin.Context, ruleEngine typex.Rhilex) {
 		}
 	}
 	ruleEngine.RemoveOutEnd(uuid)
+	intermetric.Remove(uuid)
+	supervisor.RemoveRestart(uuid)
+	lostcache.RemoveOutbox(uuid)
 	lostcache.DeleteLostDataTable(uuid)
 	c.JSON(common.HTTP_OK, common.Ok())
 }
@@ -152,6 +174,14 @@ func CreateOutEnd(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
+	if err := checkRestartPolicy(form.Config); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := checkOutboxConfig(form.Config); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
 	newUUID := utils.OutUuid()
 	if err := service.InsertMOutEnd(&model.MOutEnd{
 		UUID:        newUUID,
@@ -173,7 +203,7 @@ func CreateOutEnd(c *gin.Context, ruleEngine typex.Rhilex) {
 }
 func RestartOutEnd(c *gin.Context, ruleEngine typex.Rhilex) {
 	uuid, _ := c.GetQuery("uuid")
-	err := ruleEngine.RestartOutEnd(uuid)
+	err := restartResource(uuid, ruleEngine, ruleEngine.RestartOutEnd, server.LoadNewestOutEnd)
 	if err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
@@ -206,6 +236,14 @@ func UpdateOutEnd(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error(r))
 		return
 	}
+	if err := checkRestartPolicy(form.Config); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := checkOutboxConfig(form.Config); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
 	// 更新的时候从数据库往外面拿
 	OutEnd, err := service.GetMOutEndWithUUID(form.UUID)
 	if err != nil {
@@ -223,7 +261,7 @@ func UpdateOutEnd(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
-
+	supervisor.ResetRestart(form.UUID)
 	if err := server.LoadNewestOutEnd(form.UUID, ruleEngine); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
@@ -0,0 +1,116 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"github.com/gin-gonic/gin"
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/lostcache"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+/*
+*
+* 检查资源配置里的发件箱配置
+*
+ */
+func checkOutboxConfig(config map[string]any) error {
+	_, err := lostcache.ParseOutboxConfig(config)
+	return err
+}
+
+/*
+*
+* 发件箱状态
+*
+ */
+func OutEndOutbox(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	mOut, err := service.GetMOutEndWithUUID(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(lostcache.GetOutboxStats(mOut.UUID, mOut.GetConfig())))
+}
+
+/*
+*
+* 清空积压的消息
+*
+ */
+func ClearOutEndOutbox(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	if _, err := service.GetMOutEndWithUUID(uuid); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := lostcache.ClearOutbox(uuid); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+/*
+*
+* 死信列表
+*
+ */
+func OutEndDeadLetters(c *gin.Context, ruleEngine typex.Rhilex) {
+	pager, err := service.ReadPageRequest(c)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	uuid, _ := c.GetQuery("uuid")
+	count, records := lostcache.PageOutboxDeadLetters(uuid, pager.Current, pager.Size)
+	c.JSON(common.HTTP_OK, common.OkWithData(service.WrapPageResult(*pager, records, count)))
+}
+
+/*
+*
+* 死信重新放回补发队列
+*
+ */
+func RequeueOutEndDeadLetters(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	if _, err := service.GetMOutEndWithUUID(uuid); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	count, err := lostcache.RequeueOutboxDeadLetters(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(map[string]any{"requeued": count}))
+}
+
+/*
+*
+* 清空死信
+*
+ */
+func ClearOutEndDeadLetters(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	if err := lostcache.ClearOutboxDeadLetters(uuid); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
@@ -0,0 +1,60 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"github.com/hootrhino/rhilex/component/lostcache"
+	"github.com/hootrhino/rhilex/component/supervisor"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+// 南向资源详情, 附带监控器的重启状态
+type InEndDetailVo struct {
+	typex.InEnd
+	Restart supervisor.RestartStatus `json:"restart"`
+}
+
+// 北向资源详情, 附带监控器的重启状态和发件箱状态
+type OutEndDetailVo struct {
+	typex.OutEnd
+	Restart supervisor.RestartStatus `json:"restart"`
+	Outbox  lostcache.OutboxStats    `json:"outbox"`
+}
+
+/*
+*
+* 检查资源配置里的重启策略
+*
+ */
+func checkRestartPolicy(config map[string]any) error {
+	_, err := supervisor.ParseRestartPolicy(config)
+	return err
+}
+
+/*
+*
+* 手动重启: 清零连续重启次数; 已经 FAILED 的资源监控器已经退出了, 需要重新加载
+*
+ */
+func restartResource(uuid string, ruleEngine typex.Rhilex,
+	restart func(string) error, reload func(string, typex.Rhilex) error) error {
+	failed := supervisor.IsRestartFailed(uuid)
+	supervisor.ResetRestart(uuid)
+	if failed {
+		return reload(uuid, ruleEngine)
+	}
+	return restart(uuid)
+}
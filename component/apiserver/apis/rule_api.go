@@ -1,6 +1,7 @@
 package apis
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -9,8 +10,12 @@ import (
 	"github.com/hootrhino/rhilex/component/apiserver/model"
 	"github.com/hootrhino/rhilex/component/apiserver/server"
 	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/intermetric"
 	"github.com/hootrhino/rhilex/component/interqueue"
+	"github.com/hootrhino/rhilex/component/interwindow"
+	"github.com/hootrhino/rhilex/component/luaguard"
 	"github.com/hootrhino/rhilex/component/luaruntime"
+	"github.com/hootrhino/rhilex/component/rulerollout"
 	"github.com/hootrhino/rhilex/glogger"
 	transceiver "github.com/hootrhino/rhilex/transceiver"
 
@@ -33,22 +38,64 @@ func InitRulesRoute() {
 		rulesApi.GET(("/byDevice"), server.AddRoute(ListByDevice))
 		rulesApi.GET(("/getCanUsedResources"), server.AddRoute(GetAllResources))
 		rulesApi.POST(("/formatLua"), server.AddRoute(FormatLua))
+		rulesApi.POST(("/dryRun"), server.AddRoute(DryRunRule))
+		rulesApi.PUT(("/enable"), server.AddRoute(EnableRule))
+		rulesApi.GET(("/revisions"), server.AddRoute(RuleRevisions))
+		rulesApi.GET(("/revision"), server.AddRoute(RuleRevisionDetail))
+		rulesApi.PUT(("/rollback"), server.AddRoute(RollbackRule))
+		rulesApi.PUT(("/promote"), server.AddRoute(PromoteRule))
+		rulesApi.GET(("/rollout"), server.AddRoute(RuleRolloutDetail))
+		rulesApi.DELETE(("/rollout"), server.AddRoute(AbortRuleRollout))
 
 	}
 }
 
 type ruleVo struct {
-	UUID        string   `json:"uuid"`
-	FromSource  []string `json:"fromSource"`
-	FromDevice  []string `json:"fromDevice"`
-	Name        string   `json:"name"`
-	Type        string   `json:"type"`
-	Status      int      `json:"status"`
-	Expression  string   `json:"expression"`
-	Description string   `json:"description"`
-	Actions     string   `json:"actions"`
-	Success     string   `json:"success"`
-	Failed      string   `json:"failed"`
+	UUID        string            `json:"uuid"`
+	FromSource  []string          `json:"fromSource"`
+	FromDevice  []string          `json:"fromDevice"`
+	FromGroup   []string          `json:"fromGroup"` // 订阅的分组
+	FromType    []string          `json:"fromType"`  // 订阅的资源类型, 支持通配符
+	FromName    []string          `json:"fromName"`  // 订阅的资源名称, 支持通配符
+	FromTag     []string          `json:"fromTag"`   // 订阅的设备标签, 支持通配符
+	Name        string            `json:"name"`
+	Type        string            `json:"type"`
+	Status      int               `json:"status"` // 0: 连续超出执行预算被禁用
+	Budget      typex.LuaBudget   `json:"budget"`
+	Version     int               `json:"version"` // 线上运行的版本
+	Rollout     typex.RuleRollout `json:"rollout"` // 灰度发布
+	Expression  string            `json:"expression"`
+	Description string            `json:"description"`
+	Actions     string            `json:"actions"`
+	Success     string            `json:"success"`
+	Failed      string            `json:"failed"`
+}
+
+func newRuleVo(rule *model.MRule) ruleVo {
+	selector := server.GetRuleSelector(rule)
+	return ruleVo{
+		UUID: rule.UUID,
+		Name: rule.Name,
+		Status: func() int {
+			if luaguard.Disabled(rule.UUID) {
+				return 0
+			}
+			return 1
+		}(),
+		Budget:      server.GetRuleBudget(rule),
+		Version:     rule.Version,
+		Rollout:     server.GetRuleRollout(rule),
+		Description: rule.Description,
+		FromSource:  append([]string{}, selector.Sources...),
+		FromDevice:  append([]string{}, selector.Devices...),
+		FromGroup:   append([]string{}, selector.Groups...),
+		FromType:    append([]string{}, selector.Types...),
+		FromName:    append([]string{}, selector.Names...),
+		FromTag:     append([]string{}, selector.Tags...),
+		Success:     rule.Success,
+		Failed:      rule.Failed,
+		Actions:     rule.Actions,
+	}
 }
 
 func RuleDetail(c *gin.Context, ruleEngine typex.Rhilex) {
@@ -58,17 +105,7 @@ func RuleDetail(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400EmptyObj(err))
 		return
 	}
-	c.JSON(common.HTTP_OK, common.OkWithData(ruleVo{
-		UUID:        rule.UUID,
-		Name:        rule.Name,
-		Status:      1,
-		Description: rule.Description,
-		FromSource:  []string{rule.SourceId},
-		FromDevice:  []string{rule.DeviceId},
-		Success:     rule.Success,
-		Failed:      rule.Failed,
-		Actions:     rule.Actions,
-	}))
+	c.JSON(common.HTTP_OK, common.OkWithData(newRuleVo(rule)))
 }
 
 // Get all rules
@@ -76,17 +113,7 @@ func Rules(c *gin.Context, ruleEngine typex.Rhilex) {
 	DataList := []ruleVo{}
 	allRules, _ := service.GetAllMRule()
 	for _, rule := range allRules {
-		DataList = append(DataList, ruleVo{
-			UUID:        rule.UUID,
-			Name:        rule.Name,
-			Status:      1,
-			Description: rule.Description,
-			FromSource:  []string{rule.SourceId},
-			FromDevice:  []string{rule.DeviceId},
-			Success:     rule.Success,
-			Failed:      rule.Failed,
-			Actions:     rule.Actions,
-		})
+		DataList = append(DataList, newRuleVo(&rule))
 	}
 	c.JSON(common.HTTP_OK, common.OkWithData(DataList))
 }
@@ -94,15 +121,209 @@ func Rules(c *gin.Context, ruleEngine typex.Rhilex) {
 var __default_success = `function Success() end`
 var __default_failed = `function Failed(error) end`
 
+/*
+*
+* 规则订阅的资源: 资源和设备列表, 分组, 按照类型、名称或者设备标签通配
+*
+ */
+type ruleSelectorForm struct {
+	FromSource []string `json:"fromSource" binding:"required"`
+	FromDevice []string `json:"fromDevice" binding:"required"`
+	FromGroup  []string `json:"fromGroup"`
+	FromType   []string `json:"fromType"`
+	FromName   []string `json:"fromName"`
+	FromTag    []string `json:"fromTag"`
+}
+
+func (form ruleSelectorForm) selector() typex.RuleSelector {
+	return typex.RuleSelector{
+		Sources: uniqueStrings(form.FromSource),
+		Devices: uniqueStrings(form.FromDevice),
+		Groups:  uniqueStrings(form.FromGroup),
+		Types:   uniqueStrings(form.FromType),
+		Names:   uniqueStrings(form.FromName),
+		Tags:    uniqueStrings(form.FromTag),
+	}
+}
+
+// 检查订阅的资源是否存在
+func (form ruleSelectorForm) check() error {
+	for _, id := range form.FromSource {
+		if in, _ := service.GetMInEndWithUUID(id); in == nil {
+			return fmt.Errorf(`inend not exists: %s`, id)
+		}
+	}
+	for _, id := range form.FromDevice {
+		if in, _ := service.GetMDeviceWithUUID(id); in == nil {
+			return fmt.Errorf(`device not exists: %s`, id)
+		}
+	}
+	for _, id := range form.FromGroup {
+		if _, err := service.GetGenericGroupWithUUID(id); err != nil {
+			return fmt.Errorf(`group not exists: %s`, id)
+		}
+	}
+	return form.selector().Validate()
+}
+
+// 去掉重复和空的
+func uniqueStrings(s []string) []string {
+	result := []string{}
+	for _, v := range s {
+		if v != "" && !utils.SContains(result, v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func firstOrEmpty(s []string) string {
+	if len(s) > 0 {
+		return s[0]
+	}
+	return ""
+}
+
+func encodeRuleSelector(selector typex.RuleSelector) string {
+	bytes, _ := json.Marshal(selector)
+	return string(bytes)
+}
+
+// 规则和应用的执行预算
+func encodeLuaBudget(budget typex.LuaBudget) string {
+	bytes, _ := json.Marshal(budget)
+	return string(bytes)
+}
+
+/*
+*
+* 直接列出的资源把规则 UUID 写进 BindRules, 分组和通配符在资源加载的时候匹配
+*
+ */
+func bindRuleToResources(ruleId string, sources, devices []string) {
+	for _, inId := range sources {
+		InEnd, _ := service.GetMInEndWithUUID(inId)
+		if InEnd == nil {
+			glogger.GLogger.Error(`inend not exists: ` + inId)
+			continue
+		}
+		if utils.SContains(InEnd.BindRules, ruleId) {
+			continue
+		}
+		if err := service.UpdateMInEnd(InEnd.UUID, &model.MInEnd{
+			BindRules: append(InEnd.BindRules, ruleId),
+		}); err != nil {
+			glogger.GLogger.Error(err)
+		}
+	}
+	for _, devId := range devices {
+		Device, _ := service.GetMDeviceWithUUID(devId)
+		if Device == nil {
+			glogger.GLogger.Error(`device not exists: ` + devId)
+			continue
+		}
+		if utils.SContains(Device.BindRules, ruleId) {
+			continue
+		}
+		if err := service.UpdateDevice(Device.UUID, &model.MDevice{
+			BindRules: append(Device.BindRules, ruleId),
+		}); err != nil {
+			glogger.GLogger.Error(err)
+		}
+	}
+}
+
+func unbindRuleFromResources(ruleId string, sources, devices []string) {
+	for _, inId := range sources {
+		InEnd, _ := service.GetMInEndWithUUID(inId)
+		if InEnd == nil {
+			continue
+		}
+		BindRules := []string{}
+		for _, iid := range InEnd.BindRules {
+			if iid != ruleId {
+				BindRules = append(BindRules, iid)
+			}
+		}
+		if err := service.UpdateMInEnd(InEnd.UUID, &model.MInEnd{
+			BindRules: BindRules,
+		}); err != nil {
+			glogger.GLogger.Error(err)
+		}
+	}
+	for _, devId := range devices {
+		Device, _ := service.GetMDeviceWithUUID(devId)
+		if Device == nil {
+			continue
+		}
+		BindRules := []string{}
+		for _, iid := range Device.BindRules {
+			if iid != ruleId {
+				BindRules = append(BindRules, iid)
+			}
+		}
+		if err := service.UpdateDevice(Device.UUID, &model.MDevice{
+			BindRules: BindRules,
+		}); err != nil {
+			glogger.GLogger.Error(err)
+		}
+	}
+}
+
+/*
+*
+* 规则变化以后重新加载受影响的资源, 包括直接列出的和按照分组、通配符匹配到的
+*
+ */
+func reloadRuleResources(ruleEngine typex.Rhilex, selectors ...typex.RuleSelector) error {
+	withGroup := false
+	for _, selector := range selectors {
+		withGroup = withGroup || len(selector.Groups) > 0
+	}
+	matched := func(resource typex.RuleResource) bool {
+		if withGroup {
+			resource.Groups = service.GetResourceGroupChain(resource.UUID)
+		}
+		for _, selector := range selectors {
+			if selector.Match(resource) {
+				return true
+			}
+		}
+		return false
+	}
+	var lastErr error
+	for _, mInEnd := range service.AllMInEnd() {
+		if !matched(typex.RuleResource{Kind: "INEND", UUID: mInEnd.UUID,
+			Name: mInEnd.Name, Type: mInEnd.Type}) {
+			continue
+		}
+		if err := server.LoadNewestInEnd(mInEnd.UUID, ruleEngine); err != nil {
+			glogger.GLogger.Error(err)
+			lastErr = err
+		}
+	}
+	for _, mDevice := range service.AllDevices() {
+		if !matched(typex.RuleResource{Kind: "DEVICE", UUID: mDevice.UUID,
+			Name: mDevice.Name, Type: mDevice.Type, Tags: mDevice.Tags}) {
+			continue
+		}
+		if err := server.LoadNewestDevice(mDevice.UUID, ruleEngine); err != nil {
+			glogger.GLogger.Error(err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
 // Create rule
 func CreateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 	type Form struct {
-		FromSource  []string `json:"fromSource" binding:"required"`
-		FromDevice  []string `json:"fromDevice" binding:"required"`
-		Name        string   `json:"name" binding:"required"`
-		Type        string   `json:"type"`
-		Description string   `json:"description"`
-		Actions     string   `json:"actions"`
+		ruleSelectorForm
+		Name        string          `json:"name" binding:"required"`
+		Type        string          `json:"type"`
+		Description string          `json:"description"`
+		Actions     string          `json:"actions"`
+		Budget      typex.LuaBudget `json:"budget"`
 	}
 	form := Form{
 		Type: "lua",
@@ -120,20 +341,9 @@ func CreateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error(`rule type must be 'lua' but now is:`+form.Type))
 		return
 	}
-	for _, id := range form.FromSource {
-		in, _ := service.GetMInEndWithUUID(id)
-		if in == nil {
-			c.JSON(common.HTTP_OK, common.Error(`inend not exists: `+id))
-			return
-		}
-	}
-
-	for _, id := range form.FromDevice {
-		in, _ := service.GetMDeviceWithUUID(id)
-		if in == nil {
-			c.JSON(common.HTTP_OK, common.Error(`device not exists: `+id))
-			return
-		}
+	if err := form.check(); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
 	}
 
 	// tmpRule 是一个一次性的临时rule，用来验证规则，这么做主要是为了防止真实Lua Vm 被污染
@@ -143,154 +353,57 @@ func CreateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
-
+	selector := form.selector()
 	rule := typex.NewLuaRule(
 		ruleEngine,
 		utils.RuleUuid(),
 		form.Name,
 		form.Description,
-		func(s []string) string {
-			if len(s) > 0 {
-				return s[0]
-			}
-			return ""
-		}(form.FromSource),
-		func(s []string) string {
-			if len(s) > 0 {
-				return s[0]
-			}
-			return ""
-		}(form.FromDevice),
+		firstOrEmpty(selector.Sources),
+		firstOrEmpty(selector.Devices),
 		__default_success,
 		form.Actions,
 		__default_failed)
+	rule.Selector = selector
+	rule.Budget = form.Budget
+	rule.Version = 1
 	ruleEngine.RemoveRule(rule.UUID)
 	if err := ruleEngine.LoadRule(rule); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
+	// SaveDB
+	mRule := &model.MRule{
+		Name:        form.Name,
+		UUID:        rule.UUID,
+		Description: form.Description,
+		SourceId:    firstOrEmpty(selector.Sources),
+		DeviceId:    firstOrEmpty(selector.Devices),
+		Selector:    encodeRuleSelector(selector),
+		Budget:      encodeLuaBudget(form.Budget),
+		Version:     1,
+		Success:     __default_success,
+		Failed:      __default_failed,
+		Actions:     form.Actions,
+	}
+	if err := service.InsertMRule(mRule); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	// 第一个版本
+	revision := newRuleRevision(mRule)
+	revision.Version = mRule.Version
+	revision.Author, _ = server.CurrentUser(c)
+	revision.Message = "create"
+	revision.Diff = rulerollout.LineDiff("", revisionText(revision))
+	if err := service.InsertMRuleRevision(revision); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	// 耗时操作直接后台执行, 每个资源重新加载以后有自己的规则实例
 	go func() {
-		// 更新FromSource RULE到Device表中
-		for _, inId := range form.FromSource {
-			InEnd, _ := service.GetMInEndWithUUID(inId)
-			if InEnd == nil {
-				// c.JSON(common.HTTP_OK, common.Error(`inend not exists: `+inId))
-				glogger.GLogger.Error(`inend not exists: ` + inId)
-				return
-			}
-			// SaveDB
-			//
-			mRule := &model.MRule{
-				Name:        form.Name,
-				UUID:        rule.UUID,
-				Description: form.Description,
-				SourceId: func(s []string) string {
-					if len(s) > 0 {
-						return s[0]
-					}
-					return ""
-				}(form.FromSource),
-				DeviceId: func(s []string) string {
-					if len(s) > 0 {
-						return s[0]
-					}
-					return ""
-				}(form.FromDevice),
-				Success: __default_success,
-				Failed:  __default_failed,
-				Actions: form.Actions,
-			}
-			// 去重旧的
-			ruleMap := map[string]string{}
-			for _, rule := range InEnd.BindRules {
-				ruleMap[rule] = rule
-			}
-			// 追加新的ID
-			ruleMap[inId] = mRule.UUID
-			// 最后ID列表
-			BindRules := []string{}
-			for _, iid := range ruleMap {
-				BindRules = append(BindRules, iid)
-			}
-			InEnd.BindRules = BindRules
-			if err := service.UpdateMInEnd(InEnd.UUID, &model.MInEnd{
-				BindRules: BindRules,
-			}); err != nil {
-				glogger.GLogger.Error(err)
-				return
-			}
-
-			if err := service.InsertMRule(mRule); err != nil {
-				glogger.GLogger.Error(err)
-				return
-			}
-			// LoadNewest!!!
-			if err := server.LoadNewestInEnd(inId, ruleEngine); err != nil {
-				glogger.GLogger.Error(err)
-				return
-			}
-
-		}
-
-		// FromDevice
-		for _, devId := range form.FromDevice {
-			Device, _ := service.GetMDeviceWithUUID(devId)
-			if Device == nil {
-				glogger.GLogger.Error(`device not exists: ` + devId)
-				return
-			}
-			// 去重旧的
-			ruleMap := map[string]string{}
-			for _, rule := range Device.BindRules {
-				ruleMap[rule] = rule // for ["", "", "" ....]
-			}
-			// SaveDB
-			mRule := &model.MRule{
-				Name:        form.Name,
-				UUID:        rule.UUID,
-				Description: form.Description,
-				SourceId: func(s []string) string {
-					if len(s) > 0 {
-						return s[0]
-					}
-					return ""
-				}(form.FromSource),
-				DeviceId: func(s []string) string {
-					if len(s) > 0 {
-						return s[0]
-					}
-					return ""
-				}(form.FromDevice),
-				Success: __default_success,
-				Failed:  __default_failed,
-				Actions: form.Actions,
-			}
-			// 追加新的ID
-			ruleMap[devId] = mRule.UUID // append New Rule UUID
-			// 最后ID列表
-			BindRules := []string{}
-			for _, iid := range ruleMap {
-				BindRules = append(BindRules, iid)
-			}
-			Device.BindRules = BindRules
-			if err := service.UpdateDevice(Device.UUID, &model.MDevice{
-				BindRules: BindRules,
-			}); err != nil {
-				glogger.GLogger.Error((err))
-				return
-			}
-
-			if err := service.InsertMRule(mRule); err != nil {
-				glogger.GLogger.Error((err))
-				return
-			}
-			// LoadNewest!!!
-			if err := server.LoadNewestDevice(devId, ruleEngine); err != nil {
-				glogger.GLogger.Error((err))
-				return
-			}
-
-		}
+		bindRuleToResources(mRule.UUID, selector.Sources, selector.Devices)
+		reloadRuleResources(ruleEngine, selector)
 	}()
 
 	c.JSON(common.HTTP_OK, common.Ok())
@@ -303,13 +416,15 @@ func CreateRule(c *gin.Context, ruleEngine typex.Rhilex) {
  */
 func UpdateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 	type Form struct {
-		UUID        string   `json:"uuid" binding:"required"` // 如果空串就是新建，非空就是更新
-		FromSource  []string `json:"fromSource" binding:"required"`
-		FromDevice  []string `json:"fromDevice" binding:"required"`
-		Name        string   `json:"name" binding:"required"`
-		Type        string   `json:"type"`
-		Description string   `json:"description"`
-		Actions     string   `json:"actions"`
+		ruleSelectorForm
+		UUID        string           `json:"uuid" binding:"required"` // 如果空串就是新建，非空就是更新
+		Name        string           `json:"name" binding:"required"`
+		Type        string           `json:"type"`
+		Description string           `json:"description"`
+		Actions     string           `json:"actions"`
+		Budget      typex.LuaBudget  `json:"budget"`
+		Message     string           `json:"message"` // 修改说明, 保存在版本历史里面
+		Rollout     *ruleRolloutForm `json:"rollout"` // 不为空的时候灰度发布
 	}
 	form := Form{Type: "lua"}
 	if err := c.ShouldBindJSON(&form); err != nil {
@@ -327,133 +442,55 @@ func UpdateRule(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
-	go func() {
-		for _, id := range form.FromSource {
-			in := ruleEngine.GetInEnd(id)
-			if in == nil {
-				c.JSON(common.HTTP_OK, common.Error(`inend not exists: `+id))
-				return
-			}
-		}
-		for _, id := range form.FromDevice {
-			in := ruleEngine.GetDevice(id)
-			if in == nil {
-				c.JSON(common.HTTP_OK, common.Error(`device not exists: `+id))
-				return
-			}
-		}
-		OldRule, err := service.GetMRuleWithUUID(form.UUID)
-		if err != nil {
+	if err := form.check(); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if form.Rollout != nil {
+		if err := form.Rollout.rollout(1).Validate(); err != nil {
 			c.JSON(common.HTTP_OK, common.Error400(err))
 			return
 		}
-		rule := typex.NewLuaRule(
-			ruleEngine,
-			OldRule.UUID,
-			OldRule.Name,
-			OldRule.Description,
-			OldRule.SourceId,
-			OldRule.DeviceId,
-			OldRule.Success,
-			OldRule.Actions,
-			OldRule.Failed)
-		ruleEngine.RemoveRule(rule.UUID)
-		if err := ruleEngine.LoadRule(rule); err != nil {
+	}
+	OldRule, err := service.GetMRuleWithUUID(form.UUID)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	selector := form.selector()
+	// 每次保存都生成一个新版本
+	revision := newRuleRevision(&model.MRule{
+		UUID:        OldRule.UUID,
+		Name:        form.Name,
+		Description: form.Description,
+		Actions:     form.Actions,
+		Success:     __default_success,
+		Failed:      __default_failed,
+		Selector:    encodeRuleSelector(selector),
+		Budget:      encodeLuaBudget(form.Budget),
+	})
+	revision.Author, _ = server.CurrentUser(c)
+	revision.Message = form.Message
+	// 灰度发布: 线上版本不变, 确认以后再调用 promote 发布
+	if form.Rollout != nil && form.Rollout.Mode != "" {
+		if err := saveRuleRevision(OldRule, revision); err != nil {
 			c.JSON(common.HTTP_OK, common.Error400(err))
 			return
 		}
-		// SaveDB
-		//
-		if err := service.UpdateMRule(OldRule.UUID, &model.MRule{
-			Name:        form.Name,
-			Description: form.Description,
-			SourceId: func(s []string) string {
-				if len(s) > 0 {
-					return s[0]
-				}
-				return ""
-			}(form.FromSource),
-			DeviceId: func(s []string) string {
-				if len(s) > 0 {
-					return s[0]
-				}
-				return ""
-			}(form.FromDevice),
-			Success: __default_success,
-			Failed:  __default_failed,
-			Actions: form.Actions,
-		}); err != nil {
+		if err := startRuleRollout(ruleEngine, OldRule,
+			form.Rollout.rollout(revision.Version)); err != nil {
 			c.JSON(common.HTTP_OK, common.Error400(err))
 			return
 		}
-
-		// 耗时操作直接后台执行
-		if len(form.FromSource) > 0 {
-			// 更新FromSource RULE到Device表中
-			InEnd, _ := service.GetMInEndWithUUID(form.FromSource[0])
-			if InEnd == nil {
-				glogger.GLogger.Error((`inend not exists: ` + form.FromSource[0]))
-				return
-			}
-			// 去重旧的
-			ruleMap := map[string]string{}
-			for _, rule := range InEnd.BindRules {
-				ruleMap[rule] = rule
-			}
-			// 追加新的ID
-			ruleMap[OldRule.UUID] = OldRule.UUID // append New Rule UUID
-			// 最后ID列表
-			BindRules := []string{}
-			for _, iid := range ruleMap {
-				BindRules = append(BindRules, iid)
-			}
-			InEnd.BindRules = BindRules
-			if err := service.UpdateMInEnd(InEnd.UUID, &model.MInEnd{
-				BindRules: BindRules,
-			}); err != nil {
-				glogger.GLogger.Error((err))
-				return
-			}
-			// LoadNewest!!!
-			if err := server.LoadNewestInEnd(InEnd.UUID, ruleEngine); err != nil {
-				glogger.GLogger.Error((err))
-				return
-			}
-		}
-		// FromDevice
-		if len(form.FromDevice) > 0 {
-			Device, _ := service.GetMDeviceWithUUID(form.FromDevice[0])
-			if Device == nil {
-				glogger.GLogger.Error((`device not exists: ` + form.FromDevice[0]))
-				return
-			}
-			// 去重旧的
-			ruleMap := map[string]string{}
-			for _, rule := range Device.BindRules {
-				ruleMap[rule] = rule // for ["", "", "" ....]
-			}
-			// 追加新的ID
-			ruleMap[OldRule.UUID] = OldRule.UUID // append New Rule UUID
-			// 最后ID列表
-			BindRules := []string{}
-			for _, iid := range ruleMap {
-				BindRules = append(BindRules, iid)
-			}
-			Device.BindRules = BindRules
-			if err := service.UpdateDevice(Device.UUID, &model.MDevice{
-				BindRules: BindRules,
-			}); err != nil {
-				glogger.GLogger.Error((err))
-				return
-			}
-			// LoadNewest!!!
-			if err := server.LoadNewestDevice(Device.UUID, ruleEngine); err != nil {
-				glogger.GLogger.Error((err))
-				return
-			}
-		}
-	}()
-	c.JSON(common.HTTP_OK, common.Ok())
+		c.JSON(common.HTTP_OK, common.OkWithData(revision.Version))
+		return
+	}
+	// 先加载, 成功以后才保存版本
+	if err := publishRuleRevision(ruleEngine, OldRule, revision); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(revision.Version))
 }
 
 // Delete rule by UUID
@@ -464,68 +501,45 @@ func DeleteRule(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err0))
 		return
 	}
-	if len(mRule.SourceId) > 0 {
-		InEnd, _ := service.GetMInEndWithUUID(mRule.SourceId)
-		if InEnd == nil {
-			c.JSON(common.HTTP_OK, common.Error(`inend not exists: `+mRule.SourceId))
-			return
-		}
-		// 去重旧的
-		InEndBindRules := []string{}
-		for _, iid := range InEnd.BindRules {
-			if iid != mRule.UUID {
-				InEndBindRules = append(InEndBindRules, iid)
-			}
-		}
-		if err := service.UpdateMInEnd(InEnd.UUID, &model.MInEnd{
-			BindRules: InEndBindRules,
-		}); err != nil {
-			c.JSON(common.HTTP_OK, common.Error400(err))
-			return
-		}
-
-		if err := server.LoadNewestInEnd(mRule.SourceId, ruleEngine); err != nil {
-			c.JSON(common.HTTP_OK, common.Error400(err))
-			return
-		}
-
+	selector := server.GetRuleSelector(mRule)
+	unbindRuleFromResources(mRule.UUID, selector.Sources, selector.Devices)
+	if err := service.DeleteMRule(mRule.UUID); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
 	}
-
-	// FromDevice
-	if len(mRule.DeviceId) > 0 {
-		Device, _ := service.GetMDeviceWithUUID(mRule.DeviceId)
-		if Device == nil {
-			c.JSON(common.HTTP_OK, common.Error(`device not exists: `+mRule.DeviceId))
-			return
-		}
-		// 去重旧的
-		DeviceBindRules := []string{}
-		for _, iid := range Device.BindRules {
-			if iid != mRule.UUID {
-				DeviceBindRules = append(DeviceBindRules, iid)
-			}
-		}
-		if err := service.UpdateDevice(Device.UUID, &model.MDevice{
-			BindRules: DeviceBindRules,
-		}); err != nil {
-			c.JSON(common.HTTP_OK, common.Error400(err))
-			return
-		}
-		//
-		// 内存里的数据刷新完了以后更新数据库，最后重启
-		//
-		if err := server.LoadNewestDevice(mRule.DeviceId, ruleEngine); err != nil {
-			c.JSON(common.HTTP_OK, common.Error400(err))
-			return
-		}
-
+	if err := service.DeleteMRuleRevisions(mRule.UUID); err != nil {
+		glogger.GLogger.Error(err)
+	}
+	ruleEngine.RemoveRule(mRule.UUID)
+	intermetric.Remove(mRule.UUID)
+	rulerollout.Reset(mRule.UUID)
+	interwindow.DefaultWindowStore().RemoveRule(mRule.UUID)
+	//
+	// 数据库更新完了以后重启受影响的资源
+	//
+	if err := reloadRuleResources(ruleEngine, selector); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
 	}
+	c.JSON(common.HTTP_OK, common.Ok())
+}
 
-	if err := service.DeleteMRule(mRule.UUID); err != nil {
+/*
+*
+* 重新启用连续超出执行预算被禁用的规则
+*
+ */
+func EnableRule(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	if _, err := service.GetMRuleWithUUID(uuid); err != nil {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
-	ruleEngine.RemoveRule(mRule.UUID)
+	if err := service.UpdateMRuleDisabled(uuid, false); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	luaguard.Enable(uuid)
 	c.JSON(common.HTTP_OK, common.Ok())
 }
 
@@ -634,23 +648,14 @@ func ListByDevice(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(err))
 		return
 	}
-	mRules := service.AllMRules() // 这个效率太低了, 后期写个SQL优化一下
-	ruleVos := []ruleVo{}
-	for _, rule := range mRules {
-		if utils.SContains([]string{rule.DeviceId}, MDevice.UUID) {
-			ruleVos = append(ruleVos, ruleVo{
-				UUID:        rule.UUID,
-				FromSource:  []string{rule.SourceId},
-				FromDevice:  []string{rule.DeviceId},
-				Name:        rule.Name,
-				Status:      1,
-				Description: rule.Description,
-				Actions:     rule.Actions,
-				Success:     rule.Success,
-				Failed:      rule.Failed,
-			})
-		}
-	}
+	ruleVos := rulesOfResource(typex.RuleResource{
+		Kind:   "DEVICE",
+		UUID:   MDevice.UUID,
+		Name:   MDevice.Name,
+		Type:   MDevice.Type,
+		Groups: service.GetResourceGroupChain(MDevice.UUID),
+		Tags:   MDevice.Tags,
+	})
 	c.JSON(common.HTTP_OK, common.OkWithData(ruleVos))
 
 }
@@ -668,25 +673,27 @@ func ListByInend(c *gin.Context, ruleEngine typex.Rhilex) {
 		return
 	}
 
+	ruleVos := rulesOfResource(typex.RuleResource{
+		Kind:   "INEND",
+		UUID:   MInend.UUID,
+		Name:   MInend.Name,
+		Type:   MInend.Type,
+		Groups: service.GetResourceGroupChain(MInend.UUID),
+	})
+	c.JSON(common.HTTP_OK, common.OkWithData(ruleVos))
+
+}
+
+// 订阅了资源的规则, 包括按照分组和通配符匹配到的
+func rulesOfResource(resource typex.RuleResource) []ruleVo {
 	mRules := service.AllMRules() // 这个效率太低了, 后期写个SQL优化一下
 	ruleVos := []ruleVo{}
 	for _, rule := range mRules {
-		if utils.SContains([]string{rule.SourceId}, MInend.UUID) {
-			ruleVos = append(ruleVos, ruleVo{
-				UUID:        rule.UUID,
-				FromSource:  []string{rule.SourceId},
-				FromDevice:  []string{rule.DeviceId},
-				Name:        rule.Name,
-				Status:      1,
-				Description: rule.Description,
-				Actions:     rule.Actions,
-				Success:     rule.Success,
-				Failed:      rule.Failed,
-			})
+		if server.GetRuleSelector(&rule).Match(resource) {
+			ruleVos = append(ruleVos, newRuleVo(&rule))
 		}
 	}
-	c.JSON(common.HTTP_OK, common.OkWithData(ruleVos))
-
+	return ruleVos
 }
 
 /*
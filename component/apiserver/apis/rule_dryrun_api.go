@@ -0,0 +1,97 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"fmt"
+	"time"
+
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/luaruntime"
+	"github.com/hootrhino/rhilex/typex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 一次试运行最多的样例数据和单条数据最长的超时时间
+const (
+	maxDryRunInputs  = 100
+	maxDryRunTimeout = 10000
+)
+
+/*
+*
+* 规则试运行: 用样例数据在独立的虚拟机里面执行脚本, 不会真的输出数据或者控制设备;
+* 传了 uuid 的时候, 没有填写的脚本使用已经保存的规则
+*
+ */
+func DryRunRule(c *gin.Context, ruleEngine typex.Rhilex) {
+	type Form struct {
+		UUID    string   `json:"uuid"`
+		Actions string   `json:"actions"`
+		Success string   `json:"success"`
+		Failed  string   `json:"failed"`
+		Inputs  []string `json:"inputs" binding:"required"`
+		Timeout int      `json:"timeout"` // 毫秒
+	}
+	form := Form{}
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if len(form.Inputs) == 0 || len(form.Inputs) > maxDryRunInputs {
+		c.JSON(common.HTTP_OK, common.Error(fmt.Sprintf("inputs size must be in range [1, %d]", maxDryRunInputs)))
+		return
+	}
+	if form.Timeout < 0 || form.Timeout > maxDryRunTimeout {
+		c.JSON(common.HTTP_OK, common.Error(fmt.Sprintf("timeout must be in range [0, %d]", maxDryRunTimeout)))
+		return
+	}
+	if form.UUID != "" {
+		rule, err := service.GetMRuleWithUUID(form.UUID)
+		if err != nil {
+			c.JSON(common.HTTP_OK, common.Error400(err))
+			return
+		}
+		if form.Actions == "" {
+			form.Actions = rule.Actions
+		}
+		if form.Success == "" {
+			form.Success = rule.Success
+		}
+		if form.Failed == "" {
+			form.Failed = rule.Failed
+		}
+	}
+	if form.Actions == "" {
+		c.JSON(common.HTTP_OK, common.Error("actions can not be empty"))
+		return
+	}
+	if form.Success == "" {
+		form.Success = __default_success
+	}
+	if form.Failed == "" {
+		form.Failed = __default_failed
+	}
+	results, err := luaruntime.DryRunRule(ruleEngine, form.Actions, form.Success,
+		form.Failed, form.Inputs, time.Duration(form.Timeout)*time.Millisecond)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(results))
+}
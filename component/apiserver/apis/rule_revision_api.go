@@ -0,0 +1,435 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package apis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/apiserver/server"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/luaguard"
+	"github.com/hootrhino/rhilex/component/rulerollout"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+	"gorm.io/gorm"
+)
+
+type ruleRevisionVo struct {
+	Version     int             `json:"version"`
+	Author      string          `json:"author"`
+	Message     string          `json:"message"`
+	CreatedAt   int64           `json:"createdAt"` // 毫秒时间戳
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	FromSource  []string        `json:"fromSource"`
+	FromDevice  []string        `json:"fromDevice"`
+	FromGroup   []string        `json:"fromGroup"`
+	FromType    []string        `json:"fromType"`
+	FromName    []string        `json:"fromName"`
+	FromTag     []string        `json:"fromTag"`
+	Budget      typex.LuaBudget `json:"budget"`
+	Actions     string          `json:"actions"`
+	Diff        string          `json:"diff"` // 和上一个版本的差异
+}
+
+func newRuleRevisionVo(r *model.MRuleRevision) ruleRevisionVo {
+	selector := server.GetRuleSelector(&model.MRule{UUID: r.RuleId, Selector: r.Selector})
+	return ruleRevisionVo{
+		Version:     r.Version,
+		Author:      r.Author,
+		Message:     r.Message,
+		CreatedAt:   r.CreatedAt.UnixMilli(),
+		Name:        r.Name,
+		Description: r.Description,
+		FromSource:  append([]string{}, selector.Sources...),
+		FromDevice:  append([]string{}, selector.Devices...),
+		FromGroup:   append([]string{}, selector.Groups...),
+		FromType:    append([]string{}, selector.Types...),
+		FromName:    append([]string{}, selector.Names...),
+		FromTag:     append([]string{}, selector.Tags...),
+		Budget:      server.GetRuleBudget(&model.MRule{UUID: r.RuleId, Budget: r.Budget}),
+		Actions:     r.Actions,
+		Diff:        r.Diff,
+	}
+}
+
+/*
+*
+* 灰度发布: CANARY 在选中的资源上运行新版本, SHADOW 在所有资源上同时运行新版本,
+* 输出丢弃只和线上版本比较
+*
+ */
+type ruleRolloutForm struct {
+	Mode      string   `json:"mode"`
+	Resources []string `json:"resources"`
+	Percent   int      `json:"percent"`
+}
+
+func (form ruleRolloutForm) rollout(version int) typex.RuleRollout {
+	return typex.RuleRollout{
+		Mode:      form.Mode,
+		Version:   version,
+		Resources: uniqueStrings(form.Resources),
+		Percent:   form.Percent,
+	}
+}
+
+// 规则当前的内容作为一个版本
+func newRuleRevision(mRule *model.MRule) *model.MRuleRevision {
+	return &model.MRuleRevision{
+		RuleId:      mRule.UUID,
+		Name:        mRule.Name,
+		Description: mRule.Description,
+		Actions:     mRule.Actions,
+		Success:     mRule.Success,
+		Failed:      mRule.Failed,
+		Selector:    mRule.Selector,
+		Budget:      mRule.Budget,
+	}
+}
+
+// 比较差异用的文本, 订阅的资源和预算也算在内
+func revisionText(r *model.MRuleRevision) string {
+	return fmt.Sprintf("-- name: %s\n-- description: %s\n-- selector: %s\n-- budget: %s\n%s",
+		r.Name, r.Description, r.Selector, r.Budget, r.Actions)
+}
+
+// 老版本的规则没有历史, 当前内容作为第一个版本
+func initialRuleRevision(mRule *model.MRule) *model.MRuleRevision {
+	initial := newRuleRevision(mRule)
+	initial.Version = max(mRule.Version, 1)
+	initial.Message = "initial revision"
+	initial.Diff = rulerollout.LineDiff("", revisionText(initial))
+	return initial
+}
+
+// 下一个版本号, 保存的时候在事务里面再检查一次
+func nextRuleVersion(mRule *model.MRule) (int, error) {
+	latest, err := service.GetLatestMRuleRevision(mRule.UUID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, err
+		}
+		return initialRuleRevision(mRule).Version + 1, nil
+	}
+	return latest.Version + 1, nil
+}
+
+/*
+*
+* 在事务里面保存一个新版本, 版本号和差异都基于最新的版本; 版本号已经分配了的时候必须还是
+* 最新版本的下一个, 否则说明规则同时被别人修改了
+*
+ */
+func saveRuleRevision(mRule *model.MRule, revision *model.MRuleRevision) error {
+	return service.InsertNextMRuleRevision(mRule.UUID,
+		func(latest *model.MRuleRevision) ([]*model.MRuleRevision, error) {
+			revisions := []*model.MRuleRevision{}
+			if latest == nil {
+				latest = initialRuleRevision(mRule)
+				revisions = append(revisions, latest)
+				mRule.Version = latest.Version
+			}
+			if revision.Version == 0 {
+				revision.Version = latest.Version + 1
+			} else if revision.Version != latest.Version+1 {
+				return nil, fmt.Errorf("rule has been modified concurrently, latest version: %d",
+					latest.Version)
+			}
+			revision.RuleId = mRule.UUID
+			revision.Diff = rulerollout.LineDiff(revisionText(latest), revisionText(revision))
+			return append(revisions, revision), nil
+		})
+}
+
+// 把某个版本加载到引擎里面, 失败的时候引擎里面没有这个规则
+func loadRuleRevision(ruleEngine typex.Rhilex, mRule *model.MRule, revision *model.MRuleRevision) error {
+	selector := server.GetRuleSelector(&model.MRule{UUID: mRule.UUID, Selector: revision.Selector})
+	rule := typex.NewLuaRule(
+		ruleEngine,
+		mRule.UUID,
+		revision.Name,
+		revision.Description,
+		firstOrEmpty(selector.Sources),
+		firstOrEmpty(selector.Devices),
+		revision.Success,
+		revision.Actions,
+		revision.Failed)
+	rule.Selector = selector
+	rule.Budget = server.GetRuleBudget(&model.MRule{UUID: mRule.UUID, Budget: revision.Budget})
+	rule.Version = revision.Version
+	ruleEngine.RemoveRule(rule.UUID)
+	return ruleEngine.LoadRule(rule)
+}
+
+// 新版本加载或者保存失败的时候恢复线上版本
+func restoreRuleRevision(ruleEngine typex.Rhilex, mRule *model.MRule) {
+	online := newRuleRevision(mRule)
+	online.Version = mRule.Version
+	if err := loadRuleRevision(ruleEngine, mRule, online); err != nil {
+		glogger.GLogger.Error("Restore rule failed:", mRule.UUID, err)
+	}
+	if mRule.Disabled {
+		luaguard.Disable(mRule.UUID)
+	}
+}
+
+/*
+*
+* 保存并发布新版本: 先加载, 加载成功以后再在事务里面保存版本, 保存失败的时候恢复线上版本,
+* 加载失败的内容不会出现在历史里面
+*
+ */
+func publishRuleRevision(ruleEngine typex.Rhilex, mRule *model.MRule, revision *model.MRuleRevision) error {
+	version, err := nextRuleVersion(mRule)
+	if err != nil {
+		return err
+	}
+	revision.Version = version
+	if err := loadRuleRevision(ruleEngine, mRule, revision); err != nil {
+		restoreRuleRevision(ruleEngine, mRule)
+		return err
+	}
+	if err := saveRuleRevision(mRule, revision); err != nil {
+		restoreRuleRevision(ruleEngine, mRule)
+		return err
+	}
+	return updateRuleRevision(ruleEngine, mRule, revision)
+}
+
+// 已经保存的版本发布到线上, 比如灰度的版本全量发布
+func applyRuleRevision(ruleEngine typex.Rhilex, mRule *model.MRule, revision *model.MRuleRevision) error {
+	if err := loadRuleRevision(ruleEngine, mRule, revision); err != nil {
+		restoreRuleRevision(ruleEngine, mRule)
+		return err
+	}
+	return updateRuleRevision(ruleEngine, mRule, revision)
+}
+
+/*
+*
+* 版本加载以后更新数据库, 清掉灰度; 订阅的资源有变化的时候解除不再订阅的资源,
+* 原来和现在匹配到的资源都要重新加载
+*
+ */
+func updateRuleRevision(ruleEngine typex.Rhilex, mRule *model.MRule, revision *model.MRuleRevision) error {
+	oldSelector := server.GetRuleSelector(mRule)
+	selector := server.GetRuleSelector(&model.MRule{UUID: mRule.UUID, Selector: revision.Selector})
+	budget := server.GetRuleBudget(&model.MRule{UUID: mRule.UUID, Budget: revision.Budget})
+	if err := service.UpdateMRule(mRule.UUID, &model.MRule{
+		Name:        revision.Name,
+		Description: revision.Description,
+		Success:     revision.Success,
+		Failed:      revision.Failed,
+		Actions:     revision.Actions,
+		Budget:      encodeLuaBudget(budget),
+	}); err != nil {
+		return err
+	}
+	if err := service.UpdateMRuleSelector(mRule.UUID, firstOrEmpty(selector.Sources),
+		firstOrEmpty(selector.Devices), encodeRuleSelector(selector)); err != nil {
+		return err
+	}
+	if err := service.UpdateMRuleRollout(mRule.UUID, revision.Version, ""); err != nil {
+		return err
+	}
+	// 重新加载以后规则已经启用了, 数据库里面的状态也清掉
+	if err := service.UpdateMRuleDisabled(mRule.UUID, false); err != nil {
+		return err
+	}
+	rulerollout.Reset(mRule.UUID)
+	// 耗时操作直接后台执行
+	go func() {
+		removedSources := []string{}
+		for _, id := range oldSelector.Sources {
+			if !utils.SContains(selector.Sources, id) {
+				removedSources = append(removedSources, id)
+			}
+		}
+		removedDevices := []string{}
+		for _, id := range oldSelector.Devices {
+			if !utils.SContains(selector.Devices, id) {
+				removedDevices = append(removedDevices, id)
+			}
+		}
+		unbindRuleFromResources(mRule.UUID, removedSources, removedDevices)
+		bindRuleToResources(mRule.UUID, selector.Sources, selector.Devices)
+		reloadRuleResources(ruleEngine, oldSelector, selector)
+	}()
+	return nil
+}
+
+/*
+*
+* 开始灰度: 线上版本不变, 订阅的资源重新加载以后按照灰度配置运行待发布的版本
+*
+ */
+func startRuleRollout(ruleEngine typex.Rhilex, mRule *model.MRule, rollout typex.RuleRollout) error {
+	bytes, _ := json.Marshal(rollout)
+	if err := service.UpdateMRuleRollout(mRule.UUID, mRule.Version, string(bytes)); err != nil {
+		return err
+	}
+	rulerollout.Reset(mRule.UUID)
+	go reloadRuleResources(ruleEngine, server.GetRuleSelector(mRule))
+	return nil
+}
+
+// 规则的所有版本
+func RuleRevisions(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	if _, err := service.GetMRuleWithUUID(uuid); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	revisions, err := service.GetMRuleRevisions(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	DataList := []ruleRevisionVo{}
+	for _, revision := range revisions {
+		DataList = append(DataList, newRuleRevisionVo(&revision))
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(DataList))
+}
+
+func RuleRevisionDetail(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	version, err := strconv.Atoi(c.Query("version"))
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	revision, err := service.GetMRuleRevision(uuid, version)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400EmptyObj(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(newRuleRevisionVo(revision)))
+}
+
+/*
+*
+* 回滚: 把历史版本的内容保存成一个新版本然后发布, 历史不会被改写
+*
+ */
+func RollbackRule(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	version, err := strconv.Atoi(c.Query("version"))
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	mRule, err := service.GetMRuleWithUUID(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	target, err := service.GetMRuleRevision(uuid, version)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	revision := newRuleRevision(&model.MRule{
+		UUID:        uuid,
+		Name:        target.Name,
+		Description: target.Description,
+		Actions:     target.Actions,
+		Success:     target.Success,
+		Failed:      target.Failed,
+		Selector:    target.Selector,
+		Budget:      target.Budget,
+	})
+	revision.Author, _ = server.CurrentUser(c)
+	revision.Message = fmt.Sprintf("rollback to version %d", version)
+	if err := publishRuleRevision(ruleEngine, mRule, revision); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(revision.Version))
+}
+
+// 灰度的版本发布到所有资源
+func PromoteRule(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	mRule, err := service.GetMRuleWithUUID(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	rollout := server.GetRuleRollout(mRule)
+	if !rollout.Active() {
+		c.JSON(common.HTTP_OK, common.Error("rule is not in rollout"))
+		return
+	}
+	revision, err := service.GetMRuleRevision(uuid, rollout.Version)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if err := applyRuleRevision(ruleEngine, mRule, revision); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(revision.Version))
+}
+
+// 取消灰度, 所有资源恢复运行线上版本, 待发布的版本保留在历史里面
+func AbortRuleRollout(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	mRule, err := service.GetMRuleWithUUID(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	if !server.GetRuleRollout(mRule).Active() {
+		c.JSON(common.HTTP_OK, common.Error("rule is not in rollout"))
+		return
+	}
+	if err := service.UpdateMRuleRollout(uuid, mRule.Version, ""); err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	rulerollout.Reset(uuid)
+	go reloadRuleResources(ruleEngine, server.GetRuleSelector(mRule))
+	c.JSON(common.HTTP_OK, common.Ok())
+}
+
+/*
+*
+* 灰度状态: 配置, 每个版本的执行统计, 影子模式下的比较结果
+*
+ */
+func RuleRolloutDetail(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	mRule, err := service.GetMRuleWithUUID(uuid)
+	if err != nil {
+		c.JSON(common.HTTP_OK, common.Error400(err))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(map[string]any{
+		"version": mRule.Version,
+		"rollout": server.GetRuleRollout(mRule),
+		"report":  rulerollout.GetReport(uuid),
+	}))
+}
@@ -2,6 +2,7 @@ package apis
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"runtime"
 	"strconv"
@@ -50,6 +51,15 @@ func InitSystemRoute() {
 	}
 	server.DefaultApiServer.Route().
 		GET(server.ContextUrl("statistics"), server.AddRoute(Statistics))
+	server.DefaultApiServer.Route().
+		GET(server.ContextUrl("statistics/resources"), server.AddRoute(ResourceStatistics))
+	server.DefaultApiServer.Route().
+		GET(server.ContextUrl("statistics/metrics"), server.AddRoute(PrometheusMetrics))
+	// 不需要登录的 Prometheus 采集端点, 不在 /api/v1 下面, 默认关闭
+	if core.GlobalConfig.EnableMetrics {
+		server.DefaultApiServer.Route().
+			GET("/metrics", server.AddRoute(PrometheusMetrics))
+	}
 	server.DefaultApiServer.Route().
 		POST(server.ContextUrl("login"), server.AddRoute(Login))
 	server.DefaultApiServer.Route().
@@ -183,6 +193,38 @@ func Statistics(c *gin.Context, ruleEngine typex.Rhilex) {
 	c.JSON(common.HTTP_OK, common.OkWithData(intermetric.GetMetric()))
 }
 
+/*
+*
+* 每个资源的统计, uuid 为空的时候返回全部
+*
+ */
+func ResourceStatistics(c *gin.Context, ruleEngine typex.Rhilex) {
+	uuid, _ := c.GetQuery("uuid")
+	if uuid == "" {
+		c.JSON(common.HTTP_OK, common.OkWithData(intermetric.GetResourceMetrics()))
+		return
+	}
+	Metric, ok := intermetric.GetResourceMetric(uuid)
+	if !ok {
+		c.JSON(common.HTTP_OK, common.Error("Resource Metric Not Exists"))
+		return
+	}
+	c.JSON(common.HTTP_OK, common.OkWithData(Metric))
+}
+
+/*
+*
+* Prometheus 文本格式
+*
+ */
+func PrometheusMetrics(c *gin.Context, ruleEngine typex.Rhilex) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := intermetric.WritePrometheus(c.Writer); err != nil {
+		glogger.GLogger.Error(err)
+	}
+}
+
 // Get statistics data
 func SourceCount(c *gin.Context, ruleEngine typex.Rhilex) {
 	c.JSON(common.HTTP_OK, common.OkWithData(map[string]int{
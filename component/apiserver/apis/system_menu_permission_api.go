@@ -18,6 +18,7 @@ package apis
 import (
 	"github.com/gin-gonic/gin"
 	common "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
 	"github.com/hootrhino/rhilex/component/apiserver/server"
 	"github.com/hootrhino/rhilex/typex"
 )
@@ -42,6 +43,56 @@ type SysMenuChild struct {
 	Access bool   `json:"access"`
 }
 
+// 菜单需要的最低角色, 不在表里面的菜单默认操作员可见
+var menuRoles = map[string]string{
+	"dashboard":  model.ROLE_READONLY,
+	"device":     model.ROLE_READONLY,
+	"schema":     model.ROLE_READONLY,
+	"alarm":      model.ROLE_READONLY,
+	"repository": model.ROLE_READONLY,
+	"inend":      model.ROLE_READONLY,
+	"outend":     model.ROLE_READONLY,
+	"system":     model.ROLE_READONLY,
+	"resource":   model.ROLE_READONLY,
+	"nerworking": model.ROLE_READONLY,
+	"netStatus":  model.ROLE_READONLY,
+	"user":       model.ROLE_READONLY,
+	"network":    model.ROLE_ADMIN,
+	"wifi":       model.ROLE_ADMIN,
+	"net4g":      model.ROLE_ADMIN,
+	"net5g":      model.ROLE_ADMIN,
+	"can":        model.ROLE_ADMIN,
+	"time":       model.ROLE_ADMIN,
+	"reboot":     model.ROLE_ADMIN,
+	"firmware":   model.ROLE_ADMIN,
+	"backup":     model.ROLE_ADMIN,
+}
+
+func menuAccess(role, key string) bool {
+	required, ok := menuRoles[key]
+	if !ok {
+		required = model.ROLE_OPERATOR
+	}
+	return server.RoleAllowed(role, required)
+}
+
+/*
+*
+* 按当前用户角色过滤菜单
+*
+ */
+func filterMenusByRole(role string, menus []SysMenu) []SysMenu {
+	for i := range menus {
+		menus[i].Access = menus[i].Access && menuAccess(role, menus[i].Key)
+		for j := range menus[i].Children {
+			menus[i].Children[j].Access = menus[i].Access &&
+				menus[i].Children[j].Access &&
+				menuAccess(role, menus[i].Children[j].Key)
+		}
+	}
+	return menus
+}
+
 func GetSysMenus(c *gin.Context, ruleEngine typex.Rhilex) {
 	allMenu := []SysMenu{
 		{Group: "root", Children: []SysMenuChild{}, Id: 0, Key: "dashboard", Access: true},
@@ -57,7 +108,8 @@ func GetSysMenus(c *gin.Context, ruleEngine typex.Rhilex) {
 		{Group: "root", Children: []SysMenuChild{}, Id: 10, Key: "module", Access: true},
 		{Group: "root", Children: []SysMenuChild{}, Id: 11, Key: "system", Access: true},
 	}
-	c.JSON(common.HTTP_OK, common.OkWithData(allMenu))
+	_, role := server.CurrentUser(c)
+	c.JSON(common.HTTP_OK, common.OkWithData(filterMenusByRole(role, allMenu)))
 }
 
 // 系统资源
@@ -106,5 +158,6 @@ func GetDistConfigMenus(c *gin.Context, ruleEngine typex.Rhilex) {
 		},
 		{Id: 4, Group: "user", Key: "user", Access: true, Children: []SysMenuChild{}},
 	}
-	c.JSON(common.HTTP_OK, common.OkWithData(allMenu))
+	_, role := server.CurrentUser(c)
+	c.JSON(common.HTTP_OK, common.OkWithData(filterMenusByRole(role, allMenu)))
 }
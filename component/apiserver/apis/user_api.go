@@ -17,7 +17,6 @@ import (
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 )
 
@@ -32,10 +31,6 @@ func InitUserRoute() {
 	}
 }
 
-const (
-	__SECRET_KEY = "you-can-not-get-this-secret"
-)
-
 // All Users
 type user struct {
 	Role        string `json:"role"`
@@ -47,7 +42,7 @@ func Users(c *gin.Context, ruleEngine typex.Rhilex) {
 	users := []user{}
 	for _, u := range service.AllMUser() {
 		users = append(users, user{
-			Role:        u.Role,
+			Role:        model.NormalizeRole(u.Role),
 			Username:    u.Username,
 			Description: u.Description,
 		})
@@ -80,9 +75,13 @@ func CreateUser(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error("Password Length must Between 6 ~ 16"))
 		return
 	}
+	if !model.ValidRole(form.Role) {
+		c.JSON(common.HTTP_OK, common.Error("Invalid Role, must be one of: ADMIN, OPERATOR, READONLY"))
+		return
+	}
 	if _, err := service.GetMUser(form.Username); err != nil {
 		service.InsertMUser(&model.MUser{
-			Role:        form.Role,
+			Role:        model.NormalizeRole(form.Role),
 			Username:    form.Username,
 			Password:    md5Hash(form.Password),
 			Description: form.Description,
@@ -124,13 +123,8 @@ func UpdateUser(c *gin.Context, ruleEngine typex.Rhilex) {
 		return
 	}
 
-	token := c.GetHeader("Authorization")
-	claims, errParse := parseToken(token)
-	if errParse != nil {
-		c.JSON(common.HTTP_OK, common.Error400(errParse))
-		return
-	}
-	if err2 := service.UpdateMUser(claims.Username, &model.MUser{
+	currentUser, _ := server.CurrentUser(c)
+	if err2 := service.UpdateMUser(currentUser, &model.MUser{
 		Username:    form.Username,
 		Password:    md5Hash(form.Password2),
 		Description: form.Description,
@@ -177,7 +171,7 @@ func Login(c *gin.Context, ruleEngine typex.Rhilex) {
 		return
 	}
 	Ts := uint64(time.Now().UnixMilli())
-	_, errLogin := service.Login(u.Username, md5Hash(string(decryptedBytes)))
+	mUser, errLogin := service.Login(u.Username, md5Hash(string(decryptedBytes)))
 	if errLogin != nil {
 		glogger.GLogger.Warn("User Login Failed:", clientIP)
 		internotify.Insert(internotify.BaseEvent{
@@ -191,7 +185,7 @@ func Login(c *gin.Context, ruleEngine typex.Rhilex) {
 		c.JSON(common.HTTP_OK, common.Error400(errLogin))
 		return
 	}
-	token, err1 := generateToken(u.Username)
+	token, err1 := server.GenerateToken(mUser.Username, mUser.Role)
 	if err1 != nil {
 		glogger.GLogger.Warn("User Login Failed:", clientIP)
 		internotify.Insert(internotify.BaseEvent{
@@ -214,15 +208,10 @@ func Login(c *gin.Context, ruleEngine typex.Rhilex) {
 		Info: fmt.Sprintf(`User Login Success, Username: %s, RemoteAddr: %s`,
 			u.Username, clientIP),
 	})
-	claims, err := parseToken(token)
-	if err != nil {
-		c.JSON(common.HTTP_OK, common.Error400(err))
-		return
-	}
 	UserDetail := UserDetailVo{
-		Username:    claims.Username,
-		Role:        "ADMIN",
-		Description: "RHILEX ADMIN",
+		Username:    mUser.Username,
+		Role:        model.NormalizeRole(mUser.Role),
+		Description: mUser.Description,
 		Token:       token,
 	}
 	c.JSON(common.HTTP_OK, common.OkWithData(UserDetail))
@@ -255,67 +244,16 @@ type UserDetailVo struct {
  */
 func UserDetail(c *gin.Context, ruleEngine typex.Rhilex) {
 	token := c.GetHeader("Authorization")
-	claims, err := parseToken(token)
+	username, _ := server.CurrentUser(c)
+	mUser, err := service.GetMUser(username)
 	if err != nil {
 		c.JSON(common.HTTP_OK, common.OkWithData(UserDetailVo{}))
 		return
 	}
 	c.JSON(common.HTTP_OK, common.OkWithData(UserDetailVo{
-		Username:    claims.Username,
-		Role:        "ADMIN",
-		Description: "RHILEX ADMIN",
+		Username:    mUser.Username,
+		Role:        model.NormalizeRole(mUser.Role),
+		Description: mUser.Description,
 		Token:       token,
 	}))
 }
-
-type JwtClaims struct {
-	Username string
-	jwt.StandardClaims
-}
-
-/*
-*
-* 生成Token
-*
- */
-func generateToken(username string) (string, error) {
-	claims := &JwtClaims{
-		Username: username,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Duration(60*60*24*7) * time.Second).Unix(),
-			Issuer:    username,
-		},
-	}
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(__SECRET_KEY))
-	return token, err
-}
-
-/*
-*
-* 解析Token
-*
- */
-func parseToken(tokenString string) (*JwtClaims, error) {
-	if tokenString == "" {
-		return nil, fmt.Errorf("expected token string on headers")
-	}
-
-	token, err := jwt.ParseWithClaims(tokenString, &JwtClaims{},
-		func(token *jwt.Token) (any, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(__SECRET_KEY), nil
-		})
-	if err != nil {
-		return nil, err
-	}
-	if token.Claims == nil {
-		return nil, fmt.Errorf("invalid Claims: %v", token.Raw)
-	}
-	if claims, ok := token.Claims.(*JwtClaims); ok && token.Valid {
-		return claims, nil
-	} else {
-		return nil, err
-	}
-}
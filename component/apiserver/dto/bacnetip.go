@@ -34,3 +34,22 @@ type BacnetDataPointCreateOrUpdate struct {
 	ObjectType     string `json:"objectType"`
 	ObjectId       uint32 `json:"objectId"`
 }
+
+// Who-Is 找到的设备
+type BacnetRemoteDeviceVO struct {
+	DeviceId      uint32 `json:"deviceId"`
+	Ip            string `json:"ip"`
+	Port          int    `json:"port"`
+	NetworkNumber int    `json:"networkNumber"`
+	MacMstp       int    `json:"macMstp"`
+	VendorId      uint32 `json:"vendorId"`
+	MaxApdu       uint32 `json:"maxApdu"`
+}
+
+// 设备的对象列表
+type BacnetRemoteObjectVO struct {
+	ObjectType  string `json:"objectType"`
+	ObjectId    uint32 `json:"objectId"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
@@ -19,6 +19,8 @@ import (
 	"github.com/hootrhino/rhilex/component/apiserver/server"
 	"github.com/hootrhino/rhilex/component/apiserver/service"
 	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/component/interflow"
+	"github.com/hootrhino/rhilex/component/luaguard"
 
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
@@ -58,17 +60,21 @@ func initRhilex(engine typex.Rhilex) {
 		ExprDefines := []alarmcenter.ExprDefine{}
 		for _, exprDefine := range mAlarmRule.GetExprDefine() {
 			ExprDefines = append(ExprDefines, alarmcenter.ExprDefine{
-				Expr:      exprDefine.Expr,
-				EventType: exprDefine.EventType,
+				Expr:       exprDefine.Expr,
+				EventType:  exprDefine.EventType,
+				Severity:   exprDefine.Severity,
+				ClearExpr:  exprDefine.ClearExpr,
+				ClearDelay: exprDefine.ClearDelay,
 			})
 		}
-		alarmcenter.LoadAlarmRule(mAlarmRule.UUID, alarmcenter.AlarmRule{
+		if err := alarmcenter.LoadAlarmRule(mAlarmRule.UUID, alarmcenter.AlarmRule{
 			Interval:    time.Duration(mAlarmRule.Interval) * time.Second,
 			Threshold:   mAlarmRule.Threshold,
 			HandleId:    mAlarmRule.HandleId,
 			ExprDefines: ExprDefines,
-		},
-		)
+		}); err != nil {
+			glogger.GLogger.Error("AlarmRule load failed:", err)
+		}
 	}
 	// multimedia
 	for _, Multimedia := range service.AllMultiMedia() {
@@ -77,6 +83,12 @@ func initRhilex(engine typex.Rhilex) {
 			glogger.GLogger.Error("Multimedia load failed:", err)
 		}
 	}
+	// 超出执行预算被禁用的规则重启以后保持禁用
+	for _, mRule := range service.AllMRules() {
+		if mRule.Disabled {
+			luaguard.Disable(mRule.UUID)
+		}
+	}
 	for _, minEnd := range service.AllMInEnd() {
 		if err := server.LoadNewestInEnd(minEnd.UUID, engine); err != nil {
 			glogger.GLogger.Error("InEnd load failed:", err)
@@ -93,6 +105,12 @@ func initRhilex(engine typex.Rhilex) {
 			glogger.GLogger.Error("Device load failed:", err)
 		}
 	}
+	// 流程在资源之后加载, 加载失败的流程不影响其他流程
+	for _, mFlow := range service.AllMFlows() {
+		if err := interflow.LoadFlow(server.GetFlow(&mFlow)); err != nil {
+			glogger.GLogger.Error("Flow load failed:", mFlow.UUID, err)
+		}
+	}
 	//
 	// APP stack
 	//
@@ -102,6 +120,7 @@ func initRhilex(engine typex.Rhilex) {
 			mApp.Name,
 			mApp.Version,
 		)
+		app.Budget = service.GetAppletBudget(&mApp)
 		if err := applet.LoadApp(app, mApp.LuaSource); err != nil {
 			glogger.GLogger.Error(err)
 			continue
@@ -126,6 +145,8 @@ func (hs *ApiServerPlugin) Init(config *ini.Section) error {
 		&model.MInEnd{},
 		&model.MOutEnd{},
 		&model.MRule{},
+		&model.MRuleRevision{},
+		&model.MFlow{},
 		&model.MUser{},
 		&model.MDevice{},
 		&model.MCecolla{},
@@ -143,6 +164,10 @@ func (hs *ApiServerPlugin) Init(config *ini.Section) error {
 		&model.MModbusDataPoint{},
 		&model.MSiemensDataPoint{},
 		&model.MSnmpOid{},
+		&model.MKnxGroupAddress{},
+		&model.MIec104DataPoint{},
+		&model.MOpcuaDataPoint{},
+		&model.MDnp3DataPoint{},
 		&model.MCjt1882004DataPoint{},
 		&model.MDlt6452007DataPoint{},
 		&model.MSzy2062016DataPoint{},
@@ -151,7 +176,11 @@ func (hs *ApiServerPlugin) Init(config *ini.Section) error {
 		&model.MBacnetRouterDataPoint{},
 		&model.MMBusDataPoint{},
 		&model.MCronRebootConfig{},
+		&model.MTokenSecret{},
 	)
+	if err := server.InitTokenSecret(); err != nil {
+		return err
+	}
 	// 初始化所有预制参数
 	server.DefaultApiServer.InitializeProduct()
 	server.DefaultApiServer.InitializeGenericOSData()
@@ -219,6 +248,14 @@ func (hs *ApiServerPlugin) LoadRoute() {
 	apis.InitInternalNotifyRoute()
 	// Snmp Route
 	apis.InitSnmpRoute()
+	// KNX Route
+	apis.InitKnxRoute()
+	// IEC104 Route
+	apis.InitIec104Route()
+	// OPC UA Route
+	apis.InitOpcuaRoute()
+	// DNP3 Route
+	apis.InitDnp3Route()
 	// Bacnet Route
 	apis.InitBacnetIpRoute()
 	// Bacnet Router
@@ -237,8 +274,12 @@ func (hs *ApiServerPlugin) LoadRoute() {
 	apis.LoadAlarmRuleRoute()
 	// 告警日志
 	apis.LoadAlarmLogRoute()
+	// 告警
+	apis.LoadAlarmRoute()
 	// 多媒体
 	apis.InitMultiMediaRoute()
+	// 可视化编排的流程
+	apis.InitFlowRoute()
 }
 
 // ApiServerPlugin Start
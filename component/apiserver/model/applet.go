@@ -28,4 +28,5 @@ type MApplet struct {
 	AutoStart   *bool  `gorm:"not null"`    // 允许启动
 	LuaSource   string `gorm:"not null"`    // LuaSource
 	Description string `gorm:"not null"`    // 文件路径, 是相对于main的apps目录
+	Budget      string `gorm:"default:''"`  // 执行预算, JSON 格式的 typex.LuaBudget
 }
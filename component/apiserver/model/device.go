@@ -24,7 +24,8 @@ type MDevice struct {
 	Name        string `gorm:"not null"`
 	Type        string `gorm:"not null"`
 	Config      string
-	BindRules   StringList `json:"bindRules"` // 与之关联的规则表["A","B","C"]
+	BindRules   StringList `json:"bindRules"`                // 与之关联的规则表["A","B","C"]
+	Tags        StringList `gorm:"default:'[]'" json:"tags"` // 标签, 规则按照标签订阅
 	Description string
 }
 
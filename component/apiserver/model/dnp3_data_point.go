@@ -0,0 +1,33 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+// DNP3 主站和子站共用的测点表
+type MDnp3DataPoint struct {
+	RhilexModel
+	UUID         string
+	DeviceUuid   string   `gorm:"not null"`         // 所属设备
+	PointType    string   `gorm:"not null"`         // BINARY_INPUT | BINARY_OUTPUT | COUNTER | ANALOG_INPUT | ANALOG_OUTPUT
+	PointIndex   uint16   `gorm:"not null"`         // 测点索引
+	Tag          string   `gorm:"not null"`         // 点位名称
+	Alias        string   `gorm:"not null"`         // 别名
+	EventClass   *int     `gorm:"default:0"`        // 子站: 事件类别 0-3
+	SourceDevice string   `gorm:"default:''"`       // 子站: 数据来源设备
+	SourceTag    string   `gorm:"default:''"`       // 子站: 数据来源点位
+	ReportMode   string   `gorm:"default:'ALWAYS'"` // 上报模式
+	Deadband     *Decimal `gorm:"default:0"`        // 死区, 子站用作事件死区
+	MaxSilence   *uint64  `gorm:"default:0"`        // 最长静默时间(秒)
+}
@@ -0,0 +1,30 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+/*
+*
+* 可视化编排的流程, 节点和连线以 JSON 格式保存
+*
+ */
+type MFlow struct {
+	RhilexModel
+	UUID        string `gorm:"not null"`
+	Name        string `gorm:"not null"`
+	Description string
+	Nodes       string `gorm:"not null"` // JSON 格式的 []interflow.FlowNode
+	Edges       string `gorm:"not null"` // JSON 格式的 []interflow.FlowEdge
+}
@@ -0,0 +1,29 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+type MKnxGroupAddress struct {
+	RhilexModel
+	UUID         string
+	DeviceUuid   string   `gorm:"not null"`         // 所属设备
+	GroupAddress string   `gorm:"not null"`         // 1/2/3
+	Dpt          string   `gorm:"not null"`         // 9.001
+	Tag          string   `gorm:"not null"`         // temp
+	Alias        string   `gorm:"not null"`         // 温度
+	ReportMode   string   `gorm:"default:'ALWAYS'"` // 上报模式
+	Deadband     *Decimal `gorm:"default:0"`        // 死区
+	MaxSilence   *uint64  `gorm:"default:0"`        // 最长静默时间(秒)
+}
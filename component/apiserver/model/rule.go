@@ -24,5 +24,31 @@ type MRule struct {
 	Actions     string `gorm:"not null"`
 	Success     string `gorm:"not null"`
 	Failed      string `gorm:"not null"`
+	Selector    string `gorm:"default:''"`    // 订阅的资源, JSON 格式的 typex.RuleSelector
+	Budget      string `gorm:"default:''"`    // 执行预算, JSON 格式的 typex.LuaBudget
+	Version     int    `gorm:"default:0"`     // 线上运行的版本
+	Rollout     string `gorm:"default:''"`    // 灰度发布, JSON 格式的 typex.RuleRollout
+	Disabled    bool   `gorm:"default:false"` // 连续超出执行预算被禁用
 	Description string
 }
+
+/*
+*
+* 规则每次保存生成一个不可修改的版本, 用来查看差异和回滚
+*
+ */
+type MRuleRevision struct {
+	RhilexModel
+	RuleId      string `gorm:"uniqueIndex:idx_rule_revision;not null"`
+	Version     int    `gorm:"uniqueIndex:idx_rule_revision;not null"`
+	Author      string // 保存的用户
+	Message     string // 修改说明
+	Name        string
+	Description string
+	Actions     string
+	Success     string
+	Failed      string
+	Selector    string
+	Budget      string
+	Diff        string // 和上一个版本的差异
+}
@@ -27,4 +27,7 @@ type MSiemensDataPoint struct {
 	DataBlockOrder string   `gorm:"not null"` // 字节序
 	Frequency      *uint64  `gorm:"default:50"`
 	Weight         *Decimal `gorm:"column:weight;default:1"`
+	ReportMode     string   `gorm:"default:'ALWAYS'"` // 上报模式
+	Deadband       *Decimal `gorm:"default:0"`        // 死区
+	MaxSilence     *uint64  `gorm:"default:0"`        // 最长静默时间(秒)
 }
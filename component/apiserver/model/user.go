@@ -15,6 +15,8 @@
 
 package model
 
+import "strings"
+
 type MUser struct {
 	RhilexModel
 	Role        string `gorm:"not null"`
@@ -22,3 +24,40 @@ type MUser struct {
 	Password    string `gorm:"not null"`
 	Description string
 }
+
+// 用户角色
+const (
+	ROLE_ADMIN    string = "ADMIN"    // 管理员, 所有权限
+	ROLE_OPERATOR string = "OPERATOR" // 操作员, 可以操作资源, 但是不能管理用户和系统
+	ROLE_READONLY string = "READONLY" // 只读用户, 只能查看
+)
+
+/*
+*
+* 兼容老版本数据库里面的 "Admin" 这种写法, 未知角色一律当作只读
+*
+ */
+func NormalizeRole(role string) string {
+	switch strings.ToUpper(strings.TrimSpace(role)) {
+	case ROLE_ADMIN:
+		return ROLE_ADMIN
+	case ROLE_OPERATOR:
+		return ROLE_OPERATOR
+	case ROLE_READONLY:
+		return ROLE_READONLY
+	}
+	return ROLE_READONLY
+}
+
+/*
+*
+* 角色是否合法
+*
+ */
+func ValidRole(role string) bool {
+	switch strings.ToUpper(strings.TrimSpace(role)) {
+	case ROLE_ADMIN, ROLE_OPERATOR, ROLE_READONLY:
+		return true
+	}
+	return false
+}
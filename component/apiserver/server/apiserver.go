@@ -96,10 +96,12 @@ func StartRhilexApiServer(ruleEngine typex.Rhilex, port int) {
 		ctx.Next()
 	})
 	server.ginEngine.Use(static.Serve("/", staticFs))
+	// 跨域的响应头先加上, 认证失败的响应浏览器也能读到
+	server.ginEngine.Use(Cros())
 	server.ginEngine.Use(Authorize())
 	server.ginEngine.Use(DecryptMiddleware())
-	server.ginEngine.Use(Cros())
-	server.ginEngine.GET("/ws", glogger.WsLogger)
+	// 实时日志不在 /api/v1 下面, 单独认证
+	server.ginEngine.GET("/ws", AuthorizeWs(model.ROLE_READONLY), glogger.WsLogger)
 	server.ginEngine.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
 		if core.GlobalConfig.DebugMode {
 			utils.WritePanicStack()
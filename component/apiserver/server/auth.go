@@ -15,10 +15,335 @@
 
 package server
 
-import "github.com/gin-gonic/gin"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	response "github.com/hootrhino/rhilex/component/apiserver/common"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+)
+
+const (
+	// Token 有效期 7 天
+	__TOKEN_EXPIRE = time.Duration(60*60*24*7) * time.Second
+	// 写进 gin.Context 的 Key
+	CTX_USERNAME = "rhilex.username"
+	CTX_ROLE     = "rhilex.role"
+)
+
+// Token 签名密钥, 启动的时候从数据库加载
+var __tokenSecret atomic.Value
+
+/*
+*
+* 加载 Token 签名密钥, 第一次启动的时候随机生成一个保存到数据库
+*
+ */
+func InitTokenSecret() error {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return err
+	}
+	secret, err := service.InitTokenSecret(hex.EncodeToString(buffer))
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return fmt.Errorf("token secret is empty")
+	}
+	__tokenSecret.Store([]byte(secret))
+	return nil
+}
+
+func tokenSecret() ([]byte, error) {
+	secret, ok := __tokenSecret.Load().([]byte)
+	if !ok {
+		return nil, fmt.Errorf("token secret not initialized")
+	}
+	return secret, nil
+}
+
+type JwtClaims struct {
+	Username string
+	Role     string
+	jwt.StandardClaims
+}
+
+/*
+*
+* 生成Token
+*
+ */
+func GenerateToken(username, role string) (string, error) {
+	claims := &JwtClaims{
+		Username: username,
+		Role:     model.NormalizeRole(role),
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(__TOKEN_EXPIRE).Unix(),
+			Issuer:    username,
+		},
+	}
+	secret, err := tokenSecret()
+	if err != nil {
+		return "", err
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+/*
+*
+* 解析Token, 兼容 "Bearer xxx" 格式
+*
+ */
+func ParseToken(tokenString string) (*JwtClaims, error) {
+	tokenString = strings.TrimSpace(strings.TrimPrefix(tokenString, "Bearer "))
+	if tokenString == "" {
+		return nil, fmt.Errorf("expected token string on headers")
+	}
+	token, err := jwt.ParseWithClaims(tokenString, &JwtClaims{},
+		func(token *jwt.Token) (any, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return tokenSecret()
+		})
+	if err != nil {
+		return nil, err
+	}
+	if token.Claims == nil {
+		return nil, fmt.Errorf("invalid Claims: %v", token.Raw)
+	}
+	if claims, ok := token.Claims.(*JwtClaims); ok && token.Valid {
+		return claims, nil
+	}
+	return nil, fmt.Errorf("invalid token")
+}
+
+/*
+*
+* 角色等级, 高等级包含低等级的所有权限
+*
+ */
+func roleLevel(role string) int {
+	switch model.NormalizeRole(role) {
+	case model.ROLE_ADMIN:
+		return 3
+	case model.ROLE_OPERATOR:
+		return 2
+	}
+	return 1
+}
+
+/*
+*
+* 判断某个角色是否满足最低要求
+*
+ */
+func RoleAllowed(role, required string) bool {
+	return roleLevel(role) >= roleLevel(required)
+}
+
+/*
+*
+* 路由权限: Read 对应 GET 请求, Write 对应其他请求
+*
+ */
+type routePolicy struct {
+	Prefix string
+	Read   string
+	Write  string
+}
+
+// 不需要认证的路由
+var publicRoutes = []string{
+	"login",
+	"ping",
+	"os/getSecurityLicense",
+}
+
+// 路由组权限表, 按最长前缀匹配, 匹配不到的默认为操作员
+var routePolicies = []routePolicy{
+	// 设备, 规则, 数据中心: 只读用户可以查看
+	{Prefix: "devices", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "rules", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "flows", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "datacenter", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "inends", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "outends", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "schema", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "group", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "alarm_rule", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "alarm_log", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "alarm", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "notify", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "modbus_master_sheet", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "modbus_slaver_sheet", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "s1200_data_sheet", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "snmp_oids_sheet", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "knx_group_sheet", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "opcua_data_sheet", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "iec104_master_sheet", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "dnp3_data_sheet", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "bacnetip_data_sheet", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "bacnet_router_sheet", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "mbus_master_sheet", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "dlt6452007_master_sheet", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "cjt1882004_master_sheet", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "szy2062016_master_sheet", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "user_protocol_sheet", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "statistics", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "menu", Read: model.ROLE_READONLY, Write: model.ROLE_OPERATOR},
+	{Prefix: "os", Read: model.ROLE_READONLY, Write: model.ROLE_ADMIN},
+	// 语法检查不修改任何数据
+	{Prefix: "validateRule", Read: model.ROLE_READONLY, Write: model.ROLE_READONLY},
+	// 用户: 自己的信息和密码所有人可操作, 用户管理只能管理员
+	{Prefix: "users", Read: model.ROLE_ADMIN, Write: model.ROLE_ADMIN},
+	{Prefix: "users/detail", Read: model.ROLE_READONLY, Write: model.ROLE_READONLY},
+	{Prefix: "users/update", Read: model.ROLE_READONLY, Write: model.ROLE_READONLY},
+	{Prefix: "users/logout", Read: model.ROLE_READONLY, Write: model.ROLE_READONLY},
+	// 系统设置只能管理员
+	{Prefix: "settings", Read: model.ROLE_OPERATOR, Write: model.ROLE_ADMIN},
+	{Prefix: "firmware", Read: model.ROLE_OPERATOR, Write: model.ROLE_ADMIN},
+	{Prefix: "backup", Read: model.ROLE_ADMIN, Write: model.ROLE_ADMIN},
+	{Prefix: "cronReboot", Read: model.ROLE_OPERATOR, Write: model.ROLE_ADMIN},
+	{Prefix: "mn4g", Read: model.ROLE_OPERATOR, Write: model.ROLE_ADMIN},
+}
+
+/*
+*
+* 计算某个请求需要的最低角色
+*
+ */
+func requiredRole(method, apiPath string) string {
+	matched := routePolicy{Read: model.ROLE_OPERATOR, Write: model.ROLE_OPERATOR}
+	matchedLen := -1
+	for _, policy := range routePolicies {
+		if apiPath == policy.Prefix || strings.HasPrefix(apiPath, policy.Prefix+"/") {
+			if len(policy.Prefix) > matchedLen {
+				matched = policy
+				matchedLen = len(policy.Prefix)
+			}
+		}
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return matched.Read
+	}
+	return matched.Write
+}
+
+/*
+*
+* 去掉 /api/v1/ 前缀, 非API请求返回false
+*
+ */
+func apiRelativePath(urlPath string) (string, bool) {
+	cleaned := path.Clean("/" + urlPath)
+	root := strings.TrimSuffix(API_V1_ROOT, "/")
+	if cleaned == root {
+		return "", true
+	}
+	if !strings.HasPrefix(cleaned, root+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(cleaned, root+"/"), true
+}
+
+func isPublicRoute(apiPath string) bool {
+	for _, route := range publicRoutes {
+		if apiPath == route {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+*
+* 认证 + 鉴权中间件, 只拦截 /api/v1 下的请求
+*
+ */
 func Authorize() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+		apiPath, isApi := apiRelativePath(c.Request.URL.Path)
+		if !isApi || isPublicRoute(apiPath) {
+			c.Next()
+			return
+		}
+		if !authenticate(c, c.GetHeader("Authorization"),
+			requiredRole(c.Request.Method, apiPath)) {
+			return
+		}
+		c.Next()
+	}
+}
+
+/*
+*
+* 不在 /api/v1 下面的 WebSocket 认证, 浏览器建立 WebSocket 的时候不能带请求头,
+* 所以 Token 也可以放在 token 参数里面
+*
+ */
+func AuthorizeWs(required string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader("Authorization")
+		if token == "" {
+			token = c.Query("token")
+		}
+		if !authenticate(c, token, required) {
+			return
+		}
 		c.Next()
 	}
 }
+
+/*
+*
+* 校验 Token 和角色, 失败的时候直接返回 401 或者 403
+*
+ */
+func authenticate(c *gin.Context, token, required string) bool {
+	claims, err := ParseToken(token)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized,
+			response.Error("Unauthorized: "+err.Error()))
+		return false
+	}
+	// Token 有效期内用户可能被删除或者降级, 角色以数据库里面的为准
+	mUser, err := service.GetMUser(claims.Username)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized,
+			response.Error("Unauthorized: user not exists: "+claims.Username))
+		return false
+	}
+	role := model.NormalizeRole(mUser.Role)
+	if !RoleAllowed(role, required) {
+		c.AbortWithStatusJSON(http.StatusForbidden,
+			response.Error(fmt.Sprintf("Permission denied, role '%s' required", required)))
+		return false
+	}
+	c.Set(CTX_USERNAME, claims.Username)
+	c.Set(CTX_ROLE, role)
+	return true
+}
+
+/*
+*
+* 当前请求的用户名和角色, 由 Authorize 写入
+*
+ */
+func CurrentUser(c *gin.Context) (string, string) {
+	return c.GetString(CTX_USERNAME), model.NormalizeRole(c.GetString(CTX_ROLE))
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRequiredRole(t *testing.T) {
+	cases := []struct {
+		method string
+		path   string
+		role   string
+	}{
+		{http.MethodGet, "devices", model.ROLE_READONLY},
+		{http.MethodGet, "devices/list", model.ROLE_READONLY},
+		{http.MethodPost, "devices/create", model.ROLE_OPERATOR},
+		{http.MethodGet, "devicesX/list", model.ROLE_OPERATOR},
+		// 最长前缀优先
+		{http.MethodGet, "users/list", model.ROLE_ADMIN},
+		{http.MethodPost, "users/update", model.ROLE_READONLY},
+		{http.MethodPost, "users/updateAll", model.ROLE_ADMIN},
+		{http.MethodGet, "os/system", model.ROLE_READONLY},
+		{http.MethodPost, "os/resetInterMetric", model.ROLE_ADMIN},
+		{http.MethodHead, "settings/ntp", model.ROLE_OPERATOR},
+		{http.MethodPut, "settings/ntp", model.ROLE_ADMIN},
+		// 匹配不到的默认为操作员
+		{http.MethodGet, "unknown/path", model.ROLE_OPERATOR},
+		{http.MethodDelete, "", model.ROLE_OPERATOR},
+	}
+	for _, c := range cases {
+		if role := requiredRole(c.method, c.path); role != c.role {
+			t.Errorf("%s %s: expect %s, got %s", c.method, c.path, c.role, role)
+		}
+	}
+}
+
+// 临时目录里面的数据库, 两个用户
+func setupAuthTest(t *testing.T) *gin.Engine {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	gin.SetMode(gin.TestMode)
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		interdb.StopInterDb()
+		os.Chdir(wd)
+	})
+	if err := interdb.InitInterDb(nil); err != nil {
+		t.Fatal(err)
+	}
+	interdb.InterDbRegisterModel(&model.MUser{}, &model.MTokenSecret{})
+	if err := InitTokenSecret(); err != nil {
+		t.Fatal(err)
+	}
+	service.InsertMUser(&model.MUser{Username: "admin", Role: model.ROLE_ADMIN})
+	service.InsertMUser(&model.MUser{Username: "viewer", Role: model.ROLE_READONLY})
+	engine := gin.New()
+	engine.Use(Authorize())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	engine.GET(ContextUrl("devices/list"), ok)
+	engine.POST(ContextUrl("devices/create"), ok)
+	engine.POST(ContextUrl("login"), ok)
+	engine.GET("/index.html", ok)
+	engine.GET("/ws", AuthorizeWs(model.ROLE_READONLY), ok)
+	return engine
+}
+
+func token(t *testing.T, username, role string) string {
+	token, err := GenerateToken(username, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthorize(t *testing.T) {
+	engine := setupAuthTest(t)
+	// 用发布在源码里面的旧密钥伪造的 Token
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, &JwtClaims{
+		Username: "admin",
+		Role:     model.ROLE_ADMIN,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	}).SignedString([]byte("you-can-not-get-this-secret"))
+	cases := []struct {
+		name   string
+		method string
+		url    string
+		token  string
+		status int
+	}{
+		{"no token", http.MethodGet, "/api/v1/devices/list", "", http.StatusUnauthorized},
+		{"forged token", http.MethodGet, "/api/v1/devices/list", forged, http.StatusUnauthorized},
+		{"deleted user", http.MethodGet, "/api/v1/devices/list", token(t, "nobody", model.ROLE_ADMIN), http.StatusUnauthorized},
+		{"readonly read", http.MethodGet, "/api/v1/devices/list", token(t, "viewer", model.ROLE_READONLY), http.StatusOK},
+		{"readonly write", http.MethodPost, "/api/v1/devices/create", token(t, "viewer", model.ROLE_READONLY), http.StatusForbidden},
+		// 角色以数据库里面的为准, 不信任 Token 里面的角色
+		{"stale role", http.MethodPost, "/api/v1/devices/create", token(t, "viewer", model.ROLE_ADMIN), http.StatusForbidden},
+		{"admin write", http.MethodPost, "/api/v1/devices/create", "Bearer " + token(t, "admin", model.ROLE_ADMIN), http.StatusOK},
+		{"public route", http.MethodPost, "/api/v1/login", "", http.StatusOK},
+		{"not api", http.MethodGet, "/index.html", "", http.StatusOK},
+		{"ws no token", http.MethodGet, "/ws", "", http.StatusUnauthorized},
+		{"ws query token", http.MethodGet, "/ws?token=" + token(t, "viewer", model.ROLE_READONLY), "", http.StatusOK},
+	}
+	for _, c := range cases {
+		request := httptest.NewRequest(c.method, c.url, nil)
+		if c.token != "" {
+			request.Header.Set("Authorization", c.token)
+		}
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		if recorder.Code != c.status {
+			t.Errorf("%s: expect %d, got %d", c.name, c.status, recorder.Code)
+		}
+	}
+}
+
+// 重启以后使用数据库里面保存的密钥, 已经签发的 Token 仍然有效
+func TestTokenSecretPersisted(t *testing.T) {
+	setupAuthTest(t)
+	issued := token(t, "admin", model.ROLE_ADMIN)
+	if err := InitTokenSecret(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseToken(issued); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -6,9 +6,12 @@ import (
 
 	"encoding/json"
 
+	"github.com/hootrhino/rhilex/component/apiserver/model"
 	"github.com/hootrhino/rhilex/component/apiserver/service"
+	"github.com/hootrhino/rhilex/component/interflow"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
 )
 
 /*
@@ -50,17 +53,20 @@ func LoadNewestInEnd(uuid string, ruleEngine typex.Rhilex) error {
 			return err1
 		}
 		glogger.GLogger.Debugf("Load rule:(%s,%s)", mRule.UUID, mRule.Name)
-		RuleInstance := typex.NewLuaRule(
-			ruleEngine,
-			mRule.UUID,
-			mRule.Name,
-			mRule.Description,
-			mRule.SourceId,
-			mRule.DeviceId,
-			mRule.Success,
-			mRule.Actions,
-			mRule.Failed)
-		BindRules[mRule.UUID] = *RuleInstance
+		BindRules[mRule.UUID] = *NewRuleInstance(mRule, in.UUID, ruleEngine)
+	}
+	// 按照分组或者通配符订阅的规则
+	for _, mRule := range SelectedRules(typex.RuleResource{
+		Kind:   "INEND",
+		UUID:   mInEnd.UUID,
+		Name:   mInEnd.Name,
+		Type:   mInEnd.Type,
+		Groups: service.GetResourceGroupChain(mInEnd.UUID),
+	}) {
+		if _, ok := BindRules[mRule.UUID]; !ok {
+			glogger.GLogger.Debugf("Load selected rule:(%s,%s)", mRule.UUID, mRule.Name)
+			BindRules[mRule.UUID] = *NewRuleInstance(&mRule, in.UUID, ruleEngine)
+		}
 	}
 	// 最新的规则
 	in.BindRules = BindRules
@@ -142,17 +148,21 @@ func LoadNewestDevice(uuid string, ruleEngine typex.Rhilex) error {
 			return err1
 		}
 		glogger.GLogger.Debugf("Load rule:(%s,%s)", mRule.UUID, mRule.Name)
-		RuleInstance := typex.NewLuaRule(
-			ruleEngine,
-			mRule.UUID,
-			mRule.Name,
-			mRule.Description,
-			mRule.SourceId,
-			mRule.DeviceId,
-			mRule.Success,
-			mRule.Actions,
-			mRule.Failed)
-		BindRules[mRule.UUID] = *RuleInstance
+		BindRules[mRule.UUID] = *NewRuleInstance(mRule, dev.UUID, ruleEngine)
+	}
+	// 按照分组或者通配符订阅的规则
+	for _, mRule := range SelectedRules(typex.RuleResource{
+		Kind:   "DEVICE",
+		UUID:   mDevice.UUID,
+		Name:   mDevice.Name,
+		Type:   mDevice.Type,
+		Groups: service.GetResourceGroupChain(mDevice.UUID),
+		Tags:   mDevice.Tags,
+	}) {
+		if _, ok := BindRules[mRule.UUID]; !ok {
+			glogger.GLogger.Debugf("Load selected rule:(%s,%s)", mRule.UUID, mRule.Name)
+			BindRules[mRule.UUID] = *NewRuleInstance(&mRule, dev.UUID, ruleEngine)
+		}
 	}
 	// 最新的规则
 	dev.BindRules = BindRules
@@ -169,3 +179,146 @@ func LoadNewestDevice(uuid string, ruleEngine typex.Rhilex) error {
 	return nil
 
 }
+
+/*
+*
+* 规则订阅的资源, 老版本的规则只有 SourceId 和 DeviceId
+*
+ */
+func GetRuleSelector(mRule *model.MRule) typex.RuleSelector {
+	selector := typex.RuleSelector{}
+	if mRule.Selector != "" {
+		if err := json.Unmarshal([]byte(mRule.Selector), &selector); err != nil {
+			glogger.GLogger.Error("Rule selector invalid:", mRule.UUID, err)
+		}
+	}
+	if mRule.SourceId != "" && !utils.SContains(selector.Sources, mRule.SourceId) {
+		selector.Sources = append([]string{mRule.SourceId}, selector.Sources...)
+	}
+	if mRule.DeviceId != "" && !utils.SContains(selector.Devices, mRule.DeviceId) {
+		selector.Devices = append([]string{mRule.DeviceId}, selector.Devices...)
+	}
+	return selector
+}
+
+// 规则的执行预算, 没有配置的时候全部使用全局配置
+func GetRuleBudget(mRule *model.MRule) typex.LuaBudget {
+	return decodeRuleBudget(mRule.UUID, mRule.Budget)
+}
+
+func decodeRuleBudget(ruleId, data string) typex.LuaBudget {
+	budget := typex.LuaBudget{}
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), &budget); err != nil {
+			glogger.GLogger.Error("Rule budget invalid:", ruleId, err)
+		}
+	}
+	return budget
+}
+
+// 灰度发布的配置, 没有灰度的时候 Mode 为空
+func GetRuleRollout(mRule *model.MRule) typex.RuleRollout {
+	rollout := typex.RuleRollout{}
+	if mRule.Rollout != "" {
+		if err := json.Unmarshal([]byte(mRule.Rollout), &rollout); err != nil {
+			glogger.GLogger.Error("Rule rollout invalid:", mRule.UUID, err)
+		}
+	}
+	return rollout
+}
+
+/*
+*
+* 数据库里面的规则转成运行实例, 每个绑定的资源一个实例. 灰度发布的时候,
+* 选中的资源运行待发布的版本; 影子模式下线上版本带一个待发布版本的影子实例
+*
+ */
+func NewRuleInstance(mRule *model.MRule, resource string, ruleEngine typex.Rhilex) *typex.Rule {
+	RuleInstance := typex.NewLuaRule(
+		ruleEngine,
+		mRule.UUID,
+		mRule.Name,
+		mRule.Description,
+		mRule.SourceId,
+		mRule.DeviceId,
+		mRule.Success,
+		mRule.Actions,
+		mRule.Failed)
+	RuleInstance.Selector = GetRuleSelector(mRule)
+	RuleInstance.Budget = GetRuleBudget(mRule)
+	RuleInstance.Version = mRule.Version
+	rollout := GetRuleRollout(mRule)
+	if !rollout.Active() {
+		return RuleInstance
+	}
+	revision, err := service.GetMRuleRevision(mRule.UUID, rollout.Version)
+	if err != nil {
+		glogger.GLogger.Error("Rule rollout revision not exists:", mRule.UUID, rollout.Version)
+		return RuleInstance
+	}
+	// 订阅的资源发布以后才生效, 灰度只替换脚本和预算
+	candidate := typex.NewLuaRule(
+		ruleEngine,
+		mRule.UUID,
+		mRule.Name,
+		mRule.Description,
+		mRule.SourceId,
+		mRule.DeviceId,
+		revision.Success,
+		revision.Actions,
+		revision.Failed)
+	candidate.Selector = RuleInstance.Selector
+	candidate.Budget = decodeRuleBudget(mRule.UUID, revision.Budget)
+	candidate.Version = revision.Version
+	if rollout.Canary(mRule.UUID, resource) {
+		RuleInstance.LuaVM.Close()
+		return candidate
+	}
+	if rollout.Mode == typex.ROLLOUT_SHADOW {
+		RuleInstance.Shadow = candidate
+		return RuleInstance
+	}
+	candidate.LuaVM.Close()
+	return RuleInstance
+}
+
+/*
+*
+* 按照分组或者通配符订阅了资源的规则, 直接列出 UUID 的绑定保存在资源的 BindRules 里面
+*
+ */
+func SelectedRules(resource typex.RuleResource) []model.MRule {
+	rules := []model.MRule{}
+	for _, mRule := range service.AllMRules() {
+		if mRule.Selector == "" {
+			continue
+		}
+		selector := GetRuleSelector(&mRule)
+		if selector.Dynamic() && selector.Match(resource) {
+			rules = append(rules, mRule)
+		}
+	}
+	return rules
+}
+
+/*
+*
+* 数据库里面的流程, JSON 格式错误的时候节点或者连线为空, 加载的时候校验不通过
+*
+ */
+func GetFlow(mFlow *model.MFlow) interflow.Flow {
+	flow := interflow.Flow{
+		UUID:        mFlow.UUID,
+		Name:        mFlow.Name,
+		Description: mFlow.Description,
+		Nodes:       []interflow.FlowNode{},
+		Edges:       []interflow.FlowEdge{},
+	}
+	if err := json.Unmarshal([]byte(mFlow.Nodes), &flow.Nodes); err != nil {
+		glogger.GLogger.Error("Flow nodes invalid:", mFlow.UUID, err)
+	}
+	if err := json.Unmarshal([]byte(mFlow.Edges), &flow.Edges); err != nil {
+		glogger.GLogger.Error("Flow edges invalid:", mFlow.UUID, err)
+	}
+	return flow
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/hootrhino/rhilex/component/eventbus"
 	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/intermetric"
+	"github.com/hootrhino/rhilex/component/lostcache"
 	"github.com/hootrhino/rhilex/component/supervisor"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
@@ -14,7 +16,7 @@ import (
 
 /*
 *
-* 南向资源监控器 5秒检查一下状态
+* 南向资源监控器 5秒检查一下状态, DOWN 以后按照重启策略退避重启
 *
  */
 func StartInSupervisor(InCtx context.Context, in *typex.InEnd, ruleEngine typex.Rhilex) {
@@ -24,6 +26,7 @@ func StartInSupervisor(InCtx context.Context, in *typex.InEnd, ruleEngine typex.
 	SuperVisor := supervisor.RegisterSuperVisor(in.UUID)
 	glogger.GLogger.Debugf("Register SuperVisor For InEnd:%s", SuperVisor.SlaverId)
 	defer supervisor.UnRegisterSuperVisor(SuperVisor.SlaverId)
+	Policy := supervisor.ResolveBackoffPolicy(in.Config)
 	for {
 		select {
 		case <-context.Background().Done():
@@ -74,17 +77,29 @@ func StartInSupervisor(InCtx context.Context, in *typex.InEnd, ruleEngine typex.
 				Ts:      uint64(time.Now().UnixMilli()),
 				Payload: ErrMsg,
 			})
-			time.Sleep(4 * time.Second)
+			if !waitForRestart(SuperVisor.Ctx, "SOURCE", "inend", UUID, currentIn.Name, Policy, ErrMsg) {
+				if supervisor.IsRestartFailed(UUID) {
+					currentIn.Source.Stop()
+				}
+				return
+			}
+			if ruleEngine.GetInEnd(UUID) == nil {
+				return
+			}
+			intermetric.IncRestart(intermetric.KIND_INEND, UUID)
 			go LoadNewestInEnd(UUID, ruleEngine)
 			return
 		}
+		if currentIn.Source.Status() == typex.SOURCE_UP {
+			supervisor.MarkUp(UUID, Policy)
+		}
 		<-ticker.C
 	}
 }
 
 /*
 *
-* 北向资源监控器 5秒检查一下状态
+* 北向资源监控器 5秒检查一下状态, DOWN 以后按照重启策略退避重启
 *
  */
 func StartOutSupervisor(OutCtx context.Context, out *typex.OutEnd, ruleEngine typex.Rhilex) {
@@ -94,6 +109,7 @@ func StartOutSupervisor(OutCtx context.Context, out *typex.OutEnd, ruleEngine ty
 	SuperVisor := supervisor.RegisterSuperVisor(out.UUID)
 	glogger.GLogger.Debugf("Register SuperVisor For OutEnd:%s", SuperVisor.SlaverId)
 	defer supervisor.UnRegisterSuperVisor(SuperVisor.SlaverId)
+	Policy := supervisor.ResolveBackoffPolicy(out.Config)
 
 	for {
 		select {
@@ -120,6 +136,7 @@ func StartOutSupervisor(OutCtx context.Context, out *typex.OutEnd, ruleEngine ty
 		}
 		// 资源可能不会及时DOWN
 		if currentOut.Target.Status() == typex.SOURCE_DOWN {
+			lostcache.MarkOutboxDown(UUID)
 			ErrMsg := ""
 			Slot := intercache.GetSlot("__DefaultRuleEngine")
 			if Slot != nil {
@@ -140,17 +157,31 @@ func StartOutSupervisor(OutCtx context.Context, out *typex.OutEnd, ruleEngine ty
 				Ts:      uint64(time.Now().UnixMilli()),
 				Payload: ErrMsg,
 			})
-			time.Sleep(4 * time.Second)
+			if !waitForRestart(SuperVisor.Ctx, "TARGET", "outend", UUID, currentOut.Name, Policy, ErrMsg) {
+				if supervisor.IsRestartFailed(UUID) {
+					currentOut.Target.Stop()
+				}
+				return
+			}
+			if ruleEngine.GetOutEnd(UUID) == nil {
+				return
+			}
+			intermetric.IncRestart(intermetric.KIND_OUTEND, UUID)
 			go LoadNewestOutEnd(UUID, ruleEngine)
 			return
 		}
+		if currentOut.Target.Status() == typex.SOURCE_UP {
+			supervisor.MarkUp(UUID, Policy)
+			// 目标恢复以后补发积压的数据
+			lostcache.ReplayOutbox(currentOut)
+		}
 		<-ticker.C
 	}
 }
 
 /*
 *
-* 设备监控器 5秒检查一下状态
+* 设备监控器 5秒检查一下状态, DOWN 以后按照重启策略退避重启
 *
  */
 func StartDeviceSupervisor(DeviceCtx context.Context, device *typex.Device, ruleEngine typex.Rhilex) {
@@ -160,7 +191,8 @@ func StartDeviceSupervisor(DeviceCtx context.Context, device *typex.Device, rule
 	SuperVisor := supervisor.RegisterSuperVisor(device.UUID)
 	glogger.GLogger.Debugf("Register SuperVisor For Device:%s", SuperVisor.SlaverId)
 	defer supervisor.UnRegisterSuperVisor(SuperVisor.SlaverId)
-
+	Policy := supervisor.ResolveBackoffPolicy(device.Config)
+	reportedUp := false
 	for {
 		select {
 		case <-context.Background().Done():
@@ -210,10 +242,70 @@ func StartDeviceSupervisor(DeviceCtx context.Context, device *typex.Device, rule
 				Ts:      uint64(time.Now().UnixMilli()),
 				Payload: ErrMsg,
 			})
-			time.Sleep(4 * time.Second)
+			if !waitForRestart(SuperVisor.Ctx, "DEVICE", "device", UUID, currentDevice.Name, Policy, ErrMsg) {
+				if supervisor.IsRestartFailed(UUID) {
+					currentDevice.Device.Stop()
+				}
+				return
+			}
+			if ruleEngine.GetDevice(UUID) == nil {
+				return
+			}
+			intermetric.IncRestart(intermetric.KIND_DEVICE, UUID)
 			go LoadNewestDevice(UUID, ruleEngine)
 			return
 		}
+		if currentDeviceStatus == typex.SOURCE_UP {
+			supervisor.MarkUp(UUID, Policy)
+			// 每次加载以后第一次检查到 UP 通知一次, 北向的订阅者据此刷新设备状态
+			if !reportedUp {
+				reportedUp = true
+				lineS := "event.device.up." + UUID
+				eventbus.Publish(lineS, eventbus.EventMessage{
+					Topic:   lineS,
+					From:    "res-supervisor",
+					Type:    "DEVICE",
+					Event:   lineS,
+					Ts:      uint64(time.Now().UnixMilli()),
+					Payload: "",
+				})
+			}
+		}
 		<-ticker.C
 	}
 }
+
+/*
+*
+* 按照重启策略等待, 返回 false 表示不再重启: 连续重启次数超过上限进入 FAILED,
+* 或者等待的时候资源被重新加载、删除, 监控器被取消
+*
+ */
+func waitForRestart(Ctx context.Context, Type, Kind, UUID, Name string,
+	Policy supervisor.BackoffPolicy, ErrMsg string) bool {
+	Delay, ok := supervisor.NextRestart(UUID, Policy, ErrMsg)
+	if !ok {
+		glogger.GLogger.Errorf("Resource:(%s,%s) restarted %d times without staying UP, marked FAILED, error message: %s",
+			UUID, Name, Policy.MaxAttempts, ErrMsg)
+		lineS := "event." + Kind + ".failed." + UUID
+		eventbus.Publish(lineS, eventbus.EventMessage{
+			Topic:   lineS,
+			From:    "res-supervisor",
+			Type:    Type,
+			Event:   lineS,
+			Ts:      uint64(time.Now().UnixMilli()),
+			Payload: ErrMsg,
+		})
+		return false
+	}
+	glogger.GLogger.Debugf("Resource:(%s,%s) will restart after %v", UUID, Name, Delay)
+	timer := time.NewTimer(Delay)
+	defer timer.Stop()
+	select {
+	case <-Ctx.Done():
+		glogger.GLogger.Debugf("SuperVisor Context cancel:%v, restart canceled", UUID)
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"github.com/hootrhino/rhilex/alarmcenter"
+)
+
+// -------------------------------------------------------------------------------------
+// Alarm Dao
+// -------------------------------------------------------------------------------------
+
+func GetMAlarmWithUUID(uuid string) (*alarmcenter.MAlarm, error) {
+	m := alarmcenter.MAlarm{}
+	return &m, alarmcenter.AlarmDb().Where("uuid=?", uuid).First(&m).Error
+}
+
+// 删除Alarm
+func DeleteAlarm(uuid string) error {
+	return alarmcenter.AlarmDb().Where("uuid=?", uuid).Delete(&alarmcenter.MAlarm{}).Error
+}
+
+// 分页, state 和 ruleId 为空的时候不过滤
+func PageAlarm(state, ruleId string, current, size int) (int64, []alarmcenter.MAlarm) {
+	MAlarms := []alarmcenter.MAlarm{}
+	tx := alarmcenter.AlarmDb().Model(&alarmcenter.MAlarm{})
+	if state != "" {
+		tx = tx.Where("state=?", state)
+	}
+	if ruleId != "" {
+		tx = tx.Where("rule_id=?", ruleId)
+	}
+	var count int64
+	tx.Count(&count)
+	offset := (current - 1) * size
+	tx.Order("created_at DESC").Limit(size).Offset(offset).Find(&MAlarms)
+	return count, MAlarms
+}
@@ -16,8 +16,12 @@
 package service
 
 import (
+	"encoding/json"
+
 	"github.com/hootrhino/rhilex/component/apiserver/model"
 	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
 )
 
 // -------------------------------------------------------------------------------------
@@ -60,3 +64,14 @@ func UpdateApp(app *model.MApplet) error {
 		return nil
 	}
 }
+
+// App 的执行预算, 没有配置的时候使用默认值
+func GetAppletBudget(app *model.MApplet) typex.LuaBudget {
+	budget := typex.LuaBudget{}
+	if app.Budget != "" {
+		if err := json.Unmarshal([]byte(app.Budget), &budget); err != nil {
+			glogger.GLogger.Error("App budget invalid:", app.UUID, err)
+		}
+	}
+	return budget
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/interdb"
+)
+
+/*
+*
+* DNP3测点表管理
+*
+ */
+// InsertDnp3DataPoints 批量插入测点
+func InsertDnp3DataPoints(list []model.MDnp3DataPoint) error {
+	m := model.MDnp3DataPoint{}
+	return interdb.InterDb().Model(m).Create(list).Error
+}
+
+// InsertDnp3DataPoint 插入测点
+func InsertDnp3DataPoint(P model.MDnp3DataPoint) error {
+	return interdb.InterDb().Model(P).Create(&P).Error
+}
+
+// DeleteDnp3DataPointByDevice 删除设备的部分测点
+func DeleteDnp3DataPointByDevice(uuids []string, deviceUuid string) error {
+	return interdb.InterDb().
+		Where("uuid IN ? AND device_uuid=?", uuids, deviceUuid).
+		Delete(&model.MDnp3DataPoint{}).Error
+}
+
+// DeleteAllDnp3DataPointByDevice 删除设备的所有测点
+func DeleteAllDnp3DataPointByDevice(deviceUuid string) error {
+	return interdb.InterDb().
+		Where("device_uuid=?", deviceUuid).
+		Delete(&model.MDnp3DataPoint{}).Error
+}
+
+// UpdateDnp3DataPoint 更新测点
+func UpdateDnp3DataPoint(MDnp3DataPoint model.MDnp3DataPoint) error {
+	return interdb.InterDb().Model(model.MDnp3DataPoint{}).
+		Where("device_uuid=? AND uuid=?",
+			MDnp3DataPoint.DeviceUuid, MDnp3DataPoint.UUID).
+		Updates(MDnp3DataPoint).Error
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/interdb"
+)
+
+/*
+*
+* IEC104信息体地址表管理
+*
+ */
+// InsertIec104DataPoints 批量插入组地址
+func InsertIec104DataPoints(list []model.MIec104DataPoint) error {
+	m := model.MIec104DataPoint{}
+	return interdb.InterDb().Model(m).Create(list).Error
+}
+
+// InsertIec104DataPoint 插入组地址
+func InsertIec104DataPoint(P model.MIec104DataPoint) error {
+	return interdb.InterDb().Model(P).Create(&P).Error
+}
+
+// DeleteIec104DataPointByDevice 删除设备的部分组地址
+func DeleteIec104DataPointByDevice(uuids []string, deviceUuid string) error {
+	return interdb.InterDb().
+		Where("uuid IN ? AND device_uuid=?", uuids, deviceUuid).
+		Delete(&model.MIec104DataPoint{}).Error
+}
+
+// DeleteAllIec104DataPointByDevice 删除设备的所有组地址
+func DeleteAllIec104DataPointByDevice(deviceUuid string) error {
+	return interdb.InterDb().
+		Where("device_uuid=?", deviceUuid).
+		Delete(&model.MIec104DataPoint{}).Error
+}
+
+// UpdateIec104DataPoint 更新组地址
+func UpdateIec104DataPoint(MIec104DataPoint model.MIec104DataPoint) error {
+	return interdb.InterDb().Model(model.MIec104DataPoint{}).
+		Where("device_uuid=? AND uuid=?",
+			MIec104DataPoint.DeviceUuid, MIec104DataPoint.UUID).
+		Updates(MIec104DataPoint).Error
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/interdb"
+)
+
+/*
+*
+* KNX组地址表管理
+*
+ */
+// InsertKnxGroupAddresses 批量插入组地址
+func InsertKnxGroupAddresses(list []model.MKnxGroupAddress) error {
+	m := model.MKnxGroupAddress{}
+	return interdb.InterDb().Model(m).Create(list).Error
+}
+
+// InsertKnxGroupAddress 插入组地址
+func InsertKnxGroupAddress(P model.MKnxGroupAddress) error {
+	return interdb.InterDb().Model(P).Create(&P).Error
+}
+
+// DeleteKnxGroupAddressByDevice 删除设备的部分组地址
+func DeleteKnxGroupAddressByDevice(uuids []string, deviceUuid string) error {
+	return interdb.InterDb().
+		Where("uuid IN ? AND device_uuid=?", uuids, deviceUuid).
+		Delete(&model.MKnxGroupAddress{}).Error
+}
+
+// DeleteAllKnxGroupAddressByDevice 删除设备的所有组地址
+func DeleteAllKnxGroupAddressByDevice(deviceUuid string) error {
+	return interdb.InterDb().
+		Where("device_uuid=?", deviceUuid).
+		Delete(&model.MKnxGroupAddress{}).Error
+}
+
+// UpdateKnxGroupAddress 更新组地址
+func UpdateKnxGroupAddress(MKnxGroupAddress model.MKnxGroupAddress) error {
+	return interdb.InterDb().Model(model.MKnxGroupAddress{}).
+		Where("device_uuid=? AND uuid=?",
+			MKnxGroupAddress.DeviceUuid, MKnxGroupAddress.UUID).
+		Updates(MKnxGroupAddress).Error
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/interdb"
+)
+
+/*
+*
+* OPC UA 点位表管理
+*
+ */
+// InsertOpcuaDataPoints 批量插入点位
+func InsertOpcuaDataPoints(list []model.MOpcuaDataPoint) error {
+	m := model.MOpcuaDataPoint{}
+	return interdb.InterDb().Model(m).Create(list).Error
+}
+
+// InsertOpcuaDataPoint 插入点位
+func InsertOpcuaDataPoint(P model.MOpcuaDataPoint) error {
+	return interdb.InterDb().Model(P).Create(&P).Error
+}
+
+// DeleteOpcuaDataPointByDevice 删除设备的部分点位
+func DeleteOpcuaDataPointByDevice(uuids []string, deviceUuid string) error {
+	return interdb.InterDb().
+		Where("uuid IN ? AND device_uuid=?", uuids, deviceUuid).
+		Delete(&model.MOpcuaDataPoint{}).Error
+}
+
+// DeleteAllOpcuaDataPointByDevice 删除设备的所有点位
+func DeleteAllOpcuaDataPointByDevice(deviceUuid string) error {
+	return interdb.InterDb().
+		Where("device_uuid=?", deviceUuid).
+		Delete(&model.MOpcuaDataPoint{}).Error
+}
+
+// UpdateOpcuaDataPoint 更新点位
+func UpdateOpcuaDataPoint(MOpcuaDataPoint model.MOpcuaDataPoint) error {
+	return interdb.InterDb().Model(model.MOpcuaDataPoint{}).
+		Where("device_uuid=? AND uuid=?",
+			MOpcuaDataPoint.DeviceUuid, MOpcuaDataPoint.UUID).
+		Updates(MOpcuaDataPoint).Error
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"github.com/hootrhino/rhilex/component/apiserver/model"
+	"github.com/hootrhino/rhilex/component/interdb"
+)
+
+func GetMFlowWithUUID(uuid string) (*model.MFlow, error) {
+	m := new(model.MFlow)
+	return m, interdb.InterDb().Where("uuid=?", uuid).First(m).Error
+}
+
+func AllMFlows() []model.MFlow {
+	flows := []model.MFlow{}
+	interdb.InterDb().Find(&flows)
+	return flows
+}
+
+func InsertMFlow(m *model.MFlow) error {
+	return interdb.InterDb().Create(m).Error
+}
+
+// 描述可以清空, 所以用 map 更新
+func UpdateMFlow(uuid string, m *model.MFlow) error {
+	return interdb.InterDb().Model(&model.MFlow{}).Where("uuid=?", uuid).
+		Updates(map[string]any{
+			"name":        m.Name,
+			"description": m.Description,
+			"nodes":       m.Nodes,
+			"edges":       m.Edges,
+		}).Error
+}
+
+func DeleteMFlow(uuid string) error {
+	return interdb.InterDb().Where("uuid=?", uuid).Delete(&model.MFlow{}).Error
+}
@@ -225,3 +225,27 @@ SELECT m_generic_groups.*
 	interdb.InterDb().Raw(sql, rid).Find(&m)
 	return m
 }
+
+/*
+*
+* 资源所在的分组以及全部上级分组, 规则按照分组订阅的时候用
+*
+ */
+func GetResourceGroupChain(rid string) []string {
+	groups := []string{}
+	group := GetResourceGroup(rid)
+	visited := map[string]bool{}
+	for group.UUID != "" && !visited[group.UUID] {
+		visited[group.UUID] = true
+		groups = append(groups, group.UUID)
+		if group.Parent == "" || group.Parent == "0" {
+			break
+		}
+		parent, err := GetGenericGroupWithUUID(group.Parent)
+		if err != nil {
+			break
+		}
+		group = *parent
+	}
+	return groups
+}
@@ -15,8 +15,11 @@
 package service
 
 import (
+	"errors"
+
 	"github.com/hootrhino/rhilex/component/apiserver/model"
 	"github.com/hootrhino/rhilex/component/interdb"
+	"gorm.io/gorm"
 )
 
 // -----------------------------------------------------------------------------------
@@ -46,6 +49,88 @@ func UpdateMRule(uuid string, r *model.MRule) error {
 	return interdb.InterDb().Model(r).Where("uuid=?", uuid).Updates(*r).Error
 }
 
+// 更新规则订阅的资源, 清空绑定的时候空字符串也要写进去
+func UpdateMRuleSelector(uuid, sourceId, deviceId, selector string) error {
+	return interdb.InterDb().Model(&model.MRule{}).Where("uuid=?", uuid).
+		Updates(map[string]any{
+			"source_id": sourceId,
+			"device_id": deviceId,
+			"selector":  selector,
+		}).Error
+}
+
+// 更新线上运行的版本和灰度发布的配置
+func UpdateMRuleRollout(uuid string, version int, rollout string) error {
+	return interdb.InterDb().Model(&model.MRule{}).Where("uuid=?", uuid).
+		Updates(map[string]any{
+			"version": version,
+			"rollout": rollout,
+		}).Error
+}
+
+// 超出执行预算被禁用的状态, 重新启用的时候清掉
+func UpdateMRuleDisabled(uuid string, disabled bool) error {
+	return interdb.InterDb().Model(&model.MRule{}).Where("uuid=?", uuid).
+		Update("disabled", disabled).Error
+}
+
+// -----------------------------------------------------------------------------------
+// 规则的历史版本
+// -----------------------------------------------------------------------------------
+func InsertMRuleRevision(r *model.MRuleRevision) error {
+	return interdb.InterDb().Create(r).Error
+}
+
+/*
+*
+* 在事务里面分配版本号并保存: next 根据最新的版本(没有历史的时候为 nil)返回要保存的版本.
+* (rule_id, version) 是唯一索引, 并发保存的时候后提交的失败, 不会出现重复的版本号
+*
+ */
+func InsertNextMRuleRevision(ruleId string,
+	next func(latest *model.MRuleRevision) ([]*model.MRuleRevision, error)) error {
+	return interdb.InterDb().Transaction(func(tx *gorm.DB) error {
+		latest := new(model.MRuleRevision)
+		err := tx.Where("rule_id=?", ruleId).Order("version DESC").First(latest).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			latest = nil
+		} else if err != nil {
+			return err
+		}
+		revisions, err := next(latest)
+		if err != nil {
+			return err
+		}
+		for _, revision := range revisions {
+			if err := tx.Create(revision).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func GetMRuleRevision(ruleId string, version int) (*model.MRuleRevision, error) {
+	m := new(model.MRuleRevision)
+	return m, interdb.InterDb().Where("rule_id=? AND version=?", ruleId, version).First(m).Error
+}
+
+// 最新的版本
+func GetLatestMRuleRevision(ruleId string) (*model.MRuleRevision, error) {
+	m := new(model.MRuleRevision)
+	return m, interdb.InterDb().Where("rule_id=?", ruleId).Order("version DESC").First(m).Error
+}
+
+// 所有版本, 新的在前
+func GetMRuleRevisions(ruleId string) ([]model.MRuleRevision, error) {
+	m := []model.MRuleRevision{}
+	return m, interdb.InterDb().Where("rule_id=?", ruleId).Order("version DESC").Find(&m).Error
+}
+
+func DeleteMRuleRevisions(ruleId string) error {
+	return interdb.InterDb().Where("rule_id=?", ruleId).Delete(&model.MRuleRevision{}).Error
+}
+
 // -----------------------------------------------------------------------------------
 func AllMRules() []model.MRule {
 	rules := []model.MRule{}
@@ -46,3 +46,15 @@ func UpdateMUser(oldName string, o *model.MUser) error {
 		Where("username=?", oldName).
 		Updates(*o).Error
 }
+
+/*
+*
+* 读取 Token 签名密钥, 没有的时候保存传进来的新密钥
+*
+ */
+func InitTokenSecret(secret string) (string, error) {
+	m := model.MTokenSecret{}
+	err := interdb.InterDb().Where(model.MTokenSecret{}).
+		Attrs(model.MTokenSecret{Secret: secret}).FirstOrCreate(&m).Error
+	return m.Secret, err
+}
@@ -0,0 +1,153 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package deadband
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 上报模式
+const (
+	REPORT_ALWAYS   string = "ALWAYS"   // 每次采集都上报, 默认值
+	REPORT_CHANGE   string = "CHANGE"   // 值变化才上报
+	REPORT_ABSOLUTE string = "ABSOLUTE" // 和上次上报的值差值超过绝对死区才上报
+	REPORT_PERCENT  string = "PERCENT"  // 和上次上报的值差值超过百分比死区才上报
+)
+
+/*
+*
+* 点位的上报策略, 所有点位表共用
+*
+ */
+type ReportPolicy struct {
+	ReportMode string  `json:"reportMode"` // ALWAYS | CHANGE | ABSOLUTE | PERCENT
+	Deadband   float64 `json:"deadband"`   // 死区, ABSOLUTE 为绝对值, PERCENT 为百分比
+	MaxSilence uint64  `json:"maxSilence"` // 最长静默时间(秒), 超过以后强制上报一次, 0 表示不限制
+}
+
+/*
+*
+* 检查上报策略
+*
+ */
+func (p ReportPolicy) Validate() error {
+	switch p.ReportMode {
+	case "", REPORT_ALWAYS, REPORT_CHANGE:
+	case REPORT_ABSOLUTE, REPORT_PERCENT:
+		if p.Deadband < 0 {
+			return fmt.Errorf("'deadband' must be greater than or equal to 0")
+		}
+	default:
+		return fmt.Errorf("invalid report mode '%s', must be one of: %s, %s, %s, %s",
+			p.ReportMode, REPORT_ALWAYS, REPORT_CHANGE, REPORT_ABSOLUTE, REPORT_PERCENT)
+	}
+	return nil
+}
+
+// 是否每次都上报
+func (p ReportPolicy) Always() bool {
+	return p.ReportMode == "" || p.ReportMode == REPORT_ALWAYS
+}
+
+// 上一次上报的值
+type lastReport struct {
+	value string
+	ts    time.Time
+}
+
+/*
+*
+* 按点位过滤上报, 一个设备一个
+*
+ */
+type Filter struct {
+	locker sync.Mutex
+	last   map[string]lastReport
+}
+
+func NewFilter() *Filter {
+	return &Filter{last: map[string]lastReport{}}
+}
+
+/*
+*
+* 是否需要上报, 需要上报的话会记住这次的值
+*
+ */
+func (f *Filter) Allow(key string, policy ReportPolicy, value string) bool {
+	return f.AllowAt(key, policy, value, time.Now())
+}
+
+func (f *Filter) AllowAt(key string, policy ReportPolicy, value string, now time.Time) bool {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	last, ok := f.last[key]
+	if ok && !policy.Always() && !silenceExpired(policy, last, now) &&
+		!changed(policy, last.value, value) {
+		return false
+	}
+	f.last[key] = lastReport{value: value, ts: now}
+	return true
+}
+
+// 清空记录, 一般是设备重启的时候
+func (f *Filter) Reset() {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	f.last = map[string]lastReport{}
+}
+
+func silenceExpired(policy ReportPolicy, last lastReport, now time.Time) bool {
+	if policy.MaxSilence == 0 {
+		return false
+	}
+	return now.Sub(last.ts) >= time.Duration(policy.MaxSilence)*time.Second
+}
+
+/*
+*
+* 和上次上报的值比较, 不是数字的时候按字符串比较
+*
+ */
+func changed(policy ReportPolicy, lastValue, value string) bool {
+	if policy.ReportMode == REPORT_CHANGE {
+		return lastValue != value
+	}
+	lastFloat, err1 := strconv.ParseFloat(strings.TrimSpace(lastValue), 64)
+	newFloat, err2 := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err1 != nil || err2 != nil {
+		return lastValue != value
+	}
+	delta := math.Abs(newFloat - lastFloat)
+	switch policy.ReportMode {
+	case REPORT_ABSOLUTE:
+		if policy.Deadband == 0 {
+			return delta > 0
+		}
+		return delta > policy.Deadband
+	case REPORT_PERCENT:
+		if lastFloat == 0 || policy.Deadband == 0 {
+			return delta > 0
+		}
+		return delta > math.Abs(lastFloat)*policy.Deadband/100
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package deadband
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Filter_Deadband(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		policy ReportPolicy
+		values []string
+		expect []bool
+	}{
+		{ReportPolicy{ReportMode: REPORT_ALWAYS}, []string{"1", "1", "1"}, []bool{true, true, true}},
+		{ReportPolicy{ReportMode: REPORT_CHANGE}, []string{"1", "1", "2", "2"}, []bool{true, false, true, false}},
+		// 和上次上报的值比较, 不是和上次采集的值比较
+		{ReportPolicy{ReportMode: REPORT_ABSOLUTE, Deadband: 1}, []string{"10", "10.6", "11.2", "11"},
+			[]bool{true, false, true, false}},
+		{ReportPolicy{ReportMode: REPORT_PERCENT, Deadband: 10}, []string{"100", "105", "111", "115"},
+			[]bool{true, false, true, false}},
+		// 非数字按字符串比较
+		{ReportPolicy{ReportMode: REPORT_ABSOLUTE, Deadband: 5}, []string{"ON", "ON", "OFF"},
+			[]bool{true, false, true}},
+	}
+	for i, c := range cases {
+		f := NewFilter()
+		for j, v := range c.values {
+			if got := f.AllowAt("tag", c.policy, v, now); got != c.expect[j] {
+				t.Fatalf("case %d value %d: expect %v, got %v", i, j, c.expect[j], got)
+			}
+		}
+	}
+}
+
+func Test_Filter_MaxSilence(t *testing.T) {
+	now := time.Now()
+	f := NewFilter()
+	policy := ReportPolicy{ReportMode: REPORT_CHANGE, MaxSilence: 60}
+	if !f.AllowAt("tag", policy, "1", now) {
+		t.Fatal("first value must be reported")
+	}
+	if f.AllowAt("tag", policy, "1", now.Add(59*time.Second)) {
+		t.Fatal("unchanged value must be filtered before max silence")
+	}
+	if !f.AllowAt("tag", policy, "1", now.Add(60*time.Second)) {
+		t.Fatal("heartbeat must be reported after max silence")
+	}
+}
+
+func Test_ReportPolicy_Validate(t *testing.T) {
+	if err := (ReportPolicy{ReportMode: "FOO"}).Validate(); err == nil {
+		t.Fatal("invalid mode must be rejected")
+	}
+	if err := (ReportPolicy{ReportMode: REPORT_PERCENT, Deadband: -1}).Validate(); err == nil {
+		t.Fatal("negative deadband must be rejected")
+	}
+	if err := (ReportPolicy{}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -48,6 +48,11 @@ type Subscriber struct {
 	Callback func(topic string, msg EventMessage) // 回调函数
 }
 
+// 新建订阅者, 同一个主题下面按照 ID 区分不同的订阅者
+func NewSubscriber(id string, callback func(topic string, msg EventMessage)) *Subscriber {
+	return &Subscriber{id: id, Callback: callback}
+}
+
 type TrieNode struct {
 	Subscribers map[string]*Subscriber
 	Children    map[string]*TrieNode
@@ -0,0 +1,195 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gatewaytag
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+/*
+*
+* 网关点位: 设备点位表里面的一行或者物模型的一个属性, 北向的服务端(OPC UA, DNP3 子站)按照它导出数据
+*
+ */
+type Tag struct {
+	Key         string // 缓存里面的键, 点位表是点位 UUID, 物模型是属性名
+	Name        string // 点位 Tag, 设备内唯一
+	DataType    string // 点位表或者物模型里面的原始类型, 比如 FLOAT32, BOOL, INTEGER
+	Writable    bool
+	Description string
+}
+
+// 点位表里面需要的列
+type sheetPoint struct {
+	UUID     string
+	Tag      string
+	Alias    string
+	DataType string
+	Function int // Modbus 功能码
+}
+
+// 物模型属性
+type schemaProperty struct {
+	Name  string
+	Label string
+	Type  string
+	Rw    string
+}
+
+/*
+*
+* 加载设备的点位: Modbus 寄存器, 西门子地址, SNMP OID; 设备配置了物模型的时候再加上物模型属性.
+* 重名的点位只保留第一个
+*
+ */
+func Load(device *typex.Device) ([]Tag, error) {
+	points := []sheetPoint{}
+	var err error
+	switch device.Type {
+	case typex.GENERIC_MODBUS_MASTER:
+		err = interdb.InterDb().Table("m_modbus_data_points").
+			Select("uuid, tag, alias, data_type, function").
+			Where("device_uuid=?", device.UUID).Find(&points).Error
+	case typex.SIEMENS_PLC:
+		err = interdb.InterDb().Table("m_siemens_data_points").
+			Select("uuid, tag, alias, data_block_type AS data_type").
+			Where("device_uuid=?", device.UUID).Find(&points).Error
+	case typex.GENERIC_SNMP:
+		err = interdb.InterDb().Table("m_snmp_oids").
+			Select("uuid, tag, alias").
+			Where("device_uuid=?", device.UUID).Find(&points).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	tags := []Tag{}
+	names := map[string]bool{}
+	for _, point := range points {
+		if point.Tag == "" || names[point.Tag] {
+			continue
+		}
+		names[point.Tag] = true
+		tag := Tag{
+			Key:         point.UUID,
+			Name:        point.Tag,
+			DataType:    point.DataType,
+			Description: point.Alias,
+		}
+		switch device.Type {
+		case typex.GENERIC_MODBUS_MASTER:
+			// 只支持写单个线圈和单个保持寄存器
+			tag.Writable = point.Function == 1 || point.Function == 3
+		case typex.SIEMENS_PLC:
+			tag.Writable = true
+		}
+		tags = append(tags, tag)
+	}
+	schemaId, _ := device.Config["schemaId"].(string)
+	if schemaId == "" {
+		return tags, nil
+	}
+	properties := []schemaProperty{}
+	if err := interdb.InterDb().Table("m_iot_properties").
+		Select("name, label, type, rw").
+		Where("schema_id=?", schemaId).Find(&properties).Error; err != nil {
+		return nil, err
+	}
+	for _, property := range properties {
+		if property.Name == "" || names[property.Name] {
+			continue
+		}
+		names[property.Name] = true
+		tags = append(tags, Tag{
+			Key:         property.Name,
+			Name:        property.Name,
+			DataType:    property.Type,
+			Writable:    strings.Contains(strings.ToUpper(property.Rw), "W"),
+			Description: property.Label,
+		})
+	}
+	return tags, nil
+}
+
+// 点位的最新值
+type Value struct {
+	Value     string
+	Cached    bool // 缓存里面有这个点位, 设备停止以后缓存被清空
+	Status    int  // 1 正常, 0 没有数据或者采集失败
+	ErrMsg    string
+	Timestamp time.Time
+}
+
+// 最新一次采集成功
+func (v Value) Good() bool {
+	return v.Cached && v.Status == 1
+}
+
+// 设备还没有采集到数据
+func (v Value) Waiting() bool {
+	return v.Cached && v.Status != 1 && v.ErrMsg == "--"
+}
+
+// 设备停止以后缓存被清空
+func (v Value) Offline() bool {
+	return !v.Cached
+}
+
+/*
+*
+* 从缓存里面取设备最新采集的数据, 状态原样返回, 由调用方决定怎么处理
+*
+ */
+func Read(deviceId string, tag Tag) Value {
+	cache := intercache.GetValue(deviceId, tag.Key)
+	value := Value{
+		Value:  fmt.Sprintf("%v", cache.Value),
+		Cached: cache.UUID != "",
+		Status: cache.Status,
+		ErrMsg: cache.ErrMsg,
+	}
+	if cache.LastFetchTime > 0 {
+		value.Timestamp = time.UnixMilli(int64(cache.LastFetchTime))
+	}
+	return value
+}
+
+/*
+*
+* 写点位交给设备的 OnCtrl, 按照 Tag 写
+*
+ */
+func Write(e typex.Rhilex, deviceId string, tag Tag, value string) error {
+	device := e.GetDevice(deviceId)
+	if device == nil || device.Device == nil {
+		return fmt.Errorf("device not exists: %s", deviceId)
+	}
+	if !tag.Writable {
+		return fmt.Errorf("tag is not writable: %s", tag.Name)
+	}
+	args, err := json.Marshal(map[string]string{"tag": tag.Name, "value": value})
+	if err != nil {
+		return err
+	}
+	_, err = device.Device.OnCtrl([]byte("WriteToSheetRegisterWithTag"), args)
+	return err
+}
@@ -0,0 +1,36 @@
+package gatewaytag
+
+import (
+	"testing"
+
+	"github.com/hootrhino/rhilex/component/intercache"
+)
+
+// 西门子 PLC 采集成功和失败以后写进缓存的值
+func TestReadSiemensCache(t *testing.T) {
+	intercache.InitGlobalValueRegistry(nil)
+	intercache.RegisterSlot("S7")
+	intercache.SetValue("S7", "P1", intercache.CacheValue{
+		UUID: "P1", Status: 1, LastFetchTime: 1000, Value: "12.5",
+	})
+	intercache.SetValue("S7", "P2", intercache.CacheValue{
+		UUID: "P2", Status: 0, LastFetchTime: 1000, ErrMsg: "read timeout",
+	})
+	intercache.SetValue("S7", "P3", intercache.CacheValue{
+		UUID: "P3", Status: 0, Value: "0", ErrMsg: "--",
+	})
+	good := Read("S7", Tag{Key: "P1"})
+	if !good.Good() || good.Value != "12.5" || good.Timestamp.UnixMilli() != 1000 {
+		t.Fatalf("unexpected value: %+v", good)
+	}
+	failed := Read("S7", Tag{Key: "P2"})
+	if failed.Good() || failed.Waiting() || failed.Offline() || failed.ErrMsg != "read timeout" {
+		t.Fatalf("unexpected value: %+v", failed)
+	}
+	if waiting := Read("S7", Tag{Key: "P3"}); waiting.Good() || !waiting.Waiting() {
+		t.Fatalf("unexpected value: %+v", waiting)
+	}
+	if offline := Read("S7", Tag{Key: "P4"}); !offline.Offline() || offline.Good() {
+		t.Fatalf("unexpected value: %+v", offline)
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package interflow
+
+import (
+	"fmt"
+
+	"github.com/itchyny/gojq"
+)
+
+// 节点类型
+const (
+	NODE_INPUT  string = "input"  // 数据来源: 输入资源或者设备
+	NODE_FILTER string = "filter" // jq 表达式为真的时候继续
+	NODE_MAP    string = "map"    // jq 表达式转换数据
+	NODE_WINDOW string = "window" // 窗口聚合, 窗口关闭的时候输出聚合结果
+	NODE_ALARM  string = "alarm"  // 数据送到告警规则, 数据原样继续往下走
+	NODE_SWITCH string = "switch" // 按照第一个为真的分支输出
+	NODE_SINK   string = "sink"   // 输出到 OutEnd
+)
+
+// 分支节点没有匹配到任何分支的时候走的端口
+const PORT_DEFAULT string = "default"
+
+// 一个流程最多的节点数
+const maxNodes = 256
+
+/*
+*
+* 可视化编排的流程: 节点和连线组成一个有向无环图, 以 JSON 格式保存
+*
+ */
+type Flow struct {
+	UUID        string     `json:"uuid"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Nodes       []FlowNode `json:"nodes"`
+	Edges       []FlowEdge `json:"edges"`
+}
+
+/*
+*
+* 节点, 不同类型使用不同的字段:
+* input: kind, resource; filter, map: expr; window: key, expr, size, step;
+* alarm: alarmRuleId; switch: cases; sink: resource
+*
+ */
+type FlowNode struct {
+	ID          string        `json:"id"`
+	Type        string        `json:"type"`
+	Name        string        `json:"name"`
+	Kind        string        `json:"kind,omitempty"`        // input: INEND 或者 DEVICE
+	Resource    string        `json:"resource,omitempty"`    // input: 资源 UUID; sink: OutEnd UUID
+	Expr        string        `json:"expr,omitempty"`        // jq 表达式, window 里面是取样本值的表达式
+	Key         string        `json:"key,omitempty"`         // window: 窗口名称
+	Size        int64         `json:"size,omitempty"`        // window: 窗口大小, 毫秒
+	Step        int64         `json:"step,omitempty"`        // window: 滑动步长, 毫秒, 0 表示滚动窗口
+	AlarmRuleId string        `json:"alarmRuleId,omitempty"` // alarm: 告警规则
+	Cases       []FlowCase    `json:"cases,omitempty"`       // switch: 按顺序判断的分支
+	Position    *FlowPosition `json:"position,omitempty"`    // 编辑器里面的位置, 执行的时候不用
+}
+
+type FlowCase struct {
+	Port string `json:"port"`
+	Expr string `json:"expr"`
+}
+
+type FlowPosition struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// 连线, Port 只有分支节点出来的线才需要
+type FlowEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Port string `json:"port,omitempty"`
+}
+
+/*
+*
+* 校验流程: 节点配置, 连线, 不能有环, 所有节点都要能从输入节点到达
+*
+ */
+func (f Flow) Validate() error {
+	if len(f.Nodes) == 0 {
+		return fmt.Errorf("flow has no nodes")
+	}
+	if len(f.Nodes) > maxNodes {
+		return fmt.Errorf("flow has too many nodes: %d, max: %d", len(f.Nodes), maxNodes)
+	}
+	nodes := map[string]FlowNode{}
+	windows := map[string]string{}
+	for _, node := range f.Nodes {
+		if node.ID == "" {
+			return fmt.Errorf("node id can not be empty")
+		}
+		if _, ok := nodes[node.ID]; ok {
+			return fmt.Errorf("duplicate node id: %s", node.ID)
+		}
+		if err := node.validate(); err != nil {
+			return fmt.Errorf("node %s: %w", node.ID, err)
+		}
+		// 窗口按照流程和 key 保存, key 相同的两个节点会共用一个窗口
+		if node.Type == NODE_WINDOW {
+			if id, ok := windows[node.Key]; ok {
+				return fmt.Errorf("node %s: duplicate window key '%s' with node %s", node.ID, node.Key, id)
+			}
+			windows[node.Key] = node.ID
+		}
+		nodes[node.ID] = node
+	}
+	incoming := map[string]int{}
+	outgoing := map[string][]string{}
+	for _, edge := range f.Edges {
+		from, ok := nodes[edge.From]
+		if !ok {
+			return fmt.Errorf("edge from unknown node: %s", edge.From)
+		}
+		to, ok := nodes[edge.To]
+		if !ok {
+			return fmt.Errorf("edge to unknown node: %s", edge.To)
+		}
+		if from.Type == NODE_SINK {
+			return fmt.Errorf("sink node can not have outputs: %s", from.ID)
+		}
+		if to.Type == NODE_INPUT {
+			return fmt.Errorf("input node can not have inputs: %s", to.ID)
+		}
+		if from.Type == NODE_SWITCH {
+			if !from.hasPort(edge.Port) {
+				return fmt.Errorf("switch node %s has no port: %s", from.ID, edge.Port)
+			}
+		} else if edge.Port != "" {
+			return fmt.Errorf("only switch node has ports: %s", from.ID)
+		}
+		for _, id := range outgoing[edge.From] {
+			if id == edge.To {
+				return fmt.Errorf("duplicate edge: %s -> %s", edge.From, edge.To)
+			}
+		}
+		incoming[edge.To]++
+		outgoing[edge.From] = append(outgoing[edge.From], edge.To)
+	}
+	// 拓扑排序检查环
+	queue := []string{}
+	for _, node := range f.Nodes {
+		if incoming[node.ID] == 0 {
+			if node.Type != NODE_INPUT {
+				return fmt.Errorf("node %s is not reachable from any input", node.ID)
+			}
+			queue = append(queue, node.ID)
+		}
+	}
+	if len(queue) == 0 {
+		return fmt.Errorf("flow has no input node")
+	}
+	visited := 0
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, next := range outgoing[id] {
+			incoming[next]--
+			if incoming[next] == 0 {
+				queue = append(queue, next)
+			}
+		}
+	}
+	if visited != len(f.Nodes) {
+		return fmt.Errorf("flow has cycles")
+	}
+	return nil
+}
+
+func (node FlowNode) validate() error {
+	switch node.Type {
+	case NODE_INPUT:
+		if node.Kind != "INEND" && node.Kind != "DEVICE" {
+			return fmt.Errorf("input kind must be INEND or DEVICE")
+		}
+		if node.Resource == "" {
+			return fmt.Errorf("input resource can not be empty")
+		}
+	case NODE_FILTER, NODE_MAP:
+		if _, err := compileJq(node.Expr); err != nil {
+			return err
+		}
+	case NODE_WINDOW:
+		if node.Key == "" {
+			return fmt.Errorf("window key can not be empty")
+		}
+		if node.Size <= 0 {
+			return fmt.Errorf("window size must be greater than 0")
+		}
+		if node.Step < 0 || node.Step > node.Size {
+			return fmt.Errorf("window step must be in range [0, size]")
+		}
+		if _, err := compileJq(node.Expr); err != nil {
+			return err
+		}
+	case NODE_ALARM:
+		if node.AlarmRuleId == "" {
+			return fmt.Errorf("alarm rule can not be empty")
+		}
+	case NODE_SWITCH:
+		if len(node.Cases) == 0 {
+			return fmt.Errorf("switch has no cases")
+		}
+		ports := map[string]bool{}
+		for _, c := range node.Cases {
+			if c.Port == "" || c.Port == PORT_DEFAULT {
+				return fmt.Errorf("invalid switch port: '%s'", c.Port)
+			}
+			if ports[c.Port] {
+				return fmt.Errorf("duplicate switch port: %s", c.Port)
+			}
+			ports[c.Port] = true
+			if _, err := compileJq(c.Expr); err != nil {
+				return fmt.Errorf("case %s: %w", c.Port, err)
+			}
+		}
+	case NODE_SINK:
+		if node.Resource == "" {
+			return fmt.Errorf("sink resource can not be empty")
+		}
+	default:
+		return fmt.Errorf("unknown node type: %s", node.Type)
+	}
+	return nil
+}
+
+func (node FlowNode) hasPort(port string) bool {
+	if port == PORT_DEFAULT {
+		return true
+	}
+	for _, c := range node.Cases {
+		if c.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// 按照类型列出节点, 比如输入节点订阅的资源
+func (f Flow) NodesOf(nodeType string) []FlowNode {
+	nodes := []FlowNode{}
+	for _, node := range f.Nodes {
+		if node.Type == nodeType {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func compileJq(expr string) (*gojq.Code, error) {
+	if expr == "" {
+		return nil, fmt.Errorf("jq expression can not be empty")
+	}
+	query, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jq expression '%s': %w", expr, err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jq expression '%s': %w", expr, err)
+	}
+	return code, nil
+}
@@ -0,0 +1,251 @@
+package interflow
+
+import (
+	"strings"
+	"testing"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/interwindow"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/sirupsen/logrus"
+)
+
+type mockRhilex struct {
+	typex.Rhilex
+}
+
+func (mockRhilex) GetOutEnd(uuid string) *typex.OutEnd {
+	return &typex.OutEnd{UUID: uuid}
+}
+
+func testFlow() Flow {
+	return Flow{
+		UUID: "FLOW_TEST",
+		Name: "test",
+		Nodes: []FlowNode{
+			{ID: "in", Type: NODE_INPUT, Kind: "DEVICE", Resource: "DEVICE1"},
+			{ID: "filter", Type: NODE_FILTER, Expr: ".temp != null"},
+			{ID: "map", Type: NODE_MAP, Expr: "{t: .temp}"},
+			{ID: "switch", Type: NODE_SWITCH, Cases: []FlowCase{{Port: "hot", Expr: ".t > 30"}}},
+			{ID: "hot", Type: NODE_SINK, Resource: "OUT_HOT"},
+			{ID: "normal", Type: NODE_SINK, Resource: "OUT_NORMAL"},
+		},
+		Edges: []FlowEdge{
+			{From: "in", To: "filter"},
+			{From: "filter", To: "map"},
+			{From: "map", To: "switch"},
+			{From: "switch", To: "hot", Port: "hot"},
+			{From: "switch", To: "normal", Port: PORT_DEFAULT},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := testFlow().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	cycle := testFlow()
+	cycle.Nodes = append(cycle.Nodes, FlowNode{ID: "map2", Type: NODE_MAP, Expr: "."})
+	cycle.Edges = append(cycle.Edges, FlowEdge{From: "map", To: "map2"}, FlowEdge{From: "map2", To: "map"})
+	if err := cycle.Validate(); err == nil || !strings.Contains(err.Error(), "cycles") {
+		t.Fatal(err)
+	}
+	orphan := testFlow()
+	orphan.Nodes = append(orphan.Nodes, FlowNode{ID: "orphan", Type: NODE_SINK, Resource: "OUT"})
+	if err := orphan.Validate(); err == nil || !strings.Contains(err.Error(), "not reachable") {
+		t.Fatal(err)
+	}
+	port := testFlow()
+	port.Edges[4].Port = "cold"
+	if err := port.Validate(); err == nil || !strings.Contains(err.Error(), "no port") {
+		t.Fatal(err)
+	}
+	expr := testFlow()
+	expr.Nodes[2].Expr = "{t: "
+	if err := expr.Validate(); err == nil || !strings.Contains(err.Error(), "invalid jq") {
+		t.Fatal(err)
+	}
+	windows := testFlow()
+	windows.Nodes = append(windows.Nodes,
+		FlowNode{ID: "w1", Type: NODE_WINDOW, Key: "temp", Expr: ".t", Size: 1000},
+		FlowNode{ID: "w2", Type: NODE_WINDOW, Key: "temp", Expr: ".t", Size: 2000})
+	windows.Edges = append(windows.Edges, FlowEdge{From: "map", To: "w1"}, FlowEdge{From: "map", To: "w2"})
+	if err := windows.Validate(); err == nil || !strings.Contains(err.Error(), "duplicate window key") {
+		t.Fatal(err)
+	}
+}
+
+// 两个分支汇聚到同一个节点, 一次执行只输出一次
+func TestExecuteFanIn(t *testing.T) {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	pushed := []string{}
+	e := &flowEngine{rx: mockRhilex{}, flows: map[string]*runtime{},
+		push: func(out *typex.OutEnd, data string) error {
+			pushed = append(pushed, data)
+			return nil
+		}}
+	f := Flow{
+		UUID: "FLOW_FANIN",
+		Name: "fan-in",
+		Nodes: []FlowNode{
+			{ID: "in", Type: NODE_INPUT, Kind: "DEVICE", Resource: "DEVICE1"},
+			{ID: "hot", Type: NODE_FILTER, Expr: ".temp > 30"},
+			{ID: "humid", Type: NODE_FILTER, Expr: ".humi > 80"},
+			{ID: "out", Type: NODE_SINK, Resource: "OUT"},
+		},
+		Edges: []FlowEdge{
+			{From: "in", To: "hot"},
+			{From: "in", To: "humid"},
+			{From: "hot", To: "out"},
+			{From: "humid", To: "out"},
+		},
+	}
+	r, err := compile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := typex.RuleResource{Kind: "DEVICE", UUID: "DEVICE1"}
+	for _, data := range []string{`{"temp":35,"humi":90}`, `{"temp":20,"humi":90}`, `{"temp":20,"humi":50}`} {
+		if err := r.execute(e, device, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pushed) != 2 {
+		t.Fatal(pushed)
+	}
+	script, err := f.ToLua()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(script, `if visited["out"] then`) || strings.Contains(script, `if visited["hot"] then`) {
+		t.Fatal(script)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	pushed := map[string][]string{}
+	e := &flowEngine{rx: mockRhilex{}, flows: map[string]*runtime{},
+		push: func(out *typex.OutEnd, data string) error {
+			pushed[out.UUID] = append(pushed[out.UUID], data)
+			return nil
+		}}
+	r, err := compile(testFlow())
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := typex.RuleResource{Kind: "DEVICE", UUID: "DEVICE1"}
+	for _, data := range []string{`{"temp":35}`, `{"temp":20}`, `{"humi":50}`} {
+		if err := r.execute(e, device, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pushed["OUT_HOT"]) != 1 || pushed["OUT_HOT"][0] != `{"t":35}` {
+		t.Fatal(pushed)
+	}
+	if len(pushed["OUT_NORMAL"]) != 1 || pushed["OUT_NORMAL"][0] != `{"t":20}` {
+		t.Fatal(pushed)
+	}
+	if r.accepts(typex.RuleResource{Kind: "INEND", UUID: "DEVICE1"}) {
+		t.Fatal("should not accept inend")
+	}
+}
+
+func TestExecuteError(t *testing.T) {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	f := testFlow()
+	f.Nodes[2].Expr = "{t: .temp | tonumber}"
+	r, err := compile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &flowEngine{rx: mockRhilex{}, flows: map[string]*runtime{},
+		push: func(out *typex.OutEnd, data string) error { return nil }}
+	err = r.execute(e, typex.RuleResource{Kind: "DEVICE", UUID: "DEVICE1"}, `{"temp":"abc"}`)
+	if err == nil || !strings.Contains(err.Error(), "node map") {
+		t.Fatal(err)
+	}
+	// 不是 JSON 的数据按照字符串处理
+	err = r.execute(e, typex.RuleResource{Kind: "DEVICE", UUID: "DEVICE1"}, `not json`)
+	if err == nil || !strings.Contains(err.Error(), "node filter") {
+		t.Fatal(err)
+	}
+}
+
+// 定时器关闭的窗口从窗口节点往下走
+func TestFlushWindow(t *testing.T) {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	pushed := []string{}
+	InitFlowEngine(mockRhilex{}, func(out *typex.OutEnd, data string) error {
+		pushed = append(pushed, data)
+		return nil
+	})
+	f := Flow{
+		UUID: "FLOW_WINDOW",
+		Name: "window",
+		Nodes: []FlowNode{
+			{ID: "in", Type: NODE_INPUT, Kind: "DEVICE", Resource: "DEVICE1"},
+			{ID: "avg", Type: NODE_WINDOW, Key: "temp", Expr: ".temp", Size: 60000},
+			{ID: "out", Type: NODE_SINK, Resource: "OUT"},
+		},
+		Edges: []FlowEdge{{From: "in", To: "avg"}, {From: "avg", To: "out"}},
+	}
+	if err := LoadFlow(f); err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveFlow(f.UUID)
+	device := typex.RuleResource{Kind: "DEVICE", UUID: "DEVICE1"}
+	flushWindow(f.UUID, "avg", device, []*interwindow.Result{
+		{Key: "temp", End: 60000, Count: 1, Avg: 20},
+		{Key: "temp", End: 120000, Count: 1, Avg: 30},
+	})
+	if len(pushed) != 2 || !strings.Contains(pushed[1], `"avg":30`) {
+		t.Fatal(pushed)
+	}
+	flushWindow(f.UUID, "in", device, []*interwindow.Result{{Key: "temp"}})
+	if len(pushed) != 2 {
+		t.Fatal("only window nodes can be flushed", pushed)
+	}
+	if stat, _ := GetFlowStatus(f.UUID); stat.Executions != 1 || stat.Failures != 0 {
+		t.Fatalf("unexpected status: %+v", stat)
+	}
+}
+
+func TestToLua(t *testing.T) {
+	f := testFlow()
+	f.Nodes = append(f.Nodes,
+		FlowNode{ID: "window", Type: NODE_WINDOW, Key: "avg\"temp", Expr: ".t", Size: 60000},
+		FlowNode{ID: "alarm", Type: NODE_ALARM, AlarmRuleId: "ALARM1"})
+	f.Edges = append(f.Edges, FlowEdge{From: "map", To: "window"}, FlowEdge{From: "window", To: "alarm"})
+	script, err := f.ToLua()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{
+		`window:Tumbling("avg\"temp", 60000, value)`,
+		`window:OnClose("avg\"temp", function(output)`,
+		`for _, output in ipairs(outputs) do`,
+		`alarm:Input("ALARM1", ctx.uuid, __encode(input))`,
+		`data:ToTarget("OUT_HOT", __encode(input))`,
+		`if ctx.kind == "DEVICE" and ctx.uuid == "DEVICE1" then`,
+	} {
+		if !strings.Contains(script, expect) {
+			t.Fatal(expect, "\n", script)
+		}
+	}
+	L := lua.NewState()
+	defer L.Close()
+	if err := L.DoString(script); err != nil {
+		t.Fatal(err, "\n", script)
+	}
+	if L.GetGlobal("Actions").Type() != lua.LTTable {
+		t.Fatal("Actions not found")
+	}
+}
+
+func TestLuaQuote(t *testing.T) {
+	if q := luaQuote("a\"b\\c\n\x01"); q != `"a\"b\\c\n\001"` {
+		t.Fatal(q)
+	}
+}
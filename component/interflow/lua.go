@@ -0,0 +1,217 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package interflow
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 导出的脚本里面的公共函数, jq 库的输入输出都是数组, 这里包一层取第一个结果
+const luaHelpers = `local function __jq(expr, input)
+    local r = jq:Execute(".[0] | (" .. expr .. ")", "[" .. json:T2J(input) .. "]")
+    if r == nil then
+        return nil
+    end
+    return json:J2T(r)[1]
+end
+
+local function __encode(input)
+    if type(input) == "string" then
+        return input
+    end
+    return json:T2J(input)
+end
+
+local function __fork(visited)
+    local copy = {}
+    for k, v in pairs(visited) do
+        copy[k] = v
+    end
+    return copy
+end
+`
+
+/*
+*
+* 转成等价的 Lua 规则脚本: 每个节点一个函数, 处理完以后按照连线的顺序调用下游节点,
+* 规则的 Actions 按照数据来源调用对应的输入节点. visited 和流程一样让汇聚节点在一次
+* 执行里面只执行一次. 导出以后和流程没有关联
+*
+ */
+func (f Flow) ToLua() (string, error) {
+	if err := f.Validate(); err != nil {
+		return "", err
+	}
+	names := map[string]string{}
+	locals := []string{}
+	for i, n := range f.Nodes {
+		names[n.ID] = fmt.Sprintf("node_%d", i+1)
+		locals = append(locals, names[n.ID])
+	}
+	outgoing := map[string][]FlowEdge{}
+	incoming := map[string]int{}
+	for _, edge := range f.Edges {
+		outgoing[edge.From] = append(outgoing[edge.From], edge)
+		incoming[edge.To]++
+	}
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "-- Generated from flow: %s\n", f.Name)
+	sb.WriteString(luaHelpers)
+	sb.WriteString("\n")
+	fmt.Fprintf(sb, "local %s\n", strings.Join(locals, ", "))
+	for _, n := range f.Nodes {
+		sb.WriteString("\n")
+		fmt.Fprintf(sb, "-- %s: %s %s\n", n.Type, n.ID, n.Name)
+		fmt.Fprintf(sb, "%s = function(input, ctx, visited)\n", names[n.ID])
+		if incoming[n.ID] > 1 {
+			fmt.Fprintf(sb, "    if visited[%s] then\n", luaQuote(n.ID))
+			sb.WriteString("        return\n")
+			sb.WriteString("    end\n")
+			fmt.Fprintf(sb, "    visited[%s] = true\n", luaQuote(n.ID))
+		}
+		writeNodeBody(sb, n, outgoing[n.ID], names)
+		sb.WriteString("end\n")
+	}
+	sb.WriteString("\nActions = {\n")
+	sb.WriteString("    function(args, ctx)\n")
+	sb.WriteString("        local input = json:J2T(args)\n")
+	sb.WriteString("        if input == nil then\n")
+	sb.WriteString("            input = args\n")
+	sb.WriteString("        end\n")
+	sb.WriteString("        local visited = {}\n")
+	for _, n := range f.NodesOf(NODE_INPUT) {
+		fmt.Fprintf(sb, "        if ctx.kind == %s and ctx.uuid == %s then\n",
+			luaQuote(n.Kind), luaQuote(n.Resource))
+		fmt.Fprintf(sb, "            %s(input, ctx, visited)\n", names[n.ID])
+		sb.WriteString("        end\n")
+	}
+	sb.WriteString("        return true, args\n")
+	sb.WriteString("    end\n")
+	sb.WriteString("}\n")
+	return sb.String(), nil
+}
+
+func writeNodeBody(sb *strings.Builder, n FlowNode, edges []FlowEdge, names map[string]string) {
+	next := func(indent, value, port string) {
+		for _, edge := range edges {
+			if edge.Port == port {
+				fmt.Fprintf(sb, "%s%s(%s, ctx, visited)\n", indent, names[edge.To], value)
+			}
+		}
+	}
+	logError := func(indent string) {
+		fmt.Fprintf(sb, "%sDebug(%s .. err)\n", indent, luaQuote("[flow] node "+n.ID+": "))
+	}
+	switch n.Type {
+	case NODE_INPUT:
+		next("    ", "input", "")
+	case NODE_FILTER:
+		fmt.Fprintf(sb, "    if not __jq(%s, input) then\n", luaQuote(n.Expr))
+		sb.WriteString("        return\n")
+		sb.WriteString("    end\n")
+		next("    ", "input", "")
+	case NODE_MAP:
+		fmt.Fprintf(sb, "    local output = __jq(%s, input)\n", luaQuote(n.Expr))
+		sb.WriteString("    if output == nil then\n")
+		sb.WriteString("        return\n")
+		sb.WriteString("    end\n")
+		next("    ", "output", "")
+	case NODE_WINDOW:
+		fmt.Fprintf(sb, "    local value = __jq(%s, input)\n", luaQuote(n.Expr))
+		if n.Step == 0 || n.Step == n.Size {
+			fmt.Fprintf(sb, "    local outputs, err = window:Tumbling(%s, %d, value)\n",
+				luaQuote(n.Key), n.Size)
+		} else {
+			fmt.Fprintf(sb, "    local outputs, err = window:Sliding(%s, %d, %d, value)\n",
+				luaQuote(n.Key), n.Size, n.Step)
+		}
+		sb.WriteString("    if err ~= nil then\n")
+		logError("        ")
+		sb.WriteString("        return\n")
+		sb.WriteString("    end\n")
+		// 和流程一样, 空闲的时候定时器关闭的窗口也往下走
+		fmt.Fprintf(sb, "    window:OnClose(%s, function(output)\n", luaQuote(n.Key))
+		sb.WriteString("        local visited = {}\n")
+		next("        ", "output", "")
+		sb.WriteString("    end)\n")
+		sb.WriteString("    if outputs == nil then\n")
+		sb.WriteString("        return\n")
+		sb.WriteString("    end\n")
+		sb.WriteString("    local parent = visited\n")
+		sb.WriteString("    for _, output in ipairs(outputs) do\n")
+		sb.WriteString("        local visited = parent\n")
+		sb.WriteString("        if #outputs > 1 then\n")
+		sb.WriteString("            visited = __fork(parent)\n")
+		sb.WriteString("        end\n")
+		next("        ", "output", "")
+		sb.WriteString("    end\n")
+	case NODE_ALARM:
+		fmt.Fprintf(sb, "    local err = alarm:Input(%s, ctx.uuid, __encode(input))\n",
+			luaQuote(n.AlarmRuleId))
+		sb.WriteString("    if err ~= nil then\n")
+		logError("        ")
+		sb.WriteString("        return\n")
+		sb.WriteString("    end\n")
+		next("    ", "input", "")
+	case NODE_SWITCH:
+		for i, c := range n.Cases {
+			keyword := "elseif"
+			if i == 0 {
+				keyword = "if"
+			}
+			fmt.Fprintf(sb, "    %s __jq(%s, input) then\n", keyword, luaQuote(c.Expr))
+			next("        ", "input", c.Port)
+		}
+		sb.WriteString("    else\n")
+		next("        ", "input", PORT_DEFAULT)
+		sb.WriteString("    end\n")
+	case NODE_SINK:
+		fmt.Fprintf(sb, "    local err = data:ToTarget(%s, __encode(input))\n", luaQuote(n.Resource))
+		sb.WriteString("    if err ~= nil then\n")
+		logError("        ")
+		sb.WriteString("    end\n")
+	}
+}
+
+// Lua 的字符串字面量, 控制字符用十进制转义
+func luaQuote(s string) string {
+	sb := strings.Builder{}
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&sb, "\\%03d", c)
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
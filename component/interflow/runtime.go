@@ -0,0 +1,446 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package interflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hootrhino/rhilex/alarmcenter"
+	"github.com/hootrhino/rhilex/component/interwindow"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/itchyny/gojq"
+	"github.com/sirupsen/logrus"
+)
+
+// 数据送到 OutEnd, 由引擎初始化的时候传进来, 一般是输出队列
+type PushFunc func(out *typex.OutEnd, data string) error
+
+// 流程的执行统计
+type FlowStatus struct {
+	Executions uint64 `json:"executions"`
+	Failures   uint64 `json:"failures"`
+	LastError  string `json:"lastError"`
+}
+
+type link struct {
+	port string
+	to   *node
+}
+
+// 编译以后的节点
+type node struct {
+	FlowNode
+	code  *gojq.Code
+	cases []*gojq.Code
+	next  []link
+	fanIn bool // 有多个上游
+}
+
+type runtime struct {
+	flow   Flow
+	inputs []*node
+	nodes  []*node
+	locker sync.Mutex
+	status FlowStatus
+}
+
+/*
+*
+* 校验并编译流程, jq 表达式只编译一次
+*
+ */
+func compile(f Flow) (*runtime, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	nodes := map[string]*node{}
+	r := &runtime{flow: f}
+	for _, fn := range f.Nodes {
+		n := &node{FlowNode: fn}
+		switch fn.Type {
+		case NODE_FILTER, NODE_MAP, NODE_WINDOW:
+			n.code, _ = compileJq(fn.Expr)
+		case NODE_SWITCH:
+			for _, c := range fn.Cases {
+				code, _ := compileJq(c.Expr)
+				n.cases = append(n.cases, code)
+			}
+		case NODE_INPUT:
+			r.inputs = append(r.inputs, n)
+		}
+		nodes[fn.ID] = n
+		r.nodes = append(r.nodes, n)
+	}
+	incoming := map[string]int{}
+	for _, edge := range f.Edges {
+		from := nodes[edge.From]
+		from.next = append(from.next, link{port: edge.Port, to: nodes[edge.To]})
+		incoming[edge.To]++
+	}
+	for id, n := range nodes {
+		n.fanIn = incoming[id] > 1
+	}
+	return r, nil
+}
+
+func (r *runtime) accepts(resource typex.RuleResource) bool {
+	for _, in := range r.inputs {
+		if in.Kind == resource.Kind && in.Resource == resource.UUID {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+*
+* 一次执行的状态. 有多个上游的汇聚节点在一次执行里面只执行一次, 以先到的数据为准;
+* 窗口一次关闭多个的时候, 每个结果各自往下走
+*
+ */
+type execution struct {
+	resource typex.RuleResource
+	visited  map[string]bool
+	errs     *[]error
+}
+
+func newExecution(resource typex.RuleResource) *execution {
+	return &execution{resource: resource, visited: map[string]bool{}, errs: &[]error{}}
+}
+
+func (x *execution) fork() *execution {
+	visited := make(map[string]bool, len(x.visited))
+	for id := range x.visited {
+		visited[id] = true
+	}
+	return &execution{resource: x.resource, visited: visited, errs: x.errs}
+}
+
+func (x *execution) err() error {
+	return errors.Join(*x.errs...)
+}
+
+/*
+*
+* 执行一次: 数据从匹配的输入节点出发, 按照连线的顺序深度优先往下走. 某个节点出错
+* 只结束这一个分支, 其他分支继续执行
+*
+ */
+func (r *runtime) execute(e *flowEngine, resource typex.RuleResource, data string) error {
+	var value any
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		value = data
+	}
+	x := newExecution(resource)
+	for _, in := range r.inputs {
+		if in.Kind == resource.Kind && in.Resource == resource.UUID {
+			r.visit(e, in, value, x)
+		}
+	}
+	return x.err()
+}
+
+func (r *runtime) visit(e *flowEngine, n *node, value any, x *execution) {
+	if n.fanIn {
+		if x.visited[n.ID] {
+			return
+		}
+		x.visited[n.ID] = true
+	}
+	outputs, port, err := r.process(e, n, value, x.resource)
+	if err != nil {
+		*x.errs = append(*x.errs, fmt.Errorf("node %s: %w", n.ID, err))
+		return
+	}
+	for _, output := range outputs {
+		branch := x
+		if len(outputs) > 1 {
+			branch = x.fork()
+		}
+		r.visitNext(e, n, output, port, branch)
+	}
+}
+
+func (r *runtime) visitNext(e *flowEngine, n *node, output any, port string, x *execution) {
+	for _, l := range n.next {
+		if n.Type == NODE_SWITCH && l.port != port {
+			continue
+		}
+		r.visit(e, l.to, output, x)
+	}
+}
+
+// 返回输出的数据和分支节点选中的端口, 没有输出的时候不再往下走; 窗口一次可能关闭多个
+func (r *runtime) process(e *flowEngine, n *node, value any,
+	resource typex.RuleResource) ([]any, string, error) {
+	switch n.Type {
+	case NODE_INPUT:
+		return []any{value}, "", nil
+	case NODE_FILTER:
+		v, err := runJq(n.code, value)
+		if err != nil || !truthy(v) {
+			return nil, "", err
+		}
+		return []any{value}, "", nil
+	case NODE_MAP:
+		// 结果为 null 的时候不再往下走
+		v, err := runJq(n.code, value)
+		if err != nil || v == nil {
+			return nil, "", err
+		}
+		return []any{v}, "", nil
+	case NODE_WINDOW:
+		v, err := runJq(n.code, value)
+		if err != nil {
+			return nil, "", err
+		}
+		sample, err := windowValue(v)
+		if err != nil {
+			return nil, "", err
+		}
+		step := n.Step
+		if step == 0 {
+			step = n.Size
+		}
+		store := interwindow.DefaultWindowStore()
+		results, err := store.Push(r.flow.UUID, n.Key, n.Size, step, time.Now().UnixMilli(), sample)
+		if err != nil {
+			return nil, "", err
+		}
+		// 设备不再上报的时候, 定时器关闭的窗口从这个节点继续往下走
+		if err := store.OnFlush(r.flow.UUID, n.Key, func(results []*interwindow.Result) {
+			flushWindow(r.flow.UUID, n.ID, resource, results)
+		}); err != nil {
+			return nil, "", err
+		}
+		return windowOutputs(results), "", nil
+	case NODE_ALARM:
+		in, ok := value.(map[string]any)
+		if !ok {
+			return nil, "", fmt.Errorf("alarm input must be an object")
+		}
+		if _, err := alarmcenter.Input(n.AlarmRuleId, resource.UUID, in); err != nil {
+			return nil, "", err
+		}
+		return []any{value}, "", nil
+	case NODE_SWITCH:
+		for i, code := range n.cases {
+			v, err := runJq(code, value)
+			if err != nil {
+				return nil, "", err
+			}
+			if truthy(v) {
+				return []any{value}, n.Cases[i].Port, nil
+			}
+		}
+		return []any{value}, PORT_DEFAULT, nil
+	case NODE_SINK:
+		out := e.rx.GetOutEnd(n.Resource)
+		if out == nil {
+			return nil, "", fmt.Errorf("target not found: %s", n.Resource)
+		}
+		return nil, "", e.push(out, encodeValue(value))
+	}
+	return nil, "", fmt.Errorf("unknown node type: %s", n.Type)
+}
+
+// 窗口的结果转成 JSON 对象, 和 jq 的数据一样处理
+func windowOutputs(results []*interwindow.Result) []any {
+	outputs := []any{}
+	for _, result := range results {
+		output := map[string]any{}
+		bytes, _ := json.Marshal(result)
+		json.Unmarshal(bytes, &output)
+		outputs = append(outputs, output)
+	}
+	return outputs
+}
+
+// jq 的第一个结果, 没有结果的时候为 nil
+func runJq(code *gojq.Code, value any) (any, error) {
+	iter := code.Run(value)
+	v, ok := iter.Next()
+	if !ok {
+		return nil, nil
+	}
+	if err, ok := v.(error); ok {
+		return nil, err
+	}
+	return v, nil
+}
+
+// 和 jq 一样, 只有 false 和 null 为假
+func truthy(v any) bool {
+	b, ok := v.(bool)
+	return v != nil && (!ok || b)
+}
+
+// 数字或者数字字符串, 和 window 库一样
+func windowValue(v any) (float64, error) {
+	switch value := v.(type) {
+	case float64:
+		return value, nil
+	case int:
+		return float64(value), nil
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(value).Float64()
+		return f, nil
+	case string:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window value: %s", value)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("invalid window value: %v", v)
+}
+
+// 字符串原样输出, 其他的转成 JSON
+func encodeValue(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	bytes, _ := json.Marshal(v)
+	return string(bytes)
+}
+
+/*
+*
+* 运行中的流程, 按照 UUID 保存
+*
+ */
+type flowEngine struct {
+	rx     typex.Rhilex
+	push   PushFunc
+	locker sync.RWMutex
+	flows  map[string]*runtime
+}
+
+var __DefaultFlowEngine = &flowEngine{flows: map[string]*runtime{}}
+
+func InitFlowEngine(rx typex.Rhilex, push PushFunc) {
+	__DefaultFlowEngine.rx = rx
+	__DefaultFlowEngine.push = push
+}
+
+// 加载或者替换流程, 校验失败的时候原来的流程不受影响
+func LoadFlow(f Flow) error {
+	r, err := compile(f)
+	if err != nil {
+		return err
+	}
+	e := __DefaultFlowEngine
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	e.flows[f.UUID] = r
+	glogger.GLogger.Infof("Flow [%s, %s] load successfully", f.UUID, f.Name)
+	return nil
+}
+
+// 删除流程以及它的窗口
+func RemoveFlow(uuid string) {
+	e := __DefaultFlowEngine
+	e.locker.Lock()
+	delete(e.flows, uuid)
+	e.locker.Unlock()
+	interwindow.DefaultWindowStore().RemoveRule(uuid)
+}
+
+func GetFlowStatus(uuid string) (FlowStatus, bool) {
+	e := __DefaultFlowEngine
+	e.locker.RLock()
+	r, ok := e.flows[uuid]
+	e.locker.RUnlock()
+	if !ok {
+		return FlowStatus{}, false
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	return r.status, true
+}
+
+/*
+*
+* 资源来了数据以后执行订阅了这个资源的流程, 在队列的协程里面调用
+*
+ */
+func RunFlows(resource typex.RuleResource, data string) {
+	e := __DefaultFlowEngine
+	e.locker.RLock()
+	matched := []*runtime{}
+	for _, r := range e.flows {
+		if r.accepts(resource) {
+			matched = append(matched, r)
+		}
+	}
+	e.locker.RUnlock()
+	for _, r := range matched {
+		r.record(r.execute(e, resource, data))
+	}
+}
+
+/*
+*
+* 定时器关闭的窗口, 使用当前加载的流程从窗口节点往下走; 流程更新以后节点不在了就丢掉
+*
+ */
+func flushWindow(uuid, nodeId string, resource typex.RuleResource, results []*interwindow.Result) {
+	e := __DefaultFlowEngine
+	e.locker.RLock()
+	r, ok := e.flows[uuid]
+	e.locker.RUnlock()
+	if !ok {
+		return
+	}
+	for _, n := range r.nodes {
+		if n.ID != nodeId || n.Type != NODE_WINDOW {
+			continue
+		}
+		x := newExecution(resource)
+		outputs := windowOutputs(results)
+		for _, output := range outputs {
+			branch := x
+			if len(outputs) > 1 {
+				branch = x.fork()
+			}
+			r.visitNext(e, n, output, "", branch)
+		}
+		r.record(x.err())
+	}
+}
+
+// 记录一次执行的结果
+func (r *runtime) record(err error) {
+	r.locker.Lock()
+	r.status.Executions++
+	if err != nil {
+		r.status.Failures++
+		r.status.LastError = err.Error()
+	}
+	r.locker.Unlock()
+	if err != nil {
+		glogger.GLogger.WithFields(logrus.Fields{
+			"topic": "flow/log/" + r.flow.UUID,
+		}).Warn(err)
+	}
+}
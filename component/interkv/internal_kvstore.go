@@ -3,6 +3,7 @@ package interkv
 import (
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hootrhino/rhilex/glogger"
@@ -21,6 +22,7 @@ func InitInterKVStore(maxSize int) {
 type RhilexStore struct {
 	cache   *cache.Cache
 	maxSize int
+	locker  sync.RWMutex // 规则在多个队列协程里面并行执行, 索引需要加锁
 	index   map[string][]string
 }
 
@@ -94,6 +96,8 @@ func (rs *RhilexStore) FuzzyGet(Key string) any {
 
 // 更新索引
 func (rs *RhilexStore) updateIndex(k string) {
+	rs.locker.Lock()
+	defer rs.locker.Unlock()
 	patterns := generateIndexPatterns(k)
 	for _, pattern := range patterns {
 		rs.index[pattern] = append(rs.index[pattern], k)
@@ -102,6 +106,8 @@ func (rs *RhilexStore) updateIndex(k string) {
 
 // 从索引中移除键
 func (rs *RhilexStore) removeFromIndex(k string) {
+	rs.locker.Lock()
+	defer rs.locker.Unlock()
 	patterns := generateIndexPatterns(k)
 	for _, pattern := range patterns {
 		keys := rs.index[pattern]
@@ -116,7 +122,9 @@ func (rs *RhilexStore) removeFromIndex(k string) {
 
 // 根据查询模式从索引中获取键列表
 func (rs *RhilexStore) getKeysFromIndex(pattern string) []string {
-	return rs.index[pattern]
+	rs.locker.RLock()
+	defer rs.locker.RUnlock()
+	return append([]string{}, rs.index[pattern]...)
 }
 
 // 生成索引模式
@@ -0,0 +1,58 @@
+package intermetric
+
+import "sort"
+
+// 默认的耗时分桶, 单位秒
+var DefaultBuckets = []float64{
+	0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+/*
+*
+* 直方图, Counts[i] 为落在 (Buckets[i-1], Buckets[i]] 里的次数, 不是累计值
+*
+ */
+type Histogram struct {
+	Buckets []float64 `json:"buckets"`
+	Counts  []uint64  `json:"counts"`
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		Buckets: buckets,
+		Counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.Count++
+	h.Sum += v
+	if i := sort.SearchFloat64s(h.Buckets, v); i < len(h.Buckets) {
+		h.Counts[i]++
+	}
+}
+
+// 平均值
+func (h *Histogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / float64(h.Count)
+}
+
+func (h *Histogram) copy() *Histogram {
+	c := *h
+	c.Counts = append([]uint64{}, h.Counts...)
+	return &c
+}
+
+func sortResourceMetrics(list []ResourceMetric) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Kind != list[j].Kind {
+			return list[i].Kind < list[j].Kind
+		}
+		return list[i].UUID < list[j].UUID
+	})
+}
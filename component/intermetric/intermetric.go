@@ -1,84 +1,224 @@
 package intermetric
 
 import (
-	"math"
+	"sync"
+	"time"
 
 	"github.com/hootrhino/rhilex/typex"
 )
 
-var __DefaultInternalMetric *InternalMetric
+var __DefaultInternalMetric *metricRegistry
 
+// 资源类型
+const (
+	KIND_INEND  string = "INEND"
+	KIND_OUTEND string = "OUTEND"
+	KIND_DEVICE string = "DEVICE"
+	KIND_RULE   string = "RULE"
+	KIND_APPLET string = "APPLET"
+)
+
+/*
+*
+* 全局统计, 由每个资源的统计汇总而来
+*
+ */
 type InternalMetric struct {
-	rhilex     typex.Rhilex
 	InSuccess  uint64 `json:"inSuccess"`
 	OutSuccess uint64 `json:"outSuccess"`
 	InFailed   uint64 `json:"inFailed"`
 	OutFailed  uint64 `json:"outFailed"`
 }
 
-func InitInternalMetric(rhilex typex.Rhilex) *InternalMetric {
-	__DefaultInternalMetric = &InternalMetric{
-		InSuccess:  0,
-		OutSuccess: 0,
-		InFailed:   0,
-		OutFailed:  0,
+// 所有资源的统计
+type metricRegistry struct {
+	rhilex    typex.Rhilex
+	locker    sync.RWMutex
+	resources map[string]*ResourceMetric
+	gauges    []gaugeFunc
+}
+
+/*
+*
+* 单个资源的统计:
+* - INEND/DEVICE: In 为进入队列的消息, InFailed 为进入队列失败
+* - OUTEND: Out 为发送成功, OutFailed 为发送失败
+* - RULE: In 为执行次数, InFailed 为执行失败
+* - RULE/APPLET: Violations 为超出执行预算(超时、指令数、内存)被中止的次数
+*
+ */
+type ResourceMetric struct {
+	Kind       string     `json:"kind"`
+	UUID       string     `json:"uuid"`
+	In         uint64     `json:"in"`
+	InFailed   uint64     `json:"inFailed"`
+	Out        uint64     `json:"out"`
+	OutFailed  uint64     `json:"outFailed"`
+	Restarts   uint64     `json:"restarts"`
+	Violations uint64     `json:"violations"`
+	Latency    *Histogram `json:"latency"` // RULE: Lua执行耗时, DEVICE: 采集耗时, OUTEND: 发送耗时
+}
+
+func InitInternalMetric(rhilex typex.Rhilex) {
+	__DefaultInternalMetric = &metricRegistry{
+		resources: map[string]*ResourceMetric{},
+		gauges:    []gaugeFunc{},
 	}
 	__DefaultInternalMetric.rhilex = rhilex
-	return __DefaultInternalMetric
-
 }
-func GetMetric() InternalMetric {
-	return *__DefaultInternalMetric
 
+/*
+*
+* 汇总统计, 和 /metrics 使用同一份数据
+*
+ */
+func GetMetric() InternalMetric {
+	m := __DefaultInternalMetric
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	summary := InternalMetric{}
+	for _, r := range m.resources {
+		switch r.Kind {
+		case KIND_INEND, KIND_DEVICE:
+			summary.InSuccess += r.In
+			summary.InFailed += r.InFailed
+		case KIND_OUTEND:
+			summary.OutSuccess += r.Out
+			summary.OutFailed += r.OutFailed
+		}
+	}
+	return summary
 }
-func IncIn() {
-	if __DefaultInternalMetric.InSuccess < math.MaxUint64 {
-		__DefaultInternalMetric.InSuccess = __DefaultInternalMetric.InSuccess + 1
-	} else {
-		Reset()
+
+/*
+*
+* 所有资源的统计快照
+*
+ */
+func GetResourceMetrics() []ResourceMetric {
+	m := __DefaultInternalMetric
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	result := make([]ResourceMetric, 0, len(m.resources))
+	for _, r := range m.resources {
+		result = append(result, r.copy())
 	}
+	sortResourceMetrics(result)
+	return result
 }
-func DecIn() {
 
-	if __DefaultInternalMetric.InSuccess-1 > 0 {
-		__DefaultInternalMetric.InSuccess = __DefaultInternalMetric.InSuccess - 1
+// 某个资源的统计快照
+func GetResourceMetric(uuid string) (ResourceMetric, bool) {
+	m := __DefaultInternalMetric
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	if r, ok := m.resources[uuid]; ok {
+		return r.copy(), true
 	}
+	return ResourceMetric{}, false
 }
-func IncOut() {
 
-	if __DefaultInternalMetric.OutSuccess < math.MaxUint64 {
-		__DefaultInternalMetric.OutSuccess = __DefaultInternalMetric.OutSuccess + 1
-	} else {
-		Reset()
+func (r *ResourceMetric) copy() ResourceMetric {
+	c := *r
+	if r.Latency != nil {
+		c.Latency = r.Latency.copy()
 	}
+	return c
 }
-func DecOut() {
 
-	if __DefaultInternalMetric.OutSuccess-1 > 0 {
-		__DefaultInternalMetric.OutSuccess = __DefaultInternalMetric.OutSuccess - 1
+// 需要持有写锁
+func (m *metricRegistry) resource(kind, uuid string) *ResourceMetric {
+	r, ok := m.resources[uuid]
+	if !ok {
+		r = &ResourceMetric{Kind: kind, UUID: uuid}
+		m.resources[uuid] = r
 	}
+	return r
 }
-func IncInFailed() {
 
-	if __DefaultInternalMetric.InFailed < math.MaxUint64 {
-		__DefaultInternalMetric.InFailed = __DefaultInternalMetric.InFailed + 1
-	} else {
-		Reset()
+func update(kind, uuid string, f func(r *ResourceMetric)) {
+	m := __DefaultInternalMetric
+	if m == nil || uuid == "" {
+		return
 	}
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	f(m.resource(kind, uuid))
+}
+
+// 南向资源或者设备的数据进入队列
+func IncIn(kind, uuid string) {
+	update(kind, uuid, func(r *ResourceMetric) { r.In++ })
+}
+
+// 南向资源或者设备的数据进入队列失败
+func IncInFailed(kind, uuid string) {
+	update(kind, uuid, func(r *ResourceMetric) { r.InFailed++ })
 }
 
-func IncOutFailed() {
+// 北向资源发送成功
+func IncOut(uuid string, cost time.Duration) {
+	update(KIND_OUTEND, uuid, func(r *ResourceMetric) {
+		r.Out++
+		r.observe(cost)
+	})
+}
+
+// 北向资源发送失败
+func IncOutFailed(uuid string, cost time.Duration) {
+	update(KIND_OUTEND, uuid, func(r *ResourceMetric) {
+		r.OutFailed++
+		r.observe(cost)
+	})
+}
+
+// 规则执行一次, 记录Lua执行耗时
+func ObserveRule(uuid string, cost time.Duration, success bool) {
+	update(KIND_RULE, uuid, func(r *ResourceMetric) {
+		r.In++
+		if !success {
+			r.InFailed++
+		}
+		r.observe(cost)
+	})
+}
+
+// 规则或者应用超出执行预算被中止
+func IncViolation(kind, uuid string) {
+	update(kind, uuid, func(r *ResourceMetric) { r.Violations++ })
+}
+
+// 设备采集一轮的耗时
+func ObserveDevicePoll(uuid string, cost time.Duration) {
+	update(KIND_DEVICE, uuid, func(r *ResourceMetric) { r.observe(cost) })
+}
+
+// 资源被监控器重启
+func IncRestart(kind, uuid string) {
+	update(kind, uuid, func(r *ResourceMetric) { r.Restarts++ })
+}
+
+func (r *ResourceMetric) observe(cost time.Duration) {
+	if r.Latency == nil {
+		r.Latency = NewHistogram(DefaultBuckets)
+	}
+	r.Latency.Observe(cost.Seconds())
+}
 
-	if __DefaultInternalMetric.InFailed < math.MaxUint64 {
-		__DefaultInternalMetric.OutFailed = __DefaultInternalMetric.OutFailed + 1
-	} else {
-		Reset()
+// 资源被删除以后清理掉它的统计
+func Remove(uuid string) {
+	m := __DefaultInternalMetric
+	if m == nil {
+		return
 	}
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	delete(m.resources, uuid)
 }
 
 func Reset() {
-	__DefaultInternalMetric.InSuccess = 0
-	__DefaultInternalMetric.InFailed = 0
-	__DefaultInternalMetric.OutFailed = 0
-	__DefaultInternalMetric.OutSuccess = 0
+	m := __DefaultInternalMetric
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.resources = map[string]*ResourceMetric{}
 }
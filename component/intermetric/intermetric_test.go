@@ -0,0 +1,56 @@
+package intermetric
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Metric_Summary(t *testing.T) {
+	InitInternalMetric(nil)
+	IncIn(KIND_INEND, "INEND1")
+	IncIn(KIND_DEVICE, "DEVICE1")
+	IncInFailed(KIND_DEVICE, "DEVICE1")
+	IncOut("OUTEND1", time.Millisecond)
+	IncOutFailed("OUTEND1", time.Millisecond)
+	ObserveRule("RULE1", time.Millisecond, false)
+	summary := GetMetric()
+	if summary.InSuccess != 2 || summary.InFailed != 1 ||
+		summary.OutSuccess != 1 || summary.OutFailed != 1 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	Remove("DEVICE1")
+	if GetMetric().InSuccess != 1 {
+		t.Fatal("removed resource must not be counted")
+	}
+}
+
+func Test_Metric_Prometheus(t *testing.T) {
+	InitInternalMetric(nil)
+	ObserveRule("RULE1", 3*time.Millisecond, true)
+	ObserveRule("RULE1", 2*time.Second, true)
+	IncRestart(KIND_DEVICE, "DEVICE1")
+	RegisterGaugeFunc("rhilex_queue_depth", "queue depth", func() []GaugeSample {
+		return []GaugeSample{{Labels: []Label{{"queue", "in"}}, Value: 3}}
+	})
+	buf := bytes.Buffer{}
+	if err := WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+	for _, line := range []string{
+		"# TYPE rhilex_rule_execution_seconds histogram",
+		`rhilex_rule_execution_seconds_bucket{uuid="RULE1",le="0.0025"} 0`,
+		`rhilex_rule_execution_seconds_bucket{uuid="RULE1",le="0.005"} 1`,
+		`rhilex_rule_execution_seconds_bucket{uuid="RULE1",le="+Inf"} 2`,
+		`rhilex_rule_execution_seconds_count{uuid="RULE1"} 2`,
+		`rhilex_resource_in_total{kind="rule",uuid="RULE1"} 2`,
+		`rhilex_resource_restarts_total{kind="device",uuid="DEVICE1"} 1`,
+		`rhilex_queue_depth{queue="in"} 3`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Fatalf("missing line: %s\n%s", line, text)
+		}
+	}
+}
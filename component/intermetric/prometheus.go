@@ -0,0 +1,188 @@
+package intermetric
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 指标标签
+type Label struct {
+	Name  string
+	Value string
+}
+
+// 采集时才计算的瞬时值, 比如队列深度
+type GaugeSample struct {
+	Labels []Label
+	Value  float64
+}
+
+type gaugeFunc struct {
+	name    string
+	help    string
+	collect func() []GaugeSample
+}
+
+/*
+*
+* 注册一个瞬时值, 每次导出的时候调用 collect
+*
+ */
+func RegisterGaugeFunc(name, help string, collect func() []GaugeSample) {
+	m := __DefaultInternalMetric
+	if m == nil {
+		return
+	}
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	for i, g := range m.gauges {
+		if g.name == name {
+			m.gauges[i].help = help
+			m.gauges[i].collect = collect
+			return
+		}
+	}
+	m.gauges = append(m.gauges, gaugeFunc{name: name, help: help, collect: collect})
+}
+
+// 每种资源的直方图名称
+var latencyMetricNames = map[string][2]string{
+	KIND_RULE:   {"rhilex_rule_execution_seconds", "Lua execution time of rules."},
+	KIND_DEVICE: {"rhilex_device_poll_seconds", "Poll round-trip time of devices."},
+	KIND_OUTEND: {"rhilex_outend_send_seconds", "Send time of outends."},
+}
+
+/*
+*
+* 按照 Prometheus 文本格式导出所有指标
+*
+ */
+func WritePrometheus(w io.Writer) error {
+	m := __DefaultInternalMetric
+	resources := GetResourceMetrics()
+	summary := GetMetric()
+	m.locker.RLock()
+	gauges := append([]gaugeFunc{}, m.gauges...)
+	m.locker.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	// 汇总
+	writeHeader(bw, "rhilex_messages_in_total", "counter", "Messages received by all inends and devices.")
+	writeSample(bw, "rhilex_messages_in_total", nil, float64(summary.InSuccess))
+	writeHeader(bw, "rhilex_messages_in_failed_total", "counter", "Messages failed to enter the queue.")
+	writeSample(bw, "rhilex_messages_in_failed_total", nil, float64(summary.InFailed))
+	writeHeader(bw, "rhilex_messages_out_total", "counter", "Messages sent by all outends.")
+	writeSample(bw, "rhilex_messages_out_total", nil, float64(summary.OutSuccess))
+	writeHeader(bw, "rhilex_messages_out_failed_total", "counter", "Messages failed to send by all outends.")
+	writeSample(bw, "rhilex_messages_out_failed_total", nil, float64(summary.OutFailed))
+	// 每个资源
+	counters := []struct {
+		name  string
+		help  string
+		value func(r ResourceMetric) uint64
+	}{
+		{"rhilex_resource_in_total", "Messages in per resource, executions for rules.",
+			func(r ResourceMetric) uint64 { return r.In }},
+		{"rhilex_resource_in_failed_total", "Failed messages in per resource, failed executions for rules.",
+			func(r ResourceMetric) uint64 { return r.InFailed }},
+		{"rhilex_resource_out_total", "Messages out per resource.",
+			func(r ResourceMetric) uint64 { return r.Out }},
+		{"rhilex_resource_out_failed_total", "Failed messages out per resource.",
+			func(r ResourceMetric) uint64 { return r.OutFailed }},
+		{"rhilex_resource_restarts_total", "Restarts by the resource supervisor.",
+			func(r ResourceMetric) uint64 { return r.Restarts }},
+		{"rhilex_resource_violations_total", "Lua executions aborted by the execution budget.",
+			func(r ResourceMetric) uint64 { return r.Violations }},
+	}
+	for _, counter := range counters {
+		writeHeader(bw, counter.name, "counter", counter.help)
+		for _, r := range resources {
+			writeSample(bw, counter.name, resourceLabels(r), float64(counter.value(r)))
+		}
+	}
+	kinds := []string{KIND_DEVICE, KIND_OUTEND, KIND_RULE}
+	for _, kind := range kinds {
+		name, help := latencyMetricNames[kind][0], latencyMetricNames[kind][1]
+		writeHeader(bw, name, "histogram", help)
+		for _, r := range resources {
+			if r.Kind != kind || r.Latency == nil {
+				continue
+			}
+			writeHistogram(bw, name, []Label{{"uuid", r.UUID}}, r.Latency)
+		}
+	}
+	// 瞬时值
+	sort.Slice(gauges, func(i, j int) bool { return gauges[i].name < gauges[j].name })
+	for _, g := range gauges {
+		writeHeader(bw, g.name, "gauge", g.help)
+		for _, sample := range g.collect() {
+			writeSample(bw, g.name, sample.Labels, sample.Value)
+		}
+	}
+	return bw.Flush()
+}
+
+func resourceLabels(r ResourceMetric) []Label {
+	return []Label{{"kind", strings.ToLower(r.Kind)}, {"uuid", r.UUID}}
+}
+
+func writeHeader(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func writeHistogram(w *bufio.Writer, name string, labels []Label, h *Histogram) {
+	cumulative := uint64(0)
+	for i, bound := range h.Buckets {
+		cumulative += h.Counts[i]
+		writeSample(w, name+"_bucket",
+			append(append([]Label{}, labels...), Label{"le", formatFloat(bound)}), float64(cumulative))
+	}
+	writeSample(w, name+"_bucket",
+		append(append([]Label{}, labels...), Label{"le", "+Inf"}), float64(h.Count))
+	writeSample(w, name+"_sum", labels, h.Sum)
+	writeSample(w, name+"_count", labels, float64(h.Count))
+}
+
+func writeSample(w *bufio.Writer, name string, labels []Label, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l.Name)
+			w.WriteString(`="`)
+			w.WriteString(escapeLabelValue(l.Value))
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
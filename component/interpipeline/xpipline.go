@@ -9,16 +9,16 @@ import (
 
 // RunPipline
 //
-//	Run lua as pipline
-func RunPipline(vm *lua.LState, funcs map[string]*lua.LFunction, arg lua.LValue) (lua.LValue, error) {
+//	Run lua as pipline, extra 是每个回调都会收到的附加参数, 比如规则的上下文
+func RunPipline(vm *lua.LState, funcs map[string]*lua.LFunction, arg lua.LValue, extra ...lua.LValue) (lua.LValue, error) {
 	// start 1
 	acc := 1
-	return pipLine(vm, acc, funcs, arg)
+	return pipLine(vm, acc, funcs, arg, extra)
 }
 
-func pipLine(vm *lua.LState, acc int, funcs map[string]*lua.LFunction, arg lua.LValue) (lua.LValue, error) {
+func pipLine(vm *lua.LState, acc int, funcs map[string]*lua.LFunction, arg lua.LValue, extra []lua.LValue) (lua.LValue, error) {
 	if acc == len(funcs) {
-		values, err0 := callLuaFunc(vm, funcs[strconv.Itoa(acc)], arg)
+		values, err0 := callLuaFunc(vm, funcs[strconv.Itoa(acc)], append([]lua.LValue{arg}, extra...)...)
 		if err0 != nil {
 			return nil, err0
 		}
@@ -28,7 +28,7 @@ func pipLine(vm *lua.LState, acc int, funcs map[string]*lua.LFunction, arg lua.L
 		})
 
 	}
-	values, err0 := callLuaFunc(vm, funcs[strconv.Itoa(acc)], arg)
+	values, err0 := callLuaFunc(vm, funcs[strconv.Itoa(acc)], append([]lua.LValue{arg}, extra...)...)
 	if err0 != nil {
 		return nil, err0
 	}
@@ -37,7 +37,7 @@ func pipLine(vm *lua.LState, acc int, funcs map[string]*lua.LFunction, arg lua.L
 		result := values[1]
 		if next.Type() == lua.LTBool {
 			if next.(lua.LBool) {
-				return pipLine(vm, acc+1, funcs, result)
+				return pipLine(vm, acc+1, funcs, result, extra)
 			}
 			return result, nil
 		}
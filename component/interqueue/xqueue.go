@@ -17,11 +17,14 @@ package interqueue
 import (
 	"context"
 	"fmt"
-	"math/rand"
+	"hash/fnv"
+	"runtime"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/hootrhino/rhilex/component/intermetric"
+	"github.com/hootrhino/rhilex/component/lostcache"
 	"github.com/hootrhino/rhilex/component/luaexecutor"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
@@ -29,42 +32,94 @@ import (
 
 var __DefaultXQueue *XQueue
 
+// 队列满了以后的处理方式
+const (
+	QUEUE_FULL_BLOCK       string = "block"       // 阻塞等待, 直到有空位或者队列停止
+	QUEUE_FULL_DROP_OLDEST string = "drop_oldest" // 丢掉最早的一条
+	QUEUE_FULL_DROP_NEWEST string = "drop_newest" // 丢掉新来的, 返回错误
+)
+
+/*
+*
+* 每类队列有多个工作协程, 每个协程一个通道. 消息按照资源的 UUID 分配到固定的通道,
+* 同一个资源的消息按照顺序处理, 不同资源的消息并行处理; 资源的规则实例(虚拟机)也只在
+* 这个协程里面执行.
+*
+ */
 type XQueue struct {
-	Queue        []chan QueueData
 	OutQueue     []chan QueueData
 	InQueue      []chan QueueData
 	DeviceQueue  []chan QueueData
 	rhilex       typex.Rhilex
-	locker       sync.Mutex
 	maxQueueSize int
+	fullPolicy   string
+	ctx          context.Context
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
 }
 
-// 初始化队列
-func InitXQueue(rhilex typex.Rhilex, maxQueueSize int) *XQueue {
-	queueCount := 10
-	inQueue := make([]chan QueueData, queueCount)
-	outQueue := make([]chan QueueData, queueCount)
-	deviceQueue := make([]chan QueueData, queueCount)
+// 初始化队列, workers 为 0 的时候使用 CPU 核数
+func InitXQueue(rhilex typex.Rhilex, maxQueueSize, workers int, fullPolicy string) *XQueue {
+	__DefaultXQueue = newXQueue(rhilex, maxQueueSize, workers, fullPolicy)
+	intermetric.RegisterGaugeFunc("rhilex_queue_depth",
+		"Messages waiting in each internal queue channel.", __DefaultXQueue.queueDepth)
+	return __DefaultXQueue
+}
 
-	// 初始化队列通道
-	initQueues := func(queues []chan QueueData) {
-		for i := 0; i < queueCount; i++ {
-			queues[i] = make(chan QueueData, maxQueueSize)
+func newXQueue(rhilex typex.Rhilex, maxQueueSize, workers int, fullPolicy string) *XQueue {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if maxQueueSize <= 0 {
+		maxQueueSize = 1
+	}
+	switch fullPolicy {
+	case QUEUE_FULL_BLOCK, QUEUE_FULL_DROP_OLDEST, QUEUE_FULL_DROP_NEWEST:
+	default:
+		glogger.GLogger.Warnf("Unknown queue full policy '%s', use '%s'",
+			fullPolicy, QUEUE_FULL_DROP_NEWEST)
+		fullPolicy = QUEUE_FULL_DROP_NEWEST
+	}
+	// max_queue_size 是每类队列的总容量, 平均分给每个协程的通道
+	channelSize := max(1, maxQueueSize/workers)
+	newQueues := func() []chan QueueData {
+		queues := make([]chan QueueData, workers)
+		for i := range queues {
+			queues[i] = make(chan QueueData, channelSize)
 		}
+		return queues
 	}
-	initQueues(inQueue)
-	initQueues(outQueue)
-	initQueues(deviceQueue)
-
-	__DefaultXQueue = &XQueue{
-		InQueue:      inQueue,
-		OutQueue:     outQueue,
-		DeviceQueue:  deviceQueue,
+	ctx, cancel := context.WithCancel(context.Background())
+	return &XQueue{
+		InQueue:      newQueues(),
+		OutQueue:     newQueues(),
+		DeviceQueue:  newQueues(),
 		rhilex:       rhilex,
-		locker:       sync.Mutex{},
 		maxQueueSize: maxQueueSize,
+		fullPolicy:   fullPolicy,
+		ctx:          ctx,
+		cancel:       cancel,
 	}
-	return __DefaultXQueue
+}
+
+// 每个队列通道当前的积压
+func (q *XQueue) queueDepth() []intermetric.GaugeSample {
+	samples := []intermetric.GaugeSample{}
+	collect := func(name string, queues []chan QueueData) {
+		for i, qc := range queues {
+			samples = append(samples, intermetric.GaugeSample{
+				Labels: []intermetric.Label{
+					{Name: "queue", Value: name},
+					{Name: "channel", Value: strconv.Itoa(i)},
+				},
+				Value: float64(len(qc)),
+			})
+		}
+	}
+	collect("in", q.InQueue)
+	collect("device", q.DeviceQueue)
+	collect("out", q.OutQueue)
+	return samples
 }
 
 // 启动队列
@@ -72,46 +127,93 @@ func StartXQueue() {
 	__DefaultXQueue.StartXQueue()
 }
 
+// 停止队列, 等待正在处理的消息结束
+func StopXQueue() {
+	if __DefaultXQueue != nil {
+		__DefaultXQueue.Stop()
+	}
+}
+
+// 同一个资源总是分到同一个通道
+func partition(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
 // 通用的推送函数
-func pushData(q *XQueue, data QueueData, queue []chan QueueData) error {
-	// 减少锁的使用范围
-	var available []int
-	for i := 0; i < len(queue); i++ {
-		if len(queue[i])+1 <= q.maxQueueSize {
-			available = append(available, i)
+func pushData(q *XQueue, key string, data QueueData, queue []chan QueueData) error {
+	index := partition(key, len(queue))
+	qc := queue[index]
+	switch q.fullPolicy {
+	case QUEUE_FULL_BLOCK:
+		select {
+		case qc <- data:
+			return nil
+		case <-q.ctx.Done():
+			return fmt.Errorf("Queue Send Failed: Queue stopped")
+		}
+	case QUEUE_FULL_DROP_OLDEST:
+		for {
+			select {
+			case qc <- data:
+				return nil
+			default:
+			}
+			select {
+			case dropped := <-qc:
+				q.onDropped(dropped)
+			default:
+			}
+		}
+	default:
+		select {
+		case qc <- data:
+			return nil
+		default:
+			return fmt.Errorf("Queue Send Failed: Channel %d is full", index)
 		}
 	}
-	if len(available) == 0 {
-		return fmt.Errorf("Queue Send Failed: No available channels")
+}
+
+// 被挤掉的消息算作对应资源的失败
+func (q *XQueue) onDropped(data QueueData) {
+	switch {
+	case data.I != nil:
+		intermetric.IncInFailed(intermetric.KIND_INEND, data.I.UUID)
+	case data.D != nil:
+		intermetric.IncInFailed(intermetric.KIND_DEVICE, data.D.UUID)
+	case data.O != nil:
+		intermetric.IncOutFailed(data.O.UUID, 0)
 	}
-	// 更高效的随机数生成
-	randomIndex := available[rand.Intn(len(available))]
-	queue[randomIndex] <- data
-	return nil
 }
 
-// 通用的启动队列处理函数
-func startQueue(ctx context.Context, q *XQueue, queue []chan QueueData, logMsg string, callback func(QueueData)) {
-	glogger.GLogger.Info("Start XQueue: " + logMsg)
-	for {
-		for _, qc := range queue {
-			select {
-			case <-ctx.Done():
-				return
-			case data, ok := <-qc:
-				if ok {
+// 每个通道一个工作协程, 阻塞读取
+func (q *XQueue) startWorkers(name string, queue []chan QueueData, callback func(QueueData)) {
+	glogger.GLogger.Infof("Start XQueue: %s, workers: %d", name, len(queue))
+	for _, qc := range queue {
+		q.wg.Add(1)
+		go func(qc chan QueueData) {
+			defer q.wg.Done()
+			for {
+				select {
+				case <-q.ctx.Done():
+					return
+				case data := <-qc:
 					callback(data)
 				}
-			case <-time.After(4 * time.Millisecond):
-				continue
 			}
-		}
+		}(qc)
 	}
 }
 
 // 启动输入队列
-func (q *XQueue) startInQueue(ctx context.Context) {
-	startQueue(ctx, q, q.InQueue, "InQueue", func(data QueueData) {
+func (q *XQueue) startInQueue() {
+	q.startWorkers("InQueue", q.InQueue, func(data QueueData) {
+		if data.Call != nil {
+			data.Call()
+			return
+		}
 		if data.I == nil || data.E == nil {
 			return
 		}
@@ -120,8 +222,12 @@ func (q *XQueue) startInQueue(ctx context.Context) {
 }
 
 // 启动设备队列
-func (q *XQueue) startDeviceQueue(ctx context.Context) {
-	startQueue(ctx, q, q.DeviceQueue, "DeviceQueue", func(data QueueData) {
+func (q *XQueue) startDeviceQueue() {
+	q.startWorkers("DeviceQueue", q.DeviceQueue, func(data QueueData) {
+		if data.Call != nil {
+			data.Call()
+			return
+		}
 		if data.D == nil || data.E == nil {
 			return
 		}
@@ -130,18 +236,22 @@ func (q *XQueue) startDeviceQueue(ctx context.Context) {
 }
 
 // 启动输出队列
-func (q *XQueue) startOutQueue(ctx context.Context) {
-	startQueue(ctx, q, q.OutQueue, "OutQueue", func(data QueueData) {
+func (q *XQueue) startOutQueue() {
+	q.startWorkers("OutQueue", q.OutQueue, func(data QueueData) {
 		ProcessOutQueueData(data, data.E)
 	})
 }
 
 // 启动所有队列
 func (q *XQueue) StartXQueue() {
-	ctx := context.Background()
-	go q.startInQueue(ctx)
-	go q.startDeviceQueue(ctx)
-	go q.startOutQueue(ctx)
+	q.startInQueue()
+	q.startDeviceQueue()
+	q.startOutQueue()
+}
+
+func (q *XQueue) Stop() {
+	q.cancel()
+	q.wg.Wait()
 }
 
 // 通用的推送包装函数
@@ -156,7 +266,7 @@ func (q *XQueue) PushInQueue(in *typex.InEnd, data string) error {
 		I:    in,
 		Data: data,
 	}
-	return pushData(q, qd, q.InQueue)
+	return pushData(q, in.UUID, qd, q.InQueue)
 }
 func PushInQueue(in *typex.InEnd, data string) error {
 	return pushWrapper(__DefaultXQueue, (*XQueue).PushInQueue, in, data)
@@ -169,7 +279,7 @@ func (q *XQueue) PushDeviceQueue(device *typex.Device, data string) error {
 		D:    device,
 		Data: data,
 	}
-	return pushData(q, qd, q.DeviceQueue)
+	return pushData(q, device.UUID, qd, q.DeviceQueue)
 }
 func PushDeviceQueue(device *typex.Device, data string) error {
 	return pushWrapper(__DefaultXQueue, (*XQueue).PushDeviceQueue, device, data)
@@ -182,12 +292,28 @@ func (q *XQueue) PushOutQueue(out *typex.OutEnd, data string) error {
 		O:    out,
 		Data: data,
 	}
-	return pushData(q, qd, q.OutQueue)
+	return pushData(q, out.UUID, qd, q.OutQueue)
 }
 func PushOutQueue(out *typex.OutEnd, data string) error {
 	return pushWrapper(__DefaultXQueue, (*XQueue).PushOutQueue, out, data)
 }
 
+/*
+*
+* 在资源的工作协程里面执行 call, 和这个资源的规则串行, 可以安全地使用规则的虚拟机
+*
+ */
+func (q *XQueue) PushCall(resource typex.RuleResource, call func()) error {
+	qd := QueueData{E: q.rhilex, Call: call}
+	if resource.Kind == "DEVICE" {
+		return pushData(q, resource.UUID, qd, q.DeviceQueue)
+	}
+	return pushData(q, resource.UUID, qd, q.InQueue)
+}
+func PushCall(resource typex.RuleResource, call func()) error {
+	return __DefaultXQueue.PushCall(resource, call)
+}
+
 type QueueData struct {
 	Debug bool // 是否是Debug消息
 	I     *typex.InEnd
@@ -195,6 +321,7 @@ type QueueData struct {
 	D     *typex.Device
 	E     typex.Rhilex
 	Data  string
+	Call  func() // 不为空的时候只执行它
 }
 
 func (qd QueueData) String() string {
@@ -204,13 +331,18 @@ func (qd QueueData) String() string {
 func ProcessOutQueueData(qd QueueData, e typex.Rhilex) {
 	if qd.O != nil {
 		target := e.GetOutEnd(qd.O.UUID)
-		if target != nil {
-			if _, err := target.Target.To(qd.Data); err != nil {
-				glogger.GLogger.Error(err)
-				intermetric.IncOutFailed()
-			} else {
-				intermetric.IncOut()
-			}
+		if target == nil {
+			// 资源正在重新加载, 开启了发件箱的话先存起来
+			lostcache.SaveToOutbox(qd.O, qd.Data)
+			return
+		}
+		start := time.Now()
+		sent, err := lostcache.DeliverOutbox(target, qd.Data)
+		if err != nil {
+			glogger.GLogger.Error(err)
+			intermetric.IncOutFailed(qd.O.UUID, time.Since(start))
+		} else if sent {
+			intermetric.IncOut(qd.O.UUID, time.Since(start))
 		}
 	}
 }
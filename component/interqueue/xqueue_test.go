@@ -0,0 +1,130 @@
+package interqueue
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestQueue(size, workers int, policy string) *XQueue {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	return newXQueue(nil, size, workers, policy)
+}
+
+func inData(uuid, data string) QueueData {
+	return QueueData{I: &typex.InEnd{UUID: uuid}, Data: data}
+}
+
+func TestPartitionStable(t *testing.T) {
+	for _, key := range []string{"DEVICE1", "DEVICE2", "INEND1"} {
+		if partition(key, 8) != partition(key, 8) {
+			t.Fatal("partition should be stable:", key)
+		}
+	}
+}
+
+// 总容量平均分给每个协程, 每个通道至少 1
+func TestQueueSizeSplit(t *testing.T) {
+	q := newTestQueue(1024, 4, QUEUE_FULL_DROP_NEWEST)
+	if len(q.InQueue) != 4 || cap(q.InQueue[0]) != 256 || cap(q.DeviceQueue[3]) != 256 {
+		t.Fatal(len(q.InQueue), cap(q.InQueue[0]))
+	}
+	q = newTestQueue(2, 4, QUEUE_FULL_DROP_NEWEST)
+	if cap(q.OutQueue[0]) != 1 {
+		t.Fatal(cap(q.OutQueue[0]))
+	}
+}
+
+func TestPushDropNewest(t *testing.T) {
+	q := newTestQueue(1, 1, QUEUE_FULL_DROP_NEWEST)
+	if err := pushData(q, "A", inData("A", "1"), q.InQueue); err != nil {
+		t.Fatal(err)
+	}
+	if err := pushData(q, "A", inData("A", "2"), q.InQueue); err == nil {
+		t.Fatal("expect error when full")
+	}
+	if d := <-q.InQueue[0]; d.Data != "1" {
+		t.Fatal(d.Data)
+	}
+}
+
+func TestPushDropOldest(t *testing.T) {
+	q := newTestQueue(2, 1, QUEUE_FULL_DROP_OLDEST)
+	for i := 1; i <= 3; i++ {
+		if err := pushData(q, "A", inData("A", strconv.Itoa(i)), q.InQueue); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := <-q.InQueue[0]; d.Data != "2" {
+		t.Fatal(d.Data)
+	}
+	if d := <-q.InQueue[0]; d.Data != "3" {
+		t.Fatal(d.Data)
+	}
+}
+
+func TestPushBlock(t *testing.T) {
+	q := newTestQueue(1, 1, QUEUE_FULL_BLOCK)
+	pushData(q, "A", inData("A", "1"), q.InQueue)
+	result := make(chan error)
+	go func() {
+		result <- pushData(q, "A", inData("A", "2"), q.InQueue)
+	}()
+	select {
+	case <-result:
+		t.Fatal("should block when full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-q.InQueue[0]
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	// 停止以后不再阻塞
+	go func() {
+		result <- pushData(q, "A", inData("A", "3"), q.InQueue)
+	}()
+	q.Stop()
+	if err := <-result; err == nil {
+		t.Fatal("expect error after stop")
+	}
+}
+
+func TestWorkersKeepOrderPerResource(t *testing.T) {
+	q := newTestQueue(1024, 4, QUEUE_FULL_BLOCK)
+	lock := sync.Mutex{}
+	received := map[string][]string{}
+	wg := sync.WaitGroup{}
+	q.startWorkers("Test", q.InQueue, func(d QueueData) {
+		lock.Lock()
+		received[d.I.UUID] = append(received[d.I.UUID], d.Data)
+		lock.Unlock()
+		wg.Done()
+	})
+	defer q.Stop()
+	keys := []string{"A", "B", "C", "D", "E"}
+	for i := 0; i < 100; i++ {
+		for _, key := range keys {
+			wg.Add(1)
+			if err := pushData(q, key, inData(key, strconv.Itoa(i)), q.InQueue); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	wg.Wait()
+	for _, key := range keys {
+		if len(received[key]) != 100 {
+			t.Fatal(key, len(received[key]))
+		}
+		for i, v := range received[key] {
+			if v != strconv.Itoa(i) {
+				t.Fatal(fmt.Sprintf("%s out of order at %d: %s", key, i, v))
+			}
+		}
+	}
+}
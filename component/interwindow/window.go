@@ -0,0 +1,336 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package interwindow
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 单个窗口最多缓存的样本数, 超过以后丢弃最旧的
+const maxWindowSamples = 100000
+
+// 最多的窗口数量, 防止脚本用不断变化的 key 撑爆内存
+const maxWindows = 4096
+
+var errMaxWindowsReached = fmt.Errorf("max windows reached: %d", maxWindows)
+
+/*
+*
+* 窗口的聚合结果, 时间单位都是毫秒, 窗口为 [Start, End)
+*
+ */
+type Result struct {
+	Key    string  `json:"key"`
+	Start  int64   `json:"start"`
+	End    int64   `json:"end"`
+	Count  int     `json:"count"`
+	Sum    float64 `json:"sum"`
+	Avg    float64 `json:"avg"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Stddev float64 `json:"stddev"`
+	First  float64 `json:"first"`
+	Last   float64 `json:"last"`
+}
+
+type sample struct {
+	ts    int64
+	value float64
+}
+
+/*
+*
+* 一个窗口: 每隔 step 关闭一次, 覆盖最近 size 的数据; step 等于 size 的时候就是滚动窗口
+*
+ */
+type window struct {
+	key      string
+	size     int64
+	step     int64
+	nextEnd  int64 // 下一个要关闭的窗口的结束时间
+	lastTs   int64 // 最新样本的时间戳
+	lastSeen int64 // 最新样本到达的时间
+	samples  []sample
+}
+
+// 定时器关闭的窗口的结果, 在定时器的协程里面调用
+type FlushHandler func(results []*Result)
+
+/*
+*
+* 窗口存储, 和规则的虚拟机分开, 所以规则重新加载以后窗口的状态还在
+*
+ */
+type WindowStore struct {
+	locker   sync.Mutex
+	windows  map[string]*window
+	handlers map[string]FlushHandler
+	now      func() int64
+}
+
+var __DefaultWindowStore = NewWindowStore()
+
+func NewWindowStore() *WindowStore {
+	return &WindowStore{
+		windows:  map[string]*window{},
+		handlers: map[string]FlushHandler{},
+		now:      func() int64 { return time.Now().UnixMilli() },
+	}
+}
+
+func DefaultWindowStore() *WindowStore {
+	return __DefaultWindowStore
+}
+
+var __flusherCancel context.CancelFunc
+
+// 默认的窗口存储每秒检查一次空闲的窗口
+func StartWindowFlusher() {
+	ctx, cancel := context.WithCancel(context.Background())
+	__flusherCancel = cancel
+	__DefaultWindowStore.StartFlusher(ctx, time.Second)
+}
+
+func StopWindowFlusher() {
+	if __flusherCancel != nil {
+		__flusherCancel()
+	}
+}
+
+// 窗口按照规则隔离
+func windowId(ruleId, key string) string {
+	return ruleId + "/" + key
+}
+
+// 对齐到 step 的整数倍, 比如1分钟的窗口从整分开始
+func alignEnd(ts, step int64) int64 {
+	return ts - ts%step + step
+}
+
+/*
+*
+* 放入一个样本, 返回这次关闭的所有窗口的聚合结果, 没有数据的窗口不输出
+*
+ */
+func (s *WindowStore) Push(ruleId, key string, size, step, ts int64, value float64) ([]*Result, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid window size: %d", size)
+	}
+	if step <= 0 || step > size {
+		return nil, fmt.Errorf("window step must be in range (0, %d]", size)
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	id := windowId(ruleId, key)
+	w, ok := s.windows[id]
+	// 参数变了就重新开始
+	if !ok || w.size != size || w.step != step {
+		if !ok && len(s.windows) >= maxWindows {
+			return nil, errMaxWindowsReached
+		}
+		w = &window{key: key, size: size, step: step, nextEnd: alignEnd(ts, step)}
+		s.windows[id] = w
+	}
+	results := w.close(ts)
+	if ts >= w.nextEnd {
+		// 中间没有数据, 直接跳到当前时间所在的窗口
+		w.nextEnd = alignEnd(ts, w.step)
+	}
+	// 迟到的数据, 所在的窗口都已经关闭了
+	if ts < w.nextEnd-w.size {
+		return results, nil
+	}
+	if len(w.samples) >= maxWindowSamples {
+		w.samples = w.samples[1:]
+	}
+	w.samples = append(w.samples, sample{ts: ts, value: value})
+	w.lastTs = max(w.lastTs, ts)
+	w.lastSeen = s.now()
+	return results, nil
+}
+
+/*
+*
+* 设置定时器关闭窗口以后的处理函数. 没有处理函数的窗口只在下一个样本到来的时候关闭
+*
+ */
+func (s *WindowStore) OnFlush(ruleId, key string, handler FlushHandler) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	id := windowId(ruleId, key)
+	if _, ok := s.handlers[id]; !ok && len(s.handlers) >= maxWindows {
+		return errMaxWindowsReached
+	}
+	s.handlers[id] = handler
+	return nil
+}
+
+/*
+*
+* 关闭空闲的窗口: 设备不再上报的时候, 时间按照最新样本的时间戳加上之后经过的时间往前推,
+* 结束时间已经过去的窗口交给处理函数. 处理函数在锁外面调用
+*
+ */
+func (s *WindowStore) Flush() {
+	type flushed struct {
+		handler FlushHandler
+		results []*Result
+	}
+	all := []flushed{}
+	s.locker.Lock()
+	now := s.now()
+	for id, w := range s.windows {
+		handler, ok := s.handlers[id]
+		if !ok {
+			continue
+		}
+		if results := w.close(w.lastTs + now - w.lastSeen); len(results) > 0 {
+			all = append(all, flushed{handler: handler, results: results})
+		}
+	}
+	s.locker.Unlock()
+	for _, f := range all {
+		f.handler(f.results)
+	}
+}
+
+/*
+*
+* 定时关闭空闲的窗口, ctx 结束的时候停止
+*
+ */
+func (s *WindowStore) StartFlusher(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Flush()
+			}
+		}
+	}()
+}
+
+/*
+*
+* 还没有关闭的窗口的聚合结果, 没有数据的时候返回 nil
+*
+ */
+func (s *WindowStore) Current(ruleId, key string) *Result {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	w, ok := s.windows[windowId(ruleId, key)]
+	if !ok {
+		return nil
+	}
+	return w.aggregate(key, w.nextEnd-w.size, w.nextEnd)
+}
+
+// 清空某个窗口
+func (s *WindowStore) Reset(ruleId, key string) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	delete(s.windows, windowId(ruleId, key))
+}
+
+// 规则被删除以后清理掉它的所有窗口
+func (s *WindowStore) RemoveRule(ruleId string) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	for _, prefix := range []string{ruleId + "/", ShadowRuleId(ruleId) + "/"} {
+		for id := range s.windows {
+			if strings.HasPrefix(id, prefix) {
+				delete(s.windows, id)
+			}
+		}
+		for id := range s.handlers {
+			if strings.HasPrefix(id, prefix) {
+				delete(s.handlers, id)
+			}
+		}
+	}
+}
+
+// 影子版本的窗口单独存放, 不和线上版本共用样本; 删除规则的时候一起删除
+func ShadowRuleId(ruleId string) string {
+	return ruleId + "#shadow"
+}
+
+// 窗口数量
+func (s *WindowStore) Count() int {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return len(s.windows)
+}
+
+// 依次关闭结束时间不晚于 until 的窗口, 没有数据以后停下来
+func (w *window) close(until int64) []*Result {
+	results := []*Result{}
+	for until >= w.nextEnd && len(w.samples) > 0 {
+		if r := w.aggregate(w.key, w.nextEnd-w.size, w.nextEnd); r != nil {
+			results = append(results, r)
+		}
+		w.nextEnd += w.step
+		w.prune(w.nextEnd - w.size)
+	}
+	return results
+}
+
+// 丢掉 before 之前的数据, 乱序的数据也能处理
+func (w *window) prune(before int64) {
+	kept := w.samples[:0]
+	for _, v := range w.samples {
+		if v.ts >= before {
+			kept = append(kept, v)
+		}
+	}
+	w.samples = kept
+}
+
+func (w *window) aggregate(key string, start, end int64) *Result {
+	r := &Result{Key: key, Start: start, End: end}
+	squares := float64(0)
+	for _, v := range w.samples {
+		if v.ts < start || v.ts >= end {
+			continue
+		}
+		if r.Count == 0 {
+			r.First, r.Min, r.Max = v.value, v.value, v.value
+		}
+		r.Count++
+		r.Sum += v.value
+		squares += v.value * v.value
+		r.Min = math.Min(r.Min, v.value)
+		r.Max = math.Max(r.Max, v.value)
+		r.Last = v.value
+	}
+	if r.Count == 0 {
+		return nil
+	}
+	n := float64(r.Count)
+	r.Avg = r.Sum / n
+	// 总体标准差
+	r.Stddev = math.Sqrt(math.Max(squares/n-r.Avg*r.Avg, 0))
+	return r
+}
@@ -0,0 +1,139 @@
+package interwindow
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_Window_Tumbling(t *testing.T) {
+	s := NewWindowStore()
+	values := []struct {
+		ts    int64
+		value float64
+	}{{60000, 1}, {70000, 2}, {119999, 3}, {120000, 10}}
+	var results []*Result
+	for i, v := range values {
+		r, err := s.Push("rule1", "temp", 60000, 60000, v.ts, v.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < len(values)-1 && len(r) != 0 {
+			t.Fatalf("window must not close at %d", v.ts)
+		}
+		results = r
+	}
+	if len(results) != 1 {
+		t.Fatalf("window must close once, got %d", len(results))
+	}
+	result := results[0]
+	if result.Start != 60000 || result.End != 120000 || result.Count != 3 ||
+		result.Avg != 2 || result.Min != 1 || result.Max != 3 || result.Sum != 6 ||
+		result.First != 1 || result.Last != 3 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if math.Abs(result.Stddev-math.Sqrt(2.0/3.0)) > 1e-9 {
+		t.Fatalf("unexpected stddev: %v", result.Stddev)
+	}
+	current := s.Current("rule1", "temp")
+	if current == nil || current.Count != 1 || current.Last != 10 {
+		t.Fatalf("unexpected current: %+v", current)
+	}
+}
+
+func Test_Window_Sliding(t *testing.T) {
+	s := NewWindowStore()
+	// 窗口 3 秒, 每 1 秒关闭一次
+	for _, ts := range []int64{0, 1000, 2000} {
+		if _, err := s.Push("rule1", "k", 3000, 1000, ts, float64(ts/1000+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, _ := s.Push("rule1", "k", 3000, 1000, 3000, 4)
+	if len(r) != 1 || r[0].Start != 0 || r[0].End != 3000 || r[0].Count != 3 || r[0].Avg != 2 {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	r, _ = s.Push("rule1", "k", 3000, 1000, 4000, 5)
+	if len(r) != 1 || r[0].Start != 1000 || r[0].Count != 3 || r[0].Avg != 3 {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+}
+
+// 一个样本隔了几个步长才到, 中间关闭的窗口都要输出
+func Test_Window_MultiClose(t *testing.T) {
+	s := NewWindowStore()
+	for _, ts := range []int64{0, 1000, 2000} {
+		s.Push("rule1", "k", 3000, 1000, ts, float64(ts/1000+1))
+	}
+	r, err := s.Push("rule1", "k", 3000, 1000, 4500, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 2 {
+		t.Fatalf("expect 2 windows, got %+v", r)
+	}
+	if r[0].Start != 0 || r[0].End != 3000 || r[0].Count != 3 ||
+		r[1].Start != 1000 || r[1].End != 4000 || r[1].Count != 2 {
+		t.Fatalf("unexpected results: %+v %+v", r[0], r[1])
+	}
+	// 隔了很久才来数据, 有数据的窗口关闭以后直接跳到当前的窗口
+	r, _ = s.Push("rule1", "k", 3000, 1000, 60000, 20)
+	if len(r) != 3 || r[2].End != 7000 || r[2].Count != 1 || r[2].Last != 10 {
+		t.Fatalf("unexpected results: %+v", r)
+	}
+	current := s.Current("rule1", "k")
+	if current == nil || current.End != 61000 || current.Count != 1 {
+		t.Fatalf("unexpected current: %+v", current)
+	}
+}
+
+// 设备不再上报, 定时器关闭最后一个窗口
+func Test_Window_Flush(t *testing.T) {
+	s := NewWindowStore()
+	now := int64(100000)
+	s.now = func() int64 { return now }
+	s.Push("rule1", "temp", 60000, 60000, 60000, 1)
+	s.Push("rule1", "temp", 60000, 60000, 70000, 3)
+	s.Push("rule1", "other", 60000, 60000, 70000, 3)
+	flushed := []*Result{}
+	s.OnFlush("rule1", "temp", func(results []*Result) {
+		flushed = append(flushed, results...)
+	})
+	s.Flush()
+	if len(flushed) != 0 {
+		t.Fatalf("window must not close before its end: %+v", flushed)
+	}
+	// 最新样本之后过了 50 秒, 窗口的结束时间 120000 已经过去
+	now += 50000
+	s.Flush()
+	if len(flushed) != 1 || flushed[0].End != 120000 || flushed[0].Count != 2 || flushed[0].Avg != 2 {
+		t.Fatalf("unexpected flushed: %+v", flushed)
+	}
+	s.Flush()
+	if len(flushed) != 1 {
+		t.Fatalf("window must close only once: %+v", flushed)
+	}
+	// 没有处理函数的窗口还是等下一个样本
+	r, _ := s.Push("rule1", "other", 60000, 60000, 130000, 5)
+	if len(r) != 1 || r[0].End != 120000 {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	// 关闭以后的样本进入新的窗口
+	r, _ = s.Push("rule1", "temp", 60000, 60000, 130000, 5)
+	if len(r) != 0 {
+		t.Fatalf("closed window must not be emitted again: %+v", r)
+	}
+}
+
+func Test_Window_RemoveRule(t *testing.T) {
+	s := NewWindowStore()
+	s.Push("rule1", "a", 1000, 1000, 0, 1)
+	s.Push("rule1", "b", 1000, 1000, 0, 1)
+	s.Push("rule2", "a", 1000, 1000, 0, 1)
+	s.RemoveRule("rule1")
+	if s.Count() != 1 {
+		t.Fatalf("expect 1 window, got %d", s.Count())
+	}
+	if _, err := s.Push("rule1", "a", 1000, 2000, 0, 1); err == nil {
+		t.Fatal("step larger than size must be rejected")
+	}
+}
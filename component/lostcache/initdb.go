@@ -19,6 +19,7 @@ import "github.com/hootrhino/rhilex/typex"
 
 func InitAll(e typex.Rhilex) {
 	InitLostCacheDb(e)
+	InitOutbox()
 }
 
 func StopAll() {
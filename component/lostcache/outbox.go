@@ -0,0 +1,514 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package lostcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/intermetric"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/resconfig"
+	"github.com/hootrhino/rhilex/typex"
+	"gorm.io/gorm"
+)
+
+// 每次从数据库取出来补发的条数
+const outboxReplayBatch = 100
+
+// 过期检查的间隔
+const outboxExpireInterval = time.Minute
+
+var __DefaultOutbox = struct {
+	sync.Mutex
+	boxes map[string]*outbox
+}{boxes: map[string]*outbox{}}
+
+/*
+*
+* 每个北向资源一个发件箱: 目标 DOWN 或者发送失败的时候消息按顺序落盘,
+* 监控器发现目标 UP 以后按照限速补发
+*
+ */
+type outbox struct {
+	uuid         string
+	owner        *typex.OutEnd // 配置来源, 资源重新加载以后重新解析配置
+	config       resconfig.OutboxConfig
+	locker       sync.Mutex // 入队和补发互斥, 保证顺序
+	backlog      int64
+	backlogBytes int64
+	dropped      uint64
+	down         bool
+	replaying    bool
+	lastExpire   time.Time
+}
+
+/*
+*
+* 发件箱的状态, 北向资源详情接口里面展示
+*
+ */
+type OutboxStats struct {
+	Enable       bool                   `json:"enable"`
+	Backlog      int64                  `json:"backlog"`      // 积压的消息条数
+	BacklogBytes int64                  `json:"backlogBytes"` // 积压的消息大小
+	DeadLetters  int64                  `json:"deadLetters"`  // 死信条数
+	Dropped      uint64                 `json:"dropped"`      // 超过配额或者过期被丢弃的条数, 重启以后清零
+	Down         bool                   `json:"down"`         // 监控器发现目标 DOWN
+	Replaying    bool                   `json:"replaying"`    // 正在补发
+	OldestTs     uint64                 `json:"oldestTs"`     // 最早一条积压消息的时间, 毫秒
+	Config       resconfig.OutboxConfig `json:"config"`
+}
+
+func InitOutbox() {
+	LostCacheDbRegisterModel(&MOutboxMessage{}, &MOutboxDeadLetter{})
+}
+
+/*
+*
+* 解析资源配置里的 outbox, 没有填的字段使用默认值
+*
+ */
+func ParseOutboxConfig(config map[string]any) (resconfig.OutboxConfig, error) {
+	outboxConfig := resconfig.DefaultOutboxConfig()
+	value, ok := config["outbox"]
+	if !ok || value == nil {
+		return outboxConfig, nil
+	}
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return outboxConfig, err
+	}
+	if err := json.Unmarshal(bytes, &outboxConfig); err != nil {
+		return outboxConfig, err
+	}
+	if outboxConfig.Enable == nil {
+		outboxConfig.Enable = new(bool)
+	}
+	return outboxConfig, outboxConfig.Validate()
+}
+
+// 获取资源的发件箱, 资源重新加载以后更新配置
+func getOutbox(out *typex.OutEnd) *outbox {
+	__DefaultOutbox.Lock()
+	defer __DefaultOutbox.Unlock()
+	box, ok := __DefaultOutbox.boxes[out.UUID]
+	if ok && box.owner == out {
+		return box
+	}
+	config, err := ParseOutboxConfig(out.Config)
+	if err != nil {
+		glogger.GLogger.Warnf("OutEnd:%s invalid outbox config, use default: %v", out.UUID, err)
+		config = resconfig.DefaultOutboxConfig()
+	}
+	if !ok {
+		box = &outbox{uuid: out.UUID}
+		box.refresh()
+		__DefaultOutbox.boxes[out.UUID] = box
+	}
+	box.locker.Lock()
+	box.owner = out
+	box.config = config
+	box.locker.Unlock()
+	return box
+}
+
+func findOutbox(uuid string) *outbox {
+	__DefaultOutbox.Lock()
+	defer __DefaultOutbox.Unlock()
+	return __DefaultOutbox.boxes[uuid]
+}
+
+/*
+*
+* 发送数据: 没有开启发件箱的时候直接发送; 开启以后有积压或者目标 DOWN 的时候排队,
+* 发送失败也排队. 返回的 bool 表示是否已经发送成功
+*
+ */
+func DeliverOutbox(out *typex.OutEnd, data string) (bool, error) {
+	box := getOutbox(out)
+	// 目标的 To 是同步的, 可能卡住很久, 不能持有锁调用, 否则 DOWN 和补发都会被卡住
+	box.locker.Lock()
+	enabled := box.config.Enabled()
+	if enabled {
+		box.expire(false)
+		// 有积压的时候排在后面, 保证顺序
+		if box.down || box.backlog > 0 {
+			err := box.enqueue(data, "")
+			box.locker.Unlock()
+			return false, err
+		}
+	}
+	box.locker.Unlock()
+	if _, err := out.Target.To(data); err != nil {
+		if enabled {
+			box.locker.Lock()
+			if errEnqueue := box.enqueue(data, err.Error()); errEnqueue != nil {
+				glogger.GLogger.Error(errEnqueue)
+			}
+			box.locker.Unlock()
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+/*
+*
+* 资源正在重新加载的时候保存到发件箱, 已经删除的资源不会保存
+*
+ */
+func SaveToOutbox(out *typex.OutEnd, data string) error {
+	box := findOutbox(out.UUID)
+	if box == nil {
+		return fmt.Errorf("outEnd not exists:%s", out.UUID)
+	}
+	box.locker.Lock()
+	defer box.locker.Unlock()
+	if !box.config.Enabled() {
+		return fmt.Errorf("outEnd:%s outbox disabled", out.UUID)
+	}
+	return box.enqueue(data, "outEnd reloading")
+}
+
+/*
+*
+* 监控器发现目标 DOWN
+*
+ */
+func MarkOutboxDown(uuid string) {
+	if box := findOutbox(uuid); box != nil {
+		box.locker.Lock()
+		box.down = true
+		box.locker.Unlock()
+	}
+}
+
+/*
+*
+* 监控器发现目标 UP 以后开始补发, 已经在补发的话什么都不做
+*
+ */
+func ReplayOutbox(out *typex.OutEnd) {
+	box := getOutbox(out)
+	box.locker.Lock()
+	defer box.locker.Unlock()
+	box.down = false
+	if box.replaying || box.backlog == 0 {
+		return
+	}
+	box.replaying = true
+	go box.replay(out)
+}
+
+// 按照顺序限速补发, 目标再次失败就停下来等下次 UP
+func (box *outbox) replay(out *typex.OutEnd) {
+	defer func() {
+		box.locker.Lock()
+		box.replaying = false
+		box.locker.Unlock()
+	}()
+	box.locker.Lock()
+	rate := box.config.ReplayRate
+	box.locker.Unlock()
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	for {
+		box.locker.Lock()
+		box.expire(true)
+		batch := []MOutboxMessage{}
+		LostCacheDb().Where("outend_id=?", box.uuid).Order("id ASC").
+			Limit(outboxReplayBatch).Find(&batch)
+		box.locker.Unlock()
+		if len(batch) == 0 {
+			glogger.GLogger.Infof("OutEnd:%s outbox replay finished", box.uuid)
+			return
+		}
+		for _, message := range batch {
+			<-ticker.C
+			if !box.replayOne(out, message) {
+				return
+			}
+		}
+	}
+}
+
+// 补发一条, 返回 false 表示需要停止补发. 补发的时候消息还在积压里面, 同时到达的数据会排在后面
+func (box *outbox) replayOne(out *typex.OutEnd, message MOutboxMessage) bool {
+	box.locker.Lock()
+	if box.down || box.owner != out {
+		box.locker.Unlock()
+		return false
+	}
+	box.locker.Unlock()
+	start := time.Now()
+	_, err := out.Target.To(message.Data)
+	box.locker.Lock()
+	defer box.locker.Unlock()
+	if err != nil {
+		intermetric.IncOutFailed(box.uuid, time.Since(start))
+		message.Attempts++
+		if message.Attempts < box.config.MaxRetries {
+			LostCacheDb().Model(&MOutboxMessage{}).Where("id=?", message.ID).
+				Updates(map[string]any{"attempts": message.Attempts, "last_error": err.Error()})
+			glogger.GLogger.Warnf("OutEnd:%s outbox replay failed, will retry: %v", box.uuid, err)
+			return false
+		}
+		glogger.GLogger.Errorf("OutEnd:%s outbox message %d retried %d times, move to dead letter: %v",
+			box.uuid, message.ID, message.Attempts, err)
+		message.LastError = err.Error()
+		box.moveToDeadLetter(message)
+		return true
+	}
+	intermetric.IncOut(box.uuid, time.Since(start))
+	box.remove(message)
+	return true
+}
+
+// 入队, 超过配额以后丢弃最早的消息; 需要持有锁
+func (box *outbox) enqueue(data string, reason string) error {
+	size := int64(len(data))
+	maxBytes := int64(box.config.MaxDiskSize) * 1024
+	if size > maxBytes {
+		box.dropped++
+		return fmt.Errorf("outEnd:%s message size %d exceeds outbox disk quota", box.uuid, size)
+	}
+	for box.backlog > 0 && (box.backlog+1 > int64(box.config.MaxBacklog) ||
+		box.backlogBytes+size > maxBytes) {
+		n := box.backlog + 1 - int64(box.config.MaxBacklog)
+		if n < 1 {
+			n = 1
+		}
+		if box.evictOldest(int(n)) == 0 {
+			break
+		}
+	}
+	message := MOutboxMessage{
+		OutendId:  box.uuid,
+		Data:      data,
+		Size:      len(data),
+		LastError: reason,
+	}
+	if err := LostCacheDb().Create(&message).Error; err != nil {
+		return err
+	}
+	box.backlog++
+	box.backlogBytes += size
+	return nil
+}
+
+// 丢弃最早的 n 条消息; 需要持有锁
+func (box *outbox) evictOldest(n int) int {
+	oldest := []MOutboxMessage{}
+	LostCacheDb().Select("id", "size").Where("outend_id=?", box.uuid).
+		Order("id ASC").Limit(n).Find(&oldest)
+	for _, message := range oldest {
+		box.remove(message)
+	}
+	box.dropped += uint64(len(oldest))
+	if len(oldest) > 0 {
+		glogger.GLogger.Warnf("OutEnd:%s outbox quota exceeded, drop %d oldest messages", box.uuid, len(oldest))
+	}
+	return len(oldest)
+}
+
+// 删除一条消息并更新计数; 需要持有锁
+func (box *outbox) remove(message MOutboxMessage) {
+	result := LostCacheDb().Where("id=?", message.ID).Delete(&MOutboxMessage{})
+	if result.RowsAffected > 0 {
+		box.backlog--
+		box.backlogBytes -= int64(message.Size)
+	}
+}
+
+// 移到死信表, 死信最多保留 MaxBacklog 条; 需要持有锁
+func (box *outbox) moveToDeadLetter(message MOutboxMessage) {
+	err := LostCacheDb().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&MOutboxDeadLetter{
+			OutendId:  box.uuid,
+			Data:      message.Data,
+			Attempts:  message.Attempts,
+			LastError: message.LastError,
+			QueuedAt:  message.CreatedAt,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("outend_id=? AND id NOT IN (?)", box.uuid,
+			tx.Model(&MOutboxDeadLetter{}).Select("id").Where("outend_id=?", box.uuid).
+				Order("id DESC").Limit(box.config.MaxBacklog)).
+			Delete(&MOutboxDeadLetter{}).Error
+	})
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return
+	}
+	box.remove(message)
+}
+
+// 删除过期的消息, force 为 false 的时候每分钟最多检查一次; 需要持有锁
+func (box *outbox) expire(force bool) {
+	if box.config.TTL <= 0 || box.backlog == 0 {
+		return
+	}
+	if !force && time.Since(box.lastExpire) < outboxExpireInterval {
+		return
+	}
+	box.lastExpire = time.Now()
+	deadline := time.Now().Add(-time.Duration(box.config.TTL) * time.Second)
+	result := LostCacheDb().Where("outend_id=? AND created_at < ?", box.uuid, deadline).
+		Delete(&MOutboxMessage{})
+	if result.RowsAffected > 0 {
+		glogger.GLogger.Warnf("OutEnd:%s outbox drop %d expired messages", box.uuid, result.RowsAffected)
+		box.dropped += uint64(result.RowsAffected)
+		box.refresh()
+	}
+}
+
+// 从数据库重新统计积压; 需要持有锁或者还没有发布出去
+func (box *outbox) refresh() {
+	var stats struct {
+		Count int64
+		Bytes int64
+	}
+	LostCacheDb().Model(&MOutboxMessage{}).Select("COUNT(*) AS count, COALESCE(SUM(size), 0) AS bytes").
+		Where("outend_id=?", box.uuid).Scan(&stats)
+	box.backlog = stats.Count
+	box.backlogBytes = stats.Bytes
+}
+
+/*
+*
+* 发件箱的状态, config 为资源的配置
+*
+ */
+func GetOutboxStats(uuid string, config map[string]any) OutboxStats {
+	outboxConfig, err := ParseOutboxConfig(config)
+	if err != nil {
+		outboxConfig = resconfig.DefaultOutboxConfig()
+	}
+	stats := OutboxStats{Enable: outboxConfig.Enabled(), Config: outboxConfig}
+	if box := findOutbox(uuid); box != nil {
+		box.locker.Lock()
+		stats.Backlog = box.backlog
+		stats.BacklogBytes = box.backlogBytes
+		stats.Dropped = box.dropped
+		stats.Down = box.down
+		stats.Replaying = box.replaying
+		box.locker.Unlock()
+	} else {
+		tmp := outbox{uuid: uuid}
+		tmp.refresh()
+		stats.Backlog = tmp.backlog
+		stats.BacklogBytes = tmp.backlogBytes
+	}
+	LostCacheDb().Model(&MOutboxDeadLetter{}).Where("outend_id=?", uuid).Count(&stats.DeadLetters)
+	if stats.Backlog > 0 {
+		oldest := MOutboxMessage{}
+		if LostCacheDb().Select("created_at").Where("outend_id=?", uuid).
+			Order("id ASC").Limit(1).Find(&oldest).RowsAffected > 0 {
+			stats.OldestTs = uint64(oldest.CreatedAt.UnixMilli())
+		}
+	}
+	return stats
+}
+
+/*
+*
+* 分页获取死信
+*
+ */
+func PageOutboxDeadLetters(uuid string, current, size int) (int64, []MOutboxDeadLetter) {
+	var count int64
+	records := []MOutboxDeadLetter{}
+	LostCacheDb().Model(&MOutboxDeadLetter{}).Where("outend_id=?", uuid).Count(&count)
+	LostCacheDb().Where("outend_id=?", uuid).Order("id ASC").
+		Offset((current - 1) * size).Limit(size).Find(&records)
+	return count, records
+}
+
+/*
+*
+* 死信重新放回补发队列的末尾
+*
+ */
+func RequeueOutboxDeadLetters(uuid string) (int64, error) {
+	var count int64
+	err := LostCacheDb().Transaction(func(tx *gorm.DB) error {
+		deadLetters := []MOutboxDeadLetter{}
+		if err := tx.Where("outend_id=?", uuid).Order("id ASC").Find(&deadLetters).Error; err != nil {
+			return err
+		}
+		for _, deadLetter := range deadLetters {
+			if err := tx.Create(&MOutboxMessage{
+				OutendId:  uuid,
+				Data:      deadLetter.Data,
+				Size:      len(deadLetter.Data),
+				LastError: deadLetter.LastError,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		count = int64(len(deadLetters))
+		return tx.Where("outend_id=?", uuid).Delete(&MOutboxDeadLetter{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	if box := findOutbox(uuid); box != nil {
+		box.locker.Lock()
+		box.refresh()
+		box.locker.Unlock()
+	}
+	return count, nil
+}
+
+// 清空死信
+func ClearOutboxDeadLetters(uuid string) error {
+	return LostCacheDb().Where("outend_id=?", uuid).Delete(&MOutboxDeadLetter{}).Error
+}
+
+// 清空积压的消息
+func ClearOutbox(uuid string) error {
+	if box := findOutbox(uuid); box != nil {
+		box.locker.Lock()
+		defer box.locker.Unlock()
+		err := LostCacheDb().Where("outend_id=?", uuid).Delete(&MOutboxMessage{}).Error
+		box.refresh()
+		return err
+	}
+	return LostCacheDb().Where("outend_id=?", uuid).Delete(&MOutboxMessage{}).Error
+}
+
+/*
+*
+* 资源被删除以后清理掉发件箱
+*
+ */
+func RemoveOutbox(uuid string) {
+	__DefaultOutbox.Lock()
+	box := __DefaultOutbox.boxes[uuid]
+	delete(__DefaultOutbox.boxes, uuid)
+	__DefaultOutbox.Unlock()
+	if box != nil {
+		box.locker.Lock()
+		box.down = true // 让正在补发的协程退出
+		box.locker.Unlock()
+	}
+	LostCacheDb().Where("outend_id=?", uuid).Delete(&MOutboxMessage{})
+	LostCacheDb().Where("outend_id=?", uuid).Delete(&MOutboxDeadLetter{})
+}
@@ -0,0 +1,48 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package lostcache
+
+import "time"
+
+/*
+*
+* 等待补发的消息, 按照 ID 顺序补发
+*
+ */
+type MOutboxMessage struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	OutendId  string    `gorm:"index" json:"outendId"`
+	Data      string    `json:"data"`
+	Size      int       `json:"size"`      // 数据长度, 用来计算磁盘配额
+	Attempts  int       `json:"attempts"`  // 补发失败的次数
+	LastError string    `json:"lastError"` // 最近一次失败的原因
+}
+
+/*
+*
+* 死信: 补发失败次数超过上限的消息
+*
+ */
+type MOutboxDeadLetter struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	OutendId  string    `gorm:"index" json:"outendId"`
+	Data      string    `json:"data"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError"`
+	QueuedAt  time.Time `json:"queuedAt"` // 进入补发队列的时间
+}
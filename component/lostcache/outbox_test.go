@@ -0,0 +1,167 @@
+package lostcache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/sirupsen/logrus"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 测试用的目标, fail 为 true 的时候发送失败
+type fakeTarget struct {
+	fail bool
+	sent []string
+}
+
+func (t *fakeTarget) Init(string, map[string]any) error { return nil }
+func (t *fakeTarget) Start(typex.CCTX) error            { return nil }
+func (t *fakeTarget) Status() typex.SourceState         { return typex.SOURCE_UP }
+func (t *fakeTarget) Details() *typex.OutEnd            { return nil }
+func (t *fakeTarget) Stop()                             {}
+func (t *fakeTarget) To(data any) (any, error) {
+	if t.fail {
+		return nil, errors.New("offline")
+	}
+	t.sent = append(t.sent, data.(string))
+	return nil, nil
+}
+
+// 测试用的目标, 发送的时候一直阻塞到 release 关闭
+type blockingTarget struct {
+	fakeTarget
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (t *blockingTarget) To(data any) (any, error) {
+	close(t.entered)
+	<-t.release
+	return nil, nil
+}
+
+func setupOutboxTest(t *testing.T) {
+	glogger.GLogger = logrus.NewEntry(logrus.New())
+	db, err := gorm.Open(sqlite.Open("file:outbox_test?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	__LostCache = &SqliteDAO{name: "Sqlite3", db: db}
+	InitOutbox()
+}
+
+func newTestOutEnd(uuid string, target *fakeTarget, outbox map[string]any) *typex.OutEnd {
+	return &typex.OutEnd{
+		UUID:   uuid,
+		Config: map[string]any{"outbox": outbox},
+		Target: target,
+	}
+}
+
+func waitReplay(t *testing.T, uuid string) {
+	for i := 0; i < 200; i++ {
+		box := findOutbox(uuid)
+		box.locker.Lock()
+		replaying := box.replaying
+		box.locker.Unlock()
+		if !replaying {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("replay not finished")
+}
+
+func Test_Outbox_StoreAndForward(t *testing.T) {
+	setupOutboxTest(t)
+	target := &fakeTarget{fail: true}
+	out := newTestOutEnd("OUTEND_1", target, map[string]any{"enable": true, "replayRate": 1000})
+	defer RemoveOutbox(out.UUID)
+	if _, err := DeliverOutbox(out, "1"); err == nil {
+		t.Fatal("send to offline target must fail")
+	}
+	// 恢复以后还有积压, 新的数据排在后面
+	target.fail = false
+	if sent, err := DeliverOutbox(out, "2"); sent || err != nil {
+		t.Fatalf("message must be queued behind backlog, sent=%v err=%v", sent, err)
+	}
+	if stats := GetOutboxStats(out.UUID, out.Config); stats.Backlog != 2 {
+		t.Fatalf("expect backlog 2, got %d", stats.Backlog)
+	}
+	ReplayOutbox(out)
+	waitReplay(t, out.UUID)
+	if len(target.sent) != 2 || target.sent[0] != "1" || target.sent[1] != "2" {
+		t.Fatalf("replay must keep order, got %v", target.sent)
+	}
+	if sent, err := DeliverOutbox(out, "3"); !sent || err != nil {
+		t.Fatalf("empty backlog must send directly, sent=%v err=%v", sent, err)
+	}
+}
+
+func Test_Outbox_DeadLetterAndQuota(t *testing.T) {
+	setupOutboxTest(t)
+	target := &fakeTarget{fail: true}
+	out := newTestOutEnd("OUTEND_2", target, map[string]any{
+		"enable": true, "replayRate": 1000, "maxRetries": 1, "maxBacklog": 2,
+	})
+	defer RemoveOutbox(out.UUID)
+	for _, data := range []string{"1", "2", "3"} {
+		DeliverOutbox(out, data)
+	}
+	stats := GetOutboxStats(out.UUID, out.Config)
+	if stats.Backlog != 2 || stats.Dropped != 1 {
+		t.Fatalf("oldest message must be dropped, got %+v", stats)
+	}
+	ReplayOutbox(out)
+	waitReplay(t, out.UUID)
+	stats = GetOutboxStats(out.UUID, out.Config)
+	if stats.Backlog != 0 || stats.DeadLetters != 2 {
+		t.Fatalf("failed messages must move to dead letter, got %+v", stats)
+	}
+	if n, err := RequeueOutboxDeadLetters(out.UUID); err != nil || n != 2 {
+		t.Fatalf("requeue failed: %d %v", n, err)
+	}
+	target.fail = false
+	ReplayOutbox(out)
+	waitReplay(t, out.UUID)
+	if len(target.sent) != 2 || target.sent[0] != "2" || target.sent[1] != "3" {
+		t.Fatalf("requeued messages must be replayed, got %v", target.sent)
+	}
+}
+
+// 目标卡住的时候不能卡住发件箱的其他操作
+func Test_Outbox_BlockedTarget(t *testing.T) {
+	setupOutboxTest(t)
+	target := &blockingTarget{entered: make(chan struct{}), release: make(chan struct{})}
+	out := &typex.OutEnd{UUID: "OUTEND_3", Config: map[string]any{}, Target: target}
+	defer RemoveOutbox(out.UUID)
+	go DeliverOutbox(out, "1")
+	<-target.entered
+	done := make(chan struct{})
+	go func() {
+		MarkOutboxDown(out.UUID)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("MarkOutboxDown blocked by a hung target")
+	}
+	close(target.release)
+}
+
+func Test_Outbox_ReplayRateBound(t *testing.T) {
+	for _, rate := range []int{0, 2000000000} {
+		config := map[string]any{"outbox": map[string]any{"enable": true, "replayRate": rate}}
+		if _, err := ParseOutboxConfig(config); err == nil {
+			t.Fatalf("replayRate %d must be rejected", rate)
+		}
+	}
+}
@@ -16,21 +16,49 @@
 package luaexecutor
 
 import (
+	"context"
+	"time"
+
 	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/interflow"
+	"github.com/hootrhino/rhilex/component/intermetric"
+	"github.com/hootrhino/rhilex/component/luaguard"
+	"github.com/hootrhino/rhilex/component/rulerollout"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
 	"github.com/sirupsen/logrus"
 )
 
-// executeRule 执行单个规则
-func executeRule(rule *typex.Rule, callbackArgs lua.LValue) bool {
-	_, errA := ExecuteActions(rule, callbackArgs)
+// executeRule 执行单个规则, Actions 和 Success 一起受执行预算限制
+func executeRule(rule *typex.Rule, callbackArgs lua.LValue, resource typex.RuleResource) bool {
+	// 连续超出预算被禁用的规则直接跳过, 不影响后面的规则
+	if luaguard.Disabled(rule.UUID) {
+		return true
+	}
+	start := time.Now()
+	var output lua.LValue
+	var errA, errS error
+	rule.LuaVM.SetContext(typex.WithRuleResource(context.Background(), resource))
+	defer rule.LuaVM.RemoveContext()
+	err := luaguard.Run(rule.LuaVM, luaguard.RuleBudget(rule.Budget), func() error {
+		if output, errA = ExecuteActions(rule, callbackArgs, NewRuleContext(rule, resource)); errA != nil {
+			return errA
+		}
+		_, errS = ExecuteSuccess(rule.LuaVM)
+		return errS
+	})
+	intermetric.ObserveRule(rule.UUID, time.Since(start), err == nil)
+	rulerollout.Observe(rule.UUID, rule.Version, err == nil)
+	if v, ok := luaguard.AsViolation(err); ok {
+		luaguard.ReportRuleViolation(rule.UUID, v)
+		return false
+	}
+	luaguard.RecordSuccess(rule.UUID)
+	RunShadow(rule, callbackArgs, resource, output, errA)
 	if errA != nil {
 		handleError(rule, errA)
 		return false
 	}
-
-	_, errS := ExecuteSuccess(rule.LuaVM)
 	if errS != nil {
 		glogger.GLogger.Error(errS)
 		return false // lua 是规则链，有短路原则，中途出错会中断
@@ -72,9 +100,12 @@ func handleError(rule *typex.Rule, err error) {
  */
 func RunSourceCallbacks(in *typex.InEnd, callbackArgs string) {
 	// 执行来自资源的脚本
+	resource := typex.RuleResource{Kind: "INEND", UUID: in.UUID, Name: in.Name, Type: in.Type.String()}
+	// 可视化编排的流程和规则互不影响
+	interflow.RunFlows(resource, callbackArgs)
 	for _, rule := range in.BindRules {
 		if rule.Status == typex.RULE_RUNNING {
-			if !executeRule(&rule, lua.LString(callbackArgs)) {
+			if !executeRule(&rule, lua.LString(callbackArgs), resource) {
 				return
 			}
 		}
@@ -87,8 +118,10 @@ func RunSourceCallbacks(in *typex.InEnd, callbackArgs string) {
 *
  */
 func RunDeviceCallbacks(Device *typex.Device, callbackArgs string) {
+	resource := typex.RuleResource{Kind: "DEVICE", UUID: Device.UUID, Name: Device.Name, Type: Device.Type.String()}
+	interflow.RunFlows(resource, callbackArgs)
 	for _, rule := range Device.BindRules {
-		if !executeRule(&rule, lua.LString(callbackArgs)) {
+		if !executeRule(&rule, lua.LString(callbackArgs), resource) {
 			return
 		}
 	}
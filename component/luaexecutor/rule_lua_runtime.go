@@ -63,10 +63,10 @@ func ExecuteFailed(vm *lua.LState, arg lua.LValue) (any, error) {
 
 /*
 *
-* Execute Lua Callback
+* Execute Lua Callback, extra 会跟在数据后面传给每个 Action, 比如数据来源的上下文
 *
  */
-func ExecuteActions(rule *typex.Rule, arg lua.LValue) (lua.LValue, error) {
+func ExecuteActions(rule *typex.Rule, arg lua.LValue, extra ...lua.LValue) (lua.LValue, error) {
 	// 原始 lua 数据结构
 	luaOriginTable := rule.LuaVM.GetGlobal(ACTIONS_KEY)
 	// 检查 'Actions' 是否存在且为 Lua 表
@@ -94,7 +94,24 @@ func ExecuteActions(rule *typex.Rule, arg lua.LValue) (lua.LValue, error) {
 	}
 	// Rule may stop
 	if rule.Status != typex.RULE_STOP {
-		return interpipeline.RunPipline(rule.LuaVM, funcs, arg)
+		return interpipeline.RunPipline(rule.LuaVM, funcs, arg, extra...)
 	}
 	return lua.LNil, nil
 }
+
+/*
+*
+* 规则的上下文: 数据来自哪个资源. 一个规则绑定多个资源的时候, Actions 用第二个参数区分来源
+* Actions = { function(args, ctx) Debug(ctx.uuid .. ctx.name) return true, args end }
+*
+ */
+func NewRuleContext(rule *typex.Rule, resource typex.RuleResource) *lua.LTable {
+	ctx := rule.LuaVM.NewTable()
+	ctx.RawSetString("kind", lua.LString(resource.Kind))
+	ctx.RawSetString("uuid", lua.LString(resource.UUID))
+	ctx.RawSetString("name", lua.LString(resource.Name))
+	ctx.RawSetString("type", lua.LString(resource.Type))
+	ctx.RawSetString("ruleId", lua.LString(rule.UUID))
+	ctx.RawSetString("version", lua.LNumber(rule.Version))
+	return ctx
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package luaexecutor
+
+import (
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/luaguard"
+	"github.com/hootrhino/rhilex/component/rulerollout"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+/*
+*
+* 影子模式: 线上版本执行完以后用同样的输入执行待发布的版本, 输出丢弃, 只和线上版本的
+* 输出比较. 影子版本出错或者超出预算只记录下来, 不影响线上版本, 也不会禁用规则
+*
+ */
+func RunShadow(rule *typex.Rule, callbackArgs lua.LValue, resource typex.RuleResource,
+	liveOutput lua.LValue, liveErr error) {
+	shadow := rule.Shadow
+	if shadow == nil {
+		return
+	}
+	var output lua.LValue
+	err := luaguard.Run(shadow.LuaVM, luaguard.RuleBudget(shadow.Budget), func() error {
+		var errA error
+		output, errA = ExecuteActions(shadow, callbackArgs, NewRuleContext(shadow, resource))
+		return errA
+	})
+	// 都失败也算一致, 错误信息里面有行号, 不比较
+	matched := (err == nil) == (liveErr == nil)
+	if matched && err == nil {
+		matched = rulerollout.Encode(liveOutput) == rulerollout.Encode(output)
+	}
+	var mismatch *rulerollout.ShadowMismatch
+	if !matched {
+		mismatch = rulerollout.NewMismatch(resource.UUID, callbackArgs.String(),
+			shadowResult(liveOutput, liveErr), shadowResult(output, err))
+	}
+	rulerollout.ObserveShadow(rule.UUID, shadow.Version, err != nil && liveErr == nil, mismatch)
+}
+
+func shadowResult(output lua.LValue, err error) string {
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return rulerollout.Encode(output)
+}
@@ -0,0 +1,268 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package luaguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/hootrhino/gopher-lua"
+	core "github.com/hootrhino/rhilex/config"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+// 超出预算的类型
+const (
+	VIOLATION_TIMEOUT      string = "TIMEOUT"
+	VIOLATION_INSTRUCTIONS string = "INSTRUCTIONS"
+	VIOLATION_MEMORY       string = "MEMORY"
+)
+
+/*
+*
+* 单次执行的预算, 0 表示不限制. 内存是虚拟机能访问到的数据(全局变量, 调用栈上的局部
+* 变量和闭包)估算出来的大小, 不是进程的堆, 不会把其他协程的分配算进来
+*
+ */
+type Budget struct {
+	Timeout         time.Duration `json:"timeout"`
+	MaxInstructions int64         `json:"maxInstructions"`
+	MaxMemory       int64         `json:"maxMemory"` // 字节
+}
+
+func (b Budget) Unlimited() bool {
+	return b.Timeout <= 0 && b.MaxInstructions <= 0 && b.MaxMemory <= 0
+}
+
+/*
+*
+* 规则的预算, 没有配置的项使用全局配置
+*
+ */
+func RuleBudget(b typex.LuaBudget) Budget {
+	return Budget{
+		Timeout: time.Duration(pick(int64(b.Timeout),
+			int64(core.GlobalConfig.LuaTimeout))) * time.Millisecond,
+		MaxInstructions: pick(b.MaxInstructions, core.GlobalConfig.LuaMaxInstructions),
+		MaxMemory:       pick(int64(b.MaxMemory), int64(core.GlobalConfig.LuaMaxMemory)) << 20,
+	}
+}
+
+/*
+*
+* 应用的预算: Main 一般是常驻的循环, 默认不限制时间和指令数, 只限制内存
+*
+ */
+func AppletBudget(b typex.LuaBudget) Budget {
+	return Budget{
+		Timeout:         time.Duration(pick(int64(b.Timeout), 0)) * time.Millisecond,
+		MaxInstructions: pick(b.MaxInstructions, 0),
+		MaxMemory:       pick(int64(b.MaxMemory), int64(core.GlobalConfig.LuaMaxMemory)) << 20,
+	}
+}
+
+// 0 使用默认值, 小于 0 不限制
+func pick(value, defaultValue int64) int64 {
+	if value < 0 {
+		return 0
+	}
+	if value == 0 {
+		return max(defaultValue, 0)
+	}
+	return value
+}
+
+/*
+*
+* 超出预算以后中止执行返回的错误
+*
+ */
+type Violation struct {
+	Kind         string        `json:"kind"`
+	Limit        string        `json:"limit"`
+	Cost         time.Duration `json:"cost"`
+	Instructions int64         `json:"instructions"`
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("execution budget exceeded: %s, limit: %s, cost: %v, instructions: %d",
+		v.Kind, v.Limit, v.Cost, v.Instructions)
+}
+
+func AsViolation(err error) (*Violation, bool) {
+	var v *Violation
+	ok := errors.As(err, &v)
+	return v, ok
+}
+
+/*
+*
+* 在预算内执行: LState 每执行一条指令都会检查一次 Context.Done(), 用它来计数和检查内存,
+* 超时由定时器关闭 Done, 超出预算以后 Lua 抛出错误结束当前调用. LState 原来的 Context
+* (比如应用的 Context) 作为父 Context, 取消的时候同样会结束执行.
+*
+ */
+func Run(L *lua.LState, budget Budget, f func() error) error {
+	if budget.Unlimited() {
+		return f()
+	}
+	parent := L.Context()
+	g := newGuard(L, parent, budget)
+	L.SetContext(g)
+	defer func() {
+		g.release()
+		if parent != nil {
+			L.SetContext(parent)
+		} else {
+			L.RemoveContext()
+		}
+	}()
+	// RaiseError 不会 panic, 中止以后调用可能正常返回, 所以以 Context 的状态为准
+	err := f()
+	g.finish()
+	if v := g.violation(); v != nil {
+		return v
+	}
+	return err
+}
+
+/*
+*
+* 实现 context.Context, 只在一次调用里面使用
+*
+ */
+type guard struct {
+	parent       context.Context
+	budget       Budget
+	start        time.Time
+	instructions atomic.Int64
+	done         chan struct{}
+	once         sync.Once
+	err          error // 关闭 done 之前写入
+	timer        *time.Timer
+	stopParent   func() bool
+	memory       *memoryMeter
+}
+
+func newGuard(L *lua.LState, parent context.Context, budget Budget) *guard {
+	g := &guard{
+		parent: parent,
+		budget: budget,
+		start:  time.Now(),
+		done:   make(chan struct{}),
+	}
+	if budget.MaxMemory > 0 {
+		g.memory = newMemoryMeter(L, budget.MaxMemory)
+	}
+	if budget.Timeout > 0 {
+		g.timer = time.AfterFunc(budget.Timeout, func() {
+			g.trip(VIOLATION_TIMEOUT, budget.Timeout.String())
+		})
+	}
+	if parent != nil {
+		g.stopParent = context.AfterFunc(parent, func() {
+			g.cancel(parent.Err())
+		})
+	}
+	return g
+}
+
+func (g *guard) Deadline() (time.Time, bool) {
+	if g.budget.Timeout > 0 {
+		return g.start.Add(g.budget.Timeout), true
+	}
+	if g.parent != nil {
+		return g.parent.Deadline()
+	}
+	return time.Time{}, false
+}
+
+// 虚拟机的协程每条指令调用一次
+func (g *guard) Done() <-chan struct{} {
+	n := g.instructions.Add(1)
+	if g.budget.MaxInstructions > 0 && n > g.budget.MaxInstructions {
+		g.trip(VIOLATION_INSTRUCTIONS, fmt.Sprintf("%d", g.budget.MaxInstructions))
+	}
+	if g.memory != nil && g.memory.due(n) && g.memory.exceeded() {
+		g.trip(VIOLATION_MEMORY, fmt.Sprintf("%dMB", g.budget.MaxMemory>>20))
+	}
+	return g.done
+}
+
+// 执行结束以后在虚拟机的协程里面检查一次累计到期的内存
+func (g *guard) finish() {
+	if g.memory == nil {
+		return
+	}
+	n := g.instructions.Load()
+	if g.violation() == nil && g.memory.due(n) && g.memory.exceeded() {
+		g.trip(VIOLATION_MEMORY, fmt.Sprintf("%dMB", g.budget.MaxMemory>>20))
+	}
+	g.memory.save(n)
+}
+
+func (g *guard) Err() error {
+	select {
+	case <-g.done:
+		return g.err
+	default:
+		return nil
+	}
+}
+
+func (g *guard) Value(key any) any {
+	if g.parent != nil {
+		return g.parent.Value(key)
+	}
+	return nil
+}
+
+func (g *guard) violation() *Violation {
+	v, _ := g.Err().(*Violation)
+	return v
+}
+
+func (g *guard) trip(kind, limit string) {
+	g.once.Do(func() {
+		g.err = &Violation{
+			Kind:         kind,
+			Limit:        limit,
+			Cost:         time.Since(g.start),
+			Instructions: g.instructions.Load(),
+		}
+		close(g.done)
+	})
+}
+
+func (g *guard) cancel(err error) {
+	g.once.Do(func() {
+		g.err = err
+		close(g.done)
+	})
+}
+
+func (g *guard) release() {
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	if g.stopParent != nil {
+		g.stopParent()
+	}
+}
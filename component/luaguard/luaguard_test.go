@@ -0,0 +1,147 @@
+package luaguard
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	lua "github.com/hootrhino/gopher-lua"
+)
+
+func call(L *lua.LState, budget Budget, src string) error {
+	return Run(L, budget, func() error {
+		return L.DoString(src)
+	})
+}
+
+func TestRunInstructions(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	err := call(L, Budget{MaxInstructions: 10000}, `while true do end`)
+	v, ok := AsViolation(err)
+	if !ok || v.Kind != VIOLATION_INSTRUCTIONS {
+		t.Fatal(err)
+	}
+	// 执行完以后恢复原来的状态, 可以继续使用
+	if err := call(L, Budget{MaxInstructions: 10000}, `x = 1 + 1`); err != nil {
+		t.Fatal(err)
+	}
+	if L.Context() != nil {
+		t.Fatal("context should be removed")
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	start := time.Now()
+	err := call(L, Budget{Timeout: 50 * time.Millisecond}, `while true do end`)
+	v, ok := AsViolation(err)
+	if !ok || v.Kind != VIOLATION_TIMEOUT {
+		t.Fatal(err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("timeout too late", time.Since(start))
+	}
+}
+
+func TestRunMemory(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	// 局部变量里面的表
+	err := call(L, Budget{MaxMemory: 1 << 20}, `
+		local function fill()
+			local t = {}
+			local i = 0
+			while true do
+				i = i + 1
+				t[i] = string.rep("x", 1024) .. i
+			end
+		end
+		fill()`)
+	v, ok := AsViolation(err)
+	if !ok || v.Kind != VIOLATION_MEMORY || v.Limit != "1MB" {
+		t.Fatal(err)
+	}
+	// 全局变量每次执行都在增长
+	for i := 0; i < 64; i++ {
+		err = call(L, Budget{MaxMemory: 1 << 20}, `
+			cache = cache or {}
+			for i = 1, 64 do
+				cache[#cache + 1] = string.rep("y", 1024) .. #cache
+			end`)
+		if err != nil {
+			break
+		}
+	}
+	if v, ok := AsViolation(err); !ok || v.Kind != VIOLATION_MEMORY {
+		t.Fatal(err)
+	}
+	// 数据少的时候不受影响
+	L.SetGlobal("cache", lua.LNil)
+	if err := call(L, Budget{MaxMemory: 1 << 20}, `
+		local sum = 0
+		for i = 1, 100000 do sum = sum + i end`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunParentContext(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	L.SetContext(ctx)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	err := call(L, Budget{Timeout: time.Minute}, `while true do end`)
+	if time.Since(start) > time.Second {
+		t.Fatal("should stop with the parent context")
+	}
+	if _, ok := AsViolation(err); ok {
+		t.Fatal("canceled by parent is not a violation", err)
+	}
+	if L.Context() != ctx {
+		t.Fatal("parent context should be restored")
+	}
+}
+
+func TestRunUnlimited(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	errScript := errors.New("script")
+	if err := Run(L, Budget{}, func() error { return errScript }); err != errScript {
+		t.Fatal(err)
+	}
+}
+
+func TestPick(t *testing.T) {
+	if pick(0, 5) != 5 || pick(-1, 5) != 0 || pick(3, 5) != 3 || pick(0, -1) != 0 {
+		t.Fatal("pick")
+	}
+}
+
+func TestRecordViolation(t *testing.T) {
+	uuid := "RULE_TEST"
+	defer Enable(uuid)
+	if _, disabled := RecordViolation(uuid, 2); disabled {
+		t.Fatal("disabled too early")
+	}
+	RecordSuccess(uuid)
+	if count, disabled := RecordViolation(uuid, 2); count != 1 || disabled {
+		t.Fatal("success should reset the count", count)
+	}
+	if _, disabled := RecordViolation(uuid, 2); !disabled || !Disabled(uuid) {
+		t.Fatal("should be disabled")
+	}
+	if _, disabled := RecordViolation(uuid, 2); disabled {
+		t.Fatal("only report once")
+	}
+	Enable(uuid)
+	if Disabled(uuid) {
+		t.Fatal("should be enabled")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package luaguard
+
+import (
+	lua "github.com/hootrhino/gopher-lua"
+)
+
+// 最少每执行多少条指令检查一次内存
+const memoryCheckInterval = 1024
+
+// 注册表里面保存距离上次检查执行过的指令数和下次检查的间隔, 一次执行的指令数不够的时候
+// 累计到下一次执行, 每次执行只增长一点的全局变量也能检查到
+const (
+	memoryExecutedKey = "__LUAGUARD_MEMORY_EXECUTED"
+	memoryIntervalKey = "__LUAGUARD_MEMORY_INTERVAL"
+)
+
+// 估算用的大小, 字节
+const (
+	sizeString   = 16
+	sizeTable    = 64
+	sizeEntry    = 32
+	sizeFunction = 64
+	sizeUserData = 64
+	sizeThread   = 1024
+)
+
+/*
+*
+* 估算虚拟机能访问到的数据占用的内存: 从全局变量、注册表和调用栈上每一层的局部变量、
+* 闭包出发遍历, 同一个对象只算一次. 遍历的代价和数据量成正比, 所以两次检查之间至少
+* 执行上次遍历的对象个数那么多条指令, 分摊下来每条指令最多多遍历一个对象.
+* 只能在虚拟机自己的协程里面调用
+*
+ */
+type memoryMeter struct {
+	L        *lua.LState
+	limit    int64
+	base     int64 // 上次检查的时候本次执行的指令数, 没有检查过的时候为之前累计的负数
+	interval int64
+	size     int64
+	visited  int64
+	seen     map[any]bool
+}
+
+func newMemoryMeter(L *lua.LState, limit int64) *memoryMeter {
+	m := &memoryMeter{L: L, limit: limit, interval: memoryCheckInterval}
+	if L.G == nil {
+		return m
+	}
+	if executed, ok := L.G.Registry.RawGetString(memoryExecutedKey).(lua.LNumber); ok {
+		m.base = -int64(executed)
+	}
+	if interval, ok := L.G.Registry.RawGetString(memoryIntervalKey).(lua.LNumber); ok {
+		m.interval = max(int64(interval), memoryCheckInterval)
+	}
+	return m
+}
+
+// 执行了 n 条指令以后是否需要检查
+func (m *memoryMeter) due(n int64) bool {
+	if n-m.base < m.interval {
+		return false
+	}
+	m.base = n
+	m.interval = max(memoryCheckInterval, m.visited)
+	return true
+}
+
+// 执行结束, 保存累计的指令数
+func (m *memoryMeter) save(n int64) {
+	if m.L.G == nil {
+		return
+	}
+	m.L.G.Registry.RawSetString(memoryExecutedKey, lua.LNumber(n-m.base))
+	m.L.G.Registry.RawSetString(memoryIntervalKey, lua.LNumber(m.interval))
+}
+
+// 超过限制的时候提前结束遍历
+func (m *memoryMeter) exceeded() bool {
+	m.size = 0
+	m.visited = 0
+	m.seen = map[any]bool{}
+	defer func() {
+		m.seen = nil
+	}()
+	if m.L.G != nil {
+		m.add(m.L.G.Global)
+		m.add(m.L.G.Registry)
+	}
+	for level := 0; m.size <= m.limit; level++ {
+		dbg, ok := m.L.GetStack(level)
+		if !ok {
+			break
+		}
+		if fn, err := m.L.GetInfo("f", dbg, lua.LNil); err == nil {
+			m.add(fn)
+		}
+		for i := 1; ; i++ {
+			name, value := m.L.GetLocal(dbg, i)
+			if name == "" {
+				break
+			}
+			m.add(value)
+		}
+	}
+	return m.size > m.limit
+}
+
+func (m *memoryMeter) add(value lua.LValue) {
+	if value == nil || m.size > m.limit {
+		return
+	}
+	switch v := value.(type) {
+	case lua.LString:
+		m.size += sizeString + int64(len(v))
+	case *lua.LTable:
+		if v == nil || m.seen[v] {
+			return
+		}
+		m.seen[v] = true
+		m.visited++
+		m.size += sizeTable
+		m.add(v.Metatable)
+		v.ForEach(func(key, value lua.LValue) {
+			m.size += sizeEntry
+			m.add(key)
+			m.add(value)
+		})
+	case *lua.LFunction:
+		if v == nil || m.seen[v] {
+			return
+		}
+		m.seen[v] = true
+		m.visited++
+		m.size += sizeFunction
+		if v.Env != nil {
+			m.add(v.Env)
+		}
+		for _, upvalue := range v.Upvalues {
+			m.add(upvalue.Value())
+		}
+	case *lua.LUserData:
+		if v == nil || m.seen[v] {
+			return
+		}
+		m.seen[v] = true
+		m.visited++
+		m.size += sizeUserData
+		m.add(v.Metatable)
+	case *lua.LState:
+		if v == nil || m.seen[v] {
+			return
+		}
+		m.seen[v] = true
+		m.size += sizeThread
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package luaguard
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/eventbus"
+	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/component/intermetric"
+	core "github.com/hootrhino/rhilex/config"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+*
+* 连续超出预算的次数, 按照规则或者应用的 UUID 记录
+*
+ */
+type violationTracker struct {
+	locker   sync.Mutex
+	counts   map[string]int
+	disabled map[string]bool
+}
+
+var __DefaultTracker = &violationTracker{
+	counts:   map[string]int{},
+	disabled: map[string]bool{},
+}
+
+/*
+*
+* 记录一次超出预算, 返回连续的次数; 连续达到 max 次以后禁用, 第二个返回值只在刚被禁用的时候为 true.
+* max 为 0 不禁用
+*
+ */
+func RecordViolation(uuid string, max int) (int, bool) {
+	t := __DefaultTracker
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	t.counts[uuid]++
+	count := t.counts[uuid]
+	if max > 0 && count >= max && !t.disabled[uuid] {
+		t.disabled[uuid] = true
+		return count, true
+	}
+	return count, false
+}
+
+// 在预算内执行完成, 连续次数清零
+func RecordSuccess(uuid string) {
+	t := __DefaultTracker
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	delete(t.counts, uuid)
+}
+
+func Disabled(uuid string) bool {
+	t := __DefaultTracker
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	return t.disabled[uuid]
+}
+
+// 直接禁用, 启动的时候恢复数据库里面保存的状态
+func Disable(uuid string) {
+	t := __DefaultTracker
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	t.disabled[uuid] = true
+}
+
+// 重新启用, 规则更新、删除或者手动启用的时候调用
+func Enable(uuid string) {
+	t := __DefaultTracker
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	delete(t.counts, uuid)
+	delete(t.disabled, uuid)
+}
+
+/*
+*
+* 规则超出预算: 写规则日志, 统计次数, 连续超出 lua_max_violations 次以后禁用规则,
+* 禁用的状态写到数据库里面, 重启以后不会自动恢复
+*
+ */
+func ReportRuleViolation(uuid string, v *Violation) {
+	if !report(intermetric.KIND_RULE, uuid, "rule/log/"+uuid, "event.rule.disabled."+uuid, v) {
+		return
+	}
+	if err := interdb.InterDb().Table("m_rules").Where("uuid=?", uuid).
+		Update("disabled", true).Error; err != nil {
+		glogger.GLogger.Error("Save rule disabled state failed:", uuid, err)
+	}
+}
+
+/*
+*
+* 应用超出预算: 写应用日志, 统计次数, 返回应用是否被禁用(不再自动重启)
+*
+ */
+func ReportAppletViolation(uuid string, v *Violation) bool {
+	report(intermetric.KIND_APPLET, uuid, "app/console/"+uuid, "event.applet.disabled."+uuid, v)
+	return Disabled(uuid)
+}
+
+// 返回是否刚被禁用
+func report(kind, uuid, topic, event string, v *Violation) bool {
+	intermetric.IncViolation(kind, uuid)
+	max := core.GlobalConfig.LuaMaxViolations
+	count, disabled := RecordViolation(uuid, max)
+	glogger.GLogger.WithFields(logrus.Fields{
+		"topic": topic,
+	}).Warnf("Execution aborted (%d/%d): %s", count, max, v.Error())
+	if !disabled {
+		return false
+	}
+	glogger.GLogger.WithFields(logrus.Fields{
+		"topic": topic,
+	}).Errorf("Disabled after %d consecutive budget violations", count)
+	eventbus.Publish(event, eventbus.EventMessage{
+		Topic:   event,
+		From:    "luaguard",
+		Type:    "SYSTEM",
+		Event:   event,
+		Ts:      uint64(time.Now().UnixMilli()),
+		Payload: v,
+	})
+	return true
+}
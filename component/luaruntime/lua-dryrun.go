@@ -0,0 +1,284 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package luaruntime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/interwindow"
+	"github.com/hootrhino/rhilex/component/luaexecutor"
+	"github.com/hootrhino/rhilex/rhilexlib"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+const DRY_RUN_UUID string = "DRY_RUN"
+
+// 每条样例数据默认的超时时间
+const DefaultDryRunTimeout = 1 * time.Second
+
+/*
+*
+* 试运行时被拦截下来的调用, Args 为除了模块自己以外的参数
+*
+ */
+type DryRunCall struct {
+	Module string   `json:"module"`
+	Func   string   `json:"func"`
+	Args   []string `json:"args"`
+}
+
+/*
+*
+* 一条样例数据的试运行结果, Output 为 Actions 最后一个函数的返回值
+*
+ */
+type DryRunResult struct {
+	Input   string          `json:"input"`
+	Success bool            `json:"success"`
+	Output  json.RawMessage `json:"output"`
+	Error   string          `json:"error"`
+	Calls   []DryRunCall    `json:"calls"`
+	Logs    []string        `json:"logs"`
+	Cost    int64           `json:"cost"` // 微秒
+}
+
+// 有副作用的函数, 试运行的时候只记录不执行; data 模块里面的函数全部拦截
+var dryRunMocks = []struct {
+	module  string
+	funcs   []string
+	returns []lua.LValue
+}{
+	{"device", []string{"CtrlDevice"}, []lua.LValue{lua.LString(""), lua.LNil}},
+	{"modbus", []string{"WritePoint"}, []lua.LValue{lua.LNil}},
+	{"knx", []string{"Write", "Read"}, []lua.LValue{lua.LNil}},
+	{"bacnet", []string{"Write", "Relinquish"}, []lua.LValue{lua.LNil}},
+	{"s7", []string{"Write", "WriteAddress"}, []lua.LValue{lua.LNil}},
+	{"opcua", []string{"Write", "WriteNode"}, []lua.LValue{lua.LNil}},
+	{"opcua", []string{"Call"}, []lua.LValue{lua.LString("[]"), lua.LNil}},
+	{"modbus_slaver", []string{"F5", "F6"}, []lua.LValue{lua.LNil}},
+	{"rhilexg1", []string{"DO1Set", "DO2Set"}, []lua.LValue{lua.LNil}},
+	{"en6400", []string{"Led1On", "Led1Off"}, []lua.LValue{lua.LNil}},
+	{"haas506ld1", []string{
+		"Led2On", "Led3On", "Led4On", "Led5On",
+		"Led2Off", "Led3Off", "Led4Off", "Led5Off",
+		"DO1On", "DO2On", "DO3On", "DO4On",
+		"DO1Off", "DO2Off", "DO3Off", "DO4Off",
+	}, []lua.LValue{lua.LNil}},
+	{"audio", []string{"PlayMusic"}, []lua.LValue{lua.LNil}},
+	{"rpc", []string{"Request"}, []lua.LValue{lua.LString(""), lua.LNil}},
+	{"http", []string{"Get", "Post"}, []lua.LValue{lua.LString("")}},
+	{"rds", []string{"Save", "UpdateLast"}, []lua.LValue{lua.LNil}},
+	{"tjchmi", []string{"WriteToHmi"}, []lua.LValue{lua.LNil}},
+	{"time", []string{"Sleep"}, []lua.LValue{}},
+	{"alarm", []string{"Input"}, []lua.LValue{lua.LNil}},
+}
+
+type dryRun struct {
+	calls   []DryRunCall
+	logs    []string
+	kv      map[string]string
+	discard bool // 影子模式一直运行, 调用和日志不记录
+}
+
+/*
+*
+* 影子模式: 和试运行一样拦截有副作用的调用, 但是不记录, kv 只在影子实例里面有效
+*
+ */
+func InstallShadow(L *lua.LState, uuid string) {
+	run := &dryRun{kv: map[string]string{}, discard: true}
+	run.install(L)
+	// 窗口换成影子版本自己的, 定时关闭的回调只给线上版本
+	if window, ok := L.GetGlobal("window").(*lua.LTable); ok {
+		shadowId := interwindow.ShadowRuleId(uuid)
+		window.RawSetString("Tumbling", L.NewFunction(rhilexlib.WindowTumbling(nil, shadowId)))
+		window.RawSetString("Sliding", L.NewFunction(rhilexlib.WindowSliding(nil, shadowId)))
+		window.RawSetString("Current", L.NewFunction(rhilexlib.WindowCurrent(nil, shadowId)))
+		window.RawSetString("Reset", L.NewFunction(rhilexlib.WindowReset(nil, shadowId)))
+		window.RawSetString("OnClose", L.NewFunction(func(l *lua.LState) int {
+			l.Push(lua.LNil)
+			return 1
+		}))
+	}
+}
+
+/*
+*
+* 在独立的虚拟机里面试运行规则, 加载的库和正式运行的规则一样, 但是
+* 输出数据、控制设备之类的调用会被拦截下来放到结果里面, 不会真的执行
+*
+ */
+func DryRunRule(e typex.Rhilex, actions, success, failed string,
+	inputs []string, timeout time.Duration) ([]DryRunResult, error) {
+	if timeout <= 0 {
+		timeout = DefaultDryRunTimeout
+	}
+	// 每次试运行的 UUID 都不一样, 窗口之类按规则隔离的状态不会互相影响
+	rule := typex.NewLuaRule(e, utils.MakeUUID(DRY_RUN_UUID), DRY_RUN_UUID, "", "", "",
+		success, actions, failed)
+	defer rule.LuaVM.Close()
+	defer interwindow.DefaultWindowStore().RemoveRule(rule.UUID)
+	LoadRuleLibGroup(e, "RULE", rule.UUID, rule.LuaVM)
+	run := &dryRun{kv: map[string]string{}}
+	run.install(rule.LuaVM)
+	if err := VerifyLuaSyntax(rule); err != nil {
+		return nil, err
+	}
+	results := make([]DryRunResult, 0, len(inputs))
+	for _, input := range inputs {
+		results = append(results, run.execute(rule, input, timeout))
+	}
+	return results, nil
+}
+
+func (run *dryRun) execute(rule *typex.Rule, input string, timeout time.Duration) DryRunResult {
+	run.calls = []DryRunCall{}
+	run.logs = []string{}
+	result := DryRunResult{Input: input, Output: json.RawMessage("null")}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rule.LuaVM.SetContext(ctx)
+	defer rule.LuaVM.RemoveContext()
+
+	start := time.Now()
+	// 试运行没有真实的数据来源, 上下文里面只有类型
+	output, err := luaexecutor.ExecuteActions(rule, lua.LString(input),
+		luaexecutor.NewRuleContext(rule, typex.RuleResource{Kind: "DRY_RUN"}))
+	if err == nil {
+		_, err = luaexecutor.ExecuteSuccess(rule.LuaVM)
+	} else {
+		luaexecutor.ExecuteFailed(rule.LuaVM, lua.LString(err.Error()))
+	}
+	result.Cost = time.Since(start).Microseconds()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = errors.New("execute timeout")
+	}
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Success = true
+	}
+	if output != nil {
+		if data, errEncode := rhilexlib.EncodeValue(output); errEncode == nil {
+			result.Output = data
+		} else {
+			result.Output, _ = json.Marshal(output.String())
+		}
+	}
+	result.Calls = run.calls
+	result.Logs = run.logs
+	return result
+}
+
+/*
+*
+* 替换掉有副作用的函数, kv 换成只在本次试运行有效的 map
+*
+ */
+func (run *dryRun) install(L *lua.LState) {
+	if data, ok := L.GetGlobal("data").(*lua.LTable); ok {
+		names := []string{}
+		data.ForEach(func(k, _ lua.LValue) { names = append(names, k.String()) })
+		for _, name := range names {
+			data.RawSetString(name, L.NewFunction(run.capture(data, "data", name, []lua.LValue{lua.LNil})))
+		}
+	}
+	for _, mock := range dryRunMocks {
+		module, ok := L.GetGlobal(mock.module).(*lua.LTable)
+		if !ok {
+			continue
+		}
+		for _, name := range mock.funcs {
+			module.RawSetString(name, L.NewFunction(run.capture(module, mock.module, name, mock.returns)))
+		}
+	}
+	if kv, ok := L.GetGlobal("kv").(*lua.LTable); ok {
+		kv.RawSetString("VSet", L.NewFunction(func(l *lua.LState) int {
+			run.kv[l.ToString(2)] = l.ToString(3)
+			return 0
+		}))
+		kv.RawSetString("VSetWithDuration", L.NewFunction(func(l *lua.LState) int {
+			run.kv[l.ToString(2)] = l.ToString(3)
+			return 0
+		}))
+		kv.RawSetString("VGet", L.NewFunction(func(l *lua.LState) int {
+			if v, ok := run.kv[l.ToString(2)]; ok {
+				l.Push(lua.LString(v))
+			} else {
+				l.Push(lua.LNil)
+			}
+			return 1
+		}))
+		kv.RawSetString("VDel", L.NewFunction(func(l *lua.LState) int {
+			delete(run.kv, l.ToString(2))
+			return 0
+		}))
+	}
+	L.SetGlobal("Debug", L.NewFunction(func(l *lua.LState) int {
+		if !run.discard {
+			run.logs = append(run.logs, strings.Join(luaArgs(l, 1, nil), "  "))
+		}
+		return 0
+	}))
+	L.SetGlobal("Throw", L.NewFunction(func(l *lua.LState) int {
+		if !run.discard {
+			run.logs = append(run.logs, "Throw: "+strings.Join(luaArgs(l, 1, nil), "  "))
+		}
+		return 0
+	}))
+}
+
+// 记录调用然后返回约定好的值
+func (run *dryRun) capture(self *lua.LTable, module, name string, returns []lua.LValue) lua.LGFunction {
+	return func(l *lua.LState) int {
+		if !run.discard {
+			run.calls = append(run.calls, DryRunCall{
+				Module: module,
+				Func:   name,
+				Args:   luaArgs(l, 1, self),
+			})
+		}
+		for _, v := range returns {
+			l.Push(v)
+		}
+		return len(returns)
+	}
+}
+
+// 参数转成字符串, Table 转成 JSON; 冒号调用的时候第一个参数是模块自己, 跳过
+func luaArgs(l *lua.LState, from int, self *lua.LTable) []string {
+	args := []string{}
+	for i := from; i <= l.GetTop(); i++ {
+		v := l.Get(i)
+		if i == from && self != nil && v == self {
+			continue
+		}
+		if v.Type() == lua.LTTable {
+			if data, err := rhilexlib.EncodeValue(v); err == nil {
+				args = append(args, string(data))
+				continue
+			}
+		}
+		args = append(args, l.ToStringMeta(v).String())
+	}
+	return args
+}
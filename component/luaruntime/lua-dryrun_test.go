@@ -0,0 +1,230 @@
+package luaruntime
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/interwindow"
+	"github.com/hootrhino/rhilex/component/luaexecutor"
+	"github.com/hootrhino/rhilex/component/rulerollout"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+const dryRunSuccess = `function Success() end`
+const dryRunFailed = `function Failed(error) end`
+
+func Test_DryRunRule_CaptureCalls(t *testing.T) {
+	actions := `
+Actions = {
+    function(args)
+        local t = json:J2T(args)
+        if t.temp == nil then
+            return nil, args
+        end
+        return true, t
+    end,
+    function(t)
+        kv:VSet("last", t.temp)
+        Debug("temp", kv:VGet("last"))
+        data:ToMqtt("OUTEND1", json:T2J({value = t.temp * 2}))
+        device:CtrlDevice("DEVICE1", "ON")
+        return true, t.temp * 2
+    end
+}`
+	results, err := DryRunRule(nil, actions, dryRunSuccess, dryRunFailed,
+		[]string{`{"temp":21}`, `{"humi":1}`}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expect 2 results, got %d", len(results))
+	}
+	ok := results[0]
+	if !ok.Success || string(ok.Output) != "42" {
+		t.Fatalf("unexpected result: %+v", ok)
+	}
+	if len(ok.Calls) != 2 || ok.Calls[0].Module != "data" || ok.Calls[0].Func != "ToMqtt" ||
+		ok.Calls[0].Args[0] != "OUTEND1" || ok.Calls[0].Args[1] != `{"value":42}` {
+		t.Fatalf("unexpected calls: %+v", ok.Calls)
+	}
+	if ok.Calls[1].Module != "device" || ok.Calls[1].Args[1] != "ON" {
+		t.Fatalf("unexpected calls: %+v", ok.Calls)
+	}
+	if len(ok.Logs) != 1 || ok.Logs[0] != "temp  21" {
+		t.Fatalf("unexpected logs: %+v", ok.Logs)
+	}
+	if results[1].Success || results[1].Error == "" {
+		t.Fatalf("missing temp must fail: %+v", results[1])
+	}
+}
+
+func Test_DryRunRule_Context(t *testing.T) {
+	actions := `
+Actions = {
+    function(args, ctx)
+        return true, ctx.kind
+    end,
+    function(kind, ctx)
+        return true, kind .. ":" .. ctx.kind
+    end
+}`
+	results, err := DryRunRule(nil, actions, dryRunSuccess, dryRunFailed,
+		[]string{`{}`}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].Success || string(results[0].Output) != `"DRY_RUN:DRY_RUN"` {
+		t.Fatalf("unexpected result: %+v", results[0])
+	}
+}
+
+func Test_DryRunRule_Window(t *testing.T) {
+	actions := `
+Actions = {
+    function(args)
+        local t = json:J2T(args)
+        local results, err = window:Tumbling("temp", 60000, t.value, t.ts)
+        if results ~= nil then
+            for _, result in ipairs(results) do
+                data:ToMqtt("OUTEND1", json:T2J(result))
+            end
+        end
+        return true, args
+    end
+}`
+	results, err := DryRunRule(nil, actions, dryRunSuccess, dryRunFailed,
+		[]string{`{"ts":60000,"value":1}`, `{"ts":90000,"value":3}`, `{"ts":120000,"value":5}`}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results[1].Calls) != 0 || len(results[2].Calls) != 1 {
+		t.Fatalf("window must close on the third input: %+v", results)
+	}
+	if !strings.Contains(results[2].Calls[0].Args[1], `"avg":2`) {
+		t.Fatalf("unexpected window result: %+v", results[2].Calls[0])
+	}
+}
+
+func Test_DryRunRule_Timeout(t *testing.T) {
+	actions := `
+Actions = {
+    function(args)
+        while true do end
+        return true, args
+    end
+}`
+	results, err := DryRunRule(nil, actions, dryRunSuccess, dryRunFailed,
+		[]string{"x"}, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Success || results[0].Error != "execute timeout" {
+		t.Fatalf("expect timeout, got %+v", results[0])
+	}
+}
+
+func Test_DryRunRule_Syntax(t *testing.T) {
+	if _, err := DryRunRule(nil, "Actions = {", dryRunSuccess, dryRunFailed,
+		[]string{"x"}, time.Second); err == nil {
+		t.Fatal("syntax error must be reported")
+	}
+}
+
+func Test_RunShadow(t *testing.T) {
+	newRule := func(actions string, version int) *typex.Rule {
+		rule := typex.NewLuaRule(nil, "RULE_SHADOW", "shadow", "", "", "",
+			dryRunSuccess, actions, dryRunFailed)
+		rule.Version = version
+		LoadRuleLibGroup(nil, "RULE", rule.UUID, rule.LuaVM)
+		if err := VerifyLuaSyntax(rule); err != nil {
+			t.Fatal(err)
+		}
+		return rule
+	}
+	live := newRule(`Actions = { function(args) return true, {v = tonumber(args)} end }`, 1)
+	defer live.LuaVM.Close()
+	live.Shadow = newRule(`
+Actions = {
+    function(args, ctx)
+        data:ToMqtt("OUTEND1", args)
+        if tonumber(args) > 10 then
+            return true, {v = 10}
+        end
+        return true, {v = tonumber(args)}
+    end
+}`, 2)
+	defer live.Shadow.LuaVM.Close()
+	InstallShadow(live.Shadow.LuaVM, live.Shadow.UUID)
+	defer rulerollout.Reset(live.UUID)
+	resource := typex.RuleResource{Kind: "DEVICE", UUID: "DEVICE1"}
+	for _, input := range []string{"1", "20"} {
+		output, err := luaexecutor.ExecuteActions(live, lua.LString(input),
+			luaexecutor.NewRuleContext(live, resource))
+		if err != nil {
+			t.Fatal(err)
+		}
+		luaexecutor.RunShadow(live, lua.LString(input), resource, output, nil)
+	}
+	shadow := rulerollout.GetReport(live.UUID).Shadow
+	if shadow == nil || shadow.Version != 2 || shadow.Executions != 2 ||
+		shadow.Matched != 1 || shadow.Mismatched != 1 || shadow.Failures != 0 {
+		t.Fatalf("unexpected shadow report: %+v", shadow)
+	}
+	if m := shadow.LastMismatch; m.Input != "20" || m.Live != `{"v":20}` || m.Shadow != `{"v":10}` {
+		t.Fatalf("unexpected mismatch: %+v", m)
+	}
+}
+
+// 影子版本的窗口不能把样本再推一次到线上版本的窗口里面
+func Test_RunShadowWindow(t *testing.T) {
+	actions := `
+Actions = {
+    function(args)
+        local results = window:Tumbling("temp", 60000, tonumber(args), 1000)
+        return true, {closed = results ~= nil}
+    end
+}`
+	newRule := func(version int) *typex.Rule {
+		rule := typex.NewLuaRule(nil, "RULE_SHADOW_WINDOW", "shadow", "", "", "",
+			dryRunSuccess, actions, dryRunFailed)
+		rule.Version = version
+		LoadRuleLibGroup(nil, "RULE", rule.UUID, rule.LuaVM)
+		if err := VerifyLuaSyntax(rule); err != nil {
+			t.Fatal(err)
+		}
+		return rule
+	}
+	live := newRule(1)
+	defer live.LuaVM.Close()
+	live.Shadow = newRule(2)
+	defer live.Shadow.LuaVM.Close()
+	InstallShadow(live.Shadow.LuaVM, live.Shadow.UUID)
+	defer rulerollout.Reset(live.UUID)
+	defer interwindow.DefaultWindowStore().RemoveRule(live.UUID)
+	resource := typex.RuleResource{Kind: "DEVICE", UUID: "DEVICE1"}
+	for _, input := range []string{"1", "3"} {
+		output, err := luaexecutor.ExecuteActions(live, lua.LString(input),
+			luaexecutor.NewRuleContext(live, resource))
+		if err != nil {
+			t.Fatal(err)
+		}
+		luaexecutor.RunShadow(live, lua.LString(input), resource, output, nil)
+	}
+	current := interwindow.DefaultWindowStore().Current(live.UUID, "temp")
+	if current == nil || current.Count != 2 || current.Sum != 4 {
+		t.Fatalf("unexpected live window: %+v", current)
+	}
+	shadow := interwindow.DefaultWindowStore().Current(interwindow.ShadowRuleId(live.UUID), "temp")
+	if shadow == nil || shadow.Count != 2 {
+		t.Fatalf("unexpected shadow window: %+v", shadow)
+	}
+	if report := rulerollout.GetReport(live.UUID).Shadow; report == nil || report.Matched != 2 {
+		t.Fatalf("unexpected shadow report: %+v", report)
+	}
+	interwindow.DefaultWindowStore().RemoveRule(live.UUID)
+	if interwindow.DefaultWindowStore().Current(interwindow.ShadowRuleId(live.UUID), "temp") != nil {
+		t.Fatal("shadow windows must be removed with the rule")
+	}
+}
@@ -162,6 +162,7 @@ func LoadRuleLibGroup(e typex.Rhilex, scope, uuid string, LState *lua.LState) {
 			"ToSemtechUdp": rhilexlib.DataToSemtechUdp(e, uuid),
 			"ToUart":       rhilexlib.DataToUart(e, uuid),
 			"ToGreptimeDB": rhilexlib.DataToGreptimeDB(e),
+			"ToSparkplugB": rhilexlib.DataToSparkplugB(e, uuid),
 		}
 		AddRuleLibToGroup(e, LState, "data", Funcs)
 	}
@@ -188,6 +189,22 @@ func LoadRuleLibGroup(e typex.Rhilex, scope, uuid string, LState *lua.LState) {
 		}
 		AddRuleLibToGroup(e, LState, "kv", Funcs)
 	}
+	{
+		Funcs := map[string]func(l *lua.LState) int{
+			"Tumbling": rhilexlib.WindowTumbling(e, uuid),
+			"Sliding":  rhilexlib.WindowSliding(e, uuid),
+			"Current":  rhilexlib.WindowCurrent(e, uuid),
+			"Reset":    rhilexlib.WindowReset(e, uuid),
+			"OnClose":  rhilexlib.WindowOnClose(e, uuid),
+		}
+		AddRuleLibToGroup(e, LState, "window", Funcs)
+	}
+	{
+		Funcs := map[string]func(l *lua.LState) int{
+			"Input": rhilexlib.AlarmInput(e, uuid),
+		}
+		AddRuleLibToGroup(e, LState, "alarm", Funcs)
+	}
 	{
 		Funcs := map[string]func(l *lua.LState) int{
 			"Time":       rhilexlib.Time(e, uuid),
@@ -255,6 +272,35 @@ func LoadRuleLibGroup(e typex.Rhilex, scope, uuid string, LState *lua.LState) {
 		}
 		AddRuleLibToGroup(e, LState, "modbus", Funcs)
 	}
+	{
+		Funcs := map[string]func(l *lua.LState) int{
+			"Write": rhilexlib.KNXWrite(e),
+			"Read":  rhilexlib.KNXRead(e),
+		}
+		AddRuleLibToGroup(e, LState, "knx", Funcs)
+	}
+	{
+		Funcs := map[string]func(l *lua.LState) int{
+			"Write":      rhilexlib.BACnetWrite(e),
+			"Relinquish": rhilexlib.BACnetRelinquish(e),
+		}
+		AddRuleLibToGroup(e, LState, "bacnet", Funcs)
+	}
+	{
+		Funcs := map[string]func(l *lua.LState) int{
+			"Write":        rhilexlib.S7Write(e),
+			"WriteAddress": rhilexlib.S7WriteAddress(e),
+		}
+		AddRuleLibToGroup(e, LState, "s7", Funcs)
+	}
+	{
+		Funcs := map[string]func(l *lua.LState) int{
+			"Write":     rhilexlib.OpcuaWrite(e),
+			"WriteNode": rhilexlib.OpcuaWriteNode(e),
+			"Call":      rhilexlib.OpcuaCall(e),
+		}
+		AddRuleLibToGroup(e, LState, "opcua", Funcs)
+	}
 	{
 		Funcs := map[string]func(l *lua.LState) int{
 			"DO1Set": rhilexlib.RHILEXG1_DO1Set(e, uuid),
@@ -0,0 +1,142 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rulerollout
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 差异前后保留的行数
+const diffContext = 3
+
+// 超过这个规模不再逐行比较, 整体算作替换
+const maxDiffCells = 4000000
+
+type diffLine struct {
+	kind byte // ' ' 相同, '-' 删除, '+' 新增
+	text string
+}
+
+/*
+*
+* 两个版本脚本的逐行差异, unified diff 格式, 没有差异的时候返回空字符串
+*
+ */
+func LineDiff(oldText, newText string) string {
+	lines := diffLines(splitLines(oldText), splitLines(newText))
+	changed := []int{}
+	for i, l := range lines {
+		if l.kind != ' ' {
+			changed = append(changed, i)
+		}
+	}
+	if len(changed) == 0 {
+		return ""
+	}
+	sb := strings.Builder{}
+	// 相邻的改动合并成一个块
+	for i := 0; i < len(changed); {
+		start := max(changed[i]-diffContext, 0)
+		j := i
+		for j+1 < len(changed) && changed[j+1]-changed[j] <= 2*diffContext {
+			j++
+		}
+		end := min(changed[j]+diffContext, len(lines)-1)
+		oldStart, newStart := 1, 1
+		for _, l := range lines[:start] {
+			if l.kind != '+' {
+				oldStart++
+			}
+			if l.kind != '-' {
+				newStart++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, l := range lines[start : end+1] {
+			if l.kind != '+' {
+				oldCount++
+			}
+			if l.kind != '-' {
+				newCount++
+			}
+		}
+		sb.WriteString(fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount))
+		for _, l := range lines[start : end+1] {
+			sb.WriteByte(l.kind)
+			sb.WriteString(l.text)
+			sb.WriteByte('\n')
+		}
+		i = j + 1
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// 最长公共子序列
+func diffLines(a, b []string) []diffLine {
+	result := []diffLine{}
+	if len(a)*len(b) > maxDiffCells {
+		for _, l := range a {
+			result = append(result, diffLine{'-', l})
+		}
+		for _, l := range b {
+			result = append(result, diffLine{'+', l})
+		}
+		return result
+	}
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			result = append(result, diffLine{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			result = append(result, diffLine{'-', a[i]})
+			i++
+		default:
+			result = append(result, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		result = append(result, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		result = append(result, diffLine{'+', b[j]})
+	}
+	return result
+}
@@ -0,0 +1,235 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rulerollout
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/hootrhino/gopher-lua"
+)
+
+// 样例数据最多保留的长度
+const maxSampleSize = 1024
+
+// 每个版本的执行统计, 灰度的时候用来比较新旧版本
+type VersionStat struct {
+	Version    int    `json:"version"`
+	Executions uint64 `json:"executions"`
+	Failures   uint64 `json:"failures"`
+}
+
+// 影子模式下输出不一致的样例
+type ShadowMismatch struct {
+	Ts       int64  `json:"ts"`
+	Resource string `json:"resource"`
+	Input    string `json:"input"`
+	Live     string `json:"live"`
+	Shadow   string `json:"shadow"`
+}
+
+/*
+*
+* 影子模式的比较结果: Failures 为影子版本执行失败(线上版本成功)的次数
+*
+ */
+type ShadowStat struct {
+	Version      int             `json:"version"`
+	Executions   uint64          `json:"executions"`
+	Matched      uint64          `json:"matched"`
+	Mismatched   uint64          `json:"mismatched"`
+	Failures     uint64          `json:"failures"`
+	LastMismatch *ShadowMismatch `json:"lastMismatch"`
+}
+
+type Report struct {
+	Versions []VersionStat `json:"versions"`
+	Shadow   *ShadowStat   `json:"shadow"`
+}
+
+type ruleStat struct {
+	versions map[int]*VersionStat
+	shadow   *ShadowStat
+}
+
+type registry struct {
+	locker sync.Mutex
+	rules  map[string]*ruleStat
+}
+
+var __DefaultRegistry = &registry{rules: map[string]*ruleStat{}}
+
+// 需要持有锁
+func (r *registry) rule(ruleId string) *ruleStat {
+	s, ok := r.rules[ruleId]
+	if !ok {
+		s = &ruleStat{versions: map[int]*VersionStat{}}
+		r.rules[ruleId] = s
+	}
+	return s
+}
+
+// 规则某个版本执行一次
+func Observe(ruleId string, version int, success bool) {
+	r := __DefaultRegistry
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	s := r.rule(ruleId)
+	v, ok := s.versions[version]
+	if !ok {
+		v = &VersionStat{Version: version}
+		s.versions[version] = v
+	}
+	v.Executions++
+	if !success {
+		v.Failures++
+	}
+}
+
+/*
+*
+* 记录一次影子执行, mismatch 不为空表示输出和线上版本不一致
+*
+ */
+func ObserveShadow(ruleId string, version int, failed bool, mismatch *ShadowMismatch) {
+	r := __DefaultRegistry
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	s := r.rule(ruleId)
+	if s.shadow == nil || s.shadow.Version != version {
+		s.shadow = &ShadowStat{Version: version}
+	}
+	s.shadow.Executions++
+	if failed {
+		s.shadow.Failures++
+	}
+	if mismatch == nil {
+		s.shadow.Matched++
+		return
+	}
+	s.shadow.Mismatched++
+	mismatch.Input = truncate(mismatch.Input)
+	mismatch.Live = truncate(mismatch.Live)
+	mismatch.Shadow = truncate(mismatch.Shadow)
+	s.shadow.LastMismatch = mismatch
+}
+
+func GetReport(ruleId string) Report {
+	r := __DefaultRegistry
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	report := Report{Versions: []VersionStat{}}
+	s, ok := r.rules[ruleId]
+	if !ok {
+		return report
+	}
+	for _, v := range s.versions {
+		report.Versions = append(report.Versions, *v)
+	}
+	sort.Slice(report.Versions, func(i, j int) bool {
+		return report.Versions[i].Version < report.Versions[j].Version
+	})
+	if s.shadow != nil {
+		shadow := *s.shadow
+		report.Shadow = &shadow
+	}
+	return report
+}
+
+// 发布、回滚、取消灰度以后重新统计
+func Reset(ruleId string) {
+	r := __DefaultRegistry
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	delete(r.rules, ruleId)
+}
+
+func NewMismatch(resource, input, live, shadow string) *ShadowMismatch {
+	return &ShadowMismatch{
+		Ts:       time.Now().UnixMilli(),
+		Resource: resource,
+		Input:    input,
+		Live:     live,
+		Shadow:   shadow,
+	}
+}
+
+func truncate(s string) string {
+	if len(s) > maxSampleSize {
+		return s[:maxSampleSize] + "..."
+	}
+	return s
+}
+
+/*
+*
+* Lua 值转成确定的字符串用来比较, Table 的键排序以后输出
+*
+ */
+func Encode(v lua.LValue) string {
+	sb := strings.Builder{}
+	encode(&sb, v, 0)
+	return sb.String()
+}
+
+// 防止循环引用
+const maxEncodeDepth = 32
+
+func encode(sb *strings.Builder, v lua.LValue, depth int) {
+	switch value := v.(type) {
+	case nil:
+		sb.WriteString("nil")
+	case lua.LString:
+		b, _ := json.Marshal(string(value))
+		sb.Write(b)
+	case lua.LNumber:
+		sb.WriteString(strconv.FormatFloat(float64(value), 'g', -1, 64))
+	case *lua.LTable:
+		if depth >= maxEncodeDepth {
+			sb.WriteString("{...}")
+			return
+		}
+		keys := []lua.LValue{}
+		value.ForEach(func(k, _ lua.LValue) { keys = append(keys, k) })
+		encoded := make([]string, len(keys))
+		for i, k := range keys {
+			kb := strings.Builder{}
+			encode(&kb, k, depth+1)
+			encoded[i] = kb.String()
+		}
+		index := make([]int, len(keys))
+		for i := range index {
+			index[i] = i
+		}
+		sort.Slice(index, func(i, j int) bool { return encoded[index[i]] < encoded[index[j]] })
+		sb.WriteByte('{')
+		for n, i := range index {
+			if n > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(encoded[i])
+			sb.WriteByte(':')
+			encode(sb, value.RawGet(keys[i]), depth+1)
+		}
+		sb.WriteByte('}')
+	default:
+		sb.WriteString(v.String())
+	}
+}
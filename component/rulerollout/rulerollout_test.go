@@ -0,0 +1,93 @@
+package rulerollout
+
+import (
+	"strings"
+	"testing"
+
+	lua "github.com/hootrhino/gopher-lua"
+)
+
+func TestLineDiff(t *testing.T) {
+	if LineDiff("a\nb\n", "a\nb\n") != "" {
+		t.Fatal("same text should have no diff")
+	}
+	diff := LineDiff("a\nb\nc\n", "a\nB\nc\nd\n")
+	expect := "@@ -1,3 +1,4 @@\n a\n-b\n+B\n c\n+d\n"
+	if diff != expect {
+		t.Fatal(diff)
+	}
+}
+
+func TestLineDiffHunks(t *testing.T) {
+	old := []string{}
+	for i := 0; i < 30; i++ {
+		old = append(old, string(rune('a'+i%26)))
+	}
+	changed := append([]string{}, old...)
+	changed[2] = "X"
+	changed[25] = "Y"
+	diff := LineDiff(strings.Join(old, "\n"), strings.Join(changed, "\n"))
+	if strings.Count(diff, "@@ -") != 2 {
+		t.Fatal(diff)
+	}
+	if !strings.HasPrefix(diff, "@@ -1,6 +1,6 @@\n") {
+		t.Fatal(diff)
+	}
+	if !strings.Contains(diff, "@@ -23,7 +23,7 @@\n") {
+		t.Fatal(diff)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	if err := L.DoString(`
+		a = {x = 1, y = {"p", "q"}, z = true}
+		b = {z = true, y = {"p", "q"}, x = 1}
+		c = {x = 2, y = {"p", "q"}, z = true}`); err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := L.GetGlobal("a"), L.GetGlobal("b"), L.GetGlobal("c")
+	if Encode(a) != Encode(b) {
+		t.Fatal(Encode(a), Encode(b))
+	}
+	if Encode(a) == Encode(c) {
+		t.Fatal("should be different")
+	}
+	if Encode(lua.LString("1")) == Encode(lua.LNumber(1)) {
+		t.Fatal("string and number should be different")
+	}
+}
+
+func TestReport(t *testing.T) {
+	ruleId := "RULE_TEST"
+	defer Reset(ruleId)
+	Observe(ruleId, 2, true)
+	Observe(ruleId, 1, true)
+	Observe(ruleId, 2, false)
+	ObserveShadow(ruleId, 3, false, nil)
+	ObserveShadow(ruleId, 3, true, NewMismatch("DEVICE1", "in", "true", strings.Repeat("x", 2000)))
+	report := GetReport(ruleId)
+	if len(report.Versions) != 2 || report.Versions[0].Version != 1 {
+		t.Fatal(report.Versions)
+	}
+	if v := report.Versions[1]; v.Executions != 2 || v.Failures != 1 {
+		t.Fatal(v)
+	}
+	s := report.Shadow
+	if s == nil || s.Executions != 2 || s.Matched != 1 || s.Mismatched != 1 || s.Failures != 1 {
+		t.Fatal(s)
+	}
+	if len(s.LastMismatch.Shadow) > maxSampleSize+3 {
+		t.Fatal("sample should be truncated")
+	}
+	// 新的影子版本重新统计
+	ObserveShadow(ruleId, 4, false, nil)
+	if s := GetReport(ruleId).Shadow; s.Version != 4 || s.Executions != 1 {
+		t.Fatal(s)
+	}
+	Reset(ruleId)
+	if r := GetReport(ruleId); len(r.Versions) != 0 || r.Shadow != nil {
+		t.Fatal(r)
+	}
+}
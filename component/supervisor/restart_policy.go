@@ -0,0 +1,141 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package supervisor
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	core "github.com/hootrhino/rhilex/config"
+	"github.com/hootrhino/rhilex/resconfig"
+)
+
+// 两次重启之间最少等待的时间, 防止配置成0以后疯狂重启
+const minRestartDelay = time.Second
+
+/*
+*
+* 重启的退避策略: 第 n 次连续重启前等待 InitialDelay * Multiplier^(n-1), 最多 MaxDelay
+*
+ */
+type BackoffPolicy struct {
+	InitialDelay time.Duration `json:"initialDelay"`
+	Multiplier   float64       `json:"multiplier"`
+	MaxDelay     time.Duration `json:"maxDelay"`
+	MaxAttempts  int           `json:"maxAttempts"` // 0 表示不限制
+	ResetAfter   time.Duration `json:"resetAfter"`
+}
+
+/*
+*
+* 全局配置里的默认策略
+*
+ */
+func DefaultBackoffPolicy() BackoffPolicy {
+	return BackoffPolicy{
+		InitialDelay: time.Duration(core.GlobalConfig.RestartInitialDelay) * time.Millisecond,
+		Multiplier:   core.GlobalConfig.RestartMultiplier,
+		MaxDelay:     time.Duration(core.GlobalConfig.RestartMaxDelay) * time.Millisecond,
+		MaxAttempts:  core.GlobalConfig.RestartMaxAttempts,
+		ResetAfter:   time.Duration(core.GlobalConfig.RestartResetAfter) * time.Millisecond,
+	}.normalize()
+}
+
+/*
+*
+* 解析资源配置里的 restartPolicy, 没有配置的话返回 nil
+*
+ */
+func ParseRestartPolicy(config map[string]any) (*resconfig.RestartPolicy, error) {
+	value, ok := config["restartPolicy"]
+	if !ok || value == nil {
+		return nil, nil
+	}
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	policy := resconfig.RestartPolicy{}
+	if err := json.Unmarshal(bytes, &policy); err != nil {
+		return nil, err
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+/*
+*
+* 资源自己的策略覆盖全局策略; 配置有问题的时候使用全局策略, 创建和更新的时候已经检查过了
+*
+ */
+func ResolveBackoffPolicy(config map[string]any) BackoffPolicy {
+	policy := DefaultBackoffPolicy()
+	custom, err := ParseRestartPolicy(config)
+	if err != nil || custom == nil {
+		return policy
+	}
+	if custom.InitialDelay != nil {
+		policy.InitialDelay = time.Duration(*custom.InitialDelay) * time.Millisecond
+	}
+	if custom.Multiplier != nil {
+		policy.Multiplier = *custom.Multiplier
+	}
+	if custom.MaxDelay != nil {
+		policy.MaxDelay = time.Duration(*custom.MaxDelay) * time.Millisecond
+	}
+	if custom.MaxAttempts != nil {
+		policy.MaxAttempts = *custom.MaxAttempts
+	}
+	if custom.ResetAfter != nil {
+		policy.ResetAfter = time.Duration(*custom.ResetAfter) * time.Millisecond
+	}
+	return policy.normalize()
+}
+
+func (p BackoffPolicy) normalize() BackoffPolicy {
+	if p.InitialDelay < minRestartDelay {
+		p.InitialDelay = minRestartDelay
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 1
+	}
+	if p.MaxDelay < p.InitialDelay {
+		p.MaxDelay = p.InitialDelay
+	}
+	if p.MaxAttempts < 0 {
+		p.MaxAttempts = 0
+	}
+	return p
+}
+
+/*
+*
+* 第 attempt 次连续重启前等待的时间, attempt 从1开始
+*
+ */
+func (p BackoffPolicy) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(attempt-1))
+	if delay > float64(p.MaxDelay) || math.IsInf(delay, 0) || math.IsNaN(delay) {
+		return p.MaxDelay
+	}
+	return time.Duration(delay)
+}
@@ -0,0 +1,77 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_BackoffPolicy_Delay(t *testing.T) {
+	p := BackoffPolicy{
+		InitialDelay: 2 * time.Second,
+		Multiplier:   2,
+		MaxDelay:     10 * time.Second,
+	}.normalize()
+	expect := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, e := range expect {
+		if got := p.Delay(i + 1); got != e {
+			t.Fatalf("attempt %d: expect %v, got %v", i+1, e, got)
+		}
+	}
+	if got := p.Delay(10000); got != 10*time.Second {
+		t.Fatalf("overflow must be capped, got %v", got)
+	}
+}
+
+func Test_ResolveBackoffPolicy(t *testing.T) {
+	p := ResolveBackoffPolicy(map[string]any{
+		"restartPolicy": map[string]any{"initialDelay": 3000, "maxAttempts": 0},
+	})
+	if p.InitialDelay != 3*time.Second || p.MaxAttempts != 0 {
+		t.Fatalf("unexpected policy: %+v", p)
+	}
+	if _, err := ParseRestartPolicy(map[string]any{
+		"restartPolicy": map[string]any{"multiplier": 0.5},
+	}); err == nil {
+		t.Fatal("multiplier less than 1 must be rejected")
+	}
+}
+
+func Test_RestartTracker_CrashLoop(t *testing.T) {
+	uuid := "DEVICE_CRASH_LOOP"
+	defer RemoveRestart(uuid)
+	p := BackoffPolicy{
+		InitialDelay: time.Second,
+		Multiplier:   2,
+		MaxDelay:     time.Minute,
+		MaxAttempts:  3,
+		ResetAfter:   time.Minute,
+	}.normalize()
+	now := time.Now()
+	for i := 1; i <= 3; i++ {
+		delay, ok := nextRestartAt(uuid, p, "timeout", now)
+		if !ok || delay != p.Delay(i) {
+			t.Fatalf("attempt %d: expect restart after %v, got %v %v", i, p.Delay(i), delay, ok)
+		}
+		// UP 的时间不够长, 不清零
+		markUpAt(uuid, p, now)
+		now = now.Add(10 * time.Second)
+	}
+	if _, ok := nextRestartAt(uuid, p, "timeout", now); ok {
+		t.Fatal("resource must be FAILED after max attempts")
+	}
+	status := GetRestartStatus(uuid)
+	if !status.Failed || status.TotalRestarts != 3 || len(status.History) != 3 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	ResetRestart(uuid)
+	if IsRestartFailed(uuid) {
+		t.Fatal("manual restart must clear FAILED")
+	}
+	// 稳定运行以后清零
+	nextRestartAt(uuid, p, "timeout", now)
+	markUpAt(uuid, p, now)
+	markUpAt(uuid, p, now.Add(time.Minute))
+	if status := GetRestartStatus(uuid); status.Attempts != 0 {
+		t.Fatalf("attempts must be reset after stable run, got %d", status.Attempts)
+	}
+}
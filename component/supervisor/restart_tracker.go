@@ -0,0 +1,173 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package supervisor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hootrhino/rhilex/typex"
+)
+
+// 每个资源最多保留的重启记录
+const maxRestartHistory = 32
+
+// 一次自动重启
+type RestartRecord struct {
+	Ts      uint64 `json:"ts"`      // 决定重启的时间, 毫秒
+	Attempt int    `json:"attempt"` // 第几次连续重启
+	Delay   int64  `json:"delay"`   // 重启前等待的时间, 毫秒
+	Reason  string `json:"reason"`  // DOWN 的原因
+}
+
+/*
+*
+* 资源的重启状态, 详情接口里面展示
+*
+ */
+type RestartStatus struct {
+	Failed        bool            `json:"failed"`        // 超过重启次数, 不再自动重启
+	Attempts      int             `json:"attempts"`      // 当前连续重启的次数
+	TotalRestarts uint64          `json:"totalRestarts"` // 累计自动重启的次数
+	NextRestartAt uint64          `json:"nextRestartAt"` // 下次重启的时间, 毫秒, 0 表示没有
+	UpSince       uint64          `json:"upSince"`       // 本次 UP 的时间, 毫秒, 0 表示没有 UP
+	History       []RestartRecord `json:"history"`       // 最近的重启记录
+}
+
+var restartTracker = struct {
+	sync.Mutex
+	status map[string]*RestartStatus
+}{status: map[string]*RestartStatus{}}
+
+// 需要持有锁
+func restartStatus(uuid string) *RestartStatus {
+	s, ok := restartTracker.status[uuid]
+	if !ok {
+		s = &RestartStatus{History: []RestartRecord{}}
+		restartTracker.status[uuid] = s
+	}
+	return s
+}
+
+/*
+*
+* 监控器发现资源 UP; 稳定运行超过 ResetAfter 以后清零连续重启次数
+*
+ */
+func MarkUp(uuid string, policy BackoffPolicy) {
+	markUpAt(uuid, policy, time.Now())
+}
+
+func markUpAt(uuid string, policy BackoffPolicy, now time.Time) {
+	restartTracker.Lock()
+	defer restartTracker.Unlock()
+	s := restartStatus(uuid)
+	ts := uint64(now.UnixMilli())
+	s.NextRestartAt = 0
+	if s.UpSince == 0 {
+		s.UpSince = ts
+	}
+	if s.Attempts > 0 && ts-s.UpSince >= uint64(policy.ResetAfter.Milliseconds()) {
+		s.Attempts = 0
+	}
+}
+
+/*
+*
+* 资源 DOWN 以后计算下次重启前等待的时间; 返回 false 表示超过了重启次数, 资源进入 FAILED
+*
+ */
+func NextRestart(uuid string, policy BackoffPolicy, reason string) (time.Duration, bool) {
+	return nextRestartAt(uuid, policy, reason, time.Now())
+}
+
+func nextRestartAt(uuid string, policy BackoffPolicy, reason string, now time.Time) (time.Duration, bool) {
+	restartTracker.Lock()
+	defer restartTracker.Unlock()
+	s := restartStatus(uuid)
+	s.UpSince = 0
+	s.NextRestartAt = 0
+	if policy.MaxAttempts > 0 && s.Attempts >= policy.MaxAttempts {
+		s.Failed = true
+		return 0, false
+	}
+	s.Attempts++
+	s.TotalRestarts++
+	delay := policy.Delay(s.Attempts)
+	ts := uint64(now.UnixMilli())
+	s.NextRestartAt = ts + uint64(delay.Milliseconds())
+	s.History = append(s.History, RestartRecord{
+		Ts:      ts,
+		Attempt: s.Attempts,
+		Delay:   delay.Milliseconds(),
+		Reason:  reason,
+	})
+	if len(s.History) > maxRestartHistory {
+		s.History = s.History[len(s.History)-maxRestartHistory:]
+	}
+	return delay, true
+}
+
+// 重启状态的快照
+func GetRestartStatus(uuid string) RestartStatus {
+	restartTracker.Lock()
+	defer restartTracker.Unlock()
+	s, ok := restartTracker.status[uuid]
+	if !ok {
+		return RestartStatus{History: []RestartRecord{}}
+	}
+	c := *s
+	c.History = append([]RestartRecord{}, s.History...)
+	return c
+}
+
+// 是否已经进入 FAILED
+func IsRestartFailed(uuid string) bool {
+	restartTracker.Lock()
+	defer restartTracker.Unlock()
+	s, ok := restartTracker.status[uuid]
+	return ok && s.Failed
+}
+
+// 进入 FAILED 的资源对外展示为 FAILED
+func RestartState(uuid string, state typex.SourceState) typex.SourceState {
+	if IsRestartFailed(uuid) {
+		return typex.SOURCE_FAILED
+	}
+	return state
+}
+
+/*
+*
+* 手动重启或者更新配置以后清零连续重启次数, 保留历史记录
+*
+ */
+func ResetRestart(uuid string) {
+	restartTracker.Lock()
+	defer restartTracker.Unlock()
+	if s, ok := restartTracker.status[uuid]; ok {
+		s.Failed = false
+		s.Attempts = 0
+		s.NextRestartAt = 0
+	}
+}
+
+// 资源被删除以后清理掉
+func RemoveRestart(uuid string) {
+	restartTracker.Lock()
+	defer restartTracker.Unlock()
+	delete(restartTracker.status, uuid)
+}
@@ -37,22 +37,33 @@ func InitGlobalConfig(path string) typex.RhilexConfig {
 		os.Exit(1)
 	}
 	GlobalConfig = typex.RhilexConfig{
-		AppId:                 "rhilex",
-		IniPath:               path,
-		MaxQueueSize:          10240,
-		SourceRestartInterval: 5000,
-		GomaxProcs:            0,
-		EnablePProf:           false,
-		EnableConsole:         false,
-		DebugMode:             false,
-		LogLevel:              "info",
-		LogMaxSize:            5,    // MB
-		LogMaxBackups:         5,    // Per
-		LogMaxAge:             7,    // days
-		LogCompress:           true, // Compress
-		MaxKvStoreSize:        1024, // 20MB
-		ExtLibs:               []string{},
-		DataSchemaSecret:      []string{"rhilex-secret"},
+		AppId:               "rhilex",
+		IniPath:             path,
+		MaxQueueSize:        10240,
+		QueueWorkers:        0,             // 0: CPU 核数
+		QueueFullPolicy:     "drop_newest", // block|drop_oldest|drop_newest
+		RestartInitialDelay: 5000,          // ms
+		RestartMultiplier:   2,
+		RestartMaxDelay:     300000,   // ms
+		RestartMaxAttempts:  10,       // 0: 不限制
+		RestartResetAfter:   60000,    // ms
+		LuaTimeout:          5000,     // ms
+		LuaMaxInstructions:  10000000, // 0: 不限制
+		LuaMaxMemory:        64,       // MB
+		LuaMaxViolations:    3,        // 0: 不自动禁用
+		GomaxProcs:          0,
+		EnablePProf:         false,
+		EnableMetrics:       false, // 不需要登录的 /metrics
+		EnableConsole:       false,
+		DebugMode:           false,
+		LogLevel:            "info",
+		LogMaxSize:          5,    // MB
+		LogMaxBackups:       5,    // Per
+		LogMaxAge:           7,    // days
+		LogCompress:         true, // Compress
+		MaxKvStoreSize:      1024, // 20MB
+		ExtLibs:             []string{},
+		DataSchemaSecret:    []string{"rhilex-secret"},
 	}
 	if err := cfg.Section("main").MapTo(&GlobalConfig); err != nil {
 		log.Fatalf("[RHILEX INIT] Fail to map config file: %v", err)
@@ -0,0 +1,428 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package bacnet
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// BACnet/IP 的 BVLC 头
+const (
+	bvlcType              byte = 0x81
+	bvlcForwardedNPDU     byte = 0x04
+	bvlcOriginalUnicast   byte = 0x0A
+	bvlcOriginalBroadcast byte = 0x0B
+)
+
+// APDU 类型
+const (
+	PduConfirmedRequest   byte = 0x00
+	PduUnconfirmedRequest byte = 0x01
+	PduSimpleAck          byte = 0x02
+	PduComplexAck         byte = 0x03
+	PduError              byte = 0x05
+	PduReject             byte = 0x06
+	PduAbort              byte = 0x07
+)
+
+// 用到的服务
+const (
+	ServiceConfirmedCOVNotification   byte = 1
+	ServiceConfirmedSubscribeCOV      byte = 5
+	ServiceUnconfirmedCOVNotification byte = 2
+)
+
+// 属性 ID
+const (
+	PropPresentValue  uint32 = 85
+	PropStatusFlags   uint32 = 111
+	maxInstanceNumber uint32 = 0x3FFFFF
+)
+
+/*
+*
+* 收到的一帧 BACnet/IP 报文, 只解析到 APDU 的服务层;
+* SourceNet/SourceAdr 是经过路由器转发过来的时候原始设备的网络号和地址
+*
+ */
+type Frame struct {
+	PduType   byte
+	InvokeId  byte
+	Service   byte
+	Data      []byte
+	SourceNet uint16
+	SourceAdr []byte
+}
+
+/*
+*
+* 解析 BVLC + NPDU + APDU, 网络层报文返回错误
+*
+ */
+func ParseFrame(b []byte) (Frame, error) {
+	frame := Frame{}
+	if len(b) < 4 || b[0] != bvlcType {
+		return frame, errors.New("not a bacnet/ip frame")
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length > len(b) || length < 4 {
+		return frame, errors.New("invalid bvlc length")
+	}
+	b = b[:length]
+	switch b[1] {
+	case bvlcOriginalUnicast, bvlcOriginalBroadcast:
+		b = b[4:]
+	case bvlcForwardedNPDU:
+		if len(b) < 10 {
+			return frame, errors.New("invalid forwarded npdu")
+		}
+		b = b[10:]
+	default:
+		return frame, fmt.Errorf("unsupported bvlc function: 0x%02X", b[1])
+	}
+	// NPDU
+	if len(b) < 2 || b[0] != 0x01 {
+		return frame, errors.New("invalid npdu version")
+	}
+	control := b[1]
+	b = b[2:]
+	if control&0x80 != 0 {
+		return frame, errors.New("network layer message")
+	}
+	hasDest := control&0x20 != 0
+	if hasDest {
+		if len(b) < 3 || len(b) < 3+int(b[2]) {
+			return frame, errors.New("invalid npdu destination")
+		}
+		b = b[3+int(b[2]):]
+	}
+	if control&0x08 != 0 {
+		if len(b) < 3 || len(b) < 3+int(b[2]) {
+			return frame, errors.New("invalid npdu source")
+		}
+		frame.SourceNet = binary.BigEndian.Uint16(b[0:2])
+		frame.SourceAdr = append([]byte{}, b[3:3+int(b[2])]...)
+		b = b[3+int(b[2]):]
+	}
+	if hasDest {
+		if len(b) < 1 {
+			return frame, errors.New("invalid npdu hop count")
+		}
+		b = b[1:]
+	}
+	// APDU
+	if len(b) < 1 {
+		return frame, errors.New("empty apdu")
+	}
+	frame.PduType = b[0] >> 4
+	switch frame.PduType {
+	case PduConfirmedRequest:
+		offset := 4
+		if b[0]&0x08 != 0 { // 分段报文, 多了序号和窗口
+			offset = 6
+		}
+		if len(b) < offset {
+			return frame, errors.New("invalid confirmed request")
+		}
+		frame.InvokeId = b[2]
+		frame.Service = b[offset-1]
+		frame.Data = b[offset:]
+	case PduUnconfirmedRequest:
+		if len(b) < 2 {
+			return frame, errors.New("invalid unconfirmed request")
+		}
+		frame.Service = b[1]
+		frame.Data = b[2:]
+	case PduSimpleAck, PduComplexAck, PduError:
+		if len(b) < 3 {
+			return frame, errors.New("invalid ack")
+		}
+		frame.InvokeId = b[1]
+		frame.Service = b[2]
+		frame.Data = b[3:]
+	case PduReject, PduAbort:
+		if len(b) < 3 {
+			return frame, errors.New("invalid reject or abort")
+		}
+		frame.InvokeId = b[1]
+		frame.Data = b[2:]
+	default:
+		return frame, fmt.Errorf("unsupported pdu type: %d", frame.PduType)
+	}
+	return frame, nil
+}
+
+/*
+*
+* 打包成单播报文; net 不为0的时候带上目的网络号和地址, 由路由器转发
+*
+ */
+func encodeFrame(net uint16, adr []byte, expectingReply bool, apdu []byte) []byte {
+	npdu := []byte{0x01, 0x00}
+	if expectingReply {
+		npdu[1] |= 0x04
+	}
+	if net != 0 {
+		npdu[1] |= 0x20
+		npdu = binary.BigEndian.AppendUint16(npdu, net)
+		npdu = append(npdu, byte(len(adr)))
+		npdu = append(npdu, adr...)
+		npdu = append(npdu, 0xFF) // hop count
+	}
+	frame := []byte{bvlcType, bvlcOriginalUnicast, 0, 0}
+	frame = append(frame, npdu...)
+	frame = append(frame, apdu...)
+	binary.BigEndian.PutUint16(frame[2:4], uint16(len(frame)))
+	return frame
+}
+
+func encodeSimpleAck(invokeId, service byte) []byte {
+	return []byte{PduSimpleAck << 4, invokeId, service}
+}
+
+// 确认请求的头, 不分段, 最大 APDU 1476
+func encodeConfirmedHeader(invokeId, service byte) []byte {
+	return []byte{PduConfirmedRequest << 4, 0x05, invokeId, service}
+}
+
+/*
+*
+* Tag 编码
+*
+ */
+func appendTag(b []byte, tag byte, context bool, length uint32) []byte {
+	first := byte(0)
+	if context {
+		first |= 0x08
+	}
+	extraTag := tag >= 15
+	if extraTag {
+		first |= 0xF0
+	} else {
+		first |= tag << 4
+	}
+	if length <= 4 {
+		first |= byte(length)
+	} else {
+		first |= 0x05
+	}
+	b = append(b, first)
+	if extraTag {
+		b = append(b, tag)
+	}
+	switch {
+	case length <= 4:
+	case length <= 253:
+		b = append(b, byte(length))
+	case length <= 65535:
+		b = append(b, 254)
+		b = binary.BigEndian.AppendUint16(b, uint16(length))
+	default:
+		b = append(b, 255)
+		b = binary.BigEndian.AppendUint32(b, length)
+	}
+	return b
+}
+
+func unsignedBytes(v uint32) []byte {
+	switch {
+	case v <= 0xFF:
+		return []byte{byte(v)}
+	case v <= 0xFFFF:
+		return binary.BigEndian.AppendUint16(nil, uint16(v))
+	case v <= 0xFFFFFF:
+		return []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+	}
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func appendContextUnsigned(b []byte, tag byte, v uint32) []byte {
+	data := unsignedBytes(v)
+	b = appendTag(b, tag, true, uint32(len(data)))
+	return append(b, data...)
+}
+
+func appendContextBool(b []byte, tag byte, v bool) []byte {
+	b = appendTag(b, tag, true, 1)
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+func appendContextObjectId(b []byte, tag byte, objectType uint16, instance uint32) []byte {
+	b = appendTag(b, tag, true, 4)
+	return binary.BigEndian.AppendUint32(b, uint32(objectType)<<22|instance&maxInstanceNumber)
+}
+
+/*
+*
+* Tag 解码
+*
+ */
+type tag struct {
+	Number  byte
+	Context bool
+	Opening bool
+	Closing bool
+	Length  uint32 // 应用层布尔值的时候就是值本身
+	Size    int    // Tag 头本身的长度
+}
+
+func readTag(b []byte) (tag, error) {
+	t := tag{}
+	if len(b) < 1 {
+		return t, errors.New("tag out of range")
+	}
+	first := b[0]
+	t.Size = 1
+	t.Number = first >> 4
+	t.Context = first&0x08 != 0
+	if t.Number == 15 {
+		if len(b) < 2 {
+			return t, errors.New("tag out of range")
+		}
+		t.Number = b[1]
+		t.Size++
+	}
+	lvt := first & 0x07
+	switch {
+	case t.Context && lvt == 6:
+		t.Opening = true
+	case t.Context && lvt == 7:
+		t.Closing = true
+	case lvt == 5:
+		if len(b) < t.Size+1 {
+			return t, errors.New("tag out of range")
+		}
+		ext := b[t.Size]
+		t.Size++
+		switch ext {
+		case 254:
+			if len(b) < t.Size+2 {
+				return t, errors.New("tag out of range")
+			}
+			t.Length = uint32(binary.BigEndian.Uint16(b[t.Size:]))
+			t.Size += 2
+		case 255:
+			if len(b) < t.Size+4 {
+				return t, errors.New("tag out of range")
+			}
+			t.Length = binary.BigEndian.Uint32(b[t.Size:])
+			t.Size += 4
+		default:
+			t.Length = uint32(ext)
+		}
+	default:
+		t.Length = uint32(lvt)
+	}
+	return t, nil
+}
+
+// 读一个指定编号的上下文 Tag 的内容, 不存在的时候 ok 为 false
+func readContext(b []byte, number byte) (data []byte, rest []byte, ok bool, err error) {
+	t, err := readTag(b)
+	if err != nil {
+		return nil, b, false, err
+	}
+	if !t.Context || t.Opening || t.Closing || t.Number != number {
+		return nil, b, false, nil
+	}
+	end := t.Size + int(t.Length)
+	if end > len(b) {
+		return nil, b, false, errors.New("tag content out of range")
+	}
+	return b[t.Size:end], b[end:], true, nil
+}
+
+func decodeUnsigned(b []byte) uint32 {
+	v := uint32(0)
+	for _, x := range b {
+		v = v<<8 | uint32(x)
+	}
+	return v
+}
+
+func decodeSigned(b []byte) int32 {
+	if len(b) == 0 {
+		return 0
+	}
+	v := int32(int8(b[0]))
+	for _, x := range b[1:] {
+		v = v<<8 | int32(x)
+	}
+	return v
+}
+
+func decodeObjectId(b []byte) (uint16, uint32, error) {
+	if len(b) != 4 {
+		return 0, 0, errors.New("invalid object identifier")
+	}
+	v := binary.BigEndian.Uint32(b)
+	return uint16(v >> 22), v & maxInstanceNumber, nil
+}
+
+/*
+*
+* 解码应用层数据: Null 为 nil, 布尔为 bool, 无符号和枚举为 uint32, 有符号为 int32,
+* 浮点为 float32/float64, 字符串为 string, 其他类型返回十六进制字符串
+*
+ */
+func decodeAppValue(b []byte) (any, int, error) {
+	t, err := readTag(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	if t.Context {
+		return nil, 0, fmt.Errorf("unexpected context tag: %d", t.Number)
+	}
+	if t.Number == 1 { // Boolean 的值在 Tag 里面
+		return t.Length != 0, t.Size, nil
+	}
+	end := t.Size + int(t.Length)
+	if end > len(b) {
+		return nil, 0, errors.New("value out of range")
+	}
+	data := b[t.Size:end]
+	switch t.Number {
+	case 0:
+		return nil, end, nil
+	case 2, 9:
+		return decodeUnsigned(data), end, nil
+	case 3:
+		return decodeSigned(data), end, nil
+	case 4:
+		if len(data) != 4 {
+			return nil, 0, errors.New("invalid real")
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(data)), end, nil
+	case 5:
+		if len(data) != 8 {
+			return nil, 0, errors.New("invalid double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), end, nil
+	case 7:
+		if len(data) < 1 {
+			return "", end, nil
+		}
+		return string(data[1:]), end, nil // 第一个字节是字符集, 只处理 UTF-8
+	}
+	return hex.EncodeToString(data), end, nil
+}
@@ -0,0 +1,205 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package bacnet
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+*
+* SubscribeCOV 请求; lifetime 单位为秒, 0 表示永久订阅
+*
+ */
+type COVSubscription struct {
+	ProcessId  uint32
+	ObjectType uint16
+	Instance   uint32
+	Confirmed  bool
+	Lifetime   uint32
+}
+
+func EncodeSubscribeCOV(invokeId byte, sub COVSubscription) []byte {
+	b := encodeConfirmedHeader(invokeId, ServiceConfirmedSubscribeCOV)
+	b = appendContextUnsigned(b, 0, sub.ProcessId)
+	b = appendContextObjectId(b, 1, sub.ObjectType, sub.Instance)
+	b = appendContextBool(b, 2, sub.Confirmed)
+	b = appendContextUnsigned(b, 3, sub.Lifetime)
+	return b
+}
+
+// 取消订阅: 不带 issueConfirmedNotifications 和 lifetime
+func EncodeCancelCOV(invokeId byte, sub COVSubscription) []byte {
+	b := encodeConfirmedHeader(invokeId, ServiceConfirmedSubscribeCOV)
+	b = appendContextUnsigned(b, 0, sub.ProcessId)
+	b = appendContextObjectId(b, 1, sub.ObjectType, sub.Instance)
+	return b
+}
+
+/*
+*
+* COV 通知里面的一个属性值
+*
+ */
+type COVValue struct {
+	Property uint32 `json:"property"`
+	Value    any    `json:"value"`
+}
+
+/*
+*
+* COV 通知, 确认和非确认两种格式一样
+*
+ */
+type COVNotification struct {
+	ProcessId     uint32
+	DeviceId      uint32
+	ObjectType    uint16
+	Instance      uint32
+	TimeRemaining uint32
+	Values        []COVValue
+}
+
+/*
+*
+* 当前值
+*
+ */
+func (n COVNotification) PresentValue() (any, bool) {
+	for _, v := range n.Values {
+		if v.Property == PropPresentValue {
+			return v.Value, true
+		}
+	}
+	return nil, false
+}
+
+func DecodeCOVNotification(b []byte) (COVNotification, error) {
+	n := COVNotification{}
+	data, b, ok, err := readContext(b, 0)
+	if err != nil || !ok {
+		return n, errors.New("missing subscriber process identifier")
+	}
+	n.ProcessId = decodeUnsigned(data)
+	if data, b, ok, err = readContext(b, 1); err != nil || !ok {
+		return n, errors.New("missing initiating device identifier")
+	}
+	if _, n.DeviceId, err = decodeObjectId(data); err != nil {
+		return n, err
+	}
+	if data, b, ok, err = readContext(b, 2); err != nil || !ok {
+		return n, errors.New("missing monitored object identifier")
+	}
+	if n.ObjectType, n.Instance, err = decodeObjectId(data); err != nil {
+		return n, err
+	}
+	if data, b, ok, err = readContext(b, 3); err != nil || !ok {
+		return n, errors.New("missing time remaining")
+	}
+	n.TimeRemaining = decodeUnsigned(data)
+	t, err := readTag(b)
+	if err != nil || !t.Opening || t.Number != 4 {
+		return n, errors.New("missing list of values")
+	}
+	b = b[t.Size:]
+	for {
+		t, err := readTag(b)
+		if err != nil {
+			return n, err
+		}
+		if t.Closing && t.Number == 4 {
+			break
+		}
+		value := COVValue{}
+		if data, b, ok, err = readContext(b, 0); err != nil || !ok {
+			return n, errors.New("missing property identifier")
+		}
+		value.Property = decodeUnsigned(data)
+		if _, b, _, err = readContext(b, 1); err != nil { // 数组下标, 不关心
+			return n, err
+		}
+		if value.Value, b, err = decodePropertyValue(b); err != nil {
+			return n, fmt.Errorf("property %d: %v", value.Property, err)
+		}
+		if _, b, _, err = readContext(b, 3); err != nil { // 优先级, 不关心
+			return n, err
+		}
+		n.Values = append(n.Values, value)
+	}
+	return n, nil
+}
+
+/*
+*
+* 解析 [2] 开闭 Tag 里面的值, 只有一个值的时候直接返回, 多个值的时候返回数组;
+* 构造类型(比如 StatusFlags 以外的结构体)不展开, 返回 nil
+*
+ */
+func decodePropertyValue(b []byte) (any, []byte, error) {
+	t, err := readTag(b)
+	if err != nil || !t.Opening || t.Number != 2 {
+		return nil, b, errors.New("missing property value")
+	}
+	b = b[t.Size:]
+	values := []any{}
+	depth := 0
+	for {
+		t, err := readTag(b)
+		if err != nil {
+			return nil, b, err
+		}
+		if t.Closing && depth == 0 {
+			if t.Number != 2 {
+				return nil, b, errors.New("unexpected closing tag")
+			}
+			b = b[t.Size:]
+			break
+		}
+		switch {
+		case t.Opening:
+			depth++
+			b = b[t.Size:]
+			continue
+		case t.Closing:
+			depth--
+			b = b[t.Size:]
+			continue
+		case t.Context:
+			end := t.Size + int(t.Length)
+			if end > len(b) {
+				return nil, b, errors.New("value out of range")
+			}
+			b = b[end:]
+			continue
+		}
+		value, size, err := decodeAppValue(b)
+		if err != nil {
+			return nil, b, err
+		}
+		b = b[size:]
+		if depth == 0 {
+			values = append(values, value)
+		}
+	}
+	switch len(values) {
+	case 0:
+		return nil, b, nil
+	case 1:
+		return values[0], b, nil
+	}
+	return values, b, nil
+}
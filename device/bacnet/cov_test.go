@@ -0,0 +1,103 @@
+package bacnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_COV_Encode(t *testing.T) {
+	sub := COVSubscription{ProcessId: 18, ObjectType: 0, Instance: 10, Confirmed: true, Lifetime: 300}
+	expect, _ := hex.DecodeString("00050105" + "0912" + "1c0000000a" + "2901" + "3a012c")
+	if got := EncodeSubscribeCOV(1, sub); !bytes.Equal(got, expect) {
+		t.Fatalf("subscribe: expect %x, got %x", expect, got)
+	}
+	expect, _ = hex.DecodeString("00050205" + "0912" + "1c0000000a")
+	if got := EncodeCancelCOV(2, sub); !bytes.Equal(got, expect) {
+		t.Fatalf("cancel: expect %x, got %x", expect, got)
+	}
+}
+
+func Test_COV_Decode(t *testing.T) {
+	// ASHRAE 135 附录 F 里面的 UnconfirmedCOVNotification 例子
+	data, _ := hex.DecodeString("0912" + "1c02000004" + "2c0000000a" + "3900" +
+		"4e" + "0955" + "2e" + "4442820000" + "2f" + "096f" + "2e" + "820400" + "2f" + "4f")
+	n, err := DecodeCOVNotification(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ProcessId != 18 || n.DeviceId != 4 || n.ObjectType != 0 || n.Instance != 10 || len(n.Values) != 2 {
+		t.Fatalf("unexpected notification: %+v", n)
+	}
+	if v, ok := n.PresentValue(); !ok || v != float32(65) {
+		t.Fatalf("unexpected present value: %v", v)
+	}
+	if _, err := DecodeCOVNotification(data[:10]); err == nil {
+		t.Fatal("truncated notification must be rejected")
+	}
+}
+
+func Test_COV_Loopback(t *testing.T) {
+	device, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer device.Close()
+	notifications := make(chan COVNotification, 1)
+	s, err := NewSubscriber(0, nil, func(n COVNotification) { notifications <- n })
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	// 模拟设备: 回 SimpleAck, 然后发一条确认型通知
+	acked := make(chan bool, 1)
+	go func() {
+		buffer := make([]byte, 1500)
+		n, remote, err := device.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		frame, err := ParseFrame(buffer[:n])
+		if err != nil || frame.Service != ServiceConfirmedSubscribeCOV {
+			return
+		}
+		device.WriteToUDP(encodeFrame(0, nil, false, encodeSimpleAck(frame.InvokeId, frame.Service)), remote)
+		body, _ := hex.DecodeString("0912" + "1c02000004" + "2c0000000a" + "39b4" +
+			"4e" + "0955" + "2e" + "4442820000" + "2f" + "4f")
+		apdu := append(encodeConfirmedHeader(7, ServiceConfirmedCOVNotification), body...)
+		device.WriteToUDP(encodeFrame(0, nil, true, apdu), remote)
+		n, _, err = device.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		frame, err = ParseFrame(buffer[:n])
+		acked <- err == nil && frame.PduType == PduSimpleAck && frame.InvokeId == 7
+	}()
+	target := Target{Addr: device.LocalAddr().(*net.UDPAddr)}
+	sub := COVSubscription{ProcessId: 18, Instance: 10, Confirmed: true, Lifetime: 300}
+	if err := s.Subscribe(target, sub); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case n := <-notifications:
+		if n.TimeRemaining != 180 {
+			t.Fatalf("unexpected notification: %+v", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("notification timeout")
+	}
+	select {
+	case ok := <-acked:
+		if !ok {
+			t.Fatal("confirmed notification must be acked")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ack timeout")
+	}
+}
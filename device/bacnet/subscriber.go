@@ -0,0 +1,239 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package bacnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+*
+* 远程设备地址; Net 不为 0 的时候表示设备在路由器后面, Adr 是它在那个网络里面的地址
+*
+ */
+type Target struct {
+	Addr *net.UDPAddr
+	Net  uint16
+	Adr  []byte
+}
+
+/*
+*
+* COV 订阅客户端: 用单独的 UDP 端口发 SubscribeCOV, 设备会把 COV 通知
+* 发回到这个端口; 确认型通知会自动回 SimpleAck
+*
+ */
+type Subscriber struct {
+	Timeout  time.Duration
+	Logger   logrus.FieldLogger
+	OnNotify func(COVNotification)
+
+	conn          *net.UDPConn
+	invokeId      byte
+	pendingLocker sync.Mutex
+	pending       map[byte]chan error // 等待 SimpleAck 的请求
+}
+
+func NewSubscriber(port int, logger logrus.FieldLogger, onNotify func(COVNotification)) (*Subscriber, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+	if err != nil {
+		return nil, err
+	}
+	return &Subscriber{
+		Timeout:  3 * time.Second,
+		Logger:   logger,
+		OnNotify: onNotify,
+		conn:     conn,
+		pending:  map[byte]chan error{},
+	}, nil
+}
+
+func (s *Subscriber) LocalAddr() *net.UDPAddr {
+	return s.conn.LocalAddr().(*net.UDPAddr)
+}
+
+/*
+*
+* 接收循环, ctx 结束或者连接关闭的时候退出
+*
+ */
+func (s *Subscriber) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		s.conn.Close()
+	}()
+	buffer := make([]byte, 1500)
+	for {
+		n, remote, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		frame, err := ParseFrame(buffer[:n])
+		if err != nil {
+			s.debugf("bacnet cov: ignore frame from %s: %v", remote, err)
+			continue
+		}
+		s.handle(remote, frame)
+	}
+}
+
+func (s *Subscriber) handle(remote *net.UDPAddr, frame Frame) {
+	switch frame.PduType {
+	case PduSimpleAck:
+		s.resolve(frame.InvokeId, nil)
+	case PduError:
+		s.resolve(frame.InvokeId, decodeError(frame.Data))
+	case PduReject:
+		s.resolve(frame.InvokeId, fmt.Errorf("request rejected, reason: %d", firstByte(frame.Data)))
+	case PduAbort:
+		s.resolve(frame.InvokeId, fmt.Errorf("request aborted, reason: %d", firstByte(frame.Data)))
+	case PduUnconfirmedRequest:
+		if frame.Service == ServiceUnconfirmedCOVNotification {
+			s.notify(remote, frame.Data)
+		}
+	case PduConfirmedRequest:
+		if frame.Service == ServiceConfirmedCOVNotification {
+			ack := encodeFrame(frame.SourceNet, frame.SourceAdr, false,
+				encodeSimpleAck(frame.InvokeId, frame.Service))
+			if _, err := s.conn.WriteToUDP(ack, remote); err != nil {
+				s.debugf("bacnet cov: ack to %s failed: %v", remote, err)
+			}
+			s.notify(remote, frame.Data)
+		}
+	}
+}
+
+func (s *Subscriber) notify(remote *net.UDPAddr, data []byte) {
+	notification, err := DecodeCOVNotification(data)
+	if err != nil {
+		s.debugf("bacnet cov: invalid notification from %s: %v", remote, err)
+		return
+	}
+	if s.OnNotify != nil {
+		s.OnNotify(notification)
+	}
+}
+
+/*
+*
+* 订阅, 等待设备确认
+*
+ */
+func (s *Subscriber) Subscribe(target Target, sub COVSubscription) error {
+	return s.request(target, func(invokeId byte) []byte {
+		return EncodeSubscribeCOV(invokeId, sub)
+	})
+}
+
+/*
+*
+* 取消订阅
+*
+ */
+func (s *Subscriber) Cancel(target Target, sub COVSubscription) error {
+	return s.request(target, func(invokeId byte) []byte {
+		return EncodeCancelCOV(invokeId, sub)
+	})
+}
+
+func (s *Subscriber) request(target Target, encode func(invokeId byte) []byte) error {
+	if target.Addr == nil {
+		return errors.New("target address is empty")
+	}
+	invokeId, result, err := s.allocate()
+	if err != nil {
+		return err
+	}
+	defer s.release(invokeId)
+	frame := encodeFrame(target.Net, target.Adr, true, encode(invokeId))
+	if _, err := s.conn.WriteToUDP(frame, target.Addr); err != nil {
+		return err
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(s.Timeout):
+		return fmt.Errorf("request timeout: %s", target.Addr)
+	}
+}
+
+func (s *Subscriber) allocate() (byte, chan error, error) {
+	s.pendingLocker.Lock()
+	defer s.pendingLocker.Unlock()
+	for i := 0; i < 256; i++ {
+		s.invokeId++
+		if _, ok := s.pending[s.invokeId]; !ok {
+			result := make(chan error, 1)
+			s.pending[s.invokeId] = result
+			return s.invokeId, result, nil
+		}
+	}
+	return 0, nil, errors.New("too many pending requests")
+}
+
+func (s *Subscriber) release(invokeId byte) {
+	s.pendingLocker.Lock()
+	defer s.pendingLocker.Unlock()
+	delete(s.pending, invokeId)
+}
+
+func (s *Subscriber) resolve(invokeId byte, err error) {
+	s.pendingLocker.Lock()
+	defer s.pendingLocker.Unlock()
+	if result, ok := s.pending[invokeId]; ok {
+		select {
+		case result <- err:
+		default:
+		}
+	}
+}
+
+func (s *Subscriber) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Subscriber) debugf(format string, args ...any) {
+	if s.Logger != nil {
+		s.Logger.Debugf(format, args...)
+	}
+}
+
+// Error PDU 的内容是两个枚举: 错误类别和错误码
+func decodeError(b []byte) error {
+	class, size, err := decodeAppValue(b)
+	if err != nil {
+		return errors.New("request failed")
+	}
+	code, _, err := decodeAppValue(b[size:])
+	if err != nil {
+		return fmt.Errorf("request failed, error class: %v", class)
+	}
+	return fmt.Errorf("request failed, error class: %v, error code: %v", class, code)
+}
+
+func firstByte(b []byte) byte {
+	if len(b) == 0 {
+		return 0
+	}
+	return b[0]
+}
@@ -0,0 +1,140 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package bacnet
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/hootrhino/gobacnet/btypes"
+	"github.com/hootrhino/gobacnet/btypes/null"
+)
+
+// 写优先级, 不填的时候按照 BACnet 的约定使用最低的 16
+const (
+	MinPriority     = 1
+	MaxPriority     = 16
+	DefaultPriority = 16
+)
+
+var objectTypeNames = map[btypes.ObjectType]string{
+	btypes.AnalogInput:      "AI",
+	btypes.AnalogOutput:     "AO",
+	btypes.AnalogValue:      "AV",
+	btypes.BinaryInput:      "BI",
+	btypes.BinaryOutput:     "BO",
+	btypes.BinaryValue:      "BV",
+	btypes.MultiStateInput:  "MI",
+	btypes.MultiStateOutput: "MO",
+	btypes.MultiStateValue:  "MV",
+}
+
+/*
+*
+* 对象类型的简称, 和点位表里面的 objectType 一致; 不支持的类型 ok 为 false
+*
+ */
+func ObjectTypeName(objectType btypes.ObjectType) (string, bool) {
+	name, ok := objectTypeNames[objectType]
+	return name, ok
+}
+
+func CheckPriority(priority int) error {
+	if priority < MinPriority || priority > MaxPriority {
+		return fmt.Errorf("invalid priority: %d, must be between %d and %d",
+			priority, MinPriority, MaxPriority)
+	}
+	return nil
+}
+
+/*
+*
+* 按照对象类型把字符串转成 present-value 的值: 模拟量为 REAL,
+* 开关量为 ENUMERATED(0/1), 多态为 UNSIGNED(从1开始)
+*
+ */
+func PresentValueOf(objectType btypes.ObjectType, value string) (any, error) {
+	value = strings.TrimSpace(value)
+	switch objectType {
+	case btypes.AnalogInput, btypes.AnalogOutput, btypes.AnalogValue:
+		f, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid analog value: %s", value)
+		}
+		return float32(f), nil
+	case btypes.BinaryInput, btypes.BinaryOutput, btypes.BinaryValue:
+		switch strings.ToLower(value) {
+		case "1", "true", "on", "active":
+			return uint32(1), nil
+		case "0", "false", "off", "inactive":
+			return uint32(0), nil
+		}
+		return nil, fmt.Errorf("invalid binary value: %s", value)
+	case btypes.MultiStateInput, btypes.MultiStateOutput, btypes.MultiStateValue:
+		u, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || u < 1 {
+			return nil, fmt.Errorf("invalid multi-state value: %s", value)
+		}
+		return uint32(u), nil
+	}
+	return nil, fmt.Errorf("write is not supported for object type: %s", objectType.String())
+}
+
+/*
+*
+* WriteProperty 的参数; value 为 nil 的时候写 NULL, 也就是释放这个优先级
+*
+ */
+func WritePresentValue(objectType btypes.ObjectType, instance uint32, value any, priority int) btypes.PropertyData {
+	if value == nil {
+		value = null.Null{}
+	}
+	return btypes.PropertyData{
+		Object: btypes.Object{
+			ID: btypes.ObjectID{
+				Type:     objectType,
+				Instance: btypes.ObjectInstance(instance),
+			},
+			Properties: []btypes.Property{
+				{
+					Type:       btypes.PropPresentValue,
+					ArrayIndex: btypes.ArrayAll,
+					Data:       value,
+					// 库里面复用了 NPDU 的优先级类型, 实际编码的是写优先级
+					Priority: btypes.NPDUPriority(priority),
+				},
+			},
+		},
+	}
+}
+
+/*
+*
+* Who-Is 找到的设备转成订阅用的地址
+*
+ */
+func TargetOf(device btypes.Device) (Target, error) {
+	addr, err := device.Addr.UDPAddr()
+	if err != nil {
+		if device.Ip == "" {
+			return Target{}, fmt.Errorf("device %d has no ip address", device.DeviceID)
+		}
+		addr = net.UDPAddr{IP: net.ParseIP(device.Ip), Port: device.Port}
+	}
+	return Target{Addr: &addr, Net: device.Addr.Net, Adr: device.Addr.Adr}, nil
+}
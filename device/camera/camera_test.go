@@ -0,0 +1,126 @@
+package camera
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testJpeg(t *testing.T, w, h int, c uint8) []byte {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = c
+	}
+	img.Set(0, 0, color.Gray{Y: 255 - c})
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func Test_Camera_MjpegStream(t *testing.T) {
+	frames := [][]byte{testJpeg(t, 64, 48, 10), testJpeg(t, 64, 48, 200)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=--frame")
+		for _, frame := range frames {
+			fmt.Fprintf(w, "--frame\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", len(frame))
+			w.Write(frame)
+			w.Write([]byte("\r\n"))
+		}
+		fmt.Fprint(w, "--frame--\r\n")
+	}))
+	defer server.Close()
+	reader, err := OpenMjpegStream(context.Background(), server.URL, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	for i, expect := range frames {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(frame.Data, expect) {
+			t.Fatalf("frame %d mismatch", i)
+		}
+		if w, h, err := frame.Size(); err != nil || w != 64 || h != 48 {
+			t.Fatalf("unexpected frame size: %d x %d, %v", w, h, err)
+		}
+	}
+	if _, err := reader.ReadFrame(); err != io.EOF {
+		t.Fatalf("EOF expected, got %v", err)
+	}
+}
+
+func Test_Camera_JpegStreamReader(t *testing.T) {
+	a, b := testJpeg(t, 16, 16, 0), testJpeg(t, 32, 16, 128)
+	stream := append([]byte{0x00, 0xFF}, a...)
+	stream = append(stream, b...)
+	reader := NewJpegStreamReader(io.NopCloser(bytes.NewReader(stream)))
+	for _, expect := range [][]byte{a, b} {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(frame.Data, expect) {
+			t.Fatal("frame mismatch")
+		}
+	}
+	if _, err := reader.ReadFrame(); err != io.EOF {
+		t.Fatalf("EOF expected, got %v", err)
+	}
+}
+
+func Test_Camera_ProbeOutput(t *testing.T) {
+	info, err := parseProbeOutput([]byte(`{"streams":[{"codec_name":"h264","width":1920,"height":1080,"avg_frame_rate":"0/0","r_frame_rate":"25/1"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Codec != "h264" || info.Width != 1920 || info.Height != 1080 || info.Fps != 25 {
+		t.Fatalf("unexpected stream info: %+v", info)
+	}
+	if _, err := parseProbeOutput([]byte(`{"streams":[]}`)); err == nil {
+		t.Fatal("error expected without video stream")
+	}
+}
+
+func Test_Camera_StoreRetention(t *testing.T) {
+	store, err := NewStore(t.TempDir(), 3, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := testJpeg(t, 8, 8, 50)
+	now := time.Now()
+	// 一张已经过期
+	if _, err := store.Save(Frame{Data: data, Time: now.Add(-2 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := store.Save(Frame{Data: data, Time: now.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshots, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 3 || snapshots[0].Time.UnixMilli() != now.Add(4*time.Second).UnixMilli() {
+		t.Fatalf("unexpected snapshots: %+v", snapshots)
+	}
+	store.maxCount, store.maxBytes = 0, int64(len(data))*2
+	if err := store.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, _ := store.List(); len(snapshots) != 2 {
+		t.Fatalf("unexpected snapshots after size limit: %d", len(snapshots))
+	}
+}
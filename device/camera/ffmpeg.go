@@ -0,0 +1,146 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package camera
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// 视频流的信息
+type StreamInfo struct {
+	Codec  string  `json:"codec"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Fps    float64 `json:"fps"` // 源帧率
+}
+
+/*
+*
+* 用 ffprobe 探测第一路视频流的编码、分辨率和帧率
+*
+ */
+func Probe(ctx context.Context, url string) (StreamInfo, error) {
+	args := []string{"-v", "error"}
+	if strings.HasPrefix(url, "rtsp://") {
+		args = append(args, "-rtsp_transport", "tcp")
+	}
+	args = append(args, "-select_streams", "v:0",
+		"-show_entries", "stream=codec_name,width,height,avg_frame_rate,r_frame_rate",
+		"-of", "json", url)
+	stderr := bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, "ffprobe", args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return StreamInfo{}, fmt.Errorf("ffprobe error: %v, %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseProbeOutput(output)
+}
+
+func parseProbeOutput(output []byte) (StreamInfo, error) {
+	result := struct {
+		Streams []struct {
+			CodecName    string `json:"codec_name"`
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+			AvgFrameRate string `json:"avg_frame_rate"`
+			RFrameRate   string `json:"r_frame_rate"`
+		} `json:"streams"`
+	}{}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return StreamInfo{}, err
+	}
+	if len(result.Streams) == 0 {
+		return StreamInfo{}, errors.New("no video stream")
+	}
+	stream := result.Streams[0]
+	info := StreamInfo{
+		Codec:  stream.CodecName,
+		Width:  stream.Width,
+		Height: stream.Height,
+		Fps:    parseFrameRate(stream.AvgFrameRate),
+	}
+	if info.Fps == 0 {
+		info.Fps = parseFrameRate(stream.RFrameRate)
+	}
+	return info, nil
+}
+
+// 25/1 这种格式的帧率
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !ok {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+/*
+*
+* 用 ffmpeg 拉流并按照 fps 抽帧, 输出连续的 JPEG 到标准输出
+*
+ */
+func OpenFfmpegStream(ctx context.Context, url string, fps float64) (FrameReader, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, err
+	}
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	if strings.HasPrefix(url, "rtsp://") {
+		args = append(args, "-rtsp_transport", "tcp")
+	}
+	args = append(args, "-i", url, "-an",
+		"-vf", "fps="+strconv.FormatFloat(fps, 'f', -1, 64),
+		"-q:v", "5", "-f", "mjpeg", "pipe:1")
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return NewJpegStreamReader(&processReader{ReadCloser: stdout, cmd: cmd}), nil
+}
+
+// 关闭的时候结束 ffmpeg 进程
+type processReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (p *processReader) Close() error {
+	if p.cmd.Process != nil {
+		p.cmd.Process.Kill()
+	}
+	p.ReadCloser.Close()
+	return p.cmd.Wait()
+}
@@ -0,0 +1,238 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package camera
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 单帧最大 16MB, 防止异常的流把内存撑爆
+const maxFrameSize = 16 << 20
+
+var ErrFrameTooLarge = errors.New("camera: frame too large")
+
+// 一帧 JPEG
+type Frame struct {
+	Data []byte
+	Time time.Time
+}
+
+// JPEG 的宽高
+func (f Frame) Size() (int, int, error) {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(f.Data))
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
+
+type FrameReader interface {
+	ReadFrame() (Frame, error)
+	Close() error
+}
+
+/*
+*
+* multipart/x-mixed-replace 的 MJPEG 流, 每个 part 是一帧 JPEG
+*
+ */
+type multipartReader struct {
+	body   io.Closer
+	reader *multipart.Reader
+}
+
+func NewMultipartReader(body io.ReadCloser, boundary string) FrameReader {
+	// 部分摄像头在 Content-Type 里面的 boundary 带了前缀 --
+	boundary = strings.TrimPrefix(boundary, "--")
+	return &multipartReader{body: body, reader: multipart.NewReader(body, boundary)}
+}
+
+func (r *multipartReader) ReadFrame() (Frame, error) {
+	for {
+		part, err := r.reader.NextPart()
+		if err != nil {
+			return Frame{}, err
+		}
+		data, err := io.ReadAll(io.LimitReader(part, maxFrameSize+1))
+		part.Close()
+		if err != nil {
+			return Frame{}, err
+		}
+		if len(data) > maxFrameSize {
+			return Frame{}, ErrFrameTooLarge
+		}
+		if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+			continue
+		}
+		return Frame{Data: data, Time: time.Now()}, nil
+	}
+}
+
+func (r *multipartReader) Close() error {
+	return r.body.Close()
+}
+
+/*
+*
+* 连续的 JPEG 字节流, 比如 ffmpeg -f mjpeg 的输出, 按照 SOI(FFD8) 和 EOI(FFD9) 切帧
+*
+ */
+type jpegStreamReader struct {
+	closer io.Closer
+	reader *bufio.Reader
+}
+
+func NewJpegStreamReader(r io.ReadCloser) FrameReader {
+	return &jpegStreamReader{closer: r, reader: bufio.NewReaderSize(r, 64*1024)}
+}
+
+func (r *jpegStreamReader) ReadFrame() (Frame, error) {
+	// 找 SOI
+	var prev byte
+	for {
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			return Frame{}, err
+		}
+		if prev == 0xFF && b == 0xD8 {
+			break
+		}
+		prev = b
+	}
+	data := []byte{0xFF, 0xD8}
+	prev = 0
+	for {
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			return Frame{}, err
+		}
+		data = append(data, b)
+		if prev == 0xFF && b == 0xD9 {
+			return Frame{Data: data, Time: time.Now()}, nil
+		}
+		if len(data) > maxFrameSize {
+			return Frame{}, ErrFrameTooLarge
+		}
+		prev = b
+	}
+}
+
+func (r *jpegStreamReader) Close() error {
+	return r.closer.Close()
+}
+
+/*
+*
+* 按照固定周期请求 HTTP 抓图地址, 每次返回一帧
+*
+ */
+type httpJpegReader struct {
+	ctx      context.Context
+	client   *http.Client
+	url      string
+	interval time.Duration
+	last     time.Time
+}
+
+func NewHttpJpegReader(ctx context.Context, url string, interval, timeout time.Duration) FrameReader {
+	return &httpJpegReader{
+		ctx:      ctx,
+		client:   &http.Client{Timeout: timeout},
+		url:      url,
+		interval: interval,
+	}
+}
+
+func (r *httpJpegReader) ReadFrame() (Frame, error) {
+	if wait := r.interval - time.Since(r.last); !r.last.IsZero() && wait > 0 {
+		select {
+		case <-r.ctx.Done():
+			return Frame{}, r.ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	r.last = time.Now()
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return Frame{}, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return Frame{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Frame{}, fmt.Errorf("camera: http status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFrameSize+1))
+	if err != nil {
+		return Frame{}, err
+	}
+	if len(data) > maxFrameSize {
+		return Frame{}, ErrFrameTooLarge
+	}
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return Frame{}, errors.New("camera: response is not a jpeg image")
+	}
+	return Frame{Data: data, Time: time.Now()}, nil
+}
+
+func (r *httpJpegReader) Close() error {
+	r.client.CloseIdleConnections()
+	return nil
+}
+
+/*
+*
+* 打开 HTTP MJPEG 流; 返回的 Content-Type 必须是 multipart
+*
+ */
+func OpenMjpegStream(ctx context.Context, url string, timeout time.Duration) (FrameReader, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// 流不能设置整体超时, 只限制建立连接和响应头的时间
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: timeout,
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("camera: http status %d", resp.StatusCode)
+	}
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		resp.Body.Close()
+		return nil, fmt.Errorf("camera: not a mjpeg stream, content-type=%s", resp.Header.Get("Content-Type"))
+	}
+	return NewMultipartReader(resp.Body, params["boundary"]), nil
+}
@@ -0,0 +1,133 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package camera
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 保存下来的截图
+type Snapshot struct {
+	File string    `json:"file"`
+	Size int64     `json:"size"`
+	Time time.Time `json:"time"`
+}
+
+/*
+*
+* 截图存储: 文件名为毫秒时间戳, 超过数量、总大小或者保存时间以后删除最旧的
+*
+ */
+type Store struct {
+	dir      string
+	maxCount int
+	maxBytes int64
+	maxAge   time.Duration
+	locker   sync.Mutex
+}
+
+// 限制为 0 表示不限制
+func NewStore(dir string, maxCount int, maxBytes int64, maxAge time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, maxCount: maxCount, maxBytes: maxBytes, maxAge: maxAge}, nil
+}
+
+func (s *Store) Save(frame Frame) (Snapshot, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	name := fmt.Sprintf("%d.jpg", frame.Time.UnixMilli())
+	path := filepath.Join(s.dir, name)
+	if err := os.WriteFile(path, frame.Data, 0644); err != nil {
+		return Snapshot{}, err
+	}
+	if err := s.prune(); err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{File: path, Size: int64(len(frame.Data)), Time: frame.Time}, nil
+}
+
+// 按照时间从新到旧
+func (s *Store) List() ([]Snapshot, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.list()
+}
+
+func (s *Store) list() ([]Snapshot, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := []Snapshot{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".jpg") {
+			continue
+		}
+		ms, err := strconv.ParseInt(strings.TrimSuffix(name, ".jpg"), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{
+			File: filepath.Join(s.dir, name),
+			Size: info.Size(),
+			Time: time.UnixMilli(ms),
+		})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+func (s *Store) Prune() error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.prune()
+}
+
+func (s *Store) prune() error {
+	snapshots, err := s.list()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for i, snapshot := range snapshots {
+		total += snapshot.Size
+		expired := (s.maxCount > 0 && i >= s.maxCount) ||
+			(s.maxBytes > 0 && total > s.maxBytes && i > 0) ||
+			(s.maxAge > 0 && time.Since(snapshot.Time) > s.maxAge)
+		if expired {
+			if err := os.Remove(snapshot.File); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -15,7 +15,8 @@
 
 package dmodbus
 
- 
+import "github.com/hootrhino/rhilex/component/deadband"
+
 const (
 	READ_COIL                        = 1  //  Read Coil
 	READ_DISCRETE_INPUT              = 2  //  Read Discrete Input
@@ -45,6 +46,8 @@ type ModbusRegister struct {
 	DataOrder string  `json:"dataOrder"`                                     // 运行时数据
 	Weight    float64 `json:"weight"`
 	Value     string  `json:"value,omitempty"` // 运行时数据. Type, Order不同值也不同
+
+	deadband.ReportPolicy // 上报策略
 }
 
 type RegisterList []*ModbusRegister
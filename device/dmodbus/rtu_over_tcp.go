@@ -0,0 +1,221 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dmodbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	modbus "github.com/hootrhino/gomodbus"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+const (
+	rtuMinSize       = 4
+	rtuMaxSize       = 256
+	rtuExceptionSize = 5
+)
+
+/*
+*
+* RTU over TCP: 串口服务器透传模式, TCP 连接上跑的是原始的 RTU 帧(地址+PDU+CRC),
+* 没有 MBAP 头, 所以只能按照功能码推算响应长度
+*
+ */
+type RTUOverTCPTransporter struct {
+	Address string
+	Timeout time.Duration
+	locker  sync.Mutex
+	conn    net.Conn
+}
+
+func NewRTUOverTCPTransporter(address string, timeout time.Duration) *RTUOverTCPTransporter {
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+	return &RTUOverTCPTransporter{Address: address, Timeout: timeout}
+}
+
+func (mb *RTUOverTCPTransporter) Connect() error {
+	mb.locker.Lock()
+	defer mb.locker.Unlock()
+	return mb.connect()
+}
+
+func (mb *RTUOverTCPTransporter) connect() error {
+	if mb.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", mb.Address, mb.Timeout)
+	if err != nil {
+		return err
+	}
+	mb.conn = conn
+	return nil
+}
+
+func (mb *RTUOverTCPTransporter) Close() error {
+	mb.locker.Lock()
+	defer mb.locker.Unlock()
+	return mb.close()
+}
+
+func (mb *RTUOverTCPTransporter) close() error {
+	if mb.conn == nil {
+		return nil
+	}
+	err := mb.conn.Close()
+	mb.conn = nil
+	return err
+}
+
+/*
+*
+* 发送一帧然后等响应, 出错的时候断开连接, 下次发送的时候重连
+*
+ */
+func (mb *RTUOverTCPTransporter) Send(aduRequest []byte) ([]byte, error) {
+	if len(aduRequest) < rtuMinSize {
+		return nil, fmt.Errorf("modbus: request length '%v' must be at least '%v'",
+			len(aduRequest), rtuMinSize)
+	}
+	mb.locker.Lock()
+	defer mb.locker.Unlock()
+	if err := mb.connect(); err != nil {
+		return nil, err
+	}
+	mb.discard()
+	if err := mb.conn.SetDeadline(time.Now().Add(mb.Timeout)); err != nil {
+		mb.close()
+		return nil, err
+	}
+	if _, err := mb.conn.Write(aduRequest); err != nil {
+		mb.close()
+		return nil, err
+	}
+	aduResponse, err := ReadRTUResponse(mb.conn, aduRequest[1])
+	if err != nil {
+		mb.close()
+		return nil, err
+	}
+	return aduResponse, nil
+}
+
+// 上一次超时之后迟到的响应还留在缓冲区里面, 发送之前先丢掉, 不然会错位
+func (mb *RTUOverTCPTransporter) discard() {
+	var buffer [rtuMaxSize]byte
+	for {
+		mb.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+		n, err := mb.conn.Read(buffer[:])
+		if err != nil || n == 0 {
+			return
+		}
+	}
+}
+
+/*
+*
+* 按照功能码读取一帧 RTU 响应: 异常响应固定5字节, 读类的功能码第3个字节是后面的数据长度,
+* 写类的功能码是固定长度
+*
+ */
+func ReadRTUResponse(r io.Reader, function byte) ([]byte, error) {
+	data := make([]byte, rtuMaxSize)
+	if _, err := io.ReadFull(r, data[:3]); err != nil {
+		return nil, err
+	}
+	length := 0
+	switch {
+	case data[1] == function|0x80:
+		length = rtuExceptionSize
+	case data[1] != function:
+		return nil, fmt.Errorf("modbus: response function '%v' does not match request '%v'",
+			data[1], function)
+	case function == READ_COIL || function == READ_DISCRETE_INPUT ||
+		function == READ_HOLDING_REGISTERS || function == READ_INPUT_REGISTERS ||
+		function == 0x17:
+		length = 3 + int(data[2]) + 2
+	case function == WRITE_SINGLE_COIL || function == WRITE_SINGLE_HOLDING_REGISTER ||
+		function == WRITE_MULTIPLE_COILS || function == WRITE_MULTIPLE_HOLDING_REGISTERS:
+		length = 8
+	case function == 0x16:
+		length = 10
+	default:
+		return nil, fmt.Errorf("modbus: function '%v' is not supported over RTU", function)
+	}
+	if length > rtuMaxSize {
+		return nil, fmt.Errorf("modbus: response length '%v' exceeds '%v'", length, rtuMaxSize)
+	}
+	if _, err := io.ReadFull(r, data[3:length]); err != nil {
+		return nil, err
+	}
+	return data[:length], nil
+}
+
+/*
+*
+* 给传输层加一把锁, 轮询和网关转发共用一条总线的时候保证一问一答不会交叉
+*
+ */
+type LockedTransporter struct {
+	locker      sync.Mutex
+	transporter modbus.Transporter
+}
+
+func NewLockedTransporter(transporter modbus.Transporter) *LockedTransporter {
+	return &LockedTransporter{transporter: transporter}
+}
+
+func (t *LockedTransporter) Send(aduRequest []byte) ([]byte, error) {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	return t.transporter.Send(aduRequest)
+}
+
+func (t *LockedTransporter) Close() error {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	return t.transporter.Close()
+}
+
+// 组 RTU 帧: 从机地址 + PDU + CRC(低字节在前)
+func EncodeRTUFrame(slaverId byte, pdu []byte) []byte {
+	adu := make([]byte, 0, len(pdu)+3)
+	adu = append(adu, slaverId)
+	adu = append(adu, pdu...)
+	return binary.LittleEndian.AppendUint16(adu, utils.CRC16(adu))
+}
+
+// 拆 RTU 帧, 校验从机地址和 CRC 之后返回 PDU
+func DecodeRTUFrame(slaverId byte, adu []byte) ([]byte, error) {
+	if len(adu) < rtuMinSize {
+		return nil, fmt.Errorf("modbus: response length '%v' must be at least '%v'",
+			len(adu), rtuMinSize)
+	}
+	if adu[0] != slaverId {
+		return nil, fmt.Errorf("modbus: response slave id '%v' does not match request '%v'",
+			adu[0], slaverId)
+	}
+	crc := binary.LittleEndian.Uint16(adu[len(adu)-2:])
+	if expect := utils.CRC16(adu[:len(adu)-2]); crc != expect {
+		return nil, fmt.Errorf("modbus: response crc '%x' does not match expected '%x'", crc, expect)
+	}
+	return adu[1 : len(adu)-2], nil
+}
@@ -0,0 +1,108 @@
+package dmodbus
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_RTUFrame_EncodeDecode(t *testing.T) {
+	// 01 03 00 00 00 01 84 0A
+	adu := EncodeRTUFrame(1, []byte{0x03, 0x00, 0x00, 0x00, 0x01})
+	if !bytes.Equal(adu, []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0A}) {
+		t.Fatalf("unexpected adu: % x", adu)
+	}
+	pdu, err := DecodeRTUFrame(1, adu)
+	if err != nil || !bytes.Equal(pdu, []byte{0x03, 0x00, 0x00, 0x00, 0x01}) {
+		t.Fatalf("unexpected pdu: % x %v", pdu, err)
+	}
+	if _, err := DecodeRTUFrame(2, adu); err == nil {
+		t.Fatal("slave id mismatch must be rejected")
+	}
+	adu[len(adu)-1] ^= 0xFF
+	if _, err := DecodeRTUFrame(1, adu); err == nil {
+		t.Fatal("crc mismatch must be rejected")
+	}
+}
+
+// 模拟一个串口服务器, 响应分两次写, 前面还有一段上次超时留下的垃圾数据
+func Test_RTUOverTCPTransporter_Send(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte{0xFF, 0xFF})
+		request := make([]byte, 8)
+		for i := 0; i < 2; i++ {
+			if _, err := conn.Read(request); err != nil {
+				return
+			}
+			if request[1] == 0x03 {
+				response := EncodeRTUFrame(request[0], []byte{0x03, 0x02, 0x12, 0x34})
+				conn.Write(response[:3])
+				time.Sleep(20 * time.Millisecond)
+				conn.Write(response[3:])
+			} else {
+				conn.Write(EncodeRTUFrame(request[0], ExceptionPDU(request[1], 0x01)))
+			}
+		}
+	}()
+	transporter := NewRTUOverTCPTransporter(listener.Addr().String(), time.Second)
+	if err := transporter.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer transporter.Close()
+	time.Sleep(20 * time.Millisecond)
+	locked := NewLockedTransporter(transporter)
+	response, err := locked.Send(EncodeRTUFrame(3, []byte{0x03, 0x00, 0x10, 0x00, 0x01}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu, err := DecodeRTUFrame(3, response)
+	if err != nil || !bytes.Equal(pdu, []byte{0x03, 0x02, 0x12, 0x34}) {
+		t.Fatalf("unexpected response: % x %v", response, err)
+	}
+	response, err = locked.Send(EncodeRTUFrame(3, []byte{0x06, 0x00, 0x10, 0x00, 0x01}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdu, _ := DecodeRTUFrame(3, response); !bytes.Equal(pdu, []byte{0x86, 0x01}) {
+		t.Fatalf("unexpected exception response: % x", response)
+	}
+}
+
+func Test_ServeTCPGatewayConn(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	go ServeTCPGatewayConn(server, 0, func(unitId byte, pdu []byte) []byte {
+		if unitId != 5 {
+			return ExceptionPDU(pdu[0], EXCEPTION_GATEWAY_PATH_UNAVAILABLE)
+		}
+		return []byte{0x03, 0x02, 0x00, 0x2A}
+	})
+	request := EncodeMBAPFrame(MBAPHeader{TransactionId: 0x0102, UnitId: 5},
+		[]byte{0x03, 0x00, 0x00, 0x00, 0x01})
+	client.Write(request)
+	header, pdu, err := ReadMBAPFrame(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.TransactionId != 0x0102 || header.UnitId != 5 ||
+		!bytes.Equal(pdu, []byte{0x03, 0x02, 0x00, 0x2A}) {
+		t.Fatalf("unexpected response: %+v % x", header, pdu)
+	}
+	client.Write(EncodeMBAPFrame(MBAPHeader{TransactionId: 7, UnitId: 9},
+		[]byte{0x04, 0x00, 0x00, 0x00, 0x01}))
+	_, pdu, err = ReadMBAPFrame(client)
+	if err != nil || !bytes.Equal(pdu, []byte{0x84, 0x0A}) {
+		t.Fatalf("unexpected exception response: % x %v", pdu, err)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dmodbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	mbapHeaderSize = 7
+	mbapMaxLength  = 254 // 单元标识符 + PDU(最大253字节)
+)
+
+// 网关相关的异常码
+const (
+	EXCEPTION_GATEWAY_PATH_UNAVAILABLE byte = 0x0A // 网关路径不可用
+	EXCEPTION_GATEWAY_TARGET_FAILED    byte = 0x0B // 网关目标设备无响应
+)
+
+// MBAP 报文头
+type MBAPHeader struct {
+	TransactionId uint16
+	ProtocolId    uint16
+	Length        uint16
+	UnitId        byte
+}
+
+/*
+*
+* 读取一帧 Modbus TCP 请求, 返回报文头和 PDU
+*
+ */
+func ReadMBAPFrame(r io.Reader) (MBAPHeader, []byte, error) {
+	var buffer [mbapHeaderSize]byte
+	header := MBAPHeader{}
+	if _, err := io.ReadFull(r, buffer[:]); err != nil {
+		return header, nil, err
+	}
+	header.TransactionId = binary.BigEndian.Uint16(buffer[0:2])
+	header.ProtocolId = binary.BigEndian.Uint16(buffer[2:4])
+	header.Length = binary.BigEndian.Uint16(buffer[4:6])
+	header.UnitId = buffer[6]
+	if header.ProtocolId != 0 {
+		return header, nil, fmt.Errorf("modbus: invalid protocol id '%v'", header.ProtocolId)
+	}
+	if header.Length < 2 || header.Length > mbapMaxLength {
+		return header, nil, fmt.Errorf("modbus: invalid mbap length '%v'", header.Length)
+	}
+	pdu := make([]byte, header.Length-1)
+	if _, err := io.ReadFull(r, pdu); err != nil {
+		return header, nil, err
+	}
+	return header, pdu, nil
+}
+
+// 组 Modbus TCP 响应, 长度按照 PDU 重新计算
+func EncodeMBAPFrame(header MBAPHeader, pdu []byte) []byte {
+	adu := make([]byte, mbapHeaderSize, mbapHeaderSize+len(pdu))
+	binary.BigEndian.PutUint16(adu[0:2], header.TransactionId)
+	binary.BigEndian.PutUint16(adu[2:4], 0)
+	binary.BigEndian.PutUint16(adu[4:6], uint16(len(pdu)+1))
+	adu[6] = header.UnitId
+	return append(adu, pdu...)
+}
+
+// 异常响应 PDU
+func ExceptionPDU(function byte, code byte) []byte {
+	return []byte{function | 0x80, code}
+}
+
+/*
+*
+* 处理一个 Modbus TCP 客户端连接, 每个请求交给 handler 转发, 一问一答;
+* idle 时间内没有请求就断开, 0 表示不限制
+*
+ */
+func ServeTCPGatewayConn(conn net.Conn, idle time.Duration,
+	handler func(unitId byte, pdu []byte) []byte) error {
+	defer conn.Close()
+	for {
+		if idle > 0 {
+			conn.SetReadDeadline(time.Now().Add(idle))
+		}
+		header, pdu, err := ReadMBAPFrame(conn)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		response := handler(header.UnitId, pdu)
+		if len(response) == 0 {
+			continue
+		}
+		if _, err := conn.Write(EncodeMBAPFrame(header, response)); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dnp3
+
+import (
+	"fmt"
+)
+
+// 应用层功能码
+const (
+	FC_CONFIRM              byte = 0
+	FC_READ                 byte = 1
+	FC_WRITE                byte = 2
+	FC_SELECT               byte = 3
+	FC_OPERATE              byte = 4
+	FC_DIRECT_OPERATE       byte = 5
+	FC_DIRECT_OPERATE_NR    byte = 6
+	FC_ENABLE_UNSOLICITED   byte = 20
+	FC_DISABLE_UNSOLICITED  byte = 21
+	FC_DELAY_MEASURE        byte = 23
+	FC_RESPONSE             byte = 129
+	FC_UNSOLICITED_RESPONSE byte = 130
+)
+
+// 应用层控制字
+const (
+	appFir byte = 0x80
+	appFin byte = 0x40
+	appCon byte = 0x20
+	appUns byte = 0x10
+)
+
+// 内部指示 IIN, 低字节是 IIN1, 高字节是 IIN2
+const (
+	IIN_BROADCAST             uint16 = 0x0001
+	IIN_CLASS1_EVENTS         uint16 = 0x0002
+	IIN_CLASS2_EVENTS         uint16 = 0x0004
+	IIN_CLASS3_EVENTS         uint16 = 0x0008
+	IIN_NEED_TIME             uint16 = 0x0010
+	IIN_LOCAL_CONTROL         uint16 = 0x0020
+	IIN_DEVICE_TROUBLE        uint16 = 0x0040
+	IIN_DEVICE_RESTART        uint16 = 0x0080
+	IIN_NO_FUNC_CODE_SUPPORT  uint16 = 0x0100
+	IIN_OBJECT_UNKNOWN        uint16 = 0x0200
+	IIN_PARAMETER_ERROR       uint16 = 0x0400
+	IIN_EVENT_BUFFER_OVERFLOW uint16 = 0x0800
+	IIN_ALREADY_EXECUTING     uint16 = 0x1000
+	IIN_CONFIG_CORRUPT        uint16 = 0x2000
+)
+
+/*
+*
+* 应用层分片: 请求没有 IIN, 响应和主动上送带 IIN
+*
+ */
+type Apdu struct {
+	Control  byte
+	Function byte
+	IIN      uint16
+	Objects  []byte
+}
+
+func newApdu(function, seq byte, con, uns bool, objects []byte) Apdu {
+	control := appFir | appFin | (seq & 0x0F)
+	if con {
+		control |= appCon
+	}
+	if uns {
+		control |= appUns
+	}
+	return Apdu{Control: control, Function: function, Objects: objects}
+}
+
+func (a Apdu) Seq() byte {
+	return a.Control & 0x0F
+}
+
+func (a Apdu) Con() bool {
+	return a.Control&appCon != 0
+}
+
+func (a Apdu) Uns() bool {
+	return a.Control&appUns != 0
+}
+
+func (a Apdu) Fir() bool {
+	return a.Control&appFir != 0
+}
+
+func (a Apdu) Fin() bool {
+	return a.Control&appFin != 0
+}
+
+func (a Apdu) isResponse() bool {
+	return a.Function == FC_RESPONSE || a.Function == FC_UNSOLICITED_RESPONSE
+}
+
+func (a Apdu) Encode() []byte {
+	buf := make([]byte, 0, 4+len(a.Objects))
+	buf = append(buf, a.Control, a.Function)
+	if a.isResponse() {
+		buf = append(buf, byte(a.IIN), byte(a.IIN>>8))
+	}
+	return append(buf, a.Objects...)
+}
+
+func ParseApdu(b []byte) (Apdu, error) {
+	if len(b) < 2 {
+		return Apdu{}, fmt.Errorf("invalid dnp3 apdu length: %d", len(b))
+	}
+	a := Apdu{Control: b[0], Function: b[1]}
+	if a.isResponse() {
+		if len(b) < 4 {
+			return Apdu{}, fmt.Errorf("invalid dnp3 response length: %d", len(b))
+		}
+		a.IIN = uint16(b[2]) | uint16(b[3])<<8
+		a.Objects = b[4:]
+		return a, nil
+	}
+	a.Objects = b[2:]
+	return a, nil
+}
+
+// 功能码名字, 日志用
+func FunctionName(function byte) string {
+	switch function {
+	case FC_CONFIRM:
+		return "CONFIRM"
+	case FC_READ:
+		return "READ"
+	case FC_WRITE:
+		return "WRITE"
+	case FC_SELECT:
+		return "SELECT"
+	case FC_OPERATE:
+		return "OPERATE"
+	case FC_DIRECT_OPERATE:
+		return "DIRECT_OPERATE"
+	case FC_DIRECT_OPERATE_NR:
+		return "DIRECT_OPERATE_NR"
+	case FC_ENABLE_UNSOLICITED:
+		return "ENABLE_UNSOLICITED"
+	case FC_DISABLE_UNSOLICITED:
+		return "DISABLE_UNSOLICITED"
+	case FC_DELAY_MEASURE:
+		return "DELAY_MEASURE"
+	case FC_RESPONSE:
+		return "RESPONSE"
+	case FC_UNSOLICITED_RESPONSE:
+		return "UNSOLICITED_RESPONSE"
+	}
+	return fmt.Sprintf("FC_%d", function)
+}
@@ -0,0 +1,168 @@
+package dnp3
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Dnp3_CrcAndLinkFrame(t *testing.T) {
+	if crc := Crc([]byte("123456789")); crc != 0xEA82 {
+		t.Fatalf("unexpected crc: 0x%04X", crc)
+	}
+	data := make([]byte, 40)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	frame := linkFrame{control: 0xC4, dest: 10, src: 1, data: data}
+	encoded := frame.encode()
+	// 10 字节头, 40 字节数据分成 3 块, 每块 2 字节 CRC
+	if len(encoded) != 10+40+6 {
+		t.Fatalf("unexpected frame length: %d", len(encoded))
+	}
+	decoded, err := readLinkFrame(bufio.NewReader(bytes.NewReader(append([]byte{0xFF, 0x05}, encoded...))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.control != 0xC4 || decoded.dest != 10 || decoded.src != 1 || !bytes.Equal(decoded.data, data) {
+		t.Fatalf("unexpected frame: %+v", decoded)
+	}
+	encoded[12] ^= 0xFF
+	if _, err := readLinkFrame(bufio.NewReader(bytes.NewReader(encoded))); err != errFrameCrc {
+		t.Fatalf("crc error expected, got %v", err)
+	}
+}
+
+type pointRecorder struct {
+	locker      sync.Mutex
+	points      map[PointType]map[uint16]Point
+	unsolicited chan Point
+}
+
+func (r *pointRecorder) handle(points []Point, unsolicited bool) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	for _, p := range points {
+		if r.points[p.Type] == nil {
+			r.points[p.Type] = map[uint16]Point{}
+		}
+		r.points[p.Type][p.Index] = p
+		if unsolicited {
+			r.unsolicited <- p
+		}
+	}
+}
+
+func (r *pointRecorder) get(t PointType, index uint16) (Point, bool) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	p, ok := r.points[t][index]
+	return p, ok
+}
+
+func Test_Dnp3_MasterOutstation(t *testing.T) {
+	commands := make(chan Command, 4)
+	outstation := NewOutstation(OutstationConfig{Local: 10, Remote: 1, Unsolicited: true},
+		func(cmd Command) byte {
+			commands <- cmd
+			return STATUS_SUCCESS
+		})
+	for _, p := range []struct {
+		t     PointType
+		index uint16
+		class byte
+	}{
+		{BINARY_INPUT, 0, 1}, {BINARY_INPUT, 1, 1}, {ANALOG_INPUT, 5, 2},
+		{COUNTER, 0, 3}, {BINARY_OUTPUT, 3, 0}, {ANALOG_OUTPUT, 7, 0},
+	} {
+		if err := outstation.AddPoint(p.t, p.index, p.class, 0.5); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outstation.Update(BINARY_INPUT, 1, 1, FLAG_ONLINE, time.Time{})
+	outstation.Update(ANALOG_INPUT, 5, 21.5, FLAG_ONLINE, time.Time{})
+	outstation.Update(COUNTER, 0, 1024, FLAG_ONLINE, time.Time{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	masterConn, outstationConn := net.Pipe()
+	defer outstationConn.Close()
+	go outstation.Serve(ctx, outstationConn)
+	recorder := &pointRecorder{points: map[PointType]map[uint16]Point{}, unsolicited: make(chan Point, 16)}
+	master := NewMaster(masterConn, 1, 10, 2*time.Second, recorder.handle)
+	master.Run()
+	defer master.Close()
+
+	if err := master.IntegrityPoll(); err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := recorder.get(BINARY_INPUT, 1); !ok || p.Value != 1 || !p.Online() {
+		t.Fatalf("unexpected binary input: %+v", p)
+	}
+	if p, ok := recorder.get(BINARY_INPUT, 0); !ok || p.Online() {
+		t.Fatalf("binary input 0 must be restarting: %+v", p)
+	}
+	if p, ok := recorder.get(ANALOG_INPUT, 5); !ok || p.Value != 21.5 {
+		t.Fatalf("unexpected analog input: %+v", p)
+	}
+	if p, ok := recorder.get(COUNTER, 0); !ok || p.Value != 1024 {
+		t.Fatalf("unexpected counter: %+v", p)
+	}
+	if master.IIN()&IIN_DEVICE_RESTART != 0 || outstation.iin()&IIN_DEVICE_RESTART != 0 {
+		t.Fatal("restart flag must be cleared after integrity poll")
+	}
+
+	// 死区以内不产生事件
+	outstation.Update(ANALOG_INPUT, 5, 21.8, FLAG_ONLINE, time.Time{})
+	if outstation.iin()&IIN_CLASS2_EVENTS != 0 {
+		t.Fatal("change inside deadband must not generate event")
+	}
+	outstation.Update(ANALOG_INPUT, 5, 30, FLAG_ONLINE, time.Time{})
+	if err := master.EventPoll(2); err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := recorder.get(ANALOG_INPUT, 5); !ok || p.Value != 30 || !p.Event || p.Time.IsZero() {
+		t.Fatalf("unexpected analog event: %+v", p)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for outstation.iin()&IIN_CLASS2_EVENTS != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if outstation.iin()&IIN_CLASS2_EVENTS != 0 {
+		t.Fatal("event must be removed after confirm")
+	}
+
+	if err := master.EnableUnsolicited(1); err != nil {
+		t.Fatal(err)
+	}
+	outstation.Update(BINARY_INPUT, 0, 1, FLAG_ONLINE, time.Time{})
+	outstation.Update(BINARY_INPUT, 0, 0, FLAG_ONLINE, time.Time{})
+	select {
+	case p := <-recorder.unsolicited:
+		if p.Type != BINARY_INPUT || p.Index != 0 || p.Value != 0 || !p.Event {
+			t.Fatalf("unexpected unsolicited point: %+v", p)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("unsolicited event timeout")
+	}
+
+	if err := master.Crob(3, Crob{Code: CROB_LATCH_ON, Count: 1}, true); err != nil {
+		t.Fatal(err)
+	}
+	if cmd := <-commands; cmd.Type != BINARY_OUTPUT || cmd.Index != 3 || cmd.Value != 1 {
+		t.Fatalf("unexpected command: %+v", cmd)
+	}
+	if err := master.AnalogOutput(7, 3, 12.5, false); err != nil {
+		t.Fatal(err)
+	}
+	if cmd := <-commands; cmd.Type != ANALOG_OUTPUT || cmd.Index != 7 || cmd.Value != 12.5 {
+		t.Fatalf("unexpected command: %+v", cmd)
+	}
+	if err := master.Crob(9, Crob{Code: CROB_LATCH_OFF, Count: 1}, false); err == nil {
+		t.Fatal("command on unknown point must fail")
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dnp3
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	serial "github.com/hootrhino/goserial"
+)
+
+// 链路层起始字
+const (
+	start1 byte = 0x05
+	start2 byte = 0x64
+)
+
+// 链路层控制字
+const (
+	linkDir byte = 0x80 // 主站发出
+	linkPrm byte = 0x40 // 启动站
+	linkFcb byte = 0x20
+	linkFcv byte = 0x10
+)
+
+// 链路层功能码
+const (
+	linkResetLinkStates     byte = 0  // 启动站: 复位链路
+	linkTestLinkStates      byte = 2  // 启动站: 测试链路
+	linkConfirmedUserData   byte = 3  // 启动站: 需要确认的用户数据
+	linkUnconfirmedUserData byte = 4  // 启动站: 不需要确认的用户数据
+	linkRequestLinkStatus   byte = 9  // 启动站: 请求链路状态
+	linkAck                 byte = 0  // 从动站: 确认
+	linkStatus              byte = 11 // 从动站: 链路状态
+	linkNotSupported        byte = 15 // 从动站: 不支持
+)
+
+// 传输层
+const (
+	transportFin     byte = 0x80
+	transportFir     byte = 0x40
+	maxSegmentData        = 249 // 每个链路帧最多 250 字节用户数据, 去掉 1 字节传输层头
+	maxLinkUserData       = 250
+	maxFragmentBytes      = 2048 // 应用层分片的最大长度
+)
+
+var errFrameCrc = errors.New("dnp3 frame crc error")
+
+/*
+*
+* DNP3 的 CRC, 多项式 0x3D65, 反射输入输出, 结果取反
+*
+ */
+func Crc(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ 0xA6BC
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
+
+// 链路帧
+type linkFrame struct {
+	control byte
+	dest    uint16
+	src     uint16
+	data    []byte
+}
+
+func (f linkFrame) function() byte {
+	return f.control & 0x0F
+}
+
+/*
+*
+* 编码链路帧: 10 字节头(带 CRC), 用户数据每 16 字节带一个 CRC
+*
+ */
+func (f linkFrame) encode() []byte {
+	buf := make([]byte, 0, 10+len(f.data)+2*(len(f.data)/16+1))
+	buf = append(buf, start1, start2, byte(5+len(f.data)), f.control)
+	buf = binary.LittleEndian.AppendUint16(buf, f.dest)
+	buf = binary.LittleEndian.AppendUint16(buf, f.src)
+	buf = binary.LittleEndian.AppendUint16(buf, Crc(buf))
+	for i := 0; i < len(f.data); i += 16 {
+		end := min(i+16, len(f.data))
+		buf = append(buf, f.data[i:end]...)
+		buf = binary.LittleEndian.AppendUint16(buf, Crc(f.data[i:end]))
+	}
+	return buf
+}
+
+// 读一个链路帧, 找不到起始字的时候丢掉前面的数据
+func readLinkFrame(r *bufio.Reader) (linkFrame, error) {
+	frame := linkFrame{}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return frame, err
+		}
+		if b != start1 {
+			continue
+		}
+		next, err := r.Peek(1)
+		if err != nil {
+			return frame, err
+		}
+		if next[0] == start2 {
+			break
+		}
+	}
+	header := make([]byte, 10)
+	header[0] = start1
+	if _, err := io.ReadFull(r, header[1:]); err != nil {
+		return frame, err
+	}
+	if binary.LittleEndian.Uint16(header[8:]) != Crc(header[:8]) {
+		return frame, errFrameCrc
+	}
+	length := int(header[2])
+	if length < 5 {
+		return frame, fmt.Errorf("invalid dnp3 frame length: %d", length)
+	}
+	frame.control = header[3]
+	frame.dest = binary.LittleEndian.Uint16(header[4:])
+	frame.src = binary.LittleEndian.Uint16(header[6:])
+	remain := length - 5
+	frame.data = make([]byte, 0, remain)
+	for remain > 0 {
+		n := min(remain, 16)
+		block := make([]byte, n+2)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return frame, err
+		}
+		if binary.LittleEndian.Uint16(block[n:]) != Crc(block[:n]) {
+			return frame, errFrameCrc
+		}
+		frame.data = append(frame.data, block[:n]...)
+		remain -= n
+	}
+	return frame, nil
+}
+
+/*
+*
+* 链路层和传输层: 发送的时候把应用层分片拆成传输段, 接收的时候重组.
+* 链路层的复位、测试和链路状态请求在这里直接回复, 不上送到应用层
+*
+ */
+type Link struct {
+	rw       io.ReadWriter
+	reader   *bufio.Reader
+	master   bool
+	local    uint16
+	remote   uint16
+	wlocker  sync.Mutex
+	sendSeq  byte
+	recvBuf  []byte
+	recvSeq  byte
+	inFrames bool
+}
+
+func NewLink(rw io.ReadWriter, master bool, local, remote uint16) *Link {
+	return &Link{
+		rw:     rw,
+		reader: bufio.NewReader(rw),
+		master: master,
+		local:  local,
+		remote: remote,
+	}
+}
+
+func (l *Link) control(prm bool, function byte) byte {
+	control := function
+	if l.master {
+		control |= linkDir
+	}
+	if prm {
+		control |= linkPrm
+	}
+	return control
+}
+
+func (l *Link) writeFrame(frame linkFrame) error {
+	l.wlocker.Lock()
+	defer l.wlocker.Unlock()
+	_, err := l.rw.Write(frame.encode())
+	return err
+}
+
+/*
+*
+* 发送一个应用层分片, 使用不需要确认的用户数据
+*
+ */
+func (l *Link) WriteFragment(fragment []byte) error {
+	l.wlocker.Lock()
+	defer l.wlocker.Unlock()
+	for i := 0; i == 0 || i < len(fragment); i += maxSegmentData {
+		end := min(i+maxSegmentData, len(fragment))
+		header := l.sendSeq & 0x3F
+		if i == 0 {
+			header |= transportFir
+		}
+		if end == len(fragment) {
+			header |= transportFin
+		}
+		l.sendSeq = (l.sendSeq + 1) & 0x3F
+		frame := linkFrame{
+			control: l.control(true, linkUnconfirmedUserData),
+			dest:    l.remote,
+			src:     l.local,
+			data:    append([]byte{header}, fragment[i:end]...),
+		}
+		if _, err := l.rw.Write(frame.encode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+*
+* 读一个完整的应用层分片; CRC 错误和发给其他站的帧直接丢掉
+*
+ */
+func (l *Link) ReadFragment() ([]byte, error) {
+	for {
+		frame, err := readLinkFrame(l.reader)
+		if err != nil {
+			if errors.Is(err, errFrameCrc) {
+				continue
+			}
+			return nil, err
+		}
+		if frame.dest != l.local {
+			continue
+		}
+		if frame.control&linkPrm == 0 {
+			// 从动站的确认和链路状态, 这里只用不需要确认的数据, 忽略
+			continue
+		}
+		switch frame.function() {
+		case linkResetLinkStates, linkTestLinkStates:
+			if err := l.reply(frame, linkAck); err != nil {
+				return nil, err
+			}
+			continue
+		case linkRequestLinkStatus:
+			if err := l.reply(frame, linkStatus); err != nil {
+				return nil, err
+			}
+			continue
+		case linkConfirmedUserData:
+			if err := l.reply(frame, linkAck); err != nil {
+				return nil, err
+			}
+		case linkUnconfirmedUserData:
+		default:
+			if err := l.reply(frame, linkNotSupported); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if fragment, ok := l.reassemble(frame.data); ok {
+			return fragment, nil
+		}
+	}
+}
+
+func (l *Link) reply(frame linkFrame, function byte) error {
+	return l.writeFrame(linkFrame{
+		control: l.control(false, function),
+		dest:    frame.src,
+		src:     l.local,
+	})
+}
+
+// 传输层重组, 序号不连续的时候丢掉已经收到的段
+func (l *Link) reassemble(segment []byte) ([]byte, bool) {
+	if len(segment) < 1 {
+		return nil, false
+	}
+	header := segment[0]
+	seq := header & 0x3F
+	if header&transportFir != 0 {
+		l.recvBuf = l.recvBuf[:0]
+		l.inFrames = true
+	} else if !l.inFrames || seq != (l.recvSeq+1)&0x3F {
+		l.inFrames = false
+		return nil, false
+	}
+	l.recvSeq = seq
+	l.recvBuf = append(l.recvBuf, segment[1:]...)
+	if len(l.recvBuf) > maxFragmentBytes {
+		l.inFrames = false
+		return nil, false
+	}
+	if header&transportFin == 0 {
+		return nil, false
+	}
+	l.inFrames = false
+	fragment := make([]byte, len(l.recvBuf))
+	copy(fragment, l.recvBuf)
+	return fragment, true
+}
+
+// 串口读超时和网络读超时不算错误
+func IsTimeout(err error) bool {
+	if errors.Is(err, serial.ErrTimeout) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,346 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dnp3
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var errMasterClosed = errors.New("dnp3 master closed")
+
+// 收到测点数据的回调, unsolicited 表示子站主动上送
+type PointHandler func(points []Point, unsolicited bool)
+
+/*
+*
+* DNP3 主站: 一次只发一个请求, 等待响应的全部分片; 主动上送在读协程里面确认并回调
+*
+ */
+type Master struct {
+	link      *Link
+	closer    io.Closer
+	timeout   time.Duration
+	handler   PointHandler
+	locker    sync.Mutex // 同一时间只有一个请求
+	seq       byte
+	responses chan Apdu
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+	iinLocker sync.Mutex
+	iin       uint16
+}
+
+func NewMaster(rwc io.ReadWriteCloser, local, remote uint16, timeout time.Duration, handler PointHandler) *Master {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &Master{
+		link:      NewLink(rwc, true, local, remote),
+		closer:    rwc,
+		timeout:   timeout,
+		handler:   handler,
+		responses: make(chan Apdu, 16),
+		done:      make(chan struct{}),
+	}
+}
+
+// 启动读协程
+func (m *Master) Run() {
+	go m.readLoop()
+}
+
+func (m *Master) readLoop() {
+	for {
+		fragment, err := m.link.ReadFragment()
+		if err != nil {
+			if IsTimeout(err) {
+				select {
+				case <-m.done:
+					return
+				default:
+					continue
+				}
+			}
+			m.close(err)
+			return
+		}
+		apdu, err := ParseApdu(fragment)
+		if err != nil {
+			continue
+		}
+		switch apdu.Function {
+		case FC_UNSOLICITED_RESPONSE:
+			m.setIIN(apdu.IIN)
+			if apdu.Con() {
+				confirm := newApdu(FC_CONFIRM, apdu.Seq(), false, true, nil)
+				if err := m.link.WriteFragment(confirm.Encode()); err != nil {
+					m.close(err)
+					return
+				}
+			}
+			points, _ := ParsePoints(apdu.Objects)
+			if len(points) > 0 && m.handler != nil {
+				m.handler(points, true)
+			}
+		case FC_RESPONSE:
+			select {
+			case m.responses <- apdu:
+			default:
+			}
+		}
+	}
+}
+
+func (m *Master) close(err error) {
+	m.closeOnce.Do(func() {
+		m.err = err
+		close(m.done)
+		m.closer.Close()
+	})
+}
+
+// 连接断开以后关闭
+func (m *Master) Done() <-chan struct{} {
+	return m.done
+}
+
+func (m *Master) Err() error {
+	return m.err
+}
+
+func (m *Master) Close() error {
+	m.close(errMasterClosed)
+	return nil
+}
+
+func (m *Master) setIIN(iin uint16) {
+	m.iinLocker.Lock()
+	m.iin = iin
+	m.iinLocker.Unlock()
+}
+
+// 最近一次收到的内部指示
+func (m *Master) IIN() uint16 {
+	m.iinLocker.Lock()
+	defer m.iinLocker.Unlock()
+	return m.iin
+}
+
+/*
+*
+* 发送请求, 返回响应的全部对象; 需要确认的分片在这里确认
+*
+ */
+func (m *Master) request(function byte, objects []byte) (Apdu, error) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	select {
+	case <-m.done:
+		return Apdu{}, m.err
+	default:
+	}
+	// 丢掉之前超时的请求迟到的响应
+	for len(m.responses) > 0 {
+		<-m.responses
+	}
+	seq := m.seq
+	m.seq = (m.seq + 1) & 0x0F
+	req := newApdu(function, seq, false, false, objects)
+	if err := m.link.WriteFragment(req.Encode()); err != nil {
+		m.close(err)
+		return Apdu{}, err
+	}
+	if function == FC_DIRECT_OPERATE_NR {
+		return Apdu{}, nil
+	}
+	result := Apdu{Function: FC_RESPONSE}
+	expect := seq
+	first := true
+	timer := time.NewTimer(m.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-m.done:
+			return result, m.err
+		case <-timer.C:
+			return result, fmt.Errorf("dnp3 %s timeout", FunctionName(function))
+		case resp := <-m.responses:
+			if resp.Seq() != expect || (first && !resp.Fir()) {
+				continue
+			}
+			first = false
+			expect = (expect + 1) & 0x0F
+			m.setIIN(resp.IIN)
+			result.IIN = resp.IIN
+			result.Objects = append(result.Objects, resp.Objects...)
+			if resp.Con() {
+				confirm := newApdu(FC_CONFIRM, resp.Seq(), false, false, nil)
+				if err := m.link.WriteFragment(confirm.Encode()); err != nil {
+					m.close(err)
+					return result, err
+				}
+			}
+			if resp.Fin() {
+				return result, nil
+			}
+			timer.Reset(m.timeout)
+		}
+	}
+}
+
+// 读请求, 响应里面的测点交给回调
+func (m *Master) read(objects []byte) error {
+	resp, err := m.request(FC_READ, objects)
+	if err != nil {
+		return err
+	}
+	points, err := ParsePoints(resp.Objects)
+	if len(points) > 0 && m.handler != nil {
+		m.handler(points, false)
+	}
+	if err != nil {
+		return err
+	}
+	return m.checkIIN(resp.IIN)
+}
+
+// 子站重启以后清除重启标志, 需要对时的时候对时
+func (m *Master) checkIIN(iin uint16) error {
+	if iin&IIN_DEVICE_RESTART != 0 {
+		if err := m.ClearRestart(); err != nil {
+			return err
+		}
+	}
+	if iin&IIN_NEED_TIME != 0 {
+		if err := m.SyncTime(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+*
+* 总召唤: 先读 1/2/3 类事件, 再读 0 类静态数据
+*
+ */
+func (m *Master) IntegrityPoll() error {
+	return m.read(classObjects(1, 2, 3, 0))
+}
+
+// 事件召唤, 默认读 1/2/3 类
+func (m *Master) EventPoll(classes ...int) error {
+	if len(classes) == 0 {
+		classes = []int{1, 2, 3}
+	}
+	for _, class := range classes {
+		if class < 1 || class > 3 {
+			return fmt.Errorf("invalid event class: %d", class)
+		}
+	}
+	return m.read(classObjects(classes...))
+}
+
+// 允许子站主动上送事件
+func (m *Master) EnableUnsolicited(classes ...int) error {
+	return m.unsolicited(FC_ENABLE_UNSOLICITED, classes)
+}
+
+func (m *Master) DisableUnsolicited(classes ...int) error {
+	return m.unsolicited(FC_DISABLE_UNSOLICITED, classes)
+}
+
+func (m *Master) unsolicited(function byte, classes []int) error {
+	if len(classes) == 0 {
+		classes = []int{1, 2, 3}
+	}
+	resp, err := m.request(function, classObjects(classes...))
+	if err != nil {
+		return err
+	}
+	if resp.IIN&IIN_NO_FUNC_CODE_SUPPORT != 0 {
+		return fmt.Errorf("outstation does not support %s", FunctionName(function))
+	}
+	return nil
+}
+
+// 写 g80v1 第 7 位, 清除子站的重启标志
+func (m *Master) ClearRestart() error {
+	_, err := m.request(FC_WRITE, []byte{80, 1, qualifierRange8, 7, 7, 0})
+	return err
+}
+
+// 写 g50v1 对时
+func (m *Master) SyncTime() error {
+	objects := append([]byte{50, 1, qualifierCount8, 1}, encodeTime(time.Now())...)
+	_, err := m.request(FC_WRITE, objects)
+	return err
+}
+
+/*
+*
+* 遥控: 选择执行或者直接执行 CROB
+*
+ */
+func (m *Master) Crob(index uint16, crob Crob, selectBeforeOperate bool) error {
+	crob.Status = STATUS_SUCCESS
+	return m.operate(commandObject(12, 1, index, crob.encode()), selectBeforeOperate)
+}
+
+/*
+*
+* 设定值: variation 1 为 32 位整数, 2 为 16 位整数, 3 为单精度浮点, 4 为双精度浮点
+*
+ */
+func (m *Master) AnalogOutput(index uint16, variation byte, value float64, selectBeforeOperate bool) error {
+	if variation < 1 || variation > 4 {
+		return fmt.Errorf("invalid analog output variation: %d", variation)
+	}
+	return m.operate(commandObject(41, variation, index, encodeAnalogOutput(variation, value)), selectBeforeOperate)
+}
+
+func (m *Master) operate(objects []byte, selectBeforeOperate bool) error {
+	if selectBeforeOperate {
+		if err := m.command(FC_SELECT, objects); err != nil {
+			return err
+		}
+		return m.command(FC_OPERATE, objects)
+	}
+	return m.command(FC_DIRECT_OPERATE, objects)
+}
+
+func (m *Master) command(function byte, objects []byte) error {
+	resp, err := m.request(function, objects)
+	if err != nil {
+		return err
+	}
+	if resp.IIN&(IIN_NO_FUNC_CODE_SUPPORT|IIN_OBJECT_UNKNOWN|IIN_PARAMETER_ERROR) != 0 {
+		return fmt.Errorf("dnp3 %s rejected, iin=0x%04X", FunctionName(function), resp.IIN)
+	}
+	status, err := commandStatus(resp.Objects)
+	if err != nil {
+		return err
+	}
+	if status != STATUS_SUCCESS {
+		return fmt.Errorf("dnp3 %s failed, status=%d", FunctionName(function), status)
+	}
+	return nil
+}
@@ -0,0 +1,605 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dnp3
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// 测点类型
+type PointType string
+
+const (
+	BINARY_INPUT  PointType = "BINARY_INPUT"  // 遥信
+	BINARY_OUTPUT PointType = "BINARY_OUTPUT" // 遥控, CROB
+	COUNTER       PointType = "COUNTER"       // 电度
+	ANALOG_INPUT  PointType = "ANALOG_INPUT"  // 遥测
+	ANALOG_OUTPUT PointType = "ANALOG_OUTPUT" // 设定值
+)
+
+func CheckPointType(t PointType) error {
+	switch t {
+	case BINARY_INPUT, BINARY_OUTPUT, COUNTER, ANALOG_INPUT, ANALOG_OUTPUT:
+		return nil
+	}
+	return fmt.Errorf("unsupported dnp3 point type: %s", t)
+}
+
+// 品质标志
+const (
+	FLAG_ONLINE        byte = 0x01
+	FLAG_RESTART       byte = 0x02
+	FLAG_COMM_LOST     byte = 0x04
+	FLAG_REMOTE_FORCED byte = 0x08
+	FLAG_LOCAL_FORCED  byte = 0x10
+	FLAG_OVER_RANGE    byte = 0x20 // 模拟量
+	FLAG_STATE         byte = 0x80 // 开关量的状态位
+)
+
+// 限定词
+const (
+	qualifierRange8  byte = 0x00
+	qualifierRange16 byte = 0x01
+	qualifierAll     byte = 0x06
+	qualifierCount8  byte = 0x07
+	qualifierCount16 byte = 0x08
+	qualifierIndex8  byte = 0x17
+	qualifierIndex16 byte = 0x28
+)
+
+/*
+*
+* 解析出来的测点数据
+*
+ */
+type Point struct {
+	Type      PointType
+	Index     uint16
+	Value     float64
+	Flags     byte
+	Time      time.Time // 不带时标的为零值
+	Event     bool      // 是否为事件
+	Group     byte
+	Variation byte
+}
+
+func (p Point) Online() bool {
+	return p.Flags&FLAG_ONLINE != 0
+}
+
+// 对象的值的编码
+type valueKind byte
+
+const (
+	kindBit valueKind = iota // 状态在标志字节的最高位
+	kindInt16
+	kindInt32
+	kindUint16
+	kindUint32
+	kindFloat32
+	kindFloat64
+)
+
+func (k valueKind) size() int {
+	switch k {
+	case kindInt16, kindUint16:
+		return 2
+	case kindInt32, kindUint32, kindFloat32:
+		return 4
+	case kindFloat64:
+		return 8
+	}
+	return 0
+}
+
+/*
+*
+* 测点对象的格式: 有没有标志字节、值的类型和时标
+*
+ */
+type objectSpec struct {
+	pointType PointType
+	event     bool
+	flags     bool
+	kind      valueKind
+	time      int // 0 没有时标, 6 绝对时标, 2 相对 CTO 的时标
+}
+
+func (s objectSpec) size() int {
+	size := s.kind.size() + s.time
+	if s.flags {
+		size++
+	}
+	return size
+}
+
+func gv(group, variation byte) uint16 {
+	return uint16(group)<<8 | uint16(variation)
+}
+
+var objectSpecs = map[uint16]objectSpec{
+	gv(1, 2):  {BINARY_INPUT, false, true, kindBit, 0},
+	gv(2, 1):  {BINARY_INPUT, true, true, kindBit, 0},
+	gv(2, 2):  {BINARY_INPUT, true, true, kindBit, 6},
+	gv(2, 3):  {BINARY_INPUT, true, true, kindBit, 2},
+	gv(10, 2): {BINARY_OUTPUT, false, true, kindBit, 0},
+	gv(11, 1): {BINARY_OUTPUT, true, true, kindBit, 0},
+	gv(11, 2): {BINARY_OUTPUT, true, true, kindBit, 6},
+	gv(20, 1): {COUNTER, false, true, kindUint32, 0},
+	gv(20, 2): {COUNTER, false, true, kindUint16, 0},
+	gv(20, 5): {COUNTER, false, false, kindUint32, 0},
+	gv(20, 6): {COUNTER, false, false, kindUint16, 0},
+	gv(22, 1): {COUNTER, true, true, kindUint32, 0},
+	gv(22, 2): {COUNTER, true, true, kindUint16, 0},
+	gv(22, 5): {COUNTER, true, true, kindUint32, 6},
+	gv(22, 6): {COUNTER, true, true, kindUint16, 6},
+	gv(30, 1): {ANALOG_INPUT, false, true, kindInt32, 0},
+	gv(30, 2): {ANALOG_INPUT, false, true, kindInt16, 0},
+	gv(30, 3): {ANALOG_INPUT, false, false, kindInt32, 0},
+	gv(30, 4): {ANALOG_INPUT, false, false, kindInt16, 0},
+	gv(30, 5): {ANALOG_INPUT, false, true, kindFloat32, 0},
+	gv(30, 6): {ANALOG_INPUT, false, true, kindFloat64, 0},
+	gv(32, 1): {ANALOG_INPUT, true, true, kindInt32, 0},
+	gv(32, 2): {ANALOG_INPUT, true, true, kindInt16, 0},
+	gv(32, 3): {ANALOG_INPUT, true, true, kindInt32, 6},
+	gv(32, 4): {ANALOG_INPUT, true, true, kindInt16, 6},
+	gv(32, 5): {ANALOG_INPUT, true, true, kindFloat32, 0},
+	gv(32, 6): {ANALOG_INPUT, true, true, kindFloat64, 0},
+	gv(32, 7): {ANALOG_INPUT, true, true, kindFloat32, 6},
+	gv(32, 8): {ANALOG_INPUT, true, true, kindFloat64, 6},
+	gv(40, 1): {ANALOG_OUTPUT, false, true, kindInt32, 0},
+	gv(40, 2): {ANALOG_OUTPUT, false, true, kindInt16, 0},
+	gv(40, 3): {ANALOG_OUTPUT, false, true, kindFloat32, 0},
+	gv(40, 4): {ANALOG_OUTPUT, false, true, kindFloat64, 0},
+	gv(42, 1): {ANALOG_OUTPUT, true, true, kindInt32, 0},
+	gv(42, 2): {ANALOG_OUTPUT, true, true, kindInt16, 0},
+	gv(42, 3): {ANALOG_OUTPUT, true, true, kindInt32, 6},
+	gv(42, 4): {ANALOG_OUTPUT, true, true, kindInt16, 6},
+	gv(42, 5): {ANALOG_OUTPUT, true, true, kindFloat32, 0},
+	gv(42, 6): {ANALOG_OUTPUT, true, true, kindFloat64, 0},
+	gv(42, 7): {ANALOG_OUTPUT, true, true, kindFloat32, 6},
+	gv(42, 8): {ANALOG_OUTPUT, true, true, kindFloat64, 6},
+}
+
+// 响应里面可能出现但是不需要解析成测点的对象
+var skipObjectSizes = map[uint16]int{
+	gv(12, 1): 11, // CROB 回显
+	gv(41, 1): 5,  // 设定值回显
+	gv(41, 2): 3,
+	gv(41, 3): 5,
+	gv(41, 4): 9,
+	gv(50, 1): 6, // 时间
+	gv(51, 1): 6, // CTO
+	gv(51, 2): 6,
+	gv(52, 1): 2, // 延时
+	gv(52, 2): 2,
+}
+
+// 按位打包的对象
+var packedObjects = map[uint16]PointType{
+	gv(1, 1):  BINARY_INPUT,
+	gv(10, 1): BINARY_OUTPUT,
+	gv(80, 1): "",
+}
+
+// 静态数据和事件的默认变体
+var (
+	staticGroups = map[PointType]byte{
+		BINARY_INPUT: 1, BINARY_OUTPUT: 10, COUNTER: 20, ANALOG_INPUT: 30, ANALOG_OUTPUT: 40,
+	}
+	staticDefaults = map[PointType]byte{
+		BINARY_INPUT: 2, BINARY_OUTPUT: 2, COUNTER: 1, ANALOG_INPUT: 5, ANALOG_OUTPUT: 3,
+	}
+	eventGroups = map[PointType]byte{
+		BINARY_INPUT: 2, BINARY_OUTPUT: 11, COUNTER: 22, ANALOG_INPUT: 32, ANALOG_OUTPUT: 42,
+	}
+	eventDefaults = map[PointType]byte{
+		BINARY_INPUT: 2, BINARY_OUTPUT: 2, COUNTER: 5, ANALOG_INPUT: 7, ANALOG_OUTPUT: 7,
+	}
+)
+
+/*
+*
+* 对象头: 组、变体、限定词和范围
+*
+ */
+type objectHeader struct {
+	group      byte
+	variation  byte
+	qualifier  byte
+	start      uint32
+	count      int
+	prefixSize int // 每个对象前面的索引长度, 0 表示索引从 start 开始连续
+}
+
+func (h objectHeader) String() string {
+	return fmt.Sprintf("g%dv%d q=0x%02X", h.group, h.variation, h.qualifier)
+}
+
+func readObjectHeader(b []byte) (objectHeader, []byte, error) {
+	h := objectHeader{}
+	if len(b) < 3 {
+		return h, nil, fmt.Errorf("invalid dnp3 object header")
+	}
+	h.group, h.variation, h.qualifier = b[0], b[1], b[2]
+	b = b[3:]
+	prefix := (h.qualifier >> 4) & 0x07
+	switch prefix {
+	case 0:
+	case 1:
+		h.prefixSize = 1
+	case 2:
+		h.prefixSize = 2
+	default:
+		return h, nil, fmt.Errorf("unsupported dnp3 qualifier: 0x%02X", h.qualifier)
+	}
+	switch h.qualifier & 0x0F {
+	case 0x00, 0x01:
+		n := 1
+		if h.qualifier&0x0F == 0x01 {
+			n = 2
+		}
+		if len(b) < 2*n || prefix != 0 {
+			return h, nil, fmt.Errorf("invalid dnp3 range: %s", h)
+		}
+		start, stop := uint32(b[0]), uint32(b[1])
+		if n == 2 {
+			start = uint32(binary.LittleEndian.Uint16(b))
+			stop = uint32(binary.LittleEndian.Uint16(b[2:]))
+		}
+		if stop < start {
+			return h, nil, fmt.Errorf("invalid dnp3 range: %s", h)
+		}
+		h.start = start
+		h.count = int(stop-start) + 1
+		b = b[2*n:]
+	case 0x06:
+	case 0x07:
+		if len(b) < 1 {
+			return h, nil, fmt.Errorf("invalid dnp3 count: %s", h)
+		}
+		h.count = int(b[0])
+		b = b[1:]
+	case 0x08:
+		if len(b) < 2 {
+			return h, nil, fmt.Errorf("invalid dnp3 count: %s", h)
+		}
+		h.count = int(binary.LittleEndian.Uint16(b))
+		b = b[2:]
+	default:
+		return h, nil, fmt.Errorf("unsupported dnp3 qualifier: 0x%02X", h.qualifier)
+	}
+	return h, b, nil
+}
+
+// 读对象前面的索引
+func (h objectHeader) index(i int, b []byte) (uint16, []byte, error) {
+	switch h.prefixSize {
+	case 1:
+		if len(b) < 1 {
+			return 0, nil, fmt.Errorf("dnp3 object too short: %s", h)
+		}
+		return uint16(b[0]), b[1:], nil
+	case 2:
+		if len(b) < 2 {
+			return 0, nil, fmt.Errorf("dnp3 object too short: %s", h)
+		}
+		return binary.LittleEndian.Uint16(b), b[2:], nil
+	}
+	return uint16(h.start + uint32(i)), b, nil
+}
+
+/*
+*
+* 解析响应里面的对象, 返回所有的测点数据
+*
+ */
+func ParsePoints(objects []byte) ([]Point, error) {
+	points := []Point{}
+	var cto time.Time
+	b := objects
+	for len(b) > 0 {
+		h, rest, err := readObjectHeader(b)
+		if err != nil {
+			return points, err
+		}
+		b = rest
+		key := gv(h.group, h.variation)
+		if pointType, ok := packedObjects[key]; ok {
+			if h.prefixSize != 0 {
+				return points, fmt.Errorf("unsupported dnp3 packed object: %s", h)
+			}
+			size := (h.count + 7) / 8
+			if len(b) < size {
+				return points, fmt.Errorf("dnp3 object too short: %s", h)
+			}
+			if pointType != "" {
+				for i := 0; i < h.count; i++ {
+					value := float64((b[i/8] >> (i % 8)) & 1)
+					points = append(points, Point{
+						Type:      pointType,
+						Index:     uint16(h.start + uint32(i)),
+						Value:     value,
+						Flags:     FLAG_ONLINE,
+						Group:     h.group,
+						Variation: h.variation,
+					})
+				}
+			}
+			b = b[size:]
+			continue
+		}
+		if size, ok := skipObjectSizes[key]; ok {
+			for i := 0; i < h.count; i++ {
+				if _, b, err = h.index(i, b); err != nil {
+					return points, err
+				}
+				if len(b) < size {
+					return points, fmt.Errorf("dnp3 object too short: %s", h)
+				}
+				if h.group == 51 {
+					cto = decodeTime(b)
+				}
+				b = b[size:]
+			}
+			continue
+		}
+		spec, ok := objectSpecs[key]
+		if !ok {
+			return points, fmt.Errorf("unsupported dnp3 object: %s", h)
+		}
+		for i := 0; i < h.count; i++ {
+			var index uint16
+			if index, b, err = h.index(i, b); err != nil {
+				return points, err
+			}
+			if len(b) < spec.size() {
+				return points, fmt.Errorf("dnp3 object too short: %s", h)
+			}
+			point := decodePoint(spec, b[:spec.size()], cto)
+			point.Index = index
+			point.Group = h.group
+			point.Variation = h.variation
+			points = append(points, point)
+			b = b[spec.size():]
+		}
+	}
+	return points, nil
+}
+
+func decodePoint(spec objectSpec, b []byte, cto time.Time) Point {
+	point := Point{Type: spec.pointType, Event: spec.event, Flags: FLAG_ONLINE}
+	if spec.flags {
+		point.Flags = b[0]
+		b = b[1:]
+	}
+	switch spec.kind {
+	case kindBit:
+		if point.Flags&FLAG_STATE != 0 {
+			point.Value = 1
+		}
+	case kindInt16:
+		point.Value = float64(int16(binary.LittleEndian.Uint16(b)))
+	case kindUint16:
+		point.Value = float64(binary.LittleEndian.Uint16(b))
+	case kindInt32:
+		point.Value = float64(int32(binary.LittleEndian.Uint32(b)))
+	case kindUint32:
+		point.Value = float64(binary.LittleEndian.Uint32(b))
+	case kindFloat32:
+		point.Value = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case kindFloat64:
+		point.Value = math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	b = b[spec.kind.size():]
+	switch spec.time {
+	case 6:
+		point.Time = decodeTime(b)
+	case 2:
+		if !cto.IsZero() {
+			point.Time = cto.Add(time.Duration(binary.LittleEndian.Uint16(b)) * time.Millisecond)
+		}
+	}
+	return point
+}
+
+/*
+*
+* 按照对象格式编码一个测点, 超出范围的值取边界
+*
+ */
+func encodePoint(spec objectSpec, point Point) []byte {
+	buf := make([]byte, 0, spec.size())
+	flags := point.Flags
+	if spec.kind == kindBit {
+		flags &^= FLAG_STATE
+		if point.Value != 0 {
+			flags |= FLAG_STATE
+		}
+	}
+	if spec.flags {
+		buf = append(buf, flags)
+	}
+	switch spec.kind {
+	case kindInt16:
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(int16(clamp(point.Value, math.MinInt16, math.MaxInt16))))
+	case kindUint16:
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(clamp(point.Value, 0, math.MaxUint16)))
+	case kindInt32:
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(int32(clamp(point.Value, math.MinInt32, math.MaxInt32))))
+	case kindUint32:
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(clamp(point.Value, 0, math.MaxUint32)))
+	case kindFloat32:
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(point.Value)))
+	case kindFloat64:
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(point.Value))
+	}
+	if spec.time == 6 {
+		buf = append(buf, encodeTime(point.Time)...)
+	}
+	return buf
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Round(math.Max(lo, math.Min(hi, v)))
+}
+
+// 48 位的 UTC 毫秒时间戳
+func decodeTime(b []byte) time.Time {
+	var ms uint64
+	for i := 5; i >= 0; i-- {
+		ms = ms<<8 | uint64(b[i])
+	}
+	return time.UnixMilli(int64(ms))
+}
+
+func encodeTime(t time.Time) []byte {
+	ms := uint64(t.UnixMilli())
+	b := make([]byte, 6)
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> (8 * i))
+	}
+	return b
+}
+
+/*
+*
+* 遥控输出块 CROB (g12v1)
+*
+ */
+type Crob struct {
+	Code    byte   // 控制码, 低 4 位为操作类型, 高 2 位为分合
+	Count   byte   // 执行次数
+	OnTime  uint32 // 毫秒
+	OffTime uint32
+	Status  byte
+}
+
+// CROB 操作类型
+const (
+	CROB_NUL       byte = 0x00
+	CROB_PULSE_ON  byte = 0x01
+	CROB_PULSE_OFF byte = 0x02
+	CROB_LATCH_ON  byte = 0x03
+	CROB_LATCH_OFF byte = 0x04
+	CROB_CLOSE     byte = 0x40 // 合, 和脉冲一起用
+	CROB_TRIP      byte = 0x80 // 分, 和脉冲一起用
+)
+
+// 命令状态
+const (
+	STATUS_SUCCESS       byte = 0
+	STATUS_TIMEOUT       byte = 1
+	STATUS_NO_SELECT     byte = 2
+	STATUS_FORMAT_ERROR  byte = 3
+	STATUS_NOT_SUPPORTED byte = 4
+	STATUS_ALREADY       byte = 5
+	STATUS_HARDWARE      byte = 6
+	STATUS_LOCAL         byte = 7
+)
+
+// 命令执行以后开关的状态, 不能确定的时候返回 false
+func (c Crob) State() (bool, bool) {
+	switch c.Code & 0xC0 {
+	case CROB_CLOSE:
+		return true, true
+	case CROB_TRIP:
+		return false, true
+	}
+	switch c.Code & 0x0F {
+	case CROB_PULSE_ON, CROB_LATCH_ON:
+		return true, true
+	case CROB_PULSE_OFF, CROB_LATCH_OFF:
+		return false, true
+	}
+	return false, false
+}
+
+func (c Crob) encode() []byte {
+	buf := []byte{c.Code, c.Count}
+	buf = binary.LittleEndian.AppendUint32(buf, c.OnTime)
+	buf = binary.LittleEndian.AppendUint32(buf, c.OffTime)
+	return append(buf, c.Status)
+}
+
+func decodeCrob(b []byte) Crob {
+	return Crob{
+		Code:    b[0],
+		Count:   b[1],
+		OnTime:  binary.LittleEndian.Uint32(b[2:]),
+		OffTime: binary.LittleEndian.Uint32(b[6:]),
+		Status:  b[10],
+	}
+}
+
+// 设定值 g41 的值
+func encodeAnalogOutput(variation byte, value float64) []byte {
+	spec := map[byte]valueKind{1: kindInt32, 2: kindInt16, 3: kindFloat32, 4: kindFloat64}[variation]
+	buf := encodePoint(objectSpec{kind: spec}, Point{Value: value})
+	return append(buf, STATUS_SUCCESS)
+}
+
+func decodeAnalogOutput(variation byte, b []byte) float64 {
+	spec := map[byte]valueKind{1: kindInt32, 2: kindInt16, 3: kindFloat32, 4: kindFloat64}[variation]
+	return decodePoint(objectSpec{kind: spec}, b, time.Time{}).Value
+}
+
+// 命令对象的长度, 不是命令对象返回 0
+func commandSize(group, variation byte) int {
+	switch gv(group, variation) {
+	case gv(12, 1), gv(41, 1), gv(41, 2), gv(41, 3), gv(41, 4):
+		return skipObjectSizes[gv(group, variation)]
+	}
+	return 0
+}
+
+// 单个命令对象的请求: 2 字节数量, 2 字节索引
+func commandObject(group, variation byte, index uint16, data []byte) []byte {
+	buf := []byte{group, variation, qualifierIndex16, 1, 0}
+	buf = binary.LittleEndian.AppendUint16(buf, index)
+	return append(buf, data...)
+}
+
+// 命令响应里面第一个对象的状态
+func commandStatus(objects []byte) (byte, error) {
+	h, b, err := readObjectHeader(objects)
+	if err != nil {
+		return 0, err
+	}
+	size := commandSize(h.group, h.variation)
+	if size == 0 || h.count < 1 {
+		return 0, fmt.Errorf("unexpected dnp3 command response: %s", h)
+	}
+	if _, b, err = h.index(0, b); err != nil {
+		return 0, err
+	}
+	if len(b) < size {
+		return 0, fmt.Errorf("dnp3 object too short: %s", h)
+	}
+	return b[size-1], nil
+}
+
+// 类数据的请求对象, class 0 为静态数据
+func classObjects(classes ...int) []byte {
+	buf := []byte{}
+	for _, class := range classes {
+		buf = append(buf, 60, byte(class+1), qualifierAll)
+	}
+	return buf
+}
@@ -0,0 +1,391 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dnp3
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 主站下发的遥控或者设定值
+type Command struct {
+	Type  PointType // BINARY_OUTPUT | ANALOG_OUTPUT
+	Index uint16
+	Value float64 // 遥控为 1 合 0 分
+	Crob  Crob    // 遥控的原始命令
+}
+
+// 执行命令, 返回命令状态, 比如 STATUS_SUCCESS
+type CommandHandler func(cmd Command) byte
+
+type OutstationConfig struct {
+	Local          uint16        // 子站地址
+	Remote         uint16        // 主站地址
+	Unsolicited    bool          // 是否支持主动上送
+	MaxEvents      int           // 事件缓存的最大数量
+	ConfirmTimeout time.Duration // 等待主站确认的时间
+}
+
+// 子站数据库里面的测点
+type pointState struct {
+	Point
+	class     byte    // 事件类别, 0 不产生事件
+	deadband  float64 // 模拟量和电度的事件死区
+	lastEvent float64
+	valid     bool // 是否更新过
+}
+
+type event struct {
+	id    uint64
+	class byte
+	point Point
+}
+
+/*
+*
+* DNP3 子站: 保存测点的当前值和事件, 每个连接一个会话, 事件在主站确认以后删除
+*
+ */
+type Outstation struct {
+	config      OutstationConfig
+	handler     CommandHandler
+	locker      sync.Mutex
+	points      map[PointType]map[uint16]*pointState
+	events      []event
+	eventId     uint64
+	overflow    bool
+	restart     bool
+	subscribers map[chan struct{}]struct{}
+}
+
+func NewOutstation(config OutstationConfig, handler CommandHandler) *Outstation {
+	if config.MaxEvents <= 0 {
+		config.MaxEvents = 1000
+	}
+	if config.ConfirmTimeout <= 0 {
+		config.ConfirmTimeout = 5 * time.Second
+	}
+	return &Outstation{
+		config:      config,
+		handler:     handler,
+		points:      map[PointType]map[uint16]*pointState{},
+		restart:     true,
+		subscribers: map[chan struct{}]struct{}{},
+	}
+}
+
+/*
+*
+* 添加测点, class 为事件类别(0-3), deadband 为模拟量和电度产生事件的死区
+*
+ */
+func (o *Outstation) AddPoint(t PointType, index uint16, class byte, deadband float64) error {
+	if err := CheckPointType(t); err != nil {
+		return err
+	}
+	if class > 3 {
+		return fmt.Errorf("invalid event class: %d", class)
+	}
+	o.locker.Lock()
+	defer o.locker.Unlock()
+	if o.points[t] == nil {
+		o.points[t] = map[uint16]*pointState{}
+	}
+	if _, ok := o.points[t][index]; ok {
+		return fmt.Errorf("duplicate dnp3 point: %s %d", t, index)
+	}
+	o.points[t][index] = &pointState{
+		Point:    Point{Type: t, Index: index, Flags: FLAG_RESTART},
+		class:    class,
+		deadband: deadband,
+	}
+	return nil
+}
+
+/*
+*
+* 更新测点的当前值, 值或者品质变化的时候产生事件; 第一次更新不产生事件
+*
+ */
+func (o *Outstation) Update(t PointType, index uint16, value float64, flags byte, ts time.Time) {
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	o.locker.Lock()
+	p, ok := o.points[t][index]
+	if !ok {
+		o.locker.Unlock()
+		return
+	}
+	emit := false
+	if p.valid && p.class > 0 {
+		switch {
+		case p.Flags != flags:
+			emit = true
+		case t == BINARY_INPUT || t == BINARY_OUTPUT:
+			emit = (value != 0) != (p.Value != 0)
+		case p.deadband > 0:
+			emit = math.Abs(value-p.lastEvent) > p.deadband
+		default:
+			emit = value != p.lastEvent
+		}
+	}
+	if !p.valid || emit {
+		p.lastEvent = value
+	}
+	p.Value, p.Flags, p.Time, p.valid = value, flags, ts, true
+	if emit {
+		o.eventId++
+		o.events = append(o.events, event{id: o.eventId, class: p.class, point: p.Point})
+		if len(o.events) > o.config.MaxEvents {
+			o.events = o.events[len(o.events)-o.config.MaxEvents:]
+			o.overflow = true
+		}
+	}
+	subscribers := make([]chan struct{}, 0, len(o.subscribers))
+	if emit {
+		for ch := range o.subscribers {
+			subscribers = append(subscribers, ch)
+		}
+	}
+	o.locker.Unlock()
+	for _, ch := range subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 测点的当前值
+func (o *Outstation) Value(t PointType, index uint16) (Point, bool) {
+	o.locker.Lock()
+	defer o.locker.Unlock()
+	p, ok := o.points[t][index]
+	if !ok {
+		return Point{}, false
+	}
+	return p.Point, true
+}
+
+func (o *Outstation) iin() uint16 {
+	o.locker.Lock()
+	defer o.locker.Unlock()
+	var iin uint16
+	for _, e := range o.events {
+		iin |= IIN_CLASS1_EVENTS << (e.class - 1)
+	}
+	if o.restart {
+		iin |= IIN_DEVICE_RESTART
+	}
+	if o.overflow {
+		iin |= IIN_EVENT_BUFFER_OVERFLOW
+	}
+	return iin
+}
+
+// 主站确认以后删除事件
+func (o *Outstation) removeEvents(ids []uint64) {
+	if len(ids) == 0 {
+		return
+	}
+	remove := map[uint64]bool{}
+	for _, id := range ids {
+		remove[id] = true
+	}
+	o.locker.Lock()
+	defer o.locker.Unlock()
+	events := o.events[:0]
+	for _, e := range o.events {
+		if !remove[e.id] {
+			events = append(events, e)
+		}
+	}
+	o.events = events
+	if len(o.events) < o.config.MaxEvents {
+		o.overflow = false
+	}
+}
+
+/*
+*
+* 按照类别和测点类型取事件, classMask 第 n 位表示第 n 类, limit 为 0 的时候不限制数量
+*
+ */
+func (o *Outstation) collectEvents(classMask byte, t PointType, limit int, exclude map[uint64]bool) []event {
+	o.locker.Lock()
+	defer o.locker.Unlock()
+	events := []event{}
+	for _, e := range o.events {
+		if classMask&(1<<e.class) == 0 || exclude[e.id] {
+			continue
+		}
+		if t != "" && e.point.Type != t {
+			continue
+		}
+		events = append(events, e)
+		if limit > 0 && len(events) >= limit {
+			break
+		}
+	}
+	return events
+}
+
+// 按照索引排序的静态数据
+func (o *Outstation) staticPoints(t PointType, lo, hi uint32) []Point {
+	o.locker.Lock()
+	defer o.locker.Unlock()
+	points := []Point{}
+	for index, p := range o.points[t] {
+		if uint32(index) >= lo && uint32(index) <= hi {
+			points = append(points, p.Point)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Index < points[j].Index
+	})
+	return points
+}
+
+func (o *Outstation) hasPoint(t PointType, index uint16) bool {
+	o.locker.Lock()
+	defer o.locker.Unlock()
+	_, ok := o.points[t][index]
+	return ok
+}
+
+func (o *Outstation) clearRestart() {
+	o.locker.Lock()
+	o.restart = false
+	o.locker.Unlock()
+}
+
+func (o *Outstation) subscribe(ch chan struct{}) {
+	o.locker.Lock()
+	o.subscribers[ch] = struct{}{}
+	o.locker.Unlock()
+}
+
+func (o *Outstation) unsubscribe(ch chan struct{}) {
+	o.locker.Lock()
+	delete(o.subscribers, ch)
+	o.locker.Unlock()
+}
+
+// 每个对象块最多的对象数量, 保证一个块能放进一个分片
+const maxObjectsPerBlock = 100
+
+/*
+*
+* 编码静态数据, 连续的索引放在同一个范围里面
+*
+ */
+func encodeStatic(t PointType, variation byte, points []Point) [][]byte {
+	group := staticGroups[t]
+	blocks := [][]byte{}
+	for i := 0; i < len(points); {
+		j := i + 1
+		for j < len(points) && j-i < maxObjectsPerBlock && points[j].Index == points[j-1].Index+1 {
+			j++
+		}
+		run := points[i:j]
+		block := []byte{group, variation, qualifierRange16}
+		block = append(block, byte(run[0].Index), byte(run[0].Index>>8))
+		block = append(block, byte(run[len(run)-1].Index), byte(run[len(run)-1].Index>>8))
+		if _, packed := packedObjects[gv(group, variation)]; packed {
+			bits := make([]byte, (len(run)+7)/8)
+			for k, p := range run {
+				if p.Value != 0 {
+					bits[k/8] |= 1 << (k % 8)
+				}
+			}
+			block = append(block, bits...)
+		} else {
+			spec := objectSpecs[gv(group, variation)]
+			for _, p := range run {
+				block = append(block, encodePoint(spec, p)...)
+			}
+		}
+		blocks = append(blocks, block)
+		i = j
+	}
+	return blocks
+}
+
+/*
+*
+* 编码事件, 相邻的同类事件放在同一个带索引的对象头里面
+*
+ */
+func encodeEvents(events []event, variation byte) [][]byte {
+	blocks := [][]byte{}
+	for i := 0; i < len(events); {
+		t := events[i].point.Type
+		v := variation
+		if v == 0 {
+			v = eventDefaults[t]
+		}
+		j := i + 1
+		for j < len(events) && j-i < maxObjectsPerBlock && events[j].point.Type == t {
+			j++
+		}
+		spec := objectSpecs[gv(eventGroups[t], v)]
+		block := []byte{eventGroups[t], v, qualifierIndex16, byte(j - i), byte((j - i) >> 8)}
+		for _, e := range events[i:j] {
+			block = append(block, byte(e.point.Index), byte(e.point.Index>>8))
+			block = append(block, encodePoint(spec, e.point)...)
+		}
+		blocks = append(blocks, block)
+		i = j
+	}
+	return blocks
+}
+
+// 事件的 ID
+func eventIds(events []event) []uint64 {
+	ids := make([]uint64, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.id)
+	}
+	return ids
+}
+
+// 把对象块装进分片, 每个分片去掉 4 字节应用层头
+func splitFragments(blocks [][]byte) [][]byte {
+	fragments := [][]byte{}
+	current := []byte{}
+	for _, block := range blocks {
+		if len(current) > 0 && len(current)+len(block) > maxFragmentBytes-4 {
+			fragments = append(fragments, current)
+			current = []byte{}
+		}
+		current = append(current, block...)
+	}
+	return append(fragments, current)
+}
+
+// 点位类型对应的组
+func pointTypeOfGroup(groups map[PointType]byte, group byte) (PointType, bool) {
+	for t, g := range groups {
+		if g == group {
+			return t, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,566 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package dnp3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+)
+
+// 选择以后必须在这个时间内执行
+const selectTimeout = 10 * time.Second
+
+// 每次主动上送的最大事件数量
+const maxUnsolicitedEvents = 100
+
+// 16 位索引的最大值
+const math16 = 0xFFFF
+
+/*
+*
+* 子站和一个主站之间的会话
+*
+ */
+type session struct {
+	o         *Outstation
+	link      *Link
+	fragments chan []byte
+	errs      chan error
+	notify    chan struct{}
+	// 请求响应
+	solSeq     byte
+	solPending []uint64 // 等待确认的事件
+	// 主动上送
+	unsMask    byte // 主站允许上送的事件类别
+	unsSeq     byte
+	unsPending bool
+	unsIds     []uint64
+	unsObjects []byte
+	unsSentAt  time.Time
+	// 选择执行
+	selectObjects []byte
+	selectSeq     byte
+	selectAt      time.Time
+}
+
+/*
+*
+* 处理一个主站连接, 连接断开或者 ctx 取消以后返回; 调用方负责关闭连接
+*
+ */
+func (o *Outstation) Serve(ctx context.Context, rw io.ReadWriter) error {
+	s := &session{
+		o:         o,
+		link:      NewLink(rw, false, o.config.Local, o.config.Remote),
+		fragments: make(chan []byte, 4),
+		errs:      make(chan error, 1),
+		notify:    make(chan struct{}, 1),
+	}
+	o.subscribe(s.notify)
+	defer o.unsubscribe(s.notify)
+	go s.readLoop(ctx)
+	if o.config.Unsolicited {
+		// 启动以后先发一个空的主动上送, 主站确认以后才能上送事件
+		if err := s.sendUnsolicited(nil, nil); err != nil {
+			return err
+		}
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-s.errs:
+			return err
+		case fragment := <-s.fragments:
+			if err := s.handle(fragment); err != nil {
+				return err
+			}
+		case <-s.notify:
+			if err := s.tryUnsolicited(); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := s.retryUnsolicited(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *session) readLoop(ctx context.Context) {
+	for {
+		fragment, err := s.link.ReadFragment()
+		if err != nil {
+			if IsTimeout(err) {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+					continue
+				}
+			}
+			s.errs <- err
+			return
+		}
+		select {
+		case s.fragments <- fragment:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *session) handle(fragment []byte) error {
+	for fragment != nil {
+		apdu, err := ParseApdu(fragment)
+		if err != nil || apdu.isResponse() {
+			return nil
+		}
+		fragment = nil
+		switch apdu.Function {
+		case FC_CONFIRM:
+			s.confirm(apdu)
+		case FC_READ:
+			fragment, err = s.read(apdu)
+		case FC_WRITE:
+			err = s.write(apdu)
+		case FC_SELECT, FC_OPERATE, FC_DIRECT_OPERATE, FC_DIRECT_OPERATE_NR:
+			err = s.operate(apdu)
+		case FC_ENABLE_UNSOLICITED, FC_DISABLE_UNSOLICITED:
+			err = s.enableUnsolicited(apdu)
+		case FC_DELAY_MEASURE:
+			err = s.respond(apdu.Seq(), []byte{52, 2, qualifierCount8, 1, 0, 0}, 0, false)
+		default:
+			err = s.respond(apdu.Seq(), nil, IIN_NO_FUNC_CODE_SUPPORT, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *session) confirm(apdu Apdu) {
+	if apdu.Uns() {
+		if s.unsPending && apdu.Seq() == s.unsSeq {
+			s.o.removeEvents(s.unsIds)
+			s.unsPending = false
+			s.unsIds = nil
+			s.unsObjects = nil
+			s.unsSeq = (s.unsSeq + 1) & 0x0F
+		}
+		return
+	}
+	if len(s.solPending) > 0 && apdu.Seq() == s.solSeq {
+		s.o.removeEvents(s.solPending)
+		s.solPending = nil
+	}
+}
+
+func (s *session) respond(seq byte, objects []byte, iin uint16, con bool) error {
+	apdu := newApdu(FC_RESPONSE, seq, con, false, objects)
+	apdu.IIN = s.o.iin() | iin
+	return s.link.WriteFragment(apdu.Encode())
+}
+
+/*
+*
+* 读: 0 类返回全部静态数据, 1/2/3 类返回事件, 也支持按组读静态数据和事件.
+* 分片超过一个的时候逐个等待确认, 等待期间收到新的请求就放弃剩下的分片, 返回新的请求
+*
+ */
+func (s *session) read(apdu Apdu) ([]byte, error) {
+	blocks := [][]byte{}
+	events := []event{}
+	var iin uint16
+	exclude := map[uint64]bool{}
+	for _, id := range s.unsIds {
+		exclude[id] = true
+	}
+	b := apdu.Objects
+	for len(b) > 0 {
+		h, rest, err := readObjectHeader(b)
+		if err != nil {
+			iin |= IIN_PARAMETER_ERROR
+			break
+		}
+		b = rest
+		limit := 0
+		if q := h.qualifier & 0x0F; q == qualifierCount8 || q == qualifierCount16 {
+			limit = h.count
+		}
+		if h.group == 60 {
+			switch {
+			case h.variation == 1:
+				for _, t := range []PointType{BINARY_INPUT, BINARY_OUTPUT, COUNTER, ANALOG_INPUT, ANALOG_OUTPUT} {
+					blocks = append(blocks, encodeStatic(t, staticDefaults[t], s.o.staticPoints(t, 0, math16))...)
+				}
+			case h.variation >= 2 && h.variation <= 4:
+				found := s.o.collectEvents(1<<(h.variation-1), "", limit, exclude)
+				for _, e := range found {
+					exclude[e.id] = true
+				}
+				events = append(events, found...)
+				blocks = append(blocks, encodeEvents(found, 0)...)
+			default:
+				iin |= IIN_OBJECT_UNKNOWN
+			}
+			continue
+		}
+		if t, ok := pointTypeOfGroup(staticGroups, h.group); ok {
+			variation := h.variation
+			if variation == 0 {
+				variation = staticDefaults[t]
+			}
+			_, packed := packedObjects[gv(h.group, variation)]
+			if spec, ok := objectSpecs[gv(h.group, variation)]; !packed && (!ok || spec.event) {
+				iin |= IIN_OBJECT_UNKNOWN
+				continue
+			}
+			lo, hi := uint32(0), uint32(math16)
+			if q := h.qualifier & 0x0F; q == qualifierRange8 || q == qualifierRange16 {
+				lo, hi = h.start, h.start+uint32(h.count)-1
+			}
+			blocks = append(blocks, encodeStatic(t, variation, s.o.staticPoints(t, lo, hi))...)
+			continue
+		}
+		if t, ok := pointTypeOfGroup(eventGroups, h.group); ok {
+			if h.variation != 0 {
+				if spec, ok := objectSpecs[gv(h.group, h.variation)]; !ok || !spec.event || spec.time == 2 {
+					iin |= IIN_OBJECT_UNKNOWN
+					continue
+				}
+			}
+			found := s.o.collectEvents(0x0E, t, limit, exclude)
+			for _, e := range found {
+				exclude[e.id] = true
+			}
+			events = append(events, found...)
+			blocks = append(blocks, encodeEvents(found, h.variation)...)
+			continue
+		}
+		iin |= IIN_OBJECT_UNKNOWN
+	}
+	fragments := splitFragments(blocks)
+	seq := apdu.Seq()
+	for i, objects := range fragments {
+		resp := newApdu(FC_RESPONSE, seq, len(events) > 0 || len(fragments) > 1, false, objects)
+		if i > 0 {
+			resp.Control &^= appFir
+		}
+		if i < len(fragments)-1 {
+			resp.Control &^= appFin
+		}
+		resp.IIN = s.o.iin() | iin
+		if err := s.link.WriteFragment(resp.Encode()); err != nil {
+			return nil, err
+		}
+		if i == len(fragments)-1 {
+			if len(events) > 0 {
+				s.solSeq = seq
+				s.solPending = eventIds(events)
+			}
+			break
+		}
+		next, ok, err := s.waitConfirm(seq)
+		if err != nil || !ok {
+			return next, err
+		}
+		seq = (seq + 1) & 0x0F
+	}
+	return nil, nil
+}
+
+// 等待多分片响应的确认, 收到其他请求的时候返回这个请求
+func (s *session) waitConfirm(seq byte) ([]byte, bool, error) {
+	timer := time.NewTimer(s.o.config.ConfirmTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case err := <-s.errs:
+			return nil, false, err
+		case <-timer.C:
+			return nil, false, nil
+		case fragment := <-s.fragments:
+			apdu, err := ParseApdu(fragment)
+			if err != nil {
+				continue
+			}
+			if apdu.Function != FC_CONFIRM {
+				return fragment, false, nil
+			}
+			if apdu.Uns() {
+				s.confirm(apdu)
+				continue
+			}
+			if apdu.Seq() == seq {
+				return nil, true, nil
+			}
+		}
+	}
+}
+
+/*
+*
+* 写: 清除重启标志(g80v1 第 7 位)和对时(g50v1)
+*
+ */
+func (s *session) write(apdu Apdu) error {
+	var iin uint16
+	b := apdu.Objects
+	for len(b) > 0 && iin == 0 {
+		h, rest, err := readObjectHeader(b)
+		if err != nil {
+			iin |= IIN_PARAMETER_ERROR
+			break
+		}
+		b = rest
+		switch gv(h.group, h.variation) {
+		case gv(80, 1):
+			size := (h.count + 7) / 8
+			if h.prefixSize != 0 || h.count == 0 || len(b) < size {
+				iin |= IIN_PARAMETER_ERROR
+				break
+			}
+			for i := 0; i < h.count; i++ {
+				index := h.start + uint32(i)
+				bit := (b[i/8] >> (i % 8)) & 1
+				if index != 7 || bit != 0 {
+					iin |= IIN_PARAMETER_ERROR
+				}
+			}
+			if iin == 0 {
+				s.o.clearRestart()
+			}
+			b = b[size:]
+		case gv(50, 1):
+			if h.count != 1 || len(b) < h.prefixSize+6 {
+				iin |= IIN_PARAMETER_ERROR
+				break
+			}
+			b = b[h.prefixSize+6:]
+		default:
+			iin |= IIN_OBJECT_UNKNOWN
+		}
+	}
+	return s.respond(apdu.Seq(), nil, iin, false)
+}
+
+/*
+*
+* 遥控和设定值: 选择只检查测点, 执行的时候必须和选择的对象完全一样并且序号连续
+*
+ */
+func (s *session) operate(apdu Apdu) error {
+	var iin uint16
+	echo := []byte{}
+	commands := []Command{}
+	offsets := []int{} // 回显里面每个对象状态字节的位置
+	b := apdu.Objects
+	for len(b) > 0 {
+		h, rest, err := readObjectHeader(b)
+		size := commandSize(h.group, h.variation)
+		if err != nil || size == 0 || h.prefixSize == 0 {
+			if err == nil {
+				iin |= IIN_OBJECT_UNKNOWN
+			} else {
+				iin |= IIN_PARAMETER_ERROR
+			}
+			break
+		}
+		echo = append(echo, b[:len(b)-len(rest)]...)
+		b = rest
+		for i := 0; i < h.count; i++ {
+			start := len(b)
+			var index uint16
+			if index, b, err = h.index(i, b); err != nil || len(b) < size {
+				iin |= IIN_PARAMETER_ERROR
+				break
+			}
+			data := b[:size]
+			echo = append(echo, apdu.Objects[len(apdu.Objects)-start:len(apdu.Objects)-len(b)]...)
+			echo = append(echo, data...)
+			offsets = append(offsets, len(echo)-1)
+			command := Command{Index: index}
+			if h.group == 12 {
+				command.Type = BINARY_OUTPUT
+				command.Crob = decodeCrob(data)
+				on, ok := command.Crob.State()
+				if !ok {
+					command.Type = ""
+				}
+				if on {
+					command.Value = 1
+				}
+			} else {
+				command.Type = ANALOG_OUTPUT
+				command.Value = decodeAnalogOutput(h.variation, data)
+			}
+			commands = append(commands, command)
+			b = b[size:]
+		}
+		if iin != 0 {
+			break
+		}
+	}
+	if iin != 0 {
+		if apdu.Function == FC_DIRECT_OPERATE_NR {
+			return nil
+		}
+		return s.respond(apdu.Seq(), nil, iin, false)
+	}
+	statuses := make([]byte, len(commands))
+	for i, command := range commands {
+		if command.Type == "" || !s.o.hasPoint(command.Type, command.Index) {
+			statuses[i] = STATUS_NOT_SUPPORTED
+		}
+	}
+	switch apdu.Function {
+	case FC_SELECT:
+		s.selectObjects = nil
+		if allSuccess(statuses) {
+			s.selectObjects = append([]byte{}, apdu.Objects...)
+			s.selectSeq = apdu.Seq()
+			s.selectAt = time.Now()
+		}
+	case FC_OPERATE:
+		selected := s.selectObjects != nil && bytes.Equal(s.selectObjects, apdu.Objects) &&
+			apdu.Seq() == (s.selectSeq+1)&0x0F && time.Since(s.selectAt) < selectTimeout
+		s.selectObjects = nil
+		for i := range statuses {
+			if !selected {
+				statuses[i] = STATUS_NO_SELECT
+			}
+		}
+		if selected {
+			s.execute(commands, statuses)
+		}
+	default:
+		s.execute(commands, statuses)
+	}
+	if apdu.Function == FC_DIRECT_OPERATE_NR {
+		return nil
+	}
+	for i, offset := range offsets {
+		echo[offset] = statuses[i]
+	}
+	return s.respond(apdu.Seq(), echo, 0, false)
+}
+
+func (s *session) execute(commands []Command, statuses []byte) {
+	for i, command := range commands {
+		if statuses[i] != STATUS_SUCCESS {
+			continue
+		}
+		if s.o.handler == nil {
+			statuses[i] = STATUS_NOT_SUPPORTED
+			continue
+		}
+		statuses[i] = s.o.handler(command)
+	}
+}
+
+func allSuccess(statuses []byte) bool {
+	for _, status := range statuses {
+		if status != STATUS_SUCCESS {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+*
+* 允许或者禁止主动上送, 只支持 1/2/3 类
+*
+ */
+func (s *session) enableUnsolicited(apdu Apdu) error {
+	if !s.o.config.Unsolicited {
+		return s.respond(apdu.Seq(), nil, IIN_NO_FUNC_CODE_SUPPORT, false)
+	}
+	var mask byte
+	var iin uint16
+	b := apdu.Objects
+	for len(b) > 0 {
+		h, rest, err := readObjectHeader(b)
+		if err != nil {
+			iin |= IIN_PARAMETER_ERROR
+			break
+		}
+		b = rest
+		if h.group != 60 || h.variation < 2 || h.variation > 4 {
+			iin |= IIN_OBJECT_UNKNOWN
+			continue
+		}
+		mask |= 1 << (h.variation - 1)
+	}
+	if iin == 0 {
+		if apdu.Function == FC_ENABLE_UNSOLICITED {
+			s.unsMask |= mask
+		} else {
+			s.unsMask &^= mask
+		}
+	}
+	if err := s.respond(apdu.Seq(), nil, iin, false); err != nil {
+		return err
+	}
+	return s.tryUnsolicited()
+}
+
+func (s *session) sendUnsolicited(objects []byte, ids []uint64) error {
+	apdu := newApdu(FC_UNSOLICITED_RESPONSE, s.unsSeq, true, true, objects)
+	apdu.IIN = s.o.iin()
+	s.unsPending = true
+	s.unsIds = ids
+	s.unsObjects = objects
+	s.unsSentAt = time.Now()
+	return s.link.WriteFragment(apdu.Encode())
+}
+
+// 有允许上送的事件并且上一次上送已经确认的时候上送
+func (s *session) tryUnsolicited() error {
+	if s.unsPending || s.unsMask == 0 {
+		return nil
+	}
+	exclude := map[uint64]bool{}
+	for _, id := range s.solPending {
+		exclude[id] = true
+	}
+	events := s.o.collectEvents(s.unsMask, "", maxUnsolicitedEvents, exclude)
+	if len(events) == 0 {
+		return nil
+	}
+	objects := []byte{}
+	for _, block := range encodeEvents(events, 0) {
+		objects = append(objects, block...)
+	}
+	return s.sendUnsolicited(objects, eventIds(events))
+}
+
+// 确认超时以后重发
+func (s *session) retryUnsolicited() error {
+	if !s.unsPending {
+		return s.tryUnsolicited()
+	}
+	if time.Since(s.unsSentAt) < s.o.config.ConfirmTimeout {
+		return nil
+	}
+	return s.sendUnsolicited(s.unsObjects, s.unsIds)
+}
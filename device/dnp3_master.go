@@ -0,0 +1,487 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	serial "github.com/hootrhino/goserial"
+	"github.com/hootrhino/rhilex/component/deadband"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/device/dnp3"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/resconfig"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+type Dnp3MasterCommonConfig struct {
+	Mode              string `json:"mode" validate:"required"`              // TCP | UART
+	LocalAddress      *int   `json:"localAddress" validate:"required"`      // 主站链路地址, 一般为1
+	RemoteAddress     *int   `json:"remoteAddress" validate:"required"`     // 子站链路地址
+	IntegrityInterval *int   `json:"integrityInterval" validate:"required"` // 总召唤周期, 秒, 0 表示只在启动的时候召唤
+	EventInterval     *int   `json:"eventInterval" validate:"required"`     // 事件召唤周期, 秒, 0 表示不召唤
+	Unsolicited       *bool  `json:"unsolicited" validate:"required"`       // 是否允许子站主动上送
+	CommandMode       string `json:"commandMode"`                           // SBO | DIRECT
+	CrobMode          string `json:"crobMode"`                              // LATCH | PULSE
+	PulseTime         int    `json:"pulseTime"`                             // 脉冲宽度, 毫秒
+}
+
+type Dnp3MasterMainConfig struct {
+	CommonConfig Dnp3MasterCommonConfig `json:"commonConfig" validate:"required"`
+	HostConfig   resconfig.HostConfig   `json:"hostConfig"`
+	UartConfig   resconfig.UartConfig   `json:"uartConfig"`
+}
+
+// DNP3 测点表
+type dnp3DataPoint struct {
+	UUID       string `json:"-"`
+	PointType  string `json:"pointType"`
+	PointIndex uint16 `json:"pointIndex"`
+	Tag        string `json:"tag"`
+	Alias      string `json:"alias"`
+
+	deadband.ReportPolicy // 上报策略
+}
+
+// 测点的类型和索引
+type dnp3PointKey struct {
+	Type  dnp3.PointType
+	Index uint16
+}
+
+// 上报的数据
+type Dnp3PointValue struct {
+	Type        string  `json:"type"`  // BINARY_INPUT
+	Index       uint16  `json:"index"` // 测点索引
+	Tag         string  `json:"tag"`
+	Alias       string  `json:"alias"`
+	Value       float64 `json:"value"`
+	Flags       byte    `json:"flags"`       // 品质, 0x01 为在线
+	Event       bool    `json:"event"`       // 是否为事件
+	Ts          int64   `json:"ts"`          // 子站带的时标, 毫秒; 不带时标的为0
+	Unsolicited bool    `json:"unsolicited"` // 是否为子站主动上送
+}
+
+type Dnp3Master struct {
+	typex.XStatus
+	status       typex.SourceState
+	mainConfig   Dnp3MasterMainConfig
+	locker       sync.Mutex
+	master       *dnp3.Master
+	points       map[dnp3PointKey][]dnp3DataPoint // 同一个测点可以对应多个点位
+	tags         map[string]dnp3DataPoint
+	reportFilter *deadband.Filter
+}
+
+func NewDnp3Master(e typex.Rhilex) typex.XDevice {
+	hd := new(Dnp3Master)
+	hd.RuleEngine = e
+	hd.mainConfig = Dnp3MasterMainConfig{
+		CommonConfig: Dnp3MasterCommonConfig{
+			Mode: "TCP",
+			LocalAddress: func() *int {
+				a := 1
+				return &a
+			}(),
+			RemoteAddress: func() *int {
+				a := 10
+				return &a
+			}(),
+			IntegrityInterval: func() *int {
+				a := 3600
+				return &a
+			}(),
+			EventInterval: func() *int {
+				a := 5
+				return &a
+			}(),
+			Unsolicited: func() *bool {
+				b := true
+				return &b
+			}(),
+			CommandMode: "SBO",
+			CrobMode:    "LATCH",
+			PulseTime:   1000,
+		},
+		HostConfig: resconfig.HostConfig{
+			Host:    "127.0.0.1",
+			Port:    20000,
+			Timeout: 5000,
+		},
+		UartConfig: resconfig.UartConfig{
+			Timeout:  3000,
+			Uart:     "/dev/ttyS1",
+			BaudRate: 9600,
+			DataBits: 8,
+			Parity:   "N",
+			StopBits: 1,
+		},
+	}
+	hd.points = map[dnp3PointKey][]dnp3DataPoint{}
+	hd.tags = map[string]dnp3DataPoint{}
+	hd.reportFilter = deadband.NewFilter()
+	return hd
+}
+
+func (hd *Dnp3Master) Init(devId string, configMap map[string]any) error {
+	hd.PointId = devId
+	intercache.RegisterSlot(devId)
+	if err := utils.BindSourceConfig(configMap, &hd.mainConfig); err != nil {
+		glogger.GLogger.Error(err)
+		return err
+	}
+	if err := checkDnp3Link(hd.mainConfig.CommonConfig.Mode,
+		*hd.mainConfig.CommonConfig.LocalAddress,
+		*hd.mainConfig.CommonConfig.RemoteAddress); err != nil {
+		return err
+	}
+	if *hd.mainConfig.CommonConfig.IntegrityInterval < 0 ||
+		*hd.mainConfig.CommonConfig.EventInterval < 0 {
+		return fmt.Errorf("poll interval must be greater than or equal to 0")
+	}
+	if !utils.SContains([]string{"SBO", "DIRECT"}, hd.mainConfig.CommonConfig.CommandMode) {
+		return errors.New("unsupported command mode, only can be one of 'SBO' or 'DIRECT'")
+	}
+	if !utils.SContains([]string{"LATCH", "PULSE"}, hd.mainConfig.CommonConfig.CrobMode) {
+		return errors.New("unsupported crob mode, only can be one of 'LATCH' or 'PULSE'")
+	}
+	if hd.mainConfig.CommonConfig.PulseTime < 0 {
+		return errors.New("pulse time must be greater than or equal to 0")
+	}
+	dataPoints := []dnp3DataPoint{}
+	if err := interdb.InterDb().Table("m_dnp3_data_points").
+		Where("device_uuid=?", devId).Find(&dataPoints).Error; err != nil {
+		return err
+	}
+	LastFetchTime := uint64(time.Now().UnixMilli())
+	for _, point := range dataPoints {
+		if err := dnp3.CheckPointType(dnp3.PointType(point.PointType)); err != nil {
+			return err
+		}
+		key := dnp3PointKey{Type: dnp3.PointType(point.PointType), Index: point.PointIndex}
+		hd.points[key] = append(hd.points[key], point)
+		hd.tags[point.Tag] = point
+		intercache.SetValue(hd.PointId, point.UUID, intercache.CacheValue{
+			UUID:          point.UUID,
+			Status:        0,
+			LastFetchTime: LastFetchTime,
+			Value:         "",
+			ErrMsg:        "--",
+		})
+	}
+	return nil
+}
+
+// 主站和子站共用的链路配置检查
+func checkDnp3Link(mode string, local, remote int) error {
+	if !utils.SContains([]string{"UART", "TCP"}, mode) {
+		return errors.New("unsupported mode, only can be one of 'TCP' or 'UART'")
+	}
+	// 0xFFF0 以上为保留的广播地址
+	if local < 0 || local >= 0xFFF0 || remote < 0 || remote >= 0xFFF0 {
+		return fmt.Errorf("link address must range of 0-65519")
+	}
+	if local == remote {
+		return fmt.Errorf("local address and remote address must be different")
+	}
+	return nil
+}
+
+// 按照模式打开串口
+func openDnp3Uart(config resconfig.UartConfig) (io.ReadWriteCloser, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	serialPort, err := serial.Open(&serial.Config{
+		Address:  config.Uart,
+		BaudRate: config.BaudRate,
+		DataBits: config.DataBits,
+		Parity:   config.Parity,
+		StopBits: config.StopBits,
+		Timeout:  time.Duration(config.Timeout) * time.Millisecond,
+	})
+	if err != nil {
+		glogger.GLogger.Error("serial port start failed err:", err, ", config:", config)
+		return nil, err
+	}
+	return serialPort, nil
+}
+
+func (hd *Dnp3Master) Start(cctx typex.CCTX) error {
+	hd.Ctx = cctx.Ctx
+	hd.CancelCTX = cctx.CancelCTX
+	hd.reportFilter.Reset()
+	var conn io.ReadWriteCloser
+	timeout := 5 * time.Second
+	if hd.mainConfig.CommonConfig.Mode == "UART" {
+		serialPort, err := openDnp3Uart(hd.mainConfig.UartConfig)
+		if err != nil {
+			return err
+		}
+		conn = serialPort
+	} else {
+		if hd.mainConfig.HostConfig.Timeout > 0 {
+			timeout = time.Duration(hd.mainConfig.HostConfig.Timeout) * time.Millisecond
+		}
+		address := net.JoinHostPort(hd.mainConfig.HostConfig.Host,
+			fmt.Sprintf("%d", hd.mainConfig.HostConfig.Port))
+		tcpConn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return err
+		}
+		conn = tcpConn
+	}
+	master := dnp3.NewMaster(conn,
+		uint16(*hd.mainConfig.CommonConfig.LocalAddress),
+		uint16(*hd.mainConfig.CommonConfig.RemoteAddress),
+		timeout, hd.dataHandler)
+	master.Run()
+	hd.locker.Lock()
+	hd.master = master
+	hd.locker.Unlock()
+	go hd.schedule(master)
+	hd.status = typex.SOURCE_UP
+	return nil
+}
+
+/*
+*
+* 启动以后先总召唤, 然后按照配置允许主动上送, 周期召唤; 连接断开以后设备交给监控器重启
+*
+ */
+func (hd *Dnp3Master) schedule(master *dnp3.Master) {
+	defer master.Close()
+	if err := master.IntegrityPoll(); err != nil {
+		glogger.GLogger.Error("DNP3 integrity poll error:", err)
+	}
+	if *hd.mainConfig.CommonConfig.Unsolicited {
+		if err := master.EnableUnsolicited(); err != nil {
+			glogger.GLogger.Error("DNP3 enable unsolicited error:", err)
+		}
+	} else {
+		if err := master.DisableUnsolicited(); err != nil {
+			glogger.GLogger.Error("DNP3 disable unsolicited error:", err)
+		}
+	}
+	integrity := newIntervalTicker(*hd.mainConfig.CommonConfig.IntegrityInterval)
+	defer integrity.Stop()
+	eventPoll := newIntervalTicker(*hd.mainConfig.CommonConfig.EventInterval)
+	defer eventPoll.Stop()
+	for {
+		select {
+		case <-hd.Ctx.Done():
+			return
+		case <-master.Done():
+			glogger.GLogger.Error("DNP3 connection closed:", hd.PointId, master.Err())
+			hd.status = typex.SOURCE_DOWN
+			return
+		case <-integrity.C:
+			if err := master.IntegrityPoll(); err != nil {
+				glogger.GLogger.Error("DNP3 integrity poll error:", err)
+			}
+		case <-eventPoll.C:
+			if err := master.EventPoll(); err != nil {
+				glogger.GLogger.Error("DNP3 event poll error:", err)
+			}
+		}
+	}
+}
+
+/*
+*
+* 处理子站的数据, 召唤响应和主动上送都在这里
+*
+ */
+func (hd *Dnp3Master) dataHandler(points []dnp3.Point, unsolicited bool) {
+	LastFetchTime := uint64(time.Now().UnixMilli())
+	for _, p := range points {
+		dataPoints, ok := hd.points[dnp3PointKey{Type: p.Type, Index: p.Index}]
+		if !ok {
+			continue
+		}
+		valueStr := strconv.FormatFloat(p.Value, 'f', -1, 64)
+		var ts int64
+		if !p.Time.IsZero() {
+			ts = p.Time.UnixMilli()
+		}
+		for _, point := range dataPoints {
+			ErrMsg := ""
+			if !p.Online() {
+				ErrMsg = fmt.Sprintf("flags=0x%02X", p.Flags)
+			}
+			intercache.SetValue(hd.PointId, point.UUID, intercache.CacheValue{
+				UUID:          point.UUID,
+				Status:        1,
+				LastFetchTime: LastFetchTime,
+				Value:         valueStr,
+				ErrMsg:        ErrMsg,
+			})
+			// 事件不做过滤, 每一次变位都上报
+			if !p.Event && !hd.reportFilter.Allow(point.Tag, point.ReportPolicy, valueStr) {
+				continue
+			}
+			bytes, err := json.Marshal(Dnp3PointValue{
+				Type:        string(p.Type),
+				Index:       p.Index,
+				Tag:         point.Tag,
+				Alias:       point.Alias,
+				Value:       p.Value,
+				Flags:       p.Flags,
+				Event:       p.Event,
+				Ts:          ts,
+				Unsolicited: unsolicited,
+			})
+			if err != nil {
+				glogger.GLogger.Error(err)
+				continue
+			}
+			hd.RuleEngine.WorkDevice(hd.Details(), string(bytes))
+		}
+	}
+}
+
+func (hd *Dnp3Master) currentMaster() (*dnp3.Master, error) {
+	hd.locker.Lock()
+	defer hd.locker.Unlock()
+	if hd.master == nil {
+		return nil, fmt.Errorf("dnp3 master not connected")
+	}
+	select {
+	case <-hd.master.Done():
+		return nil, fmt.Errorf("dnp3 master not connected")
+	default:
+	}
+	return hd.master, nil
+}
+
+func (hd *Dnp3Master) Status() typex.SourceState {
+	return hd.status
+}
+
+func (hd *Dnp3Master) Stop() {
+	hd.status = typex.SOURCE_DOWN
+	if hd.CancelCTX != nil {
+		hd.CancelCTX()
+	}
+	hd.locker.Lock()
+	if hd.master != nil {
+		hd.master.Close()
+		hd.master = nil
+	}
+	hd.locker.Unlock()
+	intercache.UnRegisterSlot(hd.PointId)
+}
+
+func (hd *Dnp3Master) Details() *typex.Device {
+	return hd.RuleEngine.GetDevice(hd.PointId)
+}
+
+func (hd *Dnp3Master) SetState(status typex.SourceState) {
+	hd.status = status
+}
+
+func (hd *Dnp3Master) OnDCACall(UUID string, Command string, Args any) typex.DCAResult {
+	return typex.DCAResult{}
+}
+
+/*
+*
+* 外部控制指令:
+* - WriteWithTag: {"tag":"switch1","value":"1"} 遥控下发 CROB, 设定值下发模拟量输出
+* - IntegrityPoll: 立即总召唤一次
+* - EventPoll: 立即召唤一次 1/2/3 类事件
+*
+ */
+func (hd *Dnp3Master) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
+	glogger.Debug("Dnp3Master.OnCtrl, CMD=", string(cmd), ", Args=", string(args))
+	master, err := hd.currentMaster()
+	if err != nil {
+		return nil, err
+	}
+	switch string(cmd) {
+	case "IntegrityPoll":
+		return []byte{}, master.IntegrityPoll()
+	case "EventPoll":
+		return []byte{}, master.EventPoll()
+	case "WriteWithTag", "WriteToSheetRegisterWithTag":
+		ctrlCmd := CtrlCmd{}
+		if err := json.Unmarshal(args, &ctrlCmd); err != nil {
+			return nil, err
+		}
+		point, ok := hd.tags[ctrlCmd.Tag]
+		if !ok {
+			return nil, fmt.Errorf("data point not exists: %s", ctrlCmd.Tag)
+		}
+		if err := hd.command(master, point, ctrlCmd.Value); err != nil {
+			return nil, err
+		}
+		return []byte{}, nil
+	}
+	return nil, fmt.Errorf("unsupported command: %s", string(cmd))
+}
+
+// 按照测点类型下发遥控或者设定值
+func (hd *Dnp3Master) command(master *dnp3.Master, point dnp3DataPoint, value string) error {
+	value = strings.TrimSpace(value)
+	sbo := hd.mainConfig.CommonConfig.CommandMode == "SBO"
+	switch dnp3.PointType(point.PointType) {
+	case dnp3.BINARY_OUTPUT:
+		var on bool
+		switch strings.ToLower(value) {
+		case "1", "true", "on":
+			on = true
+		case "0", "false", "off":
+			on = false
+		default:
+			return fmt.Errorf("invalid command value: %s", value)
+		}
+		crob := dnp3.Crob{Code: dnp3.CROB_LATCH_OFF, Count: 1}
+		if on {
+			crob.Code = dnp3.CROB_LATCH_ON
+		}
+		if hd.mainConfig.CommonConfig.CrobMode == "PULSE" {
+			crob.Code = dnp3.CROB_PULSE_OFF
+			if on {
+				crob.Code = dnp3.CROB_PULSE_ON
+			}
+			crob.OnTime = uint32(hd.mainConfig.CommonConfig.PulseTime)
+		}
+		return master.Crob(point.PointIndex, crob, sbo)
+	case dnp3.ANALOG_OUTPUT:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid setpoint value: %s", value)
+		}
+		// 整数用 32 位整数下发, 其他用单精度浮点
+		variation := byte(3)
+		if f == math.Trunc(f) && f >= math.MinInt32 && f <= math.MaxInt32 {
+			variation = 1
+		}
+		return master.AnalogOutput(point.PointIndex, variation, f, sbo)
+	}
+	return fmt.Errorf("data point is not writable: %s, type=%s", point.Tag, point.PointType)
+}
@@ -0,0 +1,445 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/gatewaytag"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/device/dnp3"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/resconfig"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+type Dnp3OutstationCommonConfig struct {
+	Mode          string `json:"mode" validate:"required"`          // TCP | UART
+	LocalAddress  *int   `json:"localAddress" validate:"required"`  // 子站链路地址
+	RemoteAddress *int   `json:"remoteAddress" validate:"required"` // 主站链路地址
+	Unsolicited   *bool  `json:"unsolicited" validate:"required"`   // 是否支持主动上送
+	Frequency     int    `json:"frequency"`                         // 从来源设备刷新数据的周期, 毫秒
+}
+
+type Dnp3OutstationMainConfig struct {
+	CommonConfig Dnp3OutstationCommonConfig `json:"commonConfig" validate:"required"`
+	HostConfig   resconfig.HostConfig       `json:"hostConfig"` // TCP 模式监听的地址
+	UartConfig   resconfig.UartConfig       `json:"uartConfig"`
+}
+
+// 子站测点, 数据来自网关里面其他设备的点位
+type dnp3OutstationPoint struct {
+	UUID         string  `json:"-"`
+	PointType    string  `json:"pointType"`
+	PointIndex   uint16  `json:"pointIndex"`
+	Tag          string  `json:"tag"`
+	Alias        string  `json:"alias"`
+	EventClass   int     `json:"eventClass"`
+	SourceDevice string  `json:"sourceDevice"`
+	SourceTag    string  `json:"sourceTag"`
+	Deadband     float64 `json:"deadband"`
+}
+
+// 上报的主站命令
+type Dnp3OutstationCommand struct {
+	Type   string  `json:"type"` // BINARY_OUTPUT | ANALOG_OUTPUT
+	Index  uint16  `json:"index"`
+	Tag    string  `json:"tag"`
+	Alias  string  `json:"alias"`
+	Value  float64 `json:"value"`
+	Status byte    `json:"status"` // 命令状态, 0 为成功
+}
+
+// 来源设备的点位缓存多久重新加载一次
+const dnp3SourceTagsTTL = 10 * time.Second
+
+type Dnp3Outstation struct {
+	typex.XStatus
+	status     typex.SourceState
+	mainConfig Dnp3OutstationMainConfig
+	outstation *dnp3.Outstation
+	points     map[dnp3PointKey]dnp3OutstationPoint
+	locker     sync.Mutex
+	listener   net.Listener
+	sources    map[string]map[string]gatewaytag.Tag // 来源设备的点位, 按照名称索引
+	loadedAt   time.Time
+}
+
+func NewDnp3Outstation(e typex.Rhilex) typex.XDevice {
+	hd := new(Dnp3Outstation)
+	hd.RuleEngine = e
+	hd.mainConfig = Dnp3OutstationMainConfig{
+		CommonConfig: Dnp3OutstationCommonConfig{
+			Mode: "TCP",
+			LocalAddress: func() *int {
+				a := 10
+				return &a
+			}(),
+			RemoteAddress: func() *int {
+				a := 1
+				return &a
+			}(),
+			Unsolicited: func() *bool {
+				b := true
+				return &b
+			}(),
+			Frequency: 1000,
+		},
+		HostConfig: resconfig.HostConfig{
+			Host:    "0.0.0.0",
+			Port:    20000,
+			Timeout: 5000,
+		},
+		UartConfig: resconfig.UartConfig{
+			Timeout:  3000,
+			Uart:     "/dev/ttyS1",
+			BaudRate: 9600,
+			DataBits: 8,
+			Parity:   "N",
+			StopBits: 1,
+		},
+	}
+	hd.points = map[dnp3PointKey]dnp3OutstationPoint{}
+	hd.sources = map[string]map[string]gatewaytag.Tag{}
+	return hd
+}
+
+func (hd *Dnp3Outstation) Init(devId string, configMap map[string]any) error {
+	hd.PointId = devId
+	intercache.RegisterSlot(devId)
+	if err := utils.BindSourceConfig(configMap, &hd.mainConfig); err != nil {
+		glogger.GLogger.Error(err)
+		return err
+	}
+	if err := checkDnp3Link(hd.mainConfig.CommonConfig.Mode,
+		*hd.mainConfig.CommonConfig.LocalAddress,
+		*hd.mainConfig.CommonConfig.RemoteAddress); err != nil {
+		return err
+	}
+	if hd.mainConfig.CommonConfig.Frequency < 50 {
+		return errors.New("'frequency' must grate than 50 millisecond")
+	}
+	if hd.mainConfig.CommonConfig.Mode == "TCP" {
+		if err := hd.mainConfig.HostConfig.Validate(); err != nil {
+			return err
+		}
+	}
+	hd.outstation = dnp3.NewOutstation(dnp3.OutstationConfig{
+		Local:       uint16(*hd.mainConfig.CommonConfig.LocalAddress),
+		Remote:      uint16(*hd.mainConfig.CommonConfig.RemoteAddress),
+		Unsolicited: *hd.mainConfig.CommonConfig.Unsolicited,
+	}, hd.commandHandler)
+	dataPoints := []dnp3OutstationPoint{}
+	if err := interdb.InterDb().Table("m_dnp3_data_points").
+		Where("device_uuid=?", devId).Find(&dataPoints).Error; err != nil {
+		return err
+	}
+	LastFetchTime := uint64(time.Now().UnixMilli())
+	for _, point := range dataPoints {
+		t := dnp3.PointType(point.PointType)
+		if point.EventClass < 0 || point.EventClass > 3 {
+			return fmt.Errorf("invalid event class: %s, %d", point.Tag, point.EventClass)
+		}
+		if err := hd.outstation.AddPoint(t, point.PointIndex,
+			byte(point.EventClass), point.Deadband); err != nil {
+			return err
+		}
+		hd.points[dnp3PointKey{Type: t, Index: point.PointIndex}] = point
+		intercache.SetValue(hd.PointId, point.UUID, intercache.CacheValue{
+			UUID:          point.UUID,
+			Status:        0,
+			LastFetchTime: LastFetchTime,
+			Value:         "",
+			ErrMsg:        "--",
+		})
+	}
+	return nil
+}
+
+func (hd *Dnp3Outstation) Start(cctx typex.CCTX) error {
+	hd.Ctx = cctx.Ctx
+	hd.CancelCTX = cctx.CancelCTX
+	if hd.mainConfig.CommonConfig.Mode == "UART" {
+		serialPort, err := openDnp3Uart(hd.mainConfig.UartConfig)
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer serialPort.Close()
+			if err := hd.outstation.Serve(hd.Ctx, serialPort); err != nil {
+				glogger.GLogger.Error("DNP3 outstation serial error:", hd.PointId, err)
+				hd.status = typex.SOURCE_DOWN
+			}
+		}()
+	} else {
+		address := net.JoinHostPort(hd.mainConfig.HostConfig.Host,
+			fmt.Sprintf("%d", hd.mainConfig.HostConfig.Port))
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return err
+		}
+		hd.locker.Lock()
+		hd.listener = listener
+		hd.locker.Unlock()
+		go hd.accept(listener)
+	}
+	go hd.refresh()
+	hd.status = typex.SOURCE_UP
+	return nil
+}
+
+// 每个主站连接一个会话
+func (hd *Dnp3Outstation) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-hd.Ctx.Done():
+			default:
+				glogger.GLogger.Error("DNP3 outstation accept error:", hd.PointId, err)
+				hd.status = typex.SOURCE_DOWN
+			}
+			return
+		}
+		glogger.GLogger.Info("DNP3 master connected:", conn.RemoteAddr().String())
+		go func(conn net.Conn) {
+			defer conn.Close()
+			if err := hd.outstation.Serve(hd.Ctx, conn); err != nil {
+				glogger.GLogger.Info("DNP3 master disconnected:", conn.RemoteAddr().String(), err)
+			}
+		}(conn)
+	}
+}
+
+/*
+*
+* 周期从来源设备的缓存取最新数据更新测点, 值变化的时候子站自己产生事件
+*
+ */
+func (hd *Dnp3Outstation) refresh() {
+	ticker := time.NewTicker(time.Duration(hd.mainConfig.CommonConfig.Frequency) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		hd.updatePoints()
+		select {
+		case <-hd.Ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (hd *Dnp3Outstation) updatePoints() {
+	if time.Since(hd.loadedAt) > dnp3SourceTagsTTL {
+		hd.sources = map[string]map[string]gatewaytag.Tag{}
+		hd.loadedAt = time.Now()
+	}
+	for key, point := range hd.points {
+		if point.SourceDevice == "" {
+			continue
+		}
+		tag, ok := hd.sourceTag(point.SourceDevice, point.SourceTag)
+		if !ok {
+			hd.outstation.Update(key.Type, key.Index, 0, dnp3.FLAG_COMM_LOST, time.Time{})
+			hd.setCache(point, "", fmt.Sprintf("source tag not exists: %s", point.SourceTag))
+			continue
+		}
+		cache := gatewaytag.Read(point.SourceDevice, tag)
+		if cache.Waiting() {
+			continue
+		}
+		current, _ := hd.outstation.Value(key.Type, key.Index)
+		value := current.Value
+		flags := dnp3.FLAG_ONLINE
+		ErrMsg := ""
+		if !cache.Good() {
+			flags = dnp3.FLAG_COMM_LOST
+			ErrMsg = cache.ErrMsg
+			if ErrMsg == "" {
+				ErrMsg = "source tag is offline"
+			}
+		} else if v, err := parseDnp3Value(cache.Value); err == nil {
+			value = v
+		} else {
+			flags = dnp3.FLAG_COMM_LOST
+			ErrMsg = err.Error()
+		}
+		hd.outstation.Update(key.Type, key.Index, value, flags, cache.Timestamp)
+		hd.setCache(point, strconv.FormatFloat(value, 'f', -1, 64), ErrMsg)
+	}
+}
+
+func (hd *Dnp3Outstation) setCache(point dnp3OutstationPoint, value, ErrMsg string) {
+	Status := 1
+	if ErrMsg != "" {
+		Status = 0
+	}
+	intercache.SetValue(hd.PointId, point.UUID, intercache.CacheValue{
+		UUID:          point.UUID,
+		Status:        Status,
+		LastFetchTime: uint64(time.Now().UnixMilli()),
+		Value:         value,
+		ErrMsg:        ErrMsg,
+	})
+}
+
+// 来源设备的点位, 按照设备缓存
+func (hd *Dnp3Outstation) sourceTag(deviceId, name string) (gatewaytag.Tag, bool) {
+	tags, ok := hd.sources[deviceId]
+	if !ok {
+		tags = map[string]gatewaytag.Tag{}
+		if device := hd.RuleEngine.GetDevice(deviceId); device != nil {
+			list, err := gatewaytag.Load(device)
+			if err != nil {
+				glogger.GLogger.Error("DNP3 outstation load source tags error:", deviceId, err)
+			}
+			for _, tag := range list {
+				tags[tag.Name] = tag
+			}
+		}
+		hd.sources[deviceId] = tags
+	}
+	tag, ok := tags[name]
+	return tag, ok
+}
+
+// 开关量按照 true/false 解析, 其他按照数值解析
+func parseDnp3Value(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(value) {
+	case "true", "on":
+		return 1, nil
+	case "false", "off":
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value: %s", value)
+	}
+	return f, nil
+}
+
+/*
+*
+* 主站的遥控和设定值写到来源设备的点位, 同时把命令交给规则
+*
+ */
+func (hd *Dnp3Outstation) commandHandler(cmd dnp3.Command) byte {
+	point, ok := hd.points[dnp3PointKey{Type: cmd.Type, Index: cmd.Index}]
+	if !ok {
+		return dnp3.STATUS_NOT_SUPPORTED
+	}
+	status := dnp3.STATUS_NOT_SUPPORTED
+	if point.SourceDevice != "" {
+		status = dnp3.STATUS_SUCCESS
+		value := strconv.FormatFloat(cmd.Value, 'f', -1, 64)
+		err := gatewaytag.Write(hd.RuleEngine, point.SourceDevice,
+			gatewaytag.Tag{Name: point.SourceTag, Writable: true}, value)
+		if err != nil {
+			glogger.GLogger.Error("DNP3 outstation command error:", point.Tag, err)
+			status = dnp3.STATUS_HARDWARE
+		} else {
+			flags := dnp3.FLAG_ONLINE
+			hd.outstation.Update(cmd.Type, cmd.Index, cmd.Value, flags, time.Time{})
+		}
+	}
+	bytes, err := json.Marshal(Dnp3OutstationCommand{
+		Type:   string(cmd.Type),
+		Index:  cmd.Index,
+		Tag:    point.Tag,
+		Alias:  point.Alias,
+		Value:  cmd.Value,
+		Status: status,
+	})
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return status
+	}
+	hd.RuleEngine.WorkDevice(hd.Details(), string(bytes))
+	return status
+}
+
+func (hd *Dnp3Outstation) Status() typex.SourceState {
+	return hd.status
+}
+
+func (hd *Dnp3Outstation) Stop() {
+	hd.status = typex.SOURCE_DOWN
+	if hd.CancelCTX != nil {
+		hd.CancelCTX()
+	}
+	hd.locker.Lock()
+	if hd.listener != nil {
+		hd.listener.Close()
+		hd.listener = nil
+	}
+	hd.locker.Unlock()
+	intercache.UnRegisterSlot(hd.PointId)
+}
+
+func (hd *Dnp3Outstation) Details() *typex.Device {
+	return hd.RuleEngine.GetDevice(hd.PointId)
+}
+
+func (hd *Dnp3Outstation) SetState(status typex.SourceState) {
+	hd.status = status
+}
+
+func (hd *Dnp3Outstation) OnDCACall(UUID string, Command string, Args any) typex.DCAResult {
+	return typex.DCAResult{}
+}
+
+/*
+*
+* 外部控制指令:
+* - WriteWithTag: {"tag":"breaker","value":"1"} 直接更新没有来源设备的测点, 变化的时候产生事件
+*
+ */
+func (hd *Dnp3Outstation) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
+	glogger.Debug("Dnp3Outstation.OnCtrl, CMD=", string(cmd), ", Args=", string(args))
+	if string(cmd) != "WriteWithTag" {
+		return nil, fmt.Errorf("unsupported command: %s", string(cmd))
+	}
+	ctrlCmd := CtrlCmd{}
+	if err := json.Unmarshal(args, &ctrlCmd); err != nil {
+		return nil, err
+	}
+	for key, point := range hd.points {
+		if point.Tag != ctrlCmd.Tag {
+			continue
+		}
+		if point.SourceDevice != "" {
+			return nil, fmt.Errorf("data point is bound to source device: %s", point.Tag)
+		}
+		value, err := parseDnp3Value(ctrlCmd.Value)
+		if err != nil {
+			return nil, err
+		}
+		hd.outstation.Update(key.Type, key.Index, value, dnp3.FLAG_ONLINE, time.Time{})
+		hd.setCache(point, strconv.FormatFloat(value, 'f', -1, 64), "")
+		return []byte{}, nil
+	}
+	return nil, fmt.Errorf("data point not exists: %s", ctrlCmd.Tag)
+}
@@ -126,7 +126,7 @@ func (br *BacnetRouter) Init(devId string, configMap map[string]any) error {
 		// Cache Value
 		intercache.SetValue(br.PointId, mDataPoint.UUID, intercache.CacheValue{
 			UUID:          mDataPoint.UUID,
-			Status:        0, // 路由模式下点位默认就是正常的
+			Status:        1, // 路由模式下点位默认就是正常的
 			LastFetchTime: uint64(time.Now().UnixMilli()),
 			Value:         "0",
 			ErrMsg:        "",
@@ -229,7 +229,7 @@ func (br *BacnetRouter) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
 			}
 			intercache.SetValue(br.PointId, DataKey.UUID, intercache.CacheValue{
 				UUID:          DataKey.UUID,
-				Status:        0,
+				Status:        1,
 				LastFetchTime: uint64(time.Now().UnixMilli()),
 				Value:         fmt.Sprintf("%f", setValue.Value),
 				ErrMsg:        "",
@@ -6,15 +6,18 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/hootrhino/rhilex/alarmcenter"
 	"github.com/hootrhino/rhilex/component/apiserver/model"
 	"github.com/hootrhino/rhilex/component/intercache"
 	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/device/bacnet"
 	"github.com/hootrhino/rhilex/resconfig"
 
-	bacnet "github.com/hootrhino/gobacnet"
+	gobacnet "github.com/hootrhino/gobacnet"
 	"github.com/hootrhino/gobacnet/apdus"
 	"github.com/hootrhino/gobacnet/btypes"
 
@@ -23,9 +26,19 @@ import (
 	"github.com/hootrhino/rhilex/utils"
 )
 
+// COV 订阅模式
+const (
+	BACNET_COV_NONE        string = "NONE"        // 不订阅, 只轮询
+	BACNET_COV_UNCONFIRMED string = "UNCONFIRMED" // 非确认型通知
+	BACNET_COV_CONFIRMED   string = "CONFIRMED"   // 确认型通知
+)
+
 type bacnetCommonConfig struct {
-	BatchRequest *bool `json:"batchRequest"` // 批量采集
-	Frequency    int   `json:"frequency"`
+	BatchRequest *bool  `json:"batchRequest"` // 批量采集
+	Frequency    int    `json:"frequency"`
+	CovMode      string `json:"covMode"`     // NONE | UNCONFIRMED | CONFIRMED
+	CovLifetime  uint32 `json:"covLifetime"` // 订阅有效期, 单位秒, 过半的时候续订
+	CovPort      int    `json:"covPort"`     // 接收 COV 通知的本地端口, 0 为随机端口
 }
 type bacnetConfig struct {
 	Mode        string `json:"mode" validate:"required"` // IP/MSTP
@@ -53,7 +66,7 @@ type BacnetMainConfig struct {
 }
 type GenericBacnetIpDevice struct {
 	typex.XStatus
-	bacnetClient bacnet.Client
+	bacnetClient gobacnet.Client
 	status       typex.SourceState
 	RuleEngine   typex.Rhilex
 	mainConfig   BacnetMainConfig
@@ -61,6 +74,10 @@ type GenericBacnetIpDevice struct {
 	SubDeviceDataPoints []bacnetDataPoint           // 读到子设备的点位
 	SelfPropertyData    map[uint32][2]btypes.Object // 自己的点位
 	remoteDeviceMap     map[uint32]btypes.Device
+	// COV 订阅
+	covSubscriber *bacnet.Subscriber
+	covLocker     sync.Mutex
+	covSubscribed map[string]bacnetCovState // 点位UUID -> 订阅状态
 }
 
 func NewGenericBacnetIpDevice(e typex.Rhilex) typex.XDevice {
@@ -73,6 +90,8 @@ func NewGenericBacnetIpDevice(e typex.Rhilex) typex.XDevice {
 				b := false
 				return &b
 			}(),
+			CovMode:     BACNET_COV_NONE,
+			CovLifetime: 300,
 		},
 		BacnetConfig: bacnetConfig{
 			Mode:      "BROADCAST",
@@ -98,6 +117,7 @@ func NewGenericBacnetIpDevice(e typex.Rhilex) typex.XDevice {
 		},
 	}
 	g.SubDeviceDataPoints = make([]bacnetDataPoint, 0)
+	g.covSubscribed = map[string]bacnetCovState{}
 	g.status = typex.SOURCE_DOWN
 	return g
 }
@@ -112,6 +132,15 @@ func (dev *GenericBacnetIpDevice) Init(devId string, configMap map[string]any) e
 	if err != nil {
 		return err
 	}
+	switch dev.mainConfig.CommonConfig.CovMode {
+	case BACNET_COV_NONE:
+	case BACNET_COV_UNCONFIRMED, BACNET_COV_CONFIRMED:
+		if dev.mainConfig.CommonConfig.CovLifetime < 30 {
+			return fmt.Errorf("covLifetime must be at least 30 seconds")
+		}
+	default:
+		return fmt.Errorf("invalid covMode: %s", dev.mainConfig.CommonConfig.CovMode)
+	}
 	var dataPoints []model.MBacnetDataPoint
 	err = interdb.InterDb().Table("m_bacnet_data_points").
 		Where("device_uuid=?", devId).Find(&dataPoints).Error
@@ -200,7 +229,7 @@ func (dev *GenericBacnetIpDevice) Start(cctx typex.CCTX) error {
 			return errParseCIDR
 		}
 		MaskSize, _ := IPNet.Mask.Size()
-		client, err := bacnet.NewClient(&bacnet.ClientBuilder{
+		client, err := gobacnet.NewClient(&gobacnet.ClientBuilder{
 			Ip:           IP.String(),
 			SubnetCIDR:   MaskSize,
 			Port:         dev.mainConfig.BacnetConfig.LocalPort,
@@ -216,6 +245,13 @@ func (dev *GenericBacnetIpDevice) Start(cctx typex.CCTX) error {
 		dev.bacnetClient = client
 		client.SetLogger(glogger.GLogger.Logger)
 		go dev.bacnetClient.StartPoll(dev.Ctx)
+		if dev.mainConfig.CommonConfig.CovMode != BACNET_COV_NONE {
+			if err := dev.startCOV(dev.Ctx); err != nil {
+				client.ClientClose(false)
+				client.Close()
+				return err
+			}
+		}
 		go func(ctx context.Context) {
 			// 定时刷新device列表 后续可以优化下逻辑
 			ticker := time.NewTicker(5 * time.Second)
@@ -224,7 +260,7 @@ func (dev *GenericBacnetIpDevice) Start(cctx typex.CCTX) error {
 				select {
 				case <-ticker.C:
 					/// 迁移配置到前端控制
-					devices, err := client.WhoIs(&bacnet.WhoIsOpts{
+					devices, err := client.WhoIs(&gobacnet.WhoIsOpts{
 						Low:             -1,
 						High:            -1,
 						GlobalBroadcast: true,
@@ -317,6 +353,10 @@ func (dev *GenericBacnetIpDevice) ReadProperty() []ReadBacnetValue {
 		} else {
 			bacnetDeviceId = SubDeviceDataPoint.BacnetDeviceId
 		}
+		// 订阅成功的点位由 COV 通知上报, 不再轮询
+		if dev.covActive(SubDeviceDataPoint.UUID) {
+			continue
+		}
 		if device, ok := dev.remoteDeviceMap[bacnetDeviceId]; ok {
 			property, err := dev.bacnetClient.ReadProperty(device, SubDeviceDataPoint.property)
 			if err != nil {
@@ -373,16 +413,13 @@ func (dev *GenericBacnetIpDevice) OnWrite(cmd []byte, data []byte) (int, error)
 	return 0, errors.New("not Support")
 }
 
-func (dev *GenericBacnetIpDevice) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
-	return nil, errors.New("not Support")
-}
-
 func (dev *GenericBacnetIpDevice) Status() typex.SourceState {
 	return dev.status
 }
 
 func (dev *GenericBacnetIpDevice) Stop() {
 	dev.status = typex.SOURCE_DOWN
+	dev.stopCOV()
 	if dev.CancelCTX != nil {
 		dev.CancelCTX()
 	}
@@ -404,3 +441,294 @@ func (dev *GenericBacnetIpDevice) SetState(state typex.SourceState) {
 func (dev *GenericBacnetIpDevice) OnDCACall(UUID string, Command string, Args any) typex.DCAResult {
 	return typex.DCAResult{}
 }
+
+/*
+*
+* COV 订阅: 通知到达之后直接上报; 订阅失败或者设备不支持 COV 的点位继续轮询,
+* 过了有效期的一半之后重新订阅
+*
+ */
+type bacnetCovState struct {
+	attemptAt  time.Time
+	subscribed bool
+}
+
+func (dev *GenericBacnetIpDevice) startCOV(ctx context.Context) error {
+	subscriber, err := bacnet.NewSubscriber(dev.mainConfig.CommonConfig.CovPort,
+		glogger.GLogger, dev.onCOVNotification)
+	if err != nil {
+		return err
+	}
+	dev.covSubscriber = subscriber
+	go subscriber.Run(ctx)
+	go func(ctx context.Context) {
+		// 设备列表是 Who-Is 定时刷新的, 这里跟着定时检查
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				dev.renewCOV()
+			}
+		}
+	}(ctx)
+	return nil
+}
+
+func (dev *GenericBacnetIpDevice) covSubscription(point bacnetDataPoint) bacnet.COVSubscription {
+	return bacnet.COVSubscription{
+		ProcessId:  dev.mainConfig.BacnetConfig.DeviceId,
+		ObjectType: uint16(point.ObjectType),
+		Instance:   point.ObjectId,
+		Confirmed:  dev.mainConfig.CommonConfig.CovMode == BACNET_COV_CONFIRMED,
+		Lifetime:   dev.mainConfig.CommonConfig.CovLifetime,
+	}
+}
+
+func (dev *GenericBacnetIpDevice) renewCOV() {
+	renewAfter := time.Duration(dev.mainConfig.CommonConfig.CovLifetime) * time.Second / 2
+	for _, point := range dev.SubDeviceDataPoints {
+		dev.covLocker.Lock()
+		state, ok := dev.covSubscribed[point.UUID]
+		dev.covLocker.Unlock()
+		if ok && time.Since(state.attemptAt) < renewAfter {
+			continue
+		}
+		device, ok := dev.remoteDeviceMap[point.BacnetDeviceId]
+		if !ok {
+			continue
+		}
+		target, err := bacnet.TargetOf(device)
+		if err == nil {
+			err = dev.covSubscriber.Subscribe(target, dev.covSubscription(point))
+		}
+		if err != nil {
+			glogger.GLogger.Warnf("bacnet subscribe cov failed, fallback to polling. tag = %v, err=%v",
+				point.Tag, err)
+		}
+		dev.covLocker.Lock()
+		dev.covSubscribed[point.UUID] = bacnetCovState{attemptAt: time.Now(), subscribed: err == nil}
+		dev.covLocker.Unlock()
+	}
+}
+
+func (dev *GenericBacnetIpDevice) covActive(uuid string) bool {
+	dev.covLocker.Lock()
+	defer dev.covLocker.Unlock()
+	return dev.covSubscribed[uuid].subscribed
+}
+
+// 停止的时候取消订阅, 设备不在线也不影响
+func (dev *GenericBacnetIpDevice) stopCOV() {
+	if dev.covSubscriber == nil {
+		return
+	}
+	dev.covSubscriber.Timeout = time.Second
+	for _, point := range dev.SubDeviceDataPoints {
+		if !dev.covActive(point.UUID) {
+			continue
+		}
+		if device, ok := dev.remoteDeviceMap[point.BacnetDeviceId]; ok {
+			if target, err := bacnet.TargetOf(device); err == nil {
+				dev.covSubscriber.Cancel(target, dev.covSubscription(point))
+			}
+		}
+	}
+	dev.covSubscriber.Close()
+	dev.covSubscriber = nil
+	dev.covLocker.Lock()
+	dev.covSubscribed = map[string]bacnetCovState{}
+	dev.covLocker.Unlock()
+}
+
+func (dev *GenericBacnetIpDevice) onCOVNotification(n bacnet.COVNotification) {
+	value, ok := n.PresentValue()
+	if !ok {
+		return
+	}
+	for _, point := range dev.SubDeviceDataPoints {
+		if point.BacnetDeviceId != n.DeviceId ||
+			uint16(point.ObjectType) != n.ObjectType ||
+			point.ObjectId != n.Instance {
+			continue
+		}
+		readValue := ReadBacnetValue{
+			Tag:              point.Tag,
+			DeviceId:         n.DeviceId,
+			PropertyType:     point.ObjectType.String(),
+			PropertyInstance: n.Instance,
+			Value:            value,
+		}
+		dev.bacnetClient.GetBacnetIPServer().UpdateAIPropertyValue(point.ObjectId, value)
+		intercache.SetValue(dev.PointId, point.UUID, intercache.CacheValue{
+			UUID:          point.UUID,
+			Status:        1,
+			LastFetchTime: uint64(time.Now().UnixMilli()),
+			Value:         fmt.Sprintf("%v", value),
+			ErrMsg:        "",
+		})
+		var report any = readValue
+		if *dev.mainConfig.CommonConfig.BatchRequest {
+			report = []ReadBacnetValue{readValue}
+		}
+		if bytes, err := json.Marshal(report); err != nil {
+			glogger.GLogger.Error(err)
+		} else {
+			dev.RuleEngine.WorkDevice(dev.Details(), string(bytes))
+		}
+	}
+}
+
+/*
+*
+* 控制指令:
+* - WriteProperty: {"tag":"ao1","value":"21.5","priority":8}, 写 present-value
+* - Relinquish: {"tag":"ao1","priority":8}, 释放这个优先级上面写的值
+* - WhoIs: {"low":-1,"high":-1}, 广播 Who-Is, 返回找到的设备
+* - ReadObjectList: {"bacnetDeviceId":1}, 读设备的对象列表, 用来导入点位
+*
+ */
+type bacnetWriteCmd struct {
+	Tag      string `json:"tag"`
+	Value    string `json:"value"`
+	Priority int    `json:"priority"`
+}
+
+type BacnetRemoteDevice struct {
+	DeviceId      uint32 `json:"deviceId"`
+	Ip            string `json:"ip"`
+	Port          int    `json:"port"`
+	NetworkNumber int    `json:"networkNumber"`
+	MacMstp       int    `json:"macMstp"`
+	VendorId      uint32 `json:"vendorId"`
+	MaxApdu       uint32 `json:"maxApdu"`
+}
+
+type BacnetRemoteObject struct {
+	ObjectType  string `json:"objectType"`
+	ObjectId    uint32 `json:"objectId"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func (dev *GenericBacnetIpDevice) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
+	glogger.Debug("GenericBacnetIpDevice.OnCtrl, CMD=", string(cmd), ", Args=", string(args))
+	if dev.bacnetClient == nil {
+		return nil, errors.New("bacnet client not ready")
+	}
+	switch string(cmd) {
+	case "WriteProperty":
+		return nil, dev.writePresentValue(args, false)
+	case "Relinquish":
+		return nil, dev.writePresentValue(args, true)
+	case "WhoIs":
+		return dev.whoIs(args)
+	case "ReadObjectList":
+		return dev.readObjectList(args)
+	}
+	return nil, fmt.Errorf("unsupported command: %s", string(cmd))
+}
+
+func (dev *GenericBacnetIpDevice) writePresentValue(args []byte, relinquish bool) error {
+	writeCmd := bacnetWriteCmd{}
+	if err := json.Unmarshal(args, &writeCmd); err != nil {
+		return err
+	}
+	if writeCmd.Priority == 0 {
+		writeCmd.Priority = bacnet.DefaultPriority
+	}
+	if err := bacnet.CheckPriority(writeCmd.Priority); err != nil {
+		return err
+	}
+	for _, point := range dev.SubDeviceDataPoints {
+		if point.Tag != writeCmd.Tag {
+			continue
+		}
+		var value any
+		if !relinquish {
+			v, err := bacnet.PresentValueOf(point.ObjectType, writeCmd.Value)
+			if err != nil {
+				return err
+			}
+			value = v
+		}
+		device, ok := dev.remoteDeviceMap[point.BacnetDeviceId]
+		if !ok {
+			return fmt.Errorf("bacnet device not found: %d", point.BacnetDeviceId)
+		}
+		return dev.bacnetClient.WriteProperty(device,
+			bacnet.WritePresentValue(point.ObjectType, point.ObjectId, value, writeCmd.Priority))
+	}
+	return fmt.Errorf("tag not exists: %s", writeCmd.Tag)
+}
+
+func (dev *GenericBacnetIpDevice) whoIs(args []byte) ([]byte, error) {
+	opts := gobacnet.WhoIsOpts{Low: -1, High: -1}
+	if len(args) > 0 {
+		if err := json.Unmarshal(args, &opts); err != nil {
+			return nil, err
+		}
+	}
+	opts.GlobalBroadcast = true
+	devices, err := dev.bacnetClient.WhoIs(&opts)
+	if err != nil {
+		return nil, err
+	}
+	remoteDevices := []BacnetRemoteDevice{}
+	for _, device := range devices {
+		remoteDevices = append(remoteDevices, BacnetRemoteDevice{
+			DeviceId:      uint32(device.DeviceID),
+			Ip:            device.Ip,
+			Port:          device.Port,
+			NetworkNumber: device.NetworkNumber,
+			MacMstp:       device.MacMSTP,
+			VendorId:      device.Vendor,
+			MaxApdu:       device.MaxApdu,
+		})
+	}
+	sort.Slice(remoteDevices, func(i, j int) bool {
+		return remoteDevices[i].DeviceId < remoteDevices[j].DeviceId
+	})
+	return json.Marshal(remoteDevices)
+}
+
+func (dev *GenericBacnetIpDevice) readObjectList(args []byte) ([]byte, error) {
+	form := struct {
+		BacnetDeviceId uint32 `json:"bacnetDeviceId"`
+	}{}
+	if err := json.Unmarshal(args, &form); err != nil {
+		return nil, err
+	}
+	device, ok := dev.remoteDeviceMap[form.BacnetDeviceId]
+	if !ok {
+		return nil, fmt.Errorf("bacnet device not found: %d", form.BacnetDeviceId)
+	}
+	device, err := dev.bacnetClient.Objects(device)
+	if err != nil {
+		return nil, err
+	}
+	objects := []BacnetRemoteObject{}
+	for objectType, instances := range device.Objects {
+		name, ok := bacnet.ObjectTypeName(objectType)
+		if !ok {
+			continue
+		}
+		for instance, object := range instances {
+			objects = append(objects, BacnetRemoteObject{
+				ObjectType:  name,
+				ObjectId:    uint32(instance),
+				Name:        object.Name,
+				Description: object.Description,
+			})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].ObjectType != objects[j].ObjectType {
+			return objects[i].ObjectType < objects[j].ObjectType
+		}
+		return objects[i].ObjectId < objects[j].ObjectId
+	})
+	return json.Marshal(objects)
+}
@@ -1,3 +1,5 @@
+//go:build !gocv
+
 // Copyright (C) 2023 wwhai
 //
 // This program is free software: you can redistribute it and/or modify
@@ -16,38 +18,380 @@
 package device
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/aibase"
+	"github.com/hootrhino/rhilex/device/camera"
+	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
 )
 
-type videoCamera struct {
-	typex.XStatus
-	status typex.SourceState
+const (
+	__CAMERA_INPUT_RTSP       = "RTSP"       // RTSP 拉流, 依赖 ffmpeg
+	__CAMERA_INPUT_HTTP_MJPEG = "HTTP_MJPEG" // HTTP multipart MJPEG 流
+	__CAMERA_INPUT_HTTP_JPEG  = "HTTP_JPEG"  // HTTP 抓图地址, 按照采样帧率轮询
+)
+
+type VideoCameraCommonConfig struct {
+	InputMode        string  `json:"inputMode" validate:"required"` // RTSP | HTTP_MJPEG | HTTP_JPEG
+	InputAddr        string  `json:"inputAddr" validate:"required"` // 视频地址
+	SampleFps        float64 `json:"sampleFps"`                     // 采样帧率, RTSP 按照这个帧率解码
+	SnapshotInterval int     `json:"snapshotInterval"`              // 定时截图周期, 秒, 0 表示只手动截图
+	HealthInterval   int     `json:"healthInterval"`                // 上报流状态的周期, 秒, 0 表示不上报
+	StaleTimeout     int     `json:"staleTimeout"`                  // 超过这个时间没有新帧认为流断开, 秒
+	Timeout          int     `json:"timeout"`                       // 连接超时, 毫秒
+	ReportImage      *bool   `json:"reportImage"`                   // 截图是否以 base64 交给规则
+	Algorithm        string  `json:"algorithm"`                     // 截图交给 aibase 算法处理, 算法的 UUID
+}
+
+type VideoCameraSnapshotConfig struct {
+	Dir         string `json:"dir"`         // 截图目录, 实际目录为 dir/设备ID
+	MaxCount    int    `json:"maxCount"`    // 最多保存的数量, 0 表示不限制
+	MaxSizeMB   int    `json:"maxSizeMB"`   // 最多占用的空间, 0 表示不限制
+	MaxAgeHours int    `json:"maxAgeHours"` // 最长保存时间, 0 表示不限制
+}
+
+type VideoCameraMainConfig struct {
+	CommonConfig   VideoCameraCommonConfig   `json:"commonConfig" validate:"required"`
+	SnapshotConfig VideoCameraSnapshotConfig `json:"snapshotConfig"`
+}
+
+// 流状态
+type VideoCameraHealth struct {
+	Type          string  `json:"type"` // health
+	Online        bool    `json:"online"`
+	Codec         string  `json:"codec"`
+	Width         int     `json:"width"`
+	Height        int     `json:"height"`
+	Fps           float64 `json:"fps"`       // 实际收到的帧率
+	SourceFps     float64 `json:"sourceFps"` // 源帧率, RTSP 由 ffprobe 探测
+	LastFrameTime int64   `json:"lastFrameTime"`
+	ErrMsg        string  `json:"errMsg"`
+}
+
+// 截图
+type VideoCameraSnapshot struct {
+	Type      string         `json:"type"` // snapshot
+	File      string         `json:"file"`
+	Size      int64          `json:"size"`
+	Width     int            `json:"width"`
+	Height    int            `json:"height"`
+	Ts        int64          `json:"ts"`
+	Image     string         `json:"image,omitempty"` // base64 JPEG
+	Algorithm string         `json:"algorithm,omitempty"`
+	Result    map[string]any `json:"result,omitempty"` // 算法结果
+	ErrMsg    string         `json:"errMsg,omitempty"`
 }
 
 /*
 *
-* ARM32不支持
+* 摄像头: 拉流采样 JPEG, 上报流状态, 定时或者手动截图交给算法和规则
 *
  */
+type videoCamera struct {
+	typex.XStatus
+	status     typex.SourceState
+	mainConfig VideoCameraMainConfig
+	store      *camera.Store
+	locker     sync.Mutex
+	info       camera.StreamInfo
+	lastFrame  camera.Frame
+	frames     int // 上次计算帧率以后收到的帧数
+	fpsAt      time.Time
+	fps        float64
+	errMsg     string
+	startedAt  time.Time
+}
+
 func NewVideoCamera(e typex.Rhilex) typex.XDevice {
 	hd := new(videoCamera)
 	hd.RuleEngine = e
+	hd.mainConfig = VideoCameraMainConfig{
+		CommonConfig: VideoCameraCommonConfig{
+			InputMode:      __CAMERA_INPUT_RTSP,
+			SampleFps:      1,
+			HealthInterval: 30,
+			StaleTimeout:   15,
+			Timeout:        5000,
+			ReportImage: func() *bool {
+				b := true
+				return &b
+			}(),
+		},
+		SnapshotConfig: VideoCameraSnapshotConfig{
+			Dir:         "./upload/snapshots",
+			MaxCount:    100,
+			MaxSizeMB:   100,
+			MaxAgeHours: 24,
+		},
+	}
 	return hd
 }
 
 func (hd *videoCamera) Init(devId string, configMap map[string]any) error {
 	hd.PointId = devId
+	if err := utils.BindSourceConfig(configMap, &hd.mainConfig); err != nil {
+		glogger.GLogger.Error(err)
+		return err
+	}
+	config := hd.mainConfig.CommonConfig
+	if !utils.SContains([]string{__CAMERA_INPUT_RTSP, __CAMERA_INPUT_HTTP_MJPEG,
+		__CAMERA_INPUT_HTTP_JPEG}, config.InputMode) {
+		return errors.New("unsupported input mode, only can be one of 'RTSP', 'HTTP_MJPEG' or 'HTTP_JPEG'")
+	}
+	addr, err := url.Parse(config.InputAddr)
+	if err != nil {
+		return fmt.Errorf("invalid input address: %s", config.InputAddr)
+	}
+	if config.InputMode == __CAMERA_INPUT_RTSP && addr.Scheme != "rtsp" {
+		return fmt.Errorf("invalid rtsp url: %s", config.InputAddr)
+	}
+	if config.InputMode != __CAMERA_INPUT_RTSP && addr.Scheme != "http" && addr.Scheme != "https" {
+		return fmt.Errorf("invalid http url: %s", config.InputAddr)
+	}
+	if config.SampleFps <= 0 || config.SampleFps > 30 {
+		return errors.New("sample fps must range of (0, 30]")
+	}
+	if config.SnapshotInterval < 0 || config.HealthInterval < 0 || config.StaleTimeout < 1 {
+		return errors.New("invalid snapshot, health or stale interval")
+	}
+	snapshot := hd.mainConfig.SnapshotConfig
+	if snapshot.MaxCount < 0 || snapshot.MaxSizeMB < 0 || snapshot.MaxAgeHours < 0 {
+		return errors.New("snapshot retention limits must be greater than or equal to 0")
+	}
+	store, err := camera.NewStore(filepath.Join(snapshot.Dir, devId), snapshot.MaxCount,
+		int64(snapshot.MaxSizeMB)<<20, time.Duration(snapshot.MaxAgeHours)*time.Hour)
+	if err != nil {
+		return err
+	}
+	hd.store = store
 	return nil
 }
 
 func (hd *videoCamera) Start(cctx typex.CCTX) error {
 	hd.Ctx = cctx.Ctx
 	hd.CancelCTX = cctx.CancelCTX
-
+	hd.locker.Lock()
+	hd.lastFrame = camera.Frame{}
+	hd.frames, hd.fps, hd.fpsAt, hd.errMsg = 0, 0, time.Now(), ""
+	hd.startedAt = time.Now()
+	hd.info = camera.StreamInfo{}
+	hd.locker.Unlock()
+	reader, err := hd.openStream()
+	if err != nil {
+		return err
+	}
+	go hd.readFrames(reader)
+	go hd.schedule()
 	hd.status = typex.SOURCE_UP
 	return nil
 }
 
+// 按照输入模式打开流, RTSP 先用 ffprobe 探测编码
+func (hd *videoCamera) openStream() (camera.FrameReader, error) {
+	config := hd.mainConfig.CommonConfig
+	timeout := time.Duration(config.Timeout) * time.Millisecond
+	switch config.InputMode {
+	case __CAMERA_INPUT_RTSP:
+		ctx, cancel := context.WithTimeout(hd.Ctx, timeout+5*time.Second)
+		info, err := camera.Probe(ctx, config.InputAddr)
+		cancel()
+		if err != nil {
+			glogger.GLogger.Warn("Camera probe failed:", hd.PointId, err)
+		} else {
+			hd.locker.Lock()
+			hd.info = info
+			hd.locker.Unlock()
+		}
+		return camera.OpenFfmpegStream(hd.Ctx, config.InputAddr, config.SampleFps)
+	case __CAMERA_INPUT_HTTP_MJPEG:
+		hd.locker.Lock()
+		hd.info.Codec = "mjpeg"
+		hd.locker.Unlock()
+		return camera.OpenMjpegStream(hd.Ctx, config.InputAddr, timeout)
+	}
+	hd.locker.Lock()
+	hd.info.Codec = "mjpeg"
+	hd.locker.Unlock()
+	return camera.NewHttpJpegReader(hd.Ctx, config.InputAddr,
+		time.Duration(float64(time.Second)/config.SampleFps), timeout), nil
+}
+
+/*
+*
+* 持续读帧, 只保留最新的一帧; 流断开以后设备交给监控器重启
+*
+ */
+func (hd *videoCamera) readFrames(reader camera.FrameReader) {
+	defer reader.Close()
+	// HTTP MJPEG 按照源帧率推送, 这里按照采样帧率丢帧
+	minInterval := time.Duration(float64(time.Second) / hd.mainConfig.CommonConfig.SampleFps)
+	var sampled time.Time
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			select {
+			case <-hd.Ctx.Done():
+				return
+			default:
+			}
+			if hd.mainConfig.CommonConfig.InputMode == __CAMERA_INPUT_HTTP_JPEG && err != camera.ErrFrameTooLarge {
+				hd.setError(err)
+				continue
+			}
+			glogger.GLogger.Error("Camera stream closed:", hd.PointId, err)
+			hd.setError(err)
+			hd.status = typex.SOURCE_DOWN
+			return
+		}
+		hd.locker.Lock()
+		hd.frames++
+		if frame.Time.Sub(sampled) >= minInterval {
+			sampled = frame.Time
+			hd.lastFrame = frame
+			hd.errMsg = ""
+		}
+		hd.locker.Unlock()
+	}
+}
+
+func (hd *videoCamera) setError(err error) {
+	hd.locker.Lock()
+	hd.errMsg = err.Error()
+	hd.locker.Unlock()
+}
+
+/*
+*
+* 定时上报流状态和截图; 长时间没有新帧认为流已经断开
+*
+ */
+func (hd *videoCamera) schedule() {
+	config := hd.mainConfig.CommonConfig
+	health := newIntervalTicker(config.HealthInterval)
+	defer health.Stop()
+	snapshot := newIntervalTicker(config.SnapshotInterval)
+	defer snapshot.Stop()
+	stale := time.NewTicker(time.Second)
+	defer stale.Stop()
+	for {
+		select {
+		case <-hd.Ctx.Done():
+			return
+		case <-health.C:
+			hd.publish(hd.health())
+		case <-snapshot.C:
+			result, err := hd.snapshot()
+			if err != nil {
+				glogger.GLogger.Warn("Camera snapshot failed:", hd.PointId, err)
+				continue
+			}
+			hd.publish(result)
+		case <-stale.C:
+			if time.Since(hd.lastFrameTime()) > time.Duration(config.StaleTimeout)*time.Second {
+				glogger.GLogger.Error("Camera stream stale:", hd.PointId)
+				hd.status = typex.SOURCE_DOWN
+				return
+			}
+		}
+	}
+}
+
+// 最后一帧的时间, 还没有收到帧的时候为启动时间
+func (hd *videoCamera) lastFrameTime() time.Time {
+	hd.locker.Lock()
+	defer hd.locker.Unlock()
+	if hd.lastFrame.Time.IsZero() {
+		return hd.startedAt
+	}
+	return hd.lastFrame.Time
+}
+
+func (hd *videoCamera) health() VideoCameraHealth {
+	hd.locker.Lock()
+	defer hd.locker.Unlock()
+	now := time.Now()
+	if elapsed := now.Sub(hd.fpsAt); elapsed >= time.Second {
+		hd.fps = float64(hd.frames) / elapsed.Seconds()
+		hd.frames, hd.fpsAt = 0, now
+	}
+	health := VideoCameraHealth{
+		Type:      "health",
+		Codec:     hd.info.Codec,
+		Width:     hd.info.Width,
+		Height:    hd.info.Height,
+		Fps:       hd.fps,
+		SourceFps: hd.info.Fps,
+		ErrMsg:    hd.errMsg,
+	}
+	if !hd.lastFrame.Time.IsZero() {
+		health.LastFrameTime = hd.lastFrame.Time.UnixMilli()
+		health.Online = now.Sub(hd.lastFrame.Time) <
+			time.Duration(hd.mainConfig.CommonConfig.StaleTimeout)*time.Second
+		if w, h, err := hd.lastFrame.Size(); err == nil && health.Width == 0 {
+			health.Width, health.Height = w, h
+		}
+	}
+	return health
+}
+
+/*
+*
+* 保存最新的一帧, 交给算法处理
+*
+ */
+func (hd *videoCamera) snapshot() (VideoCameraSnapshot, error) {
+	hd.locker.Lock()
+	frame := hd.lastFrame
+	hd.locker.Unlock()
+	if frame.Time.IsZero() {
+		return VideoCameraSnapshot{}, errors.New("no frame received yet")
+	}
+	saved, err := hd.store.Save(frame)
+	if err != nil {
+		return VideoCameraSnapshot{}, err
+	}
+	result := VideoCameraSnapshot{
+		Type: "snapshot",
+		File: saved.File,
+		Size: saved.Size,
+		Ts:   frame.Time.UnixMilli(),
+	}
+	result.Width, result.Height, _ = frame.Size()
+	if *hd.mainConfig.CommonConfig.ReportImage {
+		result.Image = base64.StdEncoding.EncodeToString(frame.Data)
+	}
+	if uuid := hd.mainConfig.CommonConfig.Algorithm; uuid != "" {
+		result.Algorithm = uuid
+		if algorithm := aibase.GetAlgorithm(uuid); algorithm == nil {
+			result.ErrMsg = "algorithm not exists: " + uuid
+		} else if output, err := algorithm.Forward(frame.Data); err != nil {
+			result.ErrMsg = err.Error()
+		} else {
+			result.Result = output
+		}
+	}
+	return result, nil
+}
+
+func (hd *videoCamera) publish(data any) {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return
+	}
+	hd.RuleEngine.WorkDevice(hd.Details(), string(bytes))
+}
+
 // 设备当前状态
 func (hd *videoCamera) Status() typex.SourceState {
 	return hd.status
@@ -56,7 +400,9 @@ func (hd *videoCamera) Status() typex.SourceState {
 // 停止设备
 func (hd *videoCamera) Stop() {
 	hd.status = typex.SOURCE_DOWN
-	hd.CancelCTX()
+	if hd.CancelCTX != nil {
+		hd.CancelCTX()
+	}
 }
 
 // 真实设备
@@ -67,15 +413,38 @@ func (hd *videoCamera) Details() *typex.Device {
 // 状态
 func (hd *videoCamera) SetState(status typex.SourceState) {
 	hd.status = status
-
 }
 
-// --------------------------------------------------------------------------------------------------
-//
-// --------------------------------------------------------------------------------------------------
 func (hd *videoCamera) OnDCACall(UUID string, Command string, Args any) typex.DCAResult {
 	return typex.DCAResult{}
 }
+
+/*
+*
+* 外部控制指令:
+* - Snapshot: 立即截图, 返回截图信息并交给规则
+* - Health: 返回当前流状态
+* - ListSnapshots: 返回保存的截图列表
+*
+ */
 func (hd *videoCamera) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
-	return []byte{}, nil
+	glogger.Debug("VideoCamera.OnCtrl, CMD=", string(cmd), ", Args=", string(args))
+	switch string(cmd) {
+	case "Snapshot":
+		result, err := hd.snapshot()
+		if err != nil {
+			return nil, err
+		}
+		hd.publish(result)
+		return json.Marshal(result)
+	case "Health":
+		return json.Marshal(hd.health())
+	case "ListSnapshots":
+		snapshots, err := hd.store.List()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(snapshots)
+	}
+	return nil, fmt.Errorf("unsupported command: %s", string(cmd))
 }
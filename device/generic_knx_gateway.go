@@ -16,65 +16,300 @@
 package device
 
 import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/deadband"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/device/knx"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
 	"github.com/hootrhino/rhilex/utils"
-	"github.com/vapourismo/knx-go/knx"
+	knxgo "github.com/vapourismo/knx-go/knx"
+	"github.com/vapourismo/knx-go/knx/cemi"
+)
+
+// 连接方式
+const (
+	KNX_MODE_TUNNEL string = "TUNNEL" // 隧道, 连接到 KNX/IP 接口
+	KNX_MODE_ROUTER string = "ROUTER" // 路由, 加入 KNX/IP 路由器的组播
 )
 
 type KNXGatewayCommonConfig struct {
+	Mode        string `json:"mode" validate:"required"`        // TUNNEL | ROUTER
+	ReadOnStart *bool  `json:"readOnStart" validate:"required"` // 启动的时候读一次所有组地址
+	Timeout     *int   `json:"timeout" validate:"required"`     // 隧道的响应超时, 毫秒
 }
 
 type KNXGatewayConfig struct {
+	Host      string `json:"host" validate:"required"` // 隧道为网关地址, 路由为组播地址, 比如 224.0.23.12
+	Port      int    `json:"port" validate:"required"` // 3671
+	UseTCP    *bool  `json:"useTcp"`                   // 隧道使用 TCP 连接
+	Interface string `json:"interface"`                // 路由模式使用的网卡, 空的时候由系统选择
 }
 
 type KNXGatewayMainConfig struct {
 	CommonConfig     KNXGatewayCommonConfig `json:"commonConfig" validate:"required"`
-	KNXGatewayConfig KNXGatewayConfig       `json:"knxConfig"`
+	KNXGatewayConfig KNXGatewayConfig       `json:"knxConfig" validate:"required"`
+}
+
+// 组地址表
+type knxGroupAddress struct {
+	UUID         string `json:"-"`
+	GroupAddress string `json:"groupAddress"` // 1/2/3
+	Dpt          string `json:"dpt"`          // 9.001
+	Tag          string `json:"tag"`          // temp
+	Alias        string `json:"alias"`        // 温度
+
+	deadband.ReportPolicy // 上报策略
+}
+
+// 上报的数据
+type KNXGroupValue struct {
+	GroupAddress string `json:"groupAddress"`
+	Dpt          string `json:"dpt"`
+	Tag          string `json:"tag"`
+	Alias        string `json:"alias"`
+	Source       string `json:"source"`  // 发送报文的设备地址 1.1.5
+	Command      string `json:"command"` // Write | Response
+	Value        any    `json:"value"`
 }
 
 type KNXGateway struct {
 	typex.XStatus
-	status      typex.SourceState
-	mainConfig  KNXGatewayMainConfig
-	GroupTunnel knx.GroupTunnel
+	status       typex.SourceState
+	mainConfig   KNXGatewayMainConfig
+	locker       sync.Mutex
+	client       knxgo.GroupClient
+	closer       func()
+	points       map[cemi.GroupAddr][]knxGroupAddress // 同一个组地址可以对应多个点位
+	tags         map[string]knxGroupAddress
+	reportFilter *deadband.Filter
 }
 
 func NewKNXGateway(e typex.Rhilex) typex.XDevice {
 	hd := new(KNXGateway)
 	hd.RuleEngine = e
+	hd.mainConfig = KNXGatewayMainConfig{
+		CommonConfig: KNXGatewayCommonConfig{
+			Mode: KNX_MODE_TUNNEL,
+			ReadOnStart: func() *bool {
+				b := true
+				return &b
+			}(),
+			Timeout: func() *int {
+				a := 10000
+				return &a
+			}(),
+		},
+		KNXGatewayConfig: KNXGatewayConfig{
+			Port: 3671,
+			UseTCP: func() *bool {
+				b := false
+				return &b
+			}(),
+		},
+	}
+	hd.points = map[cemi.GroupAddr][]knxGroupAddress{}
+	hd.tags = map[string]knxGroupAddress{}
+	hd.reportFilter = deadband.NewFilter()
 	return hd
 }
 
 func (hd *KNXGateway) Init(devId string, configMap map[string]any) error {
 	hd.PointId = devId
+	intercache.RegisterSlot(devId)
 	if err := utils.BindSourceConfig(configMap, &hd.mainConfig); err != nil {
 		glogger.GLogger.Error(err)
 		return err
 	}
-
+	if hd.mainConfig.CommonConfig.Mode != KNX_MODE_TUNNEL &&
+		hd.mainConfig.CommonConfig.Mode != KNX_MODE_ROUTER {
+		return fmt.Errorf("invalid knx mode: %s", hd.mainConfig.CommonConfig.Mode)
+	}
+	groupAddresses := []knxGroupAddress{}
+	if err := interdb.InterDb().Table("m_knx_group_addresses").
+		Where("device_uuid=?", devId).Find(&groupAddresses).Error; err != nil {
+		return err
+	}
+	LastFetchTime := uint64(time.Now().UnixMilli())
+	for _, point := range groupAddresses {
+		ga, err := knx.ParseGroupAddress(point.GroupAddress)
+		if err != nil {
+			return err
+		}
+		if err := knx.CheckDpt(point.Dpt); err != nil {
+			return err
+		}
+		hd.points[ga] = append(hd.points[ga], point)
+		hd.tags[point.Tag] = point
+		intercache.SetValue(hd.PointId, point.UUID, intercache.CacheValue{
+			UUID:          point.UUID,
+			Status:        0,
+			LastFetchTime: LastFetchTime,
+			Value:         "",
+			ErrMsg:        "--",
+		})
+	}
 	return nil
 }
 
 func (hd *KNXGateway) Start(cctx typex.CCTX) error {
 	hd.Ctx = cctx.Ctx
 	hd.CancelCTX = cctx.CancelCTX
-	GroupTunnel, err := knx.NewGroupTunnel("127.0.0.1:3671", knx.DefaultTunnelConfig)
-	if err != nil {
-		return err
+	hd.reportFilter.Reset()
+	address := net.JoinHostPort(hd.mainConfig.KNXGatewayConfig.Host,
+		fmt.Sprintf("%d", hd.mainConfig.KNXGatewayConfig.Port))
+	if hd.mainConfig.CommonConfig.Mode == KNX_MODE_ROUTER {
+		config := knxgo.DefaultRouterConfig
+		if name := hd.mainConfig.KNXGatewayConfig.Interface; name != "" {
+			iface, err := net.InterfaceByName(name)
+			if err != nil {
+				return err
+			}
+			config.Interface = iface
+		}
+		router, err := knxgo.NewGroupRouter(address, config)
+		if err != nil {
+			return err
+		}
+		hd.client = &router
+		hd.closer = router.Close
+	} else {
+		config := knxgo.DefaultTunnelConfig
+		config.ResponseTimeout = time.Duration(*hd.mainConfig.CommonConfig.Timeout) * time.Millisecond
+		if hd.mainConfig.KNXGatewayConfig.UseTCP != nil {
+			config.UseTCP = *hd.mainConfig.KNXGatewayConfig.UseTCP
+		}
+		tunnel, err := knxgo.NewGroupTunnel(address, config)
+		if err != nil {
+			return err
+		}
+		hd.client = &tunnel
+		hd.closer = tunnel.Close
+	}
+	go hd.serve(hd.client.Inbound())
+	if *hd.mainConfig.CommonConfig.ReadOnStart {
+		go func() {
+			for ga := range hd.points {
+				select {
+				case <-hd.Ctx.Done():
+					return
+				default:
+				}
+				if err := hd.send(knxgo.GroupEvent{
+					Command:     knxgo.GroupRead,
+					Destination: ga,
+				}); err != nil {
+					glogger.GLogger.Error("KNX group read error:", ga.String(), err)
+				}
+			}
+		}()
 	}
-	hd.GroupTunnel = GroupTunnel
 	hd.status = typex.SOURCE_UP
 	return nil
 }
 
+/*
+*
+* 处理收到的组报文, 连接断开以后 Inbound 会被关闭, 设备交给监控器重启
+*
+ */
+func (hd *KNXGateway) serve(inbound <-chan knxgo.GroupEvent) {
+	for {
+		select {
+		case <-hd.Ctx.Done():
+			return
+		case event, ok := <-inbound:
+			if !ok {
+				glogger.GLogger.Error("KNX connection closed:", hd.PointId)
+				hd.status = typex.SOURCE_DOWN
+				return
+			}
+			if event.Command == knxgo.GroupRead {
+				continue
+			}
+			hd.handleEvent(event)
+		}
+	}
+}
+
+func (hd *KNXGateway) handleEvent(event knxgo.GroupEvent) {
+	points, ok := hd.points[event.Destination]
+	if !ok {
+		return
+	}
+	LastFetchTime := uint64(time.Now().UnixMilli())
+	for _, point := range points {
+		value, err := knx.Decode(point.Dpt, event.Data)
+		if err != nil {
+			glogger.GLogger.Error("KNX decode error:", point.GroupAddress, err)
+			intercache.SetValue(hd.PointId, point.UUID, intercache.CacheValue{
+				UUID:          point.UUID,
+				Status:        0,
+				LastFetchTime: LastFetchTime,
+				Value:         "",
+				ErrMsg:        err.Error(),
+			})
+			continue
+		}
+		intercache.SetValue(hd.PointId, point.UUID, intercache.CacheValue{
+			UUID:          point.UUID,
+			Status:        1,
+			LastFetchTime: LastFetchTime,
+			Value:         fmt.Sprintf("%v", value),
+			ErrMsg:        "",
+		})
+		if !hd.reportFilter.Allow(point.Tag, point.ReportPolicy, fmt.Sprintf("%v", value)) {
+			continue
+		}
+		bytes, err := json.Marshal(KNXGroupValue{
+			GroupAddress: point.GroupAddress,
+			Dpt:          point.Dpt,
+			Tag:          point.Tag,
+			Alias:        point.Alias,
+			Source:       event.Source.String(),
+			Command:      event.Command.String(),
+			Value:        value,
+		})
+		if err != nil {
+			glogger.GLogger.Error(err)
+			continue
+		}
+		hd.RuleEngine.WorkDevice(hd.Details(), string(bytes))
+	}
+}
+
+func (hd *KNXGateway) send(event knxgo.GroupEvent) error {
+	hd.locker.Lock()
+	defer hd.locker.Unlock()
+	if hd.client == nil {
+		return fmt.Errorf("knx gateway not connected")
+	}
+	return hd.client.Send(event)
+}
+
 func (hd *KNXGateway) Status() typex.SourceState {
 	return hd.status
 }
 
 func (hd *KNXGateway) Stop() {
 	hd.status = typex.SOURCE_DOWN
-	hd.CancelCTX()
+	if hd.CancelCTX != nil {
+		hd.CancelCTX()
+	}
+	hd.locker.Lock()
+	if hd.closer != nil {
+		hd.closer()
+		hd.closer = nil
+	}
+	hd.client = nil
+	hd.locker.Unlock()
+	intercache.UnRegisterSlot(hd.PointId)
 }
 
 func (hd *KNXGateway) Details() *typex.Device {
@@ -89,6 +324,49 @@ func (hd *KNXGateway) OnDCACall(UUID string, Command string, Args any) typex.DCA
 	return typex.DCAResult{}
 }
 
+/*
+*
+* 外部控制指令:
+* - WriteGroupWithTag: {"tag":"light1","value":"on"} 按照组地址表里面的数据点类型写
+* - ReadGroupWithTag: {"tag":"temp"} 发送读请求, 结果和其他组报文一样上报
+*
+ */
 func (hd *KNXGateway) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
-	return []byte{}, nil
+	glogger.Debug("KNXGateway.OnCtrl, CMD=", string(cmd), ", Args=", string(args))
+	ctrlCmd := CtrlCmd{}
+	if err := json.Unmarshal(args, &ctrlCmd); err != nil {
+		return nil, err
+	}
+	point, ok := hd.tags[ctrlCmd.Tag]
+	if !ok {
+		return nil, fmt.Errorf("group address not exists: %s", ctrlCmd.Tag)
+	}
+	ga, err := knx.ParseGroupAddress(point.GroupAddress)
+	if err != nil {
+		return nil, err
+	}
+	switch string(cmd) {
+	case "WriteGroupWithTag":
+		data, err := knx.Encode(point.Dpt, ctrlCmd.Value)
+		if err != nil {
+			return nil, err
+		}
+		if err := hd.send(knxgo.GroupEvent{
+			Command:     knxgo.GroupWrite,
+			Destination: ga,
+			Data:        data,
+		}); err != nil {
+			return nil, err
+		}
+		return []byte{}, nil
+	case "ReadGroupWithTag":
+		if err := hd.send(knxgo.GroupEvent{
+			Command:     knxgo.GroupRead,
+			Destination: ga,
+		}); err != nil {
+			return nil, err
+		}
+		return []byte{}, nil
+	}
+	return nil, fmt.Errorf("unsupported command: %s", string(cmd))
 }
@@ -16,51 +16,124 @@
 package device
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hootrhino/rhilex/device/lorawan"
 	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/resconfig"
+	"github.com/hootrhino/rhilex/target/semtechudp"
 	"github.com/hootrhino/rhilex/typex"
 	"github.com/hootrhino/rhilex/utils"
 )
 
+type LoraGatewayCommonConfig struct {
+	NetId             string  `json:"netId"`                        // 网络 ID, 3 字节十六进制, OTAA 分配地址用
+	Rx1Delay          *int    `json:"rx1Delay" validate:"required"` // RX1 窗口延时, 秒
+	TxPower           int     `json:"txPower"`                      // 下行发射功率, dBm
+	DownlinkFrequency float64 `json:"downlinkFrequency"`            // 下行频率, MHz; 0 表示和上行相同
+	DownlinkDataRate  string  `json:"downlinkDataRate"`             // 下行速率, 比如 SF12BW125; 空表示和上行相同
+}
+
 //	{
-//		DevEUI: "01:02:03:04:05:06:07:08",
-//		AppKey: "0123456789ABCDEF0123456789ABCDE",
-//		FrequencyBand: "CN470",
-//		JoinType: "OTAA",
-//		Channels: []string{"01", "02", "03"},
-//		DataRates: []int{1, 2, 3},
-//		TxPower: 14,
-//		AntennaPolarization: "Linear",
-//		GatewayEUI: "11:12:13:14:15:16:17:18",
-//		ServerIP: "192.168.1.1",
-//		ServerPort: "1234",
+//		"devEui": "0102030405060708",
+//		"joinType": "ABP",
+//		"devAddr": "26011BDA",
+//		"nwkSKey": "000102030405060708090A0B0C0D0E0F",
+//		"appSKey": "0F0E0D0C0B0A09080706050403020100"
 //	}
-type LoraGatewayConfig struct {
-	DevEUI              string   // 设备欧盟标识符
-	AppKey              string   // 应用程序密钥
-	FrequencyBand       string   // 频段
-	JoinType            string   // 入网方式 (OTAA 或 ABP)
-	Channels            []string // 信道列表
-	DataRates           []int    // 数据速率列表
-	TxPower             int      // 传输功率
-	AntennaPolarization string   // 天线极化
-	GatewayEUI          string   // 网关EUI
-	ServerIP            string   // 服务器IP地址
-	ServerPort          string   // 服务器端口
+type LoraWanSession struct {
+	DevEui   string `json:"devEui"`   // 终端 EUI
+	JoinType string `json:"joinType"` // 入网方式 (OTAA 或 ABP)
+	DevAddr  string `json:"devAddr"`  // 终端地址, OTAA 可以为空
+	NwkSKey  string `json:"nwkSKey"`  // ABP 网络会话密钥
+	AppSKey  string `json:"appSKey"`  // ABP 应用会话密钥
+	AppKey   string `json:"appKey"`   // OTAA 根密钥
 }
 
 type LoraGatewayMainConfig struct {
-	LoraGatewayConfig LoraGatewayConfig `json:"loraGatewayConfig"`
+	CommonConfig LoraGatewayCommonConfig `json:"commonConfig" validate:"required"`
+	HostConfig   resconfig.HostConfig    `json:"hostConfig"` // UDP 监听地址
+	Sessions     []LoraWanSession        `json:"sessions"`
+}
+
+// 上报的数据
+type LoraWanMessage struct {
+	Type       string  `json:"type"` // uplink | join
+	DevEui     string  `json:"devEui"`
+	DevAddr    string  `json:"devAddr"`
+	FCnt       uint32  `json:"fCnt"`
+	FPort      int     `json:"fPort"` // -1 表示没有 FRMPayload
+	Confirmed  bool    `json:"confirmed"`
+	Adr        bool    `json:"adr"`
+	Data       string  `json:"data"` // 解密以后的十六进制数据
+	Rssi       int16   `json:"rssi"`
+	Snr        float64 `json:"snr"`
+	Freq       float64 `json:"freq"`
+	DatR       string  `json:"datr"`
+	GatewayEui string  `json:"gatewayEui"`
+	Ts         int64   `json:"ts"`
+}
+
+// 下行指令
+type LoraWanDownlinkCmd struct {
+	DevEui    string `json:"devEui"`
+	FPort     int    `json:"fPort"`
+	Data      string `json:"data"` // 十六进制
+	Confirmed bool   `json:"confirmed"`
+}
+
+// 网关的下行地址, 来自 PULL_DATA
+type loraPacketForwarder struct {
+	addr            *net.UDPAddr
+	protocolVersion uint8
 }
 
+// 入网应答在上行以后 5 秒发送
+const loraJoinAcceptDelay = 5 * time.Second
+
+/*
+*
+* LoRaWAN 网关: 作为网络服务器一侧接收 Semtech UDP 转发器的数据, 解密上行交给规则, 下行通过 PULL_RESP 发送
+*
+ */
 type LoraGateway struct {
 	typex.XStatus
 	status     typex.SourceState
 	mainConfig LoraGatewayMainConfig
+	network    *lorawan.Network
+	locker     sync.Mutex
+	conn       *net.UDPConn
+	forwarders map[lorawan.EUI64]loraPacketForwarder
+	token      uint16
 }
 
 func NewLoraGateway(e typex.Rhilex) typex.XDevice {
 	hd := new(LoraGateway)
 	hd.RuleEngine = e
+	hd.mainConfig = LoraGatewayMainConfig{
+		CommonConfig: LoraGatewayCommonConfig{
+			NetId: "000000",
+			Rx1Delay: func() *int {
+				a := 1
+				return &a
+			}(),
+			TxPower: 14,
+		},
+		HostConfig: resconfig.HostConfig{
+			Host:    "0.0.0.0",
+			Port:    1700,
+			Timeout: 3000,
+		},
+		Sessions: []LoraWanSession{},
+	}
+	hd.forwarders = map[lorawan.EUI64]loraPacketForwarder{}
 	return hd
 }
 
@@ -70,25 +143,304 @@ func (hd *LoraGateway) Init(devId string, configMap map[string]any) error {
 		glogger.GLogger.Error(err)
 		return err
 	}
-
+	if err := hd.mainConfig.HostConfig.Validate(); err != nil {
+		return err
+	}
+	if d := *hd.mainConfig.CommonConfig.Rx1Delay; d < 1 || d > 15 {
+		return errors.New("rx1 delay must range of 1-15 seconds")
+	}
+	netIdBytes, err := hex.DecodeString(hd.mainConfig.CommonConfig.NetId)
+	if err != nil || len(netIdBytes) != 3 {
+		return fmt.Errorf("invalid netId: %s", hd.mainConfig.CommonConfig.NetId)
+	}
+	// NetID 按照大端填写, 空中按照小端传输
+	netId := [3]byte{netIdBytes[2], netIdBytes[1], netIdBytes[0]}
+	hd.network = lorawan.NewNetwork(netId, byte(*hd.mainConfig.CommonConfig.Rx1Delay))
+	for _, session := range hd.mainConfig.Sessions {
+		if err := hd.addSession(session); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (hd *LoraGateway) addSession(session LoraWanSession) error {
+	devEui, err := lorawan.ParseEUI64(session.DevEui)
+	if err != nil {
+		return err
+	}
+	var devAddr uint32
+	if session.DevAddr != "" {
+		if devAddr, err = lorawan.ParseDevAddr(session.DevAddr); err != nil {
+			return err
+		}
+	}
+	switch strings.ToUpper(session.JoinType) {
+	case "ABP":
+		if session.DevAddr == "" {
+			return fmt.Errorf("missing devAddr: %s", session.DevEui)
+		}
+		nwkSKey, err := lorawan.ParseKey(session.NwkSKey)
+		if err != nil {
+			return err
+		}
+		appSKey, err := lorawan.ParseKey(session.AppSKey)
+		if err != nil {
+			return err
+		}
+		return hd.network.AddABP(devEui, devAddr, nwkSKey, appSKey)
+	case "OTAA":
+		appKey, err := lorawan.ParseKey(session.AppKey)
+		if err != nil {
+			return err
+		}
+		return hd.network.AddOTAA(devEui, appKey, devAddr)
+	}
+	return fmt.Errorf("unsupported join type, only can be one of 'OTAA' or 'ABP': %s", session.JoinType)
+}
+
 func (hd *LoraGateway) Start(cctx typex.CCTX) error {
 	hd.Ctx = cctx.Ctx
 	hd.CancelCTX = cctx.CancelCTX
-
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(hd.mainConfig.HostConfig.Host,
+		fmt.Sprintf("%d", hd.mainConfig.HostConfig.Port)))
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	hd.locker.Lock()
+	hd.conn = conn
+	hd.locker.Unlock()
+	go hd.serve(conn)
 	hd.status = typex.SOURCE_UP
 	return nil
 }
 
+func (hd *LoraGateway) serve(conn *net.UDPConn) {
+	buffer := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			select {
+			case <-hd.Ctx.Done():
+			default:
+				glogger.GLogger.Error("LoraGateway read error:", hd.PointId, err)
+				hd.status = typex.SOURCE_DOWN
+			}
+			return
+		}
+		data := make([]byte, n)
+		copy(data, buffer[:n])
+		if err := hd.handlePacket(conn, addr, data); err != nil {
+			glogger.GLogger.Debug("LoraGateway packet error:", addr.String(), err)
+		}
+	}
+}
+
+/*
+*
+* 处理转发器的报文: PUSH_DATA 回复 PUSH_ACK, PULL_DATA 记录下行地址并回复 PULL_ACK
+*
+ */
+func (hd *LoraGateway) handlePacket(conn *net.UDPConn, addr *net.UDPAddr, data []byte) error {
+	packetType, err := semtechudp.GetPacketType(data)
+	if err != nil {
+		return err
+	}
+	switch packetType {
+	case semtechudp.PushData:
+		packet := semtechudp.PushDataPacket{}
+		if err := packet.UnmarshalBinary(data); err != nil {
+			return err
+		}
+		ack, _ := semtechudp.PushACKPacket{
+			ProtocolVersion: packet.ProtocolVersion,
+			RandomToken:     packet.RandomToken,
+		}.MarshalBinary()
+		if _, err := conn.WriteToUDP(ack, addr); err != nil {
+			return err
+		}
+		for _, rxpk := range packet.Payload.RXPK {
+			if rxpk.Stat == -1 {
+				continue
+			}
+			hd.handleRxpk(lorawan.EUI64(packet.GatewayMAC), rxpk)
+		}
+	case semtechudp.PullData:
+		packet := semtechudp.PullDataPacket{}
+		if err := packet.UnmarshalBinary(data); err != nil {
+			return err
+		}
+		hd.locker.Lock()
+		hd.forwarders[lorawan.EUI64(packet.GatewayMAC)] = loraPacketForwarder{
+			addr:            addr,
+			protocolVersion: packet.ProtocolVersion,
+		}
+		hd.locker.Unlock()
+		ack, _ := semtechudp.PullACKPacket{
+			ProtocolVersion: packet.ProtocolVersion,
+			RandomToken:     packet.RandomToken,
+		}.MarshalBinary()
+		if _, err := conn.WriteToUDP(ack, addr); err != nil {
+			return err
+		}
+	case semtechudp.TXACK:
+		packet := semtechudp.TXACKPacket{}
+		if err := packet.UnmarshalBinary(data); err != nil {
+			return err
+		}
+		if packet.Payload != nil && packet.Payload.TXPKACK.Error != "" &&
+			packet.Payload.TXPKACK.Error != "NONE" {
+			glogger.GLogger.Warn("LoraGateway downlink rejected:",
+				lorawan.EUI64(packet.GatewayMAC).String(), packet.Payload.TXPKACK.Error)
+		}
+	}
+	return nil
+}
+
+/*
+*
+* 处理一个射频包: 入网请求回复入网应答, 数据帧解密以后上报, 然后在 RX1 发送排队的下行或者确认
+*
+ */
+func (hd *LoraGateway) handleRxpk(gatewayEui lorawan.EUI64, rxpk semtechudp.RXPK) {
+	mType, err := lorawan.MTypeOf(rxpk.Data)
+	if err != nil {
+		return
+	}
+	message := LoraWanMessage{
+		FPort:      -1,
+		Rssi:       rxpk.RSSI,
+		Snr:        rxpk.LSNR,
+		Freq:       rxpk.Freq,
+		DatR:       rxpk.DatR.LoRa,
+		GatewayEui: gatewayEui.String(),
+		Ts:         time.Now().UnixMilli(),
+	}
+	switch mType {
+	case lorawan.JOIN_REQUEST:
+		result, err := hd.network.HandleJoin(rxpk.Data)
+		if err != nil {
+			glogger.GLogger.Debug("LoraGateway join request rejected:", result.DevEUI.String(), err)
+			return
+		}
+		message.Type = "join"
+		message.DevEui = result.DevEUI.String()
+		message.DevAddr = lorawan.DevAddrString(result.DevAddr)
+		hd.publish(message)
+		hd.sendDownlink(gatewayEui, rxpk, result.Accept, loraJoinAcceptDelay)
+	case lorawan.UNCONFIRMED_DATA_UP, lorawan.CONFIRMED_DATA_UP:
+		uplink, err := hd.network.HandleUplink(rxpk.Data)
+		if err != nil {
+			if err != lorawan.ErrDuplicate {
+				glogger.GLogger.Debug("LoraGateway uplink rejected:",
+					lorawan.DevAddrString(uplink.DevAddr), err)
+			}
+			return
+		}
+		message.Type = "uplink"
+		message.DevEui = uplink.DevEUI.String()
+		message.DevAddr = lorawan.DevAddrString(uplink.DevAddr)
+		message.FCnt = uplink.FCnt
+		message.Confirmed = uplink.Confirmed
+		message.Adr = uplink.ADR
+		message.Data = hex.EncodeToString(uplink.Data)
+		if uplink.FPort != nil {
+			message.FPort = int(*uplink.FPort)
+		}
+		hd.publish(message)
+		phy, ok, err := hd.network.NextDownlink(uplink.DevEUI, uplink.Confirmed)
+		if err != nil {
+			glogger.GLogger.Error("LoraGateway downlink error:", message.DevEui, err)
+			return
+		}
+		if ok {
+			delay := time.Duration(*hd.mainConfig.CommonConfig.Rx1Delay) * time.Second
+			hd.sendDownlink(gatewayEui, rxpk, phy, delay)
+		}
+	}
+}
+
+func (hd *LoraGateway) publish(message LoraWanMessage) {
+	bytes, err := json.Marshal(message)
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return
+	}
+	hd.RuleEngine.WorkDevice(hd.Details(), string(bytes))
+}
+
+/*
+*
+* 通过收到上行的网关发送 PULL_RESP, 按照上行的计数器时间加上延时发送
+*
+ */
+func (hd *LoraGateway) sendDownlink(gatewayEui lorawan.EUI64, rxpk semtechudp.RXPK,
+	phy []byte, delay time.Duration) {
+	hd.locker.Lock()
+	forwarder, ok := hd.forwarders[gatewayEui]
+	conn := hd.conn
+	hd.token++
+	token := hd.token
+	hd.locker.Unlock()
+	if !ok || conn == nil {
+		glogger.GLogger.Warn("LoraGateway downlink path not ready, waiting PULL_DATA:", gatewayEui.String())
+		return
+	}
+	tmst := rxpk.Tmst + uint32(delay/time.Microsecond)
+	txpk := semtechudp.TXPK{
+		Tmst: &tmst,
+		RFCh: 0,
+		Powe: uint8(hd.mainConfig.CommonConfig.TxPower),
+		Freq: rxpk.Freq,
+		Modu: "LORA",
+		DatR: rxpk.DatR,
+		CodR: rxpk.CodR,
+		IPol: true,
+		Size: uint16(len(phy)),
+		Data: phy,
+	}
+	if hd.mainConfig.CommonConfig.DownlinkFrequency > 0 {
+		txpk.Freq = hd.mainConfig.CommonConfig.DownlinkFrequency
+	}
+	if hd.mainConfig.CommonConfig.DownlinkDataRate != "" {
+		txpk.DatR = semtechudp.DatR{LoRa: hd.mainConfig.CommonConfig.DownlinkDataRate}
+	}
+	if txpk.CodR == "" {
+		txpk.CodR = "4/5"
+	}
+	packet, err := semtechudp.PullRespPacket{
+		ProtocolVersion: forwarder.protocolVersion,
+		RandomToken:     token,
+		Payload:         semtechudp.PullRespPayload{TXPK: txpk},
+	}.MarshalBinary()
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return
+	}
+	if _, err := conn.WriteToUDP(packet, forwarder.addr); err != nil {
+		glogger.GLogger.Error("LoraGateway send PULL_RESP error:", err)
+	}
+}
+
 func (hd *LoraGateway) Status() typex.SourceState {
 	return hd.status
 }
 
 func (hd *LoraGateway) Stop() {
 	hd.status = typex.SOURCE_DOWN
-	hd.CancelCTX()
+	if hd.CancelCTX != nil {
+		hd.CancelCTX()
+	}
+	hd.locker.Lock()
+	if hd.conn != nil {
+		hd.conn.Close()
+		hd.conn = nil
+	}
+	hd.locker.Unlock()
 }
 
 func (hd *LoraGateway) Details() *typex.Device {
@@ -103,6 +455,39 @@ func (hd *LoraGateway) OnDCACall(UUID string, Command string, Args any) typex.DC
 	return typex.DCAResult{}
 }
 
+/*
+*
+* 外部控制指令:
+* - Downlink: {"devEui":"0102030405060708","fPort":10,"data":"0102","confirmed":false}
+*   下行排队, 终端下一次上行以后在 RX1 窗口发送
+*
+ */
 func (hd *LoraGateway) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
-	return []byte{}, nil
+	glogger.Debug("LoraGateway.OnCtrl, CMD=", string(cmd), ", Args=", string(args))
+	if string(cmd) != "Downlink" {
+		return nil, fmt.Errorf("unsupported command: %s", string(cmd))
+	}
+	downlinkCmd := LoraWanDownlinkCmd{}
+	if err := json.Unmarshal(args, &downlinkCmd); err != nil {
+		return nil, err
+	}
+	devEui, err := lorawan.ParseEUI64(downlinkCmd.DevEui)
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(downlinkCmd.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex data: %s", downlinkCmd.Data)
+	}
+	if downlinkCmd.FPort < 1 || downlinkCmd.FPort > 223 {
+		return nil, fmt.Errorf("fPort must range of 1-223")
+	}
+	if err := hd.network.Enqueue(devEui, lorawan.Downlink{
+		FPort:     byte(downlinkCmd.FPort),
+		Data:      data,
+		Confirmed: downlinkCmd.Confirmed,
+	}); err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf(`{"queueSize":%d}`, hd.network.QueueSize(devEui))), nil
 }
@@ -27,8 +27,10 @@ import (
 	"time"
 
 	"github.com/hootrhino/rhilex/alarmcenter"
+	"github.com/hootrhino/rhilex/component/deadband"
 	intercache "github.com/hootrhino/rhilex/component/intercache"
 	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/component/intermetric"
 	"github.com/hootrhino/rhilex/device/dmodbus"
 	"github.com/hootrhino/rhilex/device/ithings"
 	"github.com/hootrhino/rhilex/resconfig"
@@ -54,6 +56,8 @@ type ModbusPoint struct {
 	DataType  string  `json:"dataType"`        // 运行时数据
 	DataOrder string  `json:"dataOrder"`       // 运行时数据
 	Weight    float64 `json:"weight"`          // 权重
+
+	deadband.ReportPolicy // 上报策略
 }
 
 // 这是个通用Modbus采集器, 主要用来在通用场景下采集数据，因此需要配合规则引擎来使用
@@ -109,15 +113,17 @@ type GenericModbusMaster struct {
 	status     typex.SourceState
 	RuleEngine typex.Rhilex
 	//
-	rtuHandler *modbus.RTUClientHandler
-	tcpHandler *modbus.TCPClientHandler
-	Client     modbus.Client
+	rtuHandler  *modbus.RTUClientHandler
+	tcpHandler  *modbus.TCPClientHandler
+	transporter *dmodbus.LockedTransporter // RTU 总线, 轮询和网关转发共用
+	Client      modbus.Client
 	//
 	mainConfig       ModbusMasterConfig
 	retryTimes       int
 	Registers        map[string]*dmodbus.ModbusRegister
 	RegisterWithTags map[string]*dmodbus.ModbusRegister
 	RegisterGroups   []*ModbusMasterGroupedTag
+	reportFilter     *deadband.Filter // 按点位过滤上报
 }
 
 /*
@@ -177,6 +183,7 @@ func NewGenericModbusMaster(e typex.Rhilex) typex.XDevice {
 	mdev.Registers = map[string]*dmodbus.ModbusRegister{}
 	mdev.RegisterWithTags = map[string]*dmodbus.ModbusRegister{}
 	mdev.RegisterGroups = []*ModbusMasterGroupedTag{}
+	mdev.reportFilter = deadband.NewFilter()
 	mdev.Busy = false
 	mdev.status = typex.SOURCE_DOWN
 	return mdev
@@ -190,8 +197,8 @@ func (mdev *GenericModbusMaster) Init(devId string, configMap map[string]any) er
 	if err := utils.BindSourceConfig(configMap, &mdev.mainConfig); err != nil {
 		return err
 	}
-	if !utils.SContains([]string{"UART", "TCP"}, mdev.mainConfig.CommonConfig.Mode) {
-		return errors.New("unsupported mode, only can be one of 'TCP' or 'UART'")
+	if !utils.SContains([]string{"UART", "TCP", "RTU_OVER_TCP"}, mdev.mainConfig.CommonConfig.Mode) {
+		return errors.New("unsupported mode, only can be one of 'TCP', 'UART' or 'RTU_OVER_TCP'")
 	}
 
 	// 合并数据库里面的点位表
@@ -209,30 +216,32 @@ func (mdev *GenericModbusMaster) Init(devId string, configMap map[string]any) er
 			return errors.New("'frequency' must grate than 50 millisecond")
 		}
 		mdev.RegisterWithTags[ModbusPoint.Tag] = &dmodbus.ModbusRegister{
-			UUID:      ModbusPoint.UUID,
-			Tag:       ModbusPoint.Tag,
-			Alias:     ModbusPoint.Alias,
-			Function:  ModbusPoint.Function,
-			SlaverId:  ModbusPoint.SlaverId,
-			Address:   ModbusPoint.Address,
-			Quantity:  ModbusPoint.Quantity,
-			Frequency: ModbusPoint.Frequency,
-			DataType:  ModbusPoint.DataType,
-			DataOrder: ModbusPoint.DataOrder,
-			Weight:    ModbusPoint.Weight,
+			UUID:         ModbusPoint.UUID,
+			Tag:          ModbusPoint.Tag,
+			Alias:        ModbusPoint.Alias,
+			Function:     ModbusPoint.Function,
+			SlaverId:     ModbusPoint.SlaverId,
+			Address:      ModbusPoint.Address,
+			Quantity:     ModbusPoint.Quantity,
+			Frequency:    ModbusPoint.Frequency,
+			DataType:     ModbusPoint.DataType,
+			DataOrder:    ModbusPoint.DataOrder,
+			Weight:       ModbusPoint.Weight,
+			ReportPolicy: ModbusPoint.ReportPolicy,
 		}
 		mdev.Registers[ModbusPoint.UUID] = &dmodbus.ModbusRegister{
-			UUID:      ModbusPoint.UUID,
-			Tag:       ModbusPoint.Tag,
-			Alias:     ModbusPoint.Alias,
-			Function:  ModbusPoint.Function,
-			SlaverId:  ModbusPoint.SlaverId,
-			Address:   ModbusPoint.Address,
-			Quantity:  ModbusPoint.Quantity,
-			Frequency: ModbusPoint.Frequency,
-			DataType:  ModbusPoint.DataType,
-			DataOrder: ModbusPoint.DataOrder,
-			Weight:    ModbusPoint.Weight,
+			UUID:         ModbusPoint.UUID,
+			Tag:          ModbusPoint.Tag,
+			Alias:        ModbusPoint.Alias,
+			Function:     ModbusPoint.Function,
+			SlaverId:     ModbusPoint.SlaverId,
+			Address:      ModbusPoint.Address,
+			Quantity:     ModbusPoint.Quantity,
+			Frequency:    ModbusPoint.Frequency,
+			DataType:     ModbusPoint.DataType,
+			DataOrder:    ModbusPoint.DataOrder,
+			Weight:       ModbusPoint.Weight,
+			ReportPolicy: ModbusPoint.ReportPolicy,
 		}
 		intercache.SetValue(mdev.PointId, ModbusPoint.UUID, intercache.CacheValue{
 			UUID:          ModbusPoint.UUID,
@@ -367,6 +376,7 @@ func (mdev *GenericModbusMaster) Start(cctx typex.CCTX) error {
 	mdev.Ctx = cctx.Ctx
 	mdev.CancelCTX = cctx.CancelCTX
 	mdev.retryTimes = 0
+	mdev.reportFilter.Reset()
 	if mdev.mainConfig.CommonConfig.Mode == "UART" {
 		mdev.rtuHandler = modbus.NewRTUClientHandler(mdev.mainConfig.UartConfig.Uart)
 		mdev.rtuHandler.BaudRate = mdev.mainConfig.UartConfig.BaudRate
@@ -382,7 +392,21 @@ func (mdev *GenericModbusMaster) Start(cctx typex.CCTX) error {
 		if err := mdev.rtuHandler.Connect(); err != nil {
 			return err
 		}
-		mdev.Client = modbus.NewClient(mdev.rtuHandler)
+		mdev.transporter = dmodbus.NewLockedTransporter(mdev.rtuHandler)
+		mdev.Client = modbus.NewClient2(mdev.rtuHandler, mdev.transporter)
+	}
+	// 串口服务器透传: 用 RTU 的方式组帧, 通过 TCP 发出去
+	if mdev.mainConfig.CommonConfig.Mode == "RTU_OVER_TCP" {
+		mdev.rtuHandler = modbus.NewRTUClientHandler("")
+		transporter := dmodbus.NewRTUOverTCPTransporter(
+			fmt.Sprintf("%s:%v", mdev.mainConfig.HostConfig.Host, mdev.mainConfig.HostConfig.Port),
+			time.Duration(mdev.mainConfig.HostConfig.Timeout)*time.Millisecond,
+		)
+		if err := transporter.Connect(); err != nil {
+			return err
+		}
+		mdev.transporter = dmodbus.NewLockedTransporter(transporter)
+		mdev.Client = modbus.NewClient2(mdev.rtuHandler, mdev.transporter)
 	}
 	if mdev.mainConfig.CommonConfig.Mode == "TCP" {
 		mdev.tcpHandler = modbus.NewTCPClientHandler(
@@ -411,15 +435,19 @@ func (mdev *GenericModbusMaster) Start(cctx typex.CCTX) error {
 					return
 				}
 				ReadRegisterValues := []ReadRegisterValue{}
-				if mdev.mainConfig.CommonConfig.Mode == "UART" {
+				pollStart := time.Now()
+				if mdev.mainConfig.CommonConfig.Mode == "UART" ||
+					mdev.mainConfig.CommonConfig.Mode == "RTU_OVER_TCP" {
 					ReadRegisterValues = mdev.RTURead()
 				}
 				if mdev.mainConfig.CommonConfig.Mode == "TCP" {
 					ReadRegisterValues = mdev.TCPRead()
 				}
+				intermetric.ObserveDevicePoll(mdev.PointId, time.Since(pollStart))
 				if *mdev.mainConfig.CommonConfig.BatchRequest {
-					if len(ReadRegisterValues) > 0 {
-						if bytes, errMarshal := json.Marshal(ReadRegisterValues); errMarshal != nil {
+					// 告警仍然使用全部的值, 只过滤上报的
+					if ReportValues := mdev.filterReportValues(ReadRegisterValues); len(ReportValues) > 0 {
+						if bytes, errMarshal := json.Marshal(ReportValues); errMarshal != nil {
 							mdev.retryTimes++
 							glogger.GLogger.Error(errMarshal)
 						} else {
@@ -499,9 +527,10 @@ func (mdev *GenericModbusMaster) Stop() {
 	if mdev.CancelCTX != nil {
 		mdev.CancelCTX()
 	}
-	if mdev.mainConfig.CommonConfig.Mode == "UART" {
-		if mdev.rtuHandler != nil {
-			mdev.rtuHandler.Close()
+	if mdev.mainConfig.CommonConfig.Mode == "UART" ||
+		mdev.mainConfig.CommonConfig.Mode == "RTU_OVER_TCP" {
+		if mdev.transporter != nil {
+			mdev.transporter.Close()
 		}
 	}
 	if mdev.mainConfig.CommonConfig.Mode == "TCP" {
@@ -544,6 +573,26 @@ func (O CtrlCmd) String() string {
 	return string(bytes)
 }
 
+/*
+*
+* 透传一个 PDU 到 RTU 总线上的从机, 返回从机响应的 PDU(包括异常响应),
+* 和轮询共用同一把总线锁, 给 Modbus 网关用
+*
+ */
+func (mdev *GenericModbusMaster) Transact(slaverId byte, pdu []byte) ([]byte, error) {
+	if mdev.status != typex.SOURCE_UP || mdev.transporter == nil {
+		return nil, fmt.Errorf("modbus master is not running on a rtu bus")
+	}
+	if len(pdu) < 1 {
+		return nil, fmt.Errorf("modbus: empty pdu")
+	}
+	aduResponse, err := mdev.transporter.Send(dmodbus.EncodeRTUFrame(slaverId, pdu))
+	if err != nil {
+		return nil, err
+	}
+	return dmodbus.DecodeRTUFrame(slaverId, aduResponse)
+}
+
 /**
  * 外部控制指令
  *
@@ -655,6 +704,25 @@ type ReadRegisterValue struct {
 	Value         string `json:"value"`
 }
 
+/*
+*
+* 批量模式下按照点位的上报策略过滤
+*
+ */
+func (mdev *GenericModbusMaster) filterReportValues(values []ReadRegisterValue) []ReadRegisterValue {
+	result := make([]ReadRegisterValue, 0, len(values))
+	for _, value := range values {
+		policy := deadband.ReportPolicy{}
+		if Register, ok := mdev.RegisterWithTags[value.Tag]; ok {
+			policy = Register.ReportPolicy
+		}
+		if mdev.reportFilter.Allow(value.Tag, policy, value.Value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
 /*
 *
 * 串口模式
@@ -680,7 +748,8 @@ func (mdev *GenericModbusMaster) modbusSingleRead() []ReadRegisterValue {
 	// Modbus收到的数据全部放进这个全局缓冲区内
 	var __modbusReadResult = [256]byte{0} // 放在栈上提高效率
 	for uuid, r := range mdev.Registers {
-		if mdev.mainConfig.CommonConfig.Mode == "UART" {
+		if mdev.mainConfig.CommonConfig.Mode == "UART" ||
+			mdev.mainConfig.CommonConfig.Mode == "RTU_OVER_TCP" {
 			mdev.rtuHandler.SlaveId = r.SlaverId
 		}
 		if mdev.mainConfig.CommonConfig.Mode == "TCP" {
@@ -745,7 +814,8 @@ func (mdev *GenericModbusMaster) modbusSingleRead() []ReadRegisterValue {
 				// 	}
 				// }
 			}
-			if !*mdev.mainConfig.CommonConfig.BatchRequest {
+			if !*mdev.mainConfig.CommonConfig.BatchRequest &&
+				mdev.reportFilter.Allow(r.Tag, r.ReportPolicy, Value) {
 				if bytes, errMarshal := json.Marshal(Reg); errMarshal != nil {
 					glogger.GLogger.Error(errMarshal)
 				} else {
@@ -812,7 +882,8 @@ func (mdev *GenericModbusMaster) modbusSingleRead() []ReadRegisterValue {
 				// 	}
 				// }
 			}
-			if !*mdev.mainConfig.CommonConfig.BatchRequest {
+			if !*mdev.mainConfig.CommonConfig.BatchRequest &&
+				mdev.reportFilter.Allow(r.Tag, r.ReportPolicy, Value) {
 				if bytes, errMarshal := json.Marshal(Reg); errMarshal != nil {
 					glogger.GLogger.Error(errMarshal)
 				} else {
@@ -880,7 +951,8 @@ func (mdev *GenericModbusMaster) modbusSingleRead() []ReadRegisterValue {
 				// 	}
 				// }
 			}
-			if !*mdev.mainConfig.CommonConfig.BatchRequest {
+			if !*mdev.mainConfig.CommonConfig.BatchRequest &&
+				mdev.reportFilter.Allow(r.Tag, r.ReportPolicy, Value) {
 				if bytes, errMarshal := json.Marshal(Reg); errMarshal != nil {
 					glogger.GLogger.Error(errMarshal)
 				} else {
@@ -947,7 +1019,8 @@ func (mdev *GenericModbusMaster) modbusSingleRead() []ReadRegisterValue {
 				// 	}
 				// }
 			}
-			if !*mdev.mainConfig.CommonConfig.BatchRequest {
+			if !*mdev.mainConfig.CommonConfig.BatchRequest &&
+				mdev.reportFilter.Allow(r.Tag, r.ReportPolicy, Value) {
 				if bytes, errMarshal := json.Marshal(Reg); errMarshal != nil {
 					glogger.GLogger.Error(errMarshal)
 				} else {
@@ -8,8 +8,10 @@ import (
 
 	"github.com/gosnmp/gosnmp"
 	"github.com/hootrhino/rhilex/alarmcenter"
+	"github.com/hootrhino/rhilex/component/deadband"
 	"github.com/hootrhino/rhilex/component/intercache"
 	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/component/intermetric"
 	"github.com/hootrhino/rhilex/resconfig"
 
 	"github.com/hootrhino/rhilex/glogger"
@@ -24,6 +26,8 @@ type snmpOid struct {
 	Tag       string `json:"tag"`   // temp
 	Alias     string `json:"alias"` // 温度
 	Frequency *int   `json:"-"`     // 请求频率
+
+	deadband.ReportPolicy // 上报策略
 }
 type _SNMPCommonConfig struct {
 	AutoRequest  *bool `json:"autoRequest" validate:"required"`  // 自动请求
@@ -43,12 +47,13 @@ type _GSNMPConfig struct {
 
 type genericSnmpDevice struct {
 	typex.XStatus
-	status     typex.SourceState
-	RuleEngine typex.Rhilex
-	locker     sync.Locker
-	mainConfig _GSNMPConfig
-	snmpOids   map[string]snmpOid
-	client     *gosnmp.GoSNMP
+	status       typex.SourceState
+	RuleEngine   typex.Rhilex
+	locker       sync.Locker
+	mainConfig   _GSNMPConfig
+	snmpOids     map[string]snmpOid
+	client       *gosnmp.GoSNMP
+	reportFilter *deadband.Filter // 按点位过滤上报
 }
 
 // Example: 0x02 0x92 0xFF 0x98
@@ -102,6 +107,7 @@ func NewGenericSnmpDevice(e typex.Rhilex) typex.XDevice {
 		},
 	}
 	sd.snmpOids = map[string]snmpOid{}
+	sd.reportFilter = deadband.NewFilter()
 	return sd
 }
 
@@ -122,6 +128,9 @@ func (sd *genericSnmpDevice) Init(devId string, configMap map[string]any) error
 	if err := utils.BindSourceConfig(configMap, &sd.mainConfig); err != nil {
 		return err
 	}
+	if err := sd.mainConfig.SNMPConfig.Validate(); err != nil {
+		return err
+	}
 	snmpOids := []snmpOid{}
 	snmpOidLoadErr := interdb.InterDb().Table("m_snmp_oids").
 		Where("device_uuid=?", devId).Find(&snmpOids).Error
@@ -130,11 +139,12 @@ func (sd *genericSnmpDevice) Init(devId string, configMap map[string]any) error
 	}
 	for _, oid := range snmpOids {
 		sd.snmpOids[oid.UUID] = snmpOid{
-			UUID:      oid.UUID,
-			Oid:       oid.Oid,
-			Tag:       oid.Tag,
-			Alias:     oid.Alias,
-			Frequency: oid.Frequency,
+			UUID:         oid.UUID,
+			Oid:          oid.Oid,
+			Tag:          oid.Tag,
+			Alias:        oid.Alias,
+			Frequency:    oid.Frequency,
+			ReportPolicy: oid.ReportPolicy,
 		}
 		LastFetchTime := uint64(time.Now().UnixMilli())
 		intercache.SetValue(sd.PointId, oid.UUID, intercache.CacheValue{
@@ -172,17 +182,28 @@ func (sd *genericSnmpDevice) Init(devId string, configMap map[string]any) error
 func (sd *genericSnmpDevice) Start(cctx typex.CCTX) error {
 	sd.Ctx = cctx.Ctx
 	sd.CancelCTX = cctx.CancelCTX
+	sd.reportFilter.Reset()
 	sd.client = &gosnmp.GoSNMP{
 		Target:             sd.mainConfig.SNMPConfig.Target,
 		Port:               sd.mainConfig.SNMPConfig.Port,
-		Transport:          "udp",
+		Transport:          sd.mainConfig.SNMPConfig.Transport,
 		Community:          sd.mainConfig.SNMPConfig.Community,
-		Version:            0x1,
+		Version:            gosnmp.Version2c,
 		Timeout:            time.Duration(3) * time.Second,
 		Retries:            1,
 		ExponentialTimeout: false,
 		MaxOids:            60,
 	}
+	switch sd.mainConfig.SNMPConfig.Version {
+	case 1:
+		sd.client.Version = gosnmp.Version1
+	case 3:
+		usm := sd.mainConfig.SNMPConfig.UsmConfig
+		sd.client.Version = gosnmp.Version3
+		sd.client.SecurityModel = gosnmp.UserSecurityModel
+		sd.client.MsgFlags, sd.client.SecurityParameters = utils.SnmpUsmParameters(usm)
+		sd.client.ContextName = usm.ContextName
+	}
 	err := sd.client.Connect()
 	if err != nil {
 		glogger.GLogger.Errorf("Connect err: %v", err)
@@ -206,7 +227,9 @@ func (sd *genericSnmpDevice) Start(cctx typex.CCTX) error {
 			case <-time.After(4 * time.Millisecond):
 				// Continue loop
 			}
+			pollStart := time.Now()
 			snmpOids, err := sd.readData()
+			intermetric.ObserveDevicePoll(sd.PointId, time.Since(pollStart))
 			if err != nil {
 				glogger.GLogger.Error(err)
 				goto END
@@ -218,14 +241,23 @@ func (sd *genericSnmpDevice) Start(cctx typex.CCTX) error {
 				time.Sleep(50 * time.Second)
 				continue
 			}
-			if *sd.mainConfig.CommonConfig.BatchRequest {
-				if len(snmpOids) > 0 {
-					if bytes, err := json.Marshal(snmpOids); err != nil {
+			// 告警仍然使用全部的值, 只过滤上报的
+			if ReportValues := sd.filterReportValues(snmpOids); len(ReportValues) > 0 {
+				if *sd.mainConfig.CommonConfig.BatchRequest {
+					if bytes, err := json.Marshal(ReportValues); err != nil {
 						glogger.GLogger.Error(err)
 					} else {
 						glogger.GLogger.Debug(string(bytes))
 						sd.RuleEngine.WorkDevice(sd.Details(), string(bytes))
 					}
+				} else {
+					for _, ReportValue := range ReportValues {
+						if bytes, err := json.Marshal(ReportValue); err != nil {
+							glogger.GLogger.Error(err)
+						} else {
+							sd.RuleEngine.WorkDevice(sd.Details(), string(bytes))
+						}
+					}
 				}
 			}
 			// 是否预警
@@ -317,6 +349,25 @@ type ReadSnmpOidValue struct {
 	Value any    `json:"value"`
 }
 
+/*
+*
+* 按照点位的上报策略过滤
+*
+ */
+func (sd *genericSnmpDevice) filterReportValues(values []ReadSnmpOidValue) []ReadSnmpOidValue {
+	policies := map[string]deadband.ReportPolicy{}
+	for _, oid := range sd.snmpOids {
+		policies[oid.Tag] = oid.ReportPolicy
+	}
+	result := make([]ReadSnmpOidValue, 0, len(values))
+	for _, value := range values {
+		if sd.reportFilter.Allow(value.Tag, policies[value.Tag], fmt.Sprintf("%v", value.Value)) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
 func (sd *genericSnmpDevice) readData() ([]ReadSnmpOidValue, error) {
 	if err1 := sd.client.Connect(); err1 != nil {
 		return nil, err1
@@ -333,6 +384,7 @@ func (sd *genericSnmpDevice) readData() ([]ReadSnmpOidValue, error) {
 		return result, nil
 	}
 	wg := sync.WaitGroup{}
+	resultLocker := sync.Mutex{}
 	wg.Add(len(sd.snmpOids))
 	for _, oid := range sd.snmpOids {
 		go func(snmpOid snmpOid) {
@@ -374,20 +426,15 @@ func (sd *genericSnmpDevice) readData() ([]ReadSnmpOidValue, error) {
 				NewValue.ErrMsg = ""
 				NewValue.Value = Value
 				NewValue.Status = 1
+				resultLocker.Lock()
 				result = append(result, ReadSnmpOidValue{
 					Tag:   snmpOid.Tag,
 					Alias: snmpOid.Alias,
 					Value: Value,
 				})
+				resultLocker.Unlock()
 			}
 			intercache.SetValue(sd.PointId, snmpOid.UUID, NewValue)
-			if !*sd.mainConfig.CommonConfig.BatchRequest {
-				if bytes, errMarshal := json.Marshal(snmpOid); errMarshal != nil {
-					glogger.GLogger.Error(errMarshal)
-				} else {
-					sd.RuleEngine.WorkDevice(sd.Details(), string(bytes))
-				}
-			}
 		}(oid)
 	}
 	wg.Wait()
@@ -18,7 +18,6 @@ package iec104
 import (
 	"encoding/binary"
 	"fmt"
-	"log"
 	"math"
 	"time"
 )
@@ -39,14 +38,46 @@ const (
 	MSpNa1 = 1
 	// MDpNa1 不带时标的双点遥信，每个遥信占1个字节
 	MDpNa1 = 3
-	// MMeNc1 带品质描述的测量值，每个遥测值占3个字节
+	// MStNa1 步位置信息，1个字节的值，1个字节的品质描述
+	MStNa1 = 5
+	// MBoNa1 32位比特串，4个字节的值，1个字节的品质描述
+	MBoNa1 = 7
+	// MMeNa1 带品质描述的归一化测量值，每个遥测值占3个字节
 	MMeNa1 = 9
+	// MMeNb1 带品质描述的标度化测量值，每个遥测值占3个字节
+	MMeNb1 = 11
 	// MMeNc1 带品质描述的浮点值，每个遥测值占5个字节
 	MMeNc1 = 13
 	// MItNa1 电度总量,每个遥脉值占5个字节
 	MItNa1 = 15
+	// MMeNd1 不带品质描述的归一化测量值，每个遥测值占2个字节
+	MMeNd1 = 21
 	// MSpTb1 带游标的单点遥信，3个字节的地址，1个字节的值，7个字节短时标
 	MSpTb1 = 30
+	// MDpTb1 带时标的双点遥信
+	MDpTb1 = 31
+	// MStTb1 带时标的步位置信息
+	MStTb1 = 32
+	// MBoTb1 带时标的32位比特串
+	MBoTb1 = 33
+	// MMeTd1 带时标的归一化测量值
+	MMeTd1 = 34
+	// MMeTe1 带时标的标度化测量值
+	MMeTe1 = 35
+	// MMeTf1 带时标的浮点值
+	MMeTf1 = 36
+	// MItTb1 带时标的电度总量
+	MItTb1 = 37
+	// CScNa1 单点遥控
+	CScNa1 = 45
+	// CDcNa1 双点遥控
+	CDcNa1 = 46
+	// CSeNa1 归一化设定值
+	CSeNa1 = 48
+	// CSeNb1 标度化设定值
+	CSeNb1 = 49
+	// CSeNc1 浮点设定值
+	CSeNc1 = 50
 	// MEiNA1 初始化结束
 	MEiNA1 = 70
 	// CIcNa1 总召唤
@@ -55,98 +86,170 @@ const (
 	CCiNa1 = 101
 )
 
-// ParseASDU 解析asdu
+// 传输原因
+const (
+	CausePeriodic      = 1  // 周期
+	CauseSpontaneous   = 3  // 突发
+	CauseActivation    = 6  // 激活
+	CauseActivationCon = 7  // 激活确认
+	CauseActivationEnd = 10 // 激活终止
+	CauseInterrogated  = 20 // 响应总召唤
+	CauseCounterReq    = 37 // 响应电度总召唤
+)
+
+// 信息元素的长度(不含信息体地址)以及是否带7个字节的时标
+var elementSizes = map[byte]struct {
+	size     int
+	withTime bool
+	name     string
+}{
+	MSpNa1: {1, false, "M_SP_NA_1"},
+	MDpNa1: {1, false, "M_DP_NA_1"},
+	MStNa1: {2, false, "M_ST_NA_1"},
+	MBoNa1: {5, false, "M_BO_NA_1"},
+	MMeNa1: {3, false, "M_ME_NA_1"},
+	MMeNb1: {3, false, "M_ME_NB_1"},
+	MMeNc1: {5, false, "M_ME_NC_1"},
+	MItNa1: {5, false, "M_IT_NA_1"},
+	MMeNd1: {2, false, "M_ME_ND_1"},
+	MSpTb1: {1, true, "M_SP_TB_1"},
+	MDpTb1: {1, true, "M_DP_TB_1"},
+	MStTb1: {2, true, "M_ST_TB_1"},
+	MBoTb1: {5, true, "M_BO_TB_1"},
+	MMeTd1: {3, true, "M_ME_TD_1"},
+	MMeTe1: {3, true, "M_ME_TE_1"},
+	MMeTf1: {5, true, "M_ME_TF_1"},
+	MItTb1: {5, true, "M_IT_TB_1"},
+	CScNa1: {1, false, "C_SC_NA_1"},
+	CDcNa1: {1, false, "C_DC_NA_1"},
+	CSeNa1: {3, false, "C_SE_NA_1"},
+	CSeNb1: {3, false, "C_SE_NB_1"},
+	CSeNc1: {5, false, "C_SE_NC_1"},
+	MEiNA1: {1, false, "M_EI_NA_1"},
+	CIcNa1: {1, false, "C_IC_NA_1"},
+	CCiNa1: {1, false, "C_CI_NA_1"},
+}
+
+// TypeName 类型标识的名称, 比如 M_SP_NA_1
+func TypeName(typeID uint) string {
+	if e, ok := elementSizes[byte(typeID)]; ok && typeID < 256 {
+		return e.name
+	}
+	return fmt.Sprintf("TYPE_%d", typeID)
+}
+
+// CauseOf 去掉试验位、肯定/否定位以后的传输原因
+func (asdu *ASDU) CauseOf() uint16 {
+	return asdu.Cause & 0x3F
+}
+
+// Negative 是否为否定确认
+func (asdu *ASDU) Negative() bool {
+	return asdu.Cause&0x40 != 0
+}
+
+// ParseASDU 解析asdu, 不支持的类型不返回信号
 func (asdu *ASDU) ParseASDU(asduBytes []byte) (signals []*Signal, err error) {
-	signals = make([]*Signal, 0, 0)
-	if asduBytes == nil || len(asduBytes) < 4 {
+	signals = make([]*Signal, 0)
+	if len(asduBytes) < 6 {
 		err = fmt.Errorf("asdu[%X]非法", asduBytes)
 		return
 	}
 	asdu.TypeID = asduBytes[0]
 	asdu.Sequence, asdu.Length = asdu.ParseVariable(asduBytes[1])
-
-	var firstAddress uint32
-
 	asdu.Cause = binary.LittleEndian.Uint16([]byte{asduBytes[2], asduBytes[3]})
-
 	asdu.PublicAddress = binary.LittleEndian.Uint16([]byte{asduBytes[4], asduBytes[5]})
 
+	element, ok := elementSizes[asdu.TypeID]
+	if !ok {
+		return
+	}
+	size := element.size
+	if element.withTime {
+		size += 7
+	}
+	need := 6 + int(asdu.Length)*(3+size)
+	if asdu.Sequence {
+		need = 6 + 3 + int(asdu.Length)*size
+	}
+	if len(asduBytes) < need {
+		err = fmt.Errorf("asdu[%X]长度不足, 期望长度:%d", asduBytes, need)
+		return
+	}
+	var address uint32
+	offset := 6
 	if asdu.Sequence {
-		firstAddress = binary.LittleEndian.Uint32([]byte{asduBytes[6], asduBytes[7], asduBytes[8], 0x00})
+		address = parseIOA(asduBytes[6:9])
+		offset = 9
 	}
 	for i := 0; i < int(asdu.Length); i++ {
 		s := new(Signal)
 		s.TypeID = uint(asdu.TypeID)
 		if asdu.Sequence {
-			s.Address = firstAddress
-			firstAddress++
+			s.Address = address
+			address++
+		} else {
+			s.Address = parseIOA(asduBytes[offset : offset+3])
+			offset += 3
 		}
-		switch asdu.TypeID {
-		case MSpNa1, MDpNa1:
-			size := 4
-			if asdu.Sequence {
-				s.Value = float64(asduBytes[9+i])
-			} else {
-				s.Address = binary.LittleEndian.Uint32([]byte{asduBytes[6+i*size], asduBytes[6+i*size+1], asduBytes[6+i*size+2], 0x00})
-				s.Value = float64(asduBytes[6+i*size+3])
-			}
-		case MMeNa1:
-			size := 6
-			if asdu.Sequence {
-				size := 3
-				s.Value = float64(binary.LittleEndian.Uint16([]byte{asduBytes[9+i*size], asduBytes[9+i*size+1]}))
-				s.Quality = asduBytes[9+i*size+2]
-			} else {
-				s.Address = binary.LittleEndian.Uint32([]byte{asduBytes[6+i*size], asduBytes[6+i*size+1], asduBytes[6+i*size+2], 0x00})
-				s.Value = float64(binary.LittleEndian.Uint16([]byte{asduBytes[6+i*size+3], asduBytes[6+i*size+4]}))
-				s.Quality = asduBytes[6+i*size+5]
-			}
-		case MMeNc1:
-			size := 8
-			if asdu.Sequence {
-				size := 5
-				s.Value = float64(math.Float32frombits(binary.LittleEndian.Uint32([]byte{
-					asduBytes[9+i*size], asduBytes[9+i*size+1],
-					asduBytes[9+i*size+2], asduBytes[9+i*size+3],
-				})))
-				s.Quality = asduBytes[9+i*size+4]
-			} else {
-				s.Address = binary.LittleEndian.Uint32([]byte{asduBytes[6+i*size], asduBytes[6+i*size+1], asduBytes[6+i*size+2], 0x00})
-				s.Value = float64(math.Float32frombits(binary.LittleEndian.Uint32([]byte{
-					asduBytes[6+i*size+3], asduBytes[6+i*size+4],
-					asduBytes[6+i*size+5], asduBytes[6+i*size+6],
-				})))
-				s.Quality = asduBytes[6+i*size+7]
-			}
-		case MItNa1:
-			size := 8
-			if asdu.Sequence {
-				size := 5
-				s.Value = float64(binary.LittleEndian.Uint32([]byte{
-					asduBytes[9+i*size], asduBytes[9+i*size+1],
-					asduBytes[9+i*size+2], asduBytes[9+i*size+3],
-				}))
-			} else {
-				s.Address = binary.LittleEndian.Uint32([]byte{asduBytes[6+i*size], asduBytes[6+i*size+1], asduBytes[6+i*size+2], 0x00})
-				s.Value = float64(binary.LittleEndian.Uint32([]byte{
-					asduBytes[6+i*size+3], asduBytes[9+i*size+4],
-					asduBytes[9+i*size+5], asduBytes[9+i*size+6],
-				}))
-			}
-		case MSpTb1:
-			size := 11
-			s.Address = binary.LittleEndian.Uint32([]byte{asduBytes[6+i*size], asduBytes[6+i*size+1], asduBytes[6+i*size+2], 0x00})
-			s.Value = float64(asduBytes[6+i*size+3])
-			s.Ts = asdu.ParseTime(asduBytes[6+i*size+4 : 6+i*size+11])
-		case CIcNa1, CCiNa1, MEiNA1, 21:
-		default:
-			log.Fatalln("暂不支持的数据类型:", asdu.TypeID)
+		s.Value, s.Quality = parseElement(asdu.TypeID, asduBytes[offset:offset+element.size])
+		if element.withTime {
+			s.Ts = asdu.ParseTime(asduBytes[offset+element.size : offset+size])
 		}
+		offset += size
 		signals = append(signals, s)
 	}
 	return
 }
 
+// parseIOA 解析3个字节的信息体地址
+func parseIOA(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+// parseElement 解析信息元素, 返回值和品质描述
+func parseElement(typeID byte, b []byte) (float64, byte) {
+	switch typeID {
+	case MSpNa1, MSpTb1:
+		return float64(b[0] & 0x01), b[0] & 0xF0
+	case MDpNa1, MDpTb1:
+		return float64(b[0] & 0x03), b[0] & 0xF0
+	case MStNa1, MStTb1:
+		// 低7位为有符号的步位置, 最高位为瞬变状态
+		return float64(int8(b[0]<<1) >> 1), b[1]
+	case MBoNa1, MBoTb1:
+		return float64(binary.LittleEndian.Uint32(b[0:4])), b[4]
+	case MMeNa1, MMeTd1:
+		return normalize(b[0:2]), b[2]
+	case MMeNd1:
+		return normalize(b[0:2]), 0
+	case MMeNb1, MMeTe1:
+		return float64(int16(binary.LittleEndian.Uint16(b[0:2]))), b[2]
+	case MMeNc1, MMeTf1:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b[0:4]))), b[4]
+	case MItNa1, MItTb1:
+		// 最后一个字节的高3位为 IV、CA、CY
+		return float64(int32(binary.LittleEndian.Uint32(b[0:4]))), b[4] & 0xE0
+	case CScNa1:
+		return float64(b[0] & 0x01), b[0]
+	case CDcNa1:
+		return float64(b[0] & 0x03), b[0]
+	case CSeNa1:
+		return normalize(b[0:2]), b[2]
+	case CSeNb1:
+		return float64(int16(binary.LittleEndian.Uint16(b[0:2]))), b[2]
+	case CSeNc1:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b[0:4]))), b[4]
+	}
+	// 召唤、初始化结束之类只有一个限定词
+	return float64(b[0]), 0
+}
+
+// normalize 归一化值, 范围 [-1, 1)
+func normalize(b []byte) float64 {
+	return float64(int16(binary.LittleEndian.Uint16(b))) / 32768
+}
+
 // ParseVariable 解析asdu可变结构限定词
 func (asdu *ASDU) ParseVariable(b byte) (sq bool, length byte) {
 	// 最高位是否为1
@@ -167,10 +270,11 @@ func (asdu *ASDU) ParseTime(asduBytes []byte) float64 {
 	milliseconds := binary.LittleEndian.Uint16([]byte{asduBytes[0], asduBytes[1]})
 	nanosecond := (int(milliseconds) % 1000) * 1000000
 	second := int(milliseconds / 1000)
-	minute := int(asduBytes[2])
-	hour := int(asduBytes[3])
-	day := int(asduBytes[4])
-	month := int(asduBytes[5])
-	year := int(asduBytes[6]) + 2000
+	// 高位分别是无效位、夏令时、星期, 需要去掉
+	minute := int(asduBytes[2] & 0x3F)
+	hour := int(asduBytes[3] & 0x1F)
+	day := int(asduBytes[4] & 0x1F)
+	month := int(asduBytes[5] & 0x0F)
+	year := int(asduBytes[6]&0x7F) + 2000
 	return float64(time.Date(year, time.Month(month), day, hour, minute, second, nanosecond, time.Local).Unix()) + float64(nanosecond)/1000000000.0
 }
@@ -18,168 +18,171 @@ package iec104
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
 var (
-	contextTimeout    = 30 * time.Second
-	dialTimeout       = 5 * time.Second
-	testInterval      = 20 * time.Second
-	totalCallInterval = 20 * time.Second
-	retryTimes        = 3 // 存在备用服务器时，单个服务器重试次数
+	contextTimeout = 30 * time.Second // 超过这个时间没有收到任何报文就认为连接断开
+	dialTimeout    = 5 * time.Second
+	testInterval   = 20 * time.Second // 空闲超过这个时间发送测试帧
+	commandTimeout = 10 * time.Second // 等待遥控、设定值激活确认的时间
 )
 
+var errClosed = errors.New("iec104 connection closed")
+
 // Client 104客户端
 type Client struct {
-	address    string
-	subAddress string
-	curAddress string
+	address       string
+	CommonAddress uint16 // 公共地址, 默认为1
 	DataHandlerInterface
-	conn        net.Conn
-	IsConnected bool
-	cancel      context.CancelFunc
-	Logger      *logrus.Logger
-	rsn         int16
-	ssn         int16
-	dataChan    chan *APDU
-	sendChan    chan []byte
-	iFrameNum   int
-	task        func(c *APDU)
-	wg          *sync.WaitGroup
-	tlsConfig   *tls.Config
-	needCert    bool
+	conn          net.Conn
+	IsConnected   bool
+	ctx           context.Context
+	cancel        context.CancelFunc
+	Logger        logrus.FieldLogger
+	locker        sync.Mutex // 保护收发序号
+	rsn           int16
+	ssn           int16
+	dataChan      chan *APDU
+	sendChan      chan []byte
+	iFrameNum     int
+	tlsConfig     *tls.Config
+	needCert      bool
+	started       chan struct{} // 收到启动确认以后关闭
+	startOnce     sync.Once
+	closeOnce     sync.Once
+	err           error
+	lastRecv      atomic.Int64
+	pendingLocker sync.Mutex
+	pending       map[uint64]chan *ASDU // 等待激活确认的命令
 }
 
 type DataHandlerInterface interface {
 	Datahandler(*APDU) error
 }
 
-// NewClient 初始化客户端,连接失败，每隔10秒重试
-func NewClient(handler DataHandlerInterface, address string, logger *logrus.Logger, tlsConfig *tls.Config, Cert bool) *Client {
+// NewClient 初始化客户端, 连接断开以后不会自动重连, 由调用方重新创建
+func NewClient(handler DataHandlerInterface, address string, logger logrus.FieldLogger, tlsConfig *tls.Config, Cert bool) *Client {
 	cli := &Client{
-		address:    address,
-		curAddress: address,
-		dataChan:   make(chan *APDU, 1),
-		sendChan:   make(chan []byte, 1),
-		Logger:     logger,
-		wg:         new(sync.WaitGroup),
-		tlsConfig:  new(tls.Config),
-		needCert:   Cert,
+		address:       address,
+		CommonAddress: 1,
+		dataChan:      make(chan *APDU, 64),
+		sendChan:      make(chan []byte, 16),
+		Logger:        logger,
+		tlsConfig:     new(tls.Config),
+		needCert:      Cert,
+		started:       make(chan struct{}),
+		pending:       map[uint64]chan *ASDU{},
 	}
 	if tlsConfig != nil {
 		cli.tlsConfig = tlsConfig
 	}
 	cli.DataHandlerInterface = handler
+	cli.ctx, cli.cancel = context.WithCancel(context.Background())
 	return cli
 }
 
-// Run 运行
-func (c *Client) Run() {
-	if c.needCert == false {
-		c.conn = c.dail()
+// Run 连接服务器并发送启动激活帧
+func (c *Client) Run() error {
+	c.Logger.Infof("开始连接服务器:%v", c.address)
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var err error
+	if c.needCert {
+		c.conn, err = tls.DialWithDialer(dialer, "tcp", c.address, c.tlsConfig)
 	} else {
-		conn, err := tls.Dial("tcp", c.curAddress, c.tlsConfig)
-		if err != nil {
-			i := 0
-			for {
-				conn, err = tls.Dial("tcp", c.curAddress, c.tlsConfig)
-				if err != nil {
-					time.Sleep(dialTimeout)
-					i++
-					c.Logger.Infof("连接服务器失败，开始第%d次重试", i+1)
-				} else {
-					c.Logger.Infoln("连接服务器成功")
-					break
-				}
-			}
-		}
-		c.conn = conn
+		c.conn, err = dialer.DialContext(c.ctx, "tcp", c.address)
 	}
-	c.sendUFrame(startDtAct)
-	c.IsConnected = true
-	ctx, cancel := context.WithCancel(context.Background())
-	c.cancel = cancel
-	c.wg.Add(3)
-	go c.read(ctx)
-	go c.write(ctx)
-	go c.handler(ctx)
+	if err != nil {
+		return err
+	}
+	c.Logger.Infoln("连接服务器成功")
 	c.rsn = 0
 	c.ssn = 0
 	c.iFrameNum = 0
+	c.IsConnected = true
+	c.lastRecv.Store(time.Now().UnixMilli())
+	go c.read(c.ctx)
+	go c.write(c.ctx)
+	go c.handler(c.ctx)
+	return c.sendUFrame(startDtAct)
 }
 
-// 建立tcp连接，支持重试和主备切换
-func (c *Client) dail() net.Conn {
-	var conn net.Conn
-	var err error
-	c.Logger.Infof("开始连接服务器:%v", c.curAddress)
-	i := -1
-	for {
-		conn, err = net.DialTimeout("tcp", c.curAddress, dialTimeout)
-		if err != nil {
-			time.Sleep(dialTimeout)
-			i++
-			if i == retryTimes && c.subAddress != "" {
-				i = 0
-				if c.curAddress == c.address {
-					c.curAddress = c.subAddress
-				} else {
-					c.curAddress = c.address
-				}
-				c.Logger.Infof("尝试超过3次，切换服务器为:%s,开始第%d次重试", c.curAddress, i+1)
-			} else {
-				c.Logger.Infof("连接服务器失败，开始第%d次重试", i+1)
-			}
-		} else {
-			c.Logger.Infoln("连接服务器成功")
-			break
+// Started 收到启动确认以后关闭, 之后才能发送I帧
+func (c *Client) Started() <-chan struct{} {
+	return c.started
+}
+
+// Done 连接断开以后关闭
+func (c *Client) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Err 连接断开的原因
+func (c *Client) Err() error {
+	return c.err
+}
+
+// closeWithError 关闭连接, 只执行一次
+func (c *Client) closeWithError(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		c.IsConnected = false
+		c.cancel()
+		if c.conn != nil {
+			c.conn.Close()
 		}
-	}
-	return conn
+	})
 }
 
 // Read 读数据
 func (c *Client) read(ctx context.Context) {
 	c.Logger.Info("socket读协程启动")
-	defer func() {
-		// c.cancel()
-		c.wg.Done()
-		c.Logger.Info("socket读协程停止")
-	}()
+	defer c.Logger.Info("socket读协程停止")
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			err := c.parseData(ctx)
-			if err != nil {
+			if err := c.parseData(); err != nil {
+				c.closeWithError(err)
 				return
 			}
 		}
 	}
 }
 
-// Write 写数据
+// Write 写数据, 空闲的时候发送测试帧
 func (c *Client) write(ctx context.Context) {
 	c.Logger.Info("socket写协程启动")
-	defer func() {
-		// c.cancel()
-		c.wg.Done()
-		c.Logger.Info("socket写协程停止")
-	}()
+	defer c.Logger.Info("socket写协程停止")
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case data := <-c.sendChan:
-			_, err := c.conn.Write(data)
-			if err != nil {
+			if _, err := c.conn.Write(data); err != nil {
+				c.closeWithError(err)
 				return
 			}
+		case <-ticker.C:
+			idle := time.Since(time.UnixMilli(c.lastRecv.Load()))
+			if idle >= testInterval && idle < testInterval+time.Second {
+				c.Logger.Debugln("链路空闲, 发送测试激活帧")
+				if _, err := c.conn.Write(convertBytes(convert4BytesToSlice(testFrAct))); err != nil {
+					c.closeWithError(err)
+					return
+				}
+			}
 		}
 	}
 }
@@ -187,16 +190,16 @@ func (c *Client) write(ctx context.Context) {
 // handler 处理接收到的已解析数据
 func (c *Client) handler(ctx context.Context) {
 	c.Logger.Info("数据处理协程启动")
-	defer func() {
-		// c.cancel()
-		c.wg.Done()
-		c.Logger.Info("数据接收协程停止")
-	}()
+	defer c.Logger.Info("数据处理协程停止")
 	for {
 		select {
 		case resp := <-c.dataChan:
 			c.Logger.Debugf("接收到数据类型:%d,原因:%d,长度:%d", resp.ASDU.TypeID, resp.ASDU.Cause, len(resp.Signals))
-			go c.Datahandler(resp)
+			if c.DataHandlerInterface != nil {
+				if err := c.Datahandler(resp); err != nil {
+					c.Logger.Warnf("处理数据异常: %v", err)
+				}
+			}
 		case <-ctx.Done():
 			return
 		}
@@ -204,86 +207,66 @@ func (c *Client) handler(ctx context.Context) {
 }
 
 // ParseData 解析接收到的数据
-func (c *Client) parseData(ctx context.Context) error {
-	handleErr := func(tag string, err error) {
-		c.Logger.Errorf("%s read socket读操作异常: %v", tag, err)
-	}
-
+func (c *Client) parseData() error {
 	buf := make([]byte, 2)
 	// 读取启动符和长度
-	n, err := c.conn.Read(buf)
-	if err != nil {
-		handleErr("读取启动符和长度", err)
-		return err
+	c.conn.SetReadDeadline(time.Now().Add(contextTimeout))
+	if _, err := io.ReadFull(c.conn, buf); err != nil {
+		return fmt.Errorf("读取启动符和长度异常: %v", err)
 	}
-	c.conn.SetDeadline(time.Now().Add(contextTimeout))
-	if n == 0 {
-		c.Logger.Info("读取到空数据,10s后继续读取数据")
-		time.Sleep(10 * time.Second)
-		return nil
+	if buf[0] != startFrame {
+		return fmt.Errorf("非法的启动符: %X", buf[0])
 	}
 	length := int(buf[1])
 	// 读取正文
 	contentBuf := make([]byte, length)
-	n, err = c.conn.Read(contentBuf)
-	if err != nil {
-		handleErr("读取正文", err)
-		return err
-	}
-	// 长度不够继续读取，直至达到期望长度
-	i := 1
-	for n < length {
-		i++
-		nextLength := length - n
-		nextBuf := make([]byte, nextLength)
-		m, err := c.conn.Read(nextBuf)
-		if err != nil {
-			handleErr("循环读取正文", err)
-			return err
-		}
-		contentBuf = append(contentBuf[:n], nextBuf[:m]...)
-		n = len(contentBuf)
-		c.Logger.Debugf("循环读取数据，当前为第%d次读取，期望长度:%d,本次长度:%d,当前总长度:%d", i, length, m, n)
+	if _, err := io.ReadFull(c.conn, contentBuf); err != nil {
+		return fmt.Errorf("读取正文异常: %v", err)
 	}
-	c.Logger.Debugf("收到原始数据: [% X],rsn:%d,ssn:%d,长度:%d", append(buf, contentBuf[:n]...), c.rsn, c.ssn, 2+len(contentBuf[:n]))
+	c.lastRecv.Store(time.Now().UnixMilli())
+	c.Logger.Debugf("收到原始数据: [% X],rsn:%d,ssn:%d,长度:%d", append(buf, contentBuf...), c.rsn, c.ssn, 2+length)
 	apdu := new(APDU)
-	err = apdu.parseAPDU(contentBuf[:n])
-	if err != nil {
+	if err := apdu.parseAPDU(contentBuf); err != nil {
 		c.Logger.Warnf("解析APDU异常: %v", err)
-		c.Logger.Panicln("退出程序")
-		return err
+		// 解析不了的I帧也要确认, 否则对端会因为未确认的帧太多而断开
+		if length >= 4 && contentBuf[0]&0x01 == iFrame {
+			c.incrRsn()
+			return c.sendSFrame()
+		}
+		return nil
 	}
 	switch apdu.CtrFrame.(type) {
 	case IFrame:
 		c.incrRsn()
 		switch apdu.ASDU.TypeID {
 		case MEiNA1:
-			c.Logger.Info("接收到初始化结束，开始发送总召唤")
-			c.sendSFrame()
-			// c.SendTotalCall()
+			c.Logger.Info("接收到初始化结束")
 		case CIcNa1:
-			if apdu.ASDU.Cause == 7 {
+			if apdu.ASDU.CauseOf() == CauseActivationCon {
 				c.Logger.Info("接收总召唤确认帧")
-				c.sendSFrame()
-			} else if apdu.ASDU.Cause == 10 {
+			} else if apdu.ASDU.CauseOf() == CauseActivationEnd {
 				c.Logger.Info("接收总召唤结束帧")
-				c.sendSFrame()
-				c.Logger.Info("发送电度总召唤")
-				c.SendElectricityTotalCall()
 			}
 		case CCiNa1:
-			if apdu.ASDU.Cause == 7 {
+			if apdu.ASDU.CauseOf() == CauseActivationCon {
 				c.Logger.Info("接收电度总召唤确认帧")
-			} else if apdu.ASDU.Cause == 10 {
+			} else if apdu.ASDU.CauseOf() == CauseActivationEnd {
 				c.Logger.Info("接收电度总召唤结束帧")
 			}
-			c.sendSFrame()
+		case CScNa1, CDcNa1, CSeNa1, CSeNb1, CSeNc1:
+			if apdu.ASDU.CauseOf() == CauseActivationCon && len(apdu.Signals) > 0 {
+				c.confirm(apdu.ASDU, apdu.Signals[0].Address)
+			}
 		default:
 			c.iFrameNum++
 			c.Logger.Debugf("接收到第%d个I帧", c.iFrameNum)
-			c.dataChan <- apdu
-			c.sendSFrame()
+			select {
+			case c.dataChan <- apdu:
+			case <-c.ctx.Done():
+				return errClosed
+			}
 		}
+		return c.sendSFrame()
 	case SFrame:
 		c.Logger.Debugln("接收到S帧")
 	case UFrame:
@@ -291,11 +274,13 @@ func (c *Client) parseData(ctx context.Context) error {
 		uFrame := apdu.CtrFrame.(UFrame)
 		switch uFrame.cmd {
 		case startDtCon:
-			c.Logger.Info("U帧为启动确认帧，发送总召唤")
-			// c.SendTotalCall()
+			c.Logger.Info("U帧为启动确认帧")
+			c.startOnce.Do(func() { close(c.started) })
 		case testFrAct:
 			c.Logger.Info("U帧为测试激活帧,发送测试确认帧")
-			c.sendUFrame(testFrCon)
+			return c.sendUFrame(testFrCon)
+		case testFrCon:
+			c.Logger.Debugln("U帧为测试确认帧")
 		}
 	default:
 		c.Logger.Debugln("接收到未知帧")
@@ -303,115 +288,140 @@ func (c *Client) parseData(ctx context.Context) error {
 	return nil
 }
 
+// send 放进发送队列
+func (c *Client) send(data []byte) error {
+	select {
+	case c.sendChan <- data:
+		return nil
+	case <-c.ctx.Done():
+		return errClosed
+	}
+}
+
 // sendUFrame 发送U帧
-func (c *Client) sendUFrame(cmd [4]byte) {
+func (c *Client) sendUFrame(cmd [4]byte) error {
 	data := convertBytes(convert4BytesToSlice(cmd))
 	c.Logger.Debugf("发送U帧: [% X]", data)
-	c.sendChan <- data
+	return c.send(data)
 }
 
 // sendSFrame 发送S帧
-func (c *Client) sendSFrame() {
+func (c *Client) sendSFrame() error {
+	c.locker.Lock()
 	rsnBytes := parseLittleEndianUInt16(uint16(c.rsn << 1))
-	sendBytes := make([]byte, 0, 0)
+	c.locker.Unlock()
+	sendBytes := make([]byte, 0, 4)
 	sendBytes = append(sendBytes, 0x01, 0x00)
 	sendBytes = append(sendBytes, rsnBytes...)
 	data := convertBytes(sendBytes)
 	c.Logger.Debugf("发送S帧: [% X]", data)
-	c.sendChan <- data
+	return c.send(data)
+}
+
+// sendIFrame 发送I帧, 发送序号加1
+func (c *Client) sendIFrame(asdu []byte) error {
+	c.locker.Lock()
+	iFrameData := make([]byte, 0, 4+len(asdu))
+	iFrameData = append(iFrameData, parseLittleEndianUInt16(uint16(c.ssn<<1))...)
+	iFrameData = append(iFrameData, parseLittleEndianUInt16(uint16(c.rsn<<1))...)
+	iFrameData = append(iFrameData, asdu...)
+	c.ssn = (c.ssn + 1) & 0x7FFF
+	c.locker.Unlock()
+	data := convertBytes(iFrameData)
+	c.Logger.Debugf("发送I帧: [% X]", data)
+	return c.send(data)
 }
 
 // SendTotalCall 发送总召唤
-func (c *Client) SendTotalCall() {
-	ssnBytes := parseLittleEndianUInt16(uint16(c.ssn << 1))
-	rsnBytes := parseLittleEndianUInt16(uint16(c.rsn << 1))
-	totalCallData := make([]byte, 0, 0)
-	totalCallData = append(totalCallData, ssnBytes...)
-	totalCallData = append(totalCallData, rsnBytes...)
-	totalCallData = append(totalCallData, 0x64, 0x01, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x14)
-	data := convertBytes(totalCallData)
-	c.Logger.Debugf("发送总召唤: [% X]", data)
-	c.sendChan <- data
+func (c *Client) SendTotalCall() error {
+	c.Logger.Debugln("发送总召唤")
+	return c.sendIFrame(NewInterrogation(c.CommonAddress))
 }
 
-// SendSingleCmd 发送单点遥控
-func (c *Client) SendSingleCmd(address uint, val uint) (err error) {
-	ssnBytes := parseLittleEndianUInt16(uint16(c.ssn << 1))
-	rsnBytes := parseLittleEndianUInt16(uint16(c.rsn << 1))
-	singleData := make([]byte, 0, 0)
-	singleData = append(singleData, ssnBytes...)
-	singleData = append(singleData, rsnBytes...)
-	singleData = append(singleData, 0x2D, 0x01, 0x06, 0x00, 0x01, 0x00)
-	singleData = append(singleData, byte(address), byte(address>>8), byte(address>>16))
-	if val == 0 {
-		singleData = append(singleData, 0x01)
-	} else {
-		singleData = append(singleData, 0x02)
+// SendElectricityTotalCall 发送电度总召唤
+func (c *Client) SendElectricityTotalCall() error {
+	c.Logger.Debugln("发送电度总召唤")
+	return c.sendIFrame(NewCounterInterrogation(c.CommonAddress))
+}
+
+// SendSingleCmd 发送单点遥控, val 为0是分, 否则为合
+func (c *Client) SendSingleCmd(address uint32, val uint) error {
+	return c.SendCommand(NewSingleCommand(c.CommonAddress, address, val != 0))
+}
+
+// SendDoubleCmd 发送双点遥控, val 为0是分, 否则为合
+func (c *Client) SendDoubleCmd(address uint32, val uint) error {
+	return c.SendCommand(NewDoubleCommand(c.CommonAddress, address, val != 0))
+}
+
+// SendCommand 发送遥控或者设定值, 等待激活确认, 否定确认返回错误
+func (c *Client) SendCommand(asdu []byte) error {
+	select {
+	case <-c.started:
+	default:
+		return fmt.Errorf("iec104 link not started")
+	}
+	if len(asdu) < 9 {
+		return fmt.Errorf("invalid command asdu: [% X]", asdu)
+	}
+	key := pendingKey(asdu[0], parseIOA(asdu[6:9]))
+	ch := make(chan *ASDU, 1)
+	c.pendingLocker.Lock()
+	if _, ok := c.pending[key]; ok {
+		c.pendingLocker.Unlock()
+		return fmt.Errorf("command is pending: %s, ioa=%d", TypeName(uint(asdu[0])), parseIOA(asdu[6:9]))
+	}
+	c.pending[key] = ch
+	c.pendingLocker.Unlock()
+	defer func() {
+		c.pendingLocker.Lock()
+		delete(c.pending, key)
+		c.pendingLocker.Unlock()
+	}()
+	if err := c.sendIFrame(asdu); err != nil {
+		return err
+	}
+	select {
+	case resp := <-ch:
+		if resp.Negative() {
+			return fmt.Errorf("command rejected: %s, ioa=%d", TypeName(uint(asdu[0])), parseIOA(asdu[6:9]))
+		}
+		return nil
+	case <-time.After(commandTimeout):
+		return fmt.Errorf("command confirm timeout: %s, ioa=%d", TypeName(uint(asdu[0])), parseIOA(asdu[6:9]))
+	case <-c.ctx.Done():
+		return errClosed
 	}
-	data := convertBytes(singleData)
-	c.Logger.Debugf("发送发送单点遥控: [% X]", data)
-	c.sendChan <- data
-	return nil
 }
 
-// SendDoubleCmd 发送双点遥控
-func (c *Client) SendDoubleCmd(address uint, val uint) (err error) {
-	ssnBytes := parseLittleEndianUInt16(uint16(c.ssn << 1))
-	rsnBytes := parseLittleEndianUInt16(uint16(c.rsn << 1))
-	doubleData := make([]byte, 0, 0)
-	doubleData = append(doubleData, ssnBytes...)
-	doubleData = append(doubleData, rsnBytes...)
-	doubleData = append(doubleData, 0x2E, 0x01, 0x06, 0x00, 0x01, 0x00)
-	doubleData = append(doubleData, byte(address), byte(address>>8), byte(address>>16))
-	if val == 0 {
-		doubleData = append(doubleData, 0x01)
-	} else {
-		doubleData = append(doubleData, 0x02)
+// confirm 收到命令的激活确认
+func (c *Client) confirm(asdu *ASDU, ioa uint32) {
+	c.pendingLocker.Lock()
+	ch, ok := c.pending[pendingKey(asdu.TypeID, ioa)]
+	c.pendingLocker.Unlock()
+	if !ok {
+		c.Logger.Warnf("收到未知命令的确认: %s, ioa=%d", TypeName(uint(asdu.TypeID)), ioa)
+		return
+	}
+	select {
+	case ch <- asdu:
+	default:
 	}
-	data := convertBytes(doubleData)
-	c.Logger.Debugf("发送发送双点遥控: [% X]", data)
-	c.sendChan <- data
-	return nil
 }
 
-// SendTotalCall 发送电度总召唤
-func (c *Client) SendElectricityTotalCall() {
-	ssnBytes := parseLittleEndianUInt16(uint16(c.ssn << 1))
-	rsnBytes := parseLittleEndianUInt16(uint16(c.rsn << 1))
-	totalCallData := make([]byte, 0, 0)
-	totalCallData = append(totalCallData, ssnBytes...)
-	totalCallData = append(totalCallData, rsnBytes...)
-	totalCallData = append(totalCallData, 0x65, 0x01, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x05)
-	data := convertBytes(totalCallData)
-	c.Logger.Debugf("发送电度总召唤: [% X]", data)
-	c.sendChan <- data
+func pendingKey(typeID byte, ioa uint32) uint64 {
+	return uint64(typeID)<<32 | uint64(ioa)
 }
 
 // incrRsn 增加rsn
 func (c *Client) incrRsn() {
-	c.rsn++
-	if c.rsn < 0 {
-		c.rsn = 0
-	}
+	c.locker.Lock()
+	c.rsn = (c.rsn + 1) & 0x7FFF
+	c.locker.Unlock()
 }
 
-//func (c *Client) handleSignal() {
-//	signals := make(chan os.Signal, 1)
-//	signal.Notify(signals, os.Kill, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-//	<-signals
-//	c.cancel()
-//	//c.wg.Wait()
-//	if c.conn != nil {
-//		c.conn.Close()
-//	}
-//	c.Logger.Println("断开服务器连接，程序关闭")
-//	os.Exit(0)
-//}
-
 // Close 结束程序
 func (c *Client) Close() error {
-	c.IsConnected = false
-	c.cancel()
-	c.conn.Close()
+	c.closeWithError(errClosed)
 	return nil
 }
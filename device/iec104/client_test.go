@@ -0,0 +1,115 @@
+package iec104
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCommand_RoundTrip(t *testing.T) {
+	asdu, err := NewSetpointNormalized(1, 0x4001, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		asdu  []byte
+		value float64
+	}{
+		{NewSingleCommand(1, 0x6001, true), 1},
+		{NewDoubleCommand(1, 0x6002, false), 1},
+		{asdu, 0.5},
+		{NewSetpointFloat(1, 0x4002, 21.5), 21.5},
+	}
+	for _, c := range cases {
+		signals, err := new(ASDU).ParseASDU(c.asdu)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(signals) != 1 || signals[0].Value != c.value {
+			t.Fatalf("%s: unexpected signals %+v", TypeName(uint(c.asdu[0])), signals[0])
+		}
+	}
+	if _, err := NewSetpointNormalized(1, 1, 1); err == nil {
+		t.Fatal("normalized value 1 must be rejected")
+	}
+	if _, err := new(ASDU).ParseASDU([]byte{MMeNc1, 0x02, 0x03, 0x00, 0x01, 0x00, 0x01, 0x00}); err == nil {
+		t.Fatal("truncated asdu must be rejected")
+	}
+}
+
+type testHandler chan *APDU
+
+func (h testHandler) Datahandler(apdu *APDU) error {
+	h <- apdu
+	return nil
+}
+
+// 模拟一个子站: 回复启动确认, 上送一个突发浮点值, 确认遥控
+func TestClient_Loopback(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		readFrame := func() []byte {
+			head := make([]byte, 2)
+			if _, err := io.ReadFull(conn, head); err != nil {
+				return nil
+			}
+			body := make([]byte, head[1])
+			io.ReadFull(conn, body)
+			return body
+		}
+		readFrame() // STARTDT act
+		conn.Write(convertBytes(convert4BytesToSlice(startDtCon)))
+		value := binary.LittleEndian.AppendUint32(nil, math.Float32bits(12.5))
+		spont := []byte{MMeNc1, 0x01, CauseSpontaneous, 0x00, 0x01, 0x00, 0x01, 0x40, 0x00}
+		conn.Write(convertBytes(append([]byte{0x00, 0x00, 0x00, 0x00}, append(append(spont, value...), 0x00)...)))
+		for {
+			frame := readFrame()
+			if frame == nil {
+				return
+			}
+			if frame[0]&0x01 == 0 && frame[4] == CScNa1 {
+				confirm := append([]byte{}, frame[4:]...)
+				confirm[2] = CauseActivationCon
+				conn.Write(convertBytes(append([]byte{0x02, 0x00, 0x02, 0x00}, confirm...)))
+			}
+		}
+	}()
+
+	handler := make(testHandler, 1)
+	client := NewClient(handler, listener.Addr().String(), logrus.NewEntry(logrus.New()), nil, false)
+	defer client.Close()
+	if err := client.Run(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-client.Started():
+	case <-time.After(3 * time.Second):
+		t.Fatal("STARTDT confirm timeout")
+	}
+	select {
+	case apdu := <-handler:
+		if len(apdu.Signals) != 1 || apdu.Signals[0].Address != 0x4001 ||
+			apdu.Signals[0].Value != 12.5 || apdu.ASDU.CauseOf() != CauseSpontaneous {
+			t.Fatalf("unexpected apdu: %+v", apdu.Signals[0])
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("spontaneous data timeout")
+	}
+	if err := client.SendSingleCmd(0x6001, 1); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package iec104
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// 召唤限定词
+const (
+	QOIStation       = 0x14 // 站召唤
+	QCCGeneral       = 0x05 // 总的请求计数量
+	SelectCommandBit = 0x80 // 选择/执行位, 置1为选择
+)
+
+// NewASDU 生成只有一个信息对象的asdu, 传输原因固定为激活
+func NewASDU(typeID byte, commonAddress uint16, ioa uint32, element []byte) []byte {
+	data := make([]byte, 0, 9+len(element))
+	data = append(data, typeID, 0x01, CauseActivation, 0x00)
+	data = binary.LittleEndian.AppendUint16(data, commonAddress)
+	data = append(data, byte(ioa), byte(ioa>>8), byte(ioa>>16))
+	return append(data, element...)
+}
+
+// NewInterrogation 总召唤
+func NewInterrogation(commonAddress uint16) []byte {
+	return NewASDU(CIcNa1, commonAddress, 0, []byte{QOIStation})
+}
+
+// NewCounterInterrogation 电度总召唤
+func NewCounterInterrogation(commonAddress uint16) []byte {
+	return NewASDU(CCiNa1, commonAddress, 0, []byte{QCCGeneral})
+}
+
+// NewSingleCommand 单点遥控, SCS: 0 分, 1 合
+func NewSingleCommand(commonAddress uint16, ioa uint32, on bool) []byte {
+	sco := byte(0x00)
+	if on {
+		sco = 0x01
+	}
+	return NewASDU(CScNa1, commonAddress, ioa, []byte{sco})
+}
+
+// NewDoubleCommand 双点遥控, DCS: 1 分, 2 合
+func NewDoubleCommand(commonAddress uint16, ioa uint32, on bool) []byte {
+	dco := byte(0x01)
+	if on {
+		dco = 0x02
+	}
+	return NewASDU(CDcNa1, commonAddress, ioa, []byte{dco})
+}
+
+// NewSetpointNormalized 归一化设定值, 取值范围 [-1, 1)
+func NewSetpointNormalized(commonAddress uint16, ioa uint32, value float64) ([]byte, error) {
+	if value < -1 || value >= 1 {
+		return nil, fmt.Errorf("normalized value out of range [-1, 1): %v", value)
+	}
+	element := binary.LittleEndian.AppendUint16(nil, uint16(int16(math.Round(value*32768))))
+	return NewASDU(CSeNa1, commonAddress, ioa, append(element, 0x00)), nil
+}
+
+// NewSetpointScaled 标度化设定值
+func NewSetpointScaled(commonAddress uint16, ioa uint32, value float64) ([]byte, error) {
+	if value < math.MinInt16 || value > math.MaxInt16 {
+		return nil, fmt.Errorf("scaled value out of range [-32768, 32767]: %v", value)
+	}
+	element := binary.LittleEndian.AppendUint16(nil, uint16(int16(math.Round(value))))
+	return NewASDU(CSeNb1, commonAddress, ioa, append(element, 0x00)), nil
+}
+
+// NewSetpointFloat 浮点设定值
+func NewSetpointFloat(commonAddress uint16, ioa uint32, value float64) []byte {
+	element := binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(value)))
+	return NewASDU(CSeNc1, commonAddress, ioa, append(element, 0x00))
+}
+
+// IsCommandType 是否为遥控或者设定值
+func IsCommandType(typeID int) bool {
+	switch typeID {
+	case CScNa1, CDcNa1, CSeNa1, CSeNb1, CSeNc1:
+		return true
+	}
+	return false
+}
+
+// CheckPointType 检查点表里面的类型, 只允许监视方向的数据和遥控、设定值
+func CheckPointType(typeID int) error {
+	if IsCommandType(typeID) {
+		return nil
+	}
+	if typeID > 0 && typeID < CScNa1 {
+		if _, ok := elementSizes[byte(typeID)]; ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported iec104 type id: %d", typeID)
+}
@@ -0,0 +1,392 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/deadband"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/device/iec104"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+type IEC104MasterCommonConfig struct {
+	CommonAddress                *int `json:"commonAddress" validate:"required"`                // 公共地址, 一般为1
+	InterrogationInterval        *int `json:"interrogationInterval" validate:"required"`        // 总召唤周期, 秒, 0 表示只在启动的时候召唤
+	CounterInterrogationInterval *int `json:"counterInterrogationInterval" validate:"required"` // 电度召唤周期, 秒, 0 表示只在启动的时候召唤
+}
+
+type IEC104MasterConfig struct {
+	Host string `json:"host" validate:"required"` // 子站地址
+	Port int    `json:"port" validate:"required"` // 2404
+}
+
+type IEC104MasterMainConfig struct {
+	CommonConfig IEC104MasterCommonConfig `json:"commonConfig" validate:"required"`
+	IEC104Config IEC104MasterConfig       `json:"iec104Config" validate:"required"`
+}
+
+// 信息体地址表
+type iec104DataPoint struct {
+	UUID   string `json:"-"`
+	Ioa    uint32 `json:"ioa"`    // 信息体地址
+	TypeId int    `json:"typeId"` // 类型标识, 遥控和设定值按照这个类型下发
+	Tag    string `json:"tag"`
+	Alias  string `json:"alias"`
+
+	deadband.ReportPolicy // 上报策略
+}
+
+// 上报的数据
+type IEC104PointValue struct {
+	Ioa     uint32  `json:"ioa"`
+	TypeId  uint    `json:"typeId"`
+	Type    string  `json:"type"`    // M_ME_NC_1
+	Cause   uint16  `json:"cause"`   // 3 突发, 20 响应总召唤, 37 响应电度召唤
+	Quality byte    `json:"quality"` // 品质描述, 0 为正常
+	Tag     string  `json:"tag"`
+	Alias   string  `json:"alias"`
+	Value   float64 `json:"value"`
+	Ts      float64 `json:"ts"` // 子站带的时标, 秒; 不带时标的类型为0
+}
+
+type IEC104Master struct {
+	typex.XStatus
+	status       typex.SourceState
+	mainConfig   IEC104MasterMainConfig
+	locker       sync.Mutex
+	client       *iec104.Client
+	points       map[uint32][]iec104DataPoint // 同一个地址可以对应多个点位
+	tags         map[string]iec104DataPoint
+	reportFilter *deadband.Filter
+}
+
+func NewIEC104Master(e typex.Rhilex) typex.XDevice {
+	hd := new(IEC104Master)
+	hd.RuleEngine = e
+	hd.mainConfig = IEC104MasterMainConfig{
+		CommonConfig: IEC104MasterCommonConfig{
+			CommonAddress: func() *int {
+				a := 1
+				return &a
+			}(),
+			InterrogationInterval: func() *int {
+				a := 600
+				return &a
+			}(),
+			CounterInterrogationInterval: func() *int {
+				a := 3600
+				return &a
+			}(),
+		},
+		IEC104Config: IEC104MasterConfig{
+			Port: 2404,
+		},
+	}
+	hd.points = map[uint32][]iec104DataPoint{}
+	hd.tags = map[string]iec104DataPoint{}
+	hd.reportFilter = deadband.NewFilter()
+	return hd
+}
+
+func (hd *IEC104Master) Init(devId string, configMap map[string]any) error {
+	hd.PointId = devId
+	intercache.RegisterSlot(devId)
+	if err := utils.BindSourceConfig(configMap, &hd.mainConfig); err != nil {
+		glogger.GLogger.Error(err)
+		return err
+	}
+	if ca := *hd.mainConfig.CommonConfig.CommonAddress; ca < 1 || ca > 65535 {
+		return fmt.Errorf("common address must range of 1-65535")
+	}
+	if *hd.mainConfig.CommonConfig.InterrogationInterval < 0 ||
+		*hd.mainConfig.CommonConfig.CounterInterrogationInterval < 0 {
+		return fmt.Errorf("interrogation interval must be greater than or equal to 0")
+	}
+	dataPoints := []iec104DataPoint{}
+	if err := interdb.InterDb().Table("m_iec104_data_points").
+		Where("device_uuid=?", devId).Find(&dataPoints).Error; err != nil {
+		return err
+	}
+	LastFetchTime := uint64(time.Now().UnixMilli())
+	for _, point := range dataPoints {
+		if err := iec104.CheckPointType(point.TypeId); err != nil {
+			return err
+		}
+		hd.points[point.Ioa] = append(hd.points[point.Ioa], point)
+		hd.tags[point.Tag] = point
+		intercache.SetValue(hd.PointId, point.UUID, intercache.CacheValue{
+			UUID:          point.UUID,
+			Status:        0,
+			LastFetchTime: LastFetchTime,
+			Value:         "",
+			ErrMsg:        "--",
+		})
+	}
+	return nil
+}
+
+func (hd *IEC104Master) Start(cctx typex.CCTX) error {
+	hd.Ctx = cctx.Ctx
+	hd.CancelCTX = cctx.CancelCTX
+	hd.reportFilter.Reset()
+	address := net.JoinHostPort(hd.mainConfig.IEC104Config.Host,
+		fmt.Sprintf("%d", hd.mainConfig.IEC104Config.Port))
+	client := iec104.NewClient(hd, address, glogger.GLogger, nil, false)
+	client.CommonAddress = uint16(*hd.mainConfig.CommonConfig.CommonAddress)
+	if err := client.Run(); err != nil {
+		client.Close()
+		return err
+	}
+	hd.locker.Lock()
+	hd.client = client
+	hd.locker.Unlock()
+	go hd.schedule(client)
+	hd.status = typex.SOURCE_UP
+	return nil
+}
+
+/*
+*
+* 等待启动确认以后先召唤一次, 然后按照配置的周期召唤; 连接断开以后设备交给监控器重启
+*
+ */
+func (hd *IEC104Master) schedule(client *iec104.Client) {
+	defer client.Close()
+	select {
+	case <-hd.Ctx.Done():
+		return
+	case <-client.Done():
+		glogger.GLogger.Error("IEC104 connection closed:", hd.PointId, client.Err())
+		hd.status = typex.SOURCE_DOWN
+		return
+	case <-time.After(30 * time.Second):
+		glogger.GLogger.Error("IEC104 STARTDT confirm timeout:", hd.PointId)
+		hd.status = typex.SOURCE_DOWN
+		return
+	case <-client.Started():
+	}
+	if err := client.SendTotalCall(); err != nil {
+		glogger.GLogger.Error("IEC104 interrogation error:", err)
+	}
+	if err := client.SendElectricityTotalCall(); err != nil {
+		glogger.GLogger.Error("IEC104 counter interrogation error:", err)
+	}
+	interrogation := newIntervalTicker(*hd.mainConfig.CommonConfig.InterrogationInterval)
+	defer interrogation.Stop()
+	counterInterrogation := newIntervalTicker(*hd.mainConfig.CommonConfig.CounterInterrogationInterval)
+	defer counterInterrogation.Stop()
+	for {
+		select {
+		case <-hd.Ctx.Done():
+			return
+		case <-client.Done():
+			glogger.GLogger.Error("IEC104 connection closed:", hd.PointId, client.Err())
+			hd.status = typex.SOURCE_DOWN
+			return
+		case <-interrogation.C:
+			if err := client.SendTotalCall(); err != nil {
+				glogger.GLogger.Error("IEC104 interrogation error:", err)
+			}
+		case <-counterInterrogation.C:
+			if err := client.SendElectricityTotalCall(); err != nil {
+				glogger.GLogger.Error("IEC104 counter interrogation error:", err)
+			}
+		}
+	}
+}
+
+// 周期为0的时候返回一个永远不会触发的 Ticker
+func newIntervalTicker(seconds int) *time.Ticker {
+	if seconds <= 0 {
+		ticker := time.NewTicker(time.Hour)
+		ticker.Stop()
+		return ticker
+	}
+	return time.NewTicker(time.Duration(seconds) * time.Second)
+}
+
+/*
+*
+* 处理子站上送的数据, 突发、总召唤响应和电度召唤响应都在这里
+*
+ */
+func (hd *IEC104Master) Datahandler(apdu *iec104.APDU) error {
+	LastFetchTime := uint64(time.Now().UnixMilli())
+	for _, signal := range apdu.Signals {
+		points, ok := hd.points[signal.Address]
+		if !ok {
+			continue
+		}
+		valueStr := strconv.FormatFloat(signal.Value, 'f', -1, 64)
+		for _, point := range points {
+			ErrMsg := ""
+			if signal.Quality != 0 {
+				ErrMsg = fmt.Sprintf("quality=0x%02X", signal.Quality)
+			}
+			intercache.SetValue(hd.PointId, point.UUID, intercache.CacheValue{
+				UUID:          point.UUID,
+				Status:        1,
+				LastFetchTime: LastFetchTime,
+				Value:         valueStr,
+				ErrMsg:        ErrMsg,
+			})
+			if !hd.reportFilter.Allow(point.Tag, point.ReportPolicy, valueStr) {
+				continue
+			}
+			bytes, err := json.Marshal(IEC104PointValue{
+				Ioa:     signal.Address,
+				TypeId:  signal.TypeID,
+				Type:    iec104.TypeName(signal.TypeID),
+				Cause:   apdu.ASDU.CauseOf(),
+				Quality: signal.Quality,
+				Tag:     point.Tag,
+				Alias:   point.Alias,
+				Value:   signal.Value,
+				Ts:      signal.Ts,
+			})
+			if err != nil {
+				glogger.GLogger.Error(err)
+				continue
+			}
+			hd.RuleEngine.WorkDevice(hd.Details(), string(bytes))
+		}
+	}
+	return nil
+}
+
+func (hd *IEC104Master) currentClient() (*iec104.Client, error) {
+	hd.locker.Lock()
+	defer hd.locker.Unlock()
+	if hd.client == nil || !hd.client.IsConnected {
+		return nil, fmt.Errorf("iec104 master not connected")
+	}
+	return hd.client, nil
+}
+
+func (hd *IEC104Master) Status() typex.SourceState {
+	return hd.status
+}
+
+func (hd *IEC104Master) Stop() {
+	hd.status = typex.SOURCE_DOWN
+	if hd.CancelCTX != nil {
+		hd.CancelCTX()
+	}
+	hd.locker.Lock()
+	if hd.client != nil {
+		hd.client.Close()
+		hd.client = nil
+	}
+	hd.locker.Unlock()
+	intercache.UnRegisterSlot(hd.PointId)
+}
+
+func (hd *IEC104Master) Details() *typex.Device {
+	return hd.RuleEngine.GetDevice(hd.PointId)
+}
+
+func (hd *IEC104Master) SetState(status typex.SourceState) {
+	hd.status = status
+}
+
+func (hd *IEC104Master) OnDCACall(UUID string, Command string, Args any) typex.DCAResult {
+	return typex.DCAResult{}
+}
+
+/*
+*
+* 外部控制指令:
+* - WriteWithTag: {"tag":"switch1","value":"1"} 按照点表里面的类型下发遥控或者设定值, 等待激活确认
+* - Interrogation: 立即发送一次总召唤
+* - CounterInterrogation: 立即发送一次电度召唤
+*
+ */
+func (hd *IEC104Master) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
+	glogger.Debug("IEC104Master.OnCtrl, CMD=", string(cmd), ", Args=", string(args))
+	client, err := hd.currentClient()
+	if err != nil {
+		return nil, err
+	}
+	switch string(cmd) {
+	case "Interrogation":
+		return []byte{}, client.SendTotalCall()
+	case "CounterInterrogation":
+		return []byte{}, client.SendElectricityTotalCall()
+	case "WriteWithTag":
+		ctrlCmd := CtrlCmd{}
+		if err := json.Unmarshal(args, &ctrlCmd); err != nil {
+			return nil, err
+		}
+		point, ok := hd.tags[ctrlCmd.Tag]
+		if !ok {
+			return nil, fmt.Errorf("data point not exists: %s", ctrlCmd.Tag)
+		}
+		asdu, err := hd.commandAsdu(client.CommonAddress, point, ctrlCmd.Value)
+		if err != nil {
+			return nil, err
+		}
+		if err := client.SendCommand(asdu); err != nil {
+			return nil, err
+		}
+		return []byte{}, nil
+	}
+	return nil, fmt.Errorf("unsupported command: %s", string(cmd))
+}
+
+// 按照点位的类型生成遥控、设定值报文
+func (hd *IEC104Master) commandAsdu(commonAddress uint16, point iec104DataPoint, value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	switch point.TypeId {
+	case iec104.CScNa1, iec104.CDcNa1:
+		var on bool
+		switch strings.ToLower(value) {
+		case "1", "true", "on":
+			on = true
+		case "0", "false", "off":
+			on = false
+		default:
+			return nil, fmt.Errorf("invalid command value: %s", value)
+		}
+		if point.TypeId == iec104.CScNa1 {
+			return iec104.NewSingleCommand(commonAddress, point.Ioa, on), nil
+		}
+		return iec104.NewDoubleCommand(commonAddress, point.Ioa, on), nil
+	case iec104.CSeNa1, iec104.CSeNb1, iec104.CSeNc1:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid setpoint value: %s", value)
+		}
+		if point.TypeId == iec104.CSeNa1 {
+			return iec104.NewSetpointNormalized(commonAddress, point.Ioa, f)
+		}
+		if point.TypeId == iec104.CSeNb1 {
+			return iec104.NewSetpointScaled(commonAddress, point.Ioa, f)
+		}
+		return iec104.NewSetpointFloat(commonAddress, point.Ioa, f), nil
+	}
+	return nil, fmt.Errorf("data point is not writable: %s, typeId=%d", point.Tag, point.TypeId)
+}
@@ -0,0 +1,131 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package knx
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/vapourismo/knx-go/knx/cemi"
+	"github.com/vapourismo/knx-go/knx/dpt"
+)
+
+/*
+*
+* 检查数据点类型, 比如 1.001, 5.001, 9.001, 14.056
+*
+ */
+func CheckDpt(name string) error {
+	if _, ok := dpt.Produce(name); !ok {
+		return fmt.Errorf("unsupported dpt: %s", name)
+	}
+	return nil
+}
+
+/*
+*
+* 检查组地址, 支持 1/2/3、1/2 和 1234 三种格式
+*
+ */
+func ParseGroupAddress(addr string) (cemi.GroupAddr, error) {
+	ga, err := cemi.NewGroupAddrString(strings.TrimSpace(addr))
+	if err != nil {
+		return 0, fmt.Errorf("invalid group address '%s': %v", addr, err)
+	}
+	return ga, nil
+}
+
+/*
+*
+* 解码组报文的数据: 开关量返回 bool, 数值返回 float64/int64/uint64, 字符串返回 string,
+* 时间日期之类的结构体返回它的字符串形式
+*
+ */
+func Decode(name string, data []byte) (any, error) {
+	d, ok := dpt.Produce(name)
+	if !ok {
+		return nil, fmt.Errorf("unsupported dpt: %s", name)
+	}
+	if err := d.Unpack(data); err != nil {
+		return nil, err
+	}
+	v := reflect.ValueOf(d).Elem()
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Float32, reflect.Float64:
+		// float32 转 float64 会有多余的小数位
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(v.Float(), 'f', -1, 32), 64)
+		return f, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), nil
+	case reflect.String:
+		return v.String(), nil
+	}
+	return d.String(), nil
+}
+
+/*
+*
+* 把字符串形式的值按照数据点类型编码, 只支持开关量、数值和字符串
+*
+ */
+func Encode(name string, value string) ([]byte, error) {
+	d, ok := dpt.Produce(name)
+	if !ok {
+		return nil, fmt.Errorf("unsupported dpt: %s", name)
+	}
+	value = strings.TrimSpace(value)
+	v := reflect.ValueOf(d).Elem()
+	switch v.Kind() {
+	case reflect.Bool:
+		switch strings.ToLower(value) {
+		case "1", "true", "on":
+			v.SetBool(true)
+		case "0", "false", "off":
+			v.SetBool(false)
+		default:
+			return nil, fmt.Errorf("invalid bool value: %s", value)
+		}
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float value: %s", value)
+		}
+		v.SetFloat(f)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return nil, fmt.Errorf("invalid int value: %s", value)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return nil, fmt.Errorf("invalid uint value: %s", value)
+		}
+		v.SetUint(u)
+	case reflect.String:
+		v.SetString(value)
+	default:
+		return nil, fmt.Errorf("write is not supported for dpt: %s", name)
+	}
+	return d.Pack(), nil
+}
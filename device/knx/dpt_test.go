@@ -0,0 +1,49 @@
+package knx
+
+import (
+	"testing"
+)
+
+func Test_Dpt_EncodeDecode(t *testing.T) {
+	cases := []struct {
+		dpt    string
+		value  string
+		expect any
+	}{
+		{"1.001", "on", true},
+		{"1.001", "0", false},
+		{"5.001", "50", float64(49.80392)}, // 0-100% 映射到 0-255, 有精度损失
+		{"5.004", "200", uint64(200)},
+		{"9.001", "21.5", float64(21.5)},
+		{"14.056", "1234.5", float64(1234.5)},
+	}
+	for _, c := range cases {
+		data, err := Encode(c.dpt, c.value)
+		if err != nil {
+			t.Fatalf("%s encode: %v", c.dpt, err)
+		}
+		value, err := Decode(c.dpt, data)
+		if err != nil {
+			t.Fatalf("%s decode: %v", c.dpt, err)
+		}
+		if value != c.expect {
+			t.Fatalf("%s: expect %v(%T), got %v(%T)", c.dpt, c.expect, c.expect, value, value)
+		}
+	}
+}
+
+func Test_Dpt_Invalid(t *testing.T) {
+	if err := CheckDpt("99.999"); err == nil {
+		t.Fatal("unknown dpt must be rejected")
+	}
+	if _, err := Encode("9.001", "abc"); err == nil {
+		t.Fatal("invalid value must be rejected")
+	}
+	if _, err := ParseGroupAddress("1/2/x"); err == nil {
+		t.Fatal("invalid group address must be rejected")
+	}
+	ga, err := ParseGroupAddress("1/2/3")
+	if err != nil || ga.String() != "1/2/3" {
+		t.Fatalf("unexpected group address: %v %v", ga, err)
+	}
+}
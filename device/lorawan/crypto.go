@@ -0,0 +1,174 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package lorawan
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// AES128 密钥
+type Key [16]byte
+
+/*
+*
+* 解析十六进制的密钥, 允许带空格、冒号和横线
+*
+ */
+func ParseKey(s string) (Key, error) {
+	var key Key
+	b, err := hex.DecodeString(trimHex(s))
+	if err != nil || len(b) != 16 {
+		return key, fmt.Errorf("invalid aes128 key: %s", s)
+	}
+	copy(key[:], b)
+	return key, nil
+}
+
+func (k Key) String() string {
+	return strings.ToUpper(hex.EncodeToString(k[:]))
+}
+
+func trimHex(s string) string {
+	return strings.NewReplacer(" ", "", ":", "", "-", "").Replace(strings.TrimSpace(s))
+}
+
+/*
+*
+* AES-CMAC, RFC 4493
+*
+ */
+func Cmac(key Key, data []byte) [16]byte {
+	block, _ := aes.NewCipher(key[:])
+	var k1, k2, zero, l [16]byte
+	block.Encrypt(l[:], zero[:])
+	k1 = cmacSubkey(l)
+	k2 = cmacSubkey(k1)
+	n := (len(data) + 15) / 16
+	complete := n > 0 && len(data)%16 == 0
+	if n == 0 {
+		n = 1
+	}
+	var last [16]byte
+	tail := data[(n-1)*16:]
+	if complete {
+		for i := range last {
+			last[i] = tail[i] ^ k1[i]
+		}
+	} else {
+		copy(last[:], tail)
+		last[len(tail)] = 0x80
+		for i := range last {
+			last[i] ^= k2[i]
+		}
+	}
+	var x [16]byte
+	for i := 0; i < n-1; i++ {
+		for j := 0; j < 16; j++ {
+			x[j] ^= data[i*16+j]
+		}
+		block.Encrypt(x[:], x[:])
+	}
+	for j := 0; j < 16; j++ {
+		x[j] ^= last[j]
+	}
+	block.Encrypt(x[:], x[:])
+	return x
+}
+
+func cmacSubkey(in [16]byte) [16]byte {
+	var out [16]byte
+	for i := 0; i < 15; i++ {
+		out[i] = in[i]<<1 | in[i+1]>>7
+	}
+	out[15] = in[15] << 1
+	if in[0]&0x80 != 0 {
+		out[15] ^= 0x87
+	}
+	return out
+}
+
+// 上行为 0, 下行为 1
+func direction(uplink bool) byte {
+	if uplink {
+		return 0
+	}
+	return 1
+}
+
+/*
+*
+* 数据帧的 MIC: CMAC(NwkSKey, B0 | msg) 的前 4 字节
+*
+ */
+func DataMIC(nwkSKey Key, uplink bool, devAddr uint32, fCnt uint32, msg []byte) [4]byte {
+	b0 := make([]byte, 16, 16+len(msg))
+	b0[0] = 0x49
+	b0[5] = direction(uplink)
+	binary.LittleEndian.PutUint32(b0[6:10], devAddr)
+	binary.LittleEndian.PutUint32(b0[10:14], fCnt)
+	b0[15] = byte(len(msg))
+	mac := Cmac(nwkSKey, append(b0, msg...))
+	var mic [4]byte
+	copy(mic[:], mac[:4])
+	return mic
+}
+
+/*
+*
+* 加密或者解密 FRMPayload, 两个方向的运算相同
+*
+ */
+func EncryptFRMPayload(key Key, uplink bool, devAddr uint32, fCnt uint32, payload []byte) []byte {
+	block, _ := aes.NewCipher(key[:])
+	out := make([]byte, len(payload))
+	var a, s [16]byte
+	a[0] = 0x01
+	a[5] = direction(uplink)
+	binary.LittleEndian.PutUint32(a[6:10], devAddr)
+	binary.LittleEndian.PutUint32(a[10:14], fCnt)
+	for i := 0; i < len(payload); i += 16 {
+		a[15] = byte(i/16 + 1)
+		block.Encrypt(s[:], a[:])
+		for j := i; j < len(payload) && j < i+16; j++ {
+			out[j] = payload[j] ^ s[j-i]
+		}
+	}
+	return out
+}
+
+/*
+*
+* LoRaWAN 1.0.x 入网以后的会话密钥:
+* NwkSKey = aes128_encrypt(AppKey, 0x01 | AppNonce | NetID | DevNonce | pad16)
+* AppSKey = aes128_encrypt(AppKey, 0x02 | AppNonce | NetID | DevNonce | pad16)
+*
+ */
+func DeriveSessionKeys(appKey Key, appNonce [3]byte, netId [3]byte, devNonce uint16) (nwkSKey Key, appSKey Key) {
+	block, _ := aes.NewCipher(appKey[:])
+	var b [16]byte
+	copy(b[1:4], appNonce[:])
+	copy(b[4:7], netId[:])
+	binary.LittleEndian.PutUint16(b[7:9], devNonce)
+	b[0] = 0x01
+	block.Encrypt(nwkSKey[:], b[:])
+	b[0] = 0x02
+	block.Encrypt(appSKey[:], b[:])
+	return nwkSKey, appSKey
+}
@@ -0,0 +1,252 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package lorawan
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MHDR 里面的消息类型
+type MType byte
+
+const (
+	JOIN_REQUEST          MType = 0x00
+	JOIN_ACCEPT           MType = 0x01
+	UNCONFIRMED_DATA_UP   MType = 0x02
+	UNCONFIRMED_DATA_DOWN MType = 0x03
+	CONFIRMED_DATA_UP     MType = 0x04
+	CONFIRMED_DATA_DOWN   MType = 0x05
+	REJOIN_REQUEST        MType = 0x06
+	PROPRIETARY           MType = 0x07
+)
+
+// LoRaWAN R1
+const majorLoRaWANR1 byte = 0x00
+
+// FCtrl 标志位
+const (
+	FCTRL_ADR      byte = 0x80
+	FCTRL_ACK      byte = 0x20
+	FCTRL_FPENDING byte = 0x10 // 下行
+)
+
+var ErrInvalidMIC = errors.New("lorawan: invalid mic")
+
+func MTypeOf(phy []byte) (MType, error) {
+	if len(phy) < 1 {
+		return 0, errors.New("lorawan: empty phy payload")
+	}
+	return MType(phy[0] >> 5), nil
+}
+
+func mhdr(mType MType) byte {
+	return byte(mType)<<5 | majorLoRaWANR1
+}
+
+// 8 字节的 EUI, 空中按照小端传输, 显示按照大端
+type EUI64 [8]byte
+
+func ParseEUI64(s string) (EUI64, error) {
+	var eui EUI64
+	b, err := hex.DecodeString(trimHex(s))
+	if err != nil || len(b) != 8 {
+		return eui, fmt.Errorf("invalid eui64: %s", s)
+	}
+	copy(eui[:], b)
+	return eui, nil
+}
+
+func (e EUI64) String() string {
+	return strings.ToUpper(hex.EncodeToString(e[:]))
+}
+
+func euiFromLittleEndian(b []byte) EUI64 {
+	var eui EUI64
+	for i := 0; i < 8; i++ {
+		eui[i] = b[7-i]
+	}
+	return eui
+}
+
+/*
+*
+* 解析十六进制的 DevAddr, 按照大端显示, 比如 26011BDA
+*
+ */
+func ParseDevAddr(s string) (uint32, error) {
+	b, err := hex.DecodeString(trimHex(s))
+	if err != nil || len(b) != 4 {
+		return 0, fmt.Errorf("invalid devAddr: %s", s)
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func DevAddrString(devAddr uint32) string {
+	return fmt.Sprintf("%08X", devAddr)
+}
+
+// 数据帧
+type DataFrame struct {
+	MType      MType
+	DevAddr    uint32
+	FCtrl      byte
+	FCnt       uint16 // 空中只传低 16 位
+	FOpts      []byte
+	FPort      *byte // 没有 FRMPayload 的时候为空
+	FRMPayload []byte
+	MIC        [4]byte
+}
+
+func (f DataFrame) Uplink() bool {
+	return f.MType == UNCONFIRMED_DATA_UP || f.MType == CONFIRMED_DATA_UP
+}
+
+func (f DataFrame) Confirmed() bool {
+	return f.MType == CONFIRMED_DATA_UP || f.MType == CONFIRMED_DATA_DOWN
+}
+
+/*
+*
+* 解析数据帧: MHDR | DevAddr | FCtrl | FCnt | FOpts | [FPort | FRMPayload] | MIC
+*
+ */
+func ParseDataFrame(phy []byte) (DataFrame, error) {
+	frame := DataFrame{}
+	if len(phy) < 12 {
+		return frame, errors.New("lorawan: data frame too short")
+	}
+	frame.MType = MType(phy[0] >> 5)
+	switch frame.MType {
+	case UNCONFIRMED_DATA_UP, UNCONFIRMED_DATA_DOWN, CONFIRMED_DATA_UP, CONFIRMED_DATA_DOWN:
+	default:
+		return frame, fmt.Errorf("lorawan: not a data frame, mtype=%d", frame.MType)
+	}
+	frame.DevAddr = binary.LittleEndian.Uint32(phy[1:5])
+	frame.FCtrl = phy[5]
+	frame.FCnt = binary.LittleEndian.Uint16(phy[6:8])
+	fOptsLen := int(frame.FCtrl & 0x0F)
+	body := phy[8 : len(phy)-4]
+	if len(body) < fOptsLen {
+		return frame, errors.New("lorawan: invalid fopts length")
+	}
+	frame.FOpts = body[:fOptsLen]
+	body = body[fOptsLen:]
+	if len(body) > 0 {
+		port := body[0]
+		frame.FPort = &port
+		frame.FRMPayload = body[1:]
+	}
+	copy(frame.MIC[:], phy[len(phy)-4:])
+	return frame, nil
+}
+
+// 校验 MIC, fCnt 为扩展以后的 32 位计数
+func (f DataFrame) ValidateMIC(nwkSKey Key, phy []byte, fCnt uint32) error {
+	if DataMIC(nwkSKey, f.Uplink(), f.DevAddr, fCnt, phy[:len(phy)-4]) != f.MIC {
+		return ErrInvalidMIC
+	}
+	return nil
+}
+
+/*
+*
+* 编码数据帧并计算 MIC, FRMPayload 由调用方加密; FOpts 不能超过 15 字节
+*
+ */
+func (f DataFrame) Encode(nwkSKey Key, fCnt uint32) ([]byte, error) {
+	if len(f.FOpts) > 15 {
+		return nil, errors.New("lorawan: fopts too long")
+	}
+	phy := []byte{mhdr(f.MType), 0, 0, 0, 0, f.FCtrl&0xF0 | byte(len(f.FOpts)), byte(fCnt), byte(fCnt >> 8)}
+	binary.LittleEndian.PutUint32(phy[1:5], f.DevAddr)
+	phy = append(phy, f.FOpts...)
+	if f.FPort != nil {
+		phy = append(phy, *f.FPort)
+		phy = append(phy, f.FRMPayload...)
+	}
+	mic := DataMIC(nwkSKey, f.Uplink(), f.DevAddr, fCnt, phy)
+	return append(phy, mic[:]...), nil
+}
+
+// 入网请求
+type JoinRequest struct {
+	JoinEUI  EUI64
+	DevEUI   EUI64
+	DevNonce uint16
+}
+
+/*
+*
+* 解析入网请求并用 AppKey 校验 MIC: MHDR | JoinEUI | DevEUI | DevNonce | MIC
+*
+ */
+func ParseJoinRequest(phy []byte) (JoinRequest, error) {
+	req := JoinRequest{}
+	if len(phy) != 23 || MType(phy[0]>>5) != JOIN_REQUEST {
+		return req, errors.New("lorawan: invalid join request")
+	}
+	req.JoinEUI = euiFromLittleEndian(phy[1:9])
+	req.DevEUI = euiFromLittleEndian(phy[9:17])
+	req.DevNonce = binary.LittleEndian.Uint16(phy[17:19])
+	return req, nil
+}
+
+func ValidateJoinRequestMIC(appKey Key, phy []byte) error {
+	if len(phy) != 23 {
+		return errors.New("lorawan: invalid join request")
+	}
+	mac := Cmac(appKey, phy[:19])
+	if string(mac[:4]) != string(phy[19:23]) {
+		return ErrInvalidMIC
+	}
+	return nil
+}
+
+// 入网应答
+type JoinAccept struct {
+	AppNonce   [3]byte
+	NetID      [3]byte
+	DevAddr    uint32
+	DLSettings byte // RX1DROffset 和 RX2 速率
+	RxDelay    byte // RX1 延时, 秒
+}
+
+/*
+*
+* 编码入网应答: MIC 用 AppKey 计算, 然后用 AES 解密运算加密, 终端只需要加密运算
+*
+ */
+func (j JoinAccept) Encode(appKey Key) []byte {
+	payload := make([]byte, 0, 16)
+	payload = append(payload, j.AppNonce[:]...)
+	payload = append(payload, j.NetID[:]...)
+	payload = binary.LittleEndian.AppendUint32(payload, j.DevAddr)
+	payload = append(payload, j.DLSettings, j.RxDelay)
+	header := mhdr(JOIN_ACCEPT)
+	mac := Cmac(appKey, append([]byte{header}, payload...))
+	payload = append(payload, mac[:4]...)
+	block, _ := aes.NewCipher(appKey[:])
+	encrypted := make([]byte, len(payload))
+	for i := 0; i < len(payload); i += 16 {
+		block.Decrypt(encrypted[i:i+16], payload[i:i+16])
+	}
+	return append([]byte{header}, encrypted...)
+}
@@ -0,0 +1,173 @@
+package lorawan
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+func mustKey(t *testing.T, s string) Key {
+	key, err := ParseKey(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// RFC 4493 测试向量
+func Test_Lorawan_Cmac(t *testing.T) {
+	key := mustKey(t, "2b7e151628aed2a6abf7158809cf4f3c")
+	cases := []struct{ msg, mac string }{
+		{"", "bb1d6929e95937287fa37d129b756746"},
+		{"6bc1bee22e409f96e93d7e117393172a", "070a16b46b4d4144f79bdd9dd04a287c"},
+		{"6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411",
+			"dfa66747de9ae63030ca32611497c827"},
+	}
+	for _, c := range cases {
+		msg, _ := hex.DecodeString(c.msg)
+		mac := Cmac(key, msg)
+		if hex.EncodeToString(mac[:]) != c.mac {
+			t.Fatalf("cmac(%s) = %x, want %s", c.msg, mac, c.mac)
+		}
+	}
+}
+
+func Test_Lorawan_AbpUplinkDownlink(t *testing.T) {
+	devEUI, _ := ParseEUI64("0102030405060708")
+	devAddr, _ := ParseDevAddr("26011BDA")
+	nwkSKey := mustKey(t, "000102030405060708090A0B0C0D0E0F")
+	appSKey := mustKey(t, "0F0E0D0C0B0A09080706050403020100")
+	network := NewNetwork([3]byte{}, 1)
+	if err := network.AddABP(devEUI, devAddr, nwkSKey, appSKey); err != nil {
+		t.Fatal(err)
+	}
+	// 终端侧编码上行
+	uplink := func(fCnt uint32, confirmed bool, data []byte) []byte {
+		port := byte(10)
+		frame := DataFrame{MType: UNCONFIRMED_DATA_UP, DevAddr: devAddr, FCtrl: FCTRL_ADR, FPort: &port,
+			FRMPayload: EncryptFRMPayload(appSKey, true, devAddr, fCnt, data)}
+		if confirmed {
+			frame.MType = CONFIRMED_DATA_UP
+		}
+		phy, err := frame.Encode(nwkSKey, fCnt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return phy
+	}
+	phy := uplink(0xFFFE, false, []byte("hello lorawan, 12345678"))
+	up, err := network.HandleUplink(phy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up.DevEUI != devEUI || *up.FPort != 10 || string(up.Data) != "hello lorawan, 12345678" || !up.ADR {
+		t.Fatalf("unexpected uplink: %+v", up)
+	}
+	if _, err := network.HandleUplink(phy); err != ErrDuplicate {
+		t.Fatalf("duplicate expected, got %v", err)
+	}
+	// 16 位计数回绕以后扩展到 32 位
+	up, err = network.HandleUplink(uplink(0x10001, true, []byte{1, 2, 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up.FCnt != 0x10001 || !up.Confirmed {
+		t.Fatalf("unexpected uplink: %+v", up)
+	}
+	bad := uplink(0x10002, false, []byte{1})
+	bad[len(bad)-1] ^= 0xFF
+	if _, err := network.HandleUplink(bad); err != ErrInvalidMIC {
+		t.Fatalf("invalid mic expected, got %v", err)
+	}
+
+	if err := network.Enqueue(devEUI, Downlink{FPort: 2, Data: []byte{0xAA, 0xBB}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.Enqueue(devEUI, Downlink{FPort: 3, Data: []byte{0xCC}, Confirmed: true}); err != nil {
+		t.Fatal(err)
+	}
+	down, ok, err := network.NextDownlink(devEUI, true)
+	if err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	frame, err := ParseDataFrame(down)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := frame.ValidateMIC(nwkSKey, down, 0); err != nil {
+		t.Fatal(err)
+	}
+	if frame.MType != UNCONFIRMED_DATA_DOWN || frame.FCtrl&FCTRL_ACK == 0 || frame.FCtrl&FCTRL_FPENDING == 0 || *frame.FPort != 2 {
+		t.Fatalf("unexpected downlink: %+v", frame)
+	}
+	if data := EncryptFRMPayload(appSKey, false, devAddr, 0, frame.FRMPayload); !bytes.Equal(data, []byte{0xAA, 0xBB}) {
+		t.Fatalf("unexpected downlink payload: %x", data)
+	}
+	down, _, _ = network.NextDownlink(devEUI, false)
+	frame, _ = ParseDataFrame(down)
+	if frame.FCnt != 1 || frame.MType != CONFIRMED_DATA_DOWN || frame.FCtrl&FCTRL_FPENDING != 0 {
+		t.Fatalf("unexpected downlink: %+v", frame)
+	}
+	if _, ok, _ := network.NextDownlink(devEUI, false); ok {
+		t.Fatal("queue must be empty")
+	}
+	if err := network.Enqueue(devEUI, Downlink{FPort: 0}); err == nil {
+		t.Fatal("fPort 0 must be rejected")
+	}
+}
+
+func Test_Lorawan_OtaaJoin(t *testing.T) {
+	devEUI, _ := ParseEUI64("70B3D57ED0000001")
+	appKey := mustKey(t, "2B7E151628AED2A6ABF7158809CF4F3C")
+	network := NewNetwork([3]byte{0x13, 0, 0}, 1)
+	if err := network.AddOTAA(devEUI, appKey, 0); err != nil {
+		t.Fatal(err)
+	}
+	// 终端侧编码入网请求
+	req := []byte{0x00}
+	req = append(req, 8, 7, 6, 5, 4, 3, 2, 1) // JoinEUI
+	for i := 7; i >= 0; i-- {
+		req = append(req, devEUI[i])
+	}
+	req = append(req, 0x34, 0x12) // DevNonce
+	mac := Cmac(appKey, req)
+	req = append(req, mac[:4]...)
+	result, err := network.HandleJoin(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DevEUI != devEUI || result.DevAddr>>25 != 0x13 {
+		t.Fatalf("unexpected join result: %+v", result)
+	}
+	// 终端用 AES 加密运算解出入网应答
+	block, _ := aes.NewCipher(appKey[:])
+	plain := make([]byte, len(result.Accept)-1)
+	for i := 0; i < len(plain); i += 16 {
+		block.Encrypt(plain[i:i+16], result.Accept[1+i:1+i+16])
+	}
+	check := Cmac(appKey, append([]byte{result.Accept[0]}, plain[:12]...))
+	if !bytes.Equal(check[:4], plain[12:16]) {
+		t.Fatal("invalid join accept mic")
+	}
+	var appNonce, netId [3]byte
+	copy(appNonce[:], plain[0:3])
+	copy(netId[:], plain[3:6])
+	nwkSKey, appSKey := DeriveSessionKeys(appKey, appNonce, netId, 0x1234)
+
+	port := byte(1)
+	frame := DataFrame{MType: UNCONFIRMED_DATA_UP, DevAddr: result.DevAddr, FPort: &port,
+		FRMPayload: EncryptFRMPayload(appSKey, true, result.DevAddr, 0, []byte{0x42})}
+	phy, _ := frame.Encode(nwkSKey, 0)
+	up, err := network.HandleUplink(phy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up.DevEUI != devEUI || !bytes.Equal(up.Data, []byte{0x42}) {
+		t.Fatalf("unexpected uplink: %+v", up)
+	}
+	req[len(req)-1] ^= 0xFF
+	if _, err := network.HandleJoin(req); err != ErrInvalidMIC {
+		t.Fatalf("invalid mic expected, got %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package lorawan
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrUnknownDevice = errors.New("lorawan: unknown device")
+	ErrDuplicate     = errors.New("lorawan: duplicate or replayed frame")
+)
+
+// 每个终端最多缓存的下行数量
+const maxDownlinkQueue = 16
+
+// 待发送的下行
+type Downlink struct {
+	FPort     byte
+	Data      []byte
+	Confirmed bool
+}
+
+// 解密以后的上行
+type Uplink struct {
+	DevEUI    EUI64
+	DevAddr   uint32
+	FCnt      uint32
+	FPort     *byte
+	Data      []byte // 明文, FPort 为 0 的时候是 MAC 命令
+	Confirmed bool
+	ADR       bool
+}
+
+// 入网结果
+type JoinResult struct {
+	DevEUI  EUI64
+	DevAddr uint32
+	Accept  []byte // 入网应答的 PHYPayload
+}
+
+// 终端会话
+type session struct {
+	devEUI    EUI64
+	devAddr   uint32
+	nwkSKey   Key
+	appSKey   Key
+	appKey    *Key // OTAA 的根密钥
+	joined    bool // ABP 或者 OTAA 已经入网
+	fCntUp    uint32
+	fCntValid bool
+	fCntDown  uint32
+	queue     []Downlink
+}
+
+/*
+*
+* 简单的网络服务器: 维护终端的会话, 处理入网、上行解密和下行加密; 计数器只保存在内存里面
+*
+ */
+type Network struct {
+	locker  sync.Mutex
+	netId   [3]byte
+	rxDelay byte
+	byAddr  map[uint32]*session
+	byEUI   map[EUI64]*session
+}
+
+func NewNetwork(netId [3]byte, rxDelay byte) *Network {
+	return &Network{
+		netId:   netId,
+		rxDelay: rxDelay,
+		byAddr:  map[uint32]*session{},
+		byEUI:   map[EUI64]*session{},
+	}
+}
+
+// 添加 ABP 终端
+func (n *Network) AddABP(devEUI EUI64, devAddr uint32, nwkSKey, appSKey Key) error {
+	n.locker.Lock()
+	defer n.locker.Unlock()
+	if _, ok := n.byEUI[devEUI]; ok {
+		return fmt.Errorf("duplicate devEui: %s", devEUI)
+	}
+	if _, ok := n.byAddr[devAddr]; ok {
+		return fmt.Errorf("duplicate devAddr: %s", DevAddrString(devAddr))
+	}
+	s := &session{devEUI: devEUI, devAddr: devAddr, nwkSKey: nwkSKey, appSKey: appSKey, joined: true}
+	n.byEUI[devEUI] = s
+	n.byAddr[devAddr] = s
+	return nil
+}
+
+/*
+*
+* 添加 OTAA 终端, devAddr 为 0 的时候入网的时候按照 NetID 随机分配
+*
+ */
+func (n *Network) AddOTAA(devEUI EUI64, appKey Key, devAddr uint32) error {
+	n.locker.Lock()
+	defer n.locker.Unlock()
+	if _, ok := n.byEUI[devEUI]; ok {
+		return fmt.Errorf("duplicate devEui: %s", devEUI)
+	}
+	key := appKey
+	n.byEUI[devEUI] = &session{devEUI: devEUI, devAddr: devAddr, appKey: &key}
+	return nil
+}
+
+/*
+*
+* 处理入网请求, 返回需要在 JOIN_ACCEPT_DELAY1 发送的入网应答
+*
+ */
+func (n *Network) HandleJoin(phy []byte) (JoinResult, error) {
+	req, err := ParseJoinRequest(phy)
+	if err != nil {
+		return JoinResult{}, err
+	}
+	n.locker.Lock()
+	defer n.locker.Unlock()
+	s, ok := n.byEUI[req.DevEUI]
+	if !ok || s.appKey == nil {
+		return JoinResult{DevEUI: req.DevEUI}, ErrUnknownDevice
+	}
+	if err := ValidateJoinRequestMIC(*s.appKey, phy); err != nil {
+		return JoinResult{DevEUI: req.DevEUI}, err
+	}
+	devAddr := s.devAddr
+	if devAddr == 0 {
+		devAddr = n.allocDevAddr()
+	}
+	accept := JoinAccept{NetID: n.netId, DevAddr: devAddr, RxDelay: n.rxDelay}
+	rand.Read(accept.AppNonce[:])
+	if s.joined {
+		delete(n.byAddr, s.devAddr)
+	}
+	s.devAddr = devAddr
+	s.nwkSKey, s.appSKey = DeriveSessionKeys(*s.appKey, accept.AppNonce, n.netId, req.DevNonce)
+	s.joined = true
+	s.fCntUp, s.fCntValid, s.fCntDown = 0, false, 0
+	n.byAddr[devAddr] = s
+	return JoinResult{DevEUI: req.DevEUI, DevAddr: devAddr, Accept: accept.Encode(*s.appKey)}, nil
+}
+
+// 按照 NetID 的 NwkID 分配一个没有使用的地址
+func (n *Network) allocDevAddr() uint32 {
+	nwkId := uint32(n.netId[0] & 0x7F)
+	for {
+		var b [4]byte
+		rand.Read(b[:])
+		devAddr := nwkId<<25 | binary.BigEndian.Uint32(b[:])&0x01FFFFFF
+		if _, ok := n.byAddr[devAddr]; !ok && devAddr != 0 {
+			return devAddr
+		}
+	}
+}
+
+/*
+*
+* 处理上行数据帧: 扩展帧计数, 校验 MIC, 解密 FRMPayload; 多个网关收到的同一帧只处理一次
+*
+ */
+func (n *Network) HandleUplink(phy []byte) (Uplink, error) {
+	frame, err := ParseDataFrame(phy)
+	if err != nil {
+		return Uplink{}, err
+	}
+	if !frame.Uplink() {
+		return Uplink{}, errors.New("lorawan: not an uplink frame")
+	}
+	n.locker.Lock()
+	defer n.locker.Unlock()
+	s, ok := n.byAddr[frame.DevAddr]
+	if !ok {
+		return Uplink{DevAddr: frame.DevAddr}, ErrUnknownDevice
+	}
+	fCnt := uint32(frame.FCnt)
+	if s.fCntValid {
+		fCnt = s.fCntUp&0xFFFF0000 | uint32(frame.FCnt)
+		if fCnt < s.fCntUp {
+			fCnt += 0x10000
+		}
+	}
+	if err := frame.ValidateMIC(s.nwkSKey, phy, fCnt); err != nil {
+		return Uplink{DevEUI: s.devEUI, DevAddr: frame.DevAddr}, err
+	}
+	if s.fCntValid && fCnt == s.fCntUp {
+		return Uplink{DevEUI: s.devEUI, DevAddr: frame.DevAddr, FCnt: fCnt}, ErrDuplicate
+	}
+	s.fCntUp, s.fCntValid = fCnt, true
+	uplink := Uplink{
+		DevEUI:    s.devEUI,
+		DevAddr:   frame.DevAddr,
+		FCnt:      fCnt,
+		FPort:     frame.FPort,
+		Confirmed: frame.Confirmed(),
+		ADR:       frame.FCtrl&FCTRL_ADR != 0,
+	}
+	if frame.FPort != nil {
+		key := s.appSKey
+		if *frame.FPort == 0 {
+			key = s.nwkSKey
+		}
+		uplink.Data = EncryptFRMPayload(key, true, frame.DevAddr, fCnt, frame.FRMPayload)
+	}
+	return uplink, nil
+}
+
+// 下行排队, 终端下一次上行以后在 RX1 发送
+func (n *Network) Enqueue(devEUI EUI64, downlink Downlink) error {
+	if downlink.FPort == 0 || downlink.FPort > 223 {
+		return fmt.Errorf("fPort must range of 1-223")
+	}
+	n.locker.Lock()
+	defer n.locker.Unlock()
+	s, ok := n.byEUI[devEUI]
+	if !ok {
+		return ErrUnknownDevice
+	}
+	if len(s.queue) >= maxDownlinkQueue {
+		return fmt.Errorf("downlink queue is full: %s", devEUI)
+	}
+	s.queue = append(s.queue, downlink)
+	return nil
+}
+
+// 排队的下行数量
+func (n *Network) QueueSize(devEUI EUI64) int {
+	n.locker.Lock()
+	defer n.locker.Unlock()
+	if s, ok := n.byEUI[devEUI]; ok {
+		return len(s.queue)
+	}
+	return 0
+}
+
+/*
+*
+* 取出下一个下行并编码; 没有排队的下行但是需要应答确认上行的时候发送一个空帧
+*
+ */
+func (n *Network) NextDownlink(devEUI EUI64, ack bool) ([]byte, bool, error) {
+	n.locker.Lock()
+	defer n.locker.Unlock()
+	s, ok := n.byEUI[devEUI]
+	if !ok || !s.joined {
+		return nil, false, ErrUnknownDevice
+	}
+	if len(s.queue) == 0 && !ack {
+		return nil, false, nil
+	}
+	frame := DataFrame{MType: UNCONFIRMED_DATA_DOWN, DevAddr: s.devAddr}
+	if ack {
+		frame.FCtrl |= FCTRL_ACK
+	}
+	if len(s.queue) > 0 {
+		downlink := s.queue[0]
+		s.queue = s.queue[1:]
+		if downlink.Confirmed {
+			frame.MType = CONFIRMED_DATA_DOWN
+		}
+		port := downlink.FPort
+		frame.FPort = &port
+		frame.FRMPayload = EncryptFRMPayload(s.appSKey, false, s.devAddr, s.fCntDown, downlink.Data)
+	}
+	if len(s.queue) > 0 {
+		frame.FCtrl |= FCTRL_FPENDING
+	}
+	phy, err := frame.Encode(s.nwkSKey, s.fCntDown)
+	if err != nil {
+		return nil, false, err
+	}
+	s.fCntDown++
+	return phy, true, nil
+}
@@ -0,0 +1,245 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hootrhino/rhilex/device/dmodbus"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/resconfig"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+type ModbusGatewayCommonConfig struct {
+	MasterId string `json:"masterId" validate:"required"` // 转发到哪个 Modbus 主站, 主站必须是 UART 或者 RTU_OVER_TCP 模式
+	UnitIds  []int  `json:"unitIds"`                      // 允许访问的从机地址, 为空的时候不限制
+	MaxConns int    `json:"maxConns"`                     // 最大客户端连接数
+}
+
+type ModbusGatewayConfig struct {
+	CommonConfig ModbusGatewayCommonConfig `json:"commonConfig" validate:"required"`
+	HostConfig   resconfig.HostConfig      `json:"hostConfig" validate:"required"` // Timeout 为客户端空闲超时
+}
+
+// 能把 PDU 透传到总线上的设备, 目前只有 GENERIC_MODBUS_MASTER
+type modbusTransactor interface {
+	Transact(slaverId byte, pdu []byte) ([]byte, error)
+}
+
+/*
+*
+* Modbus TCP 转 RTU 网关: 监听 Modbus TCP 请求, 按照单元标识符转发到主站的串口总线上,
+* 和主站自己的轮询排队使用总线
+*
+ */
+type ModbusGateway struct {
+	typex.XStatus
+	status     typex.SourceState
+	mainConfig ModbusGatewayConfig
+	unitIds    map[byte]bool
+	listener   net.Listener
+	locker     sync.Mutex
+	conns      map[net.Conn]struct{}
+}
+
+func NewModbusGateway(e typex.Rhilex) typex.XDevice {
+	hd := new(ModbusGateway)
+	hd.RuleEngine = e
+	hd.mainConfig = ModbusGatewayConfig{
+		CommonConfig: ModbusGatewayCommonConfig{
+			UnitIds:  []int{},
+			MaxConns: 8,
+		},
+		HostConfig: resconfig.HostConfig{
+			Host:    "0.0.0.0",
+			Port:    502,
+			Timeout: 60000,
+		},
+	}
+	hd.unitIds = map[byte]bool{}
+	hd.conns = map[net.Conn]struct{}{}
+	hd.status = typex.SOURCE_DOWN
+	return hd
+}
+
+func (hd *ModbusGateway) Init(devId string, configMap map[string]any) error {
+	hd.PointId = devId
+	if err := utils.BindSourceConfig(configMap, &hd.mainConfig); err != nil {
+		glogger.GLogger.Error(err)
+		return err
+	}
+	if hd.mainConfig.CommonConfig.MasterId == devId {
+		return errors.New("modbus gateway can not forward to itself")
+	}
+	if hd.mainConfig.CommonConfig.MaxConns < 1 {
+		return errors.New("'maxConns' must be greater than 0")
+	}
+	hd.unitIds = map[byte]bool{}
+	for _, unitId := range hd.mainConfig.CommonConfig.UnitIds {
+		if unitId < 1 || unitId > 247 {
+			return fmt.Errorf("invalid unit id: %d, must range of 1-247", unitId)
+		}
+		hd.unitIds[byte(unitId)] = true
+	}
+	return nil
+}
+
+func (hd *ModbusGateway) Start(cctx typex.CCTX) error {
+	hd.Ctx = cctx.Ctx
+	hd.CancelCTX = cctx.CancelCTX
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%v",
+		hd.mainConfig.HostConfig.Host, hd.mainConfig.HostConfig.Port))
+	if err != nil {
+		return err
+	}
+	hd.listener = listener
+	go hd.accept(listener)
+	hd.status = typex.SOURCE_UP
+	return nil
+}
+
+func (hd *ModbusGateway) accept(listener net.Listener) {
+	idle := time.Duration(hd.mainConfig.HostConfig.Timeout) * time.Millisecond
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-hd.Ctx.Done():
+				return
+			default:
+			}
+			glogger.GLogger.Error("Modbus Gateway Accept error:", err)
+			return
+		}
+		hd.locker.Lock()
+		if len(hd.conns) >= hd.mainConfig.CommonConfig.MaxConns {
+			hd.locker.Unlock()
+			glogger.GLogger.Warn("Modbus Gateway too many connections, reject:", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		hd.conns[conn] = struct{}{}
+		hd.locker.Unlock()
+		go func(conn net.Conn) {
+			if err := dmodbus.ServeTCPGatewayConn(conn, idle, hd.forward); err != nil {
+				glogger.GLogger.Debug("Modbus Gateway connection closed:", conn.RemoteAddr(), ", ", err)
+			}
+			hd.locker.Lock()
+			delete(hd.conns, conn)
+			hd.locker.Unlock()
+		}(conn)
+	}
+}
+
+/*
+*
+* 转发一个请求: 单元标识符不允许或者主站不可用返回 0x0A, 从机没有响应返回 0x0B
+*
+ */
+func (hd *ModbusGateway) forward(unitId byte, pdu []byte) []byte {
+	function := pdu[0]
+	if unitId < 1 || unitId > 247 {
+		return dmodbus.ExceptionPDU(function, dmodbus.EXCEPTION_GATEWAY_PATH_UNAVAILABLE)
+	}
+	if len(hd.unitIds) > 0 && !hd.unitIds[unitId] {
+		return dmodbus.ExceptionPDU(function, dmodbus.EXCEPTION_GATEWAY_PATH_UNAVAILABLE)
+	}
+	master, err := hd.master()
+	if err != nil {
+		glogger.GLogger.Error("Modbus Gateway forward error:", err)
+		return dmodbus.ExceptionPDU(function, dmodbus.EXCEPTION_GATEWAY_PATH_UNAVAILABLE)
+	}
+	response, err := master.Transact(unitId, pdu)
+	if err != nil {
+		glogger.GLogger.Error("Modbus Gateway forward to unit ", unitId, " error:", err)
+		return dmodbus.ExceptionPDU(function, dmodbus.EXCEPTION_GATEWAY_TARGET_FAILED)
+	}
+	return response
+}
+
+func (hd *ModbusGateway) master() (modbusTransactor, error) {
+	Device := hd.RuleEngine.GetDevice(hd.mainConfig.CommonConfig.MasterId)
+	if Device == nil || Device.Device == nil {
+		return nil, fmt.Errorf("modbus master not exists: %s", hd.mainConfig.CommonConfig.MasterId)
+	}
+	if Device.Device.Status() != typex.SOURCE_UP {
+		return nil, fmt.Errorf("modbus master not running: %s", hd.mainConfig.CommonConfig.MasterId)
+	}
+	master, ok := Device.Device.(modbusTransactor)
+	if !ok {
+		return nil, fmt.Errorf("device is not a modbus master: %s", hd.mainConfig.CommonConfig.MasterId)
+	}
+	return master, nil
+}
+
+func (hd *ModbusGateway) Status() typex.SourceState {
+	return hd.status
+}
+
+func (hd *ModbusGateway) Stop() {
+	hd.status = typex.SOURCE_DOWN
+	if hd.CancelCTX != nil {
+		hd.CancelCTX()
+	}
+	if hd.listener != nil {
+		hd.listener.Close()
+		hd.listener = nil
+	}
+	hd.locker.Lock()
+	for conn := range hd.conns {
+		conn.Close()
+	}
+	hd.conns = map[net.Conn]struct{}{}
+	hd.locker.Unlock()
+}
+
+func (hd *ModbusGateway) Details() *typex.Device {
+	return hd.RuleEngine.GetDevice(hd.PointId)
+}
+
+func (hd *ModbusGateway) SetState(status typex.SourceState) {
+	hd.status = status
+}
+
+func (hd *ModbusGateway) OnDCACall(UUID string, Command string, Args any) typex.DCAResult {
+	return typex.DCAResult{}
+}
+
+/*
+*
+* 外部控制指令:
+* - Connections: 返回当前连接的客户端地址
+*
+ */
+func (hd *ModbusGateway) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
+	if string(cmd) == "Connections" {
+		hd.locker.Lock()
+		remotes := []string{}
+		for conn := range hd.conns {
+			remotes = append(remotes, conn.RemoteAddr().String())
+		}
+		hd.locker.Unlock()
+		return json.Marshal(remotes)
+	}
+	return nil, fmt.Errorf("unsupported command: %s", string(cmd))
+}
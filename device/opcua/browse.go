@@ -0,0 +1,90 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package opcua
+
+import (
+	"context"
+	"strings"
+
+	gopcua "github.com/gopcua/opcua"
+	uaid "github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+)
+
+// 浏览结果里面的节点
+type BrowseNode struct {
+	NodeId      string `json:"nodeId"`
+	BrowseName  string `json:"browseName"`
+	DisplayName string `json:"displayName"`
+	NodeClass   string `json:"nodeClass"` // Object | Variable | Method ...
+	DataType    string `json:"dataType"`  // 变量节点的数据类型, 不支持的类型为空
+}
+
+/*
+*
+* 浏览节点的子节点, 空的时候从 Objects 目录开始; 变量节点会顺便读出数据类型,
+* 前端可以一层一层展开然后把选中的变量加到点位表里面
+*
+ */
+func Browse(ctx context.Context, client *gopcua.Client, nodeId string) ([]BrowseNode, error) {
+	parent := ua.NewNumericNodeID(0, uaid.ObjectsFolder)
+	if strings.TrimSpace(nodeId) != "" {
+		id, err := ParseNodeID(nodeId)
+		if err != nil {
+			return nil, err
+		}
+		parent = id
+	}
+	refs, err := client.Node(parent).References(ctx, uaid.HierarchicalReferences,
+		ua.BrowseDirectionForward, ua.NodeClassAll, true)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]BrowseNode, 0, len(refs))
+	for _, ref := range refs {
+		if ref.NodeID == nil || ref.NodeID.NodeID == nil {
+			continue
+		}
+		node := BrowseNode{
+			NodeId:    ref.NodeID.NodeID.String(),
+			NodeClass: strings.TrimPrefix(ref.NodeClass.String(), "NodeClass"),
+		}
+		if ref.BrowseName != nil {
+			node.BrowseName = ref.BrowseName.Name
+		}
+		if ref.DisplayName != nil {
+			node.DisplayName = ref.DisplayName.Text
+		}
+		if ref.NodeClass == ua.NodeClassVariable {
+			node.DataType = readDataType(ctx, client, ref.NodeID.NodeID)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// 只识别标准命名空间里面的基础数据类型
+func readDataType(ctx context.Context, client *gopcua.Client, id *ua.NodeID) string {
+	v, err := client.Node(id).Attribute(ctx, ua.AttributeIDDataType)
+	if err != nil || v == nil {
+		return ""
+	}
+	typeId := v.NodeID()
+	if typeId == nil || typeId.Namespace() != 0 {
+		return ""
+	}
+	return DataTypeName(ua.TypeID(typeId.IntID()))
+}
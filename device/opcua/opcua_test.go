@@ -0,0 +1,208 @@
+package opcua
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	gopcua "github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/server"
+	"github.com/gopcua/opcua/ua"
+)
+
+func Test_Opcua_Variant(t *testing.T) {
+	cases := []struct {
+		dataType string
+		value    string
+		expect   any
+	}{
+		{"Boolean", "on", true},
+		{"Int16", "-12", int16(-12)},
+		{"UInt32", "65536", uint32(65536)},
+		{"Float", "1.5", float32(1.5)},
+		{"Double", "21.25", float64(21.25)},
+		{"String", " abc ", "abc"},
+	}
+	for _, c := range cases {
+		v, err := NewVariant(c.dataType, c.value)
+		if err != nil {
+			t.Fatalf("%s: %v", c.dataType, err)
+		}
+		if DataTypeName(v.Type()) != c.dataType {
+			t.Fatalf("%s: unexpected variant type %v", c.dataType, v.Type())
+		}
+		if value := VariantValue(v); value != c.expect {
+			t.Fatalf("%s: expect %v(%T), got %v(%T)", c.dataType, c.expect, c.expect, value, value)
+		}
+	}
+	if _, err := NewVariant("Byte", "256"); err == nil {
+		t.Fatal("out of range value must be rejected")
+	}
+	if err := CheckDataType("Decimal"); err == nil {
+		t.Fatal("unknown data type must be rejected")
+	}
+	if VariantValue(ua.MustVariant([]byte{1, 2, 3})) != "AQID" {
+		t.Fatal("byte string must be base64 encoded")
+	}
+}
+
+func Test_Opcua_NodeIdAndSecurity(t *testing.T) {
+	if _, err := ParseNodeID("ns=abc;i=1"); err == nil {
+		t.Fatal("invalid node id must be rejected")
+	}
+	id, err := ParseNodeID(" ns=2;s=Temperature ")
+	if err != nil || id.String() != "ns=2;s=Temperature" {
+		t.Fatalf("unexpected node id: %v %v", id, err)
+	}
+	if err := (SecurityConfig{Policy: POLICY_NONE, Mode: "Sign"}).Check(); err == nil {
+		t.Fatal("policy None with mode Sign must be rejected")
+	}
+	if err := (SecurityConfig{Policy: POLICY_BASIC256SHA256, AuthType: AUTH_USERNAME}).Check(); err == nil {
+		t.Fatal("username auth without username must be rejected")
+	}
+	der, key, err := GenerateCertificate(APPLICATION_URI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.URIs) != 1 || cert.URIs[0].String() != APPLICATION_URI {
+		t.Fatalf("unexpected certificate uris: %v", cert.URIs)
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		t.Fatal("key mismatch")
+	}
+}
+
+func Test_Opcua_ServerAuth(t *testing.T) {
+	auth := ServerAuth{AllowAnonymous: false, Users: map[string]string{"scada": "123456"}}
+	if err := auth.Check(nil); err == nil {
+		t.Fatal("anonymous must be rejected")
+	}
+	token := func(user, password string) *ua.ExtensionObject {
+		return ua.NewExtensionObject(&ua.UserNameIdentityToken{UserName: user, Password: []byte(password)})
+	}
+	if err := auth.Check(token("scada", "123456")); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.Check(token("scada", "654321")); err == nil {
+		t.Fatal("wrong password must be rejected")
+	}
+	if err := auth.Check(token("admin", "123456")); err == nil {
+		t.Fatal("unknown user must be rejected")
+	}
+	auth.AllowAnonymous = true
+	if err := auth.Check(ua.NewExtensionObject(&ua.AnonymousIdentityToken{})); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Opcua_AddressSpace(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	srv := server.New(
+		server.EndPoint("127.0.0.1", port),
+		server.EnableSecurity(POLICY_NONE, ua.MessageSecurityModeNone),
+		server.EnableAuthMode(ua.UserTokenTypeAnonymous),
+	)
+	if err := RegisterServerAuth(srv, ServerAuth{AllowAnonymous: true}); err != nil {
+		t.Fatal(err)
+	}
+	written := map[string]string{}
+	as := NewAddressSpace(srv, "urn:rhilex:test",
+		func(object ServerObject, tag ServerTag) ServerTagValue {
+			if tag.Name == "offline" {
+				return ServerTagValue{Status: ua.StatusBadNoCommunication}
+			}
+			return ServerTagValue{Value: "21.5", Status: ua.StatusOK}
+		},
+		func(object ServerObject, tag ServerTag, value string) error {
+			written[tag.Name] = value
+			return nil
+		})
+	as.Update([]ServerObject{{
+		Id:   "DEVICE1",
+		Name: "Boiler",
+		Tags: []ServerTag{
+			{Key: "P1", Name: "temperature", DataType: "Double", Writable: true},
+			{Key: "P2", Name: "offline", DataType: "Double"},
+		},
+	}})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	c, err := gopcua.NewClient(fmt.Sprintf("opc.tcp://127.0.0.1:%d", port),
+		gopcua.SecurityMode(ua.MessageSecurityModeNone), gopcua.AuthAnonymous())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(ctx)
+	temperature := ua.NewStringNodeID(as.ID(), TagNodeId("DEVICE1", "temperature"))
+	offline := ua.NewStringNodeID(as.ID(), TagNodeId("DEVICE1", "offline"))
+	resp, err := c.Read(ctx, &ua.ReadRequest{NodesToRead: []*ua.ReadValueID{
+		{NodeID: temperature, AttributeID: ua.AttributeIDValue},
+		{NodeID: offline, AttributeID: ua.AttributeIDValue},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Results[0].Status != ua.StatusOK || VariantValue(resp.Results[0].Value) != float64(21.5) {
+		t.Fatalf("unexpected value: %v %v", resp.Results[0].Status, resp.Results[0].Value)
+	}
+	if resp.Results[1].Status != ua.StatusBadNoCommunication {
+		t.Fatalf("unexpected status: %v", resp.Results[1].Status)
+	}
+	write := func(nodeId *ua.NodeID, value any) ua.StatusCode {
+		resp, err := c.Write(ctx, &ua.WriteRequest{NodesToWrite: []*ua.WriteValue{{
+			NodeID:      nodeId,
+			AttributeID: ua.AttributeIDValue,
+			Value:       &ua.DataValue{EncodingMask: ua.DataValueValue, Value: ua.MustVariant(value)},
+		}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Results[0]
+	}
+	if status := write(temperature, float64(30)); status != ua.StatusOK || written["temperature"] != "30" {
+		t.Fatalf("unexpected write result: %v %v", status, written)
+	}
+	if status := write(offline, float64(30)); status != ua.StatusBadNotWritable {
+		t.Fatalf("read only tag must be rejected: %v", status)
+	}
+	browse, err := c.Browse(ctx, &ua.BrowseRequest{
+		NodesToBrowse: []*ua.BrowseDescription{{
+			NodeID:          ua.NewStringNodeID(as.ID(), "DEVICE1"),
+			BrowseDirection: ua.BrowseDirectionForward,
+			ReferenceTypeID: ua.NewNumericNodeID(0, id.HierarchicalReferences),
+			IncludeSubtypes: true,
+			ResultMask:      uint32(ua.BrowseResultMaskAll),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, ref := range browse.Results[0].References {
+		names = append(names, ref.BrowseName.Name)
+	}
+	if strings.Join(names, ",") != "temperature,offline" {
+		t.Fatalf("unexpected browse result: %v", names)
+	}
+}
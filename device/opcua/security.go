@@ -0,0 +1,331 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package opcua
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	gopcua "github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/ua"
+)
+
+// 安全策略
+const (
+	POLICY_NONE           string = "None"
+	POLICY_BASIC256SHA256 string = "Basic256Sha256"
+)
+
+// 用户认证方式
+const (
+	AUTH_ANONYMOUS   string = "ANONYMOUS"
+	AUTH_USERNAME    string = "USERNAME"
+	AUTH_CERTIFICATE string = "CERTIFICATE"
+)
+
+// 自动生成的客户端证书里面的应用 URI
+const APPLICATION_URI string = "urn:rhilex:opcua:client"
+
+// 自动生成的服务端证书里面的应用 URI
+const SERVER_APPLICATION_URI string = "urn:rhilex:opcua:server"
+
+/*
+*
+* 安全配置: 安全策略和模式用来选择服务端的端点, 证书和私钥是 PEM 或者 DER 格式的文件路径,
+* 策略不是 None 又没有配置证书的时候自动生成一个自签名证书
+*
+ */
+type SecurityConfig struct {
+	Policy      string `json:"securityPolicy"` // None | Basic256Sha256
+	Mode        string `json:"securityMode"`   // None | Sign | SignAndEncrypt
+	AuthType    string `json:"authType"`       // ANONYMOUS | USERNAME | CERTIFICATE
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	Certificate string `json:"certificate"` // 客户端证书文件
+	PrivateKey  string `json:"privateKey"`  // 客户端私钥文件
+}
+
+func (s SecurityConfig) Check() error {
+	switch s.Policy {
+	case POLICY_NONE:
+		if s.Mode != "" && s.Mode != "None" {
+			return fmt.Errorf("security mode must be None when policy is None")
+		}
+	case POLICY_BASIC256SHA256:
+		if s.Mode != "" && s.Mode != "Sign" && s.Mode != "SignAndEncrypt" {
+			return fmt.Errorf("invalid security mode: %s", s.Mode)
+		}
+	default:
+		return fmt.Errorf("unsupported security policy: %s", s.Policy)
+	}
+	switch s.AuthType {
+	case "", AUTH_ANONYMOUS:
+	case AUTH_USERNAME:
+		if s.Username == "" {
+			return fmt.Errorf("username is required")
+		}
+	case AUTH_CERTIFICATE:
+		if s.Certificate == "" || s.PrivateKey == "" {
+			return fmt.Errorf("certificate and private key are required")
+		}
+	default:
+		return fmt.Errorf("unsupported auth type: %s", s.AuthType)
+	}
+	return nil
+}
+
+// 没有配置的时候 None 策略对应 None 模式, 加密策略默认签名加密
+func (s SecurityConfig) mode() ua.MessageSecurityMode {
+	if s.Mode != "" {
+		return ua.MessageSecurityModeFromString(s.Mode)
+	}
+	if s.Policy == POLICY_NONE {
+		return ua.MessageSecurityModeNone
+	}
+	return ua.MessageSecurityModeSignAndEncrypt
+}
+
+func (s SecurityConfig) tokenType() ua.UserTokenType {
+	switch s.AuthType {
+	case AUTH_USERNAME:
+		return ua.UserTokenTypeUserName
+	case AUTH_CERTIFICATE:
+		return ua.UserTokenTypeCertificate
+	}
+	return ua.UserTokenTypeAnonymous
+}
+
+// 服务端的端点
+type EndpointInfo struct {
+	EndpointUrl    string   `json:"endpointUrl"`
+	SecurityPolicy string   `json:"securityPolicy"`
+	SecurityMode   string   `json:"securityMode"`
+	SecurityLevel  uint8    `json:"securityLevel"`
+	AuthTypes      []string `json:"authTypes"`
+}
+
+/*
+*
+* 端点发现, 返回服务端支持的安全策略、模式和认证方式
+*
+ */
+func GetEndpoints(ctx context.Context, endpoint string) ([]EndpointInfo, error) {
+	endpoints, err := gopcua.GetEndpoints(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]EndpointInfo, 0, len(endpoints))
+	for _, ep := range endpoints {
+		info := EndpointInfo{
+			EndpointUrl:    ep.EndpointURL,
+			SecurityPolicy: strings.TrimPrefix(ep.SecurityPolicyURI, "http://opcfoundation.org/UA/SecurityPolicy#"),
+			SecurityMode:   strings.TrimPrefix(ep.SecurityMode.String(), "MessageSecurityMode"),
+			SecurityLevel:  ep.SecurityLevel,
+			AuthTypes:      []string{},
+		}
+		for _, token := range ep.UserIdentityTokens {
+			switch token.TokenType {
+			case ua.UserTokenTypeAnonymous:
+				info.AuthTypes = append(info.AuthTypes, AUTH_ANONYMOUS)
+			case ua.UserTokenTypeUserName:
+				info.AuthTypes = append(info.AuthTypes, AUTH_USERNAME)
+			case ua.UserTokenTypeCertificate:
+				info.AuthTypes = append(info.AuthTypes, AUTH_CERTIFICATE)
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+/*
+*
+* 按照安全配置选择端点, 生成连接参数
+*
+ */
+func ClientOptions(ctx context.Context, endpoint string, s SecurityConfig) ([]gopcua.Option, error) {
+	if err := s.Check(); err != nil {
+		return nil, err
+	}
+	endpoints, err := gopcua.GetEndpoints(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	ep, err := gopcua.SelectEndpoint(endpoints, s.Policy, s.mode())
+	if err != nil {
+		return nil, fmt.Errorf("no endpoint matches policy=%s, mode=%s", s.Policy, s.mode())
+	}
+	supported := false
+	for _, token := range ep.UserIdentityTokens {
+		if token.TokenType == s.tokenType() {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return nil, fmt.Errorf("endpoint does not support auth type: %s", s.tokenType())
+	}
+	opts := []gopcua.Option{gopcua.ApplicationName("rhilex")}
+	var cert []byte
+	var key *rsa.PrivateKey
+	if s.Policy != POLICY_NONE || s.AuthType == AUTH_CERTIFICATE {
+		if s.Certificate != "" && s.PrivateKey != "" {
+			cert, key, err = LoadKeyPair(s.Certificate, s.PrivateKey)
+		} else {
+			cert, key, err = defaultKeyPair()
+			opts = append(opts, gopcua.ApplicationURI(APPLICATION_URI))
+		}
+		if err != nil {
+			return nil, err
+		}
+		// 配置的证书会覆盖应用 URI, 和证书里面的保持一致
+		opts = append(opts, gopcua.Certificate(cert), gopcua.PrivateKey(key))
+	}
+	switch s.AuthType {
+	case AUTH_USERNAME:
+		opts = append(opts, gopcua.AuthUsername(s.Username, s.Password))
+	case AUTH_CERTIFICATE:
+		opts = append(opts, gopcua.AuthCertificate(cert), gopcua.AuthPrivateKey(key))
+	default:
+		opts = append(opts, gopcua.AuthAnonymous())
+	}
+	return append(opts, gopcua.SecurityFromEndpoint(ep, s.tokenType())), nil
+}
+
+/*
+*
+* 加载证书和私钥, 证书支持 PEM 和 DER, 私钥支持 PKCS1 和 PKCS8
+*
+ */
+func LoadKeyPair(certFile, keyFile string) ([]byte, *rsa.PrivateKey, error) {
+	cert, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if block, _ := pem.Decode(cert); block != nil {
+		cert = block.Bytes
+	}
+	if _, err := x509.ParseCertificate(cert); err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate: %v", err)
+	}
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(data); err == nil {
+		return cert, key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid private key: %v", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("private key must be RSA")
+	}
+	return cert, rsaKey, nil
+}
+
+/*
+*
+* 生成自签名证书, 证书里面带上应用 URI, 服务端会检查它和会话里面的是否一致
+*
+ */
+func GenerateCertificate(appURI string) ([]byte, *rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	uri, err := url.Parse(appURI)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	hostname, _ := os.Hostname()
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   "rhilex",
+			Organization: []string{"rhilex"},
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().AddDate(10, 0, 0),
+		KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment |
+			x509.KeyUsageDataEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		URIs:                  []*url.URL{uri},
+	}
+	if hostname != "" {
+		template.DNSNames = []string{hostname}
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// 自动生成的证书整个进程共用一份, 服务端信任一次以后重启设备不用重新信任
+var defaultCert struct {
+	once sync.Once
+	cert []byte
+	key  *rsa.PrivateKey
+	err  error
+}
+
+func defaultKeyPair() ([]byte, *rsa.PrivateKey, error) {
+	defaultCert.once.Do(func() {
+		defaultCert.cert, defaultCert.key, defaultCert.err = GenerateCertificate(APPLICATION_URI)
+	})
+	return defaultCert.cert, defaultCert.key, defaultCert.err
+}
+
+var defaultServerCert struct {
+	once sync.Once
+	cert []byte
+	key  *rsa.PrivateKey
+	err  error
+}
+
+/*
+*
+* 服务端没有配置证书的时候使用的自签名证书, 整个进程共用一份
+*
+ */
+func DefaultServerKeyPair() ([]byte, *rsa.PrivateKey, error) {
+	defaultServerCert.once.Do(func() {
+		defaultServerCert.cert, defaultServerCert.key, defaultServerCert.err = GenerateCertificate(SERVER_APPLICATION_URI)
+	})
+	return defaultServerCert.cert, defaultServerCert.key, defaultServerCert.err
+}
@@ -0,0 +1,489 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package opcua
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/server"
+	"github.com/gopcua/opcua/ua"
+)
+
+// 服务端地址空间里面的变量, 对应设备的一个点位
+type ServerTag struct {
+	Key         string // 读写回调用来定位数据的键
+	Name        string // 浏览名, 同一个对象下面唯一
+	DataType    string // Boolean | Int64 | Double | String ...
+	Writable    bool
+	Description string
+}
+
+// 服务端地址空间里面的对象, 对应一个设备
+type ServerObject struct {
+	Id          string // 设备 UUID
+	Name        string
+	Description string
+	Tags        []ServerTag
+}
+
+// 变量的当前值, 值是字符串形式, 读的时候按照变量的数据类型转换
+type ServerTagValue struct {
+	Value     string
+	Status    ua.StatusCode
+	Timestamp time.Time
+}
+
+type ServerReadFunc func(object ServerObject, tag ServerTag) ServerTagValue
+type ServerWriteFunc func(object ServerObject, tag ServerTag, value string) error
+
+// 节点在地址空间里面的位置
+type serverNode struct {
+	object *ServerObject
+	tag    *ServerTag // 为空的时候是对象
+}
+
+/*
+*
+* 网关的地址空间: Objects 下面每个设备一个对象, 对象下面每个点位一个变量.
+* 对象的节点 ID 是 ns=N;s=<设备UUID>, 变量的节点 ID 是 ns=N;s=<设备UUID>.<点位Tag>
+*
+ */
+type AddressSpace struct {
+	srv     *server.Server
+	uri     string
+	id      uint16
+	read    ServerReadFunc
+	write   ServerWriteFunc
+	locker  sync.RWMutex
+	objects []*ServerObject
+	nodes   map[string]serverNode
+	last    map[string]ServerTagValue // 上一次通知订阅者的值
+}
+
+/*
+*
+* 新建地址空间并且挂到服务端 Objects 文件夹下面
+*
+ */
+func NewAddressSpace(srv *server.Server, uri string, read ServerReadFunc, write ServerWriteFunc) *AddressSpace {
+	as := &AddressSpace{
+		srv:   srv,
+		uri:   uri,
+		read:  read,
+		write: write,
+		nodes: map[string]serverNode{},
+		last:  map[string]ServerTagValue{},
+	}
+	srv.AddNamespace(as)
+	if ns0, err := srv.Namespace(0); err == nil {
+		ns0.Objects().AddRef(as.Objects(), id.Organizes, true)
+	}
+	return as
+}
+
+/*
+*
+* 替换全部对象, 设备或者点位表变化以后重新加载
+*
+ */
+func (as *AddressSpace) Update(objects []ServerObject) {
+	as.locker.Lock()
+	defer as.locker.Unlock()
+	as.objects = make([]*ServerObject, 0, len(objects))
+	as.nodes = map[string]serverNode{}
+	for i := range objects {
+		object := &objects[i]
+		as.objects = append(as.objects, object)
+		as.nodes[object.Id] = serverNode{object: object}
+		for j := range object.Tags {
+			tag := &object.Tags[j]
+			as.nodes[TagNodeId(object.Id, tag.Name)] = serverNode{object: object, tag: tag}
+		}
+	}
+	for key := range as.last {
+		if _, ok := as.nodes[key]; !ok {
+			delete(as.last, key)
+		}
+	}
+}
+
+/*
+*
+* 检查全部变量的值, 变化了的通知订阅者; 服务端没有启动的时候不能调用
+*
+ */
+func (as *AddressSpace) Notify() {
+	changed := []*ua.NodeID{}
+	as.locker.Lock()
+	for _, object := range as.objects {
+		for _, tag := range object.Tags {
+			key := TagNodeId(object.Id, tag.Name)
+			value := as.read(*object, tag)
+			if last, ok := as.last[key]; ok && last.Value == value.Value && last.Status == value.Status {
+				continue
+			}
+			as.last[key] = value
+			changed = append(changed, ua.NewStringNodeID(as.id, key))
+		}
+	}
+	as.locker.Unlock()
+	for _, nodeId := range changed {
+		as.srv.ChangeNotification(nodeId)
+	}
+}
+
+// 变量的节点 ID 的字符串部分
+func TagNodeId(objectId, tagName string) string {
+	return objectId + "." + tagName
+}
+
+func (as *AddressSpace) Name() string {
+	return as.uri
+}
+
+func (as *AddressSpace) ID() uint16 {
+	return as.id
+}
+
+func (as *AddressSpace) SetID(id uint16) {
+	as.id = id
+}
+
+// 节点由设备生成, 不支持客户端或者服务端添加
+func (as *AddressSpace) AddNode(n *server.Node) *server.Node {
+	return n
+}
+
+func (as *AddressSpace) Objects() *server.Node {
+	return as.Node(as.objectsId())
+}
+
+func (as *AddressSpace) Root() *server.Node {
+	return server.NewNode(
+		ua.NewNumericNodeID(as.id, id.RootFolder),
+		server.Attributes{
+			ua.AttributeIDNodeClass:   server.DataValueFromValue(int32(ua.NodeClassObject)),
+			ua.AttributeIDBrowseName:  server.DataValueFromValue(&ua.QualifiedName{NamespaceIndex: as.id, Name: "Root"}),
+			ua.AttributeIDDisplayName: server.DataValueFromValue(localizedText("Root")),
+		},
+		server.References{},
+		nil,
+	)
+}
+
+/*
+*
+* 服务端在浏览 ns=0 的 Objects 的时候会通过这里取类型定义, 所以节点上面带上 HasTypeDefinition
+*
+ */
+func (as *AddressSpace) Node(nodeId *ua.NodeID) *server.Node {
+	nodeClass, name, typeDef, ok := as.describe(nodeId)
+	if !ok {
+		return nil
+	}
+	return server.NewNode(
+		nodeId,
+		server.Attributes{
+			ua.AttributeIDNodeClass:   server.DataValueFromValue(int32(nodeClass)),
+			ua.AttributeIDBrowseName:  server.DataValueFromValue(&ua.QualifiedName{NamespaceIndex: as.id, Name: name}),
+			ua.AttributeIDDisplayName: server.DataValueFromValue(localizedText(name)),
+		},
+		server.References{{
+			ReferenceTypeID: ua.NewNumericNodeID(0, id.HasTypeDefinition),
+			IsForward:       true,
+			NodeID:          ua.NewNumericExpandedNodeID(0, typeDef),
+			BrowseName:      &ua.QualifiedName{},
+			DisplayName:     localizedText(""),
+			TypeDefinition:  ua.NewTwoByteExpandedNodeID(0),
+		}},
+		nil,
+	)
+}
+
+func (as *AddressSpace) objectsId() *ua.NodeID {
+	return ua.NewNumericNodeID(as.id, id.ObjectsFolder)
+}
+
+func (as *AddressSpace) isObjects(nodeId *ua.NodeID) bool {
+	return nodeId.Type() != ua.NodeIDTypeString && nodeId.IntID() == id.ObjectsFolder
+}
+
+// 节点的类型, 名字和类型定义
+func (as *AddressSpace) describe(nodeId *ua.NodeID) (ua.NodeClass, string, uint32, bool) {
+	if as.isObjects(nodeId) {
+		return ua.NodeClassObject, as.uri, id.FolderType, true
+	}
+	as.locker.RLock()
+	node, ok := as.nodes[nodeId.StringID()]
+	as.locker.RUnlock()
+	if !ok || nodeId.Type() != ua.NodeIDTypeString {
+		return 0, "", 0, false
+	}
+	if node.tag == nil {
+		return ua.NodeClassObject, node.object.Name, id.BaseObjectType, true
+	}
+	return ua.NodeClassVariable, node.tag.Name, id.BaseDataVariableType, true
+}
+
+/*
+*
+* 浏览: Objects -> 设备 (Organizes), 设备 -> 点位 (HasComponent)
+*
+ */
+func (as *AddressSpace) Browse(bd *ua.BrowseDescription) *ua.BrowseResult {
+	_, _, typeDef, ok := as.describe(bd.NodeID)
+	if !ok {
+		return &ua.BrowseResult{StatusCode: ua.StatusBadNodeIDUnknown}
+	}
+	as.locker.RLock()
+	defer as.locker.RUnlock()
+	refs := []*ua.ReferenceDescription{}
+	add := func(refType uint32, forward bool, target *ua.NodeID, class ua.NodeClass, name string, targetType uint32) {
+		if !browseDirection(bd.BrowseDirection, forward) || !browseRefType(bd, refType) {
+			return
+		}
+		if bd.NodeClassMask != 0 && bd.NodeClassMask&uint32(class) == 0 {
+			return
+		}
+		refs = append(refs, &ua.ReferenceDescription{
+			ReferenceTypeID: ua.NewNumericNodeID(0, refType),
+			IsForward:       forward,
+			NodeID:          ua.NewExpandedNodeID(target, "", 0),
+			BrowseName:      &ua.QualifiedName{NamespaceIndex: target.Namespace(), Name: name},
+			DisplayName:     localizedText(name),
+			NodeClass:       class,
+			TypeDefinition:  ua.NewNumericExpandedNodeID(0, targetType),
+		})
+	}
+	// 类型定义放在第一个
+	add(id.HasTypeDefinition, true, ua.NewNumericNodeID(0, typeDef),
+		ua.NodeClassObjectType, "", 0)
+	if as.isObjects(bd.NodeID) {
+		add(id.Organizes, false, ua.NewNumericNodeID(0, id.ObjectsFolder),
+			ua.NodeClassObject, "Objects", id.FolderType)
+		for _, object := range as.objects {
+			add(id.Organizes, true, ua.NewStringNodeID(as.id, object.Id),
+				ua.NodeClassObject, object.Name, id.BaseObjectType)
+		}
+		return &ua.BrowseResult{StatusCode: ua.StatusGood, References: refs}
+	}
+	node, ok := as.nodes[bd.NodeID.StringID()]
+	if !ok {
+		return &ua.BrowseResult{StatusCode: ua.StatusBadNodeIDUnknown}
+	}
+	if node.tag == nil {
+		add(id.Organizes, false, as.objectsId(), ua.NodeClassObject, as.uri, id.FolderType)
+		for _, tag := range node.object.Tags {
+			add(id.HasComponent, true, ua.NewStringNodeID(as.id, TagNodeId(node.object.Id, tag.Name)),
+				ua.NodeClassVariable, tag.Name, id.BaseDataVariableType)
+		}
+	} else {
+		add(id.HasComponent, false, ua.NewStringNodeID(as.id, node.object.Id),
+			ua.NodeClassObject, node.object.Name, id.BaseObjectType)
+	}
+	return &ua.BrowseResult{StatusCode: ua.StatusGood, References: refs}
+}
+
+func browseDirection(direction ua.BrowseDirection, forward bool) bool {
+	switch direction {
+	case ua.BrowseDirectionForward:
+		return forward
+	case ua.BrowseDirectionInverse:
+		return !forward
+	}
+	return true
+}
+
+// 只有这几种引用, 子类型按照规范里面的继承关系判断
+func browseRefType(bd *ua.BrowseDescription, refType uint32) bool {
+	if bd.ReferenceTypeID == nil || bd.ReferenceTypeID.IntID() == 0 || bd.ReferenceTypeID.IntID() == refType {
+		return true
+	}
+	if !bd.IncludeSubtypes {
+		return false
+	}
+	switch bd.ReferenceTypeID.IntID() {
+	case id.References:
+		return true
+	case id.HierarchicalReferences:
+		return refType != id.HasTypeDefinition
+	case id.NonHierarchicalReferences:
+		return refType == id.HasTypeDefinition
+	case id.HasChild, id.Aggregates:
+		return refType == id.HasComponent
+	}
+	return false
+}
+
+/*
+*
+* 读属性, 变量的值从回调里面取
+*
+ */
+func (as *AddressSpace) Attribute(nodeId *ua.NodeID, attr ua.AttributeID) *ua.DataValue {
+	nodeClass, name, _, ok := as.describe(nodeId)
+	if !ok {
+		return statusDataValue(ua.StatusBadNodeIDUnknown)
+	}
+	switch attr {
+	case ua.AttributeIDNodeID:
+		return attributeValue(nodeId)
+	case ua.AttributeIDNodeClass:
+		return attributeValue(int32(nodeClass))
+	case ua.AttributeIDBrowseName:
+		return attributeValue(&ua.QualifiedName{NamespaceIndex: as.id, Name: name})
+	case ua.AttributeIDDisplayName:
+		return attributeValue(localizedText(name))
+	case ua.AttributeIDWriteMask, ua.AttributeIDUserWriteMask:
+		return attributeValue(uint32(0))
+	}
+	as.locker.RLock()
+	node := as.nodes[nodeId.StringID()]
+	as.locker.RUnlock()
+	if nodeClass == ua.NodeClassObject {
+		switch attr {
+		case ua.AttributeIDDescription:
+			if node.object != nil {
+				return attributeValue(localizedText(node.object.Description))
+			}
+			return attributeValue(localizedText(""))
+		case ua.AttributeIDEventNotifier:
+			return attributeValue(uint8(0))
+		}
+		return statusDataValue(ua.StatusBadAttributeIDInvalid)
+	}
+	if node.tag == nil {
+		return statusDataValue(ua.StatusBadNodeIDUnknown)
+	}
+	tag := *node.tag
+	switch attr {
+	case ua.AttributeIDDescription:
+		return attributeValue(localizedText(tag.Description))
+	case ua.AttributeIDValue:
+		return as.readValue(*node.object, tag)
+	case ua.AttributeIDDataType:
+		return attributeValue(ua.NewNumericNodeID(0, uint32(dataTypes[tag.DataType])))
+	case ua.AttributeIDValueRank:
+		return attributeValue(int32(-1))
+	case ua.AttributeIDArrayDimensions:
+		return attributeValue([]uint32{})
+	case ua.AttributeIDAccessLevel, ua.AttributeIDUserAccessLevel:
+		level := ua.AccessLevelTypeCurrentRead
+		if tag.Writable {
+			level |= ua.AccessLevelTypeCurrentWrite
+		}
+		return attributeValue(uint8(level))
+	case ua.AttributeIDMinimumSamplingInterval:
+		return attributeValue(float64(0))
+	case ua.AttributeIDHistorizing:
+		return attributeValue(false)
+	}
+	return statusDataValue(ua.StatusBadAttributeIDInvalid)
+}
+
+func (as *AddressSpace) readValue(object ServerObject, tag ServerTag) *ua.DataValue {
+	value := as.read(object, tag)
+	dv := &ua.DataValue{
+		EncodingMask:    ua.DataValueServerTimestamp | ua.DataValueStatusCode,
+		ServerTimestamp: time.Now(),
+		Status:          value.Status,
+	}
+	if !value.Timestamp.IsZero() {
+		dv.EncodingMask |= ua.DataValueSourceTimestamp
+		dv.SourceTimestamp = value.Timestamp
+	}
+	if value.Status != ua.StatusOK {
+		return dv
+	}
+	v, err := NewVariant(tag.DataType, value.Value)
+	if err != nil {
+		dv.Status = ua.StatusBadDataEncodingInvalid
+		return dv
+	}
+	dv.EncodingMask |= ua.DataValueValue
+	dv.Value = v
+	return dv
+}
+
+/*
+*
+* 写变量, 值转成字符串以后交给回调, 由回调转给设备
+*
+ */
+func (as *AddressSpace) SetAttribute(nodeId *ua.NodeID, attr ua.AttributeID, val *ua.DataValue) ua.StatusCode {
+	as.locker.RLock()
+	node, ok := as.nodes[nodeId.StringID()]
+	as.locker.RUnlock()
+	if !ok || nodeId.Type() != ua.NodeIDTypeString {
+		return ua.StatusBadNodeIDUnknown
+	}
+	if node.tag == nil || attr != ua.AttributeIDValue || !node.tag.Writable {
+		return ua.StatusBadNotWritable
+	}
+	if val == nil || val.Value == nil {
+		return ua.StatusBadTypeMismatch
+	}
+	value := VariantValue(val.Value)
+	if _, err := NewVariant(node.tag.DataType, toString(value)); err != nil {
+		return ua.StatusBadTypeMismatch
+	}
+	if err := as.write(*node.object, *node.tag, toString(value)); err != nil {
+		return ua.StatusBadCommunicationError
+	}
+	return ua.StatusOK
+}
+
+func toString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func attributeValue(v any) *ua.DataValue {
+	return &ua.DataValue{
+		EncodingMask:    ua.DataValueValue | ua.DataValueServerTimestamp | ua.DataValueStatusCode,
+		Value:           ua.MustVariant(v),
+		ServerTimestamp: time.Now(),
+		Status:          ua.StatusOK,
+	}
+}
+
+func statusDataValue(status ua.StatusCode) *ua.DataValue {
+	return &ua.DataValue{
+		EncodingMask:    ua.DataValueServerTimestamp | ua.DataValueStatusCode,
+		ServerTimestamp: time.Now(),
+		Status:          status,
+	}
+}
+
+func localizedText(text string) *ua.LocalizedText {
+	return &ua.LocalizedText{EncodingMask: ua.LocalizedTextText, Text: text}
+}
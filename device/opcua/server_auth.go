@@ -0,0 +1,147 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package opcua
+
+import (
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/server"
+	"github.com/gopcua/opcua/ua"
+	"github.com/gopcua/opcua/uapolicy"
+	"github.com/gopcua/opcua/uasc"
+)
+
+// 客户端加密密码的时候在末尾带上服务端的 nonce, gopcua 服务端的 nonce 固定 32 字节
+const serverNonceLength = 32
+
+// RSA-OAEP, Basic256Sha256 加密用户密码的算法
+const passwordEncryptionRsaOaep = "http://www.w3.org/2001/04/xmlenc#rsa-oaep"
+
+/*
+*
+* 服务端用户认证: 匿名开关和用户名密码; 加密的密码用服务端私钥解密
+*
+ */
+type ServerAuth struct {
+	AllowAnonymous bool
+	Users          map[string]string // 用户名 -> 密码
+	PrivateKey     *rsa.PrivateKey
+}
+
+/*
+*
+* 检查会话激活请求里面的用户身份令牌, 没有令牌的按照匿名处理
+*
+ */
+func (a ServerAuth) Check(token *ua.ExtensionObject) error {
+	var value any
+	if token != nil {
+		value = token.Value
+	}
+	switch t := value.(type) {
+	case nil, *ua.AnonymousIdentityToken:
+		if !a.AllowAnonymous {
+			return fmt.Errorf("anonymous login is disabled")
+		}
+		return nil
+	case *ua.UserNameIdentityToken:
+		expect, ok := a.Users[t.UserName]
+		if !ok {
+			return fmt.Errorf("unknown user: %s", t.UserName)
+		}
+		password, err := a.password(t)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(password), []byte(expect)) != 1 {
+			return fmt.Errorf("invalid password for user: %s", t.UserName)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported identity token: %T", value)
+}
+
+// 密码格式: 4 字节小端长度 + 密码 + 服务端 nonce
+func (a ServerAuth) password(t *ua.UserNameIdentityToken) (string, error) {
+	switch t.EncryptionAlgorithm {
+	case "":
+		return string(t.Password), nil
+	case passwordEncryptionRsaOaep:
+	default:
+		return "", fmt.Errorf("unsupported password encryption: %s", t.EncryptionAlgorithm)
+	}
+	if a.PrivateKey == nil {
+		return "", fmt.Errorf("server private key not configured")
+	}
+	enc, err := uapolicy.Asymmetric(ua.SecurityPolicyURIBasic256Sha256, a.PrivateKey, &a.PrivateKey.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	plain, err := enc.Decrypt(t.Password)
+	if err != nil {
+		return "", fmt.Errorf("decrypt password failed: %v", err)
+	}
+	if len(plain) < 4 {
+		return "", fmt.Errorf("invalid encrypted password")
+	}
+	length := int(binary.LittleEndian.Uint32(plain[:4]))
+	if length < serverNonceLength || length > len(plain)-4 {
+		return "", fmt.Errorf("invalid encrypted password")
+	}
+	return string(plain[4 : 4+length-serverNonceLength]), nil
+}
+
+/*
+*
+* 注册会话激活的处理函数: 先检查用户身份, 通过以后交给 gopcua 自己的实现校验签名和更新 nonce.
+* 必须在服务端 Start 之前调用, Start 不会覆盖已经注册的处理函数
+*
+ */
+func RegisterServerAuth(srv *server.Server, auth ServerAuth) error {
+	activate, err := sessionService(srv)
+	if err != nil {
+		return err
+	}
+	srv.RegisterHandler(id.ActivateSessionRequest_Encoding_DefaultBinary,
+		func(sc *uasc.SecureChannel, r ua.Request, reqID uint32) (ua.Response, error) {
+			req, ok := r.(*ua.ActivateSessionRequest)
+			if !ok {
+				return nil, ua.StatusBadRequestTypeInvalid
+			}
+			if err := auth.Check(req.UserIdentityToken); err != nil {
+				return nil, ua.StatusBadIdentityTokenRejected
+			}
+			return activate.ActivateSession(sc, r, reqID)
+		})
+	return nil
+}
+
+// gopcua 的 SessionService 只有一个未导出的服务端字段并且没有构造函数, 这里通过反射设置
+func sessionService(srv *server.Server) (*server.SessionService, error) {
+	service := &server.SessionService{}
+	field := reflect.ValueOf(service).Elem().FieldByName("srv")
+	if !field.IsValid() || field.Type() != reflect.TypeOf(srv) {
+		return nil, fmt.Errorf("unsupported gopcua session service")
+	}
+	reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem().Set(reflect.ValueOf(srv))
+	return service, nil
+}
@@ -0,0 +1,189 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package opcua
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+)
+
+// 支持读写的基础数据类型, 名字和 OPC UA 规范里面的一致
+var dataTypes = map[string]ua.TypeID{
+	"Boolean":  ua.TypeIDBoolean,
+	"SByte":    ua.TypeIDSByte,
+	"Byte":     ua.TypeIDByte,
+	"Int16":    ua.TypeIDInt16,
+	"UInt16":   ua.TypeIDUint16,
+	"Int32":    ua.TypeIDInt32,
+	"UInt32":   ua.TypeIDUint32,
+	"Int64":    ua.TypeIDInt64,
+	"UInt64":   ua.TypeIDUint64,
+	"Float":    ua.TypeIDFloat,
+	"Double":   ua.TypeIDDouble,
+	"String":   ua.TypeIDString,
+	"DateTime": ua.TypeIDDateTime,
+}
+
+/*
+*
+* 检查数据类型, 空字符串表示写的时候按照节点当前值的类型
+*
+ */
+func CheckDataType(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := dataTypes[name]; !ok {
+		return fmt.Errorf("unsupported data type: %s", name)
+	}
+	return nil
+}
+
+/*
+*
+* 数据类型的名字, 不在支持列表里面的返回空字符串
+*
+ */
+func DataTypeName(typeId ua.TypeID) string {
+	for name, id := range dataTypes {
+		if id == typeId {
+			return name
+		}
+	}
+	return ""
+}
+
+/*
+*
+* 解析节点 ID, 比如 ns=2;s=Temperature, ns=3;i=1001, i=85
+*
+ */
+func ParseNodeID(nodeId string) (*ua.NodeID, error) {
+	id, err := ua.ParseNodeID(strings.TrimSpace(nodeId))
+	if err != nil {
+		return nil, fmt.Errorf("invalid node id '%s': %v", nodeId, err)
+	}
+	return id, nil
+}
+
+/*
+*
+* 把变量的值转成可以直接 JSON 序列化的值: 时间转成 RFC3339 字符串,
+* 字节串转成 Base64, 本地化文本只取文本, 其他复杂类型取字符串形式
+*
+ */
+func VariantValue(v *ua.Variant) any {
+	if v == nil {
+		return nil
+	}
+	switch value := v.Value().(type) {
+	case nil, bool, int8, uint8, int16, uint16, int32, uint32, int64, uint64,
+		float32, float64, string:
+		return value
+	case []bool, []int8, []int16, []uint16, []int32, []uint32, []int64, []uint64,
+		[]float32, []float64, []string:
+		return value
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(value)
+	case *ua.LocalizedText:
+		return value.Text
+	case *ua.QualifiedName:
+		return value.Name
+	case *ua.NodeID:
+		return value.String()
+	case ua.StatusCode:
+		return uint32(value)
+	}
+	return fmt.Sprintf("%v", v.Value())
+}
+
+/*
+*
+* 把字符串形式的值按照数据类型转成变量, 只支持基础数据类型
+*
+ */
+func NewVariant(dataType string, value string) (*ua.Variant, error) {
+	typeId, ok := dataTypes[dataType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported data type: %s", dataType)
+	}
+	value = strings.TrimSpace(value)
+	var v any
+	var err error
+	switch typeId {
+	case ua.TypeIDBoolean:
+		switch strings.ToLower(value) {
+		case "1", "true", "on":
+			v = true
+		case "0", "false", "off":
+			v = false
+		default:
+			err = fmt.Errorf("invalid bool value: %s", value)
+		}
+	case ua.TypeIDSByte:
+		v, err = parseInt(value, 8, func(i int64) any { return int8(i) })
+	case ua.TypeIDInt16:
+		v, err = parseInt(value, 16, func(i int64) any { return int16(i) })
+	case ua.TypeIDInt32:
+		v, err = parseInt(value, 32, func(i int64) any { return int32(i) })
+	case ua.TypeIDInt64:
+		v, err = parseInt(value, 64, func(i int64) any { return i })
+	case ua.TypeIDByte:
+		v, err = parseUint(value, 8, func(u uint64) any { return uint8(u) })
+	case ua.TypeIDUint16:
+		v, err = parseUint(value, 16, func(u uint64) any { return uint16(u) })
+	case ua.TypeIDUint32:
+		v, err = parseUint(value, 32, func(u uint64) any { return uint32(u) })
+	case ua.TypeIDUint64:
+		v, err = parseUint(value, 64, func(u uint64) any { return u })
+	case ua.TypeIDFloat:
+		f, errParse := strconv.ParseFloat(value, 32)
+		v, err = float32(f), errParse
+	case ua.TypeIDDouble:
+		v, err = strconv.ParseFloat(value, 64)
+	case ua.TypeIDString:
+		v = value
+	case ua.TypeIDDateTime:
+		v, err = time.Parse(time.RFC3339, value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value '%s': %v", dataType, value, err)
+	}
+	return ua.NewVariant(v)
+}
+
+func parseInt(value string, bits int, cast func(int64) any) (any, error) {
+	i, err := strconv.ParseInt(value, 10, bits)
+	if err != nil {
+		return nil, err
+	}
+	return cast(i), nil
+}
+
+func parseUint(value string, bits int, cast func(uint64) any) (any, error) {
+	u, err := strconv.ParseUint(value, 10, bits)
+	if err != nil {
+		return nil, err
+	}
+	return cast(u), nil
+}
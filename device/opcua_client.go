@@ -0,0 +1,530 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	gopcua "github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/ua"
+	"github.com/hootrhino/rhilex/component/deadband"
+	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/device/opcua"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+type OpcuaClientCommonConfig struct {
+	PublishInterval *int `json:"publishInterval" validate:"required"` // 订阅的发布周期, 毫秒
+	Timeout         *int `json:"timeout" validate:"required"`         // 连接和请求超时, 毫秒
+}
+
+type OpcuaClientConfig struct {
+	Endpoint string `json:"endpoint" validate:"required"` // opc.tcp://127.0.0.1:4840
+	opcua.SecurityConfig
+}
+
+type OpcuaClientMainConfig struct {
+	CommonConfig OpcuaClientCommonConfig `json:"commonConfig" validate:"required"`
+	OpcuaConfig  OpcuaClientConfig       `json:"opcuaConfig" validate:"required"`
+}
+
+// 点位表
+type opcuaDataPoint struct {
+	UUID             string  `json:"-"`
+	NodeId           string  `json:"nodeId"`           // ns=2;s=Temperature
+	DataType         string  `json:"dataType"`         // Double
+	Tag              string  `json:"tag"`              // temp
+	Alias            string  `json:"alias"`            // 温度
+	SamplingInterval *uint64 `json:"samplingInterval"` // 采样周期, 毫秒
+
+	deadband.ReportPolicy // 上报策略
+}
+
+// 上报的数据
+type OpcuaNodeValue struct {
+	NodeId          string `json:"nodeId"`
+	DataType        string `json:"dataType"`
+	Tag             string `json:"tag"`
+	Alias           string `json:"alias"`
+	SourceTimestamp int64  `json:"sourceTimestamp"` // 毫秒
+	Value           any    `json:"value"`
+}
+
+// 写任意节点
+type OpcuaWriteNodeCmd struct {
+	NodeId   string `json:"nodeId"`
+	DataType string `json:"dataType"` // 空的时候按照节点当前值的类型
+	Value    string `json:"value"`
+}
+
+// 方法调用的参数
+type OpcuaMethodArg struct {
+	DataType string `json:"dataType"`
+	Value    string `json:"value"`
+}
+
+// 调用方法
+type OpcuaCallMethodCmd struct {
+	ObjectId string           `json:"objectId"` // 方法所属的对象
+	MethodId string           `json:"methodId"`
+	Args     []OpcuaMethodArg `json:"args"`
+}
+
+type OpcuaClient struct {
+	typex.XStatus
+	status       typex.SourceState
+	mainConfig   OpcuaClientMainConfig
+	locker       sync.Mutex
+	client       *gopcua.Client
+	points       []opcuaDataPoint
+	handles      map[uint32]opcuaDataPoint // 订阅的 ClientHandle 对应点位
+	tags         map[string]opcuaDataPoint
+	reportFilter *deadband.Filter
+}
+
+func NewOpcuaClient(e typex.Rhilex) typex.XDevice {
+	hd := new(OpcuaClient)
+	hd.RuleEngine = e
+	hd.mainConfig = OpcuaClientMainConfig{
+		CommonConfig: OpcuaClientCommonConfig{
+			PublishInterval: func() *int {
+				a := 1000
+				return &a
+			}(),
+			Timeout: func() *int {
+				a := 10000
+				return &a
+			}(),
+		},
+		OpcuaConfig: OpcuaClientConfig{
+			SecurityConfig: opcua.SecurityConfig{
+				Policy:   opcua.POLICY_NONE,
+				AuthType: opcua.AUTH_ANONYMOUS,
+			},
+		},
+	}
+	hd.handles = map[uint32]opcuaDataPoint{}
+	hd.tags = map[string]opcuaDataPoint{}
+	hd.reportFilter = deadband.NewFilter()
+	return hd
+}
+
+func (hd *OpcuaClient) Init(devId string, configMap map[string]any) error {
+	hd.PointId = devId
+	intercache.RegisterSlot(devId)
+	if err := utils.BindSourceConfig(configMap, &hd.mainConfig); err != nil {
+		glogger.GLogger.Error(err)
+		return err
+	}
+	if err := hd.mainConfig.OpcuaConfig.SecurityConfig.Check(); err != nil {
+		return err
+	}
+	if *hd.mainConfig.CommonConfig.PublishInterval < 50 {
+		return fmt.Errorf("publish interval must greater than 50ms")
+	}
+	points := []opcuaDataPoint{}
+	if err := interdb.InterDb().Table("m_opcua_data_points").
+		Where("device_uuid=?", devId).Find(&points).Error; err != nil {
+		return err
+	}
+	LastFetchTime := uint64(time.Now().UnixMilli())
+	for i, point := range points {
+		if _, err := opcua.ParseNodeID(point.NodeId); err != nil {
+			return err
+		}
+		if err := opcua.CheckDataType(point.DataType); err != nil {
+			return err
+		}
+		hd.handles[uint32(i+1)] = point
+		hd.tags[point.Tag] = point
+		intercache.SetValue(hd.PointId, point.UUID, intercache.CacheValue{
+			UUID:          point.UUID,
+			Status:        0,
+			LastFetchTime: LastFetchTime,
+			Value:         "",
+			ErrMsg:        "--",
+		})
+	}
+	hd.points = points
+	return nil
+}
+
+func (hd *OpcuaClient) Start(cctx typex.CCTX) error {
+	hd.Ctx = cctx.Ctx
+	hd.CancelCTX = cctx.CancelCTX
+	hd.reportFilter.Reset()
+	timeout := time.Duration(*hd.mainConfig.CommonConfig.Timeout) * time.Millisecond
+	ctx, cancel := context.WithTimeout(hd.Ctx, timeout)
+	defer cancel()
+	endpoint := hd.mainConfig.OpcuaConfig.Endpoint
+	opts, err := opcua.ClientOptions(ctx, endpoint, hd.mainConfig.OpcuaConfig.SecurityConfig)
+	if err != nil {
+		return err
+	}
+	// 断线以后由客户端自己重连, 重连以后订阅也会恢复
+	opts = append(opts, gopcua.RequestTimeout(timeout), gopcua.AutoReconnect(true))
+	client, err := gopcua.NewClient(endpoint, opts...)
+	if err != nil {
+		return err
+	}
+	if err := client.Connect(ctx); err != nil {
+		return err
+	}
+	hd.locker.Lock()
+	hd.client = client
+	hd.locker.Unlock()
+	if len(hd.points) > 0 {
+		if err := hd.subscribe(ctx, client); err != nil {
+			client.Close(context.Background())
+			return err
+		}
+	}
+	hd.status = typex.SOURCE_UP
+	return nil
+}
+
+/*
+*
+* 所有点位放到一个订阅里面, ClientHandle 就是点位的序号
+*
+ */
+func (hd *OpcuaClient) subscribe(ctx context.Context, client *gopcua.Client) error {
+	notifyCh := make(chan *gopcua.PublishNotificationData, 100)
+	sub, err := client.Subscribe(ctx, &gopcua.SubscriptionParameters{
+		Interval: time.Duration(*hd.mainConfig.CommonConfig.PublishInterval) * time.Millisecond,
+	}, notifyCh)
+	if err != nil {
+		return err
+	}
+	handles := make([]uint32, 0, len(hd.handles))
+	items := make([]*ua.MonitoredItemCreateRequest, 0, len(hd.handles))
+	for handle, point := range hd.handles {
+		nodeId, _ := opcua.ParseNodeID(point.NodeId)
+		item := gopcua.NewMonitoredItemCreateRequestWithDefaults(nodeId, ua.AttributeIDValue, handle)
+		if point.SamplingInterval != nil && *point.SamplingInterval > 0 {
+			item.RequestedParameters.SamplingInterval = float64(*point.SamplingInterval)
+		}
+		handles = append(handles, handle)
+		items = append(items, item)
+	}
+	res, err := sub.Monitor(ctx, ua.TimestampsToReturnBoth, items...)
+	if err != nil {
+		sub.Cancel(ctx)
+		return err
+	}
+	// 单个节点订阅失败不影响其他节点, 错误显示在点位表里面
+	for i, result := range res.Results {
+		if i >= len(handles) || result.StatusCode == ua.StatusOK {
+			continue
+		}
+		point := hd.handles[handles[i]]
+		glogger.GLogger.Error("OPC UA monitor error:", point.NodeId, result.StatusCode)
+		intercache.SetValue(hd.PointId, point.UUID, intercache.CacheValue{
+			UUID:          point.UUID,
+			Status:        0,
+			LastFetchTime: uint64(time.Now().UnixMilli()),
+			Value:         "",
+			ErrMsg:        result.StatusCode.Error(),
+		})
+	}
+	go hd.serve(notifyCh)
+	return nil
+}
+
+func (hd *OpcuaClient) serve(notifyCh <-chan *gopcua.PublishNotificationData) {
+	for {
+		select {
+		case <-hd.Ctx.Done():
+			return
+		case msg := <-notifyCh:
+			if msg.Error != nil {
+				glogger.GLogger.Error("OPC UA subscription error:", msg.Error)
+				continue
+			}
+			notification, ok := msg.Value.(*ua.DataChangeNotification)
+			if !ok {
+				continue
+			}
+			for _, item := range notification.MonitoredItems {
+				if point, ok := hd.handles[item.ClientHandle]; ok {
+					hd.handleValue(point, item.Value)
+				}
+			}
+		}
+	}
+}
+
+func (hd *OpcuaClient) handleValue(point opcuaDataPoint, dv *ua.DataValue) {
+	LastFetchTime := uint64(time.Now().UnixMilli())
+	if dv == nil {
+		return
+	}
+	if dv.Status != ua.StatusOK {
+		intercache.SetValue(hd.PointId, point.UUID, intercache.CacheValue{
+			UUID:          point.UUID,
+			Status:        0,
+			LastFetchTime: LastFetchTime,
+			Value:         "",
+			ErrMsg:        dv.Status.Error(),
+		})
+		return
+	}
+	value := opcua.VariantValue(dv.Value)
+	intercache.SetValue(hd.PointId, point.UUID, intercache.CacheValue{
+		UUID:          point.UUID,
+		Status:        1,
+		LastFetchTime: LastFetchTime,
+		Value:         fmt.Sprintf("%v", value),
+		ErrMsg:        "",
+	})
+	if !hd.reportFilter.Allow(point.Tag, point.ReportPolicy, fmt.Sprintf("%v", value)) {
+		return
+	}
+	dataType := point.DataType
+	if dataType == "" && dv.Value != nil {
+		dataType = opcua.DataTypeName(dv.Value.Type())
+	}
+	sourceTimestamp := int64(LastFetchTime)
+	if !dv.SourceTimestamp.IsZero() {
+		sourceTimestamp = dv.SourceTimestamp.UnixMilli()
+	}
+	bytes, err := json.Marshal(OpcuaNodeValue{
+		NodeId:          point.NodeId,
+		DataType:        dataType,
+		Tag:             point.Tag,
+		Alias:           point.Alias,
+		SourceTimestamp: sourceTimestamp,
+		Value:           value,
+	})
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return
+	}
+	hd.RuleEngine.WorkDevice(hd.Details(), string(bytes))
+}
+
+func (hd *OpcuaClient) getClient() (*gopcua.Client, error) {
+	hd.locker.Lock()
+	defer hd.locker.Unlock()
+	if hd.client == nil {
+		return nil, fmt.Errorf("opcua client not connected")
+	}
+	return hd.client, nil
+}
+
+func (hd *OpcuaClient) requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(hd.Ctx,
+		time.Duration(*hd.mainConfig.CommonConfig.Timeout)*time.Millisecond)
+}
+
+/*
+*
+* 写节点的值, 没有指定数据类型的时候先读一次节点, 按照当前值的类型写
+*
+ */
+func (hd *OpcuaClient) writeNode(nodeId, dataType, value string) error {
+	client, err := hd.getClient()
+	if err != nil {
+		return err
+	}
+	id, err := opcua.ParseNodeID(nodeId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := hd.requestContext()
+	defer cancel()
+	if dataType == "" {
+		current, err := client.Node(id).Value(ctx)
+		if err != nil {
+			return err
+		}
+		if dataType = opcua.DataTypeName(current.Type()); dataType == "" {
+			return fmt.Errorf("unsupported data type of node: %s", nodeId)
+		}
+	}
+	variant, err := opcua.NewVariant(dataType, value)
+	if err != nil {
+		return err
+	}
+	res, err := client.Write(ctx, &ua.WriteRequest{
+		NodesToWrite: []*ua.WriteValue{{
+			NodeID:      id,
+			AttributeID: ua.AttributeIDValue,
+			Value: &ua.DataValue{
+				EncodingMask: ua.DataValueValue,
+				Value:        variant,
+			},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if len(res.Results) > 0 && res.Results[0] != ua.StatusOK {
+		return res.Results[0]
+	}
+	return nil
+}
+
+func (hd *OpcuaClient) callMethod(cmd OpcuaCallMethodCmd) ([]any, error) {
+	client, err := hd.getClient()
+	if err != nil {
+		return nil, err
+	}
+	objectId, err := opcua.ParseNodeID(cmd.ObjectId)
+	if err != nil {
+		return nil, err
+	}
+	methodId, err := opcua.ParseNodeID(cmd.MethodId)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]*ua.Variant, 0, len(cmd.Args))
+	for _, arg := range cmd.Args {
+		variant, err := opcua.NewVariant(arg.DataType, arg.Value)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, variant)
+	}
+	ctx, cancel := hd.requestContext()
+	defer cancel()
+	res, err := client.Call(ctx, &ua.CallMethodRequest{
+		ObjectID:       objectId,
+		MethodID:       methodId,
+		InputArguments: args,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != ua.StatusOK {
+		return nil, res.StatusCode
+	}
+	outputs := make([]any, 0, len(res.OutputArguments))
+	for _, output := range res.OutputArguments {
+		outputs = append(outputs, opcua.VariantValue(output))
+	}
+	return outputs, nil
+}
+
+func (hd *OpcuaClient) Status() typex.SourceState {
+	hd.locker.Lock()
+	defer hd.locker.Unlock()
+	if hd.client != nil && hd.client.State() == gopcua.Closed {
+		return typex.SOURCE_DOWN
+	}
+	return hd.status
+}
+
+func (hd *OpcuaClient) Stop() {
+	hd.status = typex.SOURCE_DOWN
+	if hd.CancelCTX != nil {
+		hd.CancelCTX()
+	}
+	hd.locker.Lock()
+	if hd.client != nil {
+		hd.client.Close(context.Background())
+		hd.client = nil
+	}
+	hd.locker.Unlock()
+	intercache.UnRegisterSlot(hd.PointId)
+}
+
+func (hd *OpcuaClient) Details() *typex.Device {
+	return hd.RuleEngine.GetDevice(hd.PointId)
+}
+
+func (hd *OpcuaClient) SetState(status typex.SourceState) {
+	hd.status = status
+}
+
+func (hd *OpcuaClient) OnDCACall(UUID string, Command string, Args any) typex.DCAResult {
+	return typex.DCAResult{}
+}
+
+/*
+*
+* 外部控制指令:
+* - WriteToSheetRegisterWithTag: {"tag":"temp","value":"21.5"} 按照点位表里面的数据类型写
+* - WriteNode: {"nodeId":"ns=2;s=Setpoint","dataType":"Double","value":"21.5"} 写任意节点
+* - CallMethod: {"objectId":"ns=2;s=Machine","methodId":"ns=2;s=Start","args":[]} 返回输出参数
+* - Browse: {"nodeId":"i=85"} 返回子节点, 用来生成点位表
+*
+ */
+func (hd *OpcuaClient) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
+	glogger.Debug("OpcuaClient.OnCtrl, CMD=", string(cmd), ", Args=", string(args))
+	switch string(cmd) {
+	case "WriteToSheetRegisterWithTag":
+		ctrlCmd := CtrlCmd{}
+		if err := json.Unmarshal(args, &ctrlCmd); err != nil {
+			return nil, err
+		}
+		point, ok := hd.tags[ctrlCmd.Tag]
+		if !ok {
+			return nil, fmt.Errorf("point not exists: %s", ctrlCmd.Tag)
+		}
+		if err := hd.writeNode(point.NodeId, point.DataType, ctrlCmd.Value); err != nil {
+			return nil, err
+		}
+		return []byte{}, nil
+	case "WriteNode":
+		writeCmd := OpcuaWriteNodeCmd{}
+		if err := json.Unmarshal(args, &writeCmd); err != nil {
+			return nil, err
+		}
+		if err := hd.writeNode(writeCmd.NodeId, writeCmd.DataType, writeCmd.Value); err != nil {
+			return nil, err
+		}
+		return []byte{}, nil
+	case "CallMethod":
+		callCmd := OpcuaCallMethodCmd{}
+		if err := json.Unmarshal(args, &callCmd); err != nil {
+			return nil, err
+		}
+		outputs, err := hd.callMethod(callCmd)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(outputs)
+	case "Browse":
+		browseCmd := struct {
+			NodeId string `json:"nodeId"`
+		}{}
+		if len(args) > 0 {
+			if err := json.Unmarshal(args, &browseCmd); err != nil {
+				return nil, err
+			}
+		}
+		client, err := hd.getClient()
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := hd.requestContext()
+		defer cancel()
+		nodes, err := opcua.Browse(ctx, client, browseCmd.NodeId)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(nodes)
+	}
+	return nil, fmt.Errorf("unsupported command: %s", string(cmd))
+}
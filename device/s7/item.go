@@ -0,0 +1,155 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s7
+
+import (
+	"fmt"
+
+	"github.com/hootrhino/rhilex/utils"
+	"github.com/robinson/gos7"
+)
+
+// 存储区, 和 gos7 内部的定义一致
+const (
+	AREA_PE = 0x81 // 输入 I
+	AREA_PA = 0x82 // 输出 Q
+	AREA_MK = 0x83 // 位存储 M
+	AREA_DB = 0x84 // 数据块 DB
+)
+
+// 变量的字长
+const (
+	WL_BIT  = 0x01
+	WL_BYTE = 0x02
+)
+
+/*
+*
+* 一个 S7 变量: 存储区 + 数据块号 + 起始字节 + 位号 + 长度
+*
+ */
+type Item struct {
+	Area     int
+	DBNumber int
+	Start    int
+	Bit      int
+	Size     int
+	IsBit    bool
+}
+
+// 解析后的地址转换成变量
+func NewItem(info utils.AddressInfo) (Item, error) {
+	item := Item{
+		DBNumber: info.DataBlockNumber,
+		Start:    info.ElementNumber,
+		Bit:      info.BitNumber,
+		Size:     info.DataBlockSize,
+		IsBit:    info.DataBlockType == "BOOL",
+	}
+	switch info.AddressType {
+	case "DB":
+		item.Area = AREA_DB
+	case "I":
+		item.Area = AREA_PE
+	case "Q":
+		item.Area = AREA_PA
+	case "M":
+		item.Area = AREA_MK
+	default:
+		return item, fmt.Errorf("unsupported address type: %s", info.AddressType)
+	}
+	if item.Size < 1 {
+		return item, fmt.Errorf("invalid data size: %d", item.Size)
+	}
+	return item, nil
+}
+
+// 解析地址字符串
+func ParseItem(address string) (Item, error) {
+	info, err := utils.ParseSiemensDB(address)
+	if err != nil {
+		return Item{}, err
+	}
+	return NewItem(info)
+}
+
+// 多变量读写用的结构, Data 按照长度预先分配好
+func (item Item) DataItem(data []byte) gos7.S7DataItem {
+	dataItem := gos7.S7DataItem{
+		Area:     item.Area,
+		DBNumber: item.DBNumber,
+		Start:    item.Start,
+		WordLen:  WL_BYTE,
+		Amount:   item.Size,
+		Data:     data,
+	}
+	if item.IsBit {
+		dataItem.WordLen = WL_BIT
+		dataItem.Bit = item.Bit
+		dataItem.Amount = 1
+	}
+	return dataItem
+}
+
+/*
+*
+* 按字节读一个变量, 超过一个 PDU 的长度由 gos7 自己拆包
+*
+ */
+func ReadItem(client gos7.Client, item Item, buffer []byte) error {
+	switch item.Area {
+	case AREA_DB:
+		return client.AGReadDB(item.DBNumber, item.Start, item.Size, buffer)
+	case AREA_PE:
+		return client.AGReadEB(item.Start, item.Size, buffer)
+	case AREA_PA:
+		return client.AGReadAB(item.Start, item.Size, buffer)
+	case AREA_MK:
+		return client.AGReadMB(item.Start, item.Size, buffer)
+	}
+	return fmt.Errorf("unsupported area: %d", item.Area)
+}
+
+/*
+*
+* 写一个变量, 位变量单独按位写, 不会影响同一个字节里面的其他位
+*
+ */
+func WriteItem(client gos7.Client, item Item, data []byte) error {
+	if item.IsBit {
+		// gos7 多变量写的位地址不会自己乘8, 这里直接给位偏移
+		dataItems := []gos7.S7DataItem{item.DataItem(data[:1])}
+		dataItems[0].Start = item.Start*8 + item.Bit
+		if err := client.AGWriteMulti(dataItems, 1); err != nil {
+			return err
+		}
+		if dataItems[0].Error != "" {
+			return fmt.Errorf("%s", dataItems[0].Error)
+		}
+		return nil
+	}
+	switch item.Area {
+	case AREA_DB:
+		return client.AGWriteDB(item.DBNumber, item.Start, len(data), data)
+	case AREA_PE:
+		return client.AGWriteEB(item.Start, len(data), data)
+	case AREA_PA:
+		return client.AGWriteAB(item.Start, len(data), data)
+	case AREA_MK:
+		return client.AGWriteMB(item.Start, len(data), data)
+	}
+	return fmt.Errorf("unsupported area: %d", item.Area)
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s7
+
+// 一个请求最多20个变量, 这是 S7 协议的限制
+const MAX_MULTI_ITEMS = 20
+
+// 和 gos7 的检查方式一致, 长度里面包含 TPKT 和 COTP 的头
+const (
+	multiReadRequestHeader  = 19
+	multiReadRequestItem    = 12
+	multiReadResponseHeader = 21
+	multiReadResponseItem   = 4
+)
+
+/*
+*
+* 按照协商好的 PDU 长度把变量分组, 每组用一个多变量读请求:
+* 请求和响应都不能超过 PDU 长度, 每组最多20个变量; 单个变量就超过 PDU 的
+* 放到 singles 里面单独读
+*
+ */
+func PlanMultiRead(items []Item, pduLength int) (groups [][]int, singles []int) {
+	group := []int{}
+	request := multiReadRequestHeader
+	response := multiReadResponseHeader
+	for i, item := range items {
+		size := responseItemSize(item)
+		if multiReadResponseHeader+size > pduLength ||
+			multiReadRequestHeader+multiReadRequestItem > pduLength {
+			singles = append(singles, i)
+			continue
+		}
+		if len(group) == MAX_MULTI_ITEMS ||
+			request+multiReadRequestItem > pduLength ||
+			response+size > pduLength {
+			groups = append(groups, group)
+			group = []int{}
+			request = multiReadRequestHeader
+			response = multiReadResponseHeader
+		}
+		group = append(group, i)
+		request += multiReadRequestItem
+		response += size
+	}
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+	return groups, singles
+}
+
+// 响应里面每个变量有4字节的头, 数据是奇数长度的时候补齐一个字节
+func responseItemSize(item Item) int {
+	size := item.Size
+	if item.IsBit {
+		size = 1
+	}
+	if size%2 != 0 {
+		size++
+	}
+	return multiReadResponseItem + size
+}
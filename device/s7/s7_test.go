@@ -0,0 +1,132 @@
+package s7
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_ParseItem(t *testing.T) {
+	cases := []struct {
+		address string
+		expect  Item
+	}{
+		{"DB1.DBX10.3", Item{Area: AREA_DB, DBNumber: 1, Start: 10, Bit: 3, Size: 1, IsBit: true}},
+		// 旧版本保存的点位没有位号, 默认第 0 位
+		{"DB1.DBX10", Item{Area: AREA_DB, DBNumber: 1, Start: 10, Bit: 0, Size: 1, IsBit: true}},
+		{"%DB4900.DBD2108", Item{Area: AREA_DB, DBNumber: 4900, Start: 2108, Size: 4}},
+		{"DB2.DBS10.20", Item{Area: AREA_DB, DBNumber: 2, Start: 10, Size: 22}},
+		{"%I0.7", Item{Area: AREA_PE, Start: 0, Bit: 7, Size: 1, IsBit: true}},
+		{"QW4", Item{Area: AREA_PA, Start: 4, Size: 2}},
+		{"MD20", Item{Area: AREA_MK, Start: 20, Size: 4}},
+	}
+	for _, c := range cases {
+		item, err := ParseItem(c.address)
+		if err != nil {
+			t.Fatalf("%s: %v", c.address, err)
+		}
+		if item != c.expect {
+			t.Fatalf("%s: expect %+v, got %+v", c.address, c.expect, item)
+		}
+	}
+	for _, address := range []string{"DB1.DBX10.8", "DB1.DBW10.1", "I0", "X10"} {
+		if _, err := ParseItem(address); err == nil {
+			t.Fatalf("%s: invalid address must be rejected", address)
+		}
+	}
+}
+
+func Test_PlanMultiRead(t *testing.T) {
+	items := []Item{}
+	for i := 0; i < 25; i++ {
+		items = append(items, Item{Area: AREA_DB, DBNumber: 1, Start: i * 4, Size: 4})
+	}
+	// 字符串超过一个 PDU, 单独读
+	items = append(items, Item{Area: AREA_DB, DBNumber: 1, Start: 200, Size: 256})
+	groups, singles := PlanMultiRead(items, 240)
+	if len(singles) != 1 || singles[0] != 25 {
+		t.Fatalf("unexpected singles: %v", singles)
+	}
+	total := 0
+	for _, group := range groups {
+		request := multiReadRequestHeader + multiReadRequestItem*len(group)
+		response := multiReadResponseHeader
+		for _, index := range group {
+			response += responseItemSize(items[index])
+		}
+		if len(group) > MAX_MULTI_ITEMS || request > 240 || response > 240 {
+			t.Fatalf("group over pdu: %v", group)
+		}
+		total += len(group)
+	}
+	if total != 25 || len(groups) != 2 {
+		t.Fatalf("unexpected groups: %v", groups)
+	}
+}
+
+func Test_EncodeValue(t *testing.T) {
+	cases := []struct {
+		dataType string
+		order    string
+		weight   float64
+		size     int
+		value    string
+		expect   []byte
+	}{
+		{"BOOL", "A", 1, 1, "true", []byte{1}},
+		{"BYTE", "A", 1, 1, "200", []byte{200}},
+		{"INT16", "AB", 1, 2, "-2", []byte{0xFF, 0xFE}},
+		{"UINT16", "BA", 0.1, 2, "25.6", []byte{0x00, 0x01}},
+		{"INT32", "CDAB", 1, 4, "65538", []byte{0x00, 0x02, 0x00, 0x01}},
+		{"FLOAT32", "ABCD", 1, 4, "3.14159", []byte{0x40, 0x49, 0x0F, 0xD0}},
+		{"STRING", "A", 1, 6, "ab", []byte{4, 2, 'a', 'b', 0, 0}},
+	}
+	for _, c := range cases {
+		data, err := EncodeValue(c.dataType, c.order, c.weight, c.size, c.value)
+		if err != nil {
+			t.Fatalf("%s: %v", c.dataType, err)
+		}
+		if !bytes.Equal(data, c.expect) {
+			t.Fatalf("%s: expect % x, got % x", c.dataType, c.expect, data)
+		}
+	}
+	if _, err := EncodeValue("STRING", "A", 1, 4, "abc"); err == nil {
+		t.Fatal("string over max length must be rejected")
+	}
+	if _, err := EncodeValue("INT16", "AB", 1, 2, "40000"); err == nil {
+		t.Fatal("out of range value must be rejected")
+	}
+	if DecodeString([]byte{4, 2, 'a', 'b', 0, 0}) != "ab" {
+		t.Fatal("unexpected string")
+	}
+}
+
+func Test_ParseTIATagTable(t *testing.T) {
+	rows := [][]string{
+		{"Name", "Path", "Data Type", "Logical Address", "Comment"},
+		{"Motor Start", "Default tag table", "Bool", "%Q0.1", "电机启动"},
+		{"Speed", "Default tag table", "Real", "%MD20", ""},
+		{"Name", "Default tag table", "String[20]", "%DB1.DBB10", ""},
+		{"Counter", "Default tag table", "Int", "%MB4", ""},
+		{"Big", "Default tag table", "LReal", "%MD30", ""},
+	}
+	tags, skipped, err := ParseTIATagTable(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 3 || len(skipped) != 2 {
+		t.Fatalf("unexpected result: %+v %v", tags, skipped)
+	}
+	expect := []TIATag{
+		{Name: "Motor_Start", Comment: "电机启动", Address: "Q0.1", DataType: "BOOL", DataOrder: "A"},
+		{Name: "Speed", Comment: "Speed", Address: "MD20", DataType: "FLOAT32", DataOrder: "ABCD"},
+		{Name: "Name", Comment: "Name", Address: "DB1.DBS10.20", DataType: "STRING", DataOrder: "A"},
+	}
+	for i := range expect {
+		if tags[i] != expect[i] {
+			t.Fatalf("expect %+v, got %+v", expect[i], tags[i])
+		}
+	}
+	if _, _, err := ParseTIATagTable([][]string{{"Name", "Comment"}}); err == nil {
+		t.Fatal("missing columns must be rejected")
+	}
+}
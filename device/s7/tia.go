@@ -0,0 +1,168 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s7
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hootrhino/rhilex/utils"
+)
+
+// TIA Portal 导出的变量表里面的一行
+type TIATag struct {
+	Name      string // 变量名, 作为点位的 tag
+	Comment   string // 注释, 为空的时候用变量名
+	Address   string // 转换后的地址, 比如 DB1.DBD4, M10.0, DB1.DBS10.20
+	DataType  string // 转换后的点位类型
+	DataOrder string
+}
+
+var tiaStringType = regexp.MustCompile(`^(?i:w?string)(?:\[(\d+)\])?$`)
+
+// TIA 类型 => 点位类型和字节长度
+var tiaDataTypes = map[string]struct {
+	dataType string
+	size     int
+}{
+	"bool":  {"BOOL", 1},
+	"byte":  {"BYTE", 1},
+	"usint": {"BYTE", 1},
+	"char":  {"BYTE", 1},
+	"int":   {"INT16", 2},
+	"uint":  {"UINT16", 2},
+	"word":  {"UINT16", 2},
+	"dint":  {"INT32", 4},
+	"udint": {"UINT32", 4},
+	"dword": {"UINT32", 4},
+	"real":  {"FLOAT32", 4},
+}
+
+/*
+*
+* 解析 TIA Portal 导出的 PLC 变量表(CSV/XLSX 读出来的行), 第一行是表头,
+* 按照表头名字找 Name / Data Type / Logical Address / Comment 这几列;
+* 不支持的类型(结构体、数组、LReal 之类)跳过, 原因放在 skipped 里面
+*
+ */
+func ParseTIATagTable(rows [][]string) (tags []TIATag, skipped []string, err error) {
+	if len(rows) < 1 {
+		return nil, nil, fmt.Errorf("empty tag table")
+	}
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.TrimSpace(strings.Trim(name, "\ufeff\"")))
+		switch name {
+		case "name":
+			columns["name"] = i
+		case "data type", "datatype":
+			columns["type"] = i
+		case "logical address", "address":
+			columns["address"] = i
+		case "comment":
+			columns["comment"] = i
+		}
+	}
+	for _, column := range []string{"name", "type", "address"} {
+		if _, ok := columns[column]; !ok {
+			return nil, nil, fmt.Errorf("invalid tag table header, missing column '%s'", column)
+		}
+	}
+	cell := func(row []string, column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(strings.Trim(row[i], "\""))
+	}
+	for i := 1; i < len(rows); i++ {
+		name := cell(rows[i], "name")
+		if name == "" {
+			continue
+		}
+		tag, err := tiaTag(name, cell(rows[i], "type"), cell(rows[i], "address"))
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("row %d '%s': %v", i+1, name, err))
+			continue
+		}
+		tag.Comment = cell(rows[i], "comment")
+		if tag.Comment == "" {
+			tag.Comment = name
+		}
+		tags = append(tags, tag)
+	}
+	return tags, skipped, nil
+}
+
+func tiaTag(name, dataType, address string) (TIATag, error) {
+	tag := TIATag{Name: tiaTagName(name)}
+	info, err := utils.ParseSiemensDB(address)
+	if err != nil {
+		return tag, err
+	}
+	if match := tiaStringType.FindStringSubmatch(dataType); match != nil {
+		if strings.HasPrefix(strings.ToLower(dataType), "w") {
+			return tag, fmt.Errorf("unsupported data type: %s", dataType)
+		}
+		if info.AddressType != "DB" || info.DataBlockSize != 1 {
+			return tag, fmt.Errorf("string must be a DB byte address: %s", address)
+		}
+		maxLength := 254
+		if match[1] != "" {
+			maxLength, _ = strconv.Atoi(match[1])
+		}
+		if maxLength < 1 || maxLength > 254 {
+			return tag, fmt.Errorf("invalid string length: %s", dataType)
+		}
+		tag.Address = fmt.Sprintf("DB%d.DBS%d.%d", info.DataBlockNumber, info.ElementNumber, maxLength)
+		tag.DataType = "STRING"
+		tag.DataOrder = DefaultDataOrder(tag.DataType, "")
+		return tag, nil
+	}
+	typeInfo, ok := tiaDataTypes[strings.ToLower(dataType)]
+	if !ok {
+		return tag, fmt.Errorf("unsupported data type: %s", dataType)
+	}
+	if (typeInfo.dataType == "BOOL") != (info.DataBlockType == "BOOL") ||
+		typeInfo.size != info.DataBlockSize {
+		return tag, fmt.Errorf("address %s does not match data type %s", address, dataType)
+	}
+	tag.Address = strings.ToUpper(strings.TrimPrefix(strings.TrimSpace(address), "%"))
+	tag.DataType = typeInfo.dataType
+	tag.DataOrder = DefaultDataOrder(tag.DataType, "")
+	return tag, nil
+}
+
+// 变量名里面可能有空格、点之类的字符, 换成下划线
+func tiaTagName(name string) string {
+	builder := strings.Builder{}
+	for i, c := range name {
+		switch {
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			builder.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				builder.WriteRune('_')
+			}
+			builder.WriteRune(c)
+		default:
+			builder.WriteRune('_')
+		}
+	}
+	return builder.String()
+}
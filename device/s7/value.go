@@ -0,0 +1,186 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s7
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+*
+* S7 是大端序, 没有配置字节序的时候用 AB / ABCD
+*
+ */
+func DefaultDataOrder(dataType string, dataOrder string) string {
+	if dataOrder != "" {
+		return dataOrder
+	}
+	switch dataType {
+	case "INT16", "UINT16", "SHORT", "USHORT":
+		return "AB"
+	case "INT", "INT32", "UINT", "UINT32", "FLOAT", "FLOAT32", "UFLOAT32", "RAW":
+		return "ABCD"
+	}
+	return "A"
+}
+
+/*
+*
+* 把字符串形式的值按照点位的类型编码成要写入的字节, 整数按照权重还原:
+* 读的时候是 原始值*权重, 写的时候就是 值/权重
+*
+ */
+func EncodeValue(dataType string, dataOrder string, weight float64, size int, value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if weight == 0 {
+		weight = 1
+	}
+	switch dataType {
+	case "BOOL", "I", "Q":
+		switch strings.ToLower(value) {
+		case "1", "true", "on":
+			return []byte{1}, nil
+		case "0", "false", "off":
+			return []byte{0}, nil
+		}
+		return nil, fmt.Errorf("invalid bool value: %s", value)
+	case "BYTE":
+		u, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid byte value: %s", value)
+		}
+		return []byte{byte(u)}, nil
+	case "INT16", "SHORT":
+		i, err := parseWeighted(value, weight, math.MinInt16, math.MaxInt16)
+		if err != nil {
+			return nil, err
+		}
+		return orderBytes16(uint16(int16(i)), dataOrder)
+	case "UINT16", "USHORT":
+		i, err := parseWeighted(value, weight, 0, math.MaxUint16)
+		if err != nil {
+			return nil, err
+		}
+		return orderBytes16(uint16(i), dataOrder)
+	case "INT", "INT32":
+		i, err := parseWeighted(value, weight, math.MinInt32, math.MaxInt32)
+		if err != nil {
+			return nil, err
+		}
+		return orderBytes32(uint32(int32(i)), dataOrder)
+	case "UINT", "UINT32":
+		i, err := parseWeighted(value, weight, 0, math.MaxUint32)
+		if err != nil {
+			return nil, err
+		}
+		return orderBytes32(uint32(i), dataOrder)
+	case "FLOAT", "FLOAT32", "UFLOAT32":
+		f, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float value: %s", value)
+		}
+		return orderBytes32(math.Float32bits(float32(f)), dataOrder)
+	case "STRING":
+		return EncodeString(size, value)
+	case "RAW":
+		data, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex value: %s", value)
+		}
+		if size > 0 && len(data) != size {
+			return nil, fmt.Errorf("raw value length must be %d", size)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("write is not supported for data type: %s", dataType)
+}
+
+func parseWeighted(value string, weight float64, min, max float64) (int64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number value: %s", value)
+	}
+	f = math.Round(f / weight)
+	if f < min || f > max {
+		return 0, fmt.Errorf("value out of range: %s", value)
+	}
+	return int64(f), nil
+}
+
+func orderBytes16(v uint16, dataOrder string) ([]byte, error) {
+	data := make([]byte, 2)
+	switch dataOrder {
+	case "AB", "":
+		binary.BigEndian.PutUint16(data, v)
+	case "BA":
+		binary.LittleEndian.PutUint16(data, v)
+	default:
+		return nil, fmt.Errorf("invalid data order: %s", dataOrder)
+	}
+	return data, nil
+}
+
+func orderBytes32(v uint32, dataOrder string) ([]byte, error) {
+	data := make([]byte, 4)
+	switch dataOrder {
+	case "ABCD", "":
+		binary.BigEndian.PutUint32(data, v)
+	case "DCBA":
+		binary.LittleEndian.PutUint32(data, v)
+	case "CDAB":
+		data[0], data[1], data[2], data[3] = byte(v>>8), byte(v), byte(v>>24), byte(v>>16)
+	default:
+		return nil, fmt.Errorf("invalid data order: %s", dataOrder)
+	}
+	return data, nil
+}
+
+/*
+*
+* S7 字符串: 第1个字节是最大长度, 第2个字节是实际长度, 后面是字符;
+* size 是整个变量的长度, 包括2字节的头
+*
+ */
+func EncodeString(size int, value string) ([]byte, error) {
+	maxLength := size - 2
+	if maxLength < 1 || maxLength > 254 {
+		return nil, fmt.Errorf("invalid string size: %d", size)
+	}
+	if len(value) > maxLength {
+		return nil, fmt.Errorf("string length must be less than %d", maxLength+1)
+	}
+	data := make([]byte, size)
+	data[0] = byte(maxLength)
+	data[1] = byte(len(value))
+	copy(data[2:], value)
+	return data, nil
+}
+
+func DecodeString(data []byte) string {
+	if len(data) < 2 {
+		return ""
+	}
+	length := int(data[1])
+	if length > len(data)-2 {
+		length = len(data) - 2
+	}
+	return string(data[2 : 2+length])
+}
@@ -5,16 +5,20 @@ import (
 
 	"encoding/json"
 	"errors"
-
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/hootrhino/rhilex/component/deadband"
 	"github.com/hootrhino/rhilex/component/intercache"
+	"github.com/hootrhino/rhilex/component/intermetric"
 	"github.com/hootrhino/rhilex/resconfig"
 
 	"github.com/jinzhu/copier"
 
 	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/device/s7"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
 	"github.com/hootrhino/rhilex/utils"
@@ -40,6 +44,9 @@ type __SiemensDataPoint struct {
 	ElementNumber   int     `json:"-"`             // // 西门子解析后的地址信息: 元素号:1000...
 	DataSize        int     `json:"-"`             // // 西门子解析后的地址信息: 位号,0-8，只针对I、Q
 	BitNumber       int     `json:"-"`             // // 西门子解析后的地址信息: 位号,0-8，只针对I、Q
+	item            s7.Item // 读写用的变量
+
+	deadband.ReportPolicy // 上报策略
 }
 
 // https://cloudvpn.beijerelectronics.com/hc/en-us/articles/4406049761169-Siemens-S7
@@ -73,6 +80,10 @@ type SIEMENS_PLC struct {
 	handler             *gos7.TCPClientHandler
 	locker              sync.Mutex
 	__SiemensDataPoints map[string]*__SiemensDataPoint
+	pointIds            []string                       // 固定读的顺序, 多变量读按照这个顺序分组
+	tags                map[string]*__SiemensDataPoint // Tag => 点位, 写的时候用
+	frequency           int64                          // 轮询间隔, 取点位里面最小的频率
+	reportFilter        *deadband.Filter               // 按点位过滤上报
 }
 
 /*
@@ -98,6 +109,8 @@ func NewSIEMENS_PLC(e typex.Rhilex) typex.XDevice {
 		},
 	}
 	s1200.__SiemensDataPoints = map[string]*__SiemensDataPoint{}
+	s1200.tags = map[string]*__SiemensDataPoint{}
+	s1200.reportFilter = deadband.NewFilter()
 	return s1200
 }
 
@@ -120,6 +133,10 @@ func (s1200 *SIEMENS_PLC) Init(devId string, configMap map[string]any) error {
 		return errDb
 	}
 	// 开始解析地址表
+	s1200.__SiemensDataPoints = map[string]*__SiemensDataPoint{}
+	s1200.tags = map[string]*__SiemensDataPoint{}
+	s1200.pointIds = []string{}
+	s1200.frequency = 0
 	for _, SiemensDataPoint := range list {
 		// 频率不能太快
 		if SiemensDataPoint.Frequency < 1 {
@@ -135,20 +152,35 @@ func (s1200 *SIEMENS_PLC) Init(devId string, configMap map[string]any) error {
 		SiemensDataPoint.AddressType = AddressInfo.AddressType
 		SiemensDataPoint.BitNumber = AddressInfo.BitNumber
 		SiemensDataPoint.DataSize = AddressInfo.DataBlockSize
+		SiemensDataPoint.DataBlockOrder = s7.DefaultDataOrder(SiemensDataPoint.DataBlockType,
+			SiemensDataPoint.DataBlockOrder)
+		item, err2 := s7.NewItem(AddressInfo)
+		if err2 != nil {
+			return fmt.Errorf("%s: %v", SiemensDataPoint.SiemensAddress, err2)
+		}
 
 		// 提前缓冲
 		NewSiemensDataPoint := __SiemensDataPoint{}
 		copier.Copy(&NewSiemensDataPoint, &SiemensDataPoint)
+		NewSiemensDataPoint.item = item
 		s1200.__SiemensDataPoints[SiemensDataPoint.UUID] = &NewSiemensDataPoint
+		s1200.tags[SiemensDataPoint.Tag] = &NewSiemensDataPoint
+		s1200.pointIds = append(s1200.pointIds, SiemensDataPoint.UUID)
+		if s1200.frequency == 0 || SiemensDataPoint.Frequency < s1200.frequency {
+			s1200.frequency = SiemensDataPoint.Frequency
+		}
 		intercache.SetValue(s1200.PointId, SiemensDataPoint.UUID, intercache.CacheValue{
 			UUID:          SiemensDataPoint.UUID,
 			Status:        0,
 			LastFetchTime: 0,
 			Value:         "0",
-			ErrMsg:        "",
+			ErrMsg:        "--",
 		})
 	}
-
+	sort.Strings(s1200.pointIds)
+	if s1200.frequency < 10 {
+		s1200.frequency = 100 // 不能太快
+	}
 	return nil
 }
 
@@ -156,6 +188,7 @@ func (s1200 *SIEMENS_PLC) Init(devId string, configMap map[string]any) error {
 func (s1200 *SIEMENS_PLC) Start(cctx typex.CCTX) error {
 	s1200.Ctx = cctx.Ctx
 	s1200.CancelCTX = cctx.CancelCTX
+	s1200.reportFilter.Reset()
 	//
 	s1200.handler = gos7.NewTCPClientHandler(
 		s1200.mainConfig.S1200Config.Host, // 127.0.0.1:1500
@@ -185,15 +218,20 @@ func (s1200 *SIEMENS_PLC) Start(cctx typex.CCTX) error {
 				// Continue loop
 			}
 			s1200.locker.Lock()
+			pollStart := time.Now()
 			ReadPLCRegisterValues := s1200.Read()
+			intermetric.ObserveDevicePoll(s1200.PointId, time.Since(pollStart))
 			s1200.locker.Unlock()
 			if len(ReadPLCRegisterValues) < 1 {
 				time.Sleep(50 * time.Second)
 				continue
 			}
-			if !s1200.mainConfig.CommonConfig.BatchRequest {
-				if len(ReadPLCRegisterValues) > 0 {
-					if bytes, err := json.Marshal(ReadPLCRegisterValues); err != nil {
+			// 在锁外面等, 不挡住写操作
+			time.Sleep(time.Duration(s1200.frequency) * time.Millisecond)
+			// 批量模式下一次性上报, 单点模式在 Read 里面已经上报过了
+			if s1200.mainConfig.CommonConfig.BatchRequest {
+				if ReportValues := s1200.filterReportValues(ReadPLCRegisterValues); len(ReportValues) > 0 {
+					if bytes, err := json.Marshal(ReportValues); err != nil {
 						glogger.GLogger.Error(err)
 					} else {
 						s1200.RuleEngine.WorkDevice(s1200.Details(), string(bytes))
@@ -239,19 +277,77 @@ func (s1200 *SIEMENS_PLC) SetState(status typex.SourceState) {
 func (s1200 *SIEMENS_PLC) OnDCACall(UUID string, Command string, Args any) typex.DCAResult {
 	return typex.DCAResult{}
 }
+
+/*
+*
+* 外部控制指令:
+* - WriteToSheetRegisterWithTag: {"tag":"speed","value":"12.5"} 按照点位表的类型和字节序写
+* - WriteToAddress: {"address":"DB1.DBX0.1","dataType":"BOOL","value":"true"} 直接写地址,
+*   不填字节序的时候按照 S7 的大端序
+*
+ */
 func (s1200 *SIEMENS_PLC) OnCtrl(cmd []byte, args []byte) ([]byte, error) {
-	return []byte{}, nil
+	glogger.Debug("SIEMENS_PLC.OnCtrl, CMD=", string(cmd), ", Args=", string(args))
+	switch string(cmd) {
+	case "WriteToSheetRegisterWithTag":
+		ctrlCmd := CtrlCmd{}
+		if err := json.Unmarshal(args, &ctrlCmd); err != nil {
+			return nil, err
+		}
+		point, ok := s1200.tags[ctrlCmd.Tag]
+		if !ok {
+			return nil, fmt.Errorf("data point not exists: %s", ctrlCmd.Tag)
+		}
+		data, err := s7.EncodeValue(point.DataBlockType, point.DataBlockOrder,
+			point.Weight, point.DataSize, ctrlCmd.Value)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s1200.writeItem(point.item, data)
+	case "WriteToAddress":
+		writeCmd := SiemensWriteAddressCmd{}
+		if err := json.Unmarshal(args, &writeCmd); err != nil {
+			return nil, err
+		}
+		item, err := s7.ParseItem(writeCmd.Address)
+		if err != nil {
+			return nil, err
+		}
+		data, err := s7.EncodeValue(writeCmd.DataType,
+			s7.DefaultDataOrder(writeCmd.DataType, writeCmd.DataOrder), 1, item.Size, writeCmd.Value)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s1200.writeItem(item, data)
+	}
+	return nil, fmt.Errorf("unsupported command: %s", string(cmd))
+}
+
+// 直接写地址的参数
+type SiemensWriteAddressCmd struct {
+	Address   string `json:"address"`   // DB1.DBD4
+	DataType  string `json:"dataType"`  // FLOAT32
+	DataOrder string `json:"dataOrder"` // 为空的时候用大端序
+	Value     string `json:"value"`
+}
+
+// 和轮询共用一把锁, 一个连接上同时只有一个请求
+func (s1200 *SIEMENS_PLC) writeItem(item s7.Item, data []byte) error {
+	s1200.locker.Lock()
+	defer s1200.locker.Unlock()
+	if s1200.client == nil || s1200.status != typex.SOURCE_UP {
+		return fmt.Errorf("siemens plc not connected")
+	}
+	if !item.IsBit && len(data) != item.Size {
+		return fmt.Errorf("data length %d does not match address size %d", len(data), item.Size)
+	}
+	return s7.WriteItem(s1200.client, item, data)
 }
+
 func (s1200 *SIEMENS_PLC) Write(cmd []byte, data []byte) (int, error) {
 	return 0, nil
 }
 
-// 字节格式:[dbNumber1, start1, size1, dbNumber2, start2, size2]
-// 读: db --> dbNumber, start, size, buffer[]
-var rData = [resconfig.T_2KB]byte{} // 一次最大接受2KB数据
-// SIEMENS_PLC: 当读多字节寄存器的时候，需要考虑UTF8
-var __siemensReadResult = [256]byte{0}
-
 type ReadPLCRegisterValue struct {
 	Tag           string `json:"tag"`
 	Alias         string `json:"alias"`
@@ -259,57 +355,112 @@ type ReadPLCRegisterValue struct {
 	Value         string `json:"value"`
 }
 
+/*
+*
+* 批量模式下按照点位的上报策略过滤
+*
+ */
+func (s1200 *SIEMENS_PLC) filterReportValues(values []ReadPLCRegisterValue) []ReadPLCRegisterValue {
+	policies := map[string]deadband.ReportPolicy{}
+	for _, db := range s1200.__SiemensDataPoints {
+		policies[db.Tag] = db.ReportPolicy
+	}
+	result := make([]ReadPLCRegisterValue, 0, len(values))
+	for _, value := range values {
+		if s1200.reportFilter.Allow(value.Tag, policies[value.Tag], value.Value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+/*
+*
+* 按照协商好的 PDU 长度把点位打包成多变量读请求, 一个 PDU 放不下的点位单独读
+*
+ */
 func (s1200 *SIEMENS_PLC) Read() []ReadPLCRegisterValue {
 	values := []ReadPLCRegisterValue{}
-	for uuid, db := range s1200.__SiemensDataPoints {
-		//DB 4字节
-		if db.AddressType == "DB" {
-			lastTimes := uint64(time.Now().UnixMilli())
-
-			// 00.00.00.01 | 00.00.00.02 | 00.00.00.03 | 00.00.00.04
-			// 根据类型解析长度
-			if err := s1200.client.AGReadDB(db.DataBlockNumber,
-				db.ElementNumber, db.DataSize, rData[:]); err != nil {
-				glogger.GLogger.Error(err)
-				intercache.SetValue(s1200.PointId, uuid, intercache.CacheValue{
-					UUID:          uuid,
-					Status:        1,
-					LastFetchTime: lastTimes,
-					Value:         "",
-					ErrMsg:        err.Error(),
-				})
-				continue
-			}
-			// ValidData := [4]byte{} // 固定4字节，以后有8自己的时候再支持
-			copy(__siemensReadResult[:], rData[:db.DataSize])
-			Value := utils.ParseModbusValue(db.DataSize, db.DataBlockType, db.DataBlockOrder,
-				float32(db.Weight), __siemensReadResult)
-			PlcReadReg := ReadPLCRegisterValue{
-				Tag:           db.Tag,
-				Alias:         db.Alias,
-				Value:         Value,
-				LastFetchTime: lastTimes,
-			}
-			values = append(values, PlcReadReg)
-			intercache.SetValue(s1200.PointId, uuid, intercache.CacheValue{
-				UUID:          uuid,
-				Status:        0,
-				LastFetchTime: lastTimes,
-				Value:         Value,
-				ErrMsg:        "",
-			})
-			if !s1200.mainConfig.CommonConfig.BatchRequest {
-				if bytes, errMarshal := json.Marshal(PlcReadReg); errMarshal != nil {
-					glogger.GLogger.Error(errMarshal)
-				} else {
-					s1200.RuleEngine.WorkDevice(s1200.Details(), string(bytes))
-				}
-			}
+	points := make([]*__SiemensDataPoint, 0, len(s1200.pointIds))
+	items := make([]s7.Item, 0, len(s1200.pointIds))
+	for _, uuid := range s1200.pointIds {
+		points = append(points, s1200.__SiemensDataPoints[uuid])
+		items = append(items, s1200.__SiemensDataPoints[uuid].item)
+	}
+	groups, singles := s7.PlanMultiRead(items, s1200.handler.PDULength)
+	for _, group := range groups {
+		dataItems := make([]gos7.S7DataItem, len(group))
+		for i, index := range group {
+			dataItems[i] = items[index].DataItem(make([]byte, items[index].Size))
 		}
-		if db.Frequency < 10 {
-			db.Frequency = 100 // 不能太快
+		err := s1200.client.AGReadMulti(dataItems, len(dataItems))
+		for i, index := range group {
+			itemErr := err
+			if itemErr == nil && dataItems[i].Error != "" {
+				itemErr = errors.New(dataItems[i].Error)
+			}
+			values = s1200.handleReadValue(values, points[index], dataItems[i].Data, itemErr)
 		}
-		time.Sleep(time.Duration(db.Frequency) * time.Millisecond)
+	}
+	for _, index := range singles {
+		buffer := make([]byte, items[index].Size)
+		err := s7.ReadItem(s1200.client, items[index], buffer)
+		values = s1200.handleReadValue(values, points[index], buffer, err)
 	}
 	return values
 }
+
+/*
+*
+* 解析一个点位的值, 更新缓存, 单点模式下直接上报
+*
+ */
+func (s1200 *SIEMENS_PLC) handleReadValue(values []ReadPLCRegisterValue,
+	db *__SiemensDataPoint, data []byte, err error) []ReadPLCRegisterValue {
+	lastTimes := uint64(time.Now().UnixMilli())
+	if err != nil {
+		glogger.GLogger.Error(err)
+		intercache.SetValue(s1200.PointId, db.UUID, intercache.CacheValue{
+			UUID:          db.UUID,
+			Status:        0,
+			LastFetchTime: lastTimes,
+			Value:         "",
+			ErrMsg:        err.Error(),
+		})
+		return values
+	}
+	Value := ""
+	switch {
+	case db.item.IsBit:
+		Value = fmt.Sprintf("%v", data[0]&0x01 == 1)
+	case db.DataBlockType == "STRING":
+		Value = s7.DecodeString(data)
+	default:
+		readResult := [256]byte{}
+		copy(readResult[:], data)
+		Value = utils.ParseModbusValue(len(data), db.DataBlockType, db.DataBlockOrder,
+			float32(db.Weight), readResult)
+	}
+	PlcReadReg := ReadPLCRegisterValue{
+		Tag:           db.Tag,
+		Alias:         db.Alias,
+		Value:         Value,
+		LastFetchTime: lastTimes,
+	}
+	intercache.SetValue(s1200.PointId, db.UUID, intercache.CacheValue{
+		UUID:          db.UUID,
+		Status:        1,
+		LastFetchTime: lastTimes,
+		Value:         Value,
+		ErrMsg:        "",
+	})
+	if !s1200.mainConfig.CommonConfig.BatchRequest &&
+		s1200.reportFilter.Allow(db.Tag, db.ReportPolicy, Value) {
+		if bytes, errMarshal := json.Marshal(PlcReadReg); errMarshal != nil {
+			glogger.GLogger.Error(errMarshal)
+		} else {
+			s1200.RuleEngine.WorkDevice(s1200.Details(), string(bytes))
+		}
+	}
+	return append(values, PlcReadReg)
+}
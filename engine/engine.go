@@ -19,8 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"runtime"
-
-	lua "github.com/hootrhino/gopher-lua"
+	"sync"
 
 	intercache "github.com/hootrhino/rhilex/component/intercache"
 	"github.com/hootrhino/rhilex/component/intermetric"
@@ -33,21 +32,22 @@ import (
 	"github.com/hootrhino/rhilex/typex"
 	"github.com/hootrhino/rhilex/utils"
 	"github.com/shirou/gopsutil/v3/disk"
-	"github.com/sirupsen/logrus"
 )
 
 // 规则引擎
 type RuleEngine struct {
-	Rules   *orderedmap.OrderedMap[string, *typex.Rule]   `json:"rules"`
+	Rules   *orderedmap.OrderedMap[string, *ruleEntry]    `json:"rules"`
 	InEnds  *orderedmap.OrderedMap[string, *typex.InEnd]  `json:"inends"`
 	OutEnds *orderedmap.OrderedMap[string, *typex.OutEnd] `json:"outends"`
 	Devices *orderedmap.OrderedMap[string, *typex.Device] `json:"devices"`
 	Config  *typex.RhilexConfig                           `json:"config"`
+
+	ruleLocker sync.Mutex // 规则注册表的实例
 }
 
 func NewRuleEngine(config typex.RhilexConfig) typex.Rhilex {
 	return &RuleEngine{
-		Rules:   orderedmap.NewOrderedMap[string, *typex.Rule](),
+		Rules:   orderedmap.NewOrderedMap[string, *ruleEntry](),
 		InEnds:  orderedmap.NewOrderedMap[string, *typex.InEnd](),
 		OutEnds: orderedmap.NewOrderedMap[string, *typex.OutEnd](),
 		Devices: orderedmap.NewOrderedMap[string, *typex.Device](),
@@ -110,88 +110,31 @@ func (e *RuleEngine) Stop() {
 // 核心功能: Work, 主要就是推流进队列
 func (e *RuleEngine) WorkInEnd(in *typex.InEnd, data string) (bool, error) {
 	if err := interqueue.PushInQueue(in, data); err != nil {
+		intermetric.IncInFailed(intermetric.KIND_INEND, in.UUID)
 		return false, err
 	}
+	intermetric.IncIn(intermetric.KIND_INEND, in.UUID)
 	return true, nil
 }
 
 // 核心功能: Work, 主要就是推流进队列
 func (e *RuleEngine) WorkDevice(Device *typex.Device, data string) (bool, error) {
 	if err := interqueue.PushDeviceQueue(Device, data); err != nil {
+		intermetric.IncInFailed(intermetric.KIND_DEVICE, Device.UUID)
 		return false, err
 	}
+	intermetric.IncIn(intermetric.KIND_DEVICE, Device.UUID)
 	return true, nil
 }
 
-// RunSourceCallbacks 执行针对资源端的规则脚本
+// RunSourceCallbacks 执行针对资源端的规则脚本, 和队列协程执行的是同一份逻辑
 func (e *RuleEngine) RunSourceCallbacks(in *typex.InEnd, callbackArgs string) {
-	// 执行来自资源的脚本
-	for _, rule := range in.BindRules {
-		if rule.Status == typex.RULE_RUNNING {
-			if err := e.executeRule(&rule, callbackArgs); err != nil {
-				return
-			}
-		}
-	}
+	luaexecutor.RunSourceCallbacks(in, callbackArgs)
 }
 
-// RunDeviceCallbacks 执行针对设备端的规则脚本
+// RunDeviceCallbacks 执行针对设备端的规则脚本, 和队列协程执行的是同一份逻辑
 func (e *RuleEngine) RunDeviceCallbacks(Device *typex.Device, callbackArgs string) {
-	for _, rule := range Device.BindRules {
-		if err := e.executeRule(&rule, callbackArgs); err != nil {
-			return
-		}
-	}
-}
-
-// executeRule 执行规则脚本的公共逻辑
-func (e *RuleEngine) executeRule(rule *typex.Rule, callbackArgs string) error {
-	// 执行规则动作
-	_, errA := luaexecutor.ExecuteActions(rule, lua.LString(callbackArgs))
-	if errA != nil {
-		e.handleError(rule, errA)
-		return errA
-	}
-
-	// 执行成功处理
-	_, errS := luaexecutor.ExecuteSuccess(rule.LuaVM)
-	if errS != nil {
-		glogger.GLogger.Error(errS)
-		return errS
-	}
-
-	return nil
-}
-
-// handleError 处理规则执行错误
-func (e *RuleEngine) handleError(rule *typex.Rule, err error) {
-	Debugger, Ok := rule.LuaVM.GetStack(1)
-	if !Ok {
-		return
-	}
-
-	LValue, _ := rule.LuaVM.GetInfo("f", Debugger, lua.LNil)
-	rule.LuaVM.GetInfo("l", Debugger, lua.LNil)
-	rule.LuaVM.GetInfo("S", Debugger, lua.LNil)
-	rule.LuaVM.GetInfo("u", Debugger, lua.LNil)
-	rule.LuaVM.GetInfo("n", Debugger, lua.LNil)
-
-	LFunction := LValue.(*lua.LFunction)
-	LastCall := lua.DbgCall{
-		Name: "_main",
-	}
-	if len(LFunction.Proto.DbgCalls) > 0 {
-		LastCall = LFunction.Proto.DbgCalls[0]
-	}
-
-	glogger.GLogger.WithFields(logrus.Fields{
-		"topic": "rule/log/" + rule.UUID,
-	}).Warnf("Function Name: [%s],"+
-		"What: [%s], Source Line: [%d],"+
-		" Last Call: [%s], Error message: %s",
-		Debugger.Name, Debugger.What, Debugger.CurrentLine,
-		LastCall.Name, err.Error(),
-	)
+	luaexecutor.RunDeviceCallbacks(Device, callbackArgs)
 }
 
 func (e *RuleEngine) GetInEnd(uuid string) *typex.InEnd {
@@ -213,6 +156,7 @@ func (e *RuleEngine) RemoveInEnd(uuid string) {
 			inEnd.Source.Stop()
 			glogger.GLogger.Infof("InEnd [%s, %s] stopped", uuid, inEnd.Name)
 			e.InEnds.Delete(uuid)
+			e.unbindRuleInstances(uuid)
 			glogger.GLogger.Infof("InEnd [%s, %s] has been deleted", uuid, inEnd.Name)
 			inEnd = nil
 		}
@@ -271,7 +215,7 @@ func (e *RuleEngine) SnapshotDump() string {
 		"osArch":   runtime.GOOS + "-" + runtime.GOARCH,
 	}
 	data := map[string]any{
-		"rules":      e.Rules.Values(),
+		"rules":      e.AllRules(),
 		"inends":     e.InEnds.Values(),
 		"outends":    e.OutEnds.Values(),
 		"devices":    e.Devices.Values(),
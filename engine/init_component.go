@@ -24,10 +24,12 @@ import (
 	"github.com/hootrhino/rhilex/component/eventbus"
 	intercache "github.com/hootrhino/rhilex/component/intercache"
 	"github.com/hootrhino/rhilex/component/interdb"
+	"github.com/hootrhino/rhilex/component/interflow"
 	"github.com/hootrhino/rhilex/component/interkv"
 	"github.com/hootrhino/rhilex/component/intermetric"
 	"github.com/hootrhino/rhilex/component/internotify"
 	"github.com/hootrhino/rhilex/component/interqueue"
+	"github.com/hootrhino/rhilex/component/interwindow"
 	"github.com/hootrhino/rhilex/component/lostcache"
 	"github.com/hootrhino/rhilex/component/security"
 	supervisor "github.com/hootrhino/rhilex/component/supervisor"
@@ -73,7 +75,10 @@ func InitAllComponent(__DefaultRuleEngine typex.Rhilex) {
 	// current only support Internal ai
 	aibase.InitAlgorithmRuntime(__DefaultRuleEngine)
 	// Internal Queue
-	interqueue.InitXQueue(__DefaultRuleEngine, core.GlobalConfig.MaxQueueSize)
+	interqueue.InitXQueue(__DefaultRuleEngine, core.GlobalConfig.MaxQueueSize,
+		core.GlobalConfig.QueueWorkers, core.GlobalConfig.QueueFullPolicy)
+	// Flow Engine, 流程输出的数据走输出队列
+	interflow.InitFlowEngine(__DefaultRuleEngine, interqueue.PushOutQueue)
 	// Init Transceiver Communicator Manager
 	transceiver.InitTransceiverManager(__DefaultRuleEngine)
 	// Init Device Registry
@@ -92,8 +97,12 @@ func InitAllComponent(__DefaultRuleEngine typex.Rhilex) {
 func StartAllComponent() {
 	// Internal BUS
 	interqueue.StartXQueue()
+	// 定时关闭空闲的窗口
+	interwindow.StartWindowFlusher()
 }
 func StopAllComponent() {
+	interwindow.StopWindowFlusher()
+	interqueue.StopXQueue()
 	crontask.StopCronRebootExecutor()
 	supervisor.StopSupervisorAdmin()
 	applet.Stop()
@@ -58,6 +58,7 @@ func (e *RuleEngine) RemoveDevice(uuid string) {
 			dev.Device.Stop()
 			glogger.GLogger.Infof("Device [%v] has been stopped", uuid)
 			e.Devices.Delete(uuid)
+			e.unbindRuleInstances(uuid)
 			dev = nil
 			glogger.GLogger.Infof("Device [%v] has been deleted", uuid)
 		}
@@ -155,18 +156,13 @@ func startDevice(xDevice typex.XDevice, e *RuleEngine,
 		// bind 最新的规则 要从数据库拿刚更新的
 		for _, rule := range device.BindRules {
 			glogger.GLogger.Debugf("Load rule:(%s,%s)", rule.UUID, rule.Name)
-			RuleInstance := typex.NewLuaRule(e,
-				rule.UUID,
-				rule.Name,
-				rule.Description,
-				rule.FromSource,
-				rule.FromDevice,
-				rule.Success,
-				rule.Actions,
-				rule.Failed)
-			if err1 := e.LoadRule(RuleInstance); err1 != nil {
+			// 一个规则可能绑定了多个资源, 这里只绑定到当前资源, 每个资源一个独立的实例
+			RuleInstance, err1 := e.newRuleInstance(&rule)
+			if err1 != nil {
 				return err1
 			}
+			device.BindRules[rule.UUID] = *RuleInstance
+			e.bindRuleInstance(device.UUID, RuleInstance)
 		}
 	}
 	return nil
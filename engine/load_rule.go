@@ -16,14 +16,129 @@
 package engine
 
 import (
+	"encoding/json"
+
+	"github.com/hootrhino/rhilex/component/luaguard"
 	"github.com/hootrhino/rhilex/component/luaruntime"
 	"github.com/hootrhino/rhilex/glogger"
 	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
 )
 
-// LoadRule: 每个规则都绑定了资源(FromSource)或者设备(FromDevice)
+// LoadRule: 规则绑定到订阅的资源(FromSource, FromDevice 以及 Selector 里面列出的资源)
 // 使用MAP来记录RULE的绑定关系, KEY是UUID, Value是规则
+// 不同资源的数据由不同的队列协程处理, 除了第一个资源以外都绑定独立的实例, 不共用虚拟机
 func (e *RuleEngine) LoadRule(r *typex.Rule) error {
+	if err := e.prepareRule(r); err != nil {
+		return err
+	}
+	e.SaveRule(r)
+	bound := false
+	instance := func() (*typex.Rule, error) {
+		if !bound {
+			bound = true
+			return r, nil
+		}
+		return e.newRuleInstance(r)
+	}
+	// 查找输入定义的资源是否存在
+	for _, uuid := range resourceIds(r.FromSource, r.Selector.Sources) {
+		if in := e.GetInEnd(uuid); in != nil {
+			RuleInstance, err := instance()
+			if err != nil {
+				return err
+			}
+			(in.BindRules)[r.UUID] = *RuleInstance
+			e.bindRuleInstance(uuid, RuleInstance)
+		}
+	}
+	// 查找输入定义的资源是否存在
+	for _, uuid := range resourceIds(r.FromDevice, r.Selector.Devices) {
+		if Device := e.GetDevice(uuid); Device != nil {
+			RuleInstance, err := instance()
+			if err != nil {
+				return err
+			}
+			// 绑定资源和规则，建立关联关系
+			(Device.BindRules)[r.UUID] = *RuleInstance
+			e.bindRuleInstance(uuid, RuleInstance)
+		}
+	}
+	return nil
+
+}
+
+// 订阅的资源 UUID, 去掉重复和空的
+func resourceIds(first string, ids []string) []string {
+	result := []string{}
+	for _, id := range append([]string{first}, ids...) {
+		if id != "" && !utils.SContains(result, id) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// 同一个规则的新实例, 有自己的虚拟机
+func (e *RuleEngine) newRuleInstance(r *typex.Rule) (*typex.Rule, error) {
+	RuleInstance := typex.NewLuaRule(e,
+		r.UUID,
+		r.Name,
+		r.Description,
+		r.FromSource,
+		r.FromDevice,
+		r.Success,
+		r.Actions,
+		r.Failed)
+	RuleInstance.Selector = r.Selector
+	RuleInstance.Budget = r.Budget
+	RuleInstance.Version = r.Version
+	if err := e.prepareRule(RuleInstance); err != nil {
+		return nil, err
+	}
+	if r.Shadow != nil {
+		Shadow, err := e.newShadowInstance(r.Shadow)
+		if err != nil {
+			// 影子版本加载失败不影响线上版本
+			glogger.GLogger.Error("Rule shadow load failed:", r.UUID, err)
+		} else {
+			RuleInstance.Shadow = Shadow
+		}
+	}
+	return RuleInstance, nil
+}
+
+/*
+*
+* 影子模式下待发布版本的实例: 不注册到引擎里面, 有副作用的调用全部拦截, 输出只用来比较
+*
+ */
+func (e *RuleEngine) newShadowInstance(r *typex.Rule) (*typex.Rule, error) {
+	Shadow := typex.NewLuaRule(e,
+		r.UUID,
+		r.Name,
+		r.Description,
+		r.FromSource,
+		r.FromDevice,
+		r.Success,
+		r.Actions,
+		r.Failed)
+	Shadow.Selector = r.Selector
+	Shadow.Budget = r.Budget
+	Shadow.Version = r.Version
+	if err := luaruntime.VerifyLuaSyntax(Shadow); err != nil {
+		return nil, err
+	}
+	if err := luaruntime.LoadExtLuaLib(e, Shadow.LuaVM); err != nil {
+		return nil, err
+	}
+	luaruntime.LoadRuleLibGroup(e, "RULE", Shadow.UUID, Shadow.LuaVM)
+	luaruntime.InstallShadow(Shadow.LuaVM, Shadow.UUID)
+	return Shadow, nil
+}
+
+// 规则加载前的校验和内置库加载
+func (e *RuleEngine) prepareRule(r *typex.Rule) error {
 	// 前置语法验证
 	if err := luaruntime.VerifyLuaSyntax(r); err != nil {
 		return err
@@ -32,63 +147,110 @@ func (e *RuleEngine) LoadRule(r *typex.Rule) error {
 	if err := luaruntime.LoadExtLuaLib(e, r.LuaVM); err != nil {
 		return err
 	}
-	e.SaveRule(r)
 	//--------------------------------------------------------------
 	// Load LoadBuildInLuaLib
 	//--------------------------------------------------------------
 	luaruntime.LoadRuleLibGroup(e, "RULE", r.UUID, r.LuaVM)
 	glogger.GLogger.Infof("Rule [%s, %s] load successfully", r.UUID, r.Name)
-	// 查找输入定义的资源是否存在
-	if in := e.GetInEnd(r.FromSource); in != nil {
-		(in.BindRules)[r.UUID] = *r
-		return nil
-	}
-	// 查找输入定义的资源是否存在
-	if Device := e.GetDevice(r.FromDevice); Device != nil {
-		// 绑定资源和规则，建立关联关系
-		(Device.BindRules)[r.UUID] = *r
-	}
 	return nil
+}
+
+/*
+*
+* 规则的注册表: 每个规则一项, 记录最新加载的规则以及它在每个资源上的实例.
+* 资源重新加载的时候替换对应的实例, 删除规则的时候所有实例一起解除绑定
+*
+ */
+type ruleEntry struct {
+	rule      *typex.Rule
+	instances map[string]*typex.Rule // 资源 UUID -> 实例
+}
 
+func (entry *ruleEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(entry.rule)
 }
 
 // GetRule a rule
 func (e *RuleEngine) GetRule(id string) *typex.Rule {
-	v, ok := (e.Rules).Get(id)
-	if ok {
-		return v
+	e.ruleLocker.Lock()
+	defer e.ruleLocker.Unlock()
+	if entry, ok := e.Rules.Get(id); ok {
+		return entry.rule
 	}
 	return nil
-
 }
 
+// 保存规则的定义, 已经绑定的实例保留
 func (e *RuleEngine) SaveRule(r *typex.Rule) {
-	e.Rules.Set(r.UUID, r)
+	e.ruleLocker.Lock()
+	defer e.ruleLocker.Unlock()
+	if entry, ok := e.Rules.Get(r.UUID); ok {
+		entry.rule = r
+		return
+	}
+	e.Rules.Set(r.UUID, &ruleEntry{rule: r, instances: map[string]*typex.Rule{}})
+}
+
+// 记录规则在某个资源上的实例, 资源加载规则的时候规则可能还没有注册
+func (e *RuleEngine) bindRuleInstance(resource string, r *typex.Rule) {
+	e.ruleLocker.Lock()
+	defer e.ruleLocker.Unlock()
+	entry, ok := e.Rules.Get(r.UUID)
+	if !ok {
+		entry = &ruleEntry{rule: r, instances: map[string]*typex.Rule{}}
+		e.Rules.Set(r.UUID, entry)
+	}
+	entry.instances[resource] = r
 }
 
-// RemoveRule and inend--rule bindings
+// 资源删除以后它上面的实例也不再属于规则
+func (e *RuleEngine) unbindRuleInstances(resource string) {
+	e.ruleLocker.Lock()
+	defer e.ruleLocker.Unlock()
+	for _, entry := range e.Rules.Values() {
+		delete(entry.instances, resource)
+	}
+}
+
+/*
+*
+* 删除规则: 解除所有资源上的实例, 包括还没有启动成功的资源上面从数据库加载的绑定.
+* 重新加载的规则不再因为之前超出预算被禁用
+*
+ */
 func (e *RuleEngine) RemoveRule(ruleId string) {
-	if rule := e.GetRule(ruleId); rule != nil {
-		for _, inEnd := range e.AllInEnds() {
-			for _, r := range inEnd.BindRules {
-				if rule.UUID == r.UUID {
-					delete(inEnd.BindRules, ruleId)
-				}
-			}
+	luaguard.Enable(ruleId)
+	e.ruleLocker.Lock()
+	entry, ok := e.Rules.Get(ruleId)
+	if ok {
+		e.Rules.Delete(ruleId)
+	}
+	e.ruleLocker.Unlock()
+	if !ok {
+		return
+	}
+	for _, inEnd := range e.AllInEnds() {
+		if _, ok := inEnd.BindRules[ruleId]; ok {
+			glogger.GLogger.Debugf("Unlink rule:[%s, %s]", ruleId, inEnd.UUID)
+			delete(inEnd.BindRules, ruleId)
 		}
-		for _, device := range e.AllDevices() {
-			for _, r := range device.BindRules {
-				glogger.GLogger.Debugf("Unlink rule:[%s, %s]", rule.UUID, rule.Name)
-				if rule.UUID == r.UUID {
-					delete(device.BindRules, ruleId)
-				}
-			}
+	}
+	for _, device := range e.AllDevices() {
+		if _, ok := device.BindRules[ruleId]; ok {
+			glogger.GLogger.Debugf("Unlink rule:[%s, %s]", ruleId, device.UUID)
+			delete(device.BindRules, ruleId)
 		}
-		e.Rules.Delete(ruleId)
-		glogger.GLogger.Infof("Rule [%s, %s] has been deleted", ruleId, rule.Name)
 	}
+	glogger.GLogger.Infof("Rule [%s, %s] has been deleted, instances: %d",
+		ruleId, entry.rule.Name, len(entry.instances))
 }
 
 func (e *RuleEngine) AllRules() []*typex.Rule {
-	return e.Rules.Values()
+	e.ruleLocker.Lock()
+	defer e.ruleLocker.Unlock()
+	rules := []*typex.Rule{}
+	for _, entry := range e.Rules.Values() {
+		rules = append(rules, entry.rule)
+	}
+	return rules
 }
@@ -103,18 +103,13 @@ func (e *RuleEngine) startSource(source typex.XSource,
 	Source := source.Details()
 	if Source != nil {
 		for _, rule := range Source.BindRules {
-			RuleInstance := typex.NewLuaRule(e,
-				rule.UUID,
-				rule.Name,
-				rule.Description,
-				rule.FromSource,
-				rule.FromDevice,
-				rule.Success,
-				rule.Actions,
-				rule.Failed)
-			if err1 := e.LoadRule(RuleInstance); err1 != nil {
+			// 一个规则可能绑定了多个资源, 这里只绑定到当前资源, 每个资源一个独立的实例
+			RuleInstance, err1 := e.newRuleInstance(&rule)
+			if err1 != nil {
 				return err1
 			}
+			Source.BindRules[rule.UUID] = *RuleInstance
+			e.bindRuleInstance(Source.UUID, RuleInstance)
 		}
 	}
 	return nil
@@ -73,6 +73,30 @@ func LoadAllDeviceType(e typex.Rhilex) {
 			NewDevice: device.NewKNXGateway,
 		},
 	)
+	DefaultDeviceRegistry.Register(typex.IEC104_MASTER,
+		&typex.XConfig{
+			Engine:    e,
+			NewDevice: device.NewIEC104Master,
+		},
+	)
+	DefaultDeviceRegistry.Register(typex.OPCUA_CLIENT,
+		&typex.XConfig{
+			Engine:    e,
+			NewDevice: device.NewOpcuaClient,
+		},
+	)
+	DefaultDeviceRegistry.Register(typex.DNP3_MASTER,
+		&typex.XConfig{
+			Engine:    e,
+			NewDevice: device.NewDnp3Master,
+		},
+	)
+	DefaultDeviceRegistry.Register(typex.DNP3_OUTSTATION,
+		&typex.XConfig{
+			Engine:    e,
+			NewDevice: device.NewDnp3Outstation,
+		},
+	)
 	DefaultDeviceRegistry.Register(typex.LORA_WAN_GATEWAY,
 		&typex.XConfig{
 			Engine:    e,
@@ -109,6 +133,12 @@ func LoadAllDeviceType(e typex.Rhilex) {
 			NewDevice: device.NewGenericModbusSlaver,
 		},
 	)
+	DefaultDeviceRegistry.Register(typex.MODBUS_GATEWAY,
+		&typex.XConfig{
+			Engine:    e,
+			NewDevice: device.NewModbusGateway,
+		},
+	)
 	DefaultDeviceRegistry.Register(typex.GENERIC_UART_RW,
 		&typex.XConfig{
 			Engine:    e,
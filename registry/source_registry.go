@@ -74,6 +74,18 @@ func LoadAllSourceType(e typex.Rhilex) {
 			NewSource: source.NewUdpInEndSource,
 		},
 	)
+	DefaultSourceRegistry.Register(typex.SNMP_TRAP_SERVER,
+		&typex.XConfig{
+			Engine:    e,
+			NewSource: source.NewSnmpTrapSource,
+		},
+	)
+	DefaultSourceRegistry.Register(typex.OPCUA_SERVER,
+		&typex.XConfig{
+			Engine:    e,
+			NewSource: source.NewOpcuaServer,
+		},
+	)
 	DefaultSourceRegistry.Register(typex.TCP_SERVER,
 		&typex.XConfig{
 			Engine:    e,
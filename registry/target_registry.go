@@ -98,6 +98,12 @@ func LoadAllTargetType(e typex.Rhilex) {
 			NewTarget: target.NewGrepTimeDbTarget,
 		},
 	)
+	DefaultTargetRegistry.Register(typex.SPARKPLUG_B,
+		&typex.XConfig{
+			Engine:    e,
+			NewTarget: target.NewSparkplugBTarget,
+		},
+	)
 }
 
 func (rm *TargetRegistry) Register(name typex.TargetType, f *typex.XConfig) {
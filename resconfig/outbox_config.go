@@ -0,0 +1,72 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package resconfig
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+*
+* 北向资源的离线补发, 放在资源配置的 outbox 里面
+*
+ */
+type OutboxConfig struct {
+	Enable      *bool `json:"enable"`      // 是否开启
+	MaxBacklog  int   `json:"maxBacklog"`  // 最多积压的消息条数, 超过以后丢弃最早的
+	MaxDiskSize int   `json:"maxDiskSize"` // 积压消息最多占用的空间, KB
+	TTL         int   `json:"ttl"`         // 消息最长保留时间, 秒, 0 表示不过期
+	ReplayRate  int   `json:"replayRate"`  // 补发速率, 条/秒, 最大 MaxOutboxReplayRate
+	MaxRetries  int   `json:"maxRetries"`  // 补发失败多少次以后进入死信表
+}
+
+// 补发速率的上限, 补发的间隔按照 1 秒除以速率计算
+const MaxOutboxReplayRate = 1000
+
+func DefaultOutboxConfig() OutboxConfig {
+	return OutboxConfig{
+		Enable:      new(bool),
+		MaxBacklog:  10000,
+		MaxDiskSize: 10240,
+		TTL:         86400,
+		ReplayRate:  50,
+		MaxRetries:  5,
+	}
+}
+
+func (c *OutboxConfig) Enabled() bool {
+	return c.Enable != nil && *c.Enable
+}
+
+func (c *OutboxConfig) Validate() error {
+	if c.MaxBacklog <= 0 {
+		return errors.New("outbox config error: maxBacklog must be a positive number")
+	}
+	if c.MaxDiskSize <= 0 {
+		return errors.New("outbox config error: maxDiskSize must be a positive number")
+	}
+	if c.TTL < 0 {
+		return errors.New("outbox config error: ttl must be a non-negative number")
+	}
+	if c.ReplayRate <= 0 || c.ReplayRate > MaxOutboxReplayRate {
+		return fmt.Errorf("outbox config error: replayRate must be between 1 and %d", MaxOutboxReplayRate)
+	}
+	if c.MaxRetries <= 0 {
+		return errors.New("outbox config error: maxRetries must be a positive number")
+	}
+	return nil
+}
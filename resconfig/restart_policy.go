@@ -0,0 +1,53 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package resconfig
+
+import "errors"
+
+/*
+*
+* 资源的重启策略, 放在资源配置的 restartPolicy 里面, 没有填的字段使用全局配置
+*
+ */
+type RestartPolicy struct {
+	InitialDelay *int     `json:"initialDelay"` // 第一次重启前等待的时间, 毫秒
+	Multiplier   *float64 `json:"multiplier"`   // 每次重启以后等待时间的倍数
+	MaxDelay     *int     `json:"maxDelay"`     // 等待时间的上限, 毫秒
+	MaxAttempts  *int     `json:"maxAttempts"`  // 连续重启的次数上限, 超过以后进入 FAILED, 0 表示不限制
+	ResetAfter   *int     `json:"resetAfter"`   // 稳定运行多久以后清零重启次数, 毫秒
+}
+
+func (p *RestartPolicy) Validate() error {
+	if p.InitialDelay != nil && *p.InitialDelay < 0 {
+		return errors.New("restart policy error: initialDelay must be a non-negative number")
+	}
+	if p.Multiplier != nil && *p.Multiplier < 1 {
+		return errors.New("restart policy error: multiplier must be greater than or equal to 1")
+	}
+	if p.MaxDelay != nil && *p.MaxDelay < 0 {
+		return errors.New("restart policy error: maxDelay must be a non-negative number")
+	}
+	if p.InitialDelay != nil && p.MaxDelay != nil && *p.MaxDelay > 0 && *p.MaxDelay < *p.InitialDelay {
+		return errors.New("restart policy error: maxDelay must be greater than or equal to initialDelay")
+	}
+	if p.MaxAttempts != nil && *p.MaxAttempts < 0 {
+		return errors.New("restart policy error: maxAttempts must be a non-negative number")
+	}
+	if p.ResetAfter != nil && *p.ResetAfter < 0 {
+		return errors.New("restart policy error: resetAfter must be a non-negative number")
+	}
+	return nil
+}
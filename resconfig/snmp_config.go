@@ -17,6 +17,7 @@ package resconfig
 
 import (
 	"errors"
+	"fmt"
 	"net"
 )
 
@@ -32,10 +33,68 @@ type GenericSnmpConfig struct {
 	Port uint16 `json:"port" validate:"required"`
 	// Transport is the transport protocol to use ("udp" or "tcp"); if unset "udp" will be used.
 	Transport string `json:"transport" validate:"required"`
-	// Community is an SNMP Community string.
-	Community string `json:"community" validate:"required"`
+	// Community is an SNMP Community string, v1 和 v2c 使用.
+	Community string `json:"community"`
 	// 1 2 3
 	Version uint8 `json:"version" validate:"required"`
+	// v3 的用户, Version 为 3 的时候使用
+	UsmConfig SnmpV3UsmConfig `json:"usmConfig"`
+}
+
+// SNMPv3 安全级别
+const (
+	SNMP_NO_AUTH_NO_PRIV string = "NoAuthNoPriv"
+	SNMP_AUTH_NO_PRIV    string = "AuthNoPriv"
+	SNMP_AUTH_PRIV       string = "AuthPriv"
+)
+
+/*
+*
+* SNMPv3 USM 用户
+*
+ */
+type SnmpV3UsmConfig struct {
+	UserName       string `json:"userName"`
+	SecurityLevel  string `json:"securityLevel"`  // NoAuthNoPriv | AuthNoPriv | AuthPriv
+	AuthProtocol   string `json:"authProtocol"`   // MD5 | SHA | SHA256
+	AuthPassphrase string `json:"authPassphrase"` // 至少8个字符
+	PrivProtocol   string `json:"privProtocol"`   // DES | AES
+	PrivPassphrase string `json:"privPassphrase"` // 至少8个字符
+	ContextName    string `json:"contextName"`
+}
+
+// Validate checks the SnmpV3UsmConfig for valid values.
+func (cfg *SnmpV3UsmConfig) Validate() error {
+	if cfg.UserName == "" {
+		return errors.New("snmp v3 config error: userName cannot be empty")
+	}
+	switch cfg.SecurityLevel {
+	case SNMP_NO_AUTH_NO_PRIV:
+		return nil
+	case SNMP_AUTH_NO_PRIV, SNMP_AUTH_PRIV:
+	default:
+		return fmt.Errorf("snmp v3 config error: invalid securityLevel '%s'", cfg.SecurityLevel)
+	}
+	switch cfg.AuthProtocol {
+	case "MD5", "SHA", "SHA256":
+	default:
+		return fmt.Errorf("snmp v3 config error: authProtocol must be MD5, SHA or SHA256")
+	}
+	if len(cfg.AuthPassphrase) < 8 {
+		return errors.New("snmp v3 config error: authPassphrase must be at least 8 characters")
+	}
+	if cfg.SecurityLevel == SNMP_AUTH_NO_PRIV {
+		return nil
+	}
+	switch cfg.PrivProtocol {
+	case "DES", "AES":
+	default:
+		return fmt.Errorf("snmp v3 config error: privProtocol must be DES or AES")
+	}
+	if len(cfg.PrivPassphrase) < 8 {
+		return errors.New("snmp v3 config error: privPassphrase must be at least 8 characters")
+	}
+	return nil
 }
 
 // Validate checks the GenericSnmpConfig for valid values.
@@ -52,11 +111,14 @@ func (cfg *GenericSnmpConfig) Validate() error {
 	if cfg.Transport != "udp" && cfg.Transport != "tcp" {
 		return errors.New("snmp config error: transport must be 'udp' or 'tcp'")
 	}
-	if cfg.Community == "" {
-		return errors.New("snmp config error: community cannot be empty")
-	}
 	if cfg.Version < 1 || cfg.Version > 3 {
 		return errors.New("snmp config error: version must be 1, 2, or 3")
 	}
+	if cfg.Version == 3 {
+		return cfg.UsmConfig.Validate()
+	}
+	if cfg.Community == "" {
+		return errors.New("snmp config error: community cannot be empty")
+	}
 	return nil
 }
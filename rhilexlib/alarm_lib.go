@@ -0,0 +1,46 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rhilexlib
+
+import (
+	"encoding/json"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/alarmcenter"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+/*
+*
+* 数据送到告警规则检查, 数据必须是 JSON 对象
+* local err = alarm:Input(alarmRuleId, source, json:T2J({temp = 30}))
+*
+ */
+func AlarmInput(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		in := map[string]any{}
+		if err := json.Unmarshal([]byte(l.ToString(4)), &in); err != nil {
+			l.Push(lua.LString("alarm input must be an object"))
+			return 1
+		}
+		if _, err := alarmcenter.Input(l.ToString(2), l.ToString(3), in); err != nil {
+			l.Push(lua.LString(err.Error()))
+			return 1
+		}
+		l.Push(lua.LNil)
+		return 1
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rhilexlib
+
+import (
+	"encoding/json"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+type bacnetWriteCmd struct {
+	Tag      string `json:"tag"`
+	Value    string `json:"value,omitempty"`
+	Priority int    `json:"priority"`
+}
+
+/*
+*
+* 按照点位表的 Tag 写 present-value, 优先级 1-16, 不填的时候为 16
+* local err = bacnet:Write("DEVICE_UUID", "ao1", "21.5", 8)
+*
+ */
+func BACnetWrite(rx typex.Rhilex) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		return bacnetCtrl(rx, l, "WriteProperty", bacnetWriteCmd{
+			Tag:      l.ToString(3),
+			Value:    l.ToString(4),
+			Priority: l.OptInt(5, 0),
+		})
+	}
+}
+
+/*
+*
+* 释放某个优先级上面写的值
+* local err = bacnet:Relinquish("DEVICE_UUID", "ao1", 8)
+*
+ */
+func BACnetRelinquish(rx typex.Rhilex) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		return bacnetCtrl(rx, l, "Relinquish", bacnetWriteCmd{
+			Tag:      l.ToString(3),
+			Priority: l.OptInt(4, 0),
+		})
+	}
+}
+
+func bacnetCtrl(rx typex.Rhilex, l *lua.LState, cmd string, writeCmd bacnetWriteCmd) int {
+	devUUID := l.ToString(2)
+	Device := rx.GetDevice(devUUID)
+	if Device == nil || Device.Device == nil {
+		l.Push(lua.LString("device not exists:" + devUUID))
+		return 1
+	}
+	if Device.Type != typex.GENERIC_BACNET_IP {
+		l.Push(lua.LString("not a bacnet device:" + devUUID))
+		return 1
+	}
+	if Device.Device.Status() != typex.SOURCE_UP {
+		l.Push(lua.LString("device down:" + devUUID))
+		return 1
+	}
+	args, _ := json.Marshal(writeCmd)
+	if _, err := Device.Device.OnCtrl([]byte(cmd), args); err != nil {
+		l.Push(lua.LString(err.Error()))
+		return 1
+	}
+	l.Push(lua.LNil)
+	return 1
+}
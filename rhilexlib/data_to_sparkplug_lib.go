@@ -0,0 +1,26 @@
+package rhilexlib
+
+import (
+	"github.com/hootrhino/rhilex/typex"
+
+	lua "github.com/hootrhino/gopher-lua"
+)
+
+/*
+*
+* 数据转发到 Sparkplug B：local err: = data:ToSparkplugB(uuid, data)
+*
+ */
+func DataToSparkplugB(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		id := l.ToString(2)
+		data := l.ToString(3)
+		err := handleDataFormat(rx, id, data)
+		if err != nil {
+			l.Push(lua.LString(err.Error()))
+			return 1
+		}
+		l.Push(lua.LNil)
+		return 1
+	}
+}
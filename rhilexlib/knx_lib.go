@@ -0,0 +1,71 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rhilexlib
+
+import (
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+/*
+*
+* 按照组地址表的 Tag 写 KNX 组地址, 值按照表里面的数据点类型编码
+* local err = knx:Write("DEVICE_UUID", "light1", "on")
+*
+ */
+func KNXWrite(rx typex.Rhilex) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		return knxCtrl(rx, l, "WriteGroupWithTag", CtrlCmd{
+			Tag:   l.ToString(3),
+			Value: l.ToString(4),
+		})
+	}
+}
+
+/*
+*
+* 发送组读请求, 读到的值和其他组报文一样由设备上报
+* local err = knx:Read("DEVICE_UUID", "temp")
+*
+ */
+func KNXRead(rx typex.Rhilex) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		return knxCtrl(rx, l, "ReadGroupWithTag", CtrlCmd{Tag: l.ToString(3)})
+	}
+}
+
+func knxCtrl(rx typex.Rhilex, l *lua.LState, cmd string, ctrlCmd CtrlCmd) int {
+	devUUID := l.ToString(2)
+	Device := rx.GetDevice(devUUID)
+	if Device == nil || Device.Device == nil {
+		l.Push(lua.LString("device not exists:" + devUUID))
+		return 1
+	}
+	if Device.Type != typex.KNX_GATEWAY {
+		l.Push(lua.LString("not a knx gateway:" + devUUID))
+		return 1
+	}
+	if Device.Device.Status() != typex.SOURCE_UP {
+		l.Push(lua.LString("device down:" + devUUID))
+		return 1
+	}
+	if _, err := Device.Device.OnCtrl([]byte(cmd), []byte(ctrlCmd.String())); err != nil {
+		l.Push(lua.LString(err.Error()))
+		return 1
+	}
+	l.Push(lua.LNil)
+	return 1
+}
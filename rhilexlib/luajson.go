@@ -108,6 +108,11 @@ func (i invalidTypeError) Error() string {
 	return `cannot encode ` + lua.LValueType(i).String() + ` to JSON`
 }
 
+// EncodeValue 把 Lua 的值转成 JSON, 给规则试运行之类的外部调用使用
+func EncodeValue(value lua.LValue) ([]byte, error) {
+	return _Encode(value)
+}
+
 // _Encode returns the JSON encoding of value.
 func _Encode(value lua.LValue) ([]byte, error) {
 	return json.Marshal(jsonValue{
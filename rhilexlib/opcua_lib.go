@@ -0,0 +1,132 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rhilexlib
+
+import (
+	"encoding/json"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+type opcuaWriteNodeCmd struct {
+	NodeId   string `json:"nodeId"`
+	DataType string `json:"dataType"`
+	Value    string `json:"value"`
+}
+
+type opcuaCallMethodCmd struct {
+	ObjectId string          `json:"objectId"`
+	MethodId string          `json:"methodId"`
+	Args     json.RawMessage `json:"args,omitempty"`
+}
+
+/*
+*
+* 按照点位表的 Tag 写节点, 值按照表里面的数据类型转换
+* local err = opcua:Write("DEVICE_UUID", "setpoint", "21.5")
+*
+ */
+func OpcuaWrite(rx typex.Rhilex) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		_, err := opcuaCtrl(rx, l, "WriteToSheetRegisterWithTag", CtrlCmd{
+			Tag:   l.ToString(3),
+			Value: l.ToString(4),
+		})
+		if err != "" {
+			l.Push(lua.LString(err))
+			return 1
+		}
+		l.Push(lua.LNil)
+		return 1
+	}
+}
+
+/*
+*
+* 直接写节点, 数据类型为空的时候按照节点当前值的类型
+* local err = opcua:WriteNode("DEVICE_UUID", "ns=2;s=Setpoint", "Double", "21.5")
+*
+ */
+func OpcuaWriteNode(rx typex.Rhilex) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		_, err := opcuaCtrl(rx, l, "WriteNode", opcuaWriteNodeCmd{
+			NodeId:   l.ToString(3),
+			DataType: l.ToString(4),
+			Value:    l.ToString(5),
+		})
+		if err != "" {
+			l.Push(lua.LString(err))
+			return 1
+		}
+		l.Push(lua.LNil)
+		return 1
+	}
+}
+
+/*
+*
+* 调用方法, 参数是 {dataType, value} 组成的数组, 返回输出参数的 JSON 数组
+* local result, err = opcua:Call("DEVICE_UUID", "ns=2;s=Machine", "ns=2;s=Start",
+*     {{dataType = "Int32", value = "1"}})
+*
+ */
+func OpcuaCall(rx typex.Rhilex) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		cmd := opcuaCallMethodCmd{
+			ObjectId: l.ToString(3),
+			MethodId: l.ToString(4),
+		}
+		if args, ok := l.Get(5).(*lua.LTable); ok && args.Len() > 0 {
+			data, err := EncodeValue(args)
+			if err != nil {
+				l.Push(lua.LString(""))
+				l.Push(lua.LString(err.Error()))
+				return 2
+			}
+			cmd.Args = data
+		}
+		result, err := opcuaCtrl(rx, l, "CallMethod", cmd)
+		if err != "" {
+			l.Push(lua.LString(""))
+			l.Push(lua.LString(err))
+			return 2
+		}
+		l.Push(lua.LString(result))
+		l.Push(lua.LNil)
+		return 2
+	}
+}
+
+func opcuaCtrl(rx typex.Rhilex, l *lua.LState, cmd string, ctrlCmd any) (string, string) {
+	devUUID := l.ToString(2)
+	Device := rx.GetDevice(devUUID)
+	if Device == nil || Device.Device == nil {
+		return "", "device not exists:" + devUUID
+	}
+	if Device.Type != typex.OPCUA_CLIENT {
+		return "", "not an opcua client:" + devUUID
+	}
+	if Device.Device.Status() != typex.SOURCE_UP {
+		return "", "device down:" + devUUID
+	}
+	args, _ := json.Marshal(ctrlCmd)
+	result, err := Device.Device.OnCtrl([]byte(cmd), args)
+	if err != nil {
+		return "", err.Error()
+	}
+	return string(result), ""
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rhilexlib
+
+import (
+	"encoding/json"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+type s7WriteAddressCmd struct {
+	Address   string `json:"address"`
+	DataType  string `json:"dataType"`
+	DataOrder string `json:"dataOrder,omitempty"`
+	Value     string `json:"value"`
+}
+
+/*
+*
+* 按照点位表的 Tag 写 PLC, 值按照表里面的类型、字节序和权重编码
+* local err = s7:Write("DEVICE_UUID", "speed", "12.5")
+*
+ */
+func S7Write(rx typex.Rhilex) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		return s7Ctrl(rx, l, "WriteToSheetRegisterWithTag", CtrlCmd{
+			Tag:   l.ToString(3),
+			Value: l.ToString(4),
+		})
+	}
+}
+
+/*
+*
+* 直接写地址, 类型支持 BOOL BYTE INT16 UINT16 INT32 UINT32 FLOAT32 STRING, 字节序不填为大端序
+* local err = s7:WriteAddress("DEVICE_UUID", "DB1.DBX0.1", "BOOL", "true")
+*
+ */
+func S7WriteAddress(rx typex.Rhilex) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		return s7Ctrl(rx, l, "WriteToAddress", s7WriteAddressCmd{
+			Address:   l.ToString(3),
+			DataType:  l.ToString(4),
+			Value:     l.ToString(5),
+			DataOrder: l.OptString(6, ""),
+		})
+	}
+}
+
+func s7Ctrl(rx typex.Rhilex, l *lua.LState, cmd string, ctrlCmd any) int {
+	devUUID := l.ToString(2)
+	Device := rx.GetDevice(devUUID)
+	if Device == nil || Device.Device == nil {
+		l.Push(lua.LString("device not exists:" + devUUID))
+		return 1
+	}
+	if Device.Type != typex.SIEMENS_PLC {
+		l.Push(lua.LString("not a siemens plc:" + devUUID))
+		return 1
+	}
+	if Device.Device.Status() != typex.SOURCE_UP {
+		l.Push(lua.LString("device down:" + devUUID))
+		return 1
+	}
+	args, _ := json.Marshal(ctrlCmd)
+	if _, err := Device.Device.OnCtrl([]byte(cmd), args); err != nil {
+		l.Push(lua.LString(err.Error()))
+		return 1
+	}
+	l.Push(lua.LNil)
+	return 1
+}
@@ -25,13 +25,23 @@ func TsUnix(rx typex.Rhilex, uuid string) func(*lua.LState) int {
 
 /*
 *
-* 等待毫秒
+* 等待毫秒, 超出执行预算或者应用停止的时候提前结束
 *
  */
 func Sleep(rx typex.Rhilex, uuid string) func(*lua.LState) int {
 	return func(l *lua.LState) int {
 		ts := l.ToNumber(2)
-		time.Sleep(time.Millisecond * time.Duration(ts))
+		ctx := l.Context()
+		if ctx == nil {
+			time.Sleep(time.Millisecond * time.Duration(ts))
+			return 0
+		}
+		timer := time.NewTimer(time.Millisecond * time.Duration(ts))
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
 		return 0
 	}
 }
@@ -0,0 +1,204 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rhilexlib
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	lua "github.com/hootrhino/gopher-lua"
+	"github.com/hootrhino/rhilex/component/interqueue"
+	"github.com/hootrhino/rhilex/component/interwindow"
+	"github.com/hootrhino/rhilex/component/luaguard"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+)
+
+/*
+*
+* 滚动窗口, 时间单位为毫秒, ts 不传的时候使用当前时间
+* local results, err = window:Tumbling("temp", 60000, value[, ts])
+* 有窗口关闭的时候 results 为聚合结果的数组, 一次可能关闭多个窗口, 否则为 nil
+*
+ */
+func WindowTumbling(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		size := l.ToInt64(3)
+		return windowPush(l, uuid, l.ToString(2), size, size, 4)
+	}
+}
+
+/*
+*
+* 滑动窗口, 每隔 step 毫秒输出一次最近 size 毫秒的聚合结果
+* local results, err = window:Sliding("temp", 60000, 10000, value[, ts])
+*
+ */
+func WindowSliding(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		return windowPush(l, uuid, l.ToString(2), l.ToInt64(3), l.ToInt64(4), 5)
+	}
+}
+
+/*
+*
+* 还没有关闭的窗口的聚合结果
+* local result = window:Current("temp")
+*
+ */
+func WindowCurrent(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		result := interwindow.DefaultWindowStore().Current(uuid, l.ToString(2))
+		if result == nil {
+			l.Push(lua.LNil)
+		} else {
+			l.Push(windowResultToTable(l, result))
+		}
+		return 1
+	}
+}
+
+/*
+*
+* 清空窗口
+* window:Reset("temp")
+*
+ */
+func WindowReset(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		interwindow.DefaultWindowStore().Reset(uuid, l.ToString(2))
+		return 0
+	}
+}
+
+/*
+*
+* 设备不再上报的时候, 窗口到时间以后由定时器关闭, 结果交给 callback;
+* callback 在数据来源资源的工作协程里面执行, 和规则串行
+* window:OnClose("temp", function(result) ... end)
+*
+ */
+func WindowOnClose(rx typex.Rhilex, uuid string) func(*lua.LState) int {
+	return func(l *lua.LState) int {
+		key := l.ToString(2)
+		callback := l.ToFunction(3)
+		if callback == nil {
+			l.Push(lua.LString("window:OnClose callback must be a function"))
+			return 1
+		}
+		resource, ok := typex.RuleResourceFrom(l.Context())
+		if !ok {
+			l.Push(lua.LString("window:OnClose only works in rule actions"))
+			return 1
+		}
+		err := interwindow.DefaultWindowStore().OnFlush(uuid, key, func(results []*interwindow.Result) {
+			call := func() {
+				if luaguard.Disabled(uuid) {
+					return
+				}
+				for _, result := range results {
+					err := luaguard.Run(l, luaguard.RuleBudget(typex.LuaBudget{}), func() error {
+						return l.CallByParam(lua.P{
+							Fn:      callback,
+							NRet:    0,
+							Protect: true,
+						}, windowResultToTable(l, result))
+					})
+					if v, ok := luaguard.AsViolation(err); ok {
+						luaguard.ReportRuleViolation(uuid, v)
+						return
+					}
+					if err != nil {
+						glogger.GLogger.Error("Window OnClose error:", uuid, key, err)
+						return
+					}
+				}
+			}
+			if err := interqueue.PushCall(resource, call); err != nil {
+				glogger.GLogger.Error("Window OnClose error:", uuid, key, err)
+			}
+		})
+		if err != nil {
+			l.Push(lua.LString(err.Error()))
+			return 1
+		}
+		l.Push(lua.LNil)
+		return 1
+	}
+}
+
+// valueIdx 为样本值所在的参数位置, 后面一个是可选的时间戳
+func windowPush(l *lua.LState, uuid, key string, size, step int64, valueIdx int) int {
+	value, err := windowValue(l.Get(valueIdx))
+	if err != nil {
+		l.Push(lua.LNil)
+		l.Push(lua.LString(err.Error()))
+		return 2
+	}
+	ts := time.Now().UnixMilli()
+	if l.GetTop() > valueIdx {
+		ts = l.ToInt64(valueIdx + 1)
+	}
+	results, err := interwindow.DefaultWindowStore().Push(uuid, key, size, step, ts, value)
+	if err != nil {
+		l.Push(lua.LNil)
+		l.Push(lua.LString(err.Error()))
+		return 2
+	}
+	if len(results) == 0 {
+		l.Push(lua.LNil)
+	} else {
+		table := l.NewTable()
+		for _, result := range results {
+			table.Append(windowResultToTable(l, result))
+		}
+		l.Push(table)
+	}
+	l.Push(lua.LNil)
+	return 2
+}
+
+// 数字或者数字字符串
+func windowValue(v lua.LValue) (float64, error) {
+	switch value := v.(type) {
+	case lua.LNumber:
+		return float64(value), nil
+	case lua.LString:
+		f, err := strconv.ParseFloat(string(value), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window value: %s", value)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("invalid window value type: %s", v.Type().String())
+}
+
+func windowResultToTable(l *lua.LState, r *interwindow.Result) *lua.LTable {
+	table := l.NewTable()
+	table.RawSetString("key", lua.LString(r.Key))
+	table.RawSetString("start", lua.LNumber(r.Start))
+	table.RawSetString("end", lua.LNumber(r.End))
+	table.RawSetString("count", lua.LNumber(r.Count))
+	table.RawSetString("sum", lua.LNumber(r.Sum))
+	table.RawSetString("avg", lua.LNumber(r.Avg))
+	table.RawSetString("min", lua.LNumber(r.Min))
+	table.RawSetString("max", lua.LNumber(r.Max))
+	table.RawSetString("stddev", lua.LNumber(r.Stddev))
+	table.RawSetString("first", lua.LNumber(r.First))
+	table.RawSetString("last", lua.LNumber(r.Last))
+	return table
+}
@@ -0,0 +1,349 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package source
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gopcua/opcua/server"
+	"github.com/gopcua/opcua/ua"
+	"github.com/hootrhino/rhilex/component/gatewaytag"
+	"github.com/hootrhino/rhilex/device/opcua"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+// 设备和点位表变化以后重新加载地址空间的周期
+const opcuaServerReloadInterval = 10 * time.Second
+
+type OpcuaServerUser struct {
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type OpcuaServerConfig struct {
+	Host           string            `json:"host" validate:"required" title:"服务地址"`
+	Port           int               `json:"port" validate:"required" title:"服务端口"`
+	NamespaceUri   string            `json:"namespaceUri" title:"命名空间"`
+	SecurityPolicy string            `json:"securityPolicy" title:"安全策略"` // None | Basic256Sha256
+	Certificate    string            `json:"certificate" title:"证书"`      // 为空的时候自动生成
+	PrivateKey     string            `json:"privateKey" title:"私钥"`
+	AllowAnonymous *bool             `json:"allowAnonymous" title:"允许匿名"`
+	Users          []OpcuaServerUser `json:"users" title:"用户"`
+	Devices        []string          `json:"devices" title:"设备"`     // 导出的设备, 为空的时候导出全部设备
+	Frequency      int               `json:"frequency" title:"刷新周期"` // 毫秒, 检查数据变化通知订阅者
+}
+
+/*
+*
+* OPC UA 服务端, 把网关上面的设备和点位表导出成地址空间, 供 SCADA 直接浏览和订阅
+*
+ */
+type opcuaServer struct {
+	typex.XStatus
+	mainConfig   OpcuaServerConfig
+	status       typex.SourceState
+	server       *server.Server
+	addressSpace *opcua.AddressSpace
+}
+
+func NewOpcuaServer(e typex.Rhilex) typex.XSource {
+	ous := &opcuaServer{
+		mainConfig: OpcuaServerConfig{
+			Host:           "0.0.0.0",
+			Port:           4840,
+			NamespaceUri:   "urn:rhilex:gateway",
+			SecurityPolicy: opcua.POLICY_NONE,
+			AllowAnonymous: func() *bool {
+				b := true
+				return &b
+			}(),
+			Users:     []OpcuaServerUser{},
+			Devices:   []string{},
+			Frequency: 1000,
+		},
+	}
+	ous.RuleEngine = e
+	return ous
+}
+
+func (ous *opcuaServer) Init(inEndId string, configMap map[string]any) error {
+	ous.PointId = inEndId
+	if err := utils.BindSourceConfig(configMap, &ous.mainConfig); err != nil {
+		return err
+	}
+	if ous.mainConfig.Port <= 0 || ous.mainConfig.Port > 65535 {
+		return fmt.Errorf("invalid port number: %d", ous.mainConfig.Port)
+	}
+	if ous.mainConfig.NamespaceUri == "" {
+		return fmt.Errorf("namespaceUri can not be empty")
+	}
+	if ous.mainConfig.Frequency < 100 {
+		return fmt.Errorf("frequency must greater than 100 millisecond")
+	}
+	switch ous.mainConfig.SecurityPolicy {
+	case opcua.POLICY_NONE:
+		// 用户名密码只在加密的端点上面提供, 否则密码明文传输
+		if len(ous.mainConfig.Users) > 0 {
+			return fmt.Errorf("users require securityPolicy %s", opcua.POLICY_BASIC256SHA256)
+		}
+	case opcua.POLICY_BASIC256SHA256:
+	default:
+		return fmt.Errorf("unsupported security policy: %s", ous.mainConfig.SecurityPolicy)
+	}
+	for _, user := range ous.mainConfig.Users {
+		if user.Username == "" || user.Password == "" {
+			return fmt.Errorf("username and password can not be empty")
+		}
+	}
+	if !*ous.mainConfig.AllowAnonymous && len(ous.mainConfig.Users) == 0 {
+		return fmt.Errorf("users can not be empty when anonymous is disabled")
+	}
+	return nil
+}
+
+func (ous *opcuaServer) Start(cctx typex.CCTX) error {
+	ous.Ctx = cctx.Ctx
+	ous.CancelCTX = cctx.CancelCTX
+	opts, key, err := ous.serverOptions()
+	if err != nil {
+		return err
+	}
+	srv := server.New(opts...)
+	users := map[string]string{}
+	for _, user := range ous.mainConfig.Users {
+		users[user.Username] = user.Password
+	}
+	if err := opcua.RegisterServerAuth(srv, opcua.ServerAuth{
+		AllowAnonymous: *ous.mainConfig.AllowAnonymous,
+		Users:          users,
+		PrivateKey:     key,
+	}); err != nil {
+		return err
+	}
+	addressSpace := opcua.NewAddressSpace(srv, ous.mainConfig.NamespaceUri, ous.readTag, ous.writeTag)
+	addressSpace.Update(ous.loadObjects())
+	if err := srv.Start(ous.Ctx); err != nil {
+		return err
+	}
+	ous.server = srv
+	ous.addressSpace = addressSpace
+	go ous.serve(addressSpace)
+	ous.status = typex.SOURCE_UP
+	glogger.GLogger.Infof("OPC UA server started on [%v]:%v", ous.mainConfig.Host, ous.mainConfig.Port)
+	return nil
+}
+
+/*
+*
+* 端点和安全配置: 允许匿名的时候提供 None 端点, 加密策略提供 Sign 和 SignAndEncrypt 两个端点
+*
+ */
+func (ous *opcuaServer) serverOptions() ([]server.Option, *rsa.PrivateKey, error) {
+	opts := []server.Option{
+		server.ServerName("rhilex"),
+		server.ManufacturerName("hootrhino"),
+		server.ProductName("rhilex OPC UA Server"),
+		server.SoftwareVersion(typex.MainVersion),
+		server.EndPoint(ous.mainConfig.Host, ous.mainConfig.Port),
+	}
+	// 监听所有地址的时候, 端点里面再带上主机名, 客户端按照端点地址连接
+	if ip := net.ParseIP(ous.mainConfig.Host); ip != nil && ip.IsUnspecified() {
+		if hostname, err := os.Hostname(); err == nil && hostname != "" {
+			opts = append(opts, server.EndPoint(hostname, ous.mainConfig.Port))
+		}
+	}
+	if *ous.mainConfig.AllowAnonymous {
+		opts = append(opts,
+			server.EnableSecurity(opcua.POLICY_NONE, ua.MessageSecurityModeNone),
+			server.EnableAuthMode(ua.UserTokenTypeAnonymous),
+		)
+	}
+	if ous.mainConfig.SecurityPolicy == opcua.POLICY_NONE {
+		return opts, nil, nil
+	}
+	var cert []byte
+	var key *rsa.PrivateKey
+	var err error
+	if ous.mainConfig.Certificate != "" && ous.mainConfig.PrivateKey != "" {
+		cert, key, err = opcua.LoadKeyPair(ous.mainConfig.Certificate, ous.mainConfig.PrivateKey)
+	} else {
+		cert, key, err = opcua.DefaultServerKeyPair()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	opts = append(opts,
+		server.Certificate(cert),
+		server.PrivateKey(key),
+		server.EnableSecurity(opcua.POLICY_BASIC256SHA256, ua.MessageSecurityModeSign),
+		server.EnableSecurity(opcua.POLICY_BASIC256SHA256, ua.MessageSecurityModeSignAndEncrypt),
+	)
+	if len(ous.mainConfig.Users) > 0 {
+		opts = append(opts, server.EnableAuthMode(ua.UserTokenTypeUserName))
+	}
+	return opts, key, nil
+}
+
+func (ous *opcuaServer) serve(addressSpace *opcua.AddressSpace) {
+	ticker := time.NewTicker(time.Duration(ous.mainConfig.Frequency) * time.Millisecond)
+	defer ticker.Stop()
+	reload := time.NewTicker(opcuaServerReloadInterval)
+	defer reload.Stop()
+	for {
+		select {
+		case <-ous.Ctx.Done():
+			return
+		case <-reload.C:
+			addressSpace.Update(ous.loadObjects())
+		case <-ticker.C:
+			addressSpace.Notify()
+		}
+	}
+}
+
+/*
+*
+* 按照设备的点位表生成地址空间, 设备按照名字排序
+*
+ */
+func (ous *opcuaServer) loadObjects() []opcua.ServerObject {
+	objects := []opcua.ServerObject{}
+	for _, device := range ous.RuleEngine.AllDevices() {
+		if len(ous.mainConfig.Devices) > 0 && !utils.SContains(ous.mainConfig.Devices, device.UUID) {
+			continue
+		}
+		tags, err := loadOpcuaServerTags(device)
+		if err != nil {
+			glogger.GLogger.Error("OPC UA server load device tags error:", device.UUID, err)
+			continue
+		}
+		if len(tags) == 0 {
+			continue
+		}
+		objects = append(objects, opcua.ServerObject{
+			Id:          device.UUID,
+			Name:        device.Name,
+			Description: device.Description,
+			Tags:        tags,
+		})
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Name < objects[j].Name
+	})
+	return objects
+}
+
+// 点位转成地址空间里面的变量, 数据类型换成 OPC UA 的类型
+func loadOpcuaServerTags(device *typex.Device) ([]opcua.ServerTag, error) {
+	list, err := gatewaytag.Load(device)
+	if err != nil {
+		return nil, err
+	}
+	tags := []opcua.ServerTag{}
+	for _, tag := range list {
+		tags = append(tags, opcua.ServerTag{
+			Key:         tag.Key,
+			Name:        tag.Name,
+			DataType:    opcuaServerDataType(tag.DataType),
+			Writable:    tag.Writable,
+			Description: tag.Description,
+		})
+	}
+	return tags, nil
+}
+
+// 点位表和物模型里面的数据类型转成 OPC UA 的数据类型, 数值带权重以后可能是小数, 统一用 Double
+func opcuaServerDataType(dataType string) string {
+	switch strings.ToUpper(dataType) {
+	case "BOOL", "I", "Q":
+		return "Boolean"
+	case "INTEGER":
+		return "Int64"
+	case "BYTE", "SHORT", "USHORT", "INT16", "UINT16", "INT", "UINT", "INT32", "UINT32",
+		"LONG", "ULONG", "INT64", "UINT64", "FLOAT", "FLOAT32", "UFLOAT32", "FLOAT64", "DOUBLE":
+		return "Double"
+	}
+	return "String"
+}
+
+/*
+*
+* 从缓存里面取设备最新的数据, 设备没有采集成功的时候返回对应的状态码
+*
+ */
+func (ous *opcuaServer) readTag(object opcua.ServerObject, tag opcua.ServerTag) opcua.ServerTagValue {
+	cache := gatewaytag.Read(object.Id, gatewaytag.Tag{Key: tag.Key, Name: tag.Name})
+	value := opcua.ServerTagValue{
+		Value:     cache.Value,
+		Status:    ua.StatusOK,
+		Timestamp: cache.Timestamp,
+	}
+	switch {
+	case cache.Offline():
+		// 设备停止以后缓存被清空
+		value.Status = ua.StatusBadNoCommunication
+	case cache.Status == 1:
+	case cache.ErrMsg == "--":
+		value.Status = ua.StatusBadWaitingForInitialData
+	case cache.ErrMsg != "":
+		value.Status = ua.StatusBadCommunicationError
+	}
+	return value
+}
+
+/*
+*
+* 写点位交给设备的 OnCtrl, 按照 Tag 写
+*
+ */
+func (ous *opcuaServer) writeTag(object opcua.ServerObject, tag opcua.ServerTag, value string) error {
+	if err := gatewaytag.Write(ous.RuleEngine, object.Id, gatewaytag.Tag{
+		Key:      tag.Key,
+		Name:     tag.Name,
+		Writable: tag.Writable,
+	}, value); err != nil {
+		glogger.GLogger.Error("OPC UA server write error:", object.Id, tag.Name, err)
+		return err
+	}
+	return nil
+}
+
+func (ous *opcuaServer) Details() *typex.InEnd {
+	return ous.RuleEngine.GetInEnd(ous.PointId)
+}
+
+func (ous *opcuaServer) Status() typex.SourceState {
+	return ous.status
+}
+
+func (ous *opcuaServer) Stop() {
+	ous.status = typex.SOURCE_DOWN
+	if ous.CancelCTX != nil {
+		ous.CancelCTX()
+	}
+	if ous.server != nil {
+		ous.server.Close()
+		ous.server = nil
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package source
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/resconfig"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+)
+
+// snmpTrapOID.0, v2c 和 v3 的 Trap 第二个变量绑定为 Trap 的 OID
+const snmpTrapOid = ".1.3.6.1.6.3.1.1.4.1.0"
+
+type SnmpTrapConfig struct {
+	Host      string                      `json:"host" validate:"required" title:"服务地址"`
+	Port      int                         `json:"port" validate:"required" title:"服务端口"`
+	Community string                      `json:"community" title:"团体名"`  // v1 和 v2c, 为空的时候不接收 v1 和 v2c
+	Users     []resconfig.SnmpV3UsmConfig `json:"users" title:"v3用户"`     // v3, 为空的时候不接收 v3
+	EngineId  string                      `json:"engineId" title:"引擎ID"`  // 十六进制, v3 Inform 需要
+	OidNames  map[string]string           `json:"oidNames" title:"OID名称"` // OID 对应的名称, 上报的时候带上
+}
+
+// 上报的数据
+type SnmpTrapEvent struct {
+	Source    string            `json:"source"`
+	Version   string            `json:"version"` // 1 | 2c | 3
+	Community string            `json:"community,omitempty"`
+	User      string            `json:"user,omitempty"`
+	PduType   string            `json:"pduType"` // Trap | Inform
+	TrapOid   string            `json:"trapOid"`
+	Uptime    uint32            `json:"uptime"`
+	Varbinds  []SnmpTrapVarbind `json:"varbinds"`
+}
+
+type SnmpTrapVarbind struct {
+	Oid   string `json:"oid"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+type snmpTrapSource struct {
+	typex.XStatus
+	mainConfig SnmpTrapConfig
+	status     typex.SourceState
+	listener   *gosnmp.TrapListener
+	users      map[string]resconfig.SnmpV3UsmConfig
+}
+
+func NewSnmpTrapSource(e typex.Rhilex) typex.XSource {
+	sts := &snmpTrapSource{
+		mainConfig: SnmpTrapConfig{
+			Host:      "0.0.0.0",
+			Port:      162,
+			Community: "public",
+			Users:     []resconfig.SnmpV3UsmConfig{},
+			OidNames:  map[string]string{},
+		},
+		users: map[string]resconfig.SnmpV3UsmConfig{},
+	}
+	sts.RuleEngine = e
+	return sts
+}
+
+func (sts *snmpTrapSource) Init(inEndId string, configMap map[string]any) error {
+	sts.PointId = inEndId
+	if err := utils.BindSourceConfig(configMap, &sts.mainConfig); err != nil {
+		return err
+	}
+	if sts.mainConfig.Port <= 0 || sts.mainConfig.Port > 65535 {
+		return fmt.Errorf("invalid port number: %d", sts.mainConfig.Port)
+	}
+	if net.ParseIP(sts.mainConfig.Host) == nil {
+		return fmt.Errorf("invalid host address: %s", sts.mainConfig.Host)
+	}
+	if sts.mainConfig.EngineId != "" {
+		engineId, err := hex.DecodeString(sts.mainConfig.EngineId)
+		if err != nil || len(engineId) < 5 || len(engineId) > 32 {
+			return fmt.Errorf("engineId must be 5-32 bytes hex string")
+		}
+	}
+	for _, user := range sts.mainConfig.Users {
+		if err := user.Validate(); err != nil {
+			return err
+		}
+		sts.users[user.UserName] = user
+	}
+	if sts.mainConfig.Community == "" && len(sts.users) == 0 {
+		return fmt.Errorf("community and users cannot be both empty")
+	}
+	return nil
+}
+
+func (sts *snmpTrapSource) Start(cctx typex.CCTX) error {
+	sts.Ctx = cctx.Ctx
+	sts.CancelCTX = cctx.CancelCTX
+	params := &gosnmp.GoSNMP{
+		Version: gosnmp.Version2c,
+		Timeout: 5 * time.Second,
+	}
+	if len(sts.users) > 0 {
+		table := gosnmp.NewSnmpV3SecurityParametersTable(params.Logger)
+		for _, user := range sts.users {
+			_, usm := utils.SnmpUsmParameters(user)
+			if err := table.Add(user.UserName, usm); err != nil {
+				return err
+			}
+		}
+		params.TrapSecurityParametersTable = table
+		// 只有 Version3 才会做认证校验, v1/v2c 的报文按照报文自己的版本解码
+		params.Version = gosnmp.Version3
+		if sts.mainConfig.EngineId != "" {
+			// Inform 的接收方是权威引擎, 发送方需要先发现引擎ID
+			engineId, _ := hex.DecodeString(sts.mainConfig.EngineId)
+			params.SecurityModel = gosnmp.UserSecurityModel
+			params.SecurityParameters = &gosnmp.UsmSecurityParameters{
+				AuthoritativeEngineID: string(engineId),
+			}
+		}
+	}
+	listener := gosnmp.NewTrapListener()
+	listener.Params = params
+	listener.OnNewTrap = sts.onTrap
+	errCh := make(chan error, 1)
+	address := net.JoinHostPort(sts.mainConfig.Host, fmt.Sprintf("%d", sts.mainConfig.Port))
+	go func() {
+		errCh <- listener.Listen(address)
+	}()
+	select {
+	case <-listener.Listening():
+	case err := <-errCh:
+		return err
+	case <-time.After(3 * time.Second):
+		listener.Close()
+		return fmt.Errorf("snmp trap listener start timeout")
+	}
+	sts.listener = listener
+	go func() {
+		// 监听意外退出的时候设备交给监控器重启
+		if err := <-errCh; err != nil {
+			glogger.GLogger.Error("SNMP trap listener exited:", err)
+		}
+		if sts.listener == listener {
+			sts.status = typex.SOURCE_DOWN
+		}
+	}()
+	sts.status = typex.SOURCE_UP
+	glogger.GLogger.Infof("SNMP trap server started on [%v]:%v", sts.mainConfig.Host, sts.mainConfig.Port)
+	return nil
+}
+
+func (sts *snmpTrapSource) onTrap(packet *gosnmp.SnmpPacket, addr *net.UDPAddr) {
+	event, err := sts.decodeTrap(packet, addr)
+	if err != nil {
+		glogger.GLogger.Warn("SNMP trap rejected:", addr.String(), err)
+		return
+	}
+	bytes, err := json.Marshal(event)
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return
+	}
+	if _, err := sts.RuleEngine.WorkInEnd(sts.Details(), string(bytes)); err != nil {
+		glogger.GLogger.Error(err)
+	}
+}
+
+/*
+*
+* 检查团体名或者 v3 用户, 然后把变量绑定按照 OID 解出来
+*
+ */
+func (sts *snmpTrapSource) decodeTrap(packet *gosnmp.SnmpPacket, addr *net.UDPAddr) (SnmpTrapEvent, error) {
+	event := SnmpTrapEvent{
+		Source:   addr.String(),
+		PduType:  "Trap",
+		Varbinds: []SnmpTrapVarbind{},
+	}
+	if packet.PDUType == gosnmp.InformRequest {
+		event.PduType = "Inform"
+	}
+	switch packet.Version {
+	case gosnmp.Version1, gosnmp.Version2c:
+		if sts.mainConfig.Community == "" || packet.Community != sts.mainConfig.Community {
+			return event, fmt.Errorf("invalid community: %s", packet.Community)
+		}
+		event.Version = packet.Version.String()
+		event.Community = packet.Community
+	case gosnmp.Version3:
+		usm, ok := packet.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+		if !ok {
+			return event, fmt.Errorf("unsupported security model")
+		}
+		user, ok := sts.users[usm.UserName]
+		if !ok {
+			return event, fmt.Errorf("unknown user: %s", usm.UserName)
+		}
+		// 认证和加密由 gosnmp 检查, 这里防止低于配置的安全级别的报文通过
+		flags, _ := utils.SnmpUsmParameters(user)
+		if packet.MsgFlags&gosnmp.AuthPriv < flags&gosnmp.AuthPriv {
+			return event, fmt.Errorf("security level too low for user: %s", usm.UserName)
+		}
+		event.Version = packet.Version.String()
+		event.User = usm.UserName
+	default:
+		return event, fmt.Errorf("unsupported version: %v", packet.Version)
+	}
+	if packet.Version == gosnmp.Version1 {
+		// v1 的 Trap OID 由企业号和特定 Trap 号组成, 通用 Trap 映射到 snmpTraps
+		if packet.GenericTrap == 6 {
+			event.TrapOid = fmt.Sprintf("%s.0.%d", packet.Enterprise, packet.SpecificTrap)
+		} else {
+			event.TrapOid = fmt.Sprintf(".1.3.6.1.6.3.1.1.5.%d", packet.GenericTrap+1)
+		}
+		event.Uptime = uint32(packet.Timestamp)
+	}
+	for _, variable := range packet.Variables {
+		oid := normalizeOid(variable.Name)
+		switch oid {
+		case snmpTrapOid:
+			event.TrapOid = fmt.Sprintf("%v", utils.SnmpPDUValue(variable))
+			continue
+		case ".1.3.6.1.2.1.1.3.0": // sysUpTime.0
+			event.Uptime = uint32(gosnmp.ToBigInt(variable.Value).Uint64())
+			continue
+		}
+		event.Varbinds = append(event.Varbinds, SnmpTrapVarbind{
+			Oid:   oid,
+			Name:  sts.oidName(oid),
+			Type:  variable.Type.String(),
+			Value: utils.SnmpPDUValue(variable),
+		})
+	}
+	return event, nil
+}
+
+// OID 名称, 先精确匹配, 再按照前缀匹配, 比如表格里面带索引的 OID
+func (sts *snmpTrapSource) oidName(oid string) string {
+	if name, ok := sts.mainConfig.OidNames[oid]; ok {
+		return name
+	}
+	best := ""
+	name := ""
+	for prefix, n := range sts.mainConfig.OidNames {
+		p := normalizeOid(prefix)
+		if strings.HasPrefix(oid, p+".") && len(p) > len(best) {
+			best, name = p, n
+		}
+	}
+	return name
+}
+
+func normalizeOid(oid string) string {
+	if !strings.HasPrefix(oid, ".") {
+		return "." + oid
+	}
+	return oid
+}
+
+func (sts *snmpTrapSource) Details() *typex.InEnd {
+	return sts.RuleEngine.GetInEnd(sts.PointId)
+}
+
+func (sts *snmpTrapSource) Status() typex.SourceState {
+	return sts.status
+}
+
+func (sts *snmpTrapSource) Stop() {
+	sts.status = typex.SOURCE_DOWN
+	if sts.CancelCTX != nil {
+		sts.CancelCTX()
+	}
+	if sts.listener != nil {
+		sts.listener.Close()
+		sts.listener = nil
+	}
+}
@@ -0,0 +1,86 @@
+package source
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+func newTestTrapSource(t *testing.T) *snmpTrapSource {
+	sts := NewSnmpTrapSource(nil).(*snmpTrapSource)
+	err := sts.Init("TEST", map[string]any{
+		"host":      "127.0.0.1",
+		"port":      1162,
+		"community": "public",
+		"users": []map[string]any{{
+			"userName":       "ups",
+			"securityLevel":  "AuthPriv",
+			"authProtocol":   "SHA256",
+			"authPassphrase": "authpass123",
+			"privProtocol":   "AES",
+			"privPassphrase": "privpass123",
+		}},
+		"oidNames": map[string]string{".1.3.6.1.4.1.318.1.1.1.2.2.1": "batteryCapacity"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sts
+}
+
+func Test_SnmpTrap_Decode(t *testing.T) {
+	sts := newTestTrapSource(t)
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 161}
+	packet := &gosnmp.SnmpPacket{
+		Version:   gosnmp.Version2c,
+		Community: "public",
+		PDUType:   gosnmp.SNMPv2Trap,
+		Variables: []gosnmp.SnmpPDU{
+			{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(1234)},
+			{Name: ".1.3.6.1.6.3.1.1.4.1.0", Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.4.1.318.0.5"},
+			{Name: "1.3.6.1.4.1.318.1.1.1.2.2.1.0", Type: gosnmp.Gauge32, Value: uint(87)},
+		},
+	}
+	event, err := sts.decodeTrap(packet, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.TrapOid != ".1.3.6.1.4.1.318.0.5" || event.Uptime != 1234 || len(event.Varbinds) != 1 {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	if v := event.Varbinds[0]; v.Name != "batteryCapacity" || v.Value != uint64(87) {
+		t.Fatalf("unexpected varbind: %+v", v)
+	}
+	packet.Community = "private"
+	if _, err := sts.decodeTrap(packet, addr); err == nil {
+		t.Fatal("wrong community must be rejected")
+	}
+}
+
+func Test_SnmpTrap_V3User(t *testing.T) {
+	sts := newTestTrapSource(t)
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 161}
+	packet := &gosnmp.SnmpPacket{
+		Version:            gosnmp.Version3,
+		MsgFlags:           gosnmp.AuthPriv,
+		SecurityModel:      gosnmp.UserSecurityModel,
+		SecurityParameters: &gosnmp.UsmSecurityParameters{UserName: "ups"},
+		PDUType:            gosnmp.InformRequest,
+	}
+	event, err := sts.decodeTrap(packet, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.User != "ups" || event.PduType != "Inform" || event.Version != "3" {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	packet.MsgFlags = gosnmp.AuthNoPriv
+	if _, err := sts.decodeTrap(packet, addr); err == nil {
+		t.Fatal("lower security level must be rejected")
+	}
+	packet.SecurityParameters = &gosnmp.UsmSecurityParameters{UserName: "guest"}
+	if _, err := sts.decodeTrap(packet, addr); err == nil {
+		t.Fatal("unknown user must be rejected")
+	}
+}
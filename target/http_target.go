@@ -139,6 +139,7 @@ func (ht *HTTPTarget) To(data any) (any, error) {
 			}
 			return nil, err
 		}
+		return nil, nil
 	}
 	return nil, fmt.Errorf("data type must string!")
 }
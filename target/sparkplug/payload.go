@@ -0,0 +1,480 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sparkplug
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Sparkplug B 的数据类型
+type DataType uint32
+
+const (
+	Unknown  DataType = 0
+	Int8     DataType = 1
+	Int16    DataType = 2
+	Int32    DataType = 3
+	Int64    DataType = 4
+	UInt8    DataType = 5
+	UInt16   DataType = 6
+	UInt32   DataType = 7
+	UInt64   DataType = 8
+	Float    DataType = 9
+	Double   DataType = 10
+	Boolean  DataType = 11
+	String   DataType = 12
+	DateTime DataType = 13
+	Text     DataType = 14
+	Bytes    DataType = 17
+)
+
+func (t DataType) String() string {
+	switch t {
+	case Int8:
+		return "Int8"
+	case Int16:
+		return "Int16"
+	case Int32:
+		return "Int32"
+	case Int64:
+		return "Int64"
+	case UInt8:
+		return "UInt8"
+	case UInt16:
+		return "UInt16"
+	case UInt32:
+		return "UInt32"
+	case UInt64:
+		return "UInt64"
+	case Float:
+		return "Float"
+	case Double:
+		return "Double"
+	case Boolean:
+		return "Boolean"
+	case String:
+		return "String"
+	case DateTime:
+		return "DateTime"
+	case Text:
+		return "Text"
+	case Bytes:
+		return "Bytes"
+	}
+	return "Unknown"
+}
+
+/*
+*
+* 一个指标, Value 按照 DataType 存放: 整数是 int64 或者 uint64, 小数是 float32 或者 float64,
+* 布尔是 bool, 字符串是 string, 字节是 []byte; IsNull 的时候忽略 Value
+*
+ */
+type Metric struct {
+	Name      string
+	Alias     uint64
+	HasAlias  bool
+	Timestamp uint64
+	DataType  DataType
+	IsNull    bool
+	Value     any
+}
+
+// 一帧 Sparkplug B 报文
+type Payload struct {
+	Timestamp uint64
+	Metrics   []Metric
+	Seq       uint64
+	HasSeq    bool
+}
+
+// Payload 字段编号
+const (
+	payloadTimestamp protowire.Number = 1
+	payloadMetrics   protowire.Number = 2
+	payloadSeq       protowire.Number = 3
+)
+
+// Metric 字段编号
+const (
+	metricName        protowire.Number = 1
+	metricAlias       protowire.Number = 2
+	metricTimestamp   protowire.Number = 3
+	metricDataType    protowire.Number = 4
+	metricIsNull      protowire.Number = 7
+	metricIntValue    protowire.Number = 10
+	metricLongValue   protowire.Number = 11
+	metricFloatValue  protowire.Number = 12
+	metricDoubleValue protowire.Number = 13
+	metricBoolValue   protowire.Number = 14
+	metricStringValue protowire.Number = 15
+	metricBytesValue  protowire.Number = 16
+)
+
+/*
+*
+* 编码成 protobuf
+*
+ */
+func (p *Payload) Encode() ([]byte, error) {
+	b := []byte{}
+	b = protowire.AppendTag(b, payloadTimestamp, protowire.VarintType)
+	b = protowire.AppendVarint(b, p.Timestamp)
+	for _, metric := range p.Metrics {
+		mb, err := metric.encode()
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, payloadMetrics, protowire.BytesType)
+		b = protowire.AppendBytes(b, mb)
+	}
+	if p.HasSeq {
+		b = protowire.AppendTag(b, payloadSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, p.Seq)
+	}
+	return b, nil
+}
+
+func (m Metric) encode() ([]byte, error) {
+	b := []byte{}
+	if m.Name != "" {
+		b = protowire.AppendTag(b, metricName, protowire.BytesType)
+		b = protowire.AppendString(b, m.Name)
+	}
+	if m.HasAlias {
+		b = protowire.AppendTag(b, metricAlias, protowire.VarintType)
+		b = protowire.AppendVarint(b, m.Alias)
+	}
+	if m.Timestamp > 0 {
+		b = protowire.AppendTag(b, metricTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, m.Timestamp)
+	}
+	if m.DataType != Unknown {
+		b = protowire.AppendTag(b, metricDataType, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(m.DataType))
+	}
+	if m.IsNull {
+		b = protowire.AppendTag(b, metricIsNull, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+		return b, nil
+	}
+	if m.Value == nil {
+		return b, nil
+	}
+	switch m.DataType {
+	case Int8, Int16, Int32, UInt8, UInt16, UInt32:
+		v, err := toInt64(m.Value)
+		if err != nil {
+			return nil, fmt.Errorf("metric %s: %w", m.Name, err)
+		}
+		// 有符号数按照补码存进 uint32
+		b = protowire.AppendTag(b, metricIntValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(uint32(v)))
+	case Int64, UInt64, DateTime:
+		v, err := toInt64(m.Value)
+		if err != nil {
+			return nil, fmt.Errorf("metric %s: %w", m.Name, err)
+		}
+		b = protowire.AppendTag(b, metricLongValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v))
+	case Float:
+		v, err := toFloat64(m.Value)
+		if err != nil {
+			return nil, fmt.Errorf("metric %s: %w", m.Name, err)
+		}
+		b = protowire.AppendTag(b, metricFloatValue, protowire.Fixed32Type)
+		b = protowire.AppendFixed32(b, math.Float32bits(float32(v)))
+	case Double:
+		v, err := toFloat64(m.Value)
+		if err != nil {
+			return nil, fmt.Errorf("metric %s: %w", m.Name, err)
+		}
+		b = protowire.AppendTag(b, metricDoubleValue, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	case Boolean:
+		v, ok := m.Value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("metric %s: invalid boolean value: %v", m.Name, m.Value)
+		}
+		b = protowire.AppendTag(b, metricBoolValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+	case String, Text:
+		b = protowire.AppendTag(b, metricStringValue, protowire.BytesType)
+		b = protowire.AppendString(b, fmt.Sprintf("%v", m.Value))
+	case Bytes:
+		v, ok := m.Value.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("metric %s: invalid bytes value", m.Name)
+		}
+		b = protowire.AppendTag(b, metricBytesValue, protowire.BytesType)
+		b = protowire.AppendBytes(b, v)
+	default:
+		return nil, fmt.Errorf("metric %s: unsupported data type: %d", m.Name, m.DataType)
+	}
+	return b, nil
+}
+
+/*
+*
+* 解析 protobuf, 不认识的字段直接跳过
+*
+ */
+func Decode(b []byte) (*Payload, error) {
+	p := &Payload{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == payloadTimestamp && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			p.Timestamp = v
+			b = b[n:]
+		case num == payloadMetrics && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			metric, err := decodeMetric(v)
+			if err != nil {
+				return nil, err
+			}
+			p.Metrics = append(p.Metrics, metric)
+			b = b[n:]
+		case num == payloadSeq && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			p.Seq = v
+			p.HasSeq = true
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return p, nil
+}
+
+func decodeMetric(b []byte) (Metric, error) {
+	m := Metric{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return m, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case metricAlias:
+				m.Alias = v
+				m.HasAlias = true
+			case metricTimestamp:
+				m.Timestamp = v
+			case metricDataType:
+				m.DataType = DataType(v)
+			case metricIsNull:
+				m.IsNull = v != 0
+			case metricIntValue:
+				m.Value = uint64(uint32(v))
+			case metricLongValue:
+				m.Value = v
+			case metricBoolValue:
+				m.Value = v != 0
+			}
+		case protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			b = b[n:]
+			if num == metricFloatValue {
+				m.Value = float64(math.Float32frombits(v))
+			}
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			b = b[n:]
+			if num == metricDoubleValue {
+				m.Value = math.Float64frombits(v)
+			}
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case metricName:
+				m.Name = string(v)
+			case metricStringValue:
+				m.Value = string(v)
+			case metricBytesValue:
+				m.Value = append([]byte{}, v...)
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	m.Value = m.normalize()
+	return m, nil
+}
+
+// 解析出来的整数按照数据类型还原符号
+func (m Metric) normalize() any {
+	v, ok := m.Value.(uint64)
+	if !ok {
+		return m.Value
+	}
+	switch m.DataType {
+	case Int8:
+		return int64(int8(v))
+	case Int16:
+		return int64(int16(v))
+	case Int32:
+		return int64(int32(v))
+	case Int64, DateTime:
+		return int64(v)
+	}
+	return v
+}
+
+/*
+*
+* 指标的值转成字符串, 写设备的时候用
+*
+ */
+func (m Metric) StringValue() string {
+	switch v := m.Value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprintf("%v", m.Value)
+}
+
+/*
+*
+* 采集到的字符串值按照数据类型转换
+*
+ */
+func ParseValue(dataType DataType, value string) (any, error) {
+	value = strings.TrimSpace(value)
+	switch dataType {
+	case Int8, Int16, Int32, Int64, UInt8, UInt16, UInt32, UInt64, DateTime:
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return v, nil
+		}
+		// 带权重的整数可能是小数
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		return int64(v), nil
+	case Float, Double:
+		return strconv.ParseFloat(value, 64)
+	case Boolean:
+		switch strings.ToLower(value) {
+		case "1", "true", "on":
+			return true, nil
+		case "0", "false", "off":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid boolean value: %s", value)
+	case Bytes:
+		return []byte(value), nil
+	}
+	return value, nil
+}
+
+func toInt64(value any) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		return int64(v), nil
+	case float32:
+		return int64(v), nil
+	case float64:
+		return int64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, errors.New("invalid integer value")
+}
+
+func toFloat64(value any) (float64, error) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	}
+	i, err := toInt64(value)
+	if err != nil {
+		return 0, errors.New("invalid float value")
+	}
+	return float64(i), nil
+}
@@ -0,0 +1,107 @@
+package sparkplug
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPayloadRoundTrip(t *testing.T) {
+	p := &Payload{
+		Timestamp: 1700000000000,
+		Seq:       7,
+		HasSeq:    true,
+		Metrics: []Metric{
+			{Name: MetricBdSeq, DataType: Int64, Value: int64(3)},
+			{Name: "temp", Alias: 1, HasAlias: true, DataType: Double, Value: 21.5},
+			{Name: "level", Alias: 2, HasAlias: true, DataType: Int16, Value: int64(-12)},
+			{Name: "ratio", Alias: 3, HasAlias: true, DataType: Float, Value: 0.5},
+			{Name: "run", Alias: 4, HasAlias: true, DataType: Boolean, Value: true},
+			{Name: "name", Alias: 5, HasAlias: true, DataType: String, Value: "pump"},
+			{Name: "raw", Alias: 6, HasAlias: true, DataType: Bytes, Value: []byte{1, 2}},
+			{Name: "lost", Alias: 7, HasAlias: true, DataType: Double, IsNull: true},
+		},
+	}
+	b, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Timestamp != p.Timestamp || !d.HasSeq || d.Seq != 7 || len(d.Metrics) != len(p.Metrics) {
+		t.Fatalf("unexpected payload: %+v", d)
+	}
+	if v := d.Metrics[0].Value; v != int64(3) {
+		t.Fatalf("bdSeq: %v", v)
+	}
+	if v := d.Metrics[1].Value; v != 21.5 {
+		t.Fatalf("double: %v", v)
+	}
+	if v := d.Metrics[2].Value; v != int64(-12) {
+		t.Fatalf("int16: %v", v)
+	}
+	if v := d.Metrics[3].Value; v != 0.5 {
+		t.Fatalf("float: %v", v)
+	}
+	if v := d.Metrics[4].Value; v != true {
+		t.Fatalf("boolean: %v", v)
+	}
+	if v := d.Metrics[5].StringValue(); v != "pump" {
+		t.Fatalf("string: %v", v)
+	}
+	if v := d.Metrics[6].Value.([]byte); !bytes.Equal(v, []byte{1, 2}) {
+		t.Fatalf("bytes: %v", v)
+	}
+	if !d.Metrics[7].IsNull || d.Metrics[7].Alias != 7 {
+		t.Fatalf("null: %+v", d.Metrics[7])
+	}
+}
+
+func TestPayloadEncodeInvalidValue(t *testing.T) {
+	p := &Payload{Metrics: []Metric{{Name: "run", DataType: Boolean, Value: "yes"}}}
+	if _, err := p.Encode(); err == nil {
+		t.Fatal("expect error")
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	if v, err := ParseValue(Int64, "12.0"); err != nil || v != int64(12) {
+		t.Fatal(v, err)
+	}
+	if v, err := ParseValue(Boolean, "1"); err != nil || v != true {
+		t.Fatal(v, err)
+	}
+	if _, err := ParseValue(Double, "abc"); err == nil {
+		t.Fatal("expect error")
+	}
+}
+
+func TestTopic(t *testing.T) {
+	topic := DeviceTopic("plant", DCMD, "gw1", "pump")
+	if topic != "spBv1.0/plant/DCMD/gw1/pump" {
+		t.Fatal(topic)
+	}
+	parsed, err := ParseTopic(topic)
+	if err != nil || parsed.DeviceId != "pump" || parsed.MessageType != DCMD {
+		t.Fatal(parsed, err)
+	}
+	if _, err := ParseTopic("spBv1.0/plant"); err == nil {
+		t.Fatal("expect error")
+	}
+	if ValidId("a/b") || !ValidId("gw1") {
+		t.Fatal("valid id")
+	}
+}
+
+func TestSequenceWrap(t *testing.T) {
+	s := &Sequence{}
+	for i := 0; i < 256; i++ {
+		if s.Next() != uint64(i) {
+			t.Fatal("sequence", i)
+		}
+	}
+	if s.Next() != 0 {
+		t.Fatal("sequence should wrap")
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sparkplug
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const Namespace = "spBv1.0"
+
+// 消息类型
+const (
+	NBIRTH = "NBIRTH"
+	NDEATH = "NDEATH"
+	DBIRTH = "DBIRTH"
+	DDEATH = "DDEATH"
+	NDATA  = "NDATA"
+	DDATA  = "DDATA"
+	NCMD   = "NCMD"
+	DCMD   = "DCMD"
+)
+
+// 节点控制指标
+const (
+	MetricBdSeq   = "bdSeq"
+	MetricRebirth = "Node Control/Rebirth"
+)
+
+/*
+*
+* spBv1.0/{group_id}/{message_type}/{edge_node_id}[/{device_id}]
+*
+ */
+type Topic struct {
+	GroupId     string
+	MessageType string
+	EdgeNodeId  string
+	DeviceId    string
+}
+
+func (t Topic) String() string {
+	topic := fmt.Sprintf("%s/%s/%s/%s", Namespace, t.GroupId, t.MessageType, t.EdgeNodeId)
+	if t.DeviceId != "" {
+		topic += "/" + t.DeviceId
+	}
+	return topic
+}
+
+func NodeTopic(groupId, messageType, edgeNodeId string) string {
+	return Topic{GroupId: groupId, MessageType: messageType, EdgeNodeId: edgeNodeId}.String()
+}
+
+func DeviceTopic(groupId, messageType, edgeNodeId, deviceId string) string {
+	return Topic{GroupId: groupId, MessageType: messageType,
+		EdgeNodeId: edgeNodeId, DeviceId: deviceId}.String()
+}
+
+func ParseTopic(topic string) (Topic, error) {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 4 || len(parts) > 5 || parts[0] != Namespace {
+		return Topic{}, fmt.Errorf("invalid sparkplug topic: %s", topic)
+	}
+	t := Topic{GroupId: parts[1], MessageType: parts[2], EdgeNodeId: parts[3]}
+	if len(parts) == 5 {
+		t.DeviceId = parts[4]
+	}
+	return t, nil
+}
+
+// ID 里面不能出现 MQTT 的保留字符
+func ValidId(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "/+#")
+}
+
+/*
+*
+* 报文序号, 0 到 255 循环; NBIRTH 的时候复位成 0
+*
+ */
+type Sequence struct {
+	lock sync.Mutex
+	next uint64
+}
+
+func (s *Sequence) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.next = 0
+}
+
+func (s *Sequence) Next() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	seq := s.next
+	s.next = (s.next + 1) % 256
+	return seq
+}
@@ -0,0 +1,613 @@
+// Copyright (C) 2025 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package target
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hootrhino/rhilex/component/eventbus"
+	"github.com/hootrhino/rhilex/component/gatewaytag"
+	"github.com/hootrhino/rhilex/glogger"
+	"github.com/hootrhino/rhilex/target/sparkplug"
+	"github.com/hootrhino/rhilex/typex"
+	"github.com/hootrhino/rhilex/utils"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+/*
+*
+* Sparkplug B 客户端: 网关是边缘节点, 点位表里面的设备是节点下面的设备
+*
+ */
+type SparkplugBConfig struct {
+	Host       string   `json:"host" validate:"required" title:"服务地址"`
+	Port       int      `json:"port" validate:"required" title:"服务端口"`
+	ClientId   string   `json:"clientId" validate:"required" title:"客户端ID"`
+	Username   string   `json:"username" title:"连接账户"`
+	Password   string   `json:"password" title:"连接密码"`
+	GroupId    string   `json:"groupId" validate:"required" title:"组ID"`
+	EdgeNodeId string   `json:"edgeNodeId" validate:"required" title:"边缘节点ID"`
+	Devices    []string `json:"devices" title:"设备"`         // 上报的设备, 为空的时候上报全部设备
+	Frequency  int64    `json:"frequency" title:"扫描间隔(毫秒)"` // 按照这个间隔检查数据变化, 变化的点位通过 DDATA 上报
+}
+
+type SparkplugBMainConfig struct {
+	SparkplugBConfig `json:"commonConfig" validate:"required"`
+}
+
+// 设备下面的一个指标
+type sparkplugMetric struct {
+	Tag      gatewaytag.Tag
+	Alias    uint64
+	DataType sparkplug.DataType
+}
+
+// 已经 DBIRTH 的设备
+type sparkplugDevice struct {
+	UUID    string
+	Id      string // Sparkplug 里面的 device_id
+	Metrics []sparkplugMetric
+	Last    map[string]string // 上一次上报的值, 按照点位名
+}
+
+// bdSeq 每次建立连接加一, 资源重启以后也要接着加
+var sparkplugBdSeq = map[string]uint64{}
+var sparkplugBdSeqLock sync.Mutex
+
+func nextSparkplugBdSeq(outEndId string) uint64 {
+	sparkplugBdSeqLock.Lock()
+	defer sparkplugBdSeqLock.Unlock()
+	bdSeq := sparkplugBdSeq[outEndId]
+	sparkplugBdSeq[outEndId] = (bdSeq + 1) % 256
+	return bdSeq
+}
+
+type sparkplugBTarget struct {
+	typex.XStatus
+	client     mqtt.Client
+	mainConfig SparkplugBMainConfig
+	status     typex.SourceState
+	lock       sync.Mutex
+	bdSeq      uint64
+	seq        sparkplug.Sequence
+	devices    map[string]*sparkplugDevice // 设备 UUID
+	aliases    map[string]uint64           // 设备 UUID + 点位名, 设备重新上线以后别名保持不变
+	nextAlias  uint64
+	subscriber *eventbus.Subscriber
+}
+
+func NewSparkplugBTarget(e typex.Rhilex) typex.XTarget {
+	spb := new(sparkplugBTarget)
+	spb.RuleEngine = e
+	spb.mainConfig = SparkplugBMainConfig{
+		SparkplugBConfig: SparkplugBConfig{
+			Host:       "127.0.0.1",
+			Port:       1883,
+			ClientId:   "rhilex",
+			Username:   "rhilex",
+			Password:   "rhilex",
+			GroupId:    "rhilex",
+			EdgeNodeId: "rhilex",
+			Devices:    []string{},
+			Frequency:  1000,
+		},
+	}
+	spb.devices = map[string]*sparkplugDevice{}
+	spb.aliases = map[string]uint64{}
+	spb.status = typex.SOURCE_DOWN
+	return spb
+}
+
+func (spb *sparkplugBTarget) Init(outEndId string, configMap map[string]any) error {
+	spb.PointId = outEndId
+	if err := utils.BindSourceConfig(configMap, &spb.mainConfig); err != nil {
+		return err
+	}
+	if !sparkplug.ValidId(spb.mainConfig.GroupId) {
+		return fmt.Errorf("invalid group id: %s", spb.mainConfig.GroupId)
+	}
+	if !sparkplug.ValidId(spb.mainConfig.EdgeNodeId) {
+		return fmt.Errorf("invalid edge node id: %s", spb.mainConfig.EdgeNodeId)
+	}
+	if spb.mainConfig.Frequency < 100 {
+		return errors.New("frequency must greater than 100ms")
+	}
+	return nil
+}
+
+func (spb *sparkplugBTarget) Start(cctx typex.CCTX) error {
+	spb.Ctx = cctx.Ctx
+	spb.CancelCTX = cctx.CancelCTX
+	spb.bdSeq = nextSparkplugBdSeq(spb.PointId)
+	death, err := spb.nodeDeathPayload()
+	if err != nil {
+		return err
+	}
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(fmt.Sprintf("tcp://%s:%v", spb.mainConfig.Host, spb.mainConfig.Port))
+	opts.SetClientID(spb.mainConfig.ClientId)
+	opts.SetUsername(spb.mainConfig.Username)
+	opts.SetPassword(spb.mainConfig.Password)
+	// 连接异常断开的时候由 Broker 发出 NDEATH
+	opts.SetBinaryWill(spb.nodeTopic(sparkplug.NDEATH), death, 1, false)
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		glogger.GLogger.Infof("Sparkplug B Connected Success")
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		glogger.GLogger.Warn("Sparkplug B Connect lost:", err)
+	})
+	opts.SetCleanSession(true)
+	opts.SetAutoReconnect(false)    //不需要自动重连, 交给RHILEX管理
+	opts.SetMaxReconnectInterval(0) // 不需要自动重连, 交给RHILEX管理
+	opts.SetConnectTimeout(5 * time.Second)
+	opts.SetPingTimeout(5 * time.Second)
+	spb.client = mqtt.NewClient(opts)
+	token := spb.client.Connect()
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	// 先订阅命令再出生, 避免错过主机收到 NBIRTH 以后马上下发的命令
+	filters := map[string]byte{
+		spb.nodeTopic(sparkplug.NCMD):        1,
+		spb.deviceTopic(sparkplug.DCMD, "+"): 1,
+	}
+	if token := spb.client.SubscribeMultiple(filters, spb.onCommand); token.Wait() && token.Error() != nil {
+		spb.client.Disconnect(10)
+		return token.Error()
+	}
+	if err := spb.birth(); err != nil {
+		spb.client.Disconnect(10)
+		return err
+	}
+	spb.subscriber = eventbus.NewSubscriber(spb.PointId, spb.onDeviceEvent)
+	eventbus.Subscribe("event.device", spb.subscriber)
+	go spb.scan()
+	spb.status = typex.SOURCE_UP
+	return nil
+}
+
+func (spb *sparkplugBTarget) nodeTopic(messageType string) string {
+	return sparkplug.NodeTopic(spb.mainConfig.GroupId, messageType, spb.mainConfig.EdgeNodeId)
+}
+
+func (spb *sparkplugBTarget) deviceTopic(messageType, deviceId string) string {
+	return sparkplug.DeviceTopic(spb.mainConfig.GroupId, messageType, spb.mainConfig.EdgeNodeId, deviceId)
+}
+
+func (spb *sparkplugBTarget) nodeDeathPayload() ([]byte, error) {
+	payload := sparkplug.Payload{
+		Timestamp: uint64(time.Now().UnixMilli()),
+		Metrics: []sparkplug.Metric{
+			{Name: sparkplug.MetricBdSeq, DataType: sparkplug.Int64, Value: spb.bdSeq},
+		},
+	}
+	return payload.Encode()
+}
+
+func (spb *sparkplugBTarget) publish(topic string, payload *sparkplug.Payload) error {
+	payload.Seq = spb.seq.Next()
+	payload.HasSeq = true
+	if payload.Timestamp == 0 {
+		payload.Timestamp = uint64(time.Now().UnixMilli())
+	}
+	b, err := payload.Encode()
+	if err != nil {
+		return err
+	}
+	token := spb.client.Publish(topic, 0, false, b)
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+/*
+*
+* NBIRTH 以后所有在线设备 DBIRTH; 收到 Rebirth 命令的时候也走这里
+*
+ */
+func (spb *sparkplugBTarget) birth() error {
+	spb.lock.Lock()
+	defer spb.lock.Unlock()
+	spb.seq.Reset()
+	nbirth := &sparkplug.Payload{
+		Metrics: []sparkplug.Metric{
+			{Name: sparkplug.MetricBdSeq, DataType: sparkplug.Int64, Value: spb.bdSeq},
+			{Name: sparkplug.MetricRebirth, DataType: sparkplug.Boolean, Value: false},
+		},
+	}
+	if err := spb.publish(spb.nodeTopic(sparkplug.NBIRTH), nbirth); err != nil {
+		return err
+	}
+	spb.devices = map[string]*sparkplugDevice{}
+	for _, device := range spb.exportDevices() {
+		if device.Device == nil || device.Device.Status() != typex.SOURCE_UP {
+			continue
+		}
+		if err := spb.deviceBirth(device); err != nil {
+			glogger.GLogger.Error("Sparkplug B device birth error:", device.UUID, err)
+		}
+	}
+	return nil
+}
+
+// 需要上报的设备, 按照名字排序
+func (spb *sparkplugBTarget) exportDevices() []*typex.Device {
+	devices := []*typex.Device{}
+	for _, device := range spb.RuleEngine.AllDevices() {
+		if len(spb.mainConfig.Devices) > 0 && !utils.SContains(spb.mainConfig.Devices, device.UUID) {
+			continue
+		}
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+	return devices
+}
+
+func (spb *sparkplugBTarget) exported(deviceId string) bool {
+	return len(spb.mainConfig.Devices) == 0 || utils.SContains(spb.mainConfig.Devices, deviceId)
+}
+
+// 设备名可以当 device_id 的时候用设备名, 否则用 UUID
+func (spb *sparkplugBTarget) sparkplugDeviceId(device *typex.Device) string {
+	if !sparkplug.ValidId(device.Name) {
+		return device.UUID
+	}
+	for _, other := range spb.devices {
+		if other.UUID != device.UUID && other.Id == device.Name {
+			return device.UUID
+		}
+	}
+	return device.Name
+}
+
+/*
+*
+* 按照设备的点位表生成 DBIRTH, 需要持有锁
+*
+ */
+func (spb *sparkplugBTarget) deviceBirth(device *typex.Device) error {
+	tags, err := gatewaytag.Load(device)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	spd := &sparkplugDevice{
+		UUID: device.UUID,
+		Id:   spb.sparkplugDeviceId(device),
+		Last: map[string]string{},
+	}
+	payload := &sparkplug.Payload{}
+	for _, tag := range tags {
+		key := device.UUID + "/" + tag.Name
+		alias, ok := spb.aliases[key]
+		if !ok {
+			spb.nextAlias++
+			alias = spb.nextAlias
+			spb.aliases[key] = alias
+		}
+		metric := sparkplugMetric{
+			Tag:      tag,
+			Alias:    alias,
+			DataType: sparkplugDataType(tag.DataType),
+		}
+		spd.Metrics = append(spd.Metrics, metric)
+		value := gatewaytag.Read(device.UUID, tag)
+		m := spb.metricValue(metric, value)
+		// DBIRTH 里面带名字和别名, 后面的 DDATA 只带别名
+		m.Name = tag.Name
+		payload.Metrics = append(payload.Metrics, m)
+		spd.Last[tag.Name] = sparkplugValueKey(value)
+	}
+	if err := spb.publish(spb.deviceTopic(sparkplug.DBIRTH, spd.Id), payload); err != nil {
+		return err
+	}
+	spb.devices[device.UUID] = spd
+	return nil
+}
+
+func (spb *sparkplugBTarget) deviceDeath(deviceId string) error {
+	spd, ok := spb.devices[deviceId]
+	if !ok {
+		return nil
+	}
+	delete(spb.devices, deviceId)
+	return spb.publish(spb.deviceTopic(sparkplug.DDEATH, spd.Id), &sparkplug.Payload{})
+}
+
+// 采集失败或者没有数据的时候上报空值
+func (spb *sparkplugBTarget) metricValue(metric sparkplugMetric, value gatewaytag.Value) sparkplug.Metric {
+	m := sparkplug.Metric{
+		Alias:    metric.Alias,
+		HasAlias: true,
+		DataType: metric.DataType,
+	}
+	if !value.Timestamp.IsZero() {
+		m.Timestamp = uint64(value.Timestamp.UnixMilli())
+	}
+	if !value.Good() {
+		m.IsNull = true
+		return m
+	}
+	v, err := sparkplug.ParseValue(metric.DataType, value.Value)
+	if err != nil {
+		m.IsNull = true
+		return m
+	}
+	m.Value = v
+	return m
+}
+
+func sparkplugValueKey(value gatewaytag.Value) string {
+	if !value.Good() {
+		return ""
+	}
+	return "=" + value.Value
+}
+
+// 点位表和物模型里面的数据类型转成 Sparkplug 的数据类型, 数值带权重以后可能是小数, 统一用 Double
+func sparkplugDataType(dataType string) sparkplug.DataType {
+	switch strings.ToUpper(dataType) {
+	case "BOOL", "BOOLEAN", "I", "Q":
+		return sparkplug.Boolean
+	case "INTEGER":
+		return sparkplug.Int64
+	case "BYTE", "SHORT", "USHORT", "INT16", "UINT16", "INT", "UINT", "INT32", "UINT32",
+		"LONG", "ULONG", "INT64", "UINT64", "FLOAT", "FLOAT32", "UFLOAT32", "FLOAT64", "DOUBLE":
+		return sparkplug.Double
+	}
+	return sparkplug.String
+}
+
+/*
+*
+* 监控器上报的设备状态: DOWN 和 FAILED 的时候 DDEATH, 重新 UP 以后按照最新的点位表 DBIRTH
+*
+ */
+func (spb *sparkplugBTarget) onDeviceEvent(topic string, msg eventbus.EventMessage) {
+	parts := strings.Split(topic, ".")
+	if len(parts) != 4 || !spb.exported(parts[3]) {
+		return
+	}
+	if spb.Status() != typex.SOURCE_UP {
+		return
+	}
+	spb.lock.Lock()
+	defer spb.lock.Unlock()
+	deviceId := parts[3]
+	var err error
+	switch parts[2] {
+	case "down", "failed":
+		err = spb.deviceDeath(deviceId)
+	case "up":
+		device := spb.RuleEngine.GetDevice(deviceId)
+		if device == nil {
+			return
+		}
+		err = spb.deviceBirth(device)
+	}
+	if err != nil {
+		glogger.GLogger.Error("Sparkplug B device state publish error:", deviceId, err)
+	}
+}
+
+/*
+*
+* 定时检查点位的变化, 变化的点位按照别名通过 DDATA 上报; 设备被删除以后 DDEATH
+*
+ */
+func (spb *sparkplugBTarget) scan() {
+	ticker := time.NewTicker(time.Duration(spb.mainConfig.Frequency) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-spb.Ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := spb.report(); err != nil {
+			glogger.GLogger.Error("Sparkplug B report error:", err)
+		}
+	}
+}
+
+func (spb *sparkplugBTarget) report() error {
+	spb.lock.Lock()
+	defer spb.lock.Unlock()
+	for deviceId, spd := range spb.devices {
+		if spb.RuleEngine.GetDevice(deviceId) == nil {
+			if err := spb.deviceDeath(deviceId); err != nil {
+				return err
+			}
+			continue
+		}
+		payload := &sparkplug.Payload{}
+		for _, metric := range spd.Metrics {
+			value := gatewaytag.Read(deviceId, metric.Tag)
+			key := sparkplugValueKey(value)
+			if spd.Last[metric.Tag.Name] == key {
+				continue
+			}
+			spd.Last[metric.Tag.Name] = key
+			payload.Metrics = append(payload.Metrics, spb.metricValue(metric, value))
+		}
+		if len(payload.Metrics) == 0 {
+			continue
+		}
+		if err := spb.publish(spb.deviceTopic(sparkplug.DDATA, spd.Id), payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+*
+* NCMD 只处理 Rebirth; DCMD 按照名字或者别名找到点位, 交给设备的 OnCtrl 写入
+*
+ */
+func (spb *sparkplugBTarget) onCommand(client mqtt.Client, message mqtt.Message) {
+	topic, err := sparkplug.ParseTopic(message.Topic())
+	if err != nil {
+		glogger.GLogger.Error(err)
+		return
+	}
+	payload, err := sparkplug.Decode(message.Payload())
+	if err != nil {
+		glogger.GLogger.Error("Sparkplug B decode command error:", err)
+		return
+	}
+	switch topic.MessageType {
+	case sparkplug.NCMD:
+		for _, metric := range payload.Metrics {
+			if metric.Name == sparkplug.MetricRebirth && metric.Value == true {
+				glogger.GLogger.Info("Sparkplug B rebirth requested")
+				if err := spb.birth(); err != nil {
+					glogger.GLogger.Error("Sparkplug B rebirth error:", err)
+				}
+				return
+			}
+		}
+	case sparkplug.DCMD:
+		spb.writeDevice(topic.DeviceId, payload.Metrics)
+	}
+}
+
+func (spb *sparkplugBTarget) writeDevice(id string, metrics []sparkplug.Metric) {
+	spb.lock.Lock()
+	var spd *sparkplugDevice
+	for _, device := range spb.devices {
+		if device.Id == id {
+			spd = device
+			break
+		}
+	}
+	type write struct {
+		tag   gatewaytag.Tag
+		value string
+	}
+	writes := []write{}
+	if spd != nil {
+		for _, metric := range metrics {
+			for _, m := range spd.Metrics {
+				if (metric.HasAlias && metric.Alias == m.Alias) || (metric.Name != "" && metric.Name == m.Tag.Name) {
+					writes = append(writes, write{tag: m.Tag, value: metric.StringValue()})
+					break
+				}
+			}
+		}
+	}
+	spb.lock.Unlock()
+	if spd == nil {
+		glogger.GLogger.Error("Sparkplug B command to unknown device:", id)
+		return
+	}
+	// 写设备可能比较慢, 不占用锁
+	for _, w := range writes {
+		if err := gatewaytag.Write(spb.RuleEngine, spd.UUID, w.tag, w.value); err != nil {
+			glogger.GLogger.Error("Sparkplug B write error:", id, w.tag.Name, err)
+		}
+	}
+}
+
+func (spb *sparkplugBTarget) Stop() {
+	spb.status = typex.SOURCE_DOWN
+	if spb.subscriber != nil {
+		eventbus.UnSubscribe("event.device", spb.subscriber)
+	}
+	if spb.CancelCTX != nil {
+		spb.CancelCTX()
+	}
+	if spb.client != nil {
+		// 正常断开的时候 Broker 不会发遗嘱, 自己发 NDEATH
+		if spb.client.IsConnected() {
+			if death, err := spb.nodeDeathPayload(); err == nil {
+				spb.client.Publish(spb.nodeTopic(sparkplug.NDEATH), 1, false, death).WaitTimeout(time.Second)
+			}
+		}
+		spb.client.Disconnect(10)
+	}
+}
+
+func (spb *sparkplugBTarget) Status() typex.SourceState {
+	if spb.client != nil {
+		if spb.client.IsConnected() && spb.client.IsConnectionOpen() {
+			return typex.SOURCE_UP
+		}
+		return typex.SOURCE_DOWN
+	}
+	return spb.status
+}
+
+func (spb *sparkplugBTarget) Details() *typex.OutEnd {
+	return spb.RuleEngine.GetOutEnd(spb.PointId)
+}
+
+// 规则里面直接上报: {"device": "设备UUID", "values": {"点位名": 值}}
+type sparkplugBData struct {
+	Device string         `json:"device"`
+	Values map[string]any `json:"values"`
+}
+
+/*
+*
+* 规则推送的数据按照 DBIRTH 里面的指标立即 DDATA, 不认识的点位忽略
+*
+ */
+func (spb *sparkplugBTarget) To(data any) (any, error) {
+	if spb.client == nil {
+		return nil, errors.New("sparkplug b client is nil")
+	}
+	T, ok := data.(string)
+	if !ok {
+		return nil, errors.New("Invalid sparkplug b data type")
+	}
+	input := sparkplugBData{}
+	if err := json.Unmarshal([]byte(T), &input); err != nil {
+		return nil, err
+	}
+	spb.lock.Lock()
+	defer spb.lock.Unlock()
+	spd, ok := spb.devices[input.Device]
+	if !ok {
+		return nil, fmt.Errorf("device not born: %s", input.Device)
+	}
+	payload := &sparkplug.Payload{}
+	for _, metric := range spd.Metrics {
+		raw, ok := input.Values[metric.Tag.Name]
+		if !ok {
+			continue
+		}
+		value := gatewaytag.Value{Value: fmt.Sprintf("%v", raw), Cached: true, Status: 1, Timestamp: time.Now()}
+		spd.Last[metric.Tag.Name] = sparkplugValueKey(value)
+		payload.Metrics = append(payload.Metrics, spb.metricValue(metric, value))
+	}
+	if len(payload.Metrics) == 0 {
+		return nil, errors.New("no known metrics in data")
+	}
+	return nil, spb.publish(spb.deviceTopic(sparkplug.DDATA, spd.Id), payload)
+}
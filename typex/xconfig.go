@@ -23,20 +23,31 @@ type Secret struct {
 	Value []string `ini:"value,,allowshadow"`
 }
 type RhilexConfig struct {
-	IniPath               string   `json:"-"`
-	AppId                 string   `ini:"app_id" json:"appId"`
-	MaxQueueSize          int      `ini:"max_queue_size" json:"maxQueueSize"`
-	SourceRestartInterval int      `ini:"resource_restart_interval" json:"sourceRestartInterval"`
-	GomaxProcs            int      `ini:"gomax_procs" json:"gomaxProcs"`
-	EnablePProf           bool     `ini:"enable_pprof" json:"enablePProf"`
-	EnableConsole         bool     `ini:"enable_console" json:"enableConsole"`
-	DebugMode             bool     `ini:"debug_mode" json:"appDebugMode"`
-	LogLevel              string   `ini:"log_level" json:"logLevel"`
-	LogMaxSize            int      `ini:"log_max_size" json:"logMaxSize"`
-	LogMaxBackups         int      `ini:"log_max_backups" json:"logMaxBackups"`
-	LogMaxAge             int      `ini:"log_max_age" json:"logMaxAge"`
-	LogCompress           bool     `ini:"log_compress" json:"logCompress"`
-	MaxKvStoreSize        int      `ini:"max_kv_store_size" json:"maxKvStoreSize"`
-	ExtLibs               []string `ini:"ext_libs,,allowshadow" json:"extLibs"`
-	DataSchemaSecret      []string `ini:"dataschema_secrets,,allowshadow" json:"dataSchemaSecret"`
+	IniPath             string   `json:"-"`
+	AppId               string   `ini:"app_id" json:"appId"`
+	MaxQueueSize        int      `ini:"max_queue_size" json:"maxQueueSize"`
+	QueueWorkers        int      `ini:"queue_workers" json:"queueWorkers"`
+	QueueFullPolicy     string   `ini:"queue_full_policy" json:"queueFullPolicy"`
+	RestartInitialDelay int      `ini:"resource_restart_initial_delay" json:"restartInitialDelay"`
+	RestartMultiplier   float64  `ini:"resource_restart_multiplier" json:"restartMultiplier"`
+	RestartMaxDelay     int      `ini:"resource_restart_max_delay" json:"restartMaxDelay"`
+	RestartMaxAttempts  int      `ini:"resource_restart_max_attempts" json:"restartMaxAttempts"`
+	RestartResetAfter   int      `ini:"resource_restart_reset_after" json:"restartResetAfter"`
+	LuaTimeout          int      `ini:"lua_timeout" json:"luaTimeout"`
+	LuaMaxInstructions  int64    `ini:"lua_max_instructions" json:"luaMaxInstructions"`
+	LuaMaxMemory        int      `ini:"lua_max_memory" json:"luaMaxMemory"`
+	LuaMaxViolations    int      `ini:"lua_max_violations" json:"luaMaxViolations"`
+	GomaxProcs          int      `ini:"gomax_procs" json:"gomaxProcs"`
+	EnablePProf         bool     `ini:"enable_pprof" json:"enablePProf"`
+	EnableMetrics       bool     `ini:"enable_metrics" json:"enableMetrics"`
+	EnableConsole       bool     `ini:"enable_console" json:"enableConsole"`
+	DebugMode           bool     `ini:"debug_mode" json:"appDebugMode"`
+	LogLevel            string   `ini:"log_level" json:"logLevel"`
+	LogMaxSize          int      `ini:"log_max_size" json:"logMaxSize"`
+	LogMaxBackups       int      `ini:"log_max_backups" json:"logMaxBackups"`
+	LogMaxAge           int      `ini:"log_max_age" json:"logMaxAge"`
+	LogCompress         bool     `ini:"log_compress" json:"logCompress"`
+	MaxKvStoreSize      int      `ini:"max_kv_store_size" json:"maxKvStoreSize"`
+	ExtLibs             []string `ini:"ext_libs,,allowshadow" json:"extLibs"`
+	DataSchemaSecret    []string `ini:"dataschema_secrets,,allowshadow" json:"dataSchemaSecret"`
 }
@@ -30,6 +30,7 @@ const (
 	GENERIC_UART_RW       DeviceType = "GENERIC_UART_RW"       // 通用读写串口
 	GENERIC_MODBUS_MASTER DeviceType = "GENERIC_MODBUS_MASTER" // 通用 GENERIC_MODBUS_MASTER
 	GENERIC_MODBUS_SLAVER DeviceType = "GENERIC_MODBUS_SLAVER" // 通用 GENERIC_MODBUS_SLAVER
+	MODBUS_GATEWAY        DeviceType = "MODBUS_GATEWAY"        // Modbus TCP 转 RTU 网关
 )
 
 /**
@@ -47,6 +48,10 @@ const (
 	ITHINGS_IOTHUB_GATEWAY      DeviceType = "ITHINGS_IOTHUB_GATEWAY"      // ITHINGS物联网平台
 	LORA_WAN_GATEWAY            DeviceType = "LORA_WAN_GATEWAY"            // LoraWan
 	KNX_GATEWAY                 DeviceType = "KNX_GATEWAY"                 // KNX 网关
+	IEC104_MASTER               DeviceType = "IEC104_MASTER"               // IEC60870-5-104 主站
+	OPCUA_CLIENT                DeviceType = "OPCUA_CLIENT"                // OPC UA 客户端
+	DNP3_MASTER                 DeviceType = "DNP3_MASTER"                 // DNP3 主站
+	DNP3_OUTSTATION             DeviceType = "DNP3_OUTSTATION"             // DNP3 子站
 	GENERIC_MBUS_EN13433_MASTER DeviceType = "GENERIC_MBUS_EN13433_MASTER" // 通用 Mbus
 	DLT6452007_MASTER           DeviceType = "DLT6452007_MASTER"           // DLT6452004
 	CJT1882004_MASTER           DeviceType = "CJT1882004_MASTER"           // CJT1882004
@@ -16,6 +16,11 @@
 package typex
 
 import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"path"
+
 	lua "github.com/hootrhino/gopher-lua"
 )
 
@@ -33,18 +38,22 @@ const RULE_RUNNING RuleStatus = 1
 
 // 规则描述
 type Rule struct {
-	Id          string      `json:"id"`
-	UUID        string      `json:"uuid"`
-	Type        string      `json:"type"` // 脚本类型，目前支持"lua"
-	Status      RuleStatus  `json:"status"`
-	Name        string      `json:"name"`
-	FromSource  string      `json:"fromSource"` // 来自数据源
-	FromDevice  string      `json:"fromDevice"` // 来自设备
-	Actions     string      `json:"actions"`
-	Success     string      `json:"success"`
-	Failed      string      `json:"failed"`
-	Description string      `json:"description"`
-	LuaVM       *lua.LState `json:"-"` // Lua VM
+	Id          string       `json:"id"`
+	UUID        string       `json:"uuid"`
+	Type        string       `json:"type"` // 脚本类型，目前支持"lua"
+	Status      RuleStatus   `json:"status"`
+	Name        string       `json:"name"`
+	FromSource  string       `json:"fromSource"` // 来自数据源
+	FromDevice  string       `json:"fromDevice"` // 来自设备
+	Selector    RuleSelector `json:"selector"`   // 订阅的资源, 一个规则可以绑定多个资源
+	Budget      LuaBudget    `json:"budget"`     // 每次执行的资源限制
+	Version     int          `json:"version"`    // 脚本的版本号, 灰度的时候可能和线上版本不同
+	Shadow      *Rule        `json:"-"`          // 影子模式下待发布版本的实例, 输出丢弃只做比较
+	Actions     string       `json:"actions"`
+	Success     string       `json:"success"`
+	Failed      string       `json:"failed"`
+	Description string       `json:"description"`
+	LuaVM       *lua.LState  `json:"-"` // Lua VM
 }
 
 func NewLuaRule(e Rhilex,
@@ -114,3 +123,174 @@ func (r *Rule) AddLib(rx Rhilex, ModuleName string, funcName string,
 		return 1
 	})
 }
+
+/*
+*
+* Lua 执行预算: 0 使用全局配置, 小于 0 表示不限制
+*
+ */
+type LuaBudget struct {
+	Timeout         int   `json:"timeout"`         // 单次执行的超时时间, 毫秒
+	MaxInstructions int64 `json:"maxInstructions"` // 单次执行最多的指令数
+	MaxMemory       int   `json:"maxMemory"`       // 虚拟机里面的数据最多占用的内存, MB
+}
+
+// 灰度发布的方式
+const (
+	ROLLOUT_CANARY string = "CANARY" // 部分资源先运行新版本
+	ROLLOUT_SHADOW string = "SHADOW" // 所有资源同时运行新版本, 输出丢弃, 只和线上版本比较
+)
+
+/*
+*
+* 规则的灰度发布: 待发布的版本先在部分资源上运行, 或者以影子模式运行, 确认以后再发布
+*
+ */
+type RuleRollout struct {
+	Mode      string   `json:"mode"`      // CANARY 或者 SHADOW, 空表示没有灰度
+	Version   int      `json:"version"`   // 待发布的版本
+	Resources []string `json:"resources"` // CANARY: 先运行新版本的资源 UUID
+	Percent   int      `json:"percent"`   // CANARY: 按照资源 UUID 哈希选出的比例, 0-100, 和 Resources 取并集
+}
+
+func (r RuleRollout) Active() bool {
+	return r.Mode != "" && r.Version > 0
+}
+
+func (r RuleRollout) Validate() error {
+	switch r.Mode {
+	case "":
+		return nil
+	case ROLLOUT_CANARY:
+		if len(r.Resources) == 0 && r.Percent <= 0 {
+			return fmt.Errorf("canary rollout need resources or percent")
+		}
+	case ROLLOUT_SHADOW:
+	default:
+		return fmt.Errorf("unknown rollout mode: %s", r.Mode)
+	}
+	if r.Percent < 0 || r.Percent > 100 {
+		return fmt.Errorf("rollout percent must be in range [0, 100]")
+	}
+	return nil
+}
+
+/*
+*
+* 资源是否运行待发布的版本, 按照百分比选的时候同一个资源的结果是固定的
+*
+ */
+func (r RuleRollout) Canary(ruleId, resource string) bool {
+	if r.Mode != ROLLOUT_CANARY {
+		return false
+	}
+	if containsString(r.Resources, resource) {
+		return true
+	}
+	if r.Percent <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(ruleId + "/" + resource))
+	return int(h.Sum32()%100) < r.Percent
+}
+
+/*
+*
+* 规则订阅的资源, 满足任意一个条件就绑定; 每个绑定的资源有自己独立的规则实例
+*
+ */
+type RuleSelector struct {
+	Sources []string `json:"sources"` // 南向资源 UUID
+	Devices []string `json:"devices"` // 设备 UUID
+	Groups  []string `json:"groups"`  // 分组 UUID, 分组和下级分组里面的资源都会绑定
+	Types   []string `json:"types"`   // 资源类型, 支持通配符, 比如 GENERIC_MODBUS_*
+	Names   []string `json:"names"`   // 资源名称, 支持通配符, 比如 meter-*
+	Tags    []string `json:"tags"`    // 设备标签, 支持通配符, 比如 floor-1*
+}
+
+// 规则的来源资源, 执行 Actions 的时候作为上下文传给脚本
+type RuleResource struct {
+	Kind   string   // INEND 或者 DEVICE
+	UUID   string   //
+	Name   string   //
+	Type   string   //
+	Groups []string // 资源所在的分组以及上级分组
+	Tags   []string // 设备的标签
+}
+
+type ruleResourceKey struct{}
+
+// 执行规则的时候资源放在虚拟机的 Context 里面, 库函数可以知道当前的数据来源
+func WithRuleResource(ctx context.Context, resource RuleResource) context.Context {
+	return context.WithValue(ctx, ruleResourceKey{}, resource)
+}
+
+func RuleResourceFrom(ctx context.Context) (RuleResource, bool) {
+	if ctx == nil {
+		return RuleResource{}, false
+	}
+	resource, ok := ctx.Value(ruleResourceKey{}).(RuleResource)
+	return resource, ok
+}
+
+// 检查通配符的格式
+func (s RuleSelector) Validate() error {
+	patterns := append(append(append([]string{}, s.Types...), s.Names...), s.Tags...)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern: %s", pattern)
+		}
+	}
+	return nil
+}
+
+// 是否有按照分组或者通配符订阅, 这种订阅在资源加载的时候才能确定
+func (s RuleSelector) Dynamic() bool {
+	return len(s.Groups) > 0 || len(s.Types) > 0 || len(s.Names) > 0 || len(s.Tags) > 0
+}
+
+func (s RuleSelector) Match(res RuleResource) bool {
+	switch res.Kind {
+	case "INEND":
+		if containsString(s.Sources, res.UUID) {
+			return true
+		}
+	case "DEVICE":
+		if containsString(s.Devices, res.UUID) {
+			return true
+		}
+	}
+	for _, group := range res.Groups {
+		if containsString(s.Groups, group) {
+			return true
+		}
+	}
+	for _, tag := range res.Tags {
+		if matchPattern(s.Tags, tag) {
+			return true
+		}
+	}
+	return matchPattern(s.Types, res.Type) || matchPattern(s.Names, res.Name)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPattern(patterns []string, s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, s); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package typex
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRuleSelectorMatch(t *testing.T) {
+	selector := RuleSelector{
+		Sources: []string{"INEND1"},
+		Devices: []string{"DEVICE1"},
+		Groups:  []string{"GROUP1"},
+		Types:   []string{"GENERIC_MODBUS_*"},
+		Names:   []string{"meter-*"},
+		Tags:    []string{"floor-1*"},
+	}
+	cases := []struct {
+		resource RuleResource
+		match    bool
+	}{
+		{RuleResource{Kind: "INEND", UUID: "INEND1"}, true},
+		{RuleResource{Kind: "DEVICE", UUID: "INEND1"}, false},
+		{RuleResource{Kind: "DEVICE", UUID: "DEVICE1"}, true},
+		{RuleResource{Kind: "DEVICE", UUID: "DEVICE2", Groups: []string{"GROUP2", "GROUP1"}}, true},
+		{RuleResource{Kind: "DEVICE", UUID: "DEVICE3", Type: "GENERIC_MODBUS_MASTER"}, true},
+		{RuleResource{Kind: "DEVICE", UUID: "DEVICE4", Type: "SIEMENS_PLC"}, false},
+		{RuleResource{Kind: "INEND", UUID: "INEND2", Name: "meter-01"}, true},
+		{RuleResource{Kind: "INEND", UUID: "INEND3", Name: "pump-01"}, false},
+		{RuleResource{Kind: "DEVICE", UUID: "DEVICE5", Tags: []string{"hvac", "floor-12"}}, true},
+		{RuleResource{Kind: "DEVICE", UUID: "DEVICE6", Tags: []string{"floor-2"}}, false},
+	}
+	for _, c := range cases {
+		if selector.Match(c.resource) != c.match {
+			t.Fatalf("match %+v, expect %v", c.resource, c.match)
+		}
+	}
+	if !selector.Dynamic() {
+		t.Fatal("selector with groups should be dynamic")
+	}
+	if !(RuleSelector{Tags: []string{"hvac"}}).Dynamic() {
+		t.Fatal("selector with tags should be dynamic")
+	}
+	if (RuleSelector{Devices: []string{"DEVICE1"}}).Dynamic() {
+		t.Fatal("selector with devices only should not be dynamic")
+	}
+}
+
+func TestRuleSelectorValidate(t *testing.T) {
+	if err := (RuleSelector{Types: []string{"GENERIC_*"}}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (RuleSelector{Names: []string{"meter-["}}).Validate(); err == nil {
+		t.Fatal("expect invalid pattern")
+	}
+	if err := (RuleSelector{Tags: []string{"floor-["}}).Validate(); err == nil {
+		t.Fatal("expect invalid tag pattern")
+	}
+}
+
+func TestRuleRolloutCanary(t *testing.T) {
+	rollout := RuleRollout{Mode: ROLLOUT_CANARY, Version: 2, Resources: []string{"DEVICE1"}}
+	if err := rollout.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !rollout.Canary("RULE1", "DEVICE1") || rollout.Canary("RULE1", "DEVICE2") {
+		t.Fatal("canary by resources")
+	}
+	rollout = RuleRollout{Mode: ROLLOUT_CANARY, Version: 2, Percent: 50}
+	selected := 0
+	for i := 0; i < 1000; i++ {
+		resource := fmt.Sprintf("DEVICE%d", i)
+		if rollout.Canary("RULE1", resource) != rollout.Canary("RULE1", resource) {
+			t.Fatal("canary should be stable")
+		}
+		if rollout.Canary("RULE1", resource) {
+			selected++
+		}
+	}
+	if selected < 400 || selected > 600 {
+		t.Fatal("canary percent:", selected)
+	}
+	if (RuleRollout{Mode: ROLLOUT_SHADOW, Version: 2}).Canary("RULE1", "DEVICE1") {
+		t.Fatal("shadow is not canary")
+	}
+	if (RuleRollout{Mode: ROLLOUT_CANARY}).Validate() == nil {
+		t.Fatal("canary without resources")
+	}
+	if (RuleRollout{Mode: "BLUE"}).Validate() == nil {
+		t.Fatal("unknown mode")
+	}
+}
@@ -34,6 +34,8 @@ const (
 	GENERIC_MQTT_SERVER    InEndType = "GENERIC_MQTT_SERVER"    // Mqtt Server
 	INTERNAL_EVENT         InEndType = "INTERNAL_EVENT"         // 内部消息
 	COMTC_EVENT_FORWARDER  InEndType = "COMTC_EVENT_FORWARDER"  // 外设通信模块事件
+	SNMP_TRAP_SERVER       InEndType = "SNMP_TRAP_SERVER"       // SNMP Trap/Inform 接收
+	OPCUA_SERVER           InEndType = "OPCUA_SERVER"           // OPC UA 服务端
 )
 
 // XStatus for source status
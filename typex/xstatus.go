@@ -25,6 +25,7 @@ const (
 	SOURCE_STOP    SourceState = 3
 	SOURCE_PENDING SourceState = 4
 	SOURCE_DISABLE SourceState = 5
+	SOURCE_FAILED  SourceState = 6 // 重启次数超过上限, 不再自动重启
 )
 
 func (s SourceState) String() string {
@@ -40,6 +41,9 @@ func (s SourceState) String() string {
 	if s == 3 {
 		return "STOP"
 	}
+	if s == 6 {
+		return "FAILED"
+	}
 	return "UnKnown State"
 
 }
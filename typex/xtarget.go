@@ -39,6 +39,7 @@ const (
 	TCP_TRANSPORT         TargetType = "TCP_TRANSPORT"         // To TCP Transport
 	SEMTECH_UDP_FORWARDER TargetType = "SEMTECH_UDP_FORWARDER" // To Chirp stack UDP
 	GREPTIME_DATABASE     TargetType = "GREPTIME_DATABASE"     // To GREPTIME DATABASE
+	SPARKPLUG_B           TargetType = "SPARKPLUG_B"           // To Sparkplug B Host Application
 )
 
 // Stream from source and to target
@@ -18,6 +18,7 @@ package utils
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 )
@@ -37,13 +38,13 @@ import (
 
 // AddressInfo 包含解析后的地址信息
 type AddressInfo struct {
-	AddressType     string // 寄存器类型: DB I Q
-	DataBlockType   string // 数据类型: BYTE SHORT INT
+	AddressType     string // 寄存器类型: DB I Q M
+	DataBlockType   string // 数据类型: 位地址为 BOOL, DBS 为 STRING, 其余由点位表决定
 	DataBlockSize   int    // 数据长度
 	DataBlockOrder  string // 字节序
 	DataBlockNumber int    // 数据块号
 	ElementNumber   int    // 元素号
-	BitNumber       int    // 位号，只针对I、Q
+	BitNumber       int    // 位号, 0-7
 }
 
 func (O AddressInfo) String() string {
@@ -54,162 +55,114 @@ func (O AddressInfo) String() string {
 	}
 }
 
+var (
+	siemensDBAddress   = regexp.MustCompile(`^DB(\d+)\.DB([XBWDS])(\d+)(?:\.(\d+))?$`)
+	siemensBitAddress  = regexp.MustCompile(`^([IQEAM])(\d+)\.(\d+)$`)
+	siemensAreaAddress = regexp.MustCompile(`^([IQEAM])([BWD])(\d+)$`)
+)
+
 /*
 *
-* 解析地址
+* 解析地址, 支持 TIA 风格的 % 前缀:
+* - DB1.DBX10.3 DB1.DBB10 DB1.DBW10 DB1.DBD10, DB1.DBS10.20 表示从第10字节开始最大长度20的字符串
+* - I0.0 Q1.1 M10.2 位地址, 德语助记符 E/A 等同于 I/Q
+* - IB0 IW2 ID4 QB0 MW10 MD20 字节、字、双字
 *
  */
 func ParseSiemensDB(s string) (AddressInfo, error) {
 	AddressInfo := AddressInfo{}
+	s = strings.ToUpper(strings.TrimPrefix(strings.TrimSpace(s), "%"))
 	if len(s) < 3 {
 		return AddressInfo, fmt.Errorf("Invalid Address format:%s", s)
 	}
-	if s[:2] == "I." {
-		return _ParseADDR_I(s)
-	}
-	if s[:2] == "Q." {
-		return _ParseADDR_Q(s)
-	}
-	if s[:2] == "IB" {
-		return _ParseADDR_IB(s)
-	}
-	if s[:2] == "QB" {
-		return _ParseADDR_QB(s)
-	}
-	if s[:2] == "DB" {
+	if strings.HasPrefix(s, "DB") {
 		return _ParseDB_DX(s)
 	}
-	return AddressInfo, nil
-
-}
-
-// 解析DB: DB4900.DBD2108
-// ^DB\d+\.\w+\.\d+$
-func _ParseDB_DX(s string) (AddressInfo, error) {
-	AddressInfo := AddressInfo{}
-	Len := len(s)
-	if Len < 3 {
-		return AddressInfo, fmt.Errorf("Invalid Address length:%s", s)
-	}
-	// DB4900.DBD2108
-	if s[:2] == "DB" {
-		AddressInfo.AddressType = "DB"
-		parts := strings.Split(s[2:], ".")
-		// 4900.DBD2108
-		if len(parts) != 2 {
-			return AddressInfo, fmt.Errorf("Invalid Element Number Address format:%s", s)
-		}
-		DataBlockNumber, err1 := strconv.Atoi(parts[0])
-		if err1 != nil {
-			return AddressInfo, fmt.Errorf("Address %s Atoi failed:%s", s, err1)
-		}
-		AddressInfo.DataBlockNumber = DataBlockNumber
-		// DBD2108
-		if len(parts[1]) < 4 {
-			return AddressInfo, fmt.Errorf("Invalid Element Number Address format:%s", s)
-		}
-		ElementNumber, err2 := strconv.Atoi(parts[1][3:]) //DBD...
-		if err2 != nil {
-			return AddressInfo, fmt.Errorf("Element Number %s Atoi failed:%s", s, err2)
-		}
-		switch parts[1][2] {
-
-		case 'D': // DBD: 4字节
-			AddressInfo.DataBlockSize = 4
-		case 'W': // DBw: 2字节
-			AddressInfo.DataBlockSize = 2
-		case 'B': // DBB: 1字节
-			AddressInfo.DataBlockSize = 1
-		case 'X': // DBX: 1字节
-			AddressInfo.DataBlockSize = 1
-		default:
-			return AddressInfo, fmt.Errorf("Invalid Element Type:%c", parts[1][2])
+	if match := siemensBitAddress.FindStringSubmatch(s); match != nil {
+		ElementNumber, _ := strconv.Atoi(match[2])
+		BitNumber, _ := strconv.Atoi(match[3])
+		if BitNumber > 7 {
+			return AddressInfo, fmt.Errorf("Invalid Bit Number:%s", s)
 		}
+		AddressInfo.AddressType = _siemensArea(match[1])
+		AddressInfo.DataBlockType = "BOOL"
+		AddressInfo.DataBlockSize = 1
 		AddressInfo.ElementNumber = ElementNumber
+		AddressInfo.BitNumber = BitNumber
+		return AddressInfo, nil
 	}
-	return AddressInfo, nil
+	if match := siemensAreaAddress.FindStringSubmatch(s); match != nil {
+		ElementNumber, _ := strconv.Atoi(match[3])
+		AddressInfo.AddressType = _siemensArea(match[1])
+		AddressInfo.DataBlockSize = _siemensElementSize(match[2][0])
+		AddressInfo.ElementNumber = ElementNumber
+		return AddressInfo, nil
+	}
+	return AddressInfo, fmt.Errorf("Invalid Address format:%s", s)
 }
 
-// 解析I格式
-// I（输入）寄存器：
-// 读取I寄存器：I + 编号，例如 I0.0、I1.1 等。
-// 写入I寄存器：I + 编号，例如 I0.0、I1.1 等。
-
-func _ParseADDR_I(s string) (AddressInfo, error) {
+// 解析DB: DB4900.DBD2108, DB1.DBX0.1, DB1.DBS10.20
+func _ParseDB_DX(s string) (AddressInfo, error) {
 	AddressInfo := AddressInfo{}
-	if len(s) < 2 {
-		return AddressInfo, fmt.Errorf("Invalid Address format:%s", s)
+	match := siemensDBAddress.FindStringSubmatch(s)
+	if match == nil {
+		return AddressInfo, fmt.Errorf("Invalid Element Number Address format:%s", s)
 	}
-	// I0.0
-	parts := strings.Split(s[1:], ".")
-	// 0.0
-	if len(parts) != 2 {
-		return AddressInfo, fmt.Errorf("Invalid ElementNumber Address format:%s", s)
-	}
-	//
-	DataBlockNumber, err1 := strconv.Atoi(parts[0])
+	DataBlockNumber, err1 := strconv.Atoi(match[1])
 	if err1 != nil {
 		return AddressInfo, fmt.Errorf("Address %s Atoi failed:%s", s, err1)
 	}
-	BitNumber, err2 := strconv.Atoi(parts[0])
+	ElementNumber, err2 := strconv.Atoi(match[3])
 	if err2 != nil {
-		return AddressInfo, fmt.Errorf("Address %s Atoi failed:%s", s, err2)
+		return AddressInfo, fmt.Errorf("Element Number %s Atoi failed:%s", s, err2)
 	}
-	AddressInfo.AddressType = "I"
-	AddressInfo.DataBlockType = "BYTE"
+	AddressInfo.AddressType = "DB"
 	AddressInfo.DataBlockNumber = DataBlockNumber
-	AddressInfo.BitNumber = BitNumber
+	AddressInfo.ElementNumber = ElementNumber
+	switch match[2][0] {
+	case 'X': // DBX: 位
+		// 兼容旧版本的 DB1.DBX10, 没有写位号的时候是第 0 位
+		BitNumber, _ := strconv.Atoi(match[4])
+		if BitNumber > 7 {
+			return AddressInfo, fmt.Errorf("Invalid Bit Number:%s", s)
+		}
+		AddressInfo.DataBlockType = "BOOL"
+		AddressInfo.DataBlockSize = 1
+		AddressInfo.BitNumber = BitNumber
+	case 'S': // DBS: 字符串, 2字节头 + 最大长度
+		MaxLength, _ := strconv.Atoi(match[4])
+		if MaxLength < 1 || MaxLength > 254 {
+			return AddressInfo, fmt.Errorf("String length must range of 1-254:%s", s)
+		}
+		AddressInfo.DataBlockType = "STRING"
+		AddressInfo.DataBlockSize = MaxLength + 2
+	default:
+		if match[4] != "" {
+			return AddressInfo, fmt.Errorf("Invalid Element Number Address format:%s", s)
+		}
+		AddressInfo.DataBlockSize = _siemensElementSize(match[2][0])
+	}
 	return AddressInfo, nil
 }
 
-// IB0
-func _ParseADDR_IB(string) (AddressInfo, error) {
-	AddressInfo := AddressInfo{}
-	AddressInfo.AddressType = "IB"
-	DataBlockNumber := 0
-	AddressInfo.DataBlockNumber = DataBlockNumber
-	BitNumber := 0
-	AddressInfo.BitNumber = BitNumber
-	return AddressInfo, nil
+// 寄存器区域: DB I Q M
+func _siemensArea(area string) string {
+	switch area {
+	case "E":
+		return "I"
+	case "A":
+		return "Q"
+	}
+	return area
 }
 
-// 解析Q格式
-// Q（输出）寄存器：
-// 读取Q寄存器：Q + 编号，例如 Q0.0、Q1.1 等。
-// 写入Q寄存器：Q + 编号，例如 Q0.0、Q1.1 等。
-func _ParseADDR_Q(s string) (AddressInfo, error) {
-	AddressInfo := AddressInfo{}
-	if len(s) < 2 {
-		return AddressInfo, fmt.Errorf("Invalid Address format:%s", s)
-	}
-	// I0.0
-	parts := strings.Split(s[1:], ".")
-	// 0.0
-	if len(parts) != 2 {
-		return AddressInfo, fmt.Errorf("Invalid ElementNumber Address format:%s", s)
-	}
-	//
-	DataBlockNumber, err1 := strconv.Atoi(parts[0])
-	if err1 != nil {
-		return AddressInfo, fmt.Errorf("Address %s Atoi failed:%s", s, err1)
-	}
-	BitNumber, err2 := strconv.Atoi(parts[0])
-	if err2 != nil {
-		return AddressInfo, fmt.Errorf("Address %s Atoi failed:%s", s, err2)
-	}
-	AddressInfo.AddressType = "Q"
-	AddressInfo.DataBlockNumber = DataBlockNumber
-	AddressInfo.DataBlockType = "BYTE"
-	AddressInfo.BitNumber = BitNumber
-
-	return AddressInfo, nil
-}
-func _ParseADDR_QB(string) (AddressInfo, error) {
-	AddressInfo := AddressInfo{}
-	AddressInfo.AddressType = "QB"
-	DataBlockNumber := 0
-	AddressInfo.DataBlockNumber = DataBlockNumber
-	BitNumber := 0
-	AddressInfo.BitNumber = BitNumber
-	return AddressInfo, nil
+// B: 1字节, W: 2字节, D: 4字节
+func _siemensElementSize(c byte) int {
+	switch c {
+	case 'W':
+		return 2
+	case 'D':
+		return 4
+	}
+	return 1
 }
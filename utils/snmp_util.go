@@ -0,0 +1,95 @@
+// Copyright (C) 2024 wwhai
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"encoding/hex"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/hootrhino/rhilex/resconfig"
+)
+
+/*
+*
+* 把配置里面的 v3 用户转成 gosnmp 的安全参数
+*
+ */
+func SnmpUsmParameters(cfg resconfig.SnmpV3UsmConfig) (gosnmp.SnmpV3MsgFlags, *gosnmp.UsmSecurityParameters) {
+	params := &gosnmp.UsmSecurityParameters{
+		UserName:                 cfg.UserName,
+		AuthenticationProtocol:   gosnmp.NoAuth,
+		PrivacyProtocol:          gosnmp.NoPriv,
+		AuthenticationPassphrase: cfg.AuthPassphrase,
+		PrivacyPassphrase:        cfg.PrivPassphrase,
+	}
+	flags := gosnmp.NoAuthNoPriv
+	if cfg.SecurityLevel == resconfig.SNMP_AUTH_NO_PRIV ||
+		cfg.SecurityLevel == resconfig.SNMP_AUTH_PRIV {
+		flags = gosnmp.AuthNoPriv
+		switch cfg.AuthProtocol {
+		case "MD5":
+			params.AuthenticationProtocol = gosnmp.MD5
+		case "SHA":
+			params.AuthenticationProtocol = gosnmp.SHA
+		case "SHA256":
+			params.AuthenticationProtocol = gosnmp.SHA256
+		}
+	}
+	if cfg.SecurityLevel == resconfig.SNMP_AUTH_PRIV {
+		flags = gosnmp.AuthPriv
+		switch cfg.PrivProtocol {
+		case "DES":
+			params.PrivacyProtocol = gosnmp.DES
+		case "AES":
+			params.PrivacyProtocol = gosnmp.AES
+		}
+	}
+	return flags, params
+}
+
+/*
+*
+* 变量绑定的值转成可以直接 JSON 序列化的值, 不可打印的字节串转成十六进制
+*
+ */
+func SnmpPDUValue(pdu gosnmp.SnmpPDU) any {
+	switch pdu.Type {
+	case gosnmp.OctetString, gosnmp.BitString, gosnmp.Opaque:
+		if b, ok := pdu.Value.([]byte); ok {
+			if utf8.Valid(b) {
+				return string(b)
+			}
+			return hex.EncodeToString(b)
+		}
+	case gosnmp.Integer:
+		return gosnmp.ToBigInt(pdu.Value).Int64()
+	case gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks,
+		gosnmp.Counter64, gosnmp.Uinteger32:
+		return gosnmp.ToBigInt(pdu.Value).Uint64()
+	case gosnmp.Null, gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		return nil
+	}
+	if pdu.Value == nil {
+		return nil
+	}
+	switch v := pdu.Value.(type) {
+	case string, bool, float32, float64:
+		return v
+	}
+	return fmt.Sprintf("%v", pdu.Value)
+}
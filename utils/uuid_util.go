@@ -38,6 +38,10 @@ func AlarmRuleUuid() string {
 	return MakeUUID("ALARM")
 }
 
+func AlarmUuid() string {
+	return MakeUUID("ALACT")
+}
+
 func GroupUuid() string {
 	return MakeUUID("GROUP")
 }
@@ -59,6 +63,11 @@ func RuleUuid() string {
 	return MakeUUID("RULE")
 }
 
+// MakeUUID
+func FlowUuid() string {
+	return MakeUUID("FLOW")
+}
+
 // MakeUUID
 func UserLuaUuid() string {
 	return MakeUUID("USERLUA")
@@ -84,6 +93,26 @@ func SnmpOidUUID() string {
 	return MakeUUID("SNMPOID")
 }
 
+// MakeUUID
+func KnxGroupAddressUUID() string {
+	return MakeUUID("KNXGA")
+}
+
+// MakeUUID
+func OpcuaPointUUID() string {
+	return MakeUUID("OPCUA")
+}
+
+// MakeUUID
+func Dnp3PointUUID() string {
+	return MakeUUID("DNP3")
+}
+
+// MakeUUID
+func Iec104PointUUID() string {
+	return MakeUUID("IEC104")
+}
+
 func BacnetPointUUID() string {
 	return MakeUUID("BACNET")
 }